@@ -7,6 +7,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand/v2"
 	"net"
@@ -21,8 +22,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/audit"
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
 	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/demandresponse"
 	server "github.com/tejusbharadwaj/edgecom/internal/grpc"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
 	pb "github.com/tejusbharadwaj/edgecom/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -70,7 +76,7 @@ func setupTestDB(t *testing.T) database.TimeSeriesRepository {
 	require.NoError(t, err)
 	defer db.Close()
 
-	_, err = db.Exec("TRUNCATE TABLE time_series_data")
+	_, err = db.Exec("TRUNCATE TABLE time_series_data, series_metadata, consumption_summaries, ingest_watermarks, demand_response_events, audit_log, virtual_series, ingest_sources")
 	require.NoError(t, err)
 
 	return repo
@@ -84,7 +90,7 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-func setupGRPCServer(t *testing.T, repo database.TimeSeriesRepository) (*grpc.Server, func()) {
+func setupGRPCServer(t *testing.T, repo database.TimeSeriesRepository) (*server.Server, func()) {
 	registry = prometheus.NewRegistry()
 	lis = bufconn.Listen(bufSize)
 
@@ -201,6 +207,343 @@ func TestTimeSeriesE2E(t *testing.T) {
 	require.Greater(t, len(resp.Data), 0)
 }
 
+func TestSeriesMetadata(t *testing.T) {
+	resetTestEnvironment()
+	_, repo, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	meta, err := repo.SeriesMetadata(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, meta.PointCount)
+	assert.True(t, meta.LastTime.IsZero())
+
+	base := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+		{Time: base.Add(-time.Hour), Value: 1},
+		{Time: base, Value: 2},
+	}))
+	require.NoError(t, repo.InsertTimeSeriesDataContext(ctx, base.Add(-2*time.Hour), 3))
+
+	meta, err = repo.SeriesMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), meta.PointCount)
+	assert.True(t, meta.FirstTime.Equal(base.Add(-2*time.Hour)))
+	assert.True(t, meta.LastTime.Equal(base))
+
+	// Describing the series keeps its stored range and count
+	description := models.SeriesDescription{Unit: "kWh", Description: "Site consumption", Tags: map[string]string{"site": "north"}}
+	require.NoError(t, repo.DescribeSeries(ctx, database.DefaultSeries, description))
+	listed, err := repo.ListSeriesMetadata(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, description, listed[0].SeriesDescription)
+	assert.Equal(t, int64(3), listed[0].PointCount)
+	assert.True(t, listed[0].LastTime.Equal(base))
+
+	// Label selectors are matched in the database
+	for selector, count := range map[string]int{
+		`{site="north"}`:           1,
+		`{site="south"}`:           0,
+		`{site=~"n.*",phase!="A"}`: 1,
+		`{phase=~"A|B"}`:           0,
+		`default{site!="south"}`:   1,
+	} {
+		matchers, err := labels.ParseSelector(selector)
+		require.NoError(t, err)
+		listed, err := repo.ListSeriesMetadata(ctx, matchers)
+		require.NoError(t, err, selector)
+		assert.Len(t, listed, count, selector)
+	}
+}
+
+func TestConsumptionSummaries(t *testing.T) {
+	resetTestEnvironment()
+	client, repo, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	month := time.Now().UTC().AddDate(0, -2, 0)
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	day := month.AddDate(0, 0, 1)
+
+	// Two batches fall in the same day; the second one raises its peak
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+		{Time: month, Value: 4},
+		{Time: day, Value: 1},
+		{Time: day.Add(30 * time.Minute), Value: 1},
+	}))
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+		{Time: day.Add(time.Hour), Value: 6},
+	}))
+
+	days, err := repo.Summaries(ctx, models.PeriodDay, month, month.AddDate(0, 1, 0))
+	require.NoError(t, err)
+	require.Len(t, days, 2)
+	assert.True(t, days[1].Start.Equal(day))
+	assert.Equal(t, 8.0, days[1].Total)
+	assert.Equal(t, 6.0, days[1].Peak)
+	assert.True(t, days[1].PeakTime.Equal(day.Add(time.Hour)))
+	assert.Equal(t, 2, days[1].Hours)
+	assert.Equal(t, int64(3), days[1].SampleCount)
+
+	resp, err := client.GetSummaries(ctx, &pb.SummariesRequest{
+		Start:  timestamppb.New(day),
+		End:    timestamppb.New(month.AddDate(0, 1, 0)),
+		Period: models.PeriodMonth,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Summaries, 1)
+	summary := resp.Summaries[0]
+	assert.True(t, summary.Start.AsTime().Equal(month))
+	assert.Equal(t, 12.0, summary.TotalKwh)
+	assert.Equal(t, 6.0, summary.PeakKw)
+	assert.InDelta(t, 12.0/(6.0*3), summary.LoadFactor, 1e-9)
+	assert.Equal(t, int64(4), summary.SampleCount)
+}
+
+func TestDerivedAggregations(t *testing.T) {
+	repo := setupTestDB(t)
+	defer repo.Close()
+
+	ctx := context.Background()
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-48 * time.Hour)
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+		{Time: base, Value: 2},
+		{Time: base.Add(time.Hour), Value: 4},
+		{Time: base.Add(2 * time.Hour), Value: 6},
+	}))
+	end := base.Add(24*time.Hour - time.Microsecond)
+
+	loadFactor, err := repo.Query(ctx, base, end, "1d", "LOAD_FACTOR")
+	require.NoError(t, err)
+	require.Len(t, loadFactor, 1)
+	assert.InDelta(t, 4.0/6.0, loadFactor[0].Value, 1e-9)
+
+	_, err = repo.Query(ctx, base, end, "1d", "UTILIZATION")
+	assert.ErrorIs(t, err, database.ErrNoContractedCapacity)
+
+	repo.(*database.PostgresRepo).SetContractedCapacity(8)
+	utilization, err := repo.Query(ctx, base, end, "1d", "UTILIZATION")
+	require.NoError(t, err)
+	require.Len(t, utilization, 1)
+	assert.InDelta(t, 0.75, utilization[0].Value, 1e-9)
+}
+
+func TestVirtualSeriesDefinitions(t *testing.T) {
+	repo := setupTestDB(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	accept := func([]models.VirtualSeries) error { return nil }
+	saved, err := repo.SaveVirtualSeries(ctx, models.VirtualSeries{Name: "billed", Expression: "default * 1.05", Unit: "kW"}, accept)
+	require.NoError(t, err)
+	assert.False(t, saved.CreatedAt.IsZero())
+
+	// Saving again replaces the definition but keeps its creation time
+	replaced, err := repo.SaveVirtualSeries(ctx, models.VirtualSeries{Name: "billed", Expression: "default * 1.1"}, accept)
+	require.NoError(t, err)
+	assert.True(t, replaced.CreatedAt.Equal(saved.CreatedAt))
+
+	series, err := repo.VirtualSeries(ctx)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, "default * 1.1", series[0].Expression)
+	assert.Empty(t, series[0].Unit)
+	assert.Nil(t, series[0].Tags)
+
+	_, err = repo.SaveVirtualSeries(ctx, models.VirtualSeries{Name: "billed", Expression: "default", Tags: map[string]string{"site": "north"}}, accept)
+	require.NoError(t, err)
+	series, err = repo.VirtualSeries(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"site": "north"}, series[0].Tags)
+
+	// Rejected changes are not applied
+	rejected := errors.New("rejected")
+	reject := func(virtual []models.VirtualSeries) error {
+		assert.Len(t, virtual, 2, "the definitions are checked with the new one")
+		return rejected
+	}
+	_, err = repo.SaveVirtualSeries(ctx, models.VirtualSeries{Name: "double", Expression: "billed * 2"}, reject)
+	assert.ErrorIs(t, err, rejected)
+	deleted, err := repo.DeleteVirtualSeries(ctx, "billed", func([]models.VirtualSeries) error { return rejected })
+	assert.ErrorIs(t, err, rejected)
+	assert.False(t, deleted)
+	series, err = repo.VirtualSeries(ctx)
+	require.NoError(t, err)
+	assert.Len(t, series, 1)
+
+	deleted, err = repo.DeleteVirtualSeries(ctx, "billed", accept)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = repo.DeleteVirtualSeries(ctx, "billed", accept)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestStatistics(t *testing.T) {
+	resetTestEnvironment()
+	client, repo, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	base := time.Now().UTC().Truncate(time.Hour).Add(-24 * time.Hour)
+
+	empty, err := repo.Statistics(ctx, base, base.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, empty.Count)
+	assert.True(t, empty.FirstTime.IsZero())
+
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+		{Time: base, Value: 2},
+		{Time: base.Add(10 * time.Minute), Value: 4},
+		{Time: base.Add(20 * time.Minute), Value: 4},
+		{Time: base.Add(30 * time.Minute), Value: 6},
+		{Time: base.Add(2 * time.Hour), Value: 100}, // outside the range
+	}))
+
+	resp, err := client.GetStatistics(ctx, &pb.StatisticsRequest{
+		Start: timestamppb.New(base),
+		End:   timestamppb.New(base.Add(time.Hour)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), resp.Count)
+	assert.Equal(t, 2.0, resp.Min)
+	assert.Equal(t, 6.0, resp.Max)
+	assert.Equal(t, 4.0, resp.Avg)
+	assert.Equal(t, 16.0, resp.Sum)
+	assert.InDelta(t, 1.4142, resp.Stddev, 0.0001)
+	assert.True(t, resp.FirstTime.AsTime().Equal(base))
+	assert.True(t, resp.LastTime.AsTime().Equal(base.Add(30*time.Minute)))
+}
+
+func TestWatermark(t *testing.T) {
+	resetTestEnvironment()
+	_, repo, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	watermark, err := repo.Watermark(ctx)
+	require.NoError(t, err)
+	assert.True(t, watermark.IsZero())
+
+	base := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, repo.AdvanceWatermark(ctx, base))
+	require.NoError(t, repo.AdvanceWatermark(ctx, base.Add(-time.Hour)))
+
+	watermark, err = repo.Watermark(ctx)
+	require.NoError(t, err)
+	assert.True(t, watermark.Equal(base), "the watermark never moves backwards")
+}
+
+func TestDemandResponseEvents(t *testing.T) {
+	resetTestEnvironment()
+	_, repo, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(-48 * time.Hour)
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+		{Time: start.AddDate(0, 0, -1), Value: 100},
+		{Time: start.AddDate(0, 0, -1).Add(30 * time.Minute), Value: 120},
+		{Time: start, Value: 80},
+		{Time: start.Add(time.Hour), Value: 1000}, // after the event
+	}))
+
+	recorded, err := repo.RecordDemandResponseEvent(ctx, models.DemandResponseEvent{
+		Name:            "peak shave",
+		Start:           start,
+		End:             start.Add(time.Hour),
+		TargetReduction: 20,
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, recorded.ID)
+	assert.False(t, recorded.CreatedAt.IsZero())
+
+	events, err := repo.DemandResponseEvents(ctx, start.Add(30*time.Minute), start.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, recorded.ID, events[0].ID)
+
+	events, err = repo.DemandResponseEvents(ctx, start.Add(time.Hour), start.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, events, "events ending at the range start do not overlap it")
+
+	performance, err := demandresponse.Evaluate(ctx, repo, recorded, time.Now())
+	require.NoError(t, err)
+	assert.True(t, performance.Evaluated)
+	assert.Equal(t, 1, performance.BaselineDays)
+	assert.Equal(t, 110.0, performance.Baseline)
+	assert.Equal(t, 30.0, performance.AchievedReduction)
+}
+
+func TestAuditLog(t *testing.T) {
+	resetTestEnvironment()
+	_, repo, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store := repo.(audit.Store)
+
+	// Timestamps round-trip with microsecond precision, so hashes verify
+	for i := 0; i < 3; i++ {
+		_, err := store.AppendAuditRecord(ctx, func(prev *models.AuditRecord) models.AuditRecord {
+			return audit.Seal(prev, models.AuditRecord{
+				Time:    time.Now(),
+				Subject: "dashboard",
+				Source:  audit.SourceGRPC,
+				Action:  "/edgecom.TimeSeriesService/QueryTimeSeries",
+				Request: `{"window":"1h"}`,
+				Code:    "OK",
+			})
+		})
+		require.NoError(t, err)
+	}
+
+	summary, err := audit.VerifyStore(ctx, store, 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Records)
+
+	_, err = db.Exec("UPDATE audit_log SET subject = 'someone else' WHERE seq = 2")
+	require.NoError(t, err)
+	_, err = audit.VerifyStore(ctx, store, 0, "")
+	assert.ErrorContains(t, err, "record 2 was modified")
+}
+
+func TestBusinessHoursQuery(t *testing.T) {
+	resetTestEnvironment()
+	_, repo, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	cal, err := calendar.New(calendar.Config{
+		Timezone: "Europe/Berlin",
+		Hours:    []calendar.HoursConfig{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00"}},
+		Holidays: []string{"2024-12-03"},
+	})
+	require.NoError(t, err)
+
+	// Monday 2 December and the holiday after it, in Berlin winter time
+	monday := time.Date(2024, 12, 2, 0, 0, 0, 0, cal.Location)
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+		{Time: monday.Add(7 * time.Hour), Value: 1},  // before opening
+		{Time: monday.Add(8 * time.Hour), Value: 10}, // opening
+		{Time: monday.Add(17*time.Hour + 59*time.Minute), Value: 20},
+		{Time: monday.Add(18 * time.Hour), Value: 100},               // closing
+		{Time: monday.Add(24*time.Hour + 10*time.Hour), Value: 1000}, // holiday
+	}))
+
+	data, err := repo.QueryBusinessHours(ctx, monday, monday.Add(48*time.Hour), "1d", "SUM", cal)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	assert.True(t, data[0].Time.Equal(monday), "buckets are aligned to local days")
+	assert.Equal(t, 30.0, data[0].Value)
+}
+
 // Consider breaking down the large E2E test into smaller, focused test functions
 func TestTimeSeriesBasicQueries(t *testing.T) {
 	resetTestEnvironment()
@@ -424,3 +767,53 @@ func TestTimeSeriesEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestIngestSourceStates(t *testing.T) {
+	repo := setupTestDB(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	sources, err := repo.IngestSources(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, sources)
+
+	disabled, err := repo.SetIngestSource(ctx, models.IngestSource{Name: "grpc", Reason: "meter reports Wh"}, false)
+	require.NoError(t, err)
+	assert.False(t, disabled.UpdatedAt.IsZero())
+
+	// Setting again replaces the state
+	_, err = repo.SetIngestSource(ctx, models.IngestSource{Name: "grpc", Enabled: true}, false)
+	require.NoError(t, err)
+
+	sources, err = repo.IngestSources(ctx)
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+	assert.True(t, sources[0].Enabled)
+	assert.Empty(t, sources[0].Reason)
+
+	// Enabling a disabled source advances the watermark to the time it is
+	// enabled, unless asked not to
+	base := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	require.NoError(t, repo.AdvanceWatermark(ctx, base))
+	_, err = repo.SetIngestSource(ctx, models.IngestSource{Name: "upstream", Enabled: true}, true)
+	require.NoError(t, err)
+	watermark, err := repo.Watermark(ctx)
+	require.NoError(t, err)
+	assert.True(t, watermark.Equal(base), "enabling an enabled source leaves the watermark")
+
+	_, err = repo.SetIngestSource(ctx, models.IngestSource{Name: "upstream"}, true)
+	require.NoError(t, err)
+	_, err = repo.SetIngestSource(ctx, models.IngestSource{Name: "upstream", Enabled: true}, false)
+	require.NoError(t, err)
+	watermark, err = repo.Watermark(ctx)
+	require.NoError(t, err)
+	assert.True(t, watermark.Equal(base), "catching up leaves the watermark")
+
+	_, err = repo.SetIngestSource(ctx, models.IngestSource{Name: "upstream"}, true)
+	require.NoError(t, err)
+	enabled, err := repo.SetIngestSource(ctx, models.IngestSource{Name: "upstream", Enabled: true}, true)
+	require.NoError(t, err)
+	watermark, err = repo.Watermark(ctx)
+	require.NoError(t, err)
+	assert.True(t, watermark.Equal(enabled.UpdatedAt))
+}
@@ -0,0 +1,218 @@
+// Package admin serves operational endpoints on a dedicated admin port,
+// separate from the public gRPC and HTTP gateway listeners.
+//
+// The admin server provides:
+//   - A built-in status dashboard at /
+//   - A JSON status summary at /api/status
+//   - Prometheus metrics at /metrics, once enabled with EnableMetrics
+//   - Liveness and readiness probes at /healthz and /readyz
+//
+// The dashboard is a static page that renders everything from /api/status,
+// which in turn is assembled from the service's own components: recent data
+// is read through the TimeSeriesService client, so it exercises the same
+// path as external callers.
+//
+// Cross-origin browser access is governed by an optional CORS policy.
+//
+// Example Usage:
+//
+//	adm := admin.New(client, scheduler, srv.Cache, broker, policy, logger)
+//	adm.EnableMetrics(prometheus.DefaultGatherer)
+//	adm.AddHealthCheck("database", repo.Ping)
+//	http.ListenAndServe(":9090", adm)
+package admin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/cors"
+	middleware "github.com/tejusbharadwaj/edgecom/internal/grpc/middlewares"
+	"github.com/tejusbharadwaj/edgecom/internal/scheduler"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+const (
+	// recentRange is how far back the dashboard chart reaches
+	recentRange = 24 * time.Hour
+	// recentWindow is the bucket width of the dashboard chart
+	recentWindow = "1h"
+	// recentAggregation is the aggregation of the dashboard chart
+	recentAggregation = "AVG"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// SchedulerStatus reports the state of the background scheduler.
+type SchedulerStatus interface {
+	Status() scheduler.Status
+}
+
+// CacheStats reports response cache effectiveness.
+type CacheStats interface {
+	Stats() middleware.CacheStats
+}
+
+// IngestStats reports recent ingestion activity.
+type IngestStats interface {
+	Stats() stream.Stats
+}
+
+// Server serves the admin endpoints.
+type Server struct {
+	client    pb.TimeSeriesServiceClient
+	scheduler SchedulerStatus
+	cache     CacheStats
+	ingest    IngestStats
+	logger    *logrus.Logger
+	mux       *http.ServeMux
+	handler   http.Handler
+
+	healthChecks    []namedCheck
+	readinessChecks []namedCheck
+}
+
+// New creates an admin server. Any of the status sources may be nil, in
+// which case the corresponding section is omitted from the status. A nil
+// policy disables cross-origin access.
+func New(
+	client pb.TimeSeriesServiceClient,
+	scheduler SchedulerStatus,
+	cache CacheStats,
+	ingest IngestStats,
+	policy *cors.Policy,
+	logger *logrus.Logger,
+) *Server {
+	s := &Server{
+		client:    client,
+		scheduler: scheduler,
+		cache:     cache,
+		ingest:    ingest,
+		logger:    logger,
+		mux:       http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /{$}", s.handleDashboard)
+	s.mux.HandleFunc("GET /api/status", s.handleStatus)
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+
+	s.handler = s.mux
+	if policy != nil {
+		s.handler = policy.Handler(s.mux)
+	}
+
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Status is the JSON document served at /api/status.
+type Status struct {
+	Time      time.Time              `json:"time"`
+	Ingestion *IngestionStatus       `json:"ingestion,omitempty"`
+	Scheduler *scheduler.Status      `json:"scheduler,omitempty"`
+	Cache     *middleware.CacheStats `json:"cache,omitempty"`
+	Recent    *RecentData            `json:"recent"`
+}
+
+// IngestionStatus describes how far behind real time the stored data is.
+type IngestionStatus struct {
+	stream.Stats
+	// LagSeconds is the age of the newest stored point
+	LagSeconds float64 `json:"lag_seconds"`
+}
+
+// RecentData is the series shown on the dashboard chart.
+type RecentData struct {
+	Window      string      `json:"window"`
+	Aggregation string      `json:"aggregation"`
+	Points      []DataPoint `json:"points"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// DataPoint is a single chart point.
+type DataPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// handleDashboard serves the embedded dashboard page.
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+// handleStatus serves the JSON status summary.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	status := Status{Time: now}
+
+	if s.ingest != nil {
+		stats := s.ingest.Stats()
+		ingestion := &IngestionStatus{Stats: stats}
+		if !stats.NewestPoint.IsZero() {
+			ingestion.LagSeconds = now.Sub(stats.NewestPoint).Seconds()
+		}
+		status.Ingestion = ingestion
+	}
+
+	if s.scheduler != nil {
+		schedulerStatus := s.scheduler.Status()
+		status.Scheduler = &schedulerStatus
+	}
+
+	if s.cache != nil {
+		cacheStats := s.cache.Stats()
+		status.Cache = &cacheStats
+	}
+
+	status.Recent = s.recentData(r, now)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		s.logger.WithError(err).Debug("Failed to write admin status")
+	}
+}
+
+// recentData queries the last day of data through the service API.
+// The range is aligned to the minute so repeated refreshes share cache
+// entries.
+func (s *Server) recentData(r *http.Request, now time.Time) *RecentData {
+	end := now.Truncate(time.Minute)
+	recent := &RecentData{
+		Window:      recentWindow,
+		Aggregation: recentAggregation,
+		Points:      []DataPoint{},
+	}
+
+	resp, err := s.client.QueryTimeSeries(r.Context(), &pb.TimeSeriesRequest{
+		Start:       timestamppb.New(end.Add(-recentRange)),
+		End:         timestamppb.New(end),
+		Window:      recentWindow,
+		Aggregation: recentAggregation,
+	})
+	if err != nil {
+		recent.Error = err.Error()
+		return recent
+	}
+
+	for _, dp := range resp.Data {
+		recent.Points = append(recent.Points, DataPoint{
+			Time:  dp.Time.AsTime(),
+			Value: dp.Value,
+		})
+	}
+	return recent
+}
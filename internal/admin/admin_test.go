@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	middleware "github.com/tejusbharadwaj/edgecom/internal/grpc/middlewares"
+	"github.com/tejusbharadwaj/edgecom/internal/grpc/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/scheduler"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+type fakeScheduler struct{ status scheduler.Status }
+
+func (f fakeScheduler) Status() scheduler.Status { return f.status }
+
+type fakeCache struct{ stats middleware.CacheStats }
+
+func (f fakeCache) Stats() middleware.CacheStats { return f.stats }
+
+type fakeIngest struct{ stats stream.Stats }
+
+func (f fakeIngest) Stats() stream.Stats { return f.stats }
+
+func TestDashboard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	srv := New(mocks.NewMockTimeSeriesServiceClient(ctrl), nil, nil, nil, nil, logrus.New())
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rec.Body.String(), "api/status")
+}
+
+func TestStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	newest := time.Now().Add(-10 * time.Minute)
+
+	srv := New(
+		client,
+		fakeScheduler{scheduler.Status{Runs: 3, Failures: 1, LastError: "boom"}},
+		fakeCache{middleware.CacheStats{Hits: 3, Misses: 1, HitRate: 0.75}},
+		fakeIngest{stream.Stats{NewestPoint: newest, LastBatchSize: 5}},
+		nil,
+		logrus.New(),
+	)
+
+	t.Run("all sections", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			Return(&pb.TimeSeriesResponse{
+				Data: []*pb.TimeSeriesDataPoint{{Time: timestamppb.New(newest), Value: 42.0}},
+			}, nil)
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var status Status
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+
+		require.NotNil(t, status.Ingestion)
+		assert.InDelta(t, 600, status.Ingestion.LagSeconds, 5)
+		assert.Equal(t, 5, status.Ingestion.LastBatchSize)
+
+		require.NotNil(t, status.Scheduler)
+		assert.Equal(t, 3, status.Scheduler.Runs)
+		assert.Equal(t, "boom", status.Scheduler.LastError)
+
+		require.NotNil(t, status.Cache)
+		assert.Equal(t, 0.75, status.Cache.HitRate)
+
+		require.Len(t, status.Recent.Points, 1)
+		assert.Equal(t, 42.0, status.Recent.Points[0].Value)
+	})
+
+	t.Run("query error is reported, not fatal", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			Return(nil, status.Error(codes.ResourceExhausted, "rate limit exceeded"))
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var status Status
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		assert.Contains(t, status.Recent.Error, "rate limit exceeded")
+		assert.Empty(t, status.Recent.Points)
+	})
+}
+
+func TestHealthProbes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var dbErr, bootstrapErr error
+	srv := New(mocks.NewMockTimeSeriesServiceClient(ctrl), nil, nil, nil, nil, logrus.New())
+	srv.AddHealthCheck("database", func(context.Context) error { return dbErr })
+	srv.AddReadinessCheck("bootstrap", func(context.Context) error { return bootstrapErr })
+
+	probe := func(path string) (int, HealthResponse) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var resp HealthResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	tests := []struct {
+		name          string
+		dbErr         error
+		bootstrapErr  error
+		healthzCode   int
+		readyzCode    int
+		readyzDetails map[string]string
+	}{
+		{
+			name:          "all healthy",
+			healthzCode:   http.StatusOK,
+			readyzCode:    http.StatusOK,
+			readyzDetails: map[string]string{"database": "ok", "bootstrap": "ok"},
+		},
+		{
+			name:          "bootstrap in progress",
+			bootstrapErr:  errors.New("bootstrap in progress"),
+			healthzCode:   http.StatusOK,
+			readyzCode:    http.StatusServiceUnavailable,
+			readyzDetails: map[string]string{"database": "ok", "bootstrap": "bootstrap in progress"},
+		},
+		{
+			name:          "database unreachable",
+			dbErr:         errors.New("connection refused"),
+			healthzCode:   http.StatusServiceUnavailable,
+			readyzCode:    http.StatusServiceUnavailable,
+			readyzDetails: map[string]string{"database": "connection refused", "bootstrap": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbErr, bootstrapErr = tt.dbErr, tt.bootstrapErr
+
+			code, resp := probe("/healthz")
+			assert.Equal(t, tt.healthzCode, code)
+			assert.NotContains(t, resp.Checks, "bootstrap")
+
+			code, resp = probe("/readyz")
+			assert.Equal(t, tt.readyzCode, code)
+			assert.Equal(t, tt.readyzDetails, resp.Checks)
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_requests_total", Help: "Test counter"})
+	reg.MustRegister(counter)
+	counter.Inc()
+
+	srv := New(mocks.NewMockTimeSeriesServiceClient(ctrl), nil, nil, nil, nil, logrus.New())
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "metrics are served only once enabled")
+
+	srv.EnableMetrics(reg)
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "test_requests_total 1")
+}
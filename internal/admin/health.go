@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// checkTimeout bounds how long a single health check may take
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is healthy. A nil error means healthy.
+type Check func(ctx context.Context) error
+
+// namedCheck is a registered Check.
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// HealthResponse is the JSON document served at /healthz and /readyz.
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// EnableMetrics serves the metrics collected by gatherer at /metrics in the
+// Prometheus exposition format.
+func (s *Server) EnableMetrics(gatherer prometheus.Gatherer) {
+	s.mux.Handle("GET /metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+}
+
+// AddHealthCheck registers a check that must pass for both /healthz and
+// /readyz to report success. It must be called before the server starts
+// serving requests.
+func (s *Server) AddHealthCheck(name string, check Check) {
+	s.healthChecks = append(s.healthChecks, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck registers a check that must pass for /readyz only, for
+// conditions such as an unfinished bootstrap that should keep traffic away
+// without restarting the process. It must be called before the server
+// starts serving requests.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.readinessChecks = append(s.readinessChecks, namedCheck{name: name, check: check})
+}
+
+// handleHealthz serves the liveness probe.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, r, s.healthChecks)
+}
+
+// handleReadyz serves the readiness probe.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := append(append([]namedCheck{}, s.healthChecks...), s.readinessChecks...)
+	s.writeHealth(w, r, checks)
+}
+
+// writeHealth runs checks and responds 200 if all pass, 503 otherwise.
+func (s *Server) writeHealth(w http.ResponseWriter, r *http.Request, checks []namedCheck) {
+	resp := HealthResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	code := http.StatusOK
+
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		err := c.check(ctx)
+		cancel()
+
+		if err != nil {
+			resp.Checks[c.name] = err.Error()
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[c.name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.WithError(err).Debug("Failed to write health response")
+	}
+}
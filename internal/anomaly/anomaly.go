@@ -0,0 +1,725 @@
+// Package anomaly detects abnormal values in newly ingested time series
+// data, such as energy spikes, close to when they are ingested.
+//
+// A Detector evaluates configurable rules against every ingested batch:
+//   - threshold: the value is above Max or below Min, or bounds computed
+//     from companion series such as outdoor temperature
+//   - zscore: the value is more than Threshold standard deviations from
+//     the mean of the samples in the preceding rolling Window
+//   - rate_of_change: the value changed from the previous sample by more
+//     than MaxChange per Per
+//
+// Anomalies are counted in edgecom_anomalies_total and flagged in the
+// edgecom_anomaly_firing gauge, which Prometheus alerting rules can use,
+// and are reported as a warning log entry and to webhooks if a notifier
+// is set. Reports of a rule are suppressed for its Cooldown after one is
+// sent, so a sustained spike is reported once.
+//
+// Threshold bounds may be expressions over companion series, such as
+// "400 + 5 * outdoor_temp", so that limits follow the weather instead of
+// firing on every warm afternoon. Companion series are read for each
+// batch, and a sample is compared against the latest companion value at
+// most 6 hours older than it; bounds whose companions have no such value
+// are not checked.
+//
+// Rules may watch a derived series instead of the stored one, such as
+// "default * 0.9", computed from each ingested sample; samples for which
+// the derived series is undefined, such as when dividing by zero, are
+// skipped by the rule.
+//
+// Only samples ingested close to when they were measured are evaluated.
+// Older samples, such as those of historical bootstraps and backfills, are
+// remembered for the rolling windows without being evaluated, so loading
+// history does not raise a flood of stale alerts.
+//
+// Example Usage:
+//
+//	detector, err := anomaly.NewDetector([]anomaly.Rule{
+//	    {Name: "spike", Type: anomaly.RuleZScore, Window: 24 * time.Hour, Threshold: 4},
+//	    {Name: "overload", Type: anomaly.RuleThreshold, Max: &maxLoad},
+//	}, logger, prometheus.DefaultRegisterer)
+//	if err != nil {
+//	    return err
+//	}
+//	detector.SetNotifier(notifier)
+//
+//	bus.Subscribe("anomaly detector", func(ctx context.Context, event events.Event) {
+//	    detector.Evaluate(ctx, event.Points)
+//	}, events.TopicDataArrived)
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+// Rule types
+const (
+	// RuleThreshold fires when a value is outside fixed bounds
+	RuleThreshold = "threshold"
+	// RuleZScore fires when a value is far from the rolling mean
+	RuleZScore = "zscore"
+	// RuleRateOfChange fires when a value changes too quickly
+	RuleRateOfChange = "rate_of_change"
+)
+
+// Rule defaults
+const (
+	// DefaultZScore is the z-score threshold of zscore rules
+	DefaultZScore = 3.0
+	// DefaultMinSamples is how many samples a zscore window must hold
+	// before values are scored
+	DefaultMinSamples = 30
+	// DefaultPer is the period rate_of_change limits are expressed in
+	DefaultPer = time.Minute
+	// DefaultCooldown is how long reports of a rule are suppressed after
+	// one is sent
+	DefaultCooldown = 15 * time.Minute
+)
+
+// maxWindowSamples bounds the samples a zscore rule keeps in memory,
+// whatever its window
+const maxWindowSamples = 100000
+
+// evaluationHorizon is how old a sample may be when it is ingested to be
+// evaluated
+const evaluationHorizon = 24 * time.Hour
+
+// Notifier sends anomaly events to webhooks.
+type Notifier interface {
+	Notify(ctx context.Context, event webhook.Event)
+}
+
+// RawQuerier is the subset of the repository needed to prime the rolling
+// windows with stored samples.
+type RawQuerier interface {
+	QueryRaw(ctx context.Context, start, end time.Time, skip, limit int) ([]models.TimeSeriesData, error)
+}
+
+// Rule describes what makes a value anomalous.
+type Rule struct {
+	Name string
+	// Type is RuleThreshold, RuleZScore or RuleRateOfChange
+	Type string
+	// Series is the derived series the rule watches, computed from each
+	// ingested sample; the stored series when empty
+	Series string
+
+	// Min and Max bound the values of threshold rules; at least one
+	// bound is required
+	Min *float64
+	Max *float64
+	// MinExpression and MaxExpression compute the bounds of threshold
+	// rules from companion series instead, such as "400 + 5 *
+	// outdoor_temp"; each is exclusive with its fixed counterpart
+	MinExpression string
+	MaxExpression string
+
+	// Window is the rolling window of zscore rules
+	Window time.Duration
+	// Threshold is the z-score above which zscore rules fire,
+	// DefaultZScore when zero
+	Threshold float64
+	// MinSamples is how many samples the window must hold before values
+	// are scored, DefaultMinSamples when zero
+	MinSamples int
+
+	// MaxChange is the largest change, up or down, per Per allowed by
+	// rate_of_change rules
+	MaxChange float64
+	// Per is the period MaxChange is expressed in, DefaultPer when zero
+	Per time.Duration
+
+	// Severity of the rule's events, webhook.SeverityWarning when empty
+	Severity string
+	// Cooldown suppresses reports after one is sent, DefaultCooldown when
+	// zero
+	Cooldown time.Duration
+}
+
+// Validate checks that the rule is usable.
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	switch r.Type {
+	case RuleThreshold:
+		if r.Min == nil && r.Max == nil && r.MinExpression == "" && r.MaxExpression == "" {
+			return fmt.Errorf("rule %s: min or max is required", r.Name)
+		}
+		if (r.Min != nil && r.MinExpression != "") || (r.Max != nil && r.MaxExpression != "") {
+			return fmt.Errorf("rule %s: a bound and its expression are mutually exclusive", r.Name)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return fmt.Errorf("rule %s: min must not exceed max", r.Name)
+		}
+		for _, source := range []string{r.MinExpression, r.MaxExpression} {
+			if source == "" {
+				continue
+			}
+			if _, err := expression.Parse(source); err != nil {
+				return fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+		}
+	case RuleZScore:
+		if r.Window <= 0 {
+			return fmt.Errorf("rule %s: window must be positive", r.Name)
+		}
+		if r.Threshold < 0 || r.MinSamples < 0 {
+			return fmt.Errorf("rule %s: threshold and min_samples must not be negative", r.Name)
+		}
+	case RuleRateOfChange:
+		if !(r.MaxChange > 0) {
+			return fmt.Errorf("rule %s: max_change must be positive", r.Name)
+		}
+		if r.Per < 0 {
+			return fmt.Errorf("rule %s: per must not be negative", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %s: invalid type %q", r.Name, r.Type)
+	}
+	switch r.Severity {
+	case "", webhook.SeverityInfo, webhook.SeverityWarning, webhook.SeverityError, webhook.SeverityCritical:
+	default:
+		return fmt.Errorf("rule %s: invalid severity %q", r.Name, r.Severity)
+	}
+	if r.Cooldown < 0 {
+		return fmt.Errorf("rule %s: cooldown must not be negative", r.Name)
+	}
+	return nil
+}
+
+// withDefaults returns the rule with unset optional fields defaulted
+func (r Rule) withDefaults() Rule {
+	if r.Threshold == 0 {
+		r.Threshold = DefaultZScore
+	}
+	if r.MinSamples == 0 {
+		r.MinSamples = DefaultMinSamples
+	}
+	if r.Per == 0 {
+		r.Per = DefaultPer
+	}
+	if r.Severity == "" {
+		r.Severity = webhook.SeverityWarning
+	}
+	if r.Cooldown == 0 {
+		r.Cooldown = DefaultCooldown
+	}
+	return r
+}
+
+// Anomaly is a sample a rule found abnormal.
+type Anomaly struct {
+	Rule     string
+	Type     string
+	Severity string
+	// Time and Value are the anomalous sample
+	Time  time.Time
+	Value float64
+	// Score is what the rule measured: the value for threshold rules, the
+	// z-score for zscore rules and the change per period for
+	// rate_of_change rules
+	Score float64
+	// Limit is the bound Score exceeded
+	Limit float64
+	// Summary describes the anomaly
+	Summary string
+}
+
+// Detector evaluates rules against ingested samples.
+type Detector struct {
+	rules     []*ruleState
+	logger    *logrus.Logger
+	anomalies *prometheus.CounterVec
+	firing    *prometheus.GaugeVec
+	notifier  Notifier
+	clock     clock.Clock
+
+	// companions are the companion series threshold expressions refer to
+	companions map[string]Companion
+
+	// derived holds the derived series rules may watch, computed from
+	// samples of the stored series
+	derived *expression.Derived
+	stored  string
+
+	mu sync.Mutex
+}
+
+// ruleState is a rule and what it remembers of the samples seen so far
+type ruleState struct {
+	Rule
+
+	// minExpression and maxExpression are the parsed bound expressions
+	// of a threshold rule
+	minExpression, maxExpression *expression.Expression
+
+	// window holds the samples of a zscore rule's rolling window in time
+	// order, with the running sums of their values and squared values
+	window     []models.TimeSeriesData
+	sum, sumSq float64
+
+	// previous is the latest sample seen, for rate_of_change rules
+	previous *models.TimeSeriesData
+
+	// reported is when the rule last sent a report, by the detector's
+	// clock
+	reported time.Time
+}
+
+// NewDetector creates a detector for rules and registers its metrics with
+// reg.
+func NewDetector(rules []Rule, logger *logrus.Logger, reg prometheus.Registerer) (*Detector, error) {
+	states := make([]*ruleState, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule: %s", rule.Name)
+		}
+		names[rule.Name] = true
+		state := &ruleState{Rule: rule.withDefaults()}
+		// The expressions were checked by Validate
+		if rule.MinExpression != "" {
+			state.minExpression, _ = expression.Parse(rule.MinExpression)
+		}
+		if rule.MaxExpression != "" {
+			state.maxExpression, _ = expression.Parse(rule.MaxExpression)
+		}
+		states = append(states, state)
+	}
+
+	anomalies := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edgecom_anomalies_total",
+		Help: "Ingested samples found anomalous, by rule",
+	}, []string{"rule", "type"})
+	firing := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "edgecom_anomaly_firing",
+		Help: "1 while the latest ingested batch contained an anomaly for the rule, 0 otherwise",
+	}, []string{"rule", "type"})
+	for _, c := range []prometheus.Collector{anomalies, firing} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register anomaly metric: %v", err)
+		}
+	}
+	for _, state := range states {
+		firing.WithLabelValues(state.Name, state.Type).Set(0)
+	}
+
+	return &Detector{
+		rules:     states,
+		logger:    logger,
+		anomalies: anomalies,
+		firing:    firing,
+		clock:     clock.System,
+	}, nil
+}
+
+// SetNotifier sends the anomalies reported by Evaluate to notifier. It
+// must be called before Evaluate is first called.
+func (d *Detector) SetNotifier(notifier Notifier) {
+	d.notifier = notifier
+}
+
+// SetClock sets the clock that decides which samples are recent enough
+// to evaluate and when cooldowns end, instead of the system clock. It must
+// be called before Evaluate is first called.
+func (d *Detector) SetClock(clk clock.Clock) {
+	d.clock = clk
+}
+
+// SetCompanions sets the companion series threshold expressions refer to,
+// by name, and returns an error if an expression refers to a series
+// missing from companions. It must be called before Evaluate is first
+// called.
+func (d *Detector) SetCompanions(companions map[string]Companion) error {
+	for _, name := range d.companionNames() {
+		if companions[name] == nil {
+			return fmt.Errorf("unknown companion series %q", name)
+		}
+	}
+	d.companions = companions
+	return nil
+}
+
+// SetDerivedSeries sets the derived series rules may watch, computed from
+// ingested samples as the values of the stored series, and returns an
+// error if a rule watches a series missing from derived. It must be called
+// before Evaluate or Prime is first called.
+func (d *Detector) SetDerivedSeries(derived *expression.Derived, stored string) error {
+	for _, state := range d.rules {
+		if state.Series != "" && !derived.Has(state.Series) {
+			return fmt.Errorf("rule %s: unknown series %q", state.Name, state.Series)
+		}
+	}
+	d.derived = derived
+	d.stored = stored
+	return nil
+}
+
+// sampleOf returns the value of the series state watches at an ingested
+// sample, and false if it is undefined there
+func (d *Detector) sampleOf(state *ruleState, sample models.TimeSeriesData) (models.TimeSeriesData, bool) {
+	if state.Series == "" {
+		return sample, true
+	}
+	if !d.derived.Has(state.Series) {
+		return sample, false
+	}
+	v, err := d.derived.Eval(state.Series, map[string]float64{d.stored: sample.Value})
+	if err != nil {
+		return sample, false
+	}
+	sample.Value = v
+	return sample, true
+}
+
+// companionNames returns the names of the companion series the rules'
+// expressions refer to, sorted
+func (d *Detector) companionNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, state := range d.rules {
+		for _, expr := range []*expression.Expression{state.minExpression, state.maxExpression} {
+			if expr == nil {
+				continue
+			}
+			for _, name := range expr.Variables() {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadCompanions reads the companion series the rules refer to for the
+// samples of a batch from start until end. Series that cannot be read are
+// logged and left out, so the bounds referring to them are not checked.
+func (d *Detector) loadCompanions(ctx context.Context, start, end time.Time) map[string]companionValues {
+	names := d.companionNames()
+	if len(names) == 0 {
+		return nil
+	}
+	values := make(map[string]companionValues, len(names))
+	for _, name := range names {
+		companion := d.companions[name]
+		if companion == nil {
+			continue
+		}
+		samples, err := companion.Samples(ctx, start.Add(-companionMaxAge), end)
+		if err != nil {
+			d.logger.WithError(err).WithField("companion", name).Warn("Failed to read companion series")
+			continue
+		}
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+		values[name] = samples
+	}
+	return values
+}
+
+// Prime fills the rolling windows and previous samples of the rules from
+// the samples stored before now, without evaluating them, so rules can
+// score values as soon as the service starts.
+func (d *Detector) Prime(ctx context.Context, repo RawQuerier, now time.Time) error {
+	var lookback time.Duration
+	for _, state := range d.rules {
+		switch state.Type {
+		case RuleZScore:
+			lookback = max(lookback, state.Window)
+		case RuleRateOfChange:
+			lookback = max(lookback, state.Per)
+		}
+	}
+	if lookback == 0 {
+		return nil
+	}
+
+	samples, err := repo.QueryRaw(ctx, now.Add(-lookback), now, 0, maxWindowSamples)
+	if err != nil {
+		return fmt.Errorf("failed to load recent samples: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, sample := range samples {
+		for _, state := range d.rules {
+			if sample, ok := d.sampleOf(state, sample); ok {
+				state.observe(sample)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate checks every recent sample of a batch against every rule in
+// time order, reports the anomalies found and returns them. Samples older
+// than the latest one seen are checked against threshold rules only.
+func (d *Detector) Evaluate(ctx context.Context, batch []models.TimeSeriesData) []Anomaly {
+	samples := append([]models.TimeSeriesData(nil), batch...)
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	now := d.clock.Now()
+	horizon := now.Add(-evaluationHorizon)
+
+	// Companion series are read before the lock is taken, for the samples
+	// that are evaluated
+	var companions map[string]companionValues
+	if recent := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(horizon) }); recent < len(samples) {
+		companions = d.loadCompanions(ctx, samples[recent].Time, samples[len(samples)-1].Time)
+	}
+
+	// Events are sent once the lock is released, so slow webhooks do not
+	// hold up other batches
+	var found []Anomaly
+	var events []webhook.Event
+
+	d.mu.Lock()
+	for _, state := range d.rules {
+		var ruleFound []Anomaly
+		for _, sample := range samples {
+			sample, ok := d.sampleOf(state, sample)
+			if !ok {
+				continue
+			}
+			if !sample.Time.Before(horizon) {
+				if anomaly, ok := state.evaluate(sample, companions); ok {
+					ruleFound = append(ruleFound, anomaly)
+				}
+			}
+			state.observe(sample)
+		}
+
+		labels := []string{state.Name, state.Type}
+		if len(ruleFound) == 0 {
+			d.firing.WithLabelValues(labels...).Set(0)
+			continue
+		}
+		d.firing.WithLabelValues(labels...).Set(1)
+		d.anomalies.WithLabelValues(labels...).Add(float64(len(ruleFound)))
+		found = append(found, ruleFound...)
+
+		// Report the most extreme anomaly of the batch
+		worst := ruleFound[0]
+		for _, anomaly := range ruleFound[1:] {
+			if math.Abs(anomaly.Score) > math.Abs(worst.Score) {
+				worst = anomaly
+			}
+		}
+		if !state.reported.IsZero() && now.Sub(state.reported) < state.Cooldown {
+			continue
+		}
+		state.reported = now
+
+		fields := logrus.Fields{
+			"rule":      worst.Rule,
+			"type":      worst.Type,
+			"time":      worst.Time,
+			"value":     worst.Value,
+			"score":     worst.Score,
+			"limit":     worst.Limit,
+			"anomalies": len(ruleFound),
+		}
+		if state.Series != "" {
+			fields["series"] = state.Series
+		}
+		d.logger.WithFields(fields).Warn("Anomaly detected")
+		events = append(events, anomalyEvent(worst, fields))
+	}
+	d.mu.Unlock()
+
+	if d.notifier != nil {
+		for _, event := range events {
+			d.notifier.Notify(ctx, event)
+		}
+	}
+	return found
+}
+
+// anomalyEvent builds a webhook event from the log fields of a report
+func anomalyEvent(anomaly Anomaly, fields logrus.Fields) webhook.Event {
+	eventFields := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		eventFields[k] = v
+	}
+	return webhook.Event{
+		Type:     webhook.EventAnomalyDetected,
+		Time:     anomaly.Time,
+		Severity: anomaly.Severity,
+		Summary:  anomaly.Summary,
+		Fields:   eventFields,
+	}
+}
+
+// evaluate checks a sample against the rule, before it is observed,
+// reading the bound expressions' variables from companions
+func (s *ruleState) evaluate(sample models.TimeSeriesData, companions map[string]companionValues) (Anomaly, bool) {
+	anomaly := Anomaly{
+		Rule:     s.Name,
+		Type:     s.Type,
+		Severity: s.Severity,
+		Time:     sample.Time,
+		Value:    sample.Value,
+	}
+
+	switch s.Type {
+	case RuleThreshold:
+		anomaly.Score = sample.Value
+		maxLimit, maxOK, maxSource := bound(s.Max, s.maxExpression, sample.Time, companions)
+		minLimit, minOK, minSource := bound(s.Min, s.minExpression, sample.Time, companions)
+		switch {
+		case maxOK && sample.Value > maxLimit:
+			anomaly.Limit = maxLimit
+			anomaly.Summary = fmt.Sprintf("Value %s is above the maximum of %s%s (rule %s)",
+				formatFloat(sample.Value), formatFloat(maxLimit), maxSource, s.Name)
+		case minOK && sample.Value < minLimit:
+			anomaly.Limit = minLimit
+			anomaly.Summary = fmt.Sprintf("Value %s is below the minimum of %s%s (rule %s)",
+				formatFloat(sample.Value), formatFloat(minLimit), minSource, s.Name)
+		default:
+			return anomaly, false
+		}
+
+	case RuleZScore:
+		if s.late(sample) {
+			return anomaly, false
+		}
+		s.expire(sample.Time)
+		n := float64(len(s.window))
+		if len(s.window) < s.MinSamples {
+			return anomaly, false
+		}
+		mean := s.sum / n
+		stddev := math.Sqrt(math.Max(s.sumSq/n-mean*mean, 0))
+		if stddev == 0 {
+			return anomaly, false
+		}
+		anomaly.Score = (sample.Value - mean) / stddev
+		anomaly.Limit = s.Threshold
+		if math.Abs(anomaly.Score) <= s.Threshold {
+			return anomaly, false
+		}
+		anomaly.Summary = fmt.Sprintf("Value %s is %s standard deviations from the %s mean of %s (rule %s)",
+			formatFloat(sample.Value), strconv.FormatFloat(anomaly.Score, 'f', 1, 64), s.Window, formatFloat(mean), s.Name)
+
+	case RuleRateOfChange:
+		if s.previous == nil || !sample.Time.After(s.previous.Time) {
+			return anomaly, false
+		}
+		elapsed := sample.Time.Sub(s.previous.Time)
+		anomaly.Score = (sample.Value - s.previous.Value) * float64(s.Per) / float64(elapsed)
+		anomaly.Limit = s.MaxChange
+		if math.Abs(anomaly.Score) <= s.MaxChange {
+			return anomaly, false
+		}
+		anomaly.Summary = fmt.Sprintf("Value changed from %s to %s in %s, %s per %s (rule %s)",
+			formatFloat(s.previous.Value), formatFloat(sample.Value), elapsed,
+			formatFloat(anomaly.Score), s.Per, s.Name)
+	}
+	return anomaly, true
+}
+
+// bound returns a threshold bound at t, either fixed or computed by expr
+// from the companion values in effect at t, whether it applies and, for
+// computed bounds, a description of how it was computed. Bounds that are
+// unset or whose companions have no value at t do not apply.
+func bound(fixed *float64, expr *expression.Expression, t time.Time, companions map[string]companionValues) (float64, bool, string) {
+	if expr == nil {
+		if fixed == nil {
+			return 0, false, ""
+		}
+		return *fixed, true, ""
+	}
+
+	values := make(map[string]float64, len(expr.Variables()))
+	described := make([]string, 0, len(expr.Variables()))
+	for _, name := range expr.Variables() {
+		v, ok := companions[name].at(t)
+		if !ok {
+			return 0, false, ""
+		}
+		values[name] = v
+		described = append(described, name+"="+formatFloat(v))
+	}
+	limit, err := expr.Eval(values)
+	if err != nil {
+		return 0, false, ""
+	}
+	source := " from " + expr.String()
+	if len(described) > 0 {
+		source += " with " + strings.Join(described, ", ")
+	}
+	return limit, true, source
+}
+
+// observe adds a sample to what the rule remembers
+func (s *ruleState) observe(sample models.TimeSeriesData) {
+	switch s.Type {
+	case RuleZScore:
+		if s.late(sample) {
+			return
+		}
+		s.expire(sample.Time)
+		s.window = append(s.window, sample)
+		s.sum += sample.Value
+		s.sumSq += sample.Value * sample.Value
+		if len(s.window) > maxWindowSamples {
+			s.drop(1)
+		}
+	case RuleRateOfChange:
+		if s.previous == nil || sample.Time.After(s.previous.Time) {
+			previous := sample
+			s.previous = &previous
+		}
+	}
+}
+
+// late reports whether a sample is older than the newest sample in the
+// window, which would break its time order
+func (s *ruleState) late(sample models.TimeSeriesData) bool {
+	return len(s.window) > 0 && sample.Time.Before(s.window[len(s.window)-1].Time)
+}
+
+// expire drops the samples that fell out of the window ending at t
+func (s *ruleState) expire(t time.Time) {
+	start := t.Add(-s.Window)
+	n := sort.Search(len(s.window), func(i int) bool { return s.window[i].Time.After(start) })
+	s.drop(n)
+}
+
+// drop removes the n oldest samples of the window
+func (s *ruleState) drop(n int) {
+	for _, sample := range s.window[:n] {
+		s.sum -= sample.Value
+		s.sumSq -= sample.Value * sample.Value
+	}
+	s.window = s.window[n:]
+	if len(s.window) == 0 {
+		// Reset the sums so rounding errors do not accumulate
+		s.sum, s.sumSq = 0, 0
+	}
+}
+
+// formatFloat formats a value without trailing zeros
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
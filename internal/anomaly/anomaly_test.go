@@ -0,0 +1,338 @@
+package anomaly
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+type recordingNotifier struct {
+	events []webhook.Event
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, event webhook.Event) {
+	n.events = append(n.events, event)
+}
+
+type fakeQuerier []models.TimeSeriesData
+
+func (q fakeQuerier) QueryRaw(_ context.Context, start, end time.Time, _, _ int) ([]models.TimeSeriesData, error) {
+	var result []models.TimeSeriesData
+	for _, p := range q {
+		if !p.Time.Before(start) && !p.Time.After(end) {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func float(v float64) *float64 { return &v }
+
+func newDetector(t *testing.T, now time.Time, rules ...Rule) (*Detector, *recordingNotifier, *prometheus.Registry) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	reg := prometheus.NewRegistry()
+	detector, err := NewDetector(rules, logger, reg)
+	require.NoError(t, err)
+	detector.SetClock(clock.NewFake(now))
+	notifier := &recordingNotifier{}
+	detector.SetNotifier(notifier)
+	return detector, notifier, reg
+}
+
+// series returns samples a minute apart ending at end
+func series(end time.Time, values ...float64) []models.TimeSeriesData {
+	points := make([]models.TimeSeriesData, len(values))
+	for i, v := range values {
+		points[i] = models.TimeSeriesData{Time: end.Add(time.Duration(i-len(values)+1) * time.Minute), Value: v}
+	}
+	return points
+}
+
+func TestThreshold(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	detector, notifier, reg := newDetector(t, now, Rule{Name: "overload", Type: RuleThreshold, Min: float(0), Max: float(500)})
+
+	found := detector.Evaluate(context.Background(), series(now, 100, 650, 700, -5))
+	require.Len(t, found, 3)
+	assert.Equal(t, 650.0, found[0].Value)
+	assert.Equal(t, 500.0, found[0].Limit)
+	assert.Contains(t, found[2].Summary, "below the minimum of 0")
+
+	require.Len(t, notifier.events, 1, "one report per batch")
+	event := notifier.events[0]
+	assert.Equal(t, webhook.EventAnomalyDetected, event.Type)
+	assert.Equal(t, webhook.SeverityWarning, event.Severity)
+	assert.Equal(t, 700.0, event.Fields["value"], "the most extreme anomaly is reported")
+	assert.Equal(t, 3, event.Fields["anomalies"])
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(detector.anomalies.WithLabelValues("overload", RuleThreshold)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(detector.firing.WithLabelValues("overload", RuleThreshold)))
+
+	detector.Evaluate(context.Background(), series(now.Add(time.Minute), 100))
+	assert.Equal(t, 0.0, testutil.ToFloat64(detector.firing.WithLabelValues("overload", RuleThreshold)))
+	count, err := testutil.GatherAndCount(reg, "edgecom_anomalies_total")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestZScore(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	detector, notifier, _ := newDetector(t, now, Rule{Name: "spike", Type: RuleZScore, Window: time.Hour, MinSamples: 10})
+
+	// Alternating 9 and 11 has a mean of 10 and a standard deviation of 1
+	var values []float64
+	for i := 0; i < 20; i++ {
+		values = append(values, 9+2*float64(i%2))
+	}
+	assert.Empty(t, detector.Evaluate(context.Background(), series(now.Add(-time.Minute), values...)))
+
+	found := detector.Evaluate(context.Background(), series(now, 14))
+	require.Len(t, found, 1)
+	assert.InDelta(t, 4.0, found[0].Score, 0.01)
+	assert.Len(t, notifier.events, 1)
+
+	// A small deviation is not anomalous
+	detector.SetClock(clock.NewFake(now.Add(time.Minute)))
+	assert.Empty(t, detector.Evaluate(context.Background(), series(now.Add(time.Minute), 11.5)))
+}
+
+func TestZScoreWindowExpiry(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	detector, _, _ := newDetector(t, now, Rule{Name: "spike", Type: RuleZScore, Window: 10 * time.Minute, MinSamples: 5})
+
+	var values []float64
+	for i := 0; i < 20; i++ {
+		values = append(values, 9+2*float64(i%2))
+	}
+	detector.Evaluate(context.Background(), series(now.Add(-2*time.Hour), values...))
+
+	// The earlier samples have left the window, so there are too few to score
+	assert.Empty(t, detector.Evaluate(context.Background(), series(now, 100)))
+	assert.Len(t, detector.rules[0].window, 1)
+}
+
+func TestRateOfChange(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	detector, _, _ := newDetector(t, now, Rule{Name: "ramp", Type: RuleRateOfChange, MaxChange: 50, Per: time.Minute})
+
+	found := detector.Evaluate(context.Background(), []models.TimeSeriesData{
+		{Time: now.Add(-10 * time.Minute), Value: 100},
+		{Time: now.Add(-5 * time.Minute), Value: 300}, // 40 per minute
+		{Time: now.Add(-4 * time.Minute), Value: 200}, // -100 per minute
+	})
+	require.Len(t, found, 1)
+	assert.Equal(t, -100.0, found[0].Score)
+	assert.Contains(t, found[0].Summary, "from 300 to 200 in 1m0s")
+
+	// The first sample of the next batch is compared with the last one
+	found = detector.Evaluate(context.Background(), series(now, 420))
+	require.Len(t, found, 1)
+	assert.Equal(t, 55.0, found[0].Score)
+}
+
+type fakeCompanion struct {
+	samples []models.TimeSeriesData
+	err     error
+}
+
+func (c fakeCompanion) Samples(_ context.Context, start, end time.Time) ([]models.TimeSeriesData, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return fakeQuerier(c.samples).QueryRaw(context.Background(), start, end, 0, 0)
+}
+
+func TestCompanionThreshold(t *testing.T) {
+	now := time.Date(2024, 7, 23, 15, 0, 0, 0, time.UTC)
+	rule := Rule{Name: "overload", Type: RuleThreshold, MaxExpression: "400 + 5 * max(outdoor_temp - 20, 0)", Min: float(0)}
+
+	t.Run("bound follows the companion", func(t *testing.T) {
+		detector, notifier, _ := newDetector(t, now, rule)
+		require.NoError(t, detector.SetCompanions(map[string]Companion{
+			"outdoor_temp": fakeCompanion{samples: []models.TimeSeriesData{
+				{Time: now.Add(-2 * time.Hour), Value: 18},
+				{Time: now.Add(-time.Hour), Value: 34},
+			}},
+		}))
+
+		// At 18 degrees the limit is 400, an hour later 470
+		found := detector.Evaluate(context.Background(), []models.TimeSeriesData{
+			{Time: now.Add(-90 * time.Minute), Value: 450},
+			{Time: now.Add(-30 * time.Minute), Value: 450},
+			{Time: now, Value: 480},
+		})
+		require.Len(t, found, 2)
+		assert.Equal(t, 400.0, found[0].Limit)
+		assert.Equal(t, 470.0, found[1].Limit)
+		assert.Equal(t, "Value 480 is above the maximum of 470 from 400 + 5 * max(outdoor_temp - 20, 0) with outdoor_temp=34 (rule overload)", found[1].Summary)
+		require.Len(t, notifier.events, 1)
+	})
+
+	t.Run("bound without a companion value is not checked", func(t *testing.T) {
+		detector, _, _ := newDetector(t, now, rule)
+		require.NoError(t, detector.SetCompanions(map[string]Companion{
+			"outdoor_temp": fakeCompanion{samples: []models.TimeSeriesData{
+				{Time: now.Add(-12 * time.Hour), Value: 34},
+			}},
+		}))
+		found := detector.Evaluate(context.Background(), series(now, 900, -1))
+		require.Len(t, found, 1, "a stale companion leaves only the fixed bound")
+		assert.Equal(t, -1.0, found[0].Value)
+	})
+
+	t.Run("unreadable companion", func(t *testing.T) {
+		detector, _, _ := newDetector(t, now, rule)
+		require.NoError(t, detector.SetCompanions(map[string]Companion{
+			"outdoor_temp": fakeCompanion{err: assert.AnError},
+		}))
+		assert.Empty(t, detector.Evaluate(context.Background(), series(now, 900)))
+	})
+}
+
+func TestCooldown(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	detector, notifier, _ := newDetector(t, now, Rule{Name: "overload", Type: RuleThreshold, Max: float(500), Cooldown: 10 * time.Minute, Severity: webhook.SeverityCritical})
+
+	detector.Evaluate(context.Background(), series(now, 600))
+	detector.SetClock(clock.NewFake(now.Add(5 * time.Minute)))
+	assert.Len(t, detector.Evaluate(context.Background(), series(now.Add(5*time.Minute), 650)), 1, "anomalies are still returned and counted")
+	assert.Len(t, notifier.events, 1, "but not reported during the cooldown")
+
+	detector.SetClock(clock.NewFake(now.Add(11 * time.Minute)))
+	detector.Evaluate(context.Background(), series(now.Add(11*time.Minute), 700))
+	require.Len(t, notifier.events, 2)
+	assert.Equal(t, webhook.SeverityCritical, notifier.events[1].Severity)
+}
+
+func TestHistoricalSamplesAreNotEvaluated(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	detector, notifier, _ := newDetector(t, now,
+		Rule{Name: "overload", Type: RuleThreshold, Max: float(500)},
+		Rule{Name: "ramp", Type: RuleRateOfChange, MaxChange: 50},
+	)
+
+	old := now.Add(-48 * time.Hour)
+	assert.Empty(t, detector.Evaluate(context.Background(), series(old, 100, 900, 100)))
+	assert.Empty(t, notifier.events)
+	assert.Equal(t, 100.0, detector.rules[1].previous.Value, "historical samples are still remembered")
+}
+
+func TestPrime(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	detector, _, _ := newDetector(t, now, Rule{Name: "spike", Type: RuleZScore, Window: time.Hour, MinSamples: 10})
+
+	var values []float64
+	for i := 0; i < 20; i++ {
+		values = append(values, 9+2*float64(i%2))
+	}
+	stored := fakeQuerier(series(now.Add(-time.Minute), values...))
+	require.NoError(t, detector.Prime(context.Background(), stored, now))
+
+	found := detector.Evaluate(context.Background(), series(now, 14))
+	assert.Len(t, found, 1, "primed windows score values immediately")
+}
+
+func TestRuleValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		err  string
+	}{
+		{"no name", Rule{Type: RuleThreshold, Max: float(1)}, "rule name is required"},
+		{"unknown type", Rule{Name: "r", Type: "median"}, "invalid type"},
+		{"threshold without bounds", Rule{Name: "r", Type: RuleThreshold}, "min or max is required"},
+		{"inverted bounds", Rule{Name: "r", Type: RuleThreshold, Min: float(2), Max: float(1)}, "min must not exceed max"},
+		{"zscore without window", Rule{Name: "r", Type: RuleZScore}, "window must be positive"},
+		{"rate without limit", Rule{Name: "r", Type: RuleRateOfChange}, "max_change must be positive"},
+		{"unknown severity", Rule{Name: "r", Type: RuleThreshold, Max: float(1), Severity: "loud"}, "invalid severity"},
+		{"bound and expression", Rule{Name: "r", Type: RuleThreshold, Max: float(1), MaxExpression: "2 * temp"}, "mutually exclusive"},
+		{"invalid expression", Rule{Name: "r", Type: RuleThreshold, MaxExpression: "2 *"}, "invalid expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, tt.rule.Validate(), tt.err)
+		})
+	}
+
+	_, err := NewDetector([]Rule{
+		{Name: "r", Type: RuleThreshold, Max: float(1)},
+		{Name: "r", Type: RuleThreshold, Max: float(2)},
+	}, logrus.New(), prometheus.NewRegistry())
+	assert.ErrorContains(t, err, "duplicate rule")
+
+	detector, err := NewDetector([]Rule{
+		{Name: "r", Type: RuleThreshold, MaxExpression: "400 + 5 * outdoor_temp"},
+	}, logrus.New(), prometheus.NewRegistry())
+	require.NoError(t, err)
+	assert.ErrorContains(t, detector.SetCompanions(nil), `unknown companion series "outdoor_temp"`)
+}
+
+func TestDerivedSeries(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	derived, err := expression.NewDerived(map[string]string{"net": "default - 100", "inverse": "1 / net"}, "default")
+	require.NoError(t, err)
+
+	detector, notifier, _ := newDetector(t, now,
+		Rule{Name: "net-overload", Type: RuleThreshold, Series: "net", Max: float(400)},
+		Rule{Name: "inverse", Type: RuleThreshold, Series: "inverse", Max: float(1)},
+	)
+	require.NoError(t, detector.SetDerivedSeries(derived, "default"))
+
+	found := detector.Evaluate(context.Background(), series(now, 450, 550, 100))
+	require.Len(t, found, 1, "the inverse is undefined at 100 and skipped")
+	assert.Equal(t, 450.0, found[0].Value, "the derived value is evaluated")
+	require.Len(t, notifier.events, 1)
+	assert.Equal(t, "net", notifier.events[0].Fields["series"])
+
+	other, _, _ := newDetector(t, now, Rule{Name: "r", Type: RuleThreshold, Series: "solar", Max: float(1)})
+	assert.ErrorContains(t, other.SetDerivedSeries(derived, "default"), `unknown series "solar"`)
+}
+
+func TestFeedCompanion(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Write([]byte(`{"result": [{"time": 1732323600, "value": 7.5}, {"time": 1732320000, "value": 6}]}`))
+	}))
+	defer srv.Close()
+
+	decoder, err := api.NewDecoder(api.DecoderConfig{})
+	require.NoError(t, err)
+	companion := NewFeedCompanion(srv.URL+"?station=EGLL", decoder)
+
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	samples, err := companion.Samples(context.Background(), start, start.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.True(t, samples[0].Time.Before(samples[1].Time), "samples are ordered by time")
+	require.Equal(t, []string{"end=2024-11-23T02%3A00%3A00&start=2024-11-23T00%3A00%3A00&station=EGLL"}, queries)
+
+	// A range fetched before is not fetched again
+	samples, err = companion.Samples(context.Background(), start.Add(time.Hour), start.Add(90*time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, samples, 1)
+	assert.Len(t, queries, 1)
+
+	// and only the part of a later range after it is
+	samples, err = companion.Samples(context.Background(), start.Add(time.Hour), start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, samples, 1)
+	require.Len(t, queries, 2)
+	assert.Equal(t, "end=2024-11-23T03%3A00%3A00&start=2024-11-23T02%3A00%3A00&station=EGLL", queries[1])
+}
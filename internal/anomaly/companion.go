@@ -0,0 +1,158 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// companionMaxAge is how long a companion sample stays in effect: values
+// are compared against the latest companion sample at most this old
+const companionMaxAge = 6 * time.Hour
+
+// feedTimeout bounds a single companion feed request
+const feedTimeout = 30 * time.Second
+
+// Companion provides the samples of a companion series, such as outdoor
+// temperature, which threshold expressions refer to by name.
+type Companion interface {
+	// Samples returns the samples within [start, end], in any order.
+	Samples(ctx context.Context, start, end time.Time) ([]models.TimeSeriesData, error)
+}
+
+// FeedCompanion reads a companion series from an external HTTP feed. The
+// feed is queried with start and end parameters in the same format as the
+// upstream series API.
+//
+// Each range is fetched once: the samples read are kept, and later calls
+// only fetch the part of their range after the end of the ranges fetched
+// before. Samples before the start of a call are forgotten, as batches are
+// evaluated in time order.
+type FeedCompanion struct {
+	url     string
+	decoder api.Decoder
+	client  *http.Client
+
+	// mu guards the samples fetched from from until to, in time order
+	mu       sync.Mutex
+	from, to time.Time
+	samples  []models.TimeSeriesData
+}
+
+// NewFeedCompanion creates a companion for the feed at rawURL, whose
+// responses are read with decoder. rawURL may carry query parameters of
+// its own.
+func NewFeedCompanion(rawURL string, decoder api.Decoder) *FeedCompanion {
+	return &FeedCompanion{
+		url:     rawURL,
+		decoder: decoder,
+		client:  http.DefaultClient,
+	}
+}
+
+// Samples returns the feed's samples from start until end, fetching those
+// not read before.
+func (f *FeedCompanion) Samples(ctx context.Context, start, end time.Time) ([]models.TimeSeriesData, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	covered := !f.to.IsZero() && !start.Before(f.from) && !start.After(f.to)
+	if !covered || end.After(f.to) {
+		fetchStart := start
+		var kept []models.TimeSeriesData
+		if covered {
+			// Only the part after the fetched ranges is missing
+			fetchStart = f.to
+			for _, sample := range f.samples {
+				if !sample.Time.Before(start) && sample.Time.Before(fetchStart) {
+					kept = append(kept, sample)
+				}
+			}
+		}
+		fetched, err := f.fetch(ctx, fetchStart, end)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(fetched, func(i, j int) bool { return fetched[i].Time.Before(fetched[j].Time) })
+		// Samples before the range fetched are kept already
+		for _, sample := range fetched {
+			if !sample.Time.Before(fetchStart) {
+				kept = append(kept, sample)
+			}
+		}
+		f.samples = kept
+		f.from, f.to = start, end
+	}
+
+	var samples []models.TimeSeriesData
+	for _, sample := range f.samples {
+		if !sample.Time.Before(start) && !sample.Time.After(end) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+// fetch requests the feed's samples from start until end
+func (f *FeedCompanion) fetch(ctx context.Context, start, end time.Time) ([]models.TimeSeriesData, error) {
+	ctx, cancel := context.WithTimeout(ctx, feedTimeout)
+	defer cancel()
+
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid companion feed URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("start", start.Format("2006-01-02T15:04:05"))
+	query.Set("end", end.Format("2006-01-02T15:04:05"))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create companion feed request: %w", err)
+	}
+	req.Header.Set("User-Agent", "EdgeCom-Client/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("companion feed request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("companion feed returned %d", resp.StatusCode)
+	}
+
+	var samples []models.TimeSeriesData
+	err = f.decoder.Decode(resp.Body, func(point models.TimeSeriesData) error {
+		samples = append(samples, point)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode companion feed: %w", err)
+	}
+	return samples, nil
+}
+
+// companionValues is the samples of a companion series in time order
+type companionValues []models.TimeSeriesData
+
+// at returns the value of the latest sample at or before t, and false if
+// there is none within companionMaxAge
+func (c companionValues) at(t time.Time) (float64, bool) {
+	// Index of the first sample after t
+	i := sort.Search(len(c), func(i int) bool { return c[i].Time.After(t) })
+	if i == 0 || t.Sub(c[i-1].Time) > companionMaxAge {
+		return 0, false
+	}
+	return c[i-1].Value, true
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+)
+
+// Authentication types
+const (
+	// AuthNone sends no credentials
+	AuthNone = ""
+	// AuthBearer sends Token in an "Authorization: Bearer" header
+	AuthBearer = "bearer"
+	// AuthBasic sends Username and Password with HTTP basic auth
+	AuthBasic = "basic"
+	// AuthHeader sends Value in the custom header named Header
+	AuthHeader = "header"
+)
+
+// reservedParams are the query parameters set by FetchData itself
+var reservedParams = []string{"start", "end"}
+
+// Secret is a credential that may change while the fetcher runs, such as
+// a *secret.File kept current by a secret.Watcher
+type Secret interface {
+	Value() string
+}
+
+// AuthConfig holds the credentials sent with every API request.
+type AuthConfig struct {
+	// Type is one of AuthNone, AuthBearer, AuthBasic or AuthHeader
+	Type string
+	// Token is the bearer token
+	Token string
+	// Username and Password are the basic auth credentials
+	Username string
+	Password string
+	// Header and Value are the name and content of a custom header, such
+	// as X-API-Key
+	Header string
+	Value  string
+	// Secret, when set, supplies the token, password or header value,
+	// whichever Type uses, in place of Token, Password or Value. It is
+	// read for every request, so rotated credentials apply immediately.
+	Secret Secret
+}
+
+// Validate checks that the credentials required by the type are present.
+func (c AuthConfig) Validate() error {
+	switch c.Type {
+	case AuthNone:
+		if c.Secret != nil {
+			return fmt.Errorf("a secret requires an auth type")
+		}
+	case AuthBearer:
+		if c.Token == "" && c.Secret == nil {
+			return fmt.Errorf("bearer auth requires a token")
+		}
+		if c.Token != "" && c.Secret != nil {
+			return fmt.Errorf("bearer auth takes a token or a secret, not both")
+		}
+	case AuthBasic:
+		if c.Username == "" {
+			return fmt.Errorf("basic auth requires a username")
+		}
+		if c.Password != "" && c.Secret != nil {
+			return fmt.Errorf("basic auth takes a password or a secret, not both")
+		}
+	case AuthHeader:
+		if c.Header == "" || (c.Value == "" && c.Secret == nil) {
+			return fmt.Errorf("header auth requires a header name and value")
+		}
+		if textproto.CanonicalMIMEHeaderKey(c.Header) == "Authorization" {
+			return fmt.Errorf("header auth cannot set the Authorization header, use bearer or basic auth")
+		}
+		if c.Value != "" && c.Secret != nil {
+			return fmt.Errorf("header auth takes a value or a secret, not both")
+		}
+	default:
+		return fmt.Errorf("invalid auth type: %q", c.Type)
+	}
+	return nil
+}
+
+// apply adds the credentials to req.
+func (c AuthConfig) apply(req *http.Request) {
+	switch c.Type {
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+c.credential(c.Token))
+	case AuthBasic:
+		req.SetBasicAuth(c.Username, c.credential(c.Password))
+	case AuthHeader:
+		req.Header.Set(c.Header, c.credential(c.Value))
+	}
+}
+
+// credential returns the current value of Secret, or static if there is
+// none
+func (c AuthConfig) credential(static string) string {
+	if c.Secret != nil {
+		return c.Secret.Value()
+	}
+	return static
+}
+
+// validateQueryParams checks that extra query parameters do not replace
+// the requested range.
+func validateQueryParams(params map[string]string) error {
+	for _, name := range reservedParams {
+		if _, ok := params[name]; ok {
+			return fmt.Errorf("query parameter %q is set by the client and cannot be configured", name)
+		}
+	}
+	for name := range params {
+		if name == "" {
+			return fmt.Errorf("query parameter name must not be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+)
+
+func TestFetchDataAuth(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	tests := []struct {
+		name  string
+		auth  AuthConfig
+		check func(t *testing.T, r *http.Request)
+	}{
+		{
+			name: "none",
+			check: func(t *testing.T, r *http.Request) {
+				assert.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		{
+			name: "bearer",
+			auth: AuthConfig{Type: AuthBearer, Token: "s3cret"},
+			check: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "Bearer s3cret", r.Header.Get("Authorization"))
+			},
+		},
+		{
+			name: "basic",
+			auth: AuthConfig{Type: AuthBasic, Username: "edgecom", Password: "s3cret"},
+			check: func(t *testing.T, r *http.Request) {
+				username, password, ok := r.BasicAuth()
+				assert.True(t, ok)
+				assert.Equal(t, "edgecom", username)
+				assert.Equal(t, "s3cret", password)
+			},
+		},
+		{
+			name: "custom header",
+			auth: AuthConfig{Type: AuthHeader, Header: "X-API-Key", Value: "s3cret"},
+			check: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "s3cret", r.Header.Get("X-API-Key"))
+				assert.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.check(t, r)
+				w.Write([]byte(`{"result": []}`))
+			}))
+			defer srv.Close()
+
+			fetcher := NewSeriesFetcher(srv.URL, mocks.NewMockTimeSeriesRepository(gomock.NewController(t)), logger)
+			require.NoError(t, fetcher.SetAuth(tt.auth))
+			assert.NoError(t, fetcher.FetchData(context.Background(), time.Now().Add(-time.Hour), time.Now()))
+		})
+	}
+}
+
+// rotatingSecret is a Secret whose value the test changes
+type rotatingSecret struct {
+	value string
+}
+
+func (s *rotatingSecret) Value() string {
+	return s.value
+}
+
+func TestFetchDataRotatedSecret(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"result": []}`))
+	}))
+	defer srv.Close()
+
+	token := &rotatingSecret{value: "first"}
+	fetcher := NewSeriesFetcher(srv.URL, mocks.NewMockTimeSeriesRepository(gomock.NewController(t)), logger)
+	require.NoError(t, fetcher.SetAuth(AuthConfig{Type: AuthBearer, Secret: token}))
+
+	require.NoError(t, fetcher.FetchData(context.Background(), time.Now().Add(-time.Hour), time.Now()))
+	token.value = "second"
+	fetcher.CloseIdleConnections()
+	require.NoError(t, fetcher.FetchData(context.Background(), time.Now().Add(-time.Hour), time.Now()))
+
+	assert.Equal(t, []string{"Bearer first", "Bearer second"}, tokens)
+}
+
+func TestFetchDataQueryParams(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		assert.Equal(t, "plant-7", query.Get("site"))
+		assert.Equal(t, "a&b", query.Get("api_key"))
+		assert.Equal(t, "2024-11-23T00:00:00", query.Get("start"))
+		assert.Equal(t, "2024-11-23T01:00:00", query.Get("end"))
+		w.Write([]byte(`{"result": []}`))
+	}))
+	defer srv.Close()
+
+	fetcher := NewSeriesFetcher(srv.URL, mocks.NewMockTimeSeriesRepository(gomock.NewController(t)), logger)
+	require.NoError(t, fetcher.SetQueryParams(map[string]string{"site": "plant-7", "api_key": "a&b"}))
+	assert.NoError(t, fetcher.FetchData(context.Background(), start, start.Add(time.Hour)))
+
+	assert.ErrorContains(t, fetcher.SetQueryParams(map[string]string{"start": "now"}), "cannot be configured")
+
+	// Failed requests do not report the configured values
+	unreachable := NewSeriesFetcher("http://127.0.0.1:1", nil, logger)
+	unreachable.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	require.NoError(t, unreachable.SetQueryParams(map[string]string{"api_key": "s3cret"}))
+	err := unreachable.FetchData(context.Background(), start, start.Add(time.Hour))
+	require.ErrorIs(t, err, ErrAPIRequest)
+	assert.NotContains(t, err.Error(), "s3cret")
+	assert.Contains(t, err.Error(), "api_key=REDACTED")
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    AuthConfig
+		wantErr string
+	}{
+		{name: "none", auth: AuthConfig{}},
+		{name: "bearer", auth: AuthConfig{Type: AuthBearer, Token: "t"}},
+		{name: "bearer without token", auth: AuthConfig{Type: AuthBearer}, wantErr: "requires a token"},
+		{name: "basic without password", auth: AuthConfig{Type: AuthBasic, Username: "u"}},
+		{name: "basic without username", auth: AuthConfig{Type: AuthBasic, Password: "p"}, wantErr: "requires a username"},
+		{name: "header without value", auth: AuthConfig{Type: AuthHeader, Header: "X-API-Key"}, wantErr: "header name and value"},
+		{name: "authorization header", auth: AuthConfig{Type: AuthHeader, Header: "authorization", Value: "v"}, wantErr: "cannot set the Authorization header"},
+		{name: "bearer from secret", auth: AuthConfig{Type: AuthBearer, Secret: &rotatingSecret{}}},
+		{name: "bearer with token and secret", auth: AuthConfig{Type: AuthBearer, Token: "t", Secret: &rotatingSecret{}}, wantErr: "not both"},
+		{name: "header from secret", auth: AuthConfig{Type: AuthHeader, Header: "X-API-Key", Secret: &rotatingSecret{}}},
+		{name: "secret without type", auth: AuthConfig{Secret: &rotatingSecret{}}, wantErr: "requires an auth type"},
+		{name: "unknown type", auth: AuthConfig{Type: "oauth"}, wantErr: "invalid auth type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.auth.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrCircuitOpen is returned by FetchData without contacting the API while
+// the circuit breaker is open.
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker. Its numeric value is
+// reported by the state gauge.
+type CircuitState int
+
+// Circuit breaker states
+const (
+	// CircuitClosed lets every request through
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a single trial request through
+	CircuitHalfOpen
+	// CircuitOpen rejects requests until the reset timeout has passed
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// BreakerConfig holds the thresholds of a circuit breaker.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed fetches that
+	// opens the circuit
+	FailureThreshold int
+	// ResetTimeout is how long the circuit stays open before a trial
+	// request is let through
+	ResetTimeout time.Duration
+	// SuccessThreshold is the number of successful trial requests that
+	// close the circuit again
+	SuccessThreshold int
+}
+
+// DefaultBreakerConfig returns a BreakerConfig that opens after 3 failed
+// fetches and tries again after 15 minutes, so that an API outage skips
+// most 5-minute collection runs.
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: 3,
+		ResetTimeout:     15 * time.Minute,
+		SuccessThreshold: 1,
+	}
+}
+
+// Validate checks that the thresholds are usable.
+func (c BreakerConfig) Validate() error {
+	if c.FailureThreshold < 1 {
+		return fmt.Errorf("circuit breaker failure threshold must be at least 1")
+	}
+	if c.SuccessThreshold < 1 {
+		return fmt.Errorf("circuit breaker success threshold must be at least 1")
+	}
+	if c.ResetTimeout <= 0 {
+		return fmt.Errorf("circuit breaker reset timeout must be positive")
+	}
+	return nil
+}
+
+// CircuitBreaker stops requests to the upstream API after repeated
+// failures. Once ResetTimeout has passed, one trial request at a time is
+// let through; SuccessThreshold successful trials close the circuit and a
+// failed one opens it again.
+type CircuitBreaker struct {
+	cfg    BreakerConfig
+	logger *logrus.Logger
+	gauge  prometheus.Gauge
+	now    func() time.Time
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	successes int
+	openedAt  time.Time
+	trial     bool
+}
+
+// NewCircuitBreaker creates a closed circuit breaker and registers its
+// state gauge with reg.
+func NewCircuitBreaker(cfg BreakerConfig, logger *logrus.Logger, reg prometheus.Registerer) (*CircuitBreaker, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "edgecom_upstream_circuit_state",
+		Help: "State of the upstream API circuit breaker (0 closed, 1 half-open, 2 open)",
+	})
+	if err := reg.Register(gauge); err != nil {
+		return nil, fmt.Errorf("failed to register circuit state metric: %v", err)
+	}
+
+	return &CircuitBreaker{
+		cfg:    cfg,
+		logger: logger,
+		gauge:  gauge,
+		now:    time.Now,
+	}, nil
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a request may be made. Every allowed request must
+// be followed by success, failure or release.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.cfg.ResetTimeout {
+		b.transition(CircuitHalfOpen)
+	}
+
+	switch {
+	case b.state == CircuitOpen:
+		return ErrCircuitOpen
+	case b.state == CircuitHalfOpen && b.trial:
+		return ErrCircuitOpen
+	case b.state == CircuitHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// success records a successful request.
+func (b *CircuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != CircuitHalfOpen {
+		return
+	}
+
+	b.trial = false
+	b.successes++
+	if b.successes >= b.cfg.SuccessThreshold {
+		b.transition(CircuitClosed)
+	}
+}
+
+// failure records a request that failed because of the upstream API.
+func (b *CircuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch {
+	case b.state == CircuitHalfOpen:
+		b.trial = false
+		b.transition(CircuitOpen)
+	case b.state == CircuitClosed && b.failures >= b.cfg.FailureThreshold:
+		b.transition(CircuitOpen)
+	}
+}
+
+// release ends a request whose outcome says nothing about the upstream
+// API, such as one cancelled by the caller.
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.trial = false
+	}
+}
+
+// transition moves to state, logging the change. b.mu must be held.
+func (b *CircuitBreaker) transition(state CircuitState) {
+	fields := logrus.Fields{
+		"from": b.state.String(),
+		"to":   state.String(),
+	}
+
+	switch state {
+	case CircuitOpen:
+		b.openedAt = b.now()
+		b.logger.WithFields(fields).WithFields(logrus.Fields{
+			"failures":     b.failures,
+			"resetTimeout": b.cfg.ResetTimeout,
+		}).Warn("Upstream circuit breaker opened, pausing API requests")
+	case CircuitHalfOpen:
+		b.successes = 0
+		b.logger.WithFields(fields).Info("Upstream circuit breaker half-open, trying the API again")
+	case CircuitClosed:
+		b.failures = 0
+		b.logger.WithFields(fields).Info("Upstream circuit breaker closed, API requests resumed")
+	}
+
+	b.state = state
+	b.gauge.Set(float64(state))
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+)
+
+func newTestBreaker(t *testing.T, cfg BreakerConfig) (*CircuitBreaker, *time.Time) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	breaker, err := NewCircuitBreaker(cfg, logger, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	now := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	breaker.now = func() time.Time { return now }
+	return breaker, &now
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg := BreakerConfig{FailureThreshold: 2, ResetTimeout: time.Minute, SuccessThreshold: 2}
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		breaker, _ := newTestBreaker(t, cfg)
+
+		require.NoError(t, breaker.allow())
+		breaker.failure()
+		require.NoError(t, breaker.allow())
+		breaker.success()
+		require.NoError(t, breaker.allow())
+		breaker.failure()
+		assert.Equal(t, CircuitClosed, breaker.State(), "a success resets the count")
+
+		require.NoError(t, breaker.allow())
+		breaker.failure()
+		assert.Equal(t, CircuitOpen, breaker.State())
+		assert.Equal(t, 2.0, testutil.ToFloat64(breaker.gauge))
+		assert.ErrorIs(t, breaker.allow(), ErrCircuitOpen)
+	})
+
+	t.Run("half-open trials close the circuit", func(t *testing.T) {
+		breaker, now := newTestBreaker(t, cfg)
+		breaker.failure()
+		breaker.failure()
+
+		*now = now.Add(time.Minute)
+		require.NoError(t, breaker.allow())
+		assert.Equal(t, CircuitHalfOpen, breaker.State())
+		assert.Equal(t, 1.0, testutil.ToFloat64(breaker.gauge))
+		assert.ErrorIs(t, breaker.allow(), ErrCircuitOpen, "one trial at a time")
+
+		breaker.success()
+		require.NoError(t, breaker.allow())
+		breaker.success()
+		assert.Equal(t, CircuitClosed, breaker.State())
+		assert.Zero(t, testutil.ToFloat64(breaker.gauge))
+	})
+
+	t.Run("failed trial reopens the circuit", func(t *testing.T) {
+		breaker, now := newTestBreaker(t, cfg)
+		breaker.failure()
+		breaker.failure()
+
+		*now = now.Add(time.Minute)
+		require.NoError(t, breaker.allow())
+		breaker.failure()
+		assert.Equal(t, CircuitOpen, breaker.State())
+
+		*now = now.Add(30 * time.Second)
+		assert.ErrorIs(t, breaker.allow(), ErrCircuitOpen, "the reset timeout restarts")
+	})
+
+	t.Run("released trial lets another through", func(t *testing.T) {
+		breaker, now := newTestBreaker(t, cfg)
+		breaker.failure()
+		breaker.failure()
+
+		*now = now.Add(time.Minute)
+		require.NoError(t, breaker.allow())
+		breaker.release()
+		assert.NoError(t, breaker.allow())
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		assert.NoError(t, DefaultBreakerConfig().Validate())
+		assert.Error(t, BreakerConfig{ResetTimeout: time.Minute, SuccessThreshold: 1}.Validate())
+		assert.Error(t, BreakerConfig{FailureThreshold: 1, SuccessThreshold: 1}.Validate())
+		assert.Error(t, BreakerConfig{FailureThreshold: 1, ResetTimeout: time.Minute}.Validate())
+	})
+}
+
+func TestFetchDataCircuitBreaker(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTimeSeriesRepository(ctrl)
+	api, calls := newFlakyAPI(t, "", 503, 503)
+
+	breaker, now := newTestBreaker(t, BreakerConfig{FailureThreshold: 2, ResetTimeout: time.Minute, SuccessThreshold: 1})
+
+	fetcher := NewSeriesFetcher(api.URL, repo, logger)
+	fetcher.SetRetryPolicy(noRetry)
+	fetcher.SetCircuitBreaker(breaker)
+
+	start := time.Now().Add(-time.Hour)
+	end := time.Now()
+
+	assert.ErrorIs(t, fetcher.FetchData(context.Background(), start, end), ErrAPIStatus)
+	assert.ErrorIs(t, fetcher.FetchData(context.Background(), start, end), ErrAPIStatus)
+	assert.ErrorIs(t, fetcher.FetchData(context.Background(), start, end), ErrCircuitOpen)
+	assert.Equal(t, int32(2), calls.Load(), "the API is not called while the circuit is open")
+
+	*now = now.Add(time.Minute)
+	repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil)
+	assert.NoError(t, fetcher.FetchData(context.Background(), start, end))
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	t.Run("cancelled fetches do not count", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		for i := 0; i < 3; i++ {
+			assert.Error(t, fetcher.FetchData(ctx, start, end))
+		}
+		assert.Equal(t, CircuitClosed, breaker.State())
+	})
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Upstream response formats
+const (
+	// FormatJSON is an object holding an array of points, by default
+	// {"result": [{"time": <unix seconds>, "value": <number>}, ...]}
+	FormatJSON = "json"
+	// FormatCSV is a header row naming the columns followed by one point
+	// per row
+	FormatCSV = "csv"
+)
+
+// Timestamp encodings
+const (
+	TimeFormatUnix      = "unix"
+	TimeFormatUnixMilli = "unix_ms"
+	TimeFormatRFC3339   = "rfc3339"
+)
+
+// Decoder reads data points from an upstream response body and passes
+// them, in order, to emit. Decoding stops at the first error from emit,
+// which is returned unchanged.
+type Decoder interface {
+	Decode(body io.Reader, emit func(models.TimeSeriesData) error) error
+}
+
+// CursorDecoder is a Decoder that can also read the next-page cursor from
+// a top-level field of the response body, as required by cursor
+// pagination without a cursor header.
+type CursorDecoder interface {
+	Decoder
+	// DecodeCursor decodes like Decode and returns the string or number
+	// held by the cursor field, or "" if it is missing or null.
+	DecodeCursor(body io.Reader, field string, emit func(models.TimeSeriesData) error) (string, error)
+}
+
+// DecoderFactory creates a Decoder for a configuration.
+type DecoderFactory func(cfg DecoderConfig) (Decoder, error)
+
+// DecoderConfig selects and parameterises the decoder for a source. Empty
+// fields take the values of DefaultDecoderConfig.
+type DecoderConfig struct {
+	// Format names a registered decoder, FormatJSON or FormatCSV unless
+	// others have been registered
+	Format string
+	// ResultField is the JSON field holding the array of points
+	ResultField string
+	// TimeField is the JSON field or CSV column holding the timestamp
+	TimeField string
+	// ValueField is the JSON field or CSV column holding the value
+	ValueField string
+	// TimeFormat is the timestamp encoding: TimeFormatUnix,
+	// TimeFormatUnixMilli or TimeFormatRFC3339
+	TimeFormat string
+}
+
+// DefaultDecoderConfig describes the EdgeCom API response.
+func DefaultDecoderConfig() DecoderConfig {
+	return DecoderConfig{
+		Format:      FormatJSON,
+		ResultField: "result",
+		TimeField:   "time",
+		ValueField:  "value",
+		TimeFormat:  TimeFormatUnix,
+	}
+}
+
+// withDefaults fills empty fields from DefaultDecoderConfig
+func (c DecoderConfig) withDefaults() DecoderConfig {
+	defaults := DefaultDecoderConfig()
+	if c.Format == "" {
+		c.Format = defaults.Format
+	}
+	if c.ResultField == "" {
+		c.ResultField = defaults.ResultField
+	}
+	if c.TimeField == "" {
+		c.TimeField = defaults.TimeField
+	}
+	if c.ValueField == "" {
+		c.ValueField = defaults.ValueField
+	}
+	if c.TimeFormat == "" {
+		c.TimeFormat = defaults.TimeFormat
+	}
+	return c
+}
+
+var decoders = map[string]DecoderFactory{
+	FormatJSON: newJSONDecoder,
+	FormatCSV:  newCSVDecoder,
+}
+
+// RegisterDecoder makes a decoder available under a format name, replacing
+// any existing one. It is not safe for concurrent use and should be called
+// during initialization, before NewDecoder.
+func RegisterDecoder(format string, factory DecoderFactory) {
+	decoders[format] = factory
+}
+
+// NewDecoder creates the decoder registered for cfg.Format.
+func NewDecoder(cfg DecoderConfig) (Decoder, error) {
+	cfg = cfg.withDefaults()
+
+	factory, ok := decoders[cfg.Format]
+	if !ok {
+		formats := make([]string, 0, len(decoders))
+		for format := range decoders {
+			formats = append(formats, format)
+		}
+		sort.Strings(formats)
+		return nil, fmt.Errorf("unknown response format %q, expected one of %v", cfg.Format, formats)
+	}
+	return factory(cfg)
+}
+
+// parseTimestamp converts a textual timestamp in the given encoding.
+func parseTimestamp(value, format string) (time.Time, error) {
+	switch format {
+	case TimeFormatUnix:
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix timestamp %q", value)
+		}
+		return time.Unix(seconds, 0), nil
+	case TimeFormatUnixMilli:
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix_ms timestamp %q", value)
+		}
+		return time.UnixMilli(millis), nil
+	case TimeFormatRFC3339:
+		return time.Parse(time.RFC3339, value)
+	default:
+		return time.Time{}, fmt.Errorf("unknown time format %q", format)
+	}
+}
+
+// validateTimeFormat rejects unknown timestamp encodings up front
+func validateTimeFormat(format string) error {
+	switch format {
+	case TimeFormatUnix, TimeFormatUnixMilli, TimeFormatRFC3339:
+		return nil
+	default:
+		return fmt.Errorf("unknown time format %q", format)
+	}
+}
+
+// jsonDecoder streams the point array of a JSON object, so memory stays
+// bounded regardless of the size of the response. Other top-level fields
+// are skipped.
+type jsonDecoder struct {
+	cfg DecoderConfig
+}
+
+func newJSONDecoder(cfg DecoderConfig) (Decoder, error) {
+	if err := validateTimeFormat(cfg.TimeFormat); err != nil {
+		return nil, err
+	}
+	return &jsonDecoder{cfg: cfg}, nil
+}
+
+func (d *jsonDecoder) Decode(body io.Reader, emit func(models.TimeSeriesData) error) error {
+	_, err := d.DecodeCursor(body, "", emit)
+	return err
+}
+
+func (d *jsonDecoder) DecodeCursor(body io.Reader, field string, emit func(models.TimeSeriesData) error) (string, error) {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return "", err
+	}
+
+	var cursor string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case key == d.cfg.ResultField:
+			if err := d.decodeResult(dec, emit); err != nil {
+				return "", err
+			}
+		case field != "" && key == field:
+			var value interface{}
+			if err := dec.Decode(&value); err != nil {
+				return "", err
+			}
+			switch v := value.(type) {
+			case nil:
+			case string:
+				cursor = v
+			case json.Number:
+				cursor = v.String()
+			default:
+				return "", fmt.Errorf("%s is not a string or number", field)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return cursor, expectDelim(dec, '}')
+}
+
+// decodeResult streams the elements of the point array to emit.
+func (d *jsonDecoder) decodeResult(dec *json.Decoder, emit func(models.TimeSeriesData) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		// "result": null
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("%s is not an array", d.cfg.ResultField)
+	}
+
+	for dec.More() {
+		var element map[string]json.RawMessage
+		if err := dec.Decode(&element); err != nil {
+			return err
+		}
+
+		point, err := d.point(element)
+		if err != nil {
+			return err
+		}
+		if err := emit(point); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// point converts one array element using the configured field names
+func (d *jsonDecoder) point(element map[string]json.RawMessage) (models.TimeSeriesData, error) {
+	var point models.TimeSeriesData
+
+	rawTime, ok := element[d.cfg.TimeField]
+	if !ok {
+		return point, fmt.Errorf("missing %q field", d.cfg.TimeField)
+	}
+	var timestamp string
+	if d.cfg.TimeFormat == TimeFormatRFC3339 {
+		if err := json.Unmarshal(rawTime, &timestamp); err != nil {
+			return point, fmt.Errorf("invalid %q field: %v", d.cfg.TimeField, err)
+		}
+	} else {
+		var number json.Number
+		if err := json.Unmarshal(rawTime, &number); err != nil {
+			return point, fmt.Errorf("invalid %q field: %v", d.cfg.TimeField, err)
+		}
+		timestamp = number.String()
+	}
+
+	var err error
+	if point.Time, err = parseTimestamp(timestamp, d.cfg.TimeFormat); err != nil {
+		return point, err
+	}
+
+	// A missing value decodes as zero, as it always has for this API
+	if rawValue, ok := element[d.cfg.ValueField]; ok {
+		if err := json.Unmarshal(rawValue, &point.Value); err != nil {
+			return point, fmt.Errorf("invalid %q field: %v", d.cfg.ValueField, err)
+		}
+	}
+
+	return point, nil
+}
+
+// expectDelim reads the next token and checks that it is the given delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %q, got %v", delim, tok)
+	}
+	return nil
+}
+
+// csvDecoder reads comma separated rows after a header row, locating the
+// time and value columns by name.
+type csvDecoder struct {
+	cfg DecoderConfig
+}
+
+func newCSVDecoder(cfg DecoderConfig) (Decoder, error) {
+	if err := validateTimeFormat(cfg.TimeFormat); err != nil {
+		return nil, err
+	}
+	return &csvDecoder{cfg: cfg}, nil
+}
+
+func (d *csvDecoder) Decode(body io.Reader, emit func(models.TimeSeriesData) error) error {
+	reader := csv.NewReader(body)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	timeColumn, valueColumn := -1, -1
+	for i, name := range header {
+		switch name {
+		case d.cfg.TimeField:
+			timeColumn = i
+		case d.cfg.ValueField:
+			valueColumn = i
+		}
+	}
+	if timeColumn < 0 || valueColumn < 0 {
+		return fmt.Errorf("header must name %q and %q columns", d.cfg.TimeField, d.cfg.ValueField)
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var point models.TimeSeriesData
+		if point.Time, err = parseTimestamp(record[timeColumn], d.cfg.TimeFormat); err != nil {
+			return err
+		}
+		if point.Value, err = strconv.ParseFloat(record[valueColumn], 64); err != nil {
+			line, _ := reader.FieldPos(valueColumn)
+			return fmt.Errorf("invalid value %q on line %d", record[valueColumn], line)
+		}
+
+		if err := emit(point); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestDecoders(t *testing.T) {
+	ts := time.Date(2024, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		config  DecoderConfig
+		body    string
+		want    []models.TimeSeriesData
+		wantErr string
+	}{
+		{
+			name:   "default json",
+			config: DecoderConfig{},
+			body:   `{"result": [{"time": 1731622400, "value": 1.5}, {"time": 1731622460}]}`,
+			want: []models.TimeSeriesData{
+				{Time: ts, Value: 1.5},
+				{Time: ts.Add(time.Minute)},
+			},
+		},
+		{
+			name: "json with custom fields",
+			config: DecoderConfig{
+				ResultField: "readings",
+				TimeField:   "ts",
+				ValueField:  "kw",
+				TimeFormat:  TimeFormatRFC3339,
+			},
+			body: `{"readings": [{"ts": "2024-11-14T22:13:20Z", "kw": 3}], "result": "ignored"}`,
+			want: []models.TimeSeriesData{{Time: ts, Value: 3}},
+		},
+		{
+			name:   "json with millisecond timestamps",
+			config: DecoderConfig{TimeFormat: TimeFormatUnixMilli},
+			body:   `{"result": [{"time": 1731622400000, "value": 2}]}`,
+			want:   []models.TimeSeriesData{{Time: ts, Value: 2}},
+		},
+		{
+			name:    "json missing time field",
+			config:  DecoderConfig{},
+			body:    `{"result": [{"value": 2}]}`,
+			wantErr: `missing "time" field`,
+		},
+		{
+			name:    "json wrong time type",
+			config:  DecoderConfig{TimeFormat: TimeFormatRFC3339},
+			body:    `{"result": [{"time": 1731622400, "value": 2}]}`,
+			wantErr: `invalid "time" field`,
+		},
+		{
+			name:   "csv",
+			config: DecoderConfig{Format: FormatCSV},
+			body:   "site,time,value\nA,1731622400,1.5\nA,1731622460,-2\n",
+			want: []models.TimeSeriesData{
+				{Time: ts, Value: 1.5},
+				{Time: ts.Add(time.Minute), Value: -2},
+			},
+		},
+		{
+			name:   "csv empty body",
+			config: DecoderConfig{Format: FormatCSV},
+			body:   "",
+		},
+		{
+			name:    "csv missing column",
+			config:  DecoderConfig{Format: FormatCSV, ValueField: "kw"},
+			body:    "time,value\n1731622400,1\n",
+			wantErr: `header must name "time" and "kw" columns`,
+		},
+		{
+			name:    "csv invalid value",
+			config:  DecoderConfig{Format: FormatCSV},
+			body:    "time,value\n1731622400,1\n1731622460,n/a\n",
+			wantErr: `invalid value "n/a" on line 3`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, err := NewDecoder(tt.config)
+			require.NoError(t, err)
+
+			var got []models.TimeSeriesData
+			err = decoder.Decode(strings.NewReader(tt.body), func(point models.TimeSeriesData) error {
+				point.Time = point.Time.UTC()
+				got = append(got, point)
+				return nil
+			})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := NewDecoder(DecoderConfig{Format: "xml"})
+		assert.ErrorContains(t, err, `unknown response format "xml", expected one of [csv json]`)
+	})
+
+	t.Run("unknown time format", func(t *testing.T) {
+		_, err := NewDecoder(DecoderConfig{TimeFormat: "iso"})
+		assert.ErrorContains(t, err, `unknown time format "iso"`)
+	})
+
+	t.Run("registered decoder", func(t *testing.T) {
+		RegisterDecoder("constant", func(cfg DecoderConfig) (Decoder, error) {
+			return decoderFunc(func(body io.Reader, emit func(models.TimeSeriesData) error) error {
+				return emit(models.TimeSeriesData{Value: 42})
+			}), nil
+		})
+		defer delete(decoders, "constant")
+
+		decoder, err := NewDecoder(DecoderConfig{Format: "constant"})
+		require.NoError(t, err)
+
+		var got models.TimeSeriesData
+		require.NoError(t, decoder.Decode(nil, func(point models.TimeSeriesData) error {
+			got = point
+			return nil
+		}))
+		assert.Equal(t, 42.0, got.Value)
+	})
+
+	t.Run("emit error is returned unchanged", func(t *testing.T) {
+		decoder, err := NewDecoder(DecoderConfig{Format: FormatCSV})
+		require.NoError(t, err)
+
+		stop := errors.New("stop")
+		err = decoder.Decode(strings.NewReader("time,value\n1731622400,1\n"), func(models.TimeSeriesData) error {
+			return stop
+		})
+		assert.Same(t, stop, err)
+	})
+}
+
+// decoderFunc adapts a function to the Decoder interface
+type decoderFunc func(body io.Reader, emit func(models.TimeSeriesData) error) error
+
+func (f decoderFunc) Decode(body io.Reader, emit func(models.TimeSeriesData) error) error {
+	return f(body, emit)
+}
+
+func TestDecodeCursor(t *testing.T) {
+	decoder, err := NewDecoder(DecoderConfig{})
+	require.NoError(t, err)
+	cursorDecoder, ok := decoder.(CursorDecoder)
+	require.True(t, ok)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "string", body: `{"next_cursor": "abc", "result": [{"time": 1, "value": 1}]}`, want: "abc"},
+		{name: "number", body: `{"result": [], "next_cursor": 12345678901234567}`, want: "12345678901234567"},
+		{name: "null", body: `{"result": [], "next_cursor": null}`},
+		{name: "missing", body: `{"result": []}`},
+		{name: "object", body: `{"result": [], "next_cursor": {}}`, wantErr: "not a string or number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := cursorDecoder.DecodeCursor(strings.NewReader(tt.body), "next_cursor", func(models.TimeSeriesData) error {
+				return nil
+			})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cursor)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+//go:generate go run github.com/golang/mock/mockgen -destination=./mocks/fetcher.go -package=mocks . DataFetcher
+
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// DataFetcher ingests time series data from an external source into the
+// repository. SeriesFetcher implements it for HTTP APIs; other sources,
+// such as files or message queues, can be swapped in behind it.
+type DataFetcher interface {
+	// FetchData ingests the data for [start, end].
+	FetchData(ctx context.Context, start, end time.Time) error
+
+	// BootstrapHistoricalData ingests the historical data available at
+	// startup, reacting to failures according to policy.
+	BootstrapHistoricalData(ctx context.Context, policy BootstrapPolicy) error
+
+	// CatchUp ingests everything between the ingest watermark and end,
+	// fetching the window before end when no watermark is recorded.
+	CatchUp(ctx context.Context, end time.Time, window time.Duration) error
+
+	// RepairGaps retries the ranges recorded as gaps.
+	RepairGaps(ctx context.Context) error
+}
+
+// SeriesFetcher is the HTTP implementation of DataFetcher
+var _ DataFetcher = (*SeriesFetcher)(nil)
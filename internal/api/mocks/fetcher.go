@@ -0,0 +1,93 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/tejusbharadwaj/edgecom/internal/api (interfaces: DataFetcher)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	api "github.com/tejusbharadwaj/edgecom/internal/api"
+)
+
+// MockDataFetcher is a mock of DataFetcher interface.
+type MockDataFetcher struct {
+	ctrl     *gomock.Controller
+	recorder *MockDataFetcherMockRecorder
+}
+
+// MockDataFetcherMockRecorder is the mock recorder for MockDataFetcher.
+type MockDataFetcherMockRecorder struct {
+	mock *MockDataFetcher
+}
+
+// NewMockDataFetcher creates a new mock instance.
+func NewMockDataFetcher(ctrl *gomock.Controller) *MockDataFetcher {
+	mock := &MockDataFetcher{ctrl: ctrl}
+	mock.recorder = &MockDataFetcherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDataFetcher) EXPECT() *MockDataFetcherMockRecorder {
+	return m.recorder
+}
+
+// BootstrapHistoricalData mocks base method.
+func (m *MockDataFetcher) BootstrapHistoricalData(arg0 context.Context, arg1 api.BootstrapPolicy) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BootstrapHistoricalData", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BootstrapHistoricalData indicates an expected call of BootstrapHistoricalData.
+func (mr *MockDataFetcherMockRecorder) BootstrapHistoricalData(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BootstrapHistoricalData", reflect.TypeOf((*MockDataFetcher)(nil).BootstrapHistoricalData), arg0, arg1)
+}
+
+// CatchUp mocks base method.
+func (m *MockDataFetcher) CatchUp(arg0 context.Context, arg1 time.Time, arg2 time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CatchUp", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CatchUp indicates an expected call of CatchUp.
+func (mr *MockDataFetcherMockRecorder) CatchUp(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CatchUp", reflect.TypeOf((*MockDataFetcher)(nil).CatchUp), arg0, arg1, arg2)
+}
+
+// FetchData mocks base method.
+func (m *MockDataFetcher) FetchData(arg0 context.Context, arg1, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FetchData", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FetchData indicates an expected call of FetchData.
+func (mr *MockDataFetcherMockRecorder) FetchData(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FetchData", reflect.TypeOf((*MockDataFetcher)(nil).FetchData), arg0, arg1, arg2)
+}
+
+// RepairGaps mocks base method.
+func (m *MockDataFetcher) RepairGaps(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RepairGaps", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RepairGaps indicates an expected call of RepairGaps.
+func (mr *MockDataFetcherMockRecorder) RepairGaps(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RepairGaps", reflect.TypeOf((*MockDataFetcher)(nil).RepairGaps), arg0)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Pagination modes
+const (
+	// PaginationNone makes a single request per range
+	PaginationNone = ""
+	// PaginationCursor passes the cursor returned with each page to the
+	// next request, until a page comes back without one
+	PaginationCursor = "cursor"
+	// PaginationOffset requests pages at increasing offsets, until a page
+	// holds fewer than PageSize points
+	PaginationOffset = "offset"
+)
+
+// Pagination describes how the upstream API splits large responses into
+// pages.
+type Pagination struct {
+	// Mode is PaginationNone, PaginationCursor or PaginationOffset
+	Mode string
+	// PageSize is the number of points requested per page
+	PageSize int
+	// LimitParam is the query parameter carrying PageSize
+	LimitParam string
+	// CursorParam is the query parameter carrying the cursor
+	CursorParam string
+	// CursorField is the top-level field of a JSON response holding the
+	// next cursor; empty or null on the last page
+	CursorField string
+	// CursorHeader, if set, is the response header holding the next
+	// cursor, used instead of CursorField
+	CursorHeader string
+	// OffsetParam is the query parameter carrying the offset
+	OffsetParam string
+	// MaxPages bounds the pages fetched for one range, so a misbehaving
+	// API cannot keep a fetch going forever
+	MaxPages int
+}
+
+// DefaultPagination returns the parameters of the EdgeCom API, which caps
+// responses at 10000 points, with pagination disabled.
+func DefaultPagination() Pagination {
+	return Pagination{
+		Mode:        PaginationNone,
+		PageSize:    10000,
+		LimitParam:  "limit",
+		CursorParam: "cursor",
+		CursorField: "next_cursor",
+		OffsetParam: "offset",
+		MaxPages:    1000,
+	}
+}
+
+// Validate checks that the pagination parameters are usable.
+func (p Pagination) Validate() error {
+	switch p.Mode {
+	case PaginationNone:
+		return nil
+	case PaginationCursor:
+		if p.CursorParam == "" {
+			return fmt.Errorf("cursor pagination requires a cursor parameter")
+		}
+		if p.CursorField == "" && p.CursorHeader == "" {
+			return fmt.Errorf("cursor pagination requires a cursor field or header")
+		}
+	case PaginationOffset:
+		if p.OffsetParam == "" || p.LimitParam == "" {
+			return fmt.Errorf("offset pagination requires offset and limit parameters")
+		}
+	default:
+		return fmt.Errorf("invalid pagination mode: %q", p.Mode)
+	}
+
+	if p.PageSize < 1 {
+		return fmt.Errorf("pagination page size must be at least 1")
+	}
+	if p.MaxPages < 1 {
+		return fmt.Errorf("pagination max pages must be at least 1")
+	}
+	return nil
+}
+
+// page identifies a page of a range: the cursor returned with the previous
+// page, or the number of points before it.
+type page struct {
+	number int
+	cursor string
+	offset int
+}
+
+// pageResult is what a page request returned.
+type pageResult struct {
+	// count is the number of points on the page
+	count int
+	// cursor is the next cursor, in cursor mode
+	cursor string
+}
+
+// pageURL adds the parameters requesting pg to the range URL.
+func (p Pagination) pageURL(rangeURL string, pg page) (string, error) {
+	if p.Mode == PaginationNone {
+		return rangeURL, nil
+	}
+
+	u, err := url.Parse(rangeURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrAPIRequest, err)
+	}
+
+	query := u.Query()
+	if p.LimitParam != "" {
+		query.Set(p.LimitParam, strconv.Itoa(p.PageSize))
+	}
+	switch p.Mode {
+	case PaginationCursor:
+		if pg.cursor != "" {
+			query.Set(p.CursorParam, pg.cursor)
+		}
+	case PaginationOffset:
+		query.Set(p.OffsetParam, strconv.Itoa(pg.offset))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// next returns the page after pg, and false when pg was the last one.
+func (p Pagination) next(pg page, result pageResult) (page, bool, error) {
+	switch p.Mode {
+	case PaginationCursor:
+		if result.cursor == "" {
+			return pg, false, nil
+		}
+		if result.cursor == pg.cursor {
+			return pg, false, fmt.Errorf("API returned the same cursor %q twice", result.cursor)
+		}
+		pg.cursor = result.cursor
+	case PaginationOffset:
+		if result.count < p.PageSize {
+			return pg, false, nil
+		}
+		pg.offset += result.count
+	default:
+		return pg, false, nil
+	}
+
+	pg.number++
+	if pg.number >= p.MaxPages {
+		return pg, false, fmt.Errorf("range exceeds the maximum of %d pages", p.MaxPages)
+	}
+	return pg, true, nil
+}
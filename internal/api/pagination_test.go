@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// newPagedAPI serves total points, at most limit per page, paginated by
+// offset or by a cursor holding the offset of the next page. The cursor is
+// returned in the next_cursor field, or in the X-Next-Cursor header when
+// header is set.
+func newPagedAPI(t *testing.T, total int, header bool) (*httptest.Server, *[]string) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		queries = append(queries, r.URL.RawQuery)
+
+		limit, err := strconv.Atoi(query.Get("limit"))
+		require.NoError(t, err)
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		if cursor := query.Get("cursor"); cursor != "" {
+			offset, err = strconv.Atoi(cursor)
+			require.NoError(t, err)
+		}
+
+		response := map[string]interface{}{}
+		var points []map[string]interface{}
+		for i := offset; i < total && i < offset+limit; i++ {
+			points = append(points, map[string]interface{}{"time": 1700000000 + i, "value": i})
+		}
+		response["result"] = points
+
+		if next := offset + limit; next < total {
+			if header {
+				w.Header().Set("X-Next-Cursor", strconv.Itoa(next))
+			} else {
+				response["next_cursor"] = strconv.Itoa(next)
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &queries
+}
+
+func TestFetchDataPagination(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		pagination Pagination
+		header     bool
+		total      int
+		wantPages  int
+	}{
+		{name: "cursor field", pagination: Pagination{Mode: PaginationCursor, CursorField: "next_cursor"}, total: 7500, wantPages: 3},
+		{name: "cursor header", pagination: Pagination{Mode: PaginationCursor, CursorHeader: "X-Next-Cursor"}, header: true, total: 7500, wantPages: 3},
+		{name: "offset", pagination: Pagination{Mode: PaginationOffset}, total: 7500, wantPages: 3},
+		{name: "offset with a full last page", pagination: Pagination{Mode: PaginationOffset}, total: 6000, wantPages: 3},
+		{name: "single page", pagination: Pagination{Mode: PaginationCursor, CursorField: "next_cursor"}, total: 10, wantPages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, queries := newPagedAPI(t, tt.total, tt.header)
+
+			pagination := DefaultPagination()
+			pagination.Mode = tt.pagination.Mode
+			pagination.PageSize = 3000
+			if tt.pagination.CursorHeader != "" {
+				pagination.CursorHeader = tt.pagination.CursorHeader
+			}
+
+			// Pages are batched together rather than inserted one by one
+			var inserted []models.TimeSeriesData
+			var sizes []int
+			repo := mocks.NewMockTimeSeriesRepository(gomock.NewController(t))
+			repo.EXPECT().
+				BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, data []models.TimeSeriesData) error {
+					inserted = append(inserted, data...)
+					sizes = append(sizes, len(data))
+					return nil
+				}).
+				AnyTimes()
+
+			fetcher := NewSeriesFetcher(srv.URL, repo, logger)
+			require.NoError(t, fetcher.SetPagination(pagination))
+			require.NoError(t, fetcher.FetchData(context.Background(), start, end))
+
+			assert.Len(t, *queries, tt.wantPages)
+			require.Len(t, inserted, tt.total)
+			assert.Equal(t, time.Unix(1700000000+int64(tt.total)-1, 0), inserted[tt.total-1].Time)
+			for _, size := range sizes[:len(sizes)-1] {
+				assert.Equal(t, insertChunkSize, size)
+			}
+			for _, query := range *queries {
+				assert.Contains(t, query, "start=2024-11-23T00%3A00%3A00")
+				assert.Contains(t, query, "limit=3000")
+			}
+		})
+	}
+
+	t.Run("repeated cursor", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"result": [], "next_cursor": "abc"}`))
+		}))
+		defer srv.Close()
+
+		fetcher := NewSeriesFetcher(srv.URL, mocks.NewMockTimeSeriesRepository(gomock.NewController(t)), logger)
+		require.NoError(t, fetcher.SetPagination(Pagination{Mode: PaginationCursor, PageSize: 10, CursorParam: "cursor", CursorField: "next_cursor", MaxPages: 10}))
+		assert.ErrorContains(t, fetcher.FetchData(context.Background(), start, end), `same cursor "abc" twice`)
+	})
+
+	t.Run("too many pages", func(t *testing.T) {
+		srv, _ := newPagedAPI(t, 100, false)
+
+		pagination := DefaultPagination()
+		pagination.Mode = PaginationOffset
+		pagination.PageSize = 10
+		pagination.MaxPages = 3
+
+		fetcher := NewSeriesFetcher(srv.URL, mocks.NewMockTimeSeriesRepository(gomock.NewController(t)), logger)
+		require.NoError(t, fetcher.SetPagination(pagination))
+		assert.ErrorContains(t, fetcher.FetchData(context.Background(), start, end), "maximum of 3 pages")
+	})
+
+	t.Run("cursor field needs a JSON decoder", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("time,value\n"))
+		}))
+		defer srv.Close()
+
+		decoder, err := NewDecoder(DecoderConfig{Format: FormatCSV})
+		require.NoError(t, err)
+
+		fetcher := NewSeriesFetcher(srv.URL, mocks.NewMockTimeSeriesRepository(gomock.NewController(t)), logger)
+		fetcher.SetDecoder(decoder)
+		pagination := DefaultPagination()
+		pagination.Mode = PaginationCursor
+		require.NoError(t, fetcher.SetPagination(pagination))
+		assert.ErrorContains(t, fetcher.FetchData(context.Background(), start, end), "configure a cursor header")
+	})
+}
+
+func TestPaginationValidate(t *testing.T) {
+	valid := DefaultPagination()
+	valid.Mode = PaginationCursor
+
+	tests := []struct {
+		name    string
+		modify  func(*Pagination)
+		wantErr string
+	}{
+		{name: "disabled", modify: func(p *Pagination) { *p = Pagination{} }},
+		{name: "cursor", modify: func(p *Pagination) {}},
+		{name: "offset", modify: func(p *Pagination) { p.Mode = PaginationOffset }},
+		{name: "unknown mode", modify: func(p *Pagination) { p.Mode = "page" }, wantErr: "invalid pagination mode"},
+		{name: "no cursor source", modify: func(p *Pagination) { p.CursorField = "" }, wantErr: "cursor field or header"},
+		{name: "no offset parameter", modify: func(p *Pagination) { p.Mode = PaginationOffset; p.OffsetParam = "" }, wantErr: "offset and limit parameters"},
+		{name: "no page size", modify: func(p *Pagination) { p.PageSize = 0 }, wantErr: "page size"},
+		{name: "no max pages", modify: func(p *Pagination) { p.MaxPages = 0 }, wantErr: "max pages"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.modify(&p)
+
+			err := p.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// ProbeResult describes a successful test request to the API.
+type ProbeResult struct {
+	// Points is the number of points decoded from the response
+	Points int
+	// Elapsed is how long the request took
+	Elapsed time.Duration
+	// ServerTime is the API server's clock from the response's Date
+	// header, the zero time if it sent none
+	ServerTime time.Time
+}
+
+// Probe requests [start, end] once with the configured credentials and
+// query parameters, and decodes the response without storing it, to check
+// that the API is reachable and accepts the credentials. Only the first
+// page is requested, failures are not retried and the circuit breaker is
+// bypassed.
+func (f *SeriesFetcher) Probe(ctx context.Context, start, end time.Time) (ProbeResult, error) {
+	var result ProbeResult
+
+	url := fmt.Sprintf("%s?start=%s&end=%s",
+		f.apiURL,
+		start.Format("2006-01-02T15:04:05"),
+		end.Format("2006-01-02T15:04:05"))
+	url, err := f.pagination.pageURL(url, page{})
+	if err != nil {
+		return result, err
+	}
+
+	req, err := f.newRequest(ctx, url)
+	if err != nil {
+		return result, err
+	}
+
+	sent := time.Now()
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return result, f.requestError(err)
+	}
+	defer resp.Body.Close()
+	result.Elapsed = time.Since(sent)
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		result.ServerTime = date
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		return result, fmt.Errorf("%w: got %d, the credentials were rejected", ErrAPIStatus, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		return result, fmt.Errorf("%w: got %d", ErrAPIStatus, resp.StatusCode)
+	}
+
+	err = f.decoder.Decode(resp.Body, func(models.TimeSeriesData) error {
+		result.Points++
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	serverTime := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"result": [{"time": 1732363200, "value": 1.5}, {"time": 1732363260, "value": 2.5}]}`))
+	}))
+	defer srv.Close()
+
+	// No repository: probes never store data
+	fetcher := NewSeriesFetcher(srv.URL, nil, logger)
+	end := time.Now()
+
+	t.Run("rejected credentials", func(t *testing.T) {
+		_, err := fetcher.Probe(context.Background(), end.Add(-time.Hour), end)
+		assert.True(t, errors.Is(err, ErrAPIStatus))
+		assert.ErrorContains(t, err, "got 401, the credentials were rejected")
+	})
+
+	t.Run("success", func(t *testing.T) {
+		require.NoError(t, fetcher.SetAuth(AuthConfig{Type: AuthBearer, Token: "s3cret"}))
+		result, err := fetcher.Probe(context.Background(), end.Add(-time.Hour), end)
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.Points)
+		assert.Equal(t, serverTime, result.ServerTime)
+		assert.Positive(t, result.Elapsed)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		unreachable := NewSeriesFetcher("http://127.0.0.1:1", nil, logger)
+		require.NoError(t, unreachable.SetQueryParams(map[string]string{"api_key": "s3cret"}))
+		_, err := unreachable.Probe(context.Background(), end.Add(-time.Hour), end)
+		assert.True(t, errors.Is(err, ErrAPIRequest))
+		assert.NotContains(t, err.Error(), "s3cret")
+	})
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how FetchData retries failed API requests.
+//
+// Requests that fail before a response arrives, or with one of the
+// RetryableStatus codes, are retried with exponential backoff and full
+// jitter: the delay before retry n is drawn uniformly from
+// [0, min(MaxDelay, BaseDelay*2^(n-1))]. A Retry-After header takes
+// precedence; if it asks for a longer wait than MaxDelay, the request is
+// not retried. Responses that were accepted are never retried, since their
+// points may already be partly stored.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first;
+	// 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff and the accepted Retry-After
+	MaxDelay time.Duration
+	// RetryableStatus lists the HTTP status codes worth retrying
+	RetryableStatus []int
+}
+
+// DefaultRetryPolicy returns a RetryPolicy that makes up to 3 attempts,
+// retrying rate limiting and gateway errors.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+		RetryableStatus: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// Validate checks that the policy is usable.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("retry max attempts must be at least 1")
+	}
+	if p.BaseDelay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("retry delays must not be negative")
+	}
+	if p.MaxDelay < p.BaseDelay {
+		return fmt.Errorf("retry max delay must not be less than the base delay")
+	}
+	for _, code := range p.RetryableStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retryable status code: %d", code)
+		}
+	}
+	return nil
+}
+
+// retryable reports whether responses with the status code are retried.
+func (p RetryPolicy) retryable(code int) bool {
+	for _, c := range p.RetryableStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the jittered delay before the given retry, counting
+// from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay + 1)
+}
+
+// delay returns how long to wait before retrying after err, and false if
+// err should not be retried.
+func (p RetryPolicy) delay(retry int, err error) (time.Duration, bool) {
+	var retryErr *retryableError
+	if !errors.As(err, &retryErr) || retry >= p.MaxAttempts {
+		return 0, false
+	}
+	if retryErr.retryAfter > 0 {
+		return retryErr.retryAfter, retryErr.retryAfter <= p.MaxDelay
+	}
+	return p.backoff(retry), true
+}
+
+// retryableError marks a failed attempt that may be retried, with the
+// wait the server asked for, if any.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date. It returns 0 if the header is absent or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+)
+
+// newFlakyAPI answers with the given status codes in turn and with one data
+// point once they are used up. Every failure carries retryAfter, if set.
+func newFlakyAPI(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte(`{"result": [{"time": 1700000000, "value": 1.0}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestFetchDataRetry(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = 5 * time.Millisecond
+
+	start := time.Now().Add(-time.Hour)
+	end := time.Now()
+
+	tests := []struct {
+		name       string
+		statuses   []int
+		retryAfter string
+		wantCalls  int32
+		wantErr    bool
+	}{
+		{name: "transient failures", statuses: []int{503, 429}, wantCalls: 3},
+		{name: "attempts exhausted", statuses: []int{503, 502, 504}, wantCalls: 3, wantErr: true},
+		{name: "not retryable", statuses: []int{404}, wantCalls: 1, wantErr: true},
+		{name: "retry after within max delay", statuses: []int{503}, retryAfter: "0", wantCalls: 2},
+		{name: "retry after beyond max delay", statuses: []int{503}, retryAfter: "120", wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			repo := mocks.NewMockTimeSeriesRepository(ctrl)
+			api, calls := newFlakyAPI(t, tt.retryAfter, tt.statuses...)
+
+			if !tt.wantErr {
+				repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(1)).Return(nil)
+			}
+
+			fetcher := NewSeriesFetcher(api.URL, repo, logger)
+			fetcher.SetRetryPolicy(policy)
+
+			err := fetcher.FetchData(context.Background(), start, end)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrAPIStatus)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, calls.Load())
+		})
+	}
+
+	t.Run("cancellation stops retrying", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api, calls := newFlakyAPI(t, "", 503, 503, 503)
+
+		slow := policy
+		slow.BaseDelay = time.Minute
+		slow.MaxDelay = time.Minute
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		fetcher.SetRetryPolicy(slow)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := fetcher.FetchData(ctx, start, end)
+		assert.ErrorIs(t, err, ErrAPIStatus)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("validate", func(t *testing.T) {
+		assert.NoError(t, DefaultRetryPolicy().Validate())
+		assert.NoError(t, RetryPolicy{MaxAttempts: 1}.Validate())
+		assert.Error(t, RetryPolicy{}.Validate())
+		assert.Error(t, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Millisecond}.Validate())
+		assert.Error(t, RetryPolicy{MaxAttempts: 3, RetryableStatus: []int{42}}.Validate())
+	})
+
+	t.Run("backoff grows up to the cap", func(t *testing.T) {
+		policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+		for retry, limit := range map[int]time.Duration{
+			1: 100 * time.Millisecond,
+			2: 200 * time.Millisecond,
+			3: 400 * time.Millisecond,
+			9: time.Second,
+		} {
+			for i := 0; i < 50; i++ {
+				delay := policy.backoff(retry)
+				require.GreaterOrEqual(t, delay, time.Duration(0))
+				require.LessOrEqual(t, delay, limit, "retry %d", retry)
+			}
+		}
+	})
+
+	t.Run("parse retry after", func(t *testing.T) {
+		now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+
+		assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+		assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+		assert.Zero(t, parseRetryAfter("", now))
+		assert.Zero(t, parseRetryAfter("-1", now))
+		assert.Zero(t, parseRetryAfter("soon", now))
+		assert.Zero(t, parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	})
+}
@@ -1,10 +1,19 @@
 // Package api provides functionality for interacting with the EdgeCom Energy API.
 //
 // The package implements:
-//   - Robust HTTP client with timeouts and context support
+//   - The DataFetcher interface for ingestion sources, with SeriesFetcher
+//     as its HTTP implementation
+//   - Robust HTTP client with timeouts, retries and context support
+//   - Bearer, basic or custom header authentication and extra query
+//     parameters
+//   - A circuit breaker that pauses requests while the API is down
 //   - Automatic data conversion and storage
-//   - Historical data bootstrapping
-//   - Structured logging
+//   - Pluggable response decoders (JSON with configurable fields, CSV)
+//   - Cursor or offset pagination of large responses
+//   - Historical data bootstrapping with a configurable failure policy
+//   - Repair of ranges that could not be ingested
+//   - Catching up from the ingest watermark after missed runs
+//   - Structured logging and tracing of API calls
 //   - Error handling with custom error types
 //
 // Example:
@@ -15,6 +24,18 @@
 //	    logger,
 //	)
 //
+//	// Sources with a different response format need a matching decoder
+//	decoder, err := api.NewDecoder(api.DecoderConfig{Format: api.FormatCSV})
+//	if err != nil {
+//	    return err
+//	}
+//	fetcher.SetDecoder(decoder)
+//
+//	// Authenticated endpoints need credentials
+//	if err := fetcher.SetAuth(api.AuthConfig{Type: api.AuthBearer, Token: token}); err != nil {
+//	    return err
+//	}
+//
 //	if err := fetcher.FetchData(ctx, start, end); err != nil {
 //	    log.Printf("Failed to fetch data: %v", err)
 //	    return err
@@ -23,16 +44,26 @@ package api
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
 	"github.com/tejusbharadwaj/edgecom/internal/database"
 	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Error types for API-related errors
@@ -43,11 +74,36 @@ var (
 	ErrAPIStatus = errors.New("error status from API")
 )
 
+// insertChunkSize is the number of points inserted per transaction while
+// decoding an API response
+const insertChunkSize = 5000
+
+// catchUpChunk bounds the range of a single fetch while catching up, so a
+// long outage is recovered in requests of a size the API handles well
+const catchUpChunk = time.Hour
+
+// tracer creates spans around API calls, with the inserts they trigger as
+// children
+var tracer = otel.Tracer("github.com/tejusbharadwaj/edgecom/internal/api")
+
 // SeriesFetcher is a struct that fetches data from the EdgeCom Energy API and stores it in a database.
 type SeriesFetcher struct {
-	apiURL    string
-	dbService database.TimeSeriesRepository
-	logger    *logrus.Logger
+	apiURL      string
+	dbService   database.TimeSeriesRepository
+	decoder     Decoder
+	retryPolicy RetryPolicy
+	breaker     *CircuitBreaker
+	auth        AuthConfig
+	queryParams map[string]string
+	pagination  Pagination
+	client      *http.Client
+	clock       clock.Clock
+	logger      *logrus.Logger
+
+	// latency and ingested, if set, observe API requests and count the
+	// points stored
+	latency  *prometheus.HistogramVec
+	ingested prometheus.Counter
 }
 
 // NewSeriesFetcher creates a new SeriesFetcher instance.
@@ -60,46 +116,272 @@ type SeriesFetcher struct {
 //   - A configured SeriesFetcher instance ready for use
 func NewSeriesFetcher(apiURL string, dbService database.TimeSeriesRepository, logger *logrus.Logger) *SeriesFetcher {
 	return &SeriesFetcher{
-		apiURL:    apiURL,
-		dbService: dbService,
-		logger:    logger,
+		apiURL:      apiURL,
+		dbService:   dbService,
+		decoder:     &jsonDecoder{cfg: DefaultDecoderConfig()},
+		retryPolicy: DefaultRetryPolicy(),
+		pagination:  DefaultPagination(),
+		client:      &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		clock:       clock.System,
+		logger:      logger,
 	}
 }
 
+// SetDecoder replaces the response decoder, which defaults to the EdgeCom
+// API's JSON format. It must be called before fetching starts.
+func (f *SeriesFetcher) SetDecoder(decoder Decoder) {
+	f.decoder = decoder
+}
+
+// SetRetryPolicy replaces the retry policy for API requests, which
+// defaults to DefaultRetryPolicy. It must be called before fetching starts.
+func (f *SeriesFetcher) SetRetryPolicy(policy RetryPolicy) {
+	f.retryPolicy = policy
+}
+
+// SetClock replaces the wall clock the bootstrap fetches history up to.
+// It must be called before fetching starts.
+func (f *SeriesFetcher) SetClock(clk clock.Clock) {
+	f.clock = clk
+}
+
+// SetCircuitBreaker makes FetchData fail fast with ErrCircuitOpen while
+// breaker is open. It must be called before fetching starts.
+func (f *SeriesFetcher) SetCircuitBreaker(breaker *CircuitBreaker) {
+	f.breaker = breaker
+}
+
+// SetPagination makes FetchData follow further pages of a response, which
+// is disabled by default. Cursor mode without a cursor header needs a
+// decoder implementing CursorDecoder. It must be called before fetching
+// starts.
+func (f *SeriesFetcher) SetPagination(pagination Pagination) error {
+	if err := pagination.Validate(); err != nil {
+		return err
+	}
+	f.pagination = pagination
+	return nil
+}
+
+// SetAuth sets the credentials sent with every API request. It must be
+// called before fetching starts.
+func (f *SeriesFetcher) SetAuth(auth AuthConfig) error {
+	if err := auth.Validate(); err != nil {
+		return err
+	}
+	f.auth = auth
+	return nil
+}
+
+// SetMetrics registers metrics of the latency of API requests, by status
+// code, and of the points ingested with reg. It must be called before
+// fetching starts.
+func (f *SeriesFetcher) SetMetrics(reg prometheus.Registerer) error {
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "edgecom_upstream_request_duration_seconds",
+		Help: "Latency of upstream API requests until their response headers, by status code",
+	}, []string{"code"})
+	ingested := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "edgecom_points_ingested_total",
+		Help: "Points fetched from the upstream API and stored",
+	})
+	for _, c := range []prometheus.Collector{latency, ingested} {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register fetcher metric: %v", err)
+		}
+	}
+	f.latency = latency
+	f.ingested = ingested
+	return nil
+}
+
+// CloseIdleConnections closes the idle connections to the API, so the
+// next requests connect afresh. It is used after credentials are rotated.
+func (f *SeriesFetcher) CloseIdleConnections() {
+	f.client.CloseIdleConnections()
+}
+
+// SetQueryParams sets extra query parameters sent with every API request,
+// such as an API key or a site identifier. Their values are redacted from
+// the errors, logs and traces of failed requests, so they may carry
+// credentials, though credentials are better sent in a header with
+// SetAuth, which also keeps them out of the API's access logs. start and
+// end are reserved. It must be called before fetching starts.
+func (f *SeriesFetcher) SetQueryParams(params map[string]string) error {
+	if err := validateQueryParams(params); err != nil {
+		return err
+	}
+	f.queryParams = params
+	return nil
+}
+
 // FetchData fetches data from the EdgeCom Energy API for a given time range and stores it in the database.
 // The method:
 //  1. Constructs the API request with proper formatting
-//  2. Executes the request with timeout
-//  3. Processes the response
-//  4. Stores the data in the database
-func (f *SeriesFetcher) FetchData(ctx context.Context, start, end time.Time) error {
+//  2. Executes the request with timeout, retrying transient failures
+//     according to the retry policy
+//  3. Decodes the response incrementally, following further pages when
+//     pagination is enabled
+//  4. Stores the data in the database in bounded chunks, which may span
+//     pages
+//
+// The whole call, including retries and pages, is traced as a single
+// client span, with the trace context propagated to the API in the request
+// headers.
+//
+// With a circuit breaker set, a call that fails because of the API counts
+// as one failure, and calls are rejected with ErrCircuitOpen while the
+// circuit is open.
+func (f *SeriesFetcher) FetchData(ctx context.Context, start, end time.Time) (err error) {
+	if f.breaker != nil {
+		if err := f.breaker.allow(); err != nil {
+			return err
+		}
+		defer func() { f.recordOutcome(ctx, err) }()
+	}
+
 	url := fmt.Sprintf("%s?start=%s&end=%s",
 		f.apiURL,
 		start.Format("2006-01-02T15:04:05"),
 		end.Format("2006-01-02T15:04:05"))
 
+	ctx, span := tracer.Start(ctx, http.MethodGet,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodGet,
+			semconv.URLFull(url),
+			attribute.String("edgecom.range.start", start.Format(time.RFC3339)),
+			attribute.String("edgecom.range.end", end.Format(time.RFC3339)),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	f.logger.WithFields(logrus.Fields{
 		"url":   url,
 		"start": start,
 		"end":   end,
 	}).Debug("Fetching data from API")
 
+	batch := &pointBatch{ctx: ctx, repo: f.dbService, ingested: f.ingested}
+	var attempts int
+	for pg := (page{}); ; {
+		pageURL, err := f.pagination.pageURL(url, pg)
+		if err != nil {
+			return err
+		}
+
+		result, err := f.fetchPage(ctx, pageURL, batch, &attempts)
+		span.SetAttributes(
+			attribute.Int("edgecom.attempts", attempts),
+			attribute.Int("edgecom.pages", pg.number+1),
+		)
+		if err != nil {
+			if pg.number > 0 {
+				return fmt.Errorf("page %d: %w", pg.number+1, err)
+			}
+			return err
+		}
+
+		if f.pagination.Mode != PaginationNone {
+			f.logger.WithFields(logrus.Fields{
+				"page":   pg.number + 1,
+				"points": result.count,
+				"start":  start,
+				"end":    end,
+			}).Debug("Fetched page from API")
+		}
+
+		var more bool
+		if pg, more, err = f.pagination.next(pg, result); err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+
+	if err := batch.flush(); err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("edgecom.points", batch.count))
+
+	if batch.count == 0 {
+		f.logger.Debug("No data points received from API")
+		return nil
+	}
+
+	f.logger.WithField("count", batch.count).Debug("Successfully inserted data points")
+	return nil
+}
+
+// fetchPage requests one page, retrying according to the retry policy, and
+// adds its points to batch. attempts is incremented for every request.
+func (f *SeriesFetcher) fetchPage(ctx context.Context, url string, batch *pointBatch, attempts *int) (pageResult, error) {
+	for attempt := 1; ; attempt++ {
+		*attempts++
+
+		result, err := f.fetchOnce(ctx, url, batch)
+		if err == nil {
+			return result, nil
+		}
+
+		delay, retry := f.retryPolicy.delay(attempt, err)
+		if !retry {
+			return result, err
+		}
+
+		f.logger.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("API request failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf("%w; retry abandoned: %w", err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// recordOutcome reports the result of a fetch to the circuit breaker. Only
+// failures to reach the API or error responses count against it.
+func (f *SeriesFetcher) recordOutcome(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		f.breaker.success()
+	case ctx.Err() == nil && (errors.Is(err, ErrAPIRequest) || errors.Is(err, ErrAPIStatus)):
+		f.breaker.failure()
+	default:
+		f.breaker.release()
+	}
+}
+
+// fetchOnce makes a single API request and adds the points of the response
+// to batch. Failures that happen before the response is accepted are
+// returned as retryableError when the retry policy allows it.
+func (f *SeriesFetcher) fetchOnce(ctx context.Context, url string, batch *pointBatch) (pageResult, error) {
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := f.newRequest(ctx, url)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrAPIRequest, err)
+		return pageResult{}, err
 	}
 
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("User-Agent", "EdgeCom-Client/1.0")
-
-	resp, err := http.DefaultClient.Do(req)
+	started := time.Now()
+	resp, err := f.client.Do(req)
+	f.observeRequest(started, resp)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrAPIRequest, err)
+		return pageResult{}, &retryableError{err: f.requestError(err)}
 	}
 	defer resp.Body.Close()
+	trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
@@ -107,64 +389,395 @@ func (f *SeriesFetcher) FetchData(ctx context.Context, start, end time.Time) err
 			"status": resp.StatusCode,
 			"body":   string(body),
 		}).Error("API request failed")
-		return fmt.Errorf("%w: got %d", ErrAPIStatus, resp.StatusCode)
+
+		err := fmt.Errorf("%w: got %d", ErrAPIStatus, resp.StatusCode)
+		if f.retryPolicy.retryable(resp.StatusCode) {
+			return pageResult{}, &retryableError{
+				err:        err,
+				retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			}
+		}
+		return pageResult{}, err
+	}
+
+	// The batch outlives this request's timeout, so inserts use the
+	// caller's context
+	result, err := f.decodePage(resp.Body, batch)
+	if err == nil && f.pagination.Mode == PaginationCursor && f.pagination.CursorHeader != "" {
+		result.cursor = resp.Header.Get(f.pagination.CursorHeader)
 	}
+	return result, err
+}
 
-	var apiResp models.APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return fmt.Errorf("failed to decode response: %v", err)
+// observeRequest records the latency of a request started at started, by
+// the status code of resp, or "error" if there is no response
+func (f *SeriesFetcher) observeRequest(started time.Time, resp *http.Response) {
+	if f.latency == nil {
+		return
+	}
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
 	}
+	f.latency.WithLabelValues(code).Observe(time.Since(started).Seconds())
+}
 
-	if len(apiResp.Result) == 0 {
-		f.logger.Debug("No data points received from API")
-		return nil
+// newRequest builds an API request for url with the configured credentials
+// and query parameters, propagating the trace context.
+func (f *SeriesFetcher) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAPIRequest, err)
 	}
 
-	dataPoints := make([]models.TimeSeriesData, len(apiResp.Result))
-	for i, data := range apiResp.Result {
-		dataPoints[i] = models.TimeSeriesData{
-			Time:  time.Unix(data.Time, 0),
-			Value: data.Value,
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("User-Agent", "EdgeCom-Client/1.0")
+	f.auth.apply(req)
+	if len(f.queryParams) > 0 {
+		query := req.URL.Query()
+		for name, value := range f.queryParams {
+			query.Set(name, value)
 		}
+		req.URL.RawQuery = query.Encode()
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, nil
+}
 
-	if err := f.dbService.BatchInsertTimeSeriesData(ctx, dataPoints); err != nil {
-		return fmt.Errorf("failed to insert data points: %v", err)
+// requestError wraps the error of a request that got no response in
+// ErrAPIRequest. The URL of a *url.Error carries the query parameters set
+// with SetQueryParams, which may be credentials, so their values are
+// redacted before the error is logged, traced or stored as a gap reason.
+func (f *SeriesFetcher) requestError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return fmt.Errorf("%w: %v", ErrAPIRequest, err)
+	}
+	return fmt.Errorf("%w: %s %q: %v", ErrAPIRequest, urlErr.Op, f.redactURL(urlErr.URL), urlErr.Err)
+}
+
+// redactURL replaces the values of the configured query parameters in
+// rawURL, and the whole query if it cannot be parsed
+func (f *SeriesFetcher) redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		base, _, _ := strings.Cut(rawURL, "?")
+		return base
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		u.RawQuery = ""
+		return u.Redacted()
+	}
+	for name := range f.queryParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.Redacted()
+}
+
+// decodeAndStore decodes an API response body with the fetcher's decoder
+// and inserts the points in chunks of insertChunkSize, so memory stays
+// bounded regardless of the size of the requested range. Chunks are
+// committed independently; if a later chunk fails, the earlier ones remain
+// stored.
+func (f *SeriesFetcher) decodeAndStore(ctx context.Context, body io.Reader) (int, error) {
+	batch := &pointBatch{ctx: ctx, repo: f.dbService, ingested: f.ingested}
+	if _, err := f.decodePage(body, batch); err != nil {
+		return batch.count, err
+	}
+	return batch.count, batch.flush()
+}
+
+// decodePage decodes one response body into batch. In cursor mode without
+// a cursor header, the next cursor is read from the body.
+func (f *SeriesFetcher) decodePage(body io.Reader, batch *pointBatch) (pageResult, error) {
+	var result pageResult
+
+	// Insert failures are passed through the decoder unchanged and must
+	// not be reported as decoding errors
+	var insertErr error
+	emit := func(point models.TimeSeriesData) error {
+		result.count++
+		insertErr = batch.add(point)
+		return insertErr
+	}
+
+	var err error
+	if f.pagination.Mode == PaginationCursor && f.pagination.CursorHeader == "" {
+		decoder, ok := f.decoder.(CursorDecoder)
+		if !ok {
+			return result, fmt.Errorf("the response format does not support cursor fields, configure a cursor header")
+		}
+		result.cursor, err = decoder.DecodeCursor(body, f.pagination.CursorField, emit)
+	} else {
+		err = f.decoder.Decode(body, emit)
+	}
+	if err != nil {
+		if insertErr != nil {
+			return result, insertErr
+		}
+		return result, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return result, nil
+}
+
+// pointBatch collects decoded points and inserts them in chunks of
+// insertChunkSize. Chunks are committed independently; if a later chunk
+// fails, the earlier ones remain stored.
+type pointBatch struct {
+	ctx   context.Context
+	repo  database.TimeSeriesRepository
+	chunk []models.TimeSeriesData
+	// count is the number of points inserted
+	count int
+	// ingested, if set, counts the points inserted
+	ingested prometheus.Counter
+}
+
+// add appends a point, inserting the chunk once it is full
+func (b *pointBatch) add(point models.TimeSeriesData) error {
+	if b.chunk == nil {
+		b.chunk = make([]models.TimeSeriesData, 0, insertChunkSize)
+	}
+	b.chunk = append(b.chunk, point)
+	if len(b.chunk) == insertChunkSize {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush inserts the points collected so far
+func (b *pointBatch) flush() error {
+	if len(b.chunk) == 0 {
+		return nil
+	}
+	if err := b.repo.BatchInsertTimeSeriesData(b.ctx, b.chunk); err != nil {
+		return fmt.Errorf("failed to insert data points: %w", err)
+	}
+	b.count += len(b.chunk)
+	if b.ingested != nil {
+		b.ingested.Add(float64(len(b.chunk)))
+	}
+	// Start a new slice: the repository may keep the inserted one, e.g. to
+	// hand it to live subscribers
+	b.chunk = nil
+	return nil
+}
+
+// Bootstrap failure modes
+const (
+	// BootstrapFail aborts the bootstrap when the historical fetch fails
+	BootstrapFail = "fail"
+	// BootstrapFallback ingests only the most recent FallbackWindow when the
+	// historical fetch fails and records the rest of the range as a gap
+	BootstrapFallback = "fallback"
+)
+
+// BootstrapPolicy controls how BootstrapHistoricalData reacts when the
+// historical fetch fails.
+type BootstrapPolicy struct {
+	// OnFailure is BootstrapFail or BootstrapFallback
+	OnFailure string
+	// FallbackWindow is how much recent data to fetch in fallback mode
+	FallbackWindow time.Duration
+	// RetrySchedule lists the delays before each retry of the full
+	// historical fetch; it is empty when the fetch is not retried
+	RetrySchedule []time.Duration
+}
+
+// DefaultBootstrapPolicy returns a BootstrapPolicy that falls back to the
+// last 24 hours without retrying.
+func DefaultBootstrapPolicy() BootstrapPolicy {
+	return BootstrapPolicy{
+		OnFailure:      BootstrapFallback,
+		FallbackWindow: 24 * time.Hour,
+	}
+}
+
+// Validate checks that the policy is usable.
+func (p BootstrapPolicy) Validate() error {
+	switch p.OnFailure {
+	case BootstrapFail:
+	case BootstrapFallback:
+		if p.FallbackWindow <= 0 {
+			return fmt.Errorf("bootstrap fallback window must be positive")
+		}
+	default:
+		return fmt.Errorf("invalid bootstrap failure mode: %q", p.OnFailure)
 	}
 
-	f.logger.WithField("count", len(dataPoints)).Debug("Successfully inserted data points")
+	for _, delay := range p.RetrySchedule {
+		if delay < 0 {
+			return fmt.Errorf("bootstrap retry delay must not be negative")
+		}
+	}
 	return nil
 }
 
 // BootstrapHistoricalData initializes the database with historical data.
-// It attempts to fetch the last 2 years of data, with a fallback to
-// the last 24 hours if the full historical fetch fails.
+// It attempts to fetch the last 2 years of data, retrying according to the
+// policy's retry schedule.
 //
-// The method implements a graceful degradation strategy:
-//  1. Attempts to fetch 2 years of historical data
-//  2. On failure, falls back to last 24 hours
-//  3. Logs all operations and failures
-func (f *SeriesFetcher) BootstrapHistoricalData(ctx context.Context) error {
-	endTime := time.Now()
+// If every attempt fails, the policy decides what happens next:
+//  1. BootstrapFail returns the error
+//  2. BootstrapFallback fetches the last FallbackWindow of data and records
+//     the remaining range as a gap, so that RepairGaps can fetch it later
+func (f *SeriesFetcher) BootstrapHistoricalData(ctx context.Context, policy BootstrapPolicy) error {
+	endTime := f.clock.Now()
 	startTime := endTime.AddDate(-2, 0, 0)
 
 	f.logger.WithFields(logrus.Fields{
 		"startTime": startTime,
 		"endTime":   endTime,
+		"onFailure": policy.OnFailure,
 	}).Info("Starting historical data bootstrap")
 
-	if err := f.FetchData(ctx, startTime, endTime); err != nil {
-		f.logger.WithError(err).Error("Failed to fetch historical data")
+	err := f.FetchData(ctx, startTime, endTime)
+	for attempt, delay := range policy.RetrySchedule {
+		if err == nil {
+			break
+		}
+		f.logger.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Warn("Historical data fetch failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		err = f.FetchData(ctx, startTime, endTime)
+	}
+
+	if err == nil {
+		f.advanceWatermark(ctx, endTime)
+		f.logger.Info("Historical data bootstrap completed")
+		return nil
+	}
+
+	f.logger.WithError(err).Error("Failed to fetch historical data")
+
+	if policy.OnFailure != BootstrapFallback {
+		return fmt.Errorf("failed to fetch historical data: %w", err)
+	}
+
+	recentStart := endTime.Add(-policy.FallbackWindow)
+	if recentStart.Before(startTime) {
+		recentStart = startTime
+	}
+
+	f.logger.WithField("window", policy.FallbackWindow).Info("Attempting to fetch recent data")
+	if err := f.FetchData(ctx, recentStart, endTime); err != nil {
+		return fmt.Errorf("failed to fetch recent data: %v", err)
+	}
+
+	if recentStart.After(startTime) {
+		gapFields := logrus.Fields{
+			"gapStart": startTime,
+			"gapEnd":   recentStart,
+		}
+		if recordErr := f.dbService.RecordGap(ctx, startTime, recentStart, err.Error()); recordErr != nil {
+			f.logger.WithError(recordErr).WithFields(gapFields).Error("Failed to record unfetched bootstrap range")
+		} else {
+			f.logger.WithFields(gapFields).Warn("Recorded unfetched bootstrap range for repair")
+		}
+	}
+
+	// The unfetched range is tracked as a gap, so the watermark may move
+	// past it
+	f.advanceWatermark(ctx, endTime)
+	f.logger.Info("Historical data bootstrap completed with fallback")
+	return nil
+}
+
+// CatchUp fetches everything between the ingest watermark and end, in
+// windows of at most one hour, advancing the watermark after each one. A
+// run that was missed or failed is therefore fetched by the next one
+// instead of being lost.
+//
+// Without a recorded watermark, only the window before end is fetched. On
+// failure CatchUp stops, and the next call resumes from the last window
+// that was stored.
+func (f *SeriesFetcher) CatchUp(ctx context.Context, end time.Time, window time.Duration) error {
+	start, err := f.dbService.Watermark(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read watermark: %w", err)
+	}
+	if start.IsZero() {
+		start = end.Add(-window)
+	}
+
+	if behind := end.Sub(start); behind > window {
+		f.logger.WithFields(logrus.Fields{
+			"watermark": start,
+			"behind":    behind,
+		}).Warn("Catching up on missed data")
+	}
+
+	for start.Before(end) {
+		chunkEnd := start.Add(catchUpChunk)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+
+		if err := f.FetchData(ctx, start, chunkEnd); err != nil {
+			return err
+		}
+		if err := f.dbService.AdvanceWatermark(ctx, chunkEnd); err != nil {
+			return fmt.Errorf("failed to advance watermark: %w", err)
+		}
+		start = chunkEnd
+	}
+
+	return nil
+}
+
+// advanceWatermark records that everything before t has been handled.
+// Failures are only logged: the next catch-up then fetches some data twice.
+func (f *SeriesFetcher) advanceWatermark(ctx context.Context, t time.Time) {
+	if err := f.dbService.AdvanceWatermark(ctx, t); err != nil {
+		f.logger.WithError(err).WithField("watermark", t).Error("Failed to advance watermark")
+	}
+}
+
+// RepairGaps fetches every recorded gap and marks the ones that were
+// ingested successfully as resolved. Gaps that still fail stay pending for
+// the next run.
+func (f *SeriesFetcher) RepairGaps(ctx context.Context) error {
+	gaps, err := f.dbService.PendingGaps(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list gaps: %w", err)
+	}
 
-		// If historical data fetch fails, try to get at least the last 24 hours
-		recentStart := endTime.Add(-24 * time.Hour)
-		f.logger.Info("Attempting to fetch last 24 hours of data")
+	var failed int
+	for _, gap := range gaps {
+		gapLogger := f.logger.WithFields(logrus.Fields{
+			"gapID":    gap.ID,
+			"gapStart": gap.Start,
+			"gapEnd":   gap.End,
+		})
 
-		if err := f.FetchData(ctx, recentStart, endTime); err != nil {
-			return fmt.Errorf("failed to fetch recent data: %v", err)
+		if err := f.FetchData(ctx, gap.Start, gap.End); err != nil {
+			gapLogger.WithError(err).Warn("Failed to repair gap")
+			failed++
+			continue
+		}
+		if err := f.dbService.ResolveGap(ctx, gap.ID); err != nil {
+			gapLogger.WithError(err).Error("Failed to mark gap as resolved")
+			failed++
+			continue
 		}
+		gapLogger.Info("Repaired gap")
 	}
 
-	f.logger.Info("Historical data bootstrap completed")
+	if failed > 0 {
+		return fmt.Errorf("%d of %d gaps could not be repaired", failed, len(gaps))
+	}
 	return nil
 }
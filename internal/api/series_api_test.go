@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// newTestAPI serves one data point per request. Requests spanning more than
+// maxRange, and the first failFirst requests, get a 503.
+func newTestAPI(t *testing.T, maxRange time.Duration, failFirst int32) *httptest.Server {
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, err := time.Parse("2006-01-02T15:04:05", r.URL.Query().Get("start"))
+		require.NoError(t, err)
+		end, err := time.Parse("2006-01-02T15:04:05", r.URL.Query().Get("end"))
+		require.NoError(t, err)
+
+		if calls.Add(1) <= failFirst || end.Sub(start) > maxRange {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": []map[string]interface{}{{"time": end.Unix(), "value": 1.0}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// noRetry makes failures surface immediately in tests of the callers of
+// FetchData
+var noRetry = RetryPolicy{MaxAttempts: 1}
+
+func TestBootstrapHistoricalData(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	t.Run("full history", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, 3*365*24*time.Hour, 0)
+
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil)
+		repo.EXPECT().
+			AdvanceWatermark(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, watermark time.Time) error {
+				assert.WithinDuration(t, time.Now(), watermark, time.Minute)
+				return nil
+			})
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		assert.NoError(t, fetcher.BootstrapHistoricalData(context.Background(), DefaultBootstrapPolicy()))
+	})
+
+	t.Run("retry schedule", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, 3*365*24*time.Hour, 2)
+
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil)
+		repo.EXPECT().AdvanceWatermark(gomock.Any(), gomock.Any()).Return(nil)
+
+		policy := BootstrapPolicy{
+			OnFailure:     BootstrapFail,
+			RetrySchedule: []time.Duration{time.Millisecond, time.Millisecond},
+		}
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		fetcher.SetRetryPolicy(noRetry)
+		assert.NoError(t, fetcher.BootstrapHistoricalData(context.Background(), policy))
+	})
+
+	t.Run("fail hard", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, 48*time.Hour, 0)
+
+		policy := BootstrapPolicy{OnFailure: BootstrapFail}
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		fetcher.SetRetryPolicy(noRetry)
+		err := fetcher.BootstrapHistoricalData(context.Background(), policy)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrAPIStatus)
+	})
+
+	t.Run("fallback records the gap", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, 48*time.Hour, 0)
+
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil)
+		repo.EXPECT().
+			RecordGap(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, start, end time.Time, reason string) error {
+				assert.InDelta(t, (2 * 365 * 24 * time.Hour).Hours(), end.Sub(start).Hours(), 48)
+				assert.WithinDuration(t, time.Now().Add(-12*time.Hour), end, time.Minute)
+				assert.Contains(t, reason, "503")
+				return nil
+			})
+		repo.EXPECT().AdvanceWatermark(gomock.Any(), gomock.Any()).Return(nil)
+
+		policy := BootstrapPolicy{OnFailure: BootstrapFallback, FallbackWindow: 12 * time.Hour}
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		fetcher.SetRetryPolicy(noRetry)
+		assert.NoError(t, fetcher.BootstrapHistoricalData(context.Background(), policy))
+	})
+
+	t.Run("fallback failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, time.Hour, 0)
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		fetcher.SetRetryPolicy(noRetry)
+		err := fetcher.BootstrapHistoricalData(context.Background(), DefaultBootstrapPolicy())
+		assert.ErrorContains(t, err, "failed to fetch recent data")
+	})
+}
+
+func TestBootstrapPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  BootstrapPolicy
+		wantErr string
+	}{
+		{name: "default", policy: DefaultBootstrapPolicy()},
+		{name: "fail", policy: BootstrapPolicy{OnFailure: BootstrapFail}},
+		{
+			name:    "unknown mode",
+			policy:  BootstrapPolicy{OnFailure: "ignore"},
+			wantErr: "invalid bootstrap failure mode",
+		},
+		{
+			name:    "missing fallback window",
+			policy:  BootstrapPolicy{OnFailure: BootstrapFallback},
+			wantErr: "fallback window must be positive",
+		},
+		{
+			name: "negative retry delay",
+			policy: BootstrapPolicy{
+				OnFailure:     BootstrapFail,
+				RetrySchedule: []time.Duration{-time.Second},
+			},
+			wantErr: "retry delay must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRepairGaps(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTimeSeriesRepository(ctrl)
+	api := newTestAPI(t, 48*time.Hour, 0)
+
+	now := time.Now()
+	repo.EXPECT().PendingGaps(gomock.Any()).Return([]models.Gap{
+		{ID: 1, Start: now.Add(-30 * time.Hour), End: now.Add(-24 * time.Hour)},
+		{ID: 2, Start: now.Add(-500 * time.Hour), End: now.Add(-24 * time.Hour)},
+	}, nil)
+	repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil)
+	repo.EXPECT().ResolveGap(gomock.Any(), int64(1)).Return(nil)
+
+	fetcher := NewSeriesFetcher(api.URL, repo, logger)
+	fetcher.SetRetryPolicy(noRetry)
+	err := fetcher.RepairGaps(context.Background())
+	assert.ErrorContains(t, err, "1 of 2 gaps could not be repaired")
+}
+
+func TestCatchUp(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	end := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+
+	t.Run("without watermark", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, time.Hour, 0)
+
+		repo.EXPECT().Watermark(gomock.Any()).Return(time.Time{}, nil)
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil)
+		repo.EXPECT().AdvanceWatermark(gomock.Any(), end).Return(nil)
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		assert.NoError(t, fetcher.CatchUp(context.Background(), end, 5*time.Minute))
+	})
+
+	t.Run("missed runs are fetched in chunks", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, time.Hour, 0)
+
+		repo.EXPECT().Watermark(gomock.Any()).Return(end.Add(-150*time.Minute), nil)
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+		gomock.InOrder(
+			repo.EXPECT().AdvanceWatermark(gomock.Any(), end.Add(-90*time.Minute)).Return(nil),
+			repo.EXPECT().AdvanceWatermark(gomock.Any(), end.Add(-30*time.Minute)).Return(nil),
+			repo.EXPECT().AdvanceWatermark(gomock.Any(), end).Return(nil),
+		)
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		assert.NoError(t, fetcher.CatchUp(context.Background(), end, 5*time.Minute))
+	})
+
+	t.Run("failure keeps the watermark", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+		api := newTestAPI(t, time.Hour, 1)
+
+		repo.EXPECT().Watermark(gomock.Any()).Return(end.Add(-2*time.Hour), nil)
+
+		fetcher := NewSeriesFetcher(api.URL, repo, logger)
+		fetcher.SetRetryPolicy(noRetry)
+		err := fetcher.CatchUp(context.Background(), end, 5*time.Minute)
+		assert.ErrorIs(t, err, ErrAPIStatus)
+	})
+}
+
+func TestDecodeAndStore(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	t.Run("large result is inserted in chunks", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		repo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+		const total = 2*insertChunkSize + 1
+		var body strings.Builder
+		body.WriteString(`{"result": [`)
+		for i := 0; i < total; i++ {
+			if i > 0 {
+				body.WriteString(",")
+			}
+			fmt.Fprintf(&body, `{"time": %d, "value": %d}`, 1700000000+i, i)
+		}
+		body.WriteString(`]}`)
+
+		var sizes []int
+		var last models.TimeSeriesData
+		repo.EXPECT().
+			BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, data []models.TimeSeriesData) error {
+				sizes = append(sizes, len(data))
+				last = data[len(data)-1]
+				return nil
+			}).
+			Times(3)
+
+		fetcher := NewSeriesFetcher("", repo, logger)
+		count, err := fetcher.decodeAndStore(context.Background(), strings.NewReader(body.String()))
+		require.NoError(t, err)
+		assert.Equal(t, total, count)
+		assert.Equal(t, []int{insertChunkSize, insertChunkSize, 1}, sizes)
+		assert.Equal(t, time.Unix(1700000000+total-1, 0), last.Time)
+		assert.Equal(t, float64(total-1), last.Value)
+	})
+
+	tests := []struct {
+		name      string
+		body      string
+		wantCount int
+		wantErr   string
+	}{
+		{
+			name:      "other fields are skipped",
+			body:      `{"status": {"code": 0}, "result": [{"time": 1700000000, "value": 1.5}], "next": null}`,
+			wantCount: 1,
+		},
+		{name: "null result", body: `{"result": null}`},
+		{name: "empty result", body: `{"result": []}`},
+		{name: "not an object", body: `[]`, wantErr: "failed to decode response"},
+		{name: "result is not an array", body: `{"result": {}}`, wantErr: "result is not an array"},
+		{name: "truncated body", body: `{"result": [{"time": 1700000000, "value": 1.5}`, wantErr: "failed to decode response"},
+		{name: "invalid element", body: `{"result": [{"time": "yesterday"}]}`, wantErr: "failed to decode response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			repo := mocks.NewMockTimeSeriesRepository(ctrl)
+			if tt.wantCount > 0 {
+				repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(tt.wantCount)).Return(nil)
+			}
+
+			fetcher := NewSeriesFetcher("", repo, logger)
+			count, err := fetcher.decodeAndStore(context.Background(), strings.NewReader(tt.body))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+		})
+	}
+}
+
+func TestFetchDataMetrics(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTimeSeriesRepository(ctrl)
+	repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(1)).Return(nil)
+	api, _ := newFlakyAPI(t, "0", 503)
+
+	fetcher := NewSeriesFetcher(api.URL, repo, logger)
+	require.NoError(t, fetcher.SetMetrics(prometheus.NewRegistry()))
+	require.NoError(t, fetcher.FetchData(context.Background(), time.Now().Add(-time.Hour), time.Now()))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(fetcher.latency), "one series per status code")
+	assert.Equal(t, 1.0, testutil.ToFloat64(fetcher.ingested))
+}
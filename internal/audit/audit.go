@@ -0,0 +1,290 @@
+// Package audit keeps a tamper-evident trail of the calls made to the
+// service's gRPC API and HTTP gateway.
+//
+// Every call is recorded with its caller, parameters and outcome. Records
+// are chained: each holds the SHA-256 hash of its own fields and of the
+// record before it, so altering, removing or reordering stored records,
+// short of recomputing every later hash, is detected by verification.
+// Auditors can keep the head hash reported by a verification and compare
+// later exports against it, which also detects a recomputed chain.
+//
+// Records are written by a background loop so calls do not wait on the
+// database, and are counted in edgecom_audit_records_total; records that
+// could not be written are counted in edgecom_audit_write_failures_total
+// and logged. A call never blocks on the audit log: when the queue is
+// full, as while the database is slow, its record is dropped and counted
+// in edgecom_audit_records_dropped_total.
+//
+// Example Usage:
+//
+//	auditLog, err := audit.NewLogger(repo, logger, prometheus.DefaultRegisterer)
+//	if err != nil {
+//	    return err
+//	}
+//	go auditLog.Run(ctx)
+//
+//	serverConfig.Auditor = auditLog
+//	gw.SetAuditor(auditLog)
+//
+//	// Later, from the audit subcommand
+//	summary, err := audit.VerifyStore(ctx, repo, 0, "")
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tejusbharadwaj/edgecom/internal/auth"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Record sources
+const (
+	// SourceGRPC marks calls of the gRPC API
+	SourceGRPC = "grpc"
+	// SourceHTTP marks requests to the HTTP gateway
+	SourceHTTP = "http"
+)
+
+const (
+	// queueSize is the number of records waiting to be written before
+	// further records are dropped
+	queueSize = 1024
+	// maxRequestLength caps the length of a record's request summary, so
+	// large ingest batches are not copied into the audit log
+	maxRequestLength = 1024
+	// pageSize is the number of records read from the store at a time
+	pageSize = 1000
+	// drainTimeout bounds writing the queued records once Run is stopped
+	drainTimeout = 5 * time.Second
+	// writeTimeout bounds writing one record, so a hung database fills
+	// the queue, and records are dropped, rather than stalling the loop
+	writeTimeout = 5 * time.Second
+)
+
+// Store appends and reads audit records, such as a
+// *database.PostgresRepo.
+type Store interface {
+	// AppendAuditRecord appends the record seal returns, given the
+	// latest record or nil, serialised with other appends
+	AppendAuditRecord(ctx context.Context, seal func(prev *models.AuditRecord) models.AuditRecord) (models.AuditRecord, error)
+	// AuditRecords returns up to limit records after sequence number
+	// after, in order
+	AuditRecords(ctx context.Context, after int64, limit int) ([]models.AuditRecord, error)
+}
+
+// Seal chains r to prev, the latest record or nil if there is none,
+// setting its sequence number and hashes.
+func Seal(prev *models.AuditRecord, r models.AuditRecord) models.AuditRecord {
+	// Stored timestamps have microsecond precision
+	r.Time = r.Time.UTC().Truncate(time.Microsecond)
+	r.Seq, r.PrevHash = 1, ""
+	if prev != nil {
+		r.Seq, r.PrevHash = prev.Seq+1, prev.Hash
+	}
+	r.Hash = Hash(r)
+	return r
+}
+
+// Hash returns the hex-encoded SHA-256 hash of a record's fields other
+// than Hash.
+func Hash(r models.AuditRecord) string {
+	// A fixed field order, independent of models.AuditRecord, keeps hashes
+	// stable as the model evolves
+	fields := []string{
+		strconv.FormatInt(r.Seq, 10),
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.Subject,
+		r.Provider,
+		r.Source,
+		r.Action,
+		r.Request,
+		r.Code,
+		r.Peer,
+		r.PrevHash,
+	}
+	encoded, _ := json.Marshal(fields)
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:])
+}
+
+// Logger records calls in a Store.
+type Logger struct {
+	store    Store
+	logger   *logrus.Logger
+	queue    chan models.AuditRecord
+	done     chan struct{}
+	records  prometheus.Counter
+	failures prometheus.Counter
+	dropped  prometheus.Counter
+	now      func() time.Time
+}
+
+// NewLogger creates a Logger writing to store and registers its metrics
+// with reg. Records are written once Run is started.
+func NewLogger(store Store, logger *logrus.Logger, reg prometheus.Registerer) (*Logger, error) {
+	records := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "edgecom_audit_records_total",
+		Help: "Audit records written",
+	})
+	failures := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "edgecom_audit_write_failures_total",
+		Help: "Audit records that could not be written",
+	})
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "edgecom_audit_records_dropped_total",
+		Help: "Audit records dropped because the queue was full",
+	})
+	for _, c := range []prometheus.Collector{records, failures, dropped} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register audit metric: %v", err)
+		}
+	}
+
+	return &Logger{
+		store:    store,
+		logger:   logger,
+		queue:    make(chan models.AuditRecord, queueSize),
+		done:     make(chan struct{}),
+		records:  records,
+		failures: failures,
+		dropped:  dropped,
+		now:      time.Now,
+	}, nil
+}
+
+// Record queues r to be written, dropping it if the queue is full. Its
+// Time is set to now when zero. Records made after Run has returned are
+// counted as failures.
+func (l *Logger) Record(r models.AuditRecord) {
+	if r.Time.IsZero() {
+		r.Time = l.now()
+	}
+	// Checked first, as the queue may still have room once Run has
+	// returned and select picks among ready cases at random
+	select {
+	case <-l.done:
+		l.failures.Inc()
+		return
+	default:
+	}
+	select {
+	case l.queue <- r:
+	default:
+		l.dropped.Inc()
+	}
+}
+
+// Run writes queued records until ctx is done, then writes those still
+// queued.
+func (l *Logger) Run(ctx context.Context) {
+	for {
+		select {
+		case r := <-l.queue:
+			l.write(ctx, r)
+		case <-ctx.Done():
+			close(l.done)
+			drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+			for {
+				select {
+				case r := <-l.queue:
+					l.write(drainCtx, r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write appends a record to the store, waiting at most writeTimeout
+func (l *Logger) write(ctx context.Context, r models.AuditRecord) {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+	_, err := l.store.AppendAuditRecord(ctx, func(prev *models.AuditRecord) models.AuditRecord {
+		return Seal(prev, r)
+	})
+	if err != nil {
+		l.failures.Inc()
+		l.logger.WithError(err).WithFields(logrus.Fields{
+			"subject": r.Subject,
+			"action":  r.Action,
+			"code":    r.Code,
+		}).Error("Failed to write audit record")
+		return
+	}
+	l.records.Inc()
+}
+
+// AuditCall records a completed gRPC call of method with request req,
+// which failed with err if not nil. Calls the service's own clients make
+// on behalf of gateway callers are not recorded, as the gateway records
+// them with their callers.
+func (l *Logger) AuditCall(ctx context.Context, method string, req interface{}, err error) {
+	r := models.AuditRecord{
+		Source: SourceGRPC,
+		Action: method,
+		Code:   status.Code(err).String(),
+	}
+	if identity, ok := auth.FromContext(ctx); ok {
+		if identity.HasRole(auth.RoleInternal) {
+			return
+		}
+		r.Subject, r.Provider = identity.Subject, identity.Provider
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.Peer = p.Addr.String()
+	}
+	if msg, ok := req.(proto.Message); ok {
+		if encoded, err := protojson.Marshal(msg); err == nil {
+			r.Request = truncate(string(encoded))
+		}
+	}
+	l.Record(r)
+}
+
+// AuditRequest records a completed gateway request answered with the HTTP
+// status code.
+func (l *Logger) AuditRequest(req *http.Request, code int) {
+	r := models.AuditRecord{
+		Source: SourceHTTP,
+		Action: req.Method + " " + req.URL.Path,
+		Code:   strconv.Itoa(code),
+		Peer:   req.RemoteAddr,
+	}
+	if identity, ok := auth.FromContext(req.Context()); ok {
+		r.Subject, r.Provider = identity.Subject, identity.Provider
+	}
+	query := req.URL.Query()
+	query.Del("access_token")
+	r.Request = truncate(query.Encode())
+	l.Record(r)
+}
+
+// truncate caps a request summary at maxRequestLength bytes, without
+// splitting a character, since exports must reproduce it exactly
+func truncate(s string) string {
+	if len(s) <= maxRequestLength {
+		return s
+	}
+	n := maxRequestLength
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/auth"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// memoryStore keeps audit records in memory
+type memoryStore struct {
+	mu      sync.Mutex
+	records []models.AuditRecord
+	err     error
+}
+
+func (s *memoryStore) AppendAuditRecord(ctx context.Context, seal func(prev *models.AuditRecord) models.AuditRecord) (models.AuditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return models.AuditRecord{}, s.err
+	}
+	var prev *models.AuditRecord
+	if len(s.records) > 0 {
+		prev = &s.records[len(s.records)-1]
+	}
+	r := seal(prev)
+	s.records = append(s.records, r)
+	return r, nil
+}
+
+func (s *memoryStore) AuditRecords(ctx context.Context, after int64, limit int) ([]models.AuditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []models.AuditRecord
+	for _, r := range s.records {
+		if r.Seq > after && len(records) < limit {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *memoryStore) stored() []models.AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.AuditRecord(nil), s.records...)
+}
+
+// newChain returns a store holding n sealed records
+func newChain(t *testing.T, n int) *memoryStore {
+	store := &memoryStore{}
+	base := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		_, err := store.AppendAuditRecord(context.Background(), func(prev *models.AuditRecord) models.AuditRecord {
+			return Seal(prev, models.AuditRecord{
+				Time:    base.Add(time.Duration(i) * time.Second),
+				Subject: "dashboard",
+				Source:  SourceGRPC,
+				Action:  "/edgecom.TimeSeriesService/QueryTimeSeries",
+				Code:    "OK",
+			})
+		})
+		require.NoError(t, err)
+	}
+	return store
+}
+
+func TestVerify(t *testing.T) {
+	store := newChain(t, 5)
+	records := store.stored()
+
+	summary, err := VerifyStore(context.Background(), store, 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Records: 5, First: 1, Last: 5, Head: records[4].Hash}, summary)
+
+	t.Run("partial runs", func(t *testing.T) {
+		summary, err := VerifyStore(context.Background(), store, 2, records[1].Hash)
+		require.NoError(t, err)
+		assert.Equal(t, Summary{Records: 3, First: 3, Last: 5, Anchor: records[1].Hash, Head: records[4].Hash}, summary)
+
+		_, err = VerifyStore(context.Background(), store, 2, records[0].Hash)
+		assert.ErrorContains(t, err, "record 3 does not follow the anchor")
+	})
+
+	tampered := []struct {
+		name   string
+		tamper func([]models.AuditRecord) []models.AuditRecord
+		err    string
+	}{
+		{"modified", func(r []models.AuditRecord) []models.AuditRecord {
+			r[2].Subject = "someone else"
+			return r
+		}, "record 3 was modified"},
+		{"deleted", func(r []models.AuditRecord) []models.AuditRecord {
+			return append(r[:2], r[3:]...)
+		}, "record 4 follows record 2"},
+		{"reordered", func(r []models.AuditRecord) []models.AuditRecord {
+			r[1], r[2] = r[2], r[1]
+			return r
+		}, "record 3 follows record 1"},
+		{"rehashed", func(r []models.AuditRecord) []models.AuditRecord {
+			r[2].Code = "PermissionDenied"
+			r[2].Hash = Hash(r[2])
+			return r
+		}, "record 4 does not follow record 3"},
+		{"first record removed", func(r []models.AuditRecord) []models.AuditRecord {
+			r[1].Seq, r[1].PrevHash = 1, r[0].Hash
+			return r[1:]
+		}, "record 1 follows another record"},
+	}
+	for _, tt := range tampered {
+		t.Run(tt.name, func(t *testing.T) {
+			tamperedStore := &memoryStore{records: tt.tamper(store.stored())}
+			_, err := VerifyStore(context.Background(), tamperedStore, 0, "")
+			assert.True(t, errors.Is(err, ErrBrokenChain), "got %v", err)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	store := newChain(t, pageSize+2)
+	records := store.stored()
+
+	var buf bytes.Buffer
+	written, err := Export(context.Background(), store, 0, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(pageSize+2), written)
+
+	summary, err := VerifyExport(bytes.NewReader(buf.Bytes()), "")
+	require.NoError(t, err)
+	assert.Equal(t, records[len(records)-1].Hash, summary.Head)
+	assert.Equal(t, int64(pageSize+2), summary.Records)
+
+	edited := strings.Replace(buf.String(), `"subject":"dashboard"`, `"subject":"admin"`, 1)
+	_, err = VerifyExport(strings.NewReader(edited), "")
+	assert.ErrorContains(t, err, "line 1: audit chain broken: record 1 was modified")
+
+	buf.Reset()
+	_, err = Export(context.Background(), store, pageSize, &buf)
+	require.NoError(t, err)
+	summary, err = VerifyExport(&buf, records[pageSize-1].Hash)
+	require.NoError(t, err)
+	assert.Equal(t, int64(pageSize+1), summary.First)
+}
+
+func TestLogger(t *testing.T) {
+	store := &memoryStore{}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	auditLog, err := NewLogger(store, logger, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		auditLog.Run(ctx)
+		close(done)
+	}()
+
+	dashboard := auth.NewContext(context.Background(), &auth.Identity{Subject: "dashboard", Provider: auth.ProviderStatic})
+	internal := auth.NewContext(context.Background(), &auth.Identity{Subject: "edgecom", Roles: []string{auth.RoleInternal}})
+	req := &pb.StatisticsRequest{Start: timestamppb.New(time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC))}
+
+	auditLog.AuditCall(dashboard, "/edgecom.TimeSeriesService/GetStatistics", req, nil)
+	auditLog.AuditCall(internal, "/edgecom.TimeSeriesService/GetStatistics", req, nil)
+	auditLog.AuditCall(context.Background(), "/edgecom.TimeSeriesService/InsertTimeSeries", nil, status.Error(codes.PermissionDenied, "denied"))
+
+	httpReq := httptest.NewRequest("GET", "/v1/timeseries/live?window=1h&access_token=s3cret", nil)
+	auditLog.AuditRequest(httpReq.WithContext(dashboard), 101)
+
+	cancel()
+	<-done
+	records := store.stored()
+	require.Len(t, records, 3, "calls forwarded by the gateway are not recorded")
+
+	assert.Equal(t, "dashboard", records[0].Subject)
+	assert.Equal(t, auth.ProviderStatic, records[0].Provider)
+	assert.Equal(t, SourceGRPC, records[0].Source)
+	assert.Contains(t, records[0].Request, "2024-11-23T00:00:00Z")
+	assert.Equal(t, "OK", records[0].Code)
+
+	assert.Equal(t, "", records[1].Subject)
+	assert.Equal(t, "PermissionDenied", records[1].Code)
+
+	assert.Equal(t, SourceHTTP, records[2].Source)
+	assert.Equal(t, "GET /v1/timeseries/live", records[2].Action)
+	assert.Equal(t, "window=1h", records[2].Request, "access tokens are not recorded")
+	assert.Equal(t, "101", records[2].Code)
+
+	_, err = VerifyStore(context.Background(), store, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, testutil.ToFloat64(auditLog.records))
+
+	auditLog.AuditCall(dashboard, "/edgecom.TimeSeriesService/GetLatest", nil, nil)
+	assert.Equal(t, 1.0, testutil.ToFloat64(auditLog.failures), "records after Run returned are lost")
+}
+
+func TestLoggerWriteFailure(t *testing.T) {
+	store := &memoryStore{err: errors.New("database down")}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	auditLog, err := NewLogger(store, logger, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	auditLog.write(context.Background(), models.AuditRecord{Action: "/edgecom.TimeSeriesService/GetLatest"})
+	assert.Equal(t, 1.0, testutil.ToFloat64(auditLog.failures))
+	assert.Equal(t, 0.0, testutil.ToFloat64(auditLog.records))
+}
+
+func TestLoggerQueueFull(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	auditLog, err := NewLogger(&memoryStore{}, logger, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	// Nothing writes the queue, as Run is not started
+	for i := 0; i < queueSize+2; i++ {
+		auditLog.Record(models.AuditRecord{Action: "/edgecom.TimeSeriesService/GetLatest"})
+	}
+	assert.Equal(t, 2.0, testutil.ToFloat64(auditLog.dropped))
+	assert.Zero(t, testutil.ToFloat64(auditLog.failures))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short"))
+
+	long := strings.Repeat("a", maxRequestLength-1) + "€uro"
+	truncated := truncate(long)
+	assert.True(t, utf8.ValidString(truncated))
+	assert.Equal(t, strings.Repeat("a", maxRequestLength-1)+"...", truncated)
+}
@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// ErrBrokenChain is returned when audit records fail verification.
+var ErrBrokenChain = errors.New("audit chain broken")
+
+// Summary describes a verified run of audit records.
+type Summary struct {
+	// Records is the number of records verified
+	Records int64 `json:"records"`
+	// First and Last are the sequence numbers of the first and last
+	// records; both are zero when there were none
+	First int64 `json:"first"`
+	Last  int64 `json:"last"`
+	// Anchor is the hash the first record chains to, empty when it is the
+	// first record of the log
+	Anchor string `json:"anchor"`
+	// Head is the hash of the last record, which later verifications can
+	// be compared against
+	Head string `json:"head"`
+}
+
+// Verifier checks that audit records, added in order, form an intact
+// chain.
+type Verifier struct {
+	anchor  string
+	summary Summary
+}
+
+// NewVerifier creates a Verifier. The first record added must chain to
+// the record with hash anchor, or be the first record of the log when
+// anchor is empty and the first record's sequence number is 1. With an
+// empty anchor, a run starting later in the log is accepted as is, and
+// the hash it chains to is reported in its Summary.
+func NewVerifier(anchor string) *Verifier {
+	return &Verifier{anchor: anchor}
+}
+
+// Add verifies the next record, returning an error wrapping
+// ErrBrokenChain if it does not follow the previous one.
+func (v *Verifier) Add(r models.AuditRecord) error {
+	if v.summary.Records == 0 {
+		switch {
+		case v.anchor != "" && r.PrevHash != v.anchor:
+			return fmt.Errorf("%w: record %d does not follow the anchor", ErrBrokenChain, r.Seq)
+		case v.anchor == "" && r.Seq == 1 && r.PrevHash != "":
+			return fmt.Errorf("%w: record 1 follows another record", ErrBrokenChain)
+		case r.Seq < 1:
+			return fmt.Errorf("%w: invalid sequence number %d", ErrBrokenChain, r.Seq)
+		}
+		v.summary.First = r.Seq
+		v.summary.Anchor = r.PrevHash
+	} else {
+		if r.Seq != v.summary.Last+1 {
+			return fmt.Errorf("%w: record %d follows record %d", ErrBrokenChain, r.Seq, v.summary.Last)
+		}
+		if r.PrevHash != v.summary.Head {
+			return fmt.Errorf("%w: record %d does not follow record %d", ErrBrokenChain, r.Seq, v.summary.Last)
+		}
+	}
+	if Hash(r) != r.Hash {
+		return fmt.Errorf("%w: record %d was modified", ErrBrokenChain, r.Seq)
+	}
+
+	v.summary.Records++
+	v.summary.Last = r.Seq
+	v.summary.Head = r.Hash
+	return nil
+}
+
+// Summary describes the records verified so far.
+func (v *Verifier) Summary() Summary {
+	return v.summary
+}
+
+// Export writes the records of store after sequence number after to w as
+// newline-delimited JSON, in order, and returns the number written.
+// Records are exported as stored, whether or not they verify.
+func Export(ctx context.Context, store Store, after int64, w io.Writer) (int64, error) {
+	encoder := json.NewEncoder(w)
+	var written int64
+	for {
+		records, err := store.AuditRecords(ctx, after, pageSize)
+		if err != nil {
+			return written, err
+		}
+		for _, r := range records {
+			if err := encoder.Encode(r); err != nil {
+				return written, err
+			}
+			written++
+			after = r.Seq
+		}
+		if len(records) < pageSize {
+			return written, nil
+		}
+	}
+}
+
+// VerifyStore verifies the records of store after sequence number after,
+// which must chain to the record with hash anchor unless it is empty.
+func VerifyStore(ctx context.Context, store Store, after int64, anchor string) (Summary, error) {
+	v := NewVerifier(anchor)
+	for {
+		records, err := store.AuditRecords(ctx, after, pageSize)
+		if err != nil {
+			return v.Summary(), err
+		}
+		for _, r := range records {
+			if err := v.Add(r); err != nil {
+				return v.Summary(), err
+			}
+			after = r.Seq
+		}
+		if len(records) < pageSize {
+			return v.Summary(), nil
+		}
+	}
+}
+
+// VerifyExport verifies records exported by Export, which must chain to
+// the record with hash anchor unless it is empty.
+func VerifyExport(r io.Reader, anchor string) (Summary, error) {
+	v := NewVerifier(anchor)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record models.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return v.Summary(), fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := v.Add(record); err != nil {
+			return v.Summary(), fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return v.Summary(), scanner.Err()
+}
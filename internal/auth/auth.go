@@ -0,0 +1,165 @@
+// Package auth identifies the callers of the gRPC service and the HTTP
+// gateway through pluggable providers, so organizations can use the
+// identity systems they already run.
+//
+// Three providers are included:
+//   - StaticKeys accepts fixed API keys, sent as a bearer token or in the
+//     x-api-key header
+//   - JWT accepts bearer tokens signed by an OIDC identity provider, whose
+//     signing keys are fetched from its JWKS endpoint and refreshed
+//   - MTLS identifies callers by the verified TLS client certificate they
+//     present, optionally mapping certificate names to identities
+//
+// An Authenticator tries its providers in order. A provider that finds no
+// credentials of its kind passes the call to the next one, and the first
+// provider to identify the caller, or to reject its credentials, decides.
+//
+// A Policy then decides which methods an identified caller may call,
+// from the roles its provider granted it: those configured for its API
+// key or certificate subject, or the scopes and roles claims of its token.
+// The policy may bind further roles to subjects, and let roles include
+// others, such as admin including writer and writer including viewer.
+//
+// Example Usage:
+//
+//	static, err := auth.NewStaticKeys([]auth.StaticKey{
+//	    {Key: os.Getenv("DASHBOARD_API_KEY"), Subject: "dashboard"},
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	jwt, err := auth.NewJWT(auth.JWTConfig{
+//	    Issuer:   "https://login.example.com/",
+//	    Audience: "edgecom",
+//	}, logger)
+//	if err != nil {
+//	    return err
+//	}
+//	go jwt.Run(ctx)
+//
+//	authenticator := auth.NewAuthenticator(static, jwt)
+//	ctx, err = authenticator.Authenticate(ctx) // incoming gRPC context
+//	if err != nil {
+//	    return status.Error(codes.Unauthenticated, err.Error())
+//	}
+//	identity, _ := auth.FromContext(ctx)
+//
+//	policy, err := auth.NewPolicy(auth.PolicyConfig{
+//	    Methods: map[string][]string{"InsertTimeSeries": {"ingest"}},
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	if err := policy.Authorize(ctx, info.FullMethod); err != nil {
+//	    return status.Error(codes.PermissionDenied, err.Error())
+//	}
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Provider names, reported in identities
+const (
+	ProviderStatic = "static"
+	ProviderJWT    = "jwt"
+	ProviderMTLS   = "mtls"
+)
+
+// ErrNoCredentials is returned by a provider when a call carries no
+// credentials it handles, and by an Authenticator when no provider
+// identified the caller.
+var ErrNoCredentials = errors.New("missing or unrecognized credentials")
+
+// Identity is an authenticated caller.
+type Identity struct {
+	// Subject names the caller, such as an API key's configured subject,
+	// a token's sub claim or a certificate's common name
+	Subject string
+	// Provider is the name of the provider that identified the caller
+	Provider string
+	// Roles are the roles and scopes granted to the caller, which a
+	// Policy authorizes calls by
+	Roles []string
+}
+
+// HasRole reports whether the identity was granted role.
+func (i *Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Provider identifies callers from the credentials of a call.
+type Provider interface {
+	// Identify returns the caller of the call with the incoming gRPC
+	// context ctx. It returns ErrNoCredentials if the call carries no
+	// credentials the provider handles, and another error if it carries
+	// credentials that are not valid.
+	Identify(ctx context.Context) (*Identity, error)
+}
+
+// Secret is a credential that may change while the service runs, such as
+// a *secret.File kept current by a secret.Watcher
+type Secret interface {
+	Value() string
+}
+
+// Authenticator identifies callers with a list of providers.
+type Authenticator struct {
+	providers []Provider
+}
+
+// NewAuthenticator creates an Authenticator trying providers in order.
+func NewAuthenticator(providers ...Provider) *Authenticator {
+	return &Authenticator{providers: providers}
+}
+
+// Authenticate identifies the caller of the call with the incoming gRPC
+// context ctx, and returns ctx carrying its identity.
+func (a *Authenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	for _, provider := range a.providers {
+		identity, err := provider.Identify(ctx)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return ctx, err
+		}
+		return NewContext(ctx, identity), nil
+	}
+	return ctx, ErrNoCredentials
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying identity.
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity of an authenticated call.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(*Identity)
+	return identity, ok
+}
+
+// bearerToken returns the token of an "authorization: Bearer" header in
+// the incoming metadata of ctx
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		scheme, token, ok := strings.Cut(value, " ")
+		if ok && strings.EqualFold(scheme, "bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+type staticSecret string
+
+func (s staticSecret) Value() string { return string(s) }
+
+func incoming(kv ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+}
+
+func TestStaticKeys(t *testing.T) {
+	rotated := staticSecret("file-key")
+	keys, err := NewStaticKeys([]StaticKey{
+		{Key: "dashboard-key", Subject: "dashboard"},
+		{File: rotated, Subject: "ingest"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		subject string
+		err     error
+	}{
+		{"bearer token", incoming("authorization", "Bearer dashboard-key"), "dashboard", nil},
+		{"api key header", incoming("x-api-key", "file-key"), "ingest", nil},
+		{"lower case scheme", incoming("authorization", "bearer dashboard-key"), "dashboard", nil},
+		{"unknown key", incoming("authorization", "Bearer guess"), "", ErrNoCredentials},
+		{"no credentials", context.Background(), "", ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := keys.Identify(tt.ctx)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, &Identity{Subject: tt.subject, Provider: ProviderStatic}, identity)
+		})
+	}
+
+	_, err = NewStaticKeys([]StaticKey{{Key: "k"}})
+	assert.ErrorContains(t, err, "a subject is required")
+	_, err = NewStaticKeys([]StaticKey{{Key: "k", File: rotated, Subject: "s"}})
+	assert.ErrorContains(t, err, "exactly one of a key and a key file")
+}
+
+type providerFunc func(ctx context.Context) (*Identity, error)
+
+func (f providerFunc) Identify(ctx context.Context) (*Identity, error) { return f(ctx) }
+
+func TestAuthenticator(t *testing.T) {
+	none := providerFunc(func(context.Context) (*Identity, error) { return nil, ErrNoCredentials })
+	reject := providerFunc(func(context.Context) (*Identity, error) { return nil, errors.New("invalid token: expired") })
+	accept := providerFunc(func(context.Context) (*Identity, error) {
+		return &Identity{Subject: "meter-gw", Provider: ProviderMTLS}, nil
+	})
+
+	ctx, err := NewAuthenticator(none, accept, reject).Authenticate(context.Background())
+	require.NoError(t, err)
+	identity, ok := FromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "meter-gw", identity.Subject)
+
+	_, err = NewAuthenticator(none, reject, accept).Authenticate(context.Background())
+	assert.EqualError(t, err, "invalid token: expired", "invalid credentials are rejected by the provider that handles them")
+
+	_, err = NewAuthenticator(none).Authenticate(context.Background())
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+}
+
+func TestTokenCredentials(t *testing.T) {
+	md, err := TokenCredentials("s3cret").GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer s3cret"}, md)
+}
@@ -0,0 +1,481 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultJWKSRefresh is how often signing keys are fetched again when
+	// the JWT provider is not given a refresh interval
+	DefaultJWKSRefresh = 15 * time.Minute
+
+	// minJWKSRefresh limits the fetches triggered by tokens signed with
+	// unknown keys, so forged key IDs cannot flood the identity provider
+	minJWKSRefresh = time.Minute
+
+	// DefaultClockSkew is the clock skew between the identity provider and
+	// the service tolerated when checking token times, when the JWT
+	// provider is not given one
+	DefaultClockSkew = time.Minute
+)
+
+// signingHashes maps the supported JWS algorithms to their hashes. Only
+// asymmetric algorithms are accepted: the service never holds a key that
+// could sign tokens, and "none" is never valid.
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// ecdsaCurveSizes maps the ECDSA algorithms to the size of their curves
+var ecdsaCurveSizes = map[string]int{
+	"ES256": 256,
+	"ES384": 384,
+	"ES512": 521,
+}
+
+// JWTConfig configures the JWT provider.
+type JWTConfig struct {
+	// Issuer is the identity provider's issuer URL, which the iss claim
+	// of tokens must match
+	Issuer string
+	// Audience, when set, must be one of the aud claim's values
+	Audience string
+	// JWKSURL is where the signing keys are fetched from. When empty, it
+	// is discovered from the issuer's OpenID Connect configuration.
+	JWKSURL string
+	// SubjectClaim is the claim naming the caller, sub when empty
+	SubjectClaim string
+	// RolesClaim is the claim listing the caller's roles, a dotted path
+	// into nested claims such as realm_access.roles for Keycloak. The
+	// scopes of the scope or scp claim are always granted as roles too.
+	RolesClaim string
+	// RefreshInterval is how often the signing keys are fetched again,
+	// DefaultJWKSRefresh when zero
+	RefreshInterval time.Duration
+	// ClockSkew is tolerated when checking the exp, nbf and iat claims,
+	// DefaultClockSkew when zero
+	ClockSkew time.Duration
+}
+
+// signingKey is a key of the key set with the algorithm it is restricted
+// to, if any
+type signingKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// JWT identifies callers by bearer tokens signed with RS256, RS384,
+// RS512, ES256, ES384 or ES512 by an OIDC identity provider.
+//
+// The JWKS URL is discovered from the issuer's OpenID Connect
+// configuration once, checking that the configuration is the issuer's.
+// Signing keys are cached, and fetched from the JWKS endpoint on first
+// use, every RefreshInterval while Run runs, and when a token names a key
+// that is not known yet, so rotated keys are picked up as soon as tokens
+// signed with them arrive. Fetches triggered by unknown keys, successful
+// or not, happen at most once a minute, and a failed fetch keeps the keys
+// cached before.
+type JWT struct {
+	cfg    JWTConfig
+	client *http.Client
+	logger *logrus.Logger
+	now    func() time.Time
+
+	// fetchMu serializes fetches of the key set
+	fetchMu sync.Mutex
+
+	mu      sync.RWMutex
+	jwksURL string
+	keys    map[string]signingKey
+	// attempted is when the key set was last fetched or failed to be
+	attempted time.Time
+}
+
+// NewJWT creates a JWT provider. The signing keys are not fetched until
+// the first token arrives or Refresh is called.
+func NewJWT(cfg JWTConfig, logger *logrus.Logger) (*JWT, error) {
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("an issuer is required")
+	}
+	if cfg.JWKSURL != "" {
+		if u, err := url.Parse(cfg.JWKSURL); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("invalid JWKS URL %q", cfg.JWKSURL)
+		}
+	} else if u, err := url.Parse(cfg.Issuer); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("a JWKS URL is required unless the issuer %q is a URL", cfg.Issuer)
+	}
+	if cfg.RefreshInterval < 0 {
+		return nil, fmt.Errorf("refresh interval must not be negative")
+	}
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = DefaultJWKSRefresh
+	}
+	if cfg.ClockSkew < 0 {
+		return nil, fmt.Errorf("clock skew must not be negative")
+	}
+	if cfg.ClockSkew == 0 {
+		cfg.ClockSkew = DefaultClockSkew
+	}
+	if cfg.SubjectClaim == "" {
+		cfg.SubjectClaim = "sub"
+	}
+	return &JWT{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		now:     time.Now,
+		jwksURL: cfg.JWKSURL,
+	}, nil
+}
+
+// Run refreshes the signing keys every refresh interval until ctx is
+// cancelled. A failed refresh keeps the keys fetched before.
+func (j *JWT) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.Refresh(ctx); err != nil {
+				j.logger.WithError(err).Warn("Failed to refresh JWT signing keys")
+			}
+		}
+	}
+}
+
+// Refresh fetches the signing keys, discovering the JWKS URL first if it
+// is not configured.
+func (j *JWT) Refresh(ctx context.Context) error {
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+	return j.fetch(ctx)
+}
+
+// fetch fetches the key set. The caller holds fetchMu.
+func (j *JWT) fetch(ctx context.Context) error {
+	j.mu.Lock()
+	jwksURL := j.jwksURL
+	j.attempted = j.now()
+	j.mu.Unlock()
+
+	if jwksURL == "" {
+		var err error
+		if jwksURL, err = j.discover(ctx); err != nil {
+			return fmt.Errorf("failed to discover the JWKS URL: %w", err)
+		}
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := j.getJSON(ctx, jwksURL, &set); err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := make(map[string]signingKey, len(set.Keys))
+	for _, k := range set.Keys {
+		// Keys of other types or for encryption are not for us
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			j.logger.WithError(err).WithField("kid", k.Kid).Warn("Skipping invalid JWT signing key")
+			continue
+		}
+		if key != nil {
+			keys[k.Kid] = signingKey{key: key, alg: k.Alg}
+		}
+	}
+	if len(keys) == 0 {
+		// Keep the cached keys rather than rejecting every token
+		return fmt.Errorf("no usable signing keys at %s", jwksURL)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jwksURL = jwksURL
+	j.keys = keys
+	return nil
+}
+
+// discover returns the JWKS URL of the issuer's OpenID Connect
+// configuration. The configuration must name the configured issuer, so a
+// misconfigured issuer URL fails here rather than on every token.
+func (j *JWT) discover(ctx context.Context) (string, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	configURL := strings.TrimSuffix(j.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := j.getJSON(ctx, configURL, &discovery); err != nil {
+		return "", err
+	}
+	if discovery.Issuer != j.cfg.Issuer {
+		return "", fmt.Errorf("%s is for issuer %q, expected %q", configURL, discovery.Issuer, j.cfg.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("%s has no jwks_uri", configURL)
+	}
+	return discovery.JWKSURI, nil
+}
+
+// getJSON decodes the JSON document at u into v
+func (j *JWT) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// key returns the signing key with the key ID, fetching the key set if
+// the key is not known and no fetch was attempted recently
+func (j *JWT) key(ctx context.Context, kid string) (signingKey, error) {
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+	// Another call may have fetched the key while this one waited
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	j.mu.RLock()
+	attempted := j.attempted
+	j.mu.RUnlock()
+	if !attempted.IsZero() && j.now().Sub(attempted) < minJWKSRefresh {
+		return signingKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := j.fetch(ctx); err != nil {
+		return signingKey{}, err
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	return signingKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup returns a known signing key. Tokens without a key ID match the
+// only key of a set that has one.
+func (j *JWT) lookup(kid string) (signingKey, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if key, ok := j.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+// Identify implements Provider. Bearer tokens that are not JWTs are left
+// to other providers.
+func (j *JWT) Identify(ctx context.Context) (*Identity, error) {
+	token := bearerToken(ctx)
+	if token == "" || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+	claims, err := j.verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	subject, _ := claims[j.cfg.SubjectClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("invalid token: no %s claim", j.cfg.SubjectClaim)
+	}
+	return &Identity{Subject: subject, Provider: ProviderJWT, Roles: j.roles(claims)}, nil
+}
+
+// roles returns the scopes and the roles claimed by a token
+func (j *JWT) roles(claims map[string]interface{}) []string {
+	roles := claimStrings(claims["scope"])
+	roles = append(roles, claimStrings(claims["scp"])...)
+	if j.cfg.RolesClaim != "" {
+		var value interface{} = claims
+		for _, name := range strings.Split(j.cfg.RolesClaim, ".") {
+			object, _ := value.(map[string]interface{})
+			value = object[name]
+		}
+		roles = append(roles, claimStrings(value)...)
+	}
+	return roles
+}
+
+// claimStrings returns the strings of a claim that is a space-separated
+// string or an array of strings
+func claimStrings(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []interface{}:
+		values := make([]string, 0, len(claim))
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// verify checks the signature and the registered claims of a token and
+// returns its claims
+func (j *JWT) verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	hash, ok := signingHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	key, err := j.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return nil, fmt.Errorf("signing key %q is for %s, not %s", header.Kid, key.alg, header.Alg)
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key.key, h.Sum(nil), signature, hash); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	if err := j.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims checks the issuer, audience and validity period of a
+// token, tolerating the configured clock skew
+func (j *JWT) checkClaims(claims map[string]interface{}) error {
+	if iss, _ := claims["iss"].(string); iss != j.cfg.Issuer {
+		return fmt.Errorf("issued by %q, expected %q", iss, j.cfg.Issuer)
+	}
+	if j.cfg.Audience != "" && !hasAudience(claims["aud"], j.cfg.Audience) {
+		return fmt.Errorf("not issued for audience %q", j.cfg.Audience)
+	}
+
+	now, skew := j.now(), j.cfg.ClockSkew
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(skew)) {
+		return fmt.Errorf("expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(skew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("not valid yet")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(skew).Before(time.Unix(int64(iat), 0)) {
+		return fmt.Errorf("issued in the future")
+	}
+	return nil
+}
+
+// hasAudience reports whether an aud claim, a string or an array of
+// strings, contains audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature checks a JWS signature over digest
+func verifySignature(alg string, key crypto.PublicKey, digest, signature []byte, hash crypto.Hash) error {
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not an RSA key for %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, signature); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+	default:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != ecdsaCurveSizes[alg] {
+			return fmt.Errorf("key is not an EC key for %s", alg)
+		}
+		// The signature is R and S, each padded to the curve size
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("bad signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
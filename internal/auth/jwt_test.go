@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// identityProvider serves an OpenID configuration and a key set
+type identityProvider struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	issuer  string
+	keys    []map[string]string
+	fetches int
+	down    bool
+}
+
+func newIdentityProvider(t *testing.T) *identityProvider {
+	idp := &identityProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.issuer, "jwks_uri": idp.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.fetches++
+		if idp.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": idp.keys})
+	})
+	idp.Server = httptest.NewServer(mux)
+	idp.issuer = idp.URL
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *identityProvider) addRSA(kid string, key *rsa.PublicKey) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keys = append(idp.keys, map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+		"n": b64(key.N.Bytes()),
+		"e": b64(big.NewInt(int64(key.E)).Bytes()),
+	})
+}
+
+func (idp *identityProvider) addEC(kid string, key *ecdsa.PublicKey) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keys = append(idp.keys, map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))),
+		"y": b64(key.Y.FillBytes(make([]byte, 32))),
+	})
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a token with the header and claims, signed with key
+func sign(t *testing.T, header, claims map[string]interface{}, key crypto.Signer) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := newIdentityProvider(t)
+	idp.addRSA("rsa-1", &rsaKey.PublicKey)
+	idp.addEC("ec-1", &ecKey.PublicKey)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	provider, err := NewJWT(JWTConfig{Issuer: idp.URL, Audience: "edgecom"}, logger)
+	require.NoError(t, err)
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	provider.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": idp.URL,
+			"aud": []string{"edgecom", "other"},
+			"sub": "analyst@example.com",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}
+
+	identify := func(token string) (*Identity, error) {
+		return provider.Identify(incoming("authorization", "Bearer "+token))
+	}
+
+	t.Run("valid tokens", func(t *testing.T) {
+		identity, err := identify(sign(t, rs256, claims(nil), rsaKey))
+		require.NoError(t, err)
+		assert.Equal(t, &Identity{Subject: "analyst@example.com", Provider: ProviderJWT}, identity)
+
+		identity, err = identify(sign(t, map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, claims(map[string]interface{}{"aud": "edgecom"}), ecKey))
+		require.NoError(t, err)
+		assert.Equal(t, "analyst@example.com", identity.Subject)
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		keycloak, err := NewJWT(JWTConfig{Issuer: idp.URL, Audience: "edgecom", RolesClaim: "realm_access.roles"}, logger)
+		require.NoError(t, err)
+		keycloak.now = provider.now
+
+		token := sign(t, rs256, claims(map[string]interface{}{
+			"scope":        "timeseries:read openid",
+			"scp":          []string{"timeseries:export"},
+			"realm_access": map[string]interface{}{"roles": []string{"operator"}},
+		}), rsaKey)
+		identity, err := keycloak.Identify(incoming("authorization", "Bearer "+token))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"timeseries:read", "openid", "timeseries:export", "operator"}, identity.Roles)
+
+		identity, err = identify(token)
+		require.NoError(t, err)
+		assert.False(t, identity.HasRole("operator"), "roles claims are only read when configured")
+	})
+
+	invalid := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"expired", sign(t, rs256, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}), rsaKey), "expired"},
+		{"not valid yet", sign(t, rs256, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}), rsaKey), "not valid yet"},
+		{"issued in the future", sign(t, rs256, claims(map[string]interface{}{"iat": now.Add(time.Hour).Unix()}), rsaKey), "issued in the future"},
+		{"key for another algorithm", sign(t, map[string]interface{}{"alg": "RS512", "kid": "rsa-1"}, claims(nil), rsaKey), "is for RS256"},
+		{"other issuer", sign(t, rs256, claims(map[string]interface{}{"iss": "https://evil.example.com"}), rsaKey), "issued by"},
+		{"other audience", sign(t, rs256, claims(map[string]interface{}{"aud": "billing"}), rsaKey), "audience"},
+		{"no subject", sign(t, rs256, claims(map[string]interface{}{"sub": ""}), rsaKey), "no sub claim"},
+		{"signed with another key", sign(t, rs256, claims(nil), rotatedKey), "bad signature"},
+		{"key of the wrong type", sign(t, map[string]interface{}{"alg": "RS256", "kid": "ec-1"}, claims(nil), rsaKey), "not an RSA key"},
+		{"alg none", sign(t, map[string]interface{}{"alg": "none"}, claims(nil), rsaKey), "unsupported algorithm"},
+		{"symmetric alg", sign(t, map[string]interface{}{"alg": "HS256", "kid": "rsa-1"}, claims(nil), rsaKey), "unsupported algorithm"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := identify(tt.token)
+			require.Error(t, err)
+			assert.False(t, errors.Is(err, ErrNoCredentials))
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+
+	t.Run("clock skew", func(t *testing.T) {
+		_, err := identify(sign(t, rs256, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}), rsaKey))
+		assert.NoError(t, err, "tokens are accepted for a minute after expiry by default")
+
+		strict, err := NewJWT(JWTConfig{Issuer: idp.URL, ClockSkew: time.Second}, logger)
+		require.NoError(t, err)
+		strict.now = provider.now
+		_, err = strict.Identify(incoming("authorization", "Bearer "+sign(t, rs256, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}), rsaKey)))
+		assert.ErrorContains(t, err, "expired")
+	})
+
+	t.Run("not a JWT", func(t *testing.T) {
+		_, err := identify("static-api-key")
+		assert.True(t, errors.Is(err, ErrNoCredentials))
+	})
+
+	t.Run("rotated key", func(t *testing.T) {
+		fetches := idp.fetches
+		idp.addRSA("rsa-2", &rotatedKey.PublicKey)
+		token := sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-2"}, claims(nil), rotatedKey)
+
+		_, err := identify(token)
+		assert.ErrorContains(t, err, "unknown signing key", "keys are not fetched again within a minute")
+
+		now = now.Add(2 * time.Minute)
+		identity, err := identify(token)
+		require.NoError(t, err)
+		assert.Equal(t, "analyst@example.com", identity.Subject)
+		assert.Equal(t, fetches+1, idp.fetches)
+	})
+}
+
+func TestJWTDiscovery(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp := newIdentityProvider(t)
+	idp.addRSA("rsa-1", &rsaKey.PublicKey)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	t.Run("issuer mismatch", func(t *testing.T) {
+		provider, err := NewJWT(JWTConfig{Issuer: idp.URL + "/"}, logger)
+		require.NoError(t, err)
+		assert.ErrorContains(t, provider.Refresh(context.Background()), "is for issuer")
+	})
+
+	t.Run("failed fetches keep the cached keys", func(t *testing.T) {
+		provider, err := NewJWT(JWTConfig{Issuer: idp.URL}, logger)
+		require.NoError(t, err)
+		now := time.Now()
+		provider.now = func() time.Time { return now }
+		require.NoError(t, provider.Refresh(context.Background()))
+
+		idp.mu.Lock()
+		idp.down = true
+		fetches := idp.fetches
+		idp.mu.Unlock()
+		assert.Error(t, provider.Refresh(context.Background()))
+
+		token := sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, map[string]interface{}{
+			"iss": idp.URL, "sub": "analyst", "exp": now.Add(time.Hour).Unix(),
+		}, rsaKey)
+		_, err = provider.Identify(incoming("authorization", "Bearer "+token))
+		assert.NoError(t, err)
+
+		// Unknown keys do not retry a failing identity provider
+		now = now.Add(30 * time.Second)
+		other := sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-9"}, map[string]interface{}{
+			"iss": idp.URL, "sub": "analyst", "exp": now.Add(time.Hour).Unix(),
+		}, rsaKey)
+		_, err = provider.Identify(incoming("authorization", "Bearer "+other))
+		assert.ErrorContains(t, err, "unknown signing key")
+		assert.Equal(t, fetches+1, idp.fetches)
+	})
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Certificate fields the MTLS provider can identify callers by
+const (
+	FieldCommonName = "cn"
+	FieldDNS        = "dns"
+	FieldURI        = "uri"
+	FieldEmail      = "email"
+)
+
+// MTLSConfig configures the MTLS provider.
+type MTLSConfig struct {
+	// Field is the certificate field naming the caller: cn, the subject
+	// common name, or dns, uri or email, the first subject alternative
+	// name of that type. Defaults to cn.
+	Field string
+	// Identities maps certificate names to subjects. When set, only the
+	// certificates it names are accepted; otherwise the certificate name
+	// is the subject.
+	Identities map[string]string
+	// Roles maps subjects to the roles granted to them
+	Roles map[string][]string
+}
+
+// MTLS identifies callers by the TLS client certificate they presented,
+// which the server has verified against its client CAs.
+type MTLS struct {
+	field      string
+	identities map[string]string
+	roles      map[string][]string
+}
+
+// NewMTLS creates an MTLS provider.
+func NewMTLS(cfg MTLSConfig) (*MTLS, error) {
+	field := cfg.Field
+	if field == "" {
+		field = FieldCommonName
+	}
+	switch field {
+	case FieldCommonName, FieldDNS, FieldURI, FieldEmail:
+	default:
+		return nil, fmt.Errorf("unsupported certificate field %q, expected %q, %q, %q or %q",
+			field, FieldCommonName, FieldDNS, FieldURI, FieldEmail)
+	}
+	return &MTLS{field: field, identities: cfg.Identities, roles: cfg.Roles}, nil
+}
+
+// Identify implements Provider. Calls without a verified client
+// certificate are left to other providers.
+func (m *MTLS) Identify(ctx context.Context) (*Identity, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+	name := certificateName(cert, m.field)
+	if name == "" {
+		return nil, fmt.Errorf("client certificate has no %s name", m.field)
+	}
+	subject := name
+	if m.identities != nil {
+		if subject, ok = m.identities[name]; !ok {
+			return nil, fmt.Errorf("client certificate %q is not mapped to an identity", name)
+		}
+	}
+	return &Identity{Subject: subject, Provider: ProviderMTLS, Roles: m.roles[subject]}, nil
+}
+
+// certificateName returns the name of a certificate in field
+func certificateName(cert *x509.Certificate, field string) string {
+	switch field {
+	case FieldDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case FieldURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case FieldEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func withClientCert(cert *x509.Certificate) context.Context {
+	state := tls.ConnectionState{}
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+func TestMTLS(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/meter-gw-01")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "meter-gw-01"},
+		DNSNames: []string{"meter-gw-01.site.example.com"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	tests := []struct {
+		name    string
+		cfg     MTLSConfig
+		subject string
+		err     string
+	}{
+		{"common name", MTLSConfig{}, "meter-gw-01", ""},
+		{"dns name", MTLSConfig{Field: FieldDNS}, "meter-gw-01.site.example.com", ""},
+		{"uri", MTLSConfig{Field: FieldURI}, "spiffe://example.com/meter-gw-01", ""},
+		{"mapped", MTLSConfig{Identities: map[string]string{"meter-gw-01": "ingest"}}, "ingest", ""},
+		{"not mapped", MTLSConfig{Identities: map[string]string{"meter-gw-02": "ingest"}}, "", "not mapped to an identity"},
+		{"missing field", MTLSConfig{Field: FieldEmail}, "", "has no email name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := NewMTLS(tt.cfg)
+			require.NoError(t, err)
+			identity, err := provider.Identify(withClientCert(cert))
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, &Identity{Subject: tt.subject, Provider: ProviderMTLS}, identity)
+		})
+	}
+
+	provider, err := NewMTLS(MTLSConfig{})
+	require.NoError(t, err)
+	_, err = provider.Identify(withClientCert(nil))
+	assert.True(t, errors.Is(err, ErrNoCredentials), "connections without a client certificate are left to other providers")
+	_, err = provider.Identify(context.Background())
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+
+	_, err = NewMTLS(MTLSConfig{Field: "serial"})
+	assert.ErrorContains(t, err, "unsupported certificate field")
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Special roles
+const (
+	// RoleInternal is granted to the service's own clients, which a
+	// Policy allows to call every method. Callers the gateway has
+	// authorized reach the service through such a client.
+	RoleInternal = "edgecom:internal"
+	// AnyRole, among the roles of a method, allows any authenticated
+	// caller to call it
+	AnyRole = "*"
+)
+
+// ErrPermissionDenied is returned by Policy.Authorize when the caller may
+// not call a method.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// PolicyConfig configures a Policy.
+type PolicyConfig struct {
+	// Methods maps methods to the roles allowed to call them; a caller
+	// needs one of them. Keys are full method names such as
+	// /edgecom.TimeSeriesService/InsertTimeSeries, method names such as
+	// InsertTimeSeries matching the method of any service, or services
+	// such as /edgecom.TimeSeriesService/* matching all their methods.
+	// Full method names take precedence over method names, and method
+	// names over services.
+	Methods map[string][]string
+	// Default lists the roles allowed to call methods Methods does not
+	// match. Any authenticated caller may call them when Default is nil,
+	// and nobody when it is empty but not nil.
+	Default []string
+	// Bindings grants roles to callers by subject, on top of those their
+	// provider granted them. Keys are provider:subject pairs such as
+	// jwt:alice, matching only callers that provider identified, or bare
+	// subjects, matching callers identified by any provider. Bare subjects
+	// are only accepted when Providers names a single provider, as the
+	// same subject may otherwise name different callers.
+	Bindings map[string][]string
+	// Providers names the providers configured, such as ProviderJWT. When
+	// set, bindings must name one of them.
+	Providers []string
+	// Inherits maps roles to the roles they include, so that for
+	// example admin callers may call every method writer and viewer
+	// callers may. Inclusion is transitive.
+	Inherits map[string][]string
+	// Restricted lists services, such as /edgecom.AdminService/*, whose
+	// methods may only be called by the roles Methods lists for them:
+	// Default does not apply to them, so they are denied until granted.
+	Restricted []string
+	// Explicit lists full method names, such as
+	// /edgecom.AdminService/SetLimits, that may only be called by the
+	// roles Methods lists for them by full or method name. Service
+	// entries and Default do not apply to them, and they cannot be
+	// allowed to any caller.
+	Explicit []string
+	// Known lists the full names of the methods served. When set, every
+	// key of Methods must match one of them, so misspelled methods are
+	// reported rather than silently falling back to Default.
+	Known []string
+}
+
+// Policy decides which authenticated callers may call which methods,
+// based on the roles of their identities.
+type Policy struct {
+	methods  map[string][]string
+	names    map[string][]string
+	services map[string][]string
+	fallback []string
+	// restricted holds the service prefixes Default does not apply to
+	restricted map[string]bool
+	// explicit holds the methods only their own entries apply to
+	explicit map[string]bool
+	// bindings maps providers to the roles bound to their subjects; the
+	// empty provider holds bindings matching any provider
+	bindings map[string]map[string][]string
+	inherits map[string][]string
+}
+
+// NewPolicy creates a Policy.
+func NewPolicy(cfg PolicyConfig) (*Policy, error) {
+	p := &Policy{
+		methods:    make(map[string][]string),
+		names:      make(map[string][]string),
+		services:   make(map[string][]string),
+		fallback:   cfg.Default,
+		restricted: make(map[string]bool),
+		explicit:   make(map[string]bool),
+		bindings:   make(map[string]map[string][]string),
+		inherits:   cfg.Inherits,
+	}
+	if p.fallback == nil {
+		p.fallback = []string{AnyRole}
+	}
+
+	for key, roles := range cfg.Methods {
+		if roles == nil {
+			roles = []string{}
+		}
+		switch {
+		case strings.HasPrefix(key, "/") && strings.HasSuffix(key, "/*"):
+			p.services[strings.TrimSuffix(key, "*")] = roles
+		case strings.HasPrefix(key, "/"):
+			if strings.Count(key, "/") != 2 || strings.HasSuffix(key, "/") {
+				return nil, fmt.Errorf("invalid method %q", key)
+			}
+			p.methods[key] = roles
+		case key != "" && !strings.Contains(key, "/"):
+			p.names[key] = roles
+		default:
+			return nil, fmt.Errorf("invalid method %q", key)
+		}
+		if len(cfg.Known) > 0 && !matchesAny(key, cfg.Known) {
+			return nil, fmt.Errorf("method %q matches no method served", key)
+		}
+	}
+
+	for _, service := range cfg.Restricted {
+		if !strings.HasPrefix(service, "/") || !strings.HasSuffix(service, "/*") || strings.Count(service, "/") != 2 {
+			return nil, fmt.Errorf("invalid restricted service %q", service)
+		}
+		p.restricted[strings.TrimSuffix(service, "*")] = true
+	}
+
+	for _, method := range cfg.Explicit {
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 || strings.HasSuffix(method, "/") {
+			return nil, fmt.Errorf("invalid explicit method %q", method)
+		}
+		p.explicit[method] = true
+		for _, role := range p.Roles(method) {
+			if role == AnyRole {
+				return nil, fmt.Errorf("%s must be granted to roles, not to %q", method, AnyRole)
+			}
+		}
+	}
+
+	providers := make(map[string]bool, len(cfg.Providers))
+	for _, provider := range cfg.Providers {
+		providers[provider] = true
+	}
+	for key, roles := range cfg.Bindings {
+		provider, subject := splitBinding(key)
+		switch {
+		case subject == "":
+			return nil, fmt.Errorf("invalid binding subject %q", key)
+		case provider == "" && len(providers) > 1:
+			return nil, fmt.Errorf("binding %q must name its provider, such as %s:%s, as several providers are configured",
+				key, ProviderJWT, key)
+		case provider != "" && len(providers) > 0 && !providers[provider]:
+			return nil, fmt.Errorf("binding %q names provider %s, which is not configured", key, provider)
+		}
+		if err := checkGrantable(roles); err != nil {
+			return nil, fmt.Errorf("binding %q: %w", key, err)
+		}
+		if p.bindings[provider] == nil {
+			p.bindings[provider] = make(map[string][]string)
+		}
+		p.bindings[provider][subject] = roles
+	}
+	for role, included := range cfg.Inherits {
+		if err := checkGrantable(append([]string{role}, included...)); err != nil {
+			return nil, fmt.Errorf("inherits: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// splitBinding splits a Bindings key into its provider, empty for bare
+// subjects, and its subject. Subjects may contain colons themselves, as
+// URIs do, so only a known provider name is taken as a prefix.
+func splitBinding(key string) (provider, subject string) {
+	prefix, rest, ok := strings.Cut(key, ":")
+	switch {
+	case ok && (prefix == ProviderStatic || prefix == ProviderJWT || prefix == ProviderMTLS):
+		return prefix, rest
+	case strings.HasSuffix(key, ":"):
+		return "", ""
+	}
+	return "", key
+}
+
+// checkGrantable returns an error if roles include a special role, which
+// cannot be granted by configuration
+func checkGrantable(roles []string) error {
+	for _, role := range roles {
+		switch role {
+		case "", AnyRole, RoleInternal:
+			return fmt.Errorf("role %q cannot be granted", role)
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether a Methods key matches one of the full method
+// names in known
+func matchesAny(key string, known []string) bool {
+	for _, method := range known {
+		service, name := splitMethod(method)
+		if key == method || key == name || key == service+"*" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitMethod splits a full method name into its service prefix, with a
+// trailing slash, and its method name
+func splitMethod(method string) (service, name string) {
+	i := strings.LastIndex(method, "/")
+	return method[:i+1], method[i+1:]
+}
+
+// Roles returns the roles allowed to call a method, by its full name. It
+// is empty for methods of restricted services that Methods does not list,
+// and for explicit methods it does not list by name.
+func (p *Policy) Roles(method string) []string {
+	if roles, ok := p.methods[method]; ok {
+		return roles
+	}
+	service, name := splitMethod(method)
+	if roles, ok := p.names[name]; ok {
+		return roles
+	}
+	if p.explicit[method] {
+		return []string{}
+	}
+	if roles, ok := p.services[service]; ok {
+		return roles
+	}
+	if p.restricted[service] {
+		return []string{}
+	}
+	return p.fallback
+}
+
+// granted returns the roles of identity: those its provider granted it,
+// those bound to its subject and those they include
+func (p *Policy) granted(identity *Identity) map[string]bool {
+	granted := make(map[string]bool)
+	var grant func(roles []string)
+	grant = func(roles []string) {
+		for _, role := range roles {
+			if !granted[role] {
+				granted[role] = true
+				grant(p.inherits[role])
+			}
+		}
+	}
+	grant(identity.Roles)
+	grant(p.bindings[""][identity.Subject])
+	if identity.Provider != "" {
+		grant(p.bindings[identity.Provider][identity.Subject])
+	}
+	return granted
+}
+
+// Authorize returns nil if the caller identified in ctx may call the
+// method with the full name method, and an error wrapping
+// ErrPermissionDenied otherwise.
+func (p *Policy) Authorize(ctx context.Context, method string) error {
+	identity, ok := FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%w: the caller is not authenticated", ErrPermissionDenied)
+	}
+	if identity.HasRole(RoleInternal) {
+		return nil
+	}
+
+	allowed := p.Roles(method)
+	granted := p.granted(identity)
+	for _, role := range allowed {
+		if role == AnyRole || granted[role] {
+			return nil
+		}
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("%w: %s may not be called", ErrPermissionDenied, method)
+	}
+	sorted := append([]string(nil), allowed...)
+	sort.Strings(sorted)
+	return fmt.Errorf("%w: %s requires one of the roles %s, which %s does not have",
+		ErrPermissionDenied, method, strings.Join(sorted, ", "), identity.Subject)
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	const (
+		query  = "/edgecom.TimeSeriesService/QueryTimeSeries"
+		insert = "/edgecom.TimeSeriesService/InsertTimeSeries"
+		ingest = "/edgecom.TimeSeriesService/IngestTimeSeries"
+		budget = "/edgecom.TimeSeriesService/GetBudgetStatus"
+	)
+	known := []string{query, insert, ingest, budget}
+
+	policy, err := NewPolicy(PolicyConfig{
+		Methods: map[string][]string{
+			"/edgecom.TimeSeriesService/*": {"read"},
+			"InsertTimeSeries":             {"ingest"},
+			ingest:                         {"ingest", "admin"},
+			"GetBudgetStatus":              {AnyRole},
+		},
+		Known: known,
+	})
+	require.NoError(t, err)
+
+	caller := func(roles ...string) context.Context {
+		return NewContext(context.Background(), &Identity{Subject: "caller", Roles: roles})
+	}
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		method  string
+		allowed bool
+	}{
+		{"service roles", caller("read"), query, true},
+		{"service roles without role", caller("ingest"), query, false},
+		{"method name", caller("ingest"), insert, true},
+		{"method name over service", caller("read"), insert, false},
+		{"full method name", caller("admin"), ingest, true},
+		{"any role", caller(), budget, true},
+		{"internal callers", caller(RoleInternal), insert, true},
+		{"not authenticated", context.Background(), budget, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Authorize(tt.ctx, tt.method)
+			if tt.allowed {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, ErrPermissionDenied), "got %v", err)
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		open, err := NewPolicy(PolicyConfig{})
+		require.NoError(t, err)
+		assert.NoError(t, open.Authorize(caller(), insert), "any authenticated caller by default")
+
+		closed, err := NewPolicy(PolicyConfig{Default: []string{}})
+		require.NoError(t, err)
+		assert.ErrorContains(t, closed.Authorize(caller("read"), insert), "may not be called")
+
+		admins, err := NewPolicy(PolicyConfig{Default: []string{"admin"}})
+		require.NoError(t, err)
+		assert.ErrorContains(t, admins.Authorize(caller("read"), insert), "requires one of the roles admin")
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		roles, err := NewPolicy(PolicyConfig{
+			Methods: map[string][]string{
+				"QueryTimeSeries":  {"viewer"},
+				"InsertTimeSeries": {"writer"},
+			},
+			Default: []string{"admin"},
+			Bindings: map[string][]string{
+				"dashboard":    {"viewer"},
+				"jwt:ops-team": {"admin"},
+			},
+			Inherits: map[string][]string{
+				"admin":  {"writer"},
+				"writer": {"viewer"},
+			},
+		})
+		require.NoError(t, err)
+
+		identity := func(subject, provider string, roles ...string) context.Context {
+			return NewContext(context.Background(), &Identity{Subject: subject, Provider: provider, Roles: roles})
+		}
+		assert.NoError(t, roles.Authorize(identity("dashboard", ProviderStatic), query), "bound roles")
+		assert.Error(t, roles.Authorize(identity("dashboard", ProviderStatic), insert))
+		assert.NoError(t, roles.Authorize(identity("meter", ProviderMTLS, "writer"), query), "included roles")
+		assert.NoError(t, roles.Authorize(identity("ops-team", ProviderJWT), budget), "roles bound by provider")
+		assert.NoError(t, roles.Authorize(identity("ops-team", ProviderJWT), query), "roles included transitively")
+		assert.Error(t, roles.Authorize(identity("ops-team", ProviderStatic), query), "bindings of other providers")
+		assert.Error(t, roles.Authorize(identity("jwt:ops-team", ProviderStatic), query), "subjects naming another provider")
+	})
+
+	t.Run("bindings with several providers", func(t *testing.T) {
+		providers := []string{ProviderStatic, ProviderJWT}
+		_, err := NewPolicy(PolicyConfig{Bindings: map[string][]string{"alice": {"admin"}}, Providers: providers})
+		assert.ErrorContains(t, err, "must name its provider")
+		_, err = NewPolicy(PolicyConfig{Bindings: map[string][]string{"mtls:alice": {"admin"}}, Providers: providers})
+		assert.ErrorContains(t, err, "not configured")
+
+		policy, err := NewPolicy(PolicyConfig{
+			Default:   []string{"admin"},
+			Bindings:  map[string][]string{"jwt:alice": {"admin"}, "static:spiffe://edge/meter": {"admin"}},
+			Providers: providers,
+		})
+		require.NoError(t, err)
+		alice := func(provider string) context.Context {
+			return NewContext(context.Background(), &Identity{Subject: "alice", Provider: provider})
+		}
+		assert.NoError(t, policy.Authorize(alice(ProviderJWT), insert))
+		assert.Error(t, policy.Authorize(alice(ProviderStatic), insert), "the same subject of another provider")
+		meter := NewContext(context.Background(), &Identity{Subject: "spiffe://edge/meter", Provider: ProviderStatic})
+		assert.NoError(t, policy.Authorize(meter, insert), "subjects containing colons")
+
+		// A single provider may be bound by bare subjects
+		_, err = NewPolicy(PolicyConfig{Bindings: map[string][]string{"alice": {"admin"}}, Providers: []string{ProviderJWT}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("restricted services", func(t *testing.T) {
+		const (
+			backfill   = "/edgecom.AdminService/Backfill"
+			clearCache = "/edgecom.AdminService/ClearCache"
+		)
+		open, err := NewPolicy(PolicyConfig{Restricted: []string{"/edgecom.AdminService/*"}})
+		require.NoError(t, err)
+		assert.NoError(t, open.Authorize(caller(), insert))
+		assert.ErrorContains(t, open.Authorize(caller("admin"), backfill), "may not be called", "the default does not apply")
+
+		granted, err := NewPolicy(PolicyConfig{
+			Methods:    map[string][]string{"ClearCache": {"operator"}},
+			Default:    []string{"admin"},
+			Restricted: []string{"/edgecom.AdminService/*"},
+		})
+		require.NoError(t, err)
+		assert.NoError(t, granted.Authorize(caller("operator"), clearCache))
+		assert.Error(t, granted.Authorize(caller("admin"), backfill))
+		assert.Empty(t, granted.Roles(backfill))
+
+		_, err = NewPolicy(PolicyConfig{Restricted: []string{"AdminService"}})
+		assert.ErrorContains(t, err, "invalid restricted service")
+	})
+
+	t.Run("explicit methods", func(t *testing.T) {
+		const setLimits = "/edgecom.AdminService/SetLimits"
+		policy, err := NewPolicy(PolicyConfig{
+			Methods:    map[string][]string{"/edgecom.AdminService/*": {"operator"}},
+			Restricted: []string{"/edgecom.AdminService/*"},
+			Explicit:   []string{setLimits},
+		})
+		require.NoError(t, err)
+		assert.NoError(t, policy.Authorize(caller("operator"), "/edgecom.AdminService/ClearCache"))
+		assert.ErrorContains(t, policy.Authorize(caller("operator"), setLimits), "may not be called",
+			"the service entry does not apply")
+
+		policy, err = NewPolicy(PolicyConfig{
+			Methods:  map[string][]string{"SetLimits": {"admin"}},
+			Explicit: []string{setLimits},
+		})
+		require.NoError(t, err)
+		assert.NoError(t, policy.Authorize(caller("admin"), setLimits))
+		assert.Error(t, policy.Authorize(caller("operator"), setLimits))
+
+		_, err = NewPolicy(PolicyConfig{
+			Methods:  map[string][]string{setLimits: {AnyRole}},
+			Explicit: []string{setLimits},
+		})
+		assert.ErrorContains(t, err, "must be granted to roles")
+		_, err = NewPolicy(PolicyConfig{Explicit: []string{"SetLimits"}})
+		assert.ErrorContains(t, err, "invalid explicit method")
+	})
+
+	t.Run("special roles cannot be granted", func(t *testing.T) {
+		_, err := NewPolicy(PolicyConfig{Bindings: map[string][]string{"dashboard": {RoleInternal}}})
+		assert.ErrorContains(t, err, "cannot be granted")
+		_, err = NewPolicy(PolicyConfig{Inherits: map[string][]string{"admin": {AnyRole}}})
+		assert.ErrorContains(t, err, "cannot be granted")
+		_, err = NewPolicy(PolicyConfig{Bindings: map[string][]string{"jwt:": {"viewer"}}})
+		assert.ErrorContains(t, err, "invalid binding subject")
+	})
+
+	invalid := []struct {
+		name string
+		key  string
+		err  string
+	}{
+		{"unknown method", "InsertTimeseries", "matches no method served"},
+		{"unknown service", "/edgecom.Other/*", "matches no method served"},
+		{"not a method", "/edgecom.TimeSeriesService", "invalid method"},
+		{"empty", "", "invalid method"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPolicy(PolicyConfig{Methods: map[string][]string{tt.key: {"read"}}, Known: known})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
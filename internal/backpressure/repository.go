@@ -0,0 +1,173 @@
+// Package backpressure bounds the amount of data waiting to be written to
+// the database.
+//
+// Repository decorates a TimeSeriesRepository with a write queue of fixed
+// capacity, counted in points. Writers wait for room in the queue, so when
+// the database slows down the ingestion paths slow down with it instead of
+// buffering fetched data in memory:
+//
+//   - gRPC streaming ingest stops reading from the stream, which pushes
+//     back on the producer through flow control
+//   - unary inserts wait until their deadline
+//   - the scheduler delays collection runs while the queue is full
+//
+// The queue depth is exported as a Prometheus gauge.
+//
+// Example Usage:
+//
+//	repo, err := backpressure.NewRepository(repo, 20000, prometheus.DefaultRegisterer)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	scheduler.SetBackpressure(repo)
+package backpressure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// ErrClosed is returned for writes started after Drain.
+var ErrClosed = errors.New("write queue closed")
+
+// DefaultCapacity is the default write queue capacity in points, enough
+// for a few chunks of a large upstream response.
+const DefaultCapacity = 20000
+
+// Repository limits the number of points being written at once.
+type Repository struct {
+	database.TimeSeriesRepository
+
+	capacity int64
+	slots    *semaphore.Weighted
+	closed   atomic.Bool
+
+	// depth counts points waiting for or being written
+	depth      atomic.Int64
+	depthGauge prometheus.Gauge
+}
+
+// NewRepository wraps repo with a write queue of the given capacity in
+// points and registers its metrics with reg.
+func NewRepository(repo database.TimeSeriesRepository, capacity int, reg prometheus.Registerer) (*Repository, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("write queue capacity must be positive, got %d", capacity)
+	}
+
+	depthGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "edgecom_write_queue_depth",
+		Help: "Points waiting for or being written to the database",
+	})
+	capacityGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "edgecom_write_queue_capacity",
+		Help: "Maximum number of points written to the database at once",
+	})
+	capacityGauge.Set(float64(capacity))
+
+	if err := reg.Register(depthGauge); err != nil {
+		return nil, fmt.Errorf("failed to register write queue depth metric: %v", err)
+	}
+	if err := reg.Register(capacityGauge); err != nil {
+		return nil, fmt.Errorf("failed to register write queue capacity metric: %v", err)
+	}
+
+	return &Repository{
+		TimeSeriesRepository: repo,
+		capacity:             int64(capacity),
+		slots:                semaphore.NewWeighted(int64(capacity)),
+		depthGauge:           depthGauge,
+	}, nil
+}
+
+// Depth returns the number of points waiting for or being written.
+func (r *Repository) Depth() int64 {
+	return r.depth.Load()
+}
+
+// Saturated reports whether the queue is full, i.e. a new write would have
+// to wait.
+func (r *Repository) Saturated() bool {
+	return r.Depth() >= r.capacity
+}
+
+// Drain stops admitting new writes and waits until the writes already
+// admitted or waiting have been written, or until ctx is done. It is used
+// on shutdown before the underlying repository is closed.
+func (r *Repository) Drain(ctx context.Context) error {
+	r.closed.Store(true)
+
+	// Waiters queued before this call are admitted first
+	if err := r.slots.Acquire(ctx, r.capacity); err != nil {
+		return fmt.Errorf("%d points still pending: %w", r.Depth(), err)
+	}
+	return nil
+}
+
+// InsertTimeSeriesData inserts a single point once there is room for it.
+//
+// Deprecated: Use InsertTimeSeriesDataContext.
+func (r *Repository) InsertTimeSeriesData(timestamp time.Time, value float64) error {
+	return r.InsertTimeSeriesDataContext(context.Background(), timestamp, value)
+}
+
+// InsertTimeSeriesDataContext inserts a single point once there is room for
+// it, giving up when ctx is done.
+func (r *Repository) InsertTimeSeriesDataContext(ctx context.Context, timestamp time.Time, value float64) error {
+	release, err := r.acquire(ctx, 1)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return r.TimeSeriesRepository.InsertTimeSeriesDataContext(ctx, timestamp, value)
+}
+
+// BatchInsertTimeSeriesData inserts a batch once there is room for it,
+// giving up when ctx is done. A batch larger than the capacity waits for
+// the queue to drain and is then written on its own.
+func (r *Repository) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
+	release, err := r.acquire(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return r.TimeSeriesRepository.BatchInsertTimeSeriesData(ctx, data)
+}
+
+// acquire waits for room for n points and returns a function that frees it
+func (r *Repository) acquire(ctx context.Context, n int64) (func(), error) {
+	if r.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	r.depth.Add(n)
+	r.depthGauge.Add(float64(n))
+	done := func() {
+		r.depth.Add(-n)
+		r.depthGauge.Sub(float64(n))
+	}
+
+	weight := min(n, r.capacity)
+	if err := r.slots.Acquire(ctx, weight); err != nil {
+		done()
+		return nil, fmt.Errorf("write queue full: %w", err)
+	}
+
+	return func() {
+		r.slots.Release(weight)
+		done()
+	}, nil
+}
+
+// Compile-time interface implementation check
+var _ database.TimeSeriesRepository = (*Repository)(nil)
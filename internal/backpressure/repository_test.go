@@ -0,0 +1,151 @@
+package backpressure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func points(n int) []models.TimeSeriesData {
+	return make([]models.TimeSeriesData, n)
+}
+
+func TestRepository(t *testing.T) {
+	t.Run("invalid capacity", func(t *testing.T) {
+		_, err := NewRepository(nil, 0, prometheus.NewRegistry())
+		assert.ErrorContains(t, err, "capacity must be positive")
+	})
+
+	t.Run("writes wait for room", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+		repo, err := NewRepository(mockRepo, 10, prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		// Hold the first batch in the database until released
+		started := make(chan struct{})
+		unblock := make(chan struct{})
+		mockRepo.EXPECT().
+			BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(8)).
+			DoAndReturn(func(context.Context, []models.TimeSeriesData) error {
+				close(started)
+				<-unblock
+				return nil
+			})
+		mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(5)).Return(nil)
+
+		first := make(chan error)
+		go func() { first <- repo.BatchInsertTimeSeriesData(context.Background(), points(8)) }()
+		<-started
+
+		second := make(chan error)
+		go func() { second <- repo.BatchInsertTimeSeriesData(context.Background(), points(5)) }()
+
+		require.Eventually(t, func() bool { return repo.Depth() == 13 }, time.Second, time.Millisecond)
+		assert.True(t, repo.Saturated())
+		assert.Equal(t, 13.0, testutil.ToFloat64(repo.depthGauge))
+
+		select {
+		case <-second:
+			t.Fatal("second batch was written while the queue was full")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(unblock)
+		require.NoError(t, <-first)
+		require.NoError(t, <-second)
+
+		assert.Zero(t, repo.Depth())
+		assert.False(t, repo.Saturated())
+		assert.Zero(t, testutil.ToFloat64(repo.depthGauge))
+	})
+
+	t.Run("waiting gives up with the context", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+		repo, err := NewRepository(mockRepo, 1, prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		release, err := repo.acquire(context.Background(), 1)
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err = repo.InsertTimeSeriesDataContext(ctx, time.Now(), 1)
+		assert.ErrorContains(t, err, "write queue full")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, int64(1), repo.Depth())
+	})
+
+	t.Run("batch larger than the capacity", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+		repo, err := NewRepository(mockRepo, 10, prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(25)).Return(nil)
+		assert.NoError(t, repo.BatchInsertTimeSeriesData(context.Background(), points(25)))
+		assert.Zero(t, repo.Depth())
+	})
+
+	t.Run("drain waits for pending writes", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+		repo, err := NewRepository(mockRepo, 10, prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		unblock := make(chan struct{})
+		mockRepo.EXPECT().
+			BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(3)).
+			DoAndReturn(func(context.Context, []models.TimeSeriesData) error {
+				<-unblock
+				return nil
+			})
+
+		written := make(chan error)
+		go func() { written <- repo.BatchInsertTimeSeriesData(context.Background(), points(3)) }()
+		require.Eventually(t, func() bool { return repo.Depth() == 3 }, time.Second, time.Millisecond)
+
+		drained := make(chan error)
+		go func() { drained <- repo.Drain(context.Background()) }()
+
+		require.Eventually(t, repo.closed.Load, time.Second, time.Millisecond)
+		err = repo.BatchInsertTimeSeriesData(context.Background(), points(1))
+		assert.ErrorIs(t, err, ErrClosed)
+
+		close(unblock)
+		require.NoError(t, <-written)
+		require.NoError(t, <-drained)
+	})
+
+	t.Run("drain gives up with the context", func(t *testing.T) {
+		repo, err := NewRepository(nil, 10, prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		release, err := repo.acquire(context.Background(), 4)
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err = repo.Drain(ctx)
+		assert.ErrorContains(t, err, "4 points still pending")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,337 @@
+// Package bucketcache caches aggregated buckets, the second tier behind
+// the gRPC response cache.
+//
+// The response cache only helps when a request is repeated exactly.
+// Dashboards showing a sliding range, such as the last 24 hours refreshed
+// every minute, ask for a slightly different range each time. Repository
+// caches the individual buckets of aggregation queries instead, so such a
+// query is answered from the buckets it shares with earlier ones plus
+// small queries for the buckets at its edges:
+//
+//   - partial buckets, cut by an unaligned start or end, and the bucket
+//     still open at the current time are always read from the database
+//   - whole buckets are cached, including empty ones, keyed by window,
+//     aggregation and start time
+//   - every insert evicts the buckets its points fall in, once it has
+//     been written, and results read while an insert was running are not
+//     cached
+//
+// Only writes made through the Repository evict buckets, so it must wrap
+// the repository every ingestion path of the process writes to. Buckets
+// written by other processes, such as the import subcommand, are read
+// again once cached buckets reach their maximum age. Bucket hits and misses
+// are counted in edgecom_bucket_cache_hits_total and
+// edgecom_bucket_cache_misses_total.
+//
+// Example Usage:
+//
+//	repo, err := bucketcache.NewRepository(repo, bucketcache.DefaultEntries, prometheus.DefaultRegisterer)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+package bucketcache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+)
+
+// Cache defaults
+const (
+	// DefaultEntries is the default number of cached buckets, a year of
+	// hourly buckets for each aggregation with room to spare
+	DefaultEntries = 100000
+	// DefaultMaxAge is how long buckets are served from the cache by
+	// default
+	DefaultMaxAge = 10 * time.Minute
+)
+
+// resolution is the precision of stored timestamps. A range ending one
+// resolution before a bucket boundary covers the whole bucket before it.
+const resolution = time.Microsecond
+
+// maxSpans is the number of queries a composed query may need; queries
+// needing more are read whole.
+const maxSpans = 3
+
+// series identifies the buckets of one window and aggregation
+type series struct {
+	window      string
+	aggregation string
+}
+
+// key identifies a cached bucket
+type key struct {
+	series
+	start int64
+}
+
+// entry is a cached bucket; empty buckets have no point
+type entry struct {
+	point  models.TimeSeriesData
+	ok     bool
+	stored time.Time
+}
+
+// span is a time range read from the database, inclusive of both ends
+type span struct {
+	start, end time.Time
+}
+
+// Repository serves Query from cached buckets.
+type Repository struct {
+	database.TimeSeriesRepository
+
+	entries int
+	maxAge  time.Duration
+	cache   *lru.Cache
+	// generation is incremented by every insert, so results read while one
+	// was running are not cached
+	generation atomic.Uint64
+
+	mu sync.Mutex
+	// widths holds the bucket width of each series cached so far
+	widths map[series]time.Duration
+
+	hits   prometheus.Counter
+	misses prometheus.Counter
+	clock  clock.Clock
+}
+
+// NewRepository wraps repo with a cache of up to entries buckets and
+// registers its metrics with reg.
+func NewRepository(repo database.TimeSeriesRepository, entries int, reg prometheus.Registerer) (*Repository, error) {
+	if entries <= 0 {
+		return nil, fmt.Errorf("bucket cache entries must be positive, got %d", entries)
+	}
+	cache, err := lru.New(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "edgecom_bucket_cache_hits_total",
+		Help: "Aggregated buckets served from the bucket cache",
+	})
+	misses := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "edgecom_bucket_cache_misses_total",
+		Help: "Aggregated buckets read from the database that the bucket cache could hold",
+	})
+	for _, c := range []prometheus.Collector{hits, misses} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register bucket cache metric: %v", err)
+		}
+	}
+
+	return &Repository{
+		TimeSeriesRepository: repo,
+		entries:              entries,
+		maxAge:               DefaultMaxAge,
+		cache:                cache,
+		widths:               make(map[series]time.Duration),
+		hits:                 hits,
+		misses:               misses,
+		clock:                clock.System,
+	}, nil
+}
+
+// SetMaxAge sets how long buckets are served from the cache before being
+// read again. It must be called before the repository is used.
+func (r *Repository) SetMaxAge(maxAge time.Duration) {
+	r.maxAge = maxAge
+}
+
+// SetClock sets the clock cached buckets age by, instead of the system
+// clock. It must be called before the repository is used.
+func (r *Repository) SetClock(clk clock.Clock) {
+	r.clock = clk
+}
+
+// Query returns the aggregated buckets of [start, end], reading from the
+// database only those that are not cached.
+func (r *Repository) Query(ctx context.Context, start, end time.Time, window string, aggregation string) ([]models.TimeSeriesData, error) {
+	width, err := stream.WindowDuration(window)
+	if err != nil {
+		return r.TimeSeriesRepository.Query(ctx, start, end, window, aggregation)
+	}
+
+	// The whole buckets within the range, leaving out the open one
+	first := start.Truncate(width)
+	if first.Before(start) {
+		first = first.Add(width)
+	}
+	last := end.Add(resolution).Truncate(width).Add(-width)
+	now := r.clock.Now()
+	if open := now.Truncate(width); !last.Before(open) {
+		last = open.Add(-width)
+	}
+	if last.Before(first) || int(last.Sub(first)/width) >= r.entries/2 {
+		return r.TimeSeriesRepository.Query(ctx, start, end, window, aggregation)
+	}
+
+	s := series{window: window, aggregation: aggregation}
+	r.mu.Lock()
+	r.widths[s] = width
+	r.mu.Unlock()
+
+	// Collect the cached buckets and the spans to read
+	var (
+		results []models.TimeSeriesData
+		spans   []span
+		hits    int
+	)
+	addSpan := func(from, to time.Time) {
+		if n := len(spans); n > 0 && spans[n-1].end.Add(resolution).Equal(from) {
+			spans[n-1].end = to
+			return
+		}
+		spans = append(spans, span{start: from, end: to})
+	}
+	if start.Before(first) {
+		addSpan(start, first.Add(-resolution))
+	}
+	for b := first; !b.After(last); b = b.Add(width) {
+		if cached, ok := r.cache.Get(key{series: s, start: b.UnixMicro()}); ok {
+			if e := cached.(entry); now.Sub(e.stored) < r.maxAge {
+				hits++
+				if e.ok {
+					results = append(results, e.point)
+				}
+				continue
+			}
+		}
+		addSpan(b, b.Add(width-resolution))
+	}
+	if next := last.Add(width); !next.After(end) {
+		addSpan(next, end)
+	}
+	if len(spans) > maxSpans {
+		spans, results, hits = []span{{start: start, end: end}}, nil, 0
+	}
+
+	generation := r.generation.Load()
+	misses := 0
+	for _, sp := range spans {
+		points, err := r.TimeSeriesRepository.Query(ctx, sp.start, sp.end, window, aggregation)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, points...)
+		misses += r.store(s, width, first, last, sp, points, generation, now)
+	}
+	r.hits.Add(float64(hits))
+	r.misses.Add(float64(misses))
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Time.Before(results[j].Time) })
+	return results, nil
+}
+
+// store caches the whole buckets within [first, last] that sp covers,
+// given the points read for it at now, unless an insert has run since
+// generation was read. It returns the number of such buckets.
+func (r *Repository) store(s series, width time.Duration, first, last time.Time, sp span, points []models.TimeSeriesData, generation uint64, now time.Time) int {
+	read := make(map[int64]models.TimeSeriesData, len(points))
+	for _, p := range points {
+		read[p.Time.UnixMicro()] = p
+	}
+
+	from := sp.start.Truncate(width)
+	if from.Before(sp.start) {
+		from = from.Add(width)
+	}
+	if from.Before(first) {
+		from = first
+	}
+	n := 0
+	stale := false
+	for b := from; !b.After(last) && !b.Add(width-resolution).After(sp.end); b = b.Add(width) {
+		n++
+		if stale {
+			continue
+		}
+		k := key{series: s, start: b.UnixMicro()}
+		p, ok := read[k.start]
+		r.cache.Add(k, entry{point: p, ok: ok, stored: now})
+		// Checked after adding, as evict increments the generation before
+		// removing buckets
+		if r.generation.Load() != generation {
+			r.cache.Remove(k)
+			stale = true
+		}
+	}
+	return n
+}
+
+// InsertTimeSeriesData inserts a single point and evicts its buckets.
+//
+// Deprecated: Use InsertTimeSeriesDataContext.
+func (r *Repository) InsertTimeSeriesData(timestamp time.Time, value float64) error {
+	return r.InsertTimeSeriesDataContext(context.Background(), timestamp, value)
+}
+
+// InsertTimeSeriesDataContext inserts a single point and evicts its
+// buckets.
+func (r *Repository) InsertTimeSeriesDataContext(ctx context.Context, timestamp time.Time, value float64) error {
+	defer r.evict([]models.TimeSeriesData{{Time: timestamp, Value: value}})
+	return r.TimeSeriesRepository.InsertTimeSeriesDataContext(ctx, timestamp, value)
+}
+
+// BatchInsertTimeSeriesData inserts a batch and evicts the buckets of its
+// points. Buckets are evicted even if the insert fails, as it may have
+// been written before the failure was reported.
+func (r *Repository) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
+	defer r.evict(data)
+	return r.TimeSeriesRepository.BatchInsertTimeSeriesData(ctx, data)
+}
+
+// evict removes the cached buckets the points fall in
+func (r *Repository) evict(points []models.TimeSeriesData) {
+	r.generation.Add(1)
+
+	r.mu.Lock()
+	widths := make(map[series]time.Duration, len(r.widths))
+	for s, width := range r.widths {
+		widths[s] = width
+	}
+	r.mu.Unlock()
+
+	for s, width := range widths {
+		evicted := make(map[int64]bool)
+		for _, p := range points {
+			start := p.Time.Truncate(width).UnixMicro()
+			if !evicted[start] {
+				evicted[start] = true
+				r.cache.Remove(key{series: s, start: start})
+			}
+		}
+	}
+}
+
+// Purge removes every cached bucket and returns the number removed.
+func (r *Repository) Purge() int {
+	// Results read before the purge are not cached after it
+	r.generation.Add(1)
+	entries := r.cache.Len()
+	r.cache.Purge()
+	return entries
+}
+
+// Len returns the number of cached buckets.
+func (r *Repository) Len() int {
+	return r.cache.Len()
+}
+
+// Compile-time interface implementation check
+var _ database.TimeSeriesRepository = (*Repository)(nil)
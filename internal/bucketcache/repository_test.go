@@ -0,0 +1,241 @@
+package bucketcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// fakeStore answers aggregation queries like the database, summing the
+// stored samples of each bucket, and records the ranges queried
+type fakeStore struct {
+	mu      sync.Mutex
+	samples []models.TimeSeriesData
+	queries []span
+}
+
+func (f *fakeStore) query(_ context.Context, start, end time.Time, window, _ string) ([]models.TimeSeriesData, error) {
+	width := time.Hour
+	if window == "1m" {
+		width = time.Minute
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, span{start: start, end: end})
+
+	var points []models.TimeSeriesData
+	for _, s := range f.samples {
+		if s.Time.Before(start) || s.Time.After(end) {
+			continue
+		}
+		bucket := s.Time.Truncate(width)
+		if n := len(points); n > 0 && points[n-1].Time.Equal(bucket) {
+			points[n-1].Value += s.Value
+			points[n-1].Count++
+			continue
+		}
+		points = append(points, models.TimeSeriesData{Time: bucket, Value: s.Value, Count: 1})
+	}
+	return points, nil
+}
+
+func (f *fakeStore) insert(_ context.Context, data []models.TimeSeriesData) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.samples = append(f.samples, data...)
+	// Kept in time order, as the database scans them
+	for i := len(f.samples) - 1; i > 0 && f.samples[i].Time.Before(f.samples[i-1].Time); i-- {
+		f.samples[i], f.samples[i-1] = f.samples[i-1], f.samples[i]
+	}
+	return nil
+}
+
+// takeQueries returns the ranges queried since the last call
+func (f *fakeStore) takeQueries() []span {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	queries := f.queries
+	f.queries = nil
+	return queries
+}
+
+func newTestRepository(t *testing.T, store *fakeStore, clk clock.Clock) *Repository {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	mockRepo.EXPECT().Query(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(store.query).AnyTimes()
+	mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).
+		DoAndReturn(store.insert).AnyTimes()
+
+	repo, err := NewRepository(mockRepo, 1000, prometheus.NewRegistry())
+	require.NoError(t, err)
+	repo.SetClock(clk)
+	return repo
+}
+
+func TestQuery(t *testing.T) {
+	base := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return base.Add(time.Duration(h) * time.Hour) }
+
+	// A sample every 30 minutes for 48 hours, except between 10:00 and 12:00
+	store := &fakeStore{}
+	for t := base; t.Before(hour(48)); t = t.Add(30 * time.Minute) {
+		if t.Before(hour(10)) || !t.Before(hour(12)) {
+			store.samples = append(store.samples, models.TimeSeriesData{Time: t, Value: 1})
+		}
+	}
+	clk := clock.NewFake(hour(40).Add(20 * time.Minute))
+	repo := newTestRepository(t, store, clk)
+	ctx := context.Background()
+
+	// query returns the points of a SUM query and the ranges it read
+	query := func(start, end time.Time, window string) ([]models.TimeSeriesData, []span) {
+		points, err := repo.Query(ctx, start, end, window, "SUM")
+		require.NoError(t, err)
+		queries := store.takeQueries()
+		// The cache must answer like the database
+		want, _ := store.query(ctx, start, end, window, "SUM")
+		store.takeQueries()
+		assert.Equal(t, want, points)
+		return points, queries
+	}
+
+	t.Run("first query", func(t *testing.T) {
+		points, queries := query(hour(0), hour(24).Add(-resolution), "1h")
+		assert.Len(t, points, 22)
+		assert.Equal(t, []span{{start: hour(0), end: hour(24).Add(-resolution)}}, queries)
+		assert.Equal(t, 24, repo.Len())
+		assert.Equal(t, 24.0, testutil.ToFloat64(repo.misses))
+	})
+
+	t.Run("shifted range", func(t *testing.T) {
+		// Only the partial edges and the new whole bucket are read
+		start, end := hour(1).Add(15*time.Minute), hour(25).Add(15*time.Minute)
+		_, queries := query(start, end, "1h")
+		assert.Equal(t, []span{
+			{start: start, end: hour(2).Add(-resolution)},
+			{start: hour(24), end: end},
+		}, queries)
+		assert.Equal(t, 22.0, testutil.ToFloat64(repo.hits))
+	})
+
+	t.Run("empty buckets are cached", func(t *testing.T) {
+		_, queries := query(hour(10), hour(12).Add(-resolution), "1h")
+		assert.Empty(t, queries)
+	})
+
+	t.Run("open bucket is not cached", func(t *testing.T) {
+		start, end := hour(39), hour(41).Add(-resolution)
+		_, queries := query(start, end, "1h")
+		assert.Equal(t, []span{{start: start, end: end}}, queries)
+		_, queries = query(start, end, "1h")
+		assert.Equal(t, []span{{start: hour(40), end: end}}, queries)
+	})
+
+	t.Run("inserts evict their buckets", func(t *testing.T) {
+		require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{
+			{Time: hour(5).Add(10 * time.Minute), Value: 5},
+		}))
+		points, queries := query(hour(0), hour(24).Add(-resolution), "1h")
+		assert.Equal(t, 7.0, points[5].Value)
+		assert.Equal(t, []span{{start: hour(5), end: hour(6).Add(-resolution)}}, queries)
+	})
+
+	t.Run("scattered misses read the whole range", func(t *testing.T) {
+		for _, h := range []int{2, 6, 14, 20} {
+			require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{{Time: hour(h), Value: 1}}))
+		}
+		_, queries := query(hour(0), hour(24).Add(-resolution), "1h")
+		assert.Equal(t, []span{{start: hour(0), end: hour(24).Add(-resolution)}}, queries)
+		_, queries = query(hour(0), hour(24).Add(-resolution), "1h")
+		assert.Empty(t, queries)
+	})
+
+	t.Run("series are cached separately", func(t *testing.T) {
+		_, queries := query(hour(0), hour(1).Add(-resolution), "1m")
+		assert.Len(t, queries, 1)
+		_, err := repo.Query(ctx, hour(0), hour(2).Add(-resolution), "1h", "MAX")
+		require.NoError(t, err)
+		assert.Len(t, store.takeQueries(), 1)
+	})
+
+	t.Run("ranges without whole buckets", func(t *testing.T) {
+		start, end := hour(3).Add(10*time.Minute), hour(3).Add(50*time.Minute)
+		_, queries := query(start, end, "1h")
+		assert.Equal(t, []span{{start: start, end: end}}, queries)
+	})
+
+	t.Run("expired buckets are read again", func(t *testing.T) {
+		repo.SetMaxAge(time.Minute)
+		defer repo.SetMaxAge(DefaultMaxAge)
+		_, queries := query(hour(0), hour(2).Add(-resolution), "1h")
+		assert.Empty(t, queries)
+
+		clk.Advance(time.Minute)
+		_, queries = query(hour(0), hour(2).Add(-resolution), "1h")
+		assert.Equal(t, []span{{start: hour(0), end: hour(2).Add(-resolution)}}, queries)
+		_, queries = query(hour(0), hour(2).Add(-resolution), "1h")
+		assert.Empty(t, queries)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		assert.Equal(t, repo.Len(), repo.Purge())
+		assert.Zero(t, repo.Len())
+		_, queries := query(hour(0), hour(2).Add(-resolution), "1h")
+		assert.Equal(t, []span{{start: hour(0), end: hour(2).Add(-resolution)}}, queries)
+	})
+}
+
+func TestQueryRacingInsert(t *testing.T) {
+	base := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	store := &fakeStore{samples: []models.TimeSeriesData{{Time: base, Value: 1}}}
+
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	repo, err := NewRepository(mockRepo, 1000, prometheus.NewRegistry())
+	require.NoError(t, err)
+	repo.SetClock(clock.NewFake(base.Add(24 * time.Hour)))
+
+	// An insert completes while the query is reading
+	mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).DoAndReturn(store.insert)
+	mockRepo.EXPECT().Query(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, start, end time.Time, window, aggregation string) ([]models.TimeSeriesData, error) {
+			points, err := store.query(ctx, start, end, window, aggregation)
+			require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{{Time: base, Value: 2}}))
+			return points, err
+		})
+	points, err := repo.Query(context.Background(), base, base.Add(time.Hour-resolution), "1h", "SUM")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, points[0].Value)
+	assert.Zero(t, repo.Len())
+}
+
+func TestQueryErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	repo, err := NewRepository(mockRepo, 1000, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	base := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	mockRepo.EXPECT().Query(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, errors.New("connection refused"))
+	_, err = repo.Query(context.Background(), base, base.Add(2*time.Hour), "1h", "AVG")
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Zero(t, repo.Len())
+
+	_, err = NewRepository(mockRepo, 0, prometheus.NewRegistry())
+	assert.ErrorContains(t, err, "must be positive")
+}
@@ -0,0 +1,322 @@
+// Package budget tracks consumption and cost against monthly budgets.
+//
+// The month-to-date actual of a budget is the consumption since the start
+// of the calendar month in the tracker's time zone, converted to cost with
+// a flat unit price for cost budgets. The month-end projection extrapolates
+// the month-to-date run rate over the whole month, once MinProjectedFraction
+// of the month has elapsed; until then, a few hours of data would be
+// extrapolated into large false overruns, so the projection is the actual.
+//
+// Check reports each threshold (a fraction of the limit) the first time
+// the actual crosses it in a month, and the first time the projection
+// exceeds the limit, as a warning log entry and a metric increment, and
+// to webhooks if a notifier is set.
+//
+// Example Usage:
+//
+//	tracker, err := budget.NewTracker(repo, []budget.Budget{
+//	    {Name: "electricity", Kind: budget.KindConsumption, Limit: 12000},
+//	}, 0, time.UTC, logger, prometheus.DefaultRegisterer)
+//	if err != nil {
+//	    return err
+//	}
+//
+//	statuses, err := tracker.Status(ctx, time.Now())
+package budget
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+// Budget kinds
+const (
+	// KindConsumption limits consumption in stored units
+	KindConsumption = "consumption"
+	// KindCost limits consumption multiplied by the unit price
+	KindCost = "cost"
+)
+
+// projectedLabel is the threshold label of projection events
+const projectedLabel = "projected"
+
+// MinProjectedFraction is the fraction of the month, about three days, that
+// must have elapsed before the month-to-date run rate is extrapolated
+const MinProjectedFraction = 0.1
+
+// DefaultThresholds are the fractions of the limit reported when a budget
+// does not list its own
+var DefaultThresholds = []float64{0.8, 1}
+
+// Querier is the subset of the repository needed to sum consumption.
+type Querier interface {
+	Query(ctx context.Context, start, end time.Time, window, aggregation string) ([]models.TimeSeriesData, error)
+}
+
+// Notifier sends budget events to webhooks.
+type Notifier interface {
+	Notify(ctx context.Context, event webhook.Event)
+}
+
+// Budget is a monthly limit on consumption or cost.
+type Budget struct {
+	Name string
+	// Kind is KindConsumption or KindCost
+	Kind string
+	// Limit is the monthly budget in stored units or currency
+	Limit float64
+	// Thresholds are the fractions of Limit reported when reached,
+	// DefaultThresholds when empty
+	Thresholds []float64
+}
+
+// Validate checks that the budget is usable.
+func (b Budget) Validate() error {
+	if b.Name == "" {
+		return fmt.Errorf("budget name is required")
+	}
+	if b.Kind != KindConsumption && b.Kind != KindCost {
+		return fmt.Errorf("budget %s: invalid kind %q", b.Name, b.Kind)
+	}
+	if !(b.Limit > 0) {
+		return fmt.Errorf("budget %s: limit must be positive", b.Name)
+	}
+	for _, threshold := range b.Thresholds {
+		if !(threshold > 0) {
+			return fmt.Errorf("budget %s: thresholds must be positive", b.Name)
+		}
+	}
+	return nil
+}
+
+// Status is the state of a budget in the current month.
+type Status struct {
+	Budget Budget
+	// MonthStart and MonthEnd bound the current month
+	MonthStart time.Time
+	MonthEnd   time.Time
+	// Actual is the month-to-date consumption or cost
+	Actual float64
+	// Projected is the expected month-end consumption or cost, which is
+	// Actual until MinProjectedFraction of the month has elapsed
+	Projected float64
+	// UsedFraction and ProjectedFraction relate Actual and Projected to
+	// the limit
+	UsedFraction      float64
+	ProjectedFraction float64
+	// Crossed lists the thresholds Actual has reached
+	Crossed []float64
+}
+
+// Tracker computes the status of a set of budgets.
+type Tracker struct {
+	repo      Querier
+	budgets   []Budget
+	unitPrice float64
+	location  *time.Location
+	logger    *logrus.Logger
+	crossings *prometheus.CounterVec
+	notifier  Notifier
+
+	mu sync.Mutex
+	// notified maps a budget and threshold label to the start of the month
+	// it was last reported in
+	notified map[string]time.Time
+}
+
+// NewTracker creates a tracker for budgets and registers its metric with
+// reg. unitPrice converts stored units into cost and must be positive when
+// there are cost budgets. Months begin at midnight in location.
+func NewTracker(
+	repo Querier,
+	budgets []Budget,
+	unitPrice float64,
+	location *time.Location,
+	logger *logrus.Logger,
+	reg prometheus.Registerer,
+) (*Tracker, error) {
+	budgets = append([]Budget(nil), budgets...)
+	names := make(map[string]bool, len(budgets))
+	for i, b := range budgets {
+		if err := b.Validate(); err != nil {
+			return nil, err
+		}
+		if names[b.Name] {
+			return nil, fmt.Errorf("duplicate budget: %s", b.Name)
+		}
+		names[b.Name] = true
+		if b.Kind == KindCost && !(unitPrice > 0) {
+			return nil, fmt.Errorf("budget %s: cost budgets require a positive unit price", b.Name)
+		}
+		if len(b.Thresholds) == 0 {
+			budgets[i].Thresholds = DefaultThresholds
+		}
+	}
+
+	crossings := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edgecom_budget_threshold_crossings_total",
+		Help: "Budget thresholds reached by month-to-date actuals, or \"projected\" when the month-end projection exceeds the limit",
+	}, []string{"budget", "threshold"})
+	if err := reg.Register(crossings); err != nil {
+		return nil, fmt.Errorf("failed to register budget metric: %v", err)
+	}
+
+	return &Tracker{
+		repo:      repo,
+		budgets:   budgets,
+		unitPrice: unitPrice,
+		location:  location,
+		logger:    logger,
+		crossings: crossings,
+		notified:  make(map[string]time.Time),
+	}, nil
+}
+
+// SetNotifier sends the events reported by Check to notifier. It must be
+// called before Check is first called.
+func (t *Tracker) SetNotifier(notifier Notifier) {
+	t.notifier = notifier
+}
+
+// Status returns the status of every budget for the month containing now.
+func (t *Tracker) Status(ctx context.Context, now time.Time) ([]Status, error) {
+	local := now.In(t.location)
+	monthStart := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, t.location)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+
+	buckets, err := t.repo.Query(ctx, monthStart, now, "1d", "SUM")
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum consumption: %w", err)
+	}
+	var consumption float64
+	for _, bucket := range buckets {
+		consumption += bucket.Value
+	}
+
+	// Extrapolate the run rate so far over the whole month, once enough
+	// of it has elapsed for the run rate to mean something
+	projection := 1.0
+	month := float64(monthEnd.Sub(monthStart))
+	if elapsed := float64(now.Sub(monthStart)); elapsed >= month*MinProjectedFraction {
+		projection = month / elapsed
+	}
+
+	statuses := make([]Status, 0, len(t.budgets))
+	for _, b := range t.budgets {
+		actual := consumption
+		if b.Kind == KindCost {
+			actual *= t.unitPrice
+		}
+
+		status := Status{
+			Budget:     b,
+			MonthStart: monthStart,
+			MonthEnd:   monthEnd,
+			Actual:     actual,
+			Projected:  actual * projection,
+		}
+		status.UsedFraction = status.Actual / b.Limit
+		status.ProjectedFraction = status.Projected / b.Limit
+		for _, threshold := range b.Thresholds {
+			if status.UsedFraction >= threshold {
+				status.Crossed = append(status.Crossed, threshold)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Check reports thresholds crossed and projected overruns not yet reported
+// this month. Reports are kept in memory, so they are repeated after a
+// restart.
+func (t *Tracker) Check(ctx context.Context, now time.Time) error {
+	statuses, err := t.Status(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	// Events are sent once the lock is released, so slow webhooks do not
+	// hold up other checks
+	var events []webhook.Event
+
+	t.mu.Lock()
+	for _, status := range statuses {
+		fields := logrus.Fields{
+			"budget":    status.Budget.Name,
+			"kind":      status.Budget.Kind,
+			"limit":     status.Budget.Limit,
+			"actual":    status.Actual,
+			"projected": status.Projected,
+		}
+
+		for _, threshold := range status.Crossed {
+			label := strconv.FormatFloat(threshold, 'f', -1, 64)
+			if t.report(status, label) {
+				t.logger.WithFields(fields).WithField("threshold", threshold).
+					Warn("Budget threshold reached")
+
+				severity := webhook.SeverityWarning
+				if threshold >= 1 {
+					severity = webhook.SeverityCritical
+				}
+				events = append(events, budgetEvent(webhook.EventBudgetThreshold, severity, now, fields, map[string]interface{}{"threshold": threshold},
+					fmt.Sprintf("Budget %s reached %s%% of its monthly limit", status.Budget.Name, strconv.FormatFloat(threshold*100, 'f', -1, 64))))
+			}
+		}
+		if status.ProjectedFraction > 1 && t.report(status, projectedLabel) {
+			t.logger.WithFields(fields).Warn("Budget projected to be exceeded this month")
+			events = append(events, budgetEvent(webhook.EventBudgetProjected, webhook.SeverityWarning, now, fields, nil,
+				fmt.Sprintf("Budget %s is projected to be exceeded this month", status.Budget.Name)))
+		}
+	}
+	t.mu.Unlock()
+
+	if t.notifier != nil {
+		for _, event := range events {
+			t.notifier.Notify(ctx, event)
+		}
+	}
+	return nil
+}
+
+// budgetEvent builds a webhook event from the log fields of a budget
+// report and any extra fields
+func budgetEvent(typ, severity string, now time.Time, fields logrus.Fields, extra map[string]interface{}, summary string) webhook.Event {
+	eventFields := make(map[string]interface{}, len(fields)+len(extra))
+	for k, v := range fields {
+		eventFields[k] = v
+	}
+	for k, v := range extra {
+		eventFields[k] = v
+	}
+	return webhook.Event{
+		Type:     typ,
+		Time:     now,
+		Severity: severity,
+		Summary:  summary,
+		Fields:   eventFields,
+	}
+}
+
+// report records an event for status and label, and reports whether it is
+// new this month. t.mu must be held.
+func (t *Tracker) report(status Status, label string) bool {
+	key := status.Budget.Name + "/" + label
+	if t.notified[key].Equal(status.MonthStart) {
+		return false
+	}
+	t.notified[key] = status.MonthStart
+	t.crossings.WithLabelValues(status.Budget.Name, label).Inc()
+	return true
+}
@@ -0,0 +1,157 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+// fakeQuerier returns fixed daily sums and records the queried range.
+type fakeQuerier struct {
+	buckets    []models.TimeSeriesData
+	err        error
+	start, end time.Time
+}
+
+func (f *fakeQuerier) Query(_ context.Context, start, end time.Time, window, aggregation string) ([]models.TimeSeriesData, error) {
+	f.start, f.end = start, end
+	return f.buckets, f.err
+}
+
+func newTestTracker(t *testing.T, repo Querier, budgets ...Budget) *Tracker {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	tracker, err := NewTracker(repo, budgets, 0.25, time.UTC, logger, prometheus.NewRegistry())
+	require.NoError(t, err)
+	return tracker
+}
+
+func TestStatus(t *testing.T) {
+	repo := &fakeQuerier{buckets: []models.TimeSeriesData{{Value: 400}, {Value: 500}}}
+	tracker := newTestTracker(t, repo,
+		Budget{Name: "energy", Kind: KindConsumption, Limit: 2000},
+		Budget{Name: "bill", Kind: KindCost, Limit: 500, Thresholds: []float64{0.1, 0.5}},
+	)
+
+	// Ten days into a 30-day month
+	now := time.Date(2024, 11, 11, 0, 0, 0, 0, time.UTC)
+	statuses, err := tracker.Status(context.Background(), now)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	assert.Equal(t, time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), repo.start)
+	assert.Equal(t, now, repo.end)
+
+	energy := statuses[0]
+	assert.Equal(t, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), energy.MonthEnd)
+	assert.Equal(t, 900.0, energy.Actual)
+	assert.InDelta(t, 2700, energy.Projected, 1e-9)
+	assert.InDelta(t, 0.45, energy.UsedFraction, 1e-9)
+	assert.InDelta(t, 1.35, energy.ProjectedFraction, 1e-9)
+	assert.Empty(t, energy.Crossed)
+	assert.Equal(t, DefaultThresholds, energy.Budget.Thresholds)
+
+	bill := statuses[1]
+	assert.Equal(t, 225.0, bill.Actual)
+	assert.Equal(t, []float64{0.1}, bill.Crossed)
+
+	t.Run("no projection early in the month", func(t *testing.T) {
+		// Two hours of data would project a thirty-fold overrun
+		statuses, err := tracker.Status(context.Background(), time.Date(2024, 11, 1, 2, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.Equal(t, statuses[0].Actual, statuses[0].Projected)
+	})
+
+	t.Run("months follow the time zone", func(t *testing.T) {
+		berlin, err := time.LoadLocation("Europe/Berlin")
+		require.NoError(t, err)
+		tracker.location = berlin
+
+		_, err = tracker.Status(context.Background(), time.Date(2024, 11, 30, 23, 30, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.True(t, repo.start.Equal(time.Date(2024, 11, 30, 23, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		tracker := newTestTracker(t, &fakeQuerier{err: errors.New("connection refused")})
+		_, err := tracker.Status(context.Background(), now)
+		assert.ErrorContains(t, err, "failed to sum consumption")
+	})
+}
+
+// eventRecorder records the events sent to webhooks
+type eventRecorder struct {
+	events []webhook.Event
+}
+
+func (e *eventRecorder) Notify(_ context.Context, event webhook.Event) {
+	e.events = append(e.events, event)
+}
+
+func TestCheck(t *testing.T) {
+	repo := &fakeQuerier{buckets: []models.TimeSeriesData{{Value: 1700}}}
+	tracker := newTestTracker(t, repo, Budget{Name: "energy", Kind: KindConsumption, Limit: 2000})
+	notifier := &eventRecorder{}
+	tracker.SetNotifier(notifier)
+
+	now := time.Date(2024, 11, 11, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, tracker.Check(context.Background(), now))
+	require.NoError(t, tracker.Check(context.Background(), now.Add(time.Hour)))
+
+	require.Len(t, notifier.events, 2)
+	threshold := notifier.events[0]
+	assert.Equal(t, webhook.EventBudgetThreshold, threshold.Type)
+	assert.Equal(t, webhook.SeverityWarning, threshold.Severity)
+	assert.Equal(t, "Budget energy reached 80% of its monthly limit", threshold.Summary)
+	assert.Equal(t, 0.8, threshold.Fields["threshold"])
+	assert.Equal(t, 1700.0, threshold.Fields["actual"])
+	assert.Equal(t, webhook.EventBudgetProjected, notifier.events[1].Type)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(tracker.crossings.WithLabelValues("energy", "0.8")), "reported once a month")
+	assert.Equal(t, 1.0, testutil.ToFloat64(tracker.crossings.WithLabelValues("energy", "projected")))
+	assert.Zero(t, testutil.ToFloat64(tracker.crossings.WithLabelValues("energy", "1")))
+
+	require.NoError(t, tracker.Check(context.Background(), now.AddDate(0, 1, 0)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(tracker.crossings.WithLabelValues("energy", "0.8")), "reported again next month")
+}
+
+func TestNewTracker(t *testing.T) {
+	logger := logrus.New()
+
+	tests := []struct {
+		name      string
+		budgets   []Budget
+		unitPrice float64
+		wantErr   string
+	}{
+		{name: "valid", budgets: []Budget{{Name: "a", Kind: KindCost, Limit: 1}}, unitPrice: 0.3},
+		{name: "missing name", budgets: []Budget{{Kind: KindConsumption, Limit: 1}}, wantErr: "name is required"},
+		{name: "invalid kind", budgets: []Budget{{Name: "a", Kind: "power", Limit: 1}}, wantErr: "invalid kind"},
+		{name: "no limit", budgets: []Budget{{Name: "a", Kind: KindConsumption}}, wantErr: "limit must be positive"},
+		{name: "negative threshold", budgets: []Budget{{Name: "a", Kind: KindConsumption, Limit: 1, Thresholds: []float64{-1}}}, wantErr: "thresholds must be positive"},
+		{name: "duplicate", budgets: []Budget{{Name: "a", Kind: KindConsumption, Limit: 1}, {Name: "a", Kind: KindConsumption, Limit: 2}}, wantErr: "duplicate budget"},
+		{name: "cost without price", budgets: []Budget{{Name: "a", Kind: KindCost, Limit: 1}}, wantErr: "positive unit price"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTracker(&fakeQuerier{}, tt.budgets, tt.unitPrice, time.UTC, logger, prometheus.NewRegistry())
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
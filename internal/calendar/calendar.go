@@ -0,0 +1,189 @@
+// Package calendar describes business calendars: the working hours of each
+// weekday in a time zone, excluding holidays.
+//
+// Aggregations can be restricted to a calendar's working hours, which
+// separates occupied-hours consumption from the baseline outside them.
+// Working hours are wall clock times, so they follow daylight saving
+// changes in the calendar's time zone.
+//
+// Example Usage:
+//
+//	cal, err := calendar.New(calendar.Config{
+//	    Timezone: "Europe/Berlin",
+//	    Hours: []calendar.HoursConfig{
+//	        {Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00"},
+//	    },
+//	    Holidays: []string{"2024-12-25", "2024-12-26"},
+//	})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	open := cal.Contains(time.Now())
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateLayout is the format of holiday dates.
+const DateLayout = "2006-01-02"
+
+// weekdays maps accepted day names to weekdays.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// Config is the textual form of a calendar, as read from configuration.
+type Config struct {
+	// Timezone is an IANA time zone name; empty means UTC
+	Timezone string
+	// Hours lists the working hours and the days they apply to
+	Hours []HoursConfig
+	// Holidays lists dates (YYYY-MM-DD) without working hours
+	Holidays []string
+}
+
+// HoursConfig is one range of working hours, e.g. "08:00" to "18:00" on
+// "mon" to "fri". End may be "24:00"; ranges cannot span midnight.
+type HoursConfig struct {
+	Days  []string
+	Start string
+	End   string
+}
+
+// Hours is a range of working hours on a set of weekdays. Start and End
+// are offsets from local midnight; the range includes Start and excludes
+// End.
+type Hours struct {
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+// Calendar is a validated business calendar.
+type Calendar struct {
+	// Location is the time zone working hours are given in
+	Location *time.Location
+	// Hours are the working hours; a time is within business hours if any
+	// range contains it
+	Hours []Hours
+	// Holidays are the dates without working hours, in DateLayout
+	Holidays []string
+}
+
+// New validates cfg and builds a calendar from it.
+func New(cfg Config) (*Calendar, error) {
+	cal := &Calendar{Location: time.UTC}
+
+	if cfg.Timezone != "" {
+		if cfg.Timezone == "Local" {
+			return nil, fmt.Errorf("timezone must be an IANA name, not %q", cfg.Timezone)
+		}
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", cfg.Timezone, err)
+		}
+		cal.Location = loc
+	}
+
+	if len(cfg.Hours) == 0 {
+		return nil, fmt.Errorf("at least one range of working hours is required")
+	}
+	for i, h := range cfg.Hours {
+		hours, err := parseHours(h)
+		if err != nil {
+			return nil, fmt.Errorf("hours[%d]: %w", i, err)
+		}
+		cal.Hours = append(cal.Hours, hours)
+	}
+
+	for _, value := range cfg.Holidays {
+		date, err := time.Parse(DateLayout, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid holiday %q, expected YYYY-MM-DD", value)
+		}
+		cal.Holidays = append(cal.Holidays, date.Format(DateLayout))
+	}
+
+	return cal, nil
+}
+
+// Contains reports whether t falls within the calendar's business hours.
+func (c *Calendar) Contains(t time.Time) bool {
+	local := t.In(c.Location)
+
+	date := local.Format(DateLayout)
+	for _, holiday := range c.Holidays {
+		if holiday == date {
+			return false
+		}
+	}
+
+	clock := time.Duration(local.Hour())*time.Hour +
+		time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second +
+		time.Duration(local.Nanosecond())
+	for _, h := range c.Hours {
+		if clock < h.Start || clock >= h.End {
+			continue
+		}
+		for _, day := range h.Days {
+			if day == local.Weekday() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseHours validates one range of working hours.
+func parseHours(cfg HoursConfig) (Hours, error) {
+	var hours Hours
+
+	if len(cfg.Days) == 0 {
+		return hours, fmt.Errorf("days are required")
+	}
+	for _, name := range cfg.Days {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return hours, fmt.Errorf("invalid day %q", name)
+		}
+		hours.Days = append(hours.Days, day)
+	}
+
+	var err error
+	if hours.Start, err = parseClock(cfg.Start); err != nil {
+		return hours, fmt.Errorf("invalid start: %w", err)
+	}
+	if hours.End, err = parseClock(cfg.End); err != nil {
+		return hours, fmt.Errorf("invalid end: %w", err)
+	}
+	if hours.Start >= hours.End {
+		return hours, fmt.Errorf("start %s must be before end %s", cfg.Start, cfg.End)
+	}
+
+	return hours, nil
+}
+
+// parseClock parses a wall clock time such as "08:30" into an offset from
+// midnight. "24:00" denotes the end of the day.
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a HH:MM time", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
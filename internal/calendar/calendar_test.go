@@ -0,0 +1,111 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	weekdays := []string{"mon", "tue", "wed", "thu", "fri"}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{
+			name: "valid",
+			cfg: Config{
+				Timezone: "Europe/Berlin",
+				Hours:    []HoursConfig{{Days: weekdays, Start: "08:00", End: "24:00"}},
+				Holidays: []string{"2024-12-25"},
+			},
+		},
+		{
+			name:    "unknown timezone",
+			cfg:     Config{Timezone: "Mars/Olympus", Hours: []HoursConfig{{Days: weekdays, Start: "08:00", End: "18:00"}}},
+			wantErr: `invalid timezone "Mars/Olympus"`,
+		},
+		{
+			name:    "local timezone",
+			cfg:     Config{Timezone: "Local", Hours: []HoursConfig{{Days: weekdays, Start: "08:00", End: "18:00"}}},
+			wantErr: "IANA name",
+		},
+		{
+			name:    "no hours",
+			cfg:     Config{},
+			wantErr: "at least one range",
+		},
+		{
+			name:    "unknown day",
+			cfg:     Config{Hours: []HoursConfig{{Days: []string{"someday"}, Start: "08:00", End: "18:00"}}},
+			wantErr: `hours[0]: invalid day "someday"`,
+		},
+		{
+			name:    "invalid clock",
+			cfg:     Config{Hours: []HoursConfig{{Days: weekdays, Start: "8am", End: "18:00"}}},
+			wantErr: "invalid start",
+		},
+		{
+			name:    "spans midnight",
+			cfg:     Config{Hours: []HoursConfig{{Days: weekdays, Start: "22:00", End: "06:00"}}},
+			wantErr: "must be before end",
+		},
+		{
+			name:    "invalid holiday",
+			cfg:     Config{Hours: []HoursConfig{{Days: weekdays, Start: "08:00", End: "18:00"}}, Holidays: []string{"25.12.2024"}},
+			wantErr: `invalid holiday "25.12.2024"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	cal, err := New(Config{
+		Timezone: "Europe/Berlin",
+		Hours: []HoursConfig{
+			{Days: []string{"Monday", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00"},
+			{Days: []string{"sat"}, Start: "09:00", End: "12:30"},
+		},
+		Holidays: []string{"2024-12-25"},
+	})
+	require.NoError(t, err)
+
+	berlin := cal.Location
+	tests := []struct {
+		name string
+		time time.Time
+		want bool
+	}{
+		{name: "weekday morning", time: time.Date(2024, 12, 2, 8, 0, 0, 0, berlin), want: true},
+		{name: "before opening", time: time.Date(2024, 12, 2, 7, 59, 59, 0, berlin), want: false},
+		{name: "at closing", time: time.Date(2024, 12, 2, 18, 0, 0, 0, berlin), want: false},
+		{name: "saturday", time: time.Date(2024, 12, 7, 12, 0, 0, 0, berlin), want: true},
+		{name: "saturday afternoon", time: time.Date(2024, 12, 7, 13, 0, 0, 0, berlin), want: false},
+		{name: "sunday", time: time.Date(2024, 12, 8, 10, 0, 0, 0, berlin), want: false},
+		{name: "holiday", time: time.Date(2024, 12, 25, 10, 0, 0, 0, berlin), want: false},
+		// 07:30 UTC is 08:30 in Berlin during winter time
+		{name: "converted to the calendar zone", time: time.Date(2024, 12, 2, 7, 30, 0, 0, time.UTC), want: true},
+		// 06:30 UTC is 08:30 in Berlin during summer time
+		{name: "daylight saving time", time: time.Date(2024, 7, 1, 6, 30, 0, 0, time.UTC), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cal.Contains(tt.time))
+		})
+	}
+}
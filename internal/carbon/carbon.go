@@ -0,0 +1,151 @@
+// Package carbon converts energy consumption into greenhouse gas emissions
+// using grid carbon-intensity factors.
+//
+// Intensity is given in grams of CO2e per kWh and comes from a Source:
+// either a static schedule of factors, each in effect until the next one,
+// or an external feed queried for the range being reported. Consumption is
+// converted to kWh with a configurable factor per stored unit.
+//
+// Example Usage:
+//
+//	source, err := carbon.NewStaticSource([]carbon.Step{
+//	    {Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Intensity: 380},
+//	})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	schedule, err := source.Schedule(ctx, start, end)
+//	if err != nil {
+//	    return err
+//	}
+//	emissions, err := carbon.Compute(hourlyConsumption, schedule, 1.0, 24*time.Hour)
+package carbon
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Step is a carbon intensity that applies from Start until the next step.
+type Step struct {
+	Start time.Time
+	// Intensity is in grams of CO2e per kWh
+	Intensity float64
+}
+
+// Schedule is a list of steps ordered by start time.
+type Schedule []Step
+
+// At returns the intensity in effect at t, and false if t is before the
+// first step.
+func (s Schedule) At(t time.Time) (float64, bool) {
+	// Index of the first step starting after t
+	i := sort.Search(len(s), func(i int) bool { return s[i].Start.After(t) })
+	if i == 0 {
+		return 0, false
+	}
+	return s[i-1].Intensity, true
+}
+
+// Source provides the carbon intensity schedule for a time range.
+type Source interface {
+	// Schedule returns steps covering [start, end], including the step in
+	// effect at start.
+	Schedule(ctx context.Context, start, end time.Time) (Schedule, error)
+}
+
+// StaticSource is a fixed schedule of intensity factors.
+type StaticSource struct {
+	schedule Schedule
+}
+
+// NewStaticSource validates steps and returns a source serving them. Steps
+// may be given in any order.
+func NewStaticSource(steps []Step) (*StaticSource, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("carbon intensity schedule is empty")
+	}
+
+	schedule := make(Schedule, len(steps))
+	copy(schedule, steps)
+	sort.Slice(schedule, func(i, j int) bool { return schedule[i].Start.Before(schedule[j].Start) })
+
+	for i, step := range schedule {
+		if err := validateIntensity(step.Intensity); err != nil {
+			return nil, err
+		}
+		if i > 0 && step.Start.Equal(schedule[i-1].Start) {
+			return nil, fmt.Errorf("duplicate carbon intensity step at %s", step.Start.Format(time.RFC3339))
+		}
+	}
+
+	return &StaticSource{schedule: schedule}, nil
+}
+
+// Schedule returns the whole static schedule.
+func (s *StaticSource) Schedule(context.Context, time.Time, time.Time) (Schedule, error) {
+	return s.schedule, nil
+}
+
+// Emission is the consumption and emissions of one bucket.
+type Emission struct {
+	Time time.Time
+	// Consumption is the energy used, in stored units
+	Consumption float64
+	// Intensity is the consumption-weighted carbon intensity of the
+	// bucket, in grams of CO2e per kWh
+	Intensity float64
+	// Kilograms is the emitted CO2e
+	Kilograms float64
+}
+
+// Compute converts consumption into emissions. Each consumption point is
+// an energy total starting at its time and is charged at the intensity in
+// effect then, so points should be no coarser than the schedule's steps.
+// kWhPerUnit converts stored units to kWh. Points are summed into buckets
+// of window, aligned to UTC.
+func Compute(consumption []models.TimeSeriesData, schedule Schedule, kWhPerUnit float64, window time.Duration) ([]Emission, error) {
+	var emissions []Emission
+	index := make(map[int64]int)
+
+	for _, point := range consumption {
+		intensity, ok := schedule.At(point.Time)
+		if !ok {
+			return nil, fmt.Errorf("no carbon intensity for %s", point.Time.UTC().Format(time.RFC3339))
+		}
+
+		bucket := point.Time.UTC().Truncate(window)
+		i, ok := index[bucket.UnixNano()]
+		if !ok {
+			i = len(emissions)
+			index[bucket.UnixNano()] = i
+			emissions = append(emissions, Emission{Time: bucket})
+		}
+
+		emissions[i].Consumption += point.Value
+		emissions[i].Kilograms += point.Value * kWhPerUnit * intensity / 1000
+	}
+
+	for i, e := range emissions {
+		if kWh := e.Consumption * kWhPerUnit; kWh != 0 {
+			emissions[i].Intensity = e.Kilograms * 1000 / kWh
+		}
+	}
+
+	sort.Slice(emissions, func(i, j int) bool { return emissions[i].Time.Before(emissions[j].Time) })
+	return emissions, nil
+}
+
+// validateIntensity rejects intensities no grid can have.
+func validateIntensity(intensity float64) error {
+	if intensity < 0 || math.IsNaN(intensity) || math.IsInf(intensity, 0) {
+		return fmt.Errorf("invalid carbon intensity: %v", intensity)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package carbon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+var base = time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+
+func TestStaticSource(t *testing.T) {
+	source, err := NewStaticSource([]Step{
+		{Start: base.Add(2 * time.Hour), Intensity: 100},
+		{Start: base, Intensity: 400},
+	})
+	require.NoError(t, err)
+
+	schedule, err := source.Schedule(context.Background(), base, base.Add(time.Hour))
+	require.NoError(t, err)
+
+	tests := []struct {
+		time  time.Time
+		want  float64
+		found bool
+	}{
+		{time: base.Add(-time.Second), found: false},
+		{time: base, want: 400, found: true},
+		{time: base.Add(2*time.Hour - time.Second), want: 400, found: true},
+		{time: base.Add(2 * time.Hour), want: 100, found: true},
+		{time: base.AddDate(1, 0, 0), want: 100, found: true},
+	}
+	for _, tt := range tests {
+		got, found := schedule.At(tt.time)
+		assert.Equal(t, tt.found, found, tt.time)
+		assert.Equal(t, tt.want, got, tt.time)
+	}
+
+	_, err = NewStaticSource(nil)
+	assert.ErrorContains(t, err, "empty")
+	_, err = NewStaticSource([]Step{{Start: base, Intensity: -1}})
+	assert.ErrorContains(t, err, "invalid carbon intensity")
+	_, err = NewStaticSource([]Step{{Start: base, Intensity: 1}, {Start: base, Intensity: 2}})
+	assert.ErrorContains(t, err, "duplicate")
+}
+
+func TestCompute(t *testing.T) {
+	schedule := Schedule{
+		{Start: base, Intensity: 400},
+		{Start: base.Add(time.Hour), Intensity: 100},
+	}
+
+	// Hourly consumption in Wh
+	consumption := []models.TimeSeriesData{
+		{Time: base, Value: 1000},
+		{Time: base.Add(time.Hour), Value: 3000},
+		{Time: base.Add(24 * time.Hour), Value: 2000},
+	}
+
+	emissions, err := Compute(consumption, schedule, 0.001, 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, emissions, 2)
+
+	// 1 kWh at 400 g/kWh plus 3 kWh at 100 g/kWh
+	assert.Equal(t, base, emissions[0].Time)
+	assert.Equal(t, 4000.0, emissions[0].Consumption)
+	assert.InDelta(t, 0.7, emissions[0].Kilograms, 1e-9)
+	assert.InDelta(t, 175, emissions[0].Intensity, 1e-9)
+
+	assert.Equal(t, base.Add(24*time.Hour), emissions[1].Time)
+	assert.InDelta(t, 0.2, emissions[1].Kilograms, 1e-9)
+	assert.InDelta(t, 100, emissions[1].Intensity, 1e-9)
+
+	_, err = Compute([]models.TimeSeriesData{{Time: base.Add(-time.Hour), Value: 1}}, schedule, 1, time.Hour)
+	assert.ErrorContains(t, err, "no carbon intensity for 2024-11-22T23:00:00Z")
+}
+
+func TestFeedSource(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"result": [{"time": 1732320000, "value": 250}, {"time": 1732323600, "value": 300}]}`))
+	}))
+	defer srv.Close()
+
+	decoder, err := api.NewDecoder(api.DecoderConfig{})
+	require.NoError(t, err)
+
+	source := NewFeedSource(srv.URL, decoder)
+	schedule, err := source.Schedule(context.Background(), base, base.Add(2*time.Hour))
+	require.NoError(t, err)
+
+	assert.Equal(t, "start=2024-11-22T00:00:00&end=2024-11-23T02:00:00", query)
+	require.Len(t, schedule, 2)
+	assert.True(t, schedule[0].Start.Equal(base))
+	assert.Equal(t, 300.0, schedule[1].Intensity)
+
+	t.Run("error status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+
+		_, err := NewFeedSource(srv.URL, decoder).Schedule(context.Background(), base, base.Add(time.Hour))
+		assert.ErrorContains(t, err, "carbon intensity feed returned 502")
+	})
+}
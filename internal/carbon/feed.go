@@ -0,0 +1,85 @@
+package carbon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// feedLookback is how far before the requested range a feed is queried,
+// so that the step in effect at the start of the range is included
+const feedLookback = 24 * time.Hour
+
+// feedTimeout bounds a single feed request
+const feedTimeout = 30 * time.Second
+
+// FeedSource reads carbon intensity from an external HTTP feed. The feed is
+// queried with start and end parameters in the same format as the upstream
+// series API, and each point of the response is a step whose value is the
+// intensity in grams of CO2e per kWh.
+type FeedSource struct {
+	url     string
+	decoder api.Decoder
+	client  *http.Client
+}
+
+// NewFeedSource creates a source for the feed at url, whose responses are
+// read with decoder.
+func NewFeedSource(url string, decoder api.Decoder) *FeedSource {
+	return &FeedSource{
+		url:     url,
+		decoder: decoder,
+		client:  http.DefaultClient,
+	}
+}
+
+// Schedule fetches the feed's steps from a day before start until end.
+func (f *FeedSource) Schedule(ctx context.Context, start, end time.Time) (Schedule, error) {
+	ctx, cancel := context.WithTimeout(ctx, feedTimeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s?start=%s&end=%s",
+		f.url,
+		start.Add(-feedLookback).Format("2006-01-02T15:04:05"),
+		end.Format("2006-01-02T15:04:05"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create carbon intensity request: %w", err)
+	}
+	req.Header.Set("User-Agent", "EdgeCom-Client/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("carbon intensity feed request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("carbon intensity feed returned %d", resp.StatusCode)
+	}
+
+	var steps []Step
+	err = f.decoder.Decode(resp.Body, func(point models.TimeSeriesData) error {
+		if err := validateIntensity(point.Value); err != nil {
+			return err
+		}
+		steps = append(steps, Step{Start: point.Time, Intensity: point.Value})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode carbon intensity feed: %w", err)
+	}
+
+	static, err := NewStaticSource(steps)
+	if err != nil {
+		return nil, err
+	}
+	return static.schedule, nil
+}
@@ -0,0 +1,41 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	clk := NewFake(start)
+	assert.Equal(t, start, clk.Now())
+
+	clk.Advance(5 * time.Minute)
+	assert.Equal(t, start.Add(5*time.Minute), clk.Now())
+
+	clk.Set(start)
+	assert.Equal(t, start, clk.Now())
+}
+
+func TestVirtual(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	clk := NewVirtual(start, 1)
+
+	first := clk.Now()
+	assert.WithinDuration(t, start, first, time.Second)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, clk.Now().After(first), "virtual clocks advance with the wall clock")
+	assert.Equal(t, time.Minute, Wall(clk, time.Minute))
+
+	fast := NewVirtual(start, 3600)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, fast.Now().Sub(start) >= 36*time.Second, "an hour passes every wall second")
+	assert.Equal(t, time.Second, Wall(fast, time.Hour))
+	assert.Equal(t, time.Hour, Wall(System, time.Hour))
+}
+
+func TestSystem(t *testing.T) {
+	assert.WithinDuration(t, time.Now(), System.Now(), time.Second)
+}
@@ -0,0 +1,282 @@
+// Package codec compresses blocks of time series points, so that data kept
+// on storage-constrained edge hardware, such as cold-storage exports,
+// takes a fraction of its raw size.
+//
+// Codecs:
+//   - none: 16 bytes per point, the timestamp and value as they are
+//   - delta: byte-aligned varints of the timestamps' delta-of-delta and of
+//     each value XORed with the previous one, bit-reversed so that values
+//     differing in few significant bits take few bytes; fast and simple
+//   - gorilla: the bit-packed encoding of Facebook's Gorilla, with
+//     delta-of-delta timestamps in variable-length buckets and XORed values
+//     storing only their meaningful bits; a regularly sampled series
+//     takes under 7 bytes per point with noisy values and a fraction of a
+//     byte when they repeat
+//
+// Every block is self-contained: it starts with its point count and the
+// unit its timestamps are stored in, the coarsest of seconds,
+// milliseconds, microseconds and nanoseconds that loses no precision.
+// Points decode in the order they were encoded, with UTC timestamps and
+// the exact bits of every value, NaN included.
+//
+// Further codecs can be added with Register and are found, like the
+// built-in ones, by Lookup.
+//
+// Example Usage:
+//
+//	c, err := codec.Lookup("gorilla")
+//	if err != nil {
+//	    return err
+//	}
+//	block := c.Encode(nil, points)
+//	decoded, err := c.Decode(block)
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Codec encodes blocks of points.
+type Codec interface {
+	// Name identifies the codec in configuration and in encoded files
+	Name() string
+	// Encode appends the encoding of points to dst and returns it
+	Encode(dst []byte, points []models.TimeSeriesData) []byte
+	// Decode returns the points of a block returned by Encode
+	Decode(src []byte) ([]models.TimeSeriesData, error)
+}
+
+// Built-in codecs
+var (
+	None    Codec = noneCodec{}
+	Delta   Codec = deltaCodec{}
+	Gorilla Codec = gorillaCodec{}
+)
+
+// DefaultName is the codec used when none is configured.
+const DefaultName = "gorilla"
+
+// ErrCorrupt is returned when a block cannot be decoded.
+var ErrCorrupt = errors.New("corrupt block")
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{
+		None.Name():    None,
+		Delta.Name():   Delta,
+		Gorilla.Name(): Gorilla,
+	}
+)
+
+// Register makes c available to Lookup. It fails if a codec of the same
+// name is already registered.
+func Register(c Codec) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if c.Name() == "" {
+		return fmt.Errorf("codec name is required")
+	}
+	if _, ok := registry[c.Name()]; ok {
+		return fmt.Errorf("codec %q is already registered", c.Name())
+	}
+	registry[c.Name()] = c
+	return nil
+}
+
+// Lookup returns the codec registered as name, or the default codec when
+// name is empty.
+func Lookup(name string) (Codec, error) {
+	if name == "" {
+		name = DefaultName
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q, expected one of %s", name, strings.Join(namesLocked(), ", "))
+	}
+	return c, nil
+}
+
+// Names returns the names of the registered codecs, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return namesLocked()
+}
+
+func namesLocked() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// timeUnits are the units timestamps can be stored in, coarsest first, as
+// their power of ten in nanoseconds
+var timeUnits = []int64{9, 6, 3, 0}
+
+// pow10 returns 10^exp
+func pow10(exp int64) int64 {
+	n := int64(1)
+	for i := int64(0); i < exp; i++ {
+		n *= 10
+	}
+	return n
+}
+
+// timeUnit returns the power of ten of the coarsest unit all timestamps
+// of points are a whole number of
+func timeUnit(points []models.TimeSeriesData) int64 {
+	for _, exp := range timeUnits {
+		unit := pow10(exp)
+		exact := true
+		for _, point := range points {
+			if point.Time.UnixNano()%unit != 0 {
+				exact = false
+				break
+			}
+		}
+		if exact {
+			return exp
+		}
+	}
+	return 0
+}
+
+// appendHeader appends the point count and timestamp unit of a block,
+// returning the unit's length in nanoseconds
+func appendHeader(dst []byte, points []models.TimeSeriesData) ([]byte, int64) {
+	exp := timeUnit(points)
+	dst = binary.AppendUvarint(dst, uint64(len(points)))
+	dst = append(dst, byte(exp))
+	return dst, pow10(exp)
+}
+
+// readHeader reads the header of a block, returning the point count, the
+// timestamp unit's length in nanoseconds and the rest of the block.
+// minBits is the least number of bits a point takes, which bounds the
+// count of a valid block.
+func readHeader(src []byte, minBits int) (int, int64, []byte, error) {
+	count, n := binary.Uvarint(src)
+	if n <= 0 || n >= len(src) {
+		return 0, 0, nil, fmt.Errorf("%w: invalid header", ErrCorrupt)
+	}
+	exp := int64(src[n])
+	valid := false
+	for _, unit := range timeUnits {
+		valid = valid || exp == unit
+	}
+	if !valid {
+		return 0, 0, nil, fmt.Errorf("%w: invalid time unit %d", ErrCorrupt, exp)
+	}
+	rest := src[n+1:]
+	if count > uint64(len(rest))*8/uint64(minBits)+1 {
+		return 0, 0, nil, fmt.Errorf("%w: %d points cannot fit in %d bytes", ErrCorrupt, count, len(rest))
+	}
+	return int(count), pow10(exp), rest, nil
+}
+
+// point returns the point stored as a timestamp in units and value bits
+func point(t, unit int64, value uint64) models.TimeSeriesData {
+	return models.TimeSeriesData{
+		Time:  time.Unix(0, t*unit).UTC(),
+		Value: math.Float64frombits(value),
+	}
+}
+
+// noneCodec stores points uncompressed
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return "none" }
+
+func (noneCodec) Encode(dst []byte, points []models.TimeSeriesData) []byte {
+	dst, unit := appendHeader(dst, points)
+	for _, p := range points {
+		dst = binary.BigEndian.AppendUint64(dst, uint64(p.Time.UnixNano()/unit))
+		dst = binary.BigEndian.AppendUint64(dst, math.Float64bits(p.Value))
+	}
+	return dst
+}
+
+func (noneCodec) Decode(src []byte) ([]models.TimeSeriesData, error) {
+	count, unit, rest, err := readHeader(src, 128)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != count*16 {
+		return nil, fmt.Errorf("%w: %d bytes for %d points", ErrCorrupt, len(rest), count)
+	}
+	points := make([]models.TimeSeriesData, count)
+	for i := range points {
+		t := int64(binary.BigEndian.Uint64(rest[i*16:]))
+		points[i] = point(t, unit, binary.BigEndian.Uint64(rest[i*16+8:]))
+	}
+	return points, nil
+}
+
+// deltaCodec stores the delta-of-delta of timestamps and the bit-reversed
+// XOR of consecutive values as varints
+type deltaCodec struct{}
+
+func (deltaCodec) Name() string { return "delta" }
+
+func (deltaCodec) Encode(dst []byte, points []models.TimeSeriesData) []byte {
+	dst, unit := appendHeader(dst, points)
+	var prevTime, prevDelta int64
+	var prevBits uint64
+	for _, p := range points {
+		t := p.Time.UnixNano() / unit
+		delta := t - prevTime
+		dst = binary.AppendVarint(dst, delta-prevDelta)
+		prevTime, prevDelta = t, delta
+
+		value := math.Float64bits(p.Value)
+		dst = binary.AppendUvarint(dst, bits.Reverse64(value^prevBits))
+		prevBits = value
+	}
+	return dst
+}
+
+func (deltaCodec) Decode(src []byte) ([]models.TimeSeriesData, error) {
+	count, unit, rest, err := readHeader(src, 16)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]models.TimeSeriesData, 0, count)
+	var prevTime, prevDelta int64
+	var prevBits uint64
+	for i := 0; i < count; i++ {
+		dod, n := binary.Varint(rest)
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: truncated timestamp of point %d", ErrCorrupt, i)
+		}
+		rest = rest[n:]
+		xor, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: truncated value of point %d", ErrCorrupt, i)
+		}
+		rest = rest[n:]
+
+		prevDelta += dod
+		prevTime += prevDelta
+		prevBits ^= bits.Reverse64(xor)
+		points = append(points, point(prevTime, unit, prevBits))
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrCorrupt, len(rest))
+	}
+	return points, nil
+}
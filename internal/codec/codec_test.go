@@ -0,0 +1,136 @@
+package codec
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+var base = time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+
+// regular returns a minutely series of slowly changing readings
+func regular(n int) []models.TimeSeriesData {
+	points := make([]models.TimeSeriesData, n)
+	for i := range points {
+		points[i] = models.TimeSeriesData{
+			Time:  base.Add(time.Duration(i) * time.Minute),
+			Value: 100 + float64(i%10)*0.25,
+		}
+	}
+	return points
+}
+
+// assertEqualPoints compares points by timestamp and value bits, so NaN
+// values compare equal
+func assertEqualPoints(t *testing.T, expected, actual []models.TimeSeriesData) {
+	t.Helper()
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		assert.True(t, expected[i].Time.Equal(actual[i].Time), "time of point %d: %v != %v", i, expected[i].Time, actual[i].Time)
+		assert.Equal(t, time.UTC, actual[i].Time.Location())
+		assert.Equal(t, math.Float64bits(expected[i].Value), math.Float64bits(actual[i].Value), "value of point %d", i)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]models.TimeSeriesData, 500)
+	at := base
+	for i := range random {
+		at = at.Add(time.Duration(rng.Int63n(int64(time.Hour))))
+		random[i] = models.TimeSeriesData{Time: at, Value: rng.NormFloat64() * 1e6}
+	}
+
+	series := map[string][]models.TimeSeriesData{
+		"empty":   nil,
+		"single":  regular(1),
+		"regular": regular(1000),
+		"random":  random,
+		"special values": {
+			{Time: base, Value: math.NaN()},
+			{Time: base.Add(time.Second), Value: math.Inf(1)},
+			{Time: base.Add(2 * time.Second), Value: math.Inf(-1)},
+			{Time: base.Add(3 * time.Second), Value: math.Copysign(0, -1)},
+			{Time: base.Add(4 * time.Second), Value: math.MaxFloat64},
+			{Time: base.Add(5 * time.Second), Value: math.SmallestNonzeroFloat64},
+		},
+		"unordered and sub-second": {
+			{Time: base.Add(time.Hour), Value: 1},
+			{Time: base.Add(500 * time.Millisecond), Value: 2},
+			{Time: base.Add(time.Nanosecond), Value: 3},
+			{Time: time.Unix(0, 0), Value: 4},
+			{Time: base.Add(10000 * time.Hour), Value: 5},
+		},
+	}
+
+	for _, c := range []Codec{None, Delta, Gorilla} {
+		for name, points := range series {
+			t.Run(c.Name()+"/"+name, func(t *testing.T) {
+				block := c.Encode(nil, points)
+				decoded, err := c.Decode(block)
+				require.NoError(t, err)
+				assertEqualPoints(t, points, decoded)
+			})
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	points := regular(1000)
+	raw := len(None.Encode(nil, points))
+	delta := len(Delta.Encode(nil, points))
+	gorilla := len(Gorilla.Encode(nil, points))
+
+	assert.Less(t, delta, raw/2, "delta")
+	assert.Less(t, gorilla, delta, "gorilla")
+	assert.Less(t, gorilla, raw/4, "gorilla")
+}
+
+func TestEncodeAppends(t *testing.T) {
+	prefix := []byte("block:")
+	block := Gorilla.Encode(prefix, regular(3))
+	assert.Equal(t, prefix, block[:len(prefix)])
+
+	decoded, err := Gorilla.Decode(block[len(prefix):])
+	require.NoError(t, err)
+	assertEqualPoints(t, regular(3), decoded)
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	for _, c := range []Codec{None, Delta, Gorilla} {
+		t.Run(c.Name(), func(t *testing.T) {
+			block := c.Encode(nil, regular(100))
+
+			_, err := c.Decode(block[:len(block)/2])
+			assert.ErrorIs(t, err, ErrCorrupt, "truncated")
+
+			_, err = c.Decode(nil)
+			assert.ErrorIs(t, err, ErrCorrupt, "empty")
+
+			// A count far beyond what the block could hold
+			_, err = c.Decode([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 9, 0})
+			assert.ErrorIs(t, err, ErrCorrupt, "oversized count")
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	c, err := Lookup("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultName, c.Name())
+
+	c, err = Lookup("delta")
+	require.NoError(t, err)
+	assert.Equal(t, Delta, c)
+
+	_, err = Lookup("zstd")
+	assert.ErrorContains(t, err, `unknown codec "zstd", expected one of delta, gorilla, none`)
+
+	assert.ErrorContains(t, Register(noneCodec{}), "already registered")
+}
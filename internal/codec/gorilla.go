@@ -0,0 +1,227 @@
+package codec
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// dodBuckets are the delta-of-delta timestamp encodings of the gorilla
+// codec after the zero one: a prefix of ones ended by a zero, except for
+// the last, followed by the value in that many bits
+var dodBuckets = []struct {
+	prefix     uint64
+	prefixBits int
+	valueBits  int
+}{
+	{0b10, 2, 7},
+	{0b110, 3, 9},
+	{0b1110, 4, 12},
+	{0b11110, 5, 32},
+	{0b11111, 5, 64},
+}
+
+// gorillaCodec is the encoding of Facebook's Gorilla time series database
+type gorillaCodec struct{}
+
+func (gorillaCodec) Name() string { return "gorilla" }
+
+func (gorillaCodec) Encode(dst []byte, points []models.TimeSeriesData) []byte {
+	dst, unit := appendHeader(dst, points)
+	w := bitWriter{buf: dst}
+	var prevTime, prevDelta int64
+	var prevBits uint64
+	// leading and trailing are the zero bits around the meaningful bits of
+	// the previous XOR, -1 before the first
+	leading, trailing := -1, -1
+	for i, p := range points {
+		t := p.Time.UnixNano() / unit
+		value := math.Float64bits(p.Value)
+		if i == 0 {
+			w.writeBits(uint64(t), 64)
+			w.writeBits(value, 64)
+			prevTime, prevBits = t, value
+			continue
+		}
+
+		delta := t - prevTime
+		writeDoD(&w, delta-prevDelta)
+		prevTime, prevDelta = t, delta
+
+		xor := value ^ prevBits
+		prevBits = value
+		if xor == 0 {
+			w.writeBits(0, 1)
+			continue
+		}
+		w.writeBits(1, 1)
+		lz, tz := bits.LeadingZeros64(xor), bits.TrailingZeros64(xor)
+		if leading >= 0 && lz >= leading && tz >= trailing {
+			// The meaningful bits fit in the previous window
+			w.writeBits(0, 1)
+			w.writeBits(xor>>trailing, 64-leading-trailing)
+			continue
+		}
+		leading, trailing = lz, tz
+		meaningful := 64 - lz - tz
+		w.writeBits(1, 1)
+		w.writeBits(uint64(lz), 6)
+		w.writeBits(uint64(meaningful-1), 6)
+		w.writeBits(xor>>tz, meaningful)
+	}
+	return w.buf
+}
+
+// writeDoD writes a timestamp's delta-of-delta in the smallest bucket
+// holding it
+func writeDoD(w *bitWriter, dod int64) {
+	if dod == 0 {
+		w.writeBits(0, 1)
+		return
+	}
+	for _, bucket := range dodBuckets {
+		if bucket.valueBits == 64 || fitsSigned(dod, bucket.valueBits) {
+			w.writeBits(bucket.prefix, bucket.prefixBits)
+			w.writeBits(uint64(dod), bucket.valueBits)
+			return
+		}
+	}
+}
+
+// fitsSigned reports whether v is representable in n bits of two's
+// complement
+func fitsSigned(v int64, n int) bool {
+	limit := int64(1) << (n - 1)
+	return v >= -limit && v < limit
+}
+
+// signExtend returns the n-bit two's complement value v
+func signExtend(v uint64, n int) int64 {
+	shift := 64 - n
+	return int64(v<<shift) >> shift
+}
+
+func (gorillaCodec) Decode(src []byte) ([]models.TimeSeriesData, error) {
+	count, unit, rest, err := readHeader(src, 2)
+	if err != nil {
+		return nil, err
+	}
+	r := bitReader{buf: rest}
+	points := make([]models.TimeSeriesData, 0, count)
+	var prevTime, prevDelta int64
+	var prevBits uint64
+	leading, trailing := -1, -1
+	for i := 0; i < count; i++ {
+		if i == 0 {
+			t := int64(r.readBits(64))
+			prevBits = r.readBits(64)
+			prevTime = t
+			points = append(points, point(t, unit, prevBits))
+			continue
+		}
+
+		dod, err := readDoD(&r)
+		if err != nil {
+			return nil, err
+		}
+		prevDelta += dod
+		prevTime += prevDelta
+
+		if r.readBits(1) == 1 {
+			if r.readBits(1) == 1 {
+				leading = int(r.readBits(6))
+				meaningful := int(r.readBits(6)) + 1
+				trailing = 64 - leading - meaningful
+				if trailing < 0 {
+					return nil, fmt.Errorf("%w: invalid value window at point %d", ErrCorrupt, i)
+				}
+			} else if leading < 0 {
+				return nil, fmt.Errorf("%w: value window reused before being set at point %d", ErrCorrupt, i)
+			}
+			prevBits ^= r.readBits(64-leading-trailing) << trailing
+		}
+		if r.err != nil {
+			break
+		}
+		points = append(points, point(prevTime, unit, prevBits))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: truncated after %d points", ErrCorrupt, len(points))
+	}
+	return points, nil
+}
+
+// readDoD reads a timestamp's delta-of-delta
+func readDoD(r *bitReader) (int64, error) {
+	if r.readBits(1) == 0 {
+		return 0, r.err
+	}
+	prefix, prefixBits := uint64(1), 1
+	for _, bucket := range dodBuckets {
+		if prefixBits < bucket.prefixBits {
+			prefix = prefix<<1 | r.readBits(1)
+			prefixBits++
+		}
+		if prefix == bucket.prefix {
+			return signExtend(r.readBits(bucket.valueBits), bucket.valueBits), r.err
+		}
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, fmt.Errorf("%w: invalid timestamp encoding", ErrCorrupt)
+}
+
+// bitWriter appends bits to a byte slice, most significant first
+type bitWriter struct {
+	buf []byte
+	// free is the number of unused low bits of the last byte
+	free int
+}
+
+// writeBits writes the n low bits of v
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		if w.free == 0 {
+			w.buf = append(w.buf, 0)
+			w.free = 8
+		}
+		k := min(w.free, n)
+		chunk := (v >> (n - k)) & (1<<k - 1)
+		w.buf[len(w.buf)-1] |= byte(chunk << (w.free - k))
+		w.free -= k
+		n -= k
+	}
+}
+
+// bitReader reads bits written by bitWriter. Reading past the end sets
+// err and returns zeros.
+type bitReader struct {
+	buf []byte
+	// pos is the index of the next bit
+	pos int
+	err error
+}
+
+// readBits reads n bits
+func (r *bitReader) readBits(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+n > len(r.buf)*8 {
+		r.err = ErrCorrupt
+		return 0
+	}
+	var v uint64
+	for n > 0 {
+		available := 8 - r.pos%8
+		k := min(available, n)
+		chunk := uint64(r.buf[r.pos/8]>>(available-k)) & (1<<k - 1)
+		v = v<<k | chunk
+		r.pos += k
+		n -= k
+	}
+	return v
+}
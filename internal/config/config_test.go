@@ -1,11 +1,16 @@
 package config
 
 import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoad(t *testing.T) {
@@ -18,6 +23,55 @@ server:
   port: 8080
   host: "0.0.0.0"
 
+upstream:
+  format: "csv"
+  time_format: "rfc3339"
+  retry:
+    max_attempts: 5
+    max_delay: "30s"
+    retryable_status: [503]
+  circuit_breaker:
+    failure_threshold: 5
+    reset_timeout: "10m"
+
+cors:
+  allowed_origins:
+    - "https://dashboard.example.com"
+  max_age: 600
+
+bootstrap:
+  on_failure: "fail"
+  retry_schedule: ["30s", "2m"]
+
+ingest:
+  duplicate_policy: "average"
+
+calendars:
+  office:
+    timezone: "Europe/Berlin"
+    hours:
+      - days: ["mon", "fri"]
+        start: "08:00"
+        end: "18:00"
+    holidays: ["2024-12-25"]
+
+carbon:
+  kwh_per_unit: 0.001
+  schedule:
+    - from: "2024-01-01T00:00:00Z"
+      intensity: 380
+
+write_queue:
+  capacity: 5000
+
+shutdown:
+  timeout: "10s"
+
+tracing:
+  enabled: true
+  endpoint: "otel-collector:4317"
+  sample_ratio: 0.25
+
 database:
   host: "localhost"
   port: 5432
@@ -46,6 +100,30 @@ logging:
 	assert.Equal(t, "localhost", config.Database.Host)
 	assert.Equal(t, "testdb", config.Database.Name)
 	assert.Equal(t, "debug", config.Logging.Level)
+	assert.Equal(t, []string{"https://dashboard.example.com"}, config.CORS.AllowedOrigins)
+	assert.Equal(t, 600, config.CORS.MaxAge)
+	assert.Equal(t, "fail", config.Bootstrap.OnFailure)
+	assert.Equal(t, []string{"30s", "2m"}, config.Bootstrap.RetrySchedule)
+	assert.Equal(t, "csv", config.Upstream.Format)
+	assert.Equal(t, 5, config.Upstream.Retry.MaxAttempts)
+	assert.Equal(t, "30s", config.Upstream.Retry.MaxDelay)
+	assert.Equal(t, []int{503}, config.Upstream.Retry.RetryableStatus)
+	assert.Equal(t, 5, config.Upstream.CircuitBreaker.FailureThreshold)
+	assert.Equal(t, "10m", config.Upstream.CircuitBreaker.ResetTimeout)
+	assert.Equal(t, 0.001, config.Carbon.KWhPerUnit)
+	assert.Equal(t, "2024-01-01T00:00:00Z", config.Carbon.Schedule[0].From)
+	assert.Equal(t, 380.0, config.Carbon.Schedule[0].Intensity)
+	assert.Equal(t, "Europe/Berlin", config.Calendars["office"].Timezone)
+	assert.Equal(t, []string{"mon", "fri"}, config.Calendars["office"].Hours[0].Days)
+	assert.Equal(t, "18:00", config.Calendars["office"].Hours[0].End)
+	assert.Equal(t, []string{"2024-12-25"}, config.Calendars["office"].Holidays)
+	assert.Equal(t, "rfc3339", config.Upstream.TimeFormat)
+	assert.Equal(t, "average", config.Ingest.DuplicatePolicy)
+	assert.Equal(t, 5000, config.WriteQueue.Capacity)
+	assert.Equal(t, "10s", config.Shutdown.Timeout)
+	assert.True(t, config.Tracing.Enabled)
+	assert.Equal(t, "otel-collector:4317", config.Tracing.Endpoint)
+	assert.Equal(t, 0.25, config.Tracing.SampleRatio)
 }
 
 func TestLoadWithEnvOverride(t *testing.T) {
@@ -80,3 +158,217 @@ database:
 	assert.Equal(t, "envhost", config.Database.Host)
 	assert.Equal(t, 5433, config.Database.Port)
 }
+
+func TestLoadUpstreamAuthFromEnv(t *testing.T) {
+	t.Setenv("EDGECOM_API_TOKEN", "s3cret")
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `
+upstream:
+  auth:
+    type: "bearer"
+    token: "${EDGECOM_API_TOKEN}"
+  query_params:
+    site: "plant-7"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	config, err := Load(configPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, "bearer", config.Upstream.Auth.Type)
+	assert.Equal(t, "s3cret", config.Upstream.Auth.Token)
+	assert.Equal(t, map[string]string{"site": "plant-7"}, config.Upstream.QueryParams)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `
+server:
+  rate_limit: 20
+  cache_size: 500
+database:
+  host: "filehost"
+cors:
+  allowed_origins: ["https://file.example.com"]
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	t.Run("environment over file over defaults", func(t *testing.T) {
+		t.Setenv("EDGECOM_DATABASE_HOST", "envhost")
+		t.Setenv("EDGECOM_SERVER_CACHE_SIZE", "2000")
+		t.Setenv("EDGECOM_CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+		t.Setenv("EDGECOM_TRACING_ENABLED", "true")
+
+		config, err := Load(configPath)
+		require.NoError(t, err)
+		assert.Equal(t, 8080, config.Server.Port, "default")
+		assert.Equal(t, 20.0, config.Server.RateLimit, "file")
+		assert.Equal(t, 2000, config.Server.CacheSize)
+		assert.Equal(t, "envhost", config.Database.Host)
+		assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, config.CORS.AllowedOrigins)
+		assert.True(t, config.Tracing.Enabled)
+	})
+
+	t.Run("flags over environment", func(t *testing.T) {
+		t.Setenv("EDGECOM_SERVER_PORT", "9000")
+		t.Setenv("EDGECOM_SERVER_RATE_LIMIT", "30")
+
+		fs := flag.NewFlagSet("edgecom", flag.ContinueOnError)
+		flags := RegisterFlags(fs)
+		require.NoError(t, fs.Parse([]string{"-config", configPath, "-port", "7000", "-conn-string", "postgres://db/edgecom"}))
+		assert.Equal(t, configPath, flags.Path())
+
+		config, err := flags.Load()
+		require.NoError(t, err)
+		assert.Equal(t, 7000, config.Server.Port)
+		assert.Equal(t, 30.0, config.Server.RateLimit, "flags not given leave the environment in effect")
+		assert.Equal(t, "postgres://db/edgecom", config.Database.ConnectionString)
+	})
+
+	t.Run("invalid values name their source", func(t *testing.T) {
+		t.Setenv("EDGECOM_SERVER_CACHE_SIZE", "large")
+		_, err := Load(configPath)
+		assert.EqualError(t, err, `EDGECOM_SERVER_CACHE_SIZE: invalid integer "large"`)
+
+		fs := flag.NewFlagSet("edgecom", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		RegisterFlags(fs)
+		assert.ErrorContains(t, fs.Parse([]string{"-rate-limit", "fast"}), `invalid value "fast" for flag -rate-limit: invalid number "fast"`)
+	})
+}
+
+func TestLoadSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.URL.Path != "/v1/secret/data/edgecom" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"api_token":"vault-token","access_key":"AKIDVAULT"},"metadata":{"version":1}}}`))
+	}))
+	defer vault.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "db_password"), []byte("db-secret\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vault_token"), []byte("root\n"), 0o600))
+	t.Setenv("VAULT_ADDR", vault.URL)
+
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	t.Run("resolved from files and vault", func(t *testing.T) {
+		config, err := Load(write(t, `
+secrets:
+  vault:
+    token_file: "`+filepath.Join(dir, "vault_token")+`"
+upstream:
+  auth:
+    type: "bearer"
+    token: "${secret:vault:secret/data/edgecom#api_token}"
+database:
+  password_file: "`+filepath.Join(dir, "db_password")+`"
+destinations:
+  archive:
+    type: "s3"
+    access_key_id: "${secret:vault:secret/data/edgecom#access_key}"
+    secret_access_key: "${secret:file:`+filepath.Join(dir, "db_password")+`}"
+webhooks:
+  - name: "ops"
+    headers:
+      Authorization: "Bearer ${secret:vault:secret/data/edgecom#api_token}"
+`))
+		require.NoError(t, err)
+		assert.Equal(t, "db-secret", config.Database.Password)
+		assert.Equal(t, "vault-token", config.Upstream.Auth.Token)
+		assert.Equal(t, "AKIDVAULT", config.Destinations["archive"].AccessKeyID)
+		assert.Equal(t, "db-secret", config.Destinations["archive"].SecretAccessKey)
+		assert.Equal(t, "Bearer vault-token", config.Webhooks[0].Headers["Authorization"])
+	})
+
+	t.Run("references in the environment and flags", func(t *testing.T) {
+		t.Setenv("VAULT_TOKEN", "root")
+		t.Setenv("EDGECOM_DATABASE_PASSWORD", "${secret:vault:secret/data/edgecom#api_token}")
+
+		fs := flag.NewFlagSet("edgecom", flag.ContinueOnError)
+		flags := RegisterFlags(fs)
+		require.NoError(t, fs.Parse([]string{"-config", write(t, "server:\n  port: 8080\n"),
+			"-conn-string", "postgres://edgecom:${secret:file:" + filepath.Join(dir, "db_password") + "}@db/edgecom"}))
+
+		config, err := flags.Load()
+		require.NoError(t, err)
+		assert.Equal(t, "vault-token", config.Database.Password)
+		assert.Equal(t, "postgres://edgecom:db-secret@db/edgecom", config.Database.ConnectionString)
+	})
+
+	for name, test := range map[string]struct {
+		content string
+		err     string
+	}{
+		"password and password file": {
+			content: "database:\n  password: x\n  password_file: " + filepath.Join(dir, "db_password") + "\n",
+			err:     "database.password and database.password_file are mutually exclusive",
+		},
+		"missing password file": {
+			content: "database:\n  password_file: " + filepath.Join(dir, "missing") + "\n",
+			err:     "database.password_file: open",
+		},
+		"unknown provider": {
+			content: "database:\n  user: ${secret:consul:edgecom}\n",
+			err:     `database.user: unknown secret provider "consul", expected file, vault or aws`,
+		},
+		"secret not found": {
+			content: "upstream:\n  auth:\n    token: ${secret:vault:secret/data/other#token}\n",
+			err:     "upstream.auth.token: failed to read vault secret secret/data/other: 403 Forbidden",
+		},
+		"aws without credentials": {
+			content: "secrets:\n  aws:\n    region: eu-central-1\ndatabase:\n  password: ${secret:aws:edgecom/database#password}\n",
+			err:     "database.password: secrets.aws: an aws access key ID and secret access key are required",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("VAULT_TOKEN", "other")
+			t.Setenv("AWS_ACCESS_KEY_ID", "")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+			_, err := Load(write(t, test.content))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	for name, test := range map[string]struct {
+		content string
+		err     string
+	}{
+		"misspelled key": {
+			content: "server:\n  prot: 8080\n",
+			err:     "unknown key server.prot, expected one of port, host, url,",
+		},
+		"misspelled key in a list": {
+			content: "webhooks:\n  - name: ops\n    ur: http://ops\n",
+			err:     "unknown key webhooks[0].ur, expected one of name, url,",
+		},
+		"misspelled key in a map": {
+			content: "calendars:\n  office:\n    timezon: UTC\n",
+			err:     "unknown key calendars.office.timezon, expected one of timezone,",
+		},
+		"port out of range": {
+			content: "server:\n  port: 70000\n",
+			err:     "server.port must be between 1 and 65535, got 70000",
+		},
+		"negative limit": {
+			content: "server:\n  rate_limit: -1\n",
+			err:     "server.rate_limit must not be negative, got -1",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(configPath, []byte(test.content), 0644))
+			_, err := Load(configPath)
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
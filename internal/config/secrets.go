@@ -0,0 +1,252 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/secret"
+	"github.com/tejusbharadwaj/edgecom/internal/sigv4"
+)
+
+// secretTimeout bounds the lookups of the secrets referenced by a
+// configuration
+const secretTimeout = 10 * time.Second
+
+// secretPrefix starts a reference to a secret in a string value, as in
+// "${secret:vault:secret/data/edgecom#password}"
+const secretPrefix = "${secret:"
+
+// expandEnv replaces ${VARIABLE} and $VARIABLE references in s with the
+// values of environment variables, leaving secret references for
+// resolveSecrets
+func expandEnv(s string) string {
+	return os.Expand(s, func(name string) string {
+		if strings.HasPrefix(name, "secret:") {
+			return "${" + name + "}"
+		}
+		return os.Getenv(name)
+	})
+}
+
+// resolveSecrets reads database.password_file and replaces the secret
+// references in every string value outside the secrets section with the
+// secrets they name
+func (c *Config) resolveSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+
+	if c.Database.PasswordFile != "" {
+		if c.Database.Password != "" {
+			return fmt.Errorf("database.password and database.password_file are mutually exclusive")
+		}
+		password, err := secret.FileProvider{}.Lookup(ctx, c.Database.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("database.password_file: %w", err)
+		}
+		c.Database.Password = password
+	}
+
+	r := &secretResolver{config: c, providers: make(map[string]secret.Provider), values: make(map[string]string)}
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := yamlName(v.Type().Field(i))
+		if name == "" || name == "secrets" {
+			continue
+		}
+		if err := r.resolveValue(ctx, v.Field(i), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretResolver replaces secret references, creating the providers they
+// use on first use and looking up each reference once
+type secretResolver struct {
+	config    *Config
+	providers map[string]secret.Provider
+	values    map[string]string
+}
+
+// resolveValue resolves the references in the strings within v, the
+// value at path
+func (r *secretResolver) resolveValue(ctx context.Context, v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !strings.Contains(v.String(), secretPrefix) {
+			return nil
+		}
+		resolved, err := r.resolve(ctx, v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetString(resolved)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if name := yamlName(v.Type().Field(i)); name != "" {
+				if err := r.resolveValue(ctx, v.Field(i), path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.resolveValue(ctx, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return r.resolveValue(ctx, v.Elem(), path)
+		}
+	case reflect.Map:
+		entries := make(map[string]reflect.Value, v.Len())
+		for _, key := range v.MapKeys() {
+			entries[key.String()] = key
+		}
+		for _, name := range sortedKeys(entries) {
+			key := entries[name]
+			// Map entries cannot be set in place
+			entry := reflect.New(v.Type().Elem()).Elem()
+			entry.Set(v.MapIndex(key))
+			if err := r.resolveValue(ctx, entry, path+"."+name); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, entry)
+		}
+	}
+	return nil
+}
+
+// resolve replaces every secret reference in s
+func (r *secretResolver) resolve(ctx context.Context, s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, secretPrefix)
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated secret reference")
+		}
+		ref := s[start+len(secretPrefix) : start+end]
+		value, err := r.lookup(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+// lookup returns the secret referenced by ref, "provider:reference"
+func (r *secretResolver) lookup(ctx context.Context, ref string) (string, error) {
+	if value, ok := r.values[ref]; ok {
+		return value, nil
+	}
+	name, key, ok := strings.Cut(ref, ":")
+	if !ok || key == "" {
+		return "", fmt.Errorf("invalid secret reference %q, expected provider:reference", ref)
+	}
+	provider, err := r.provider(name)
+	if err != nil {
+		return "", err
+	}
+	value, err := provider.Lookup(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	r.values[ref] = value
+	return value, nil
+}
+
+// provider returns the provider called name, creating it from the
+// secrets section on first use
+func (r *secretResolver) provider(name string) (secret.Provider, error) {
+	if p, ok := r.providers[name]; ok {
+		return p, nil
+	}
+	var p secret.Provider
+	var err error
+	switch name {
+	case "file":
+		p = secret.FileProvider{}
+	case "vault":
+		p, err = r.config.vaultProvider()
+	case "aws":
+		p, err = r.config.awsProvider()
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q, expected file, vault or aws", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.providers[name] = p
+	return p, nil
+}
+
+// vaultProvider creates the Vault provider of secrets.vault, falling back
+// to the VAULT_ADDR and VAULT_TOKEN environment variables
+func (c *Config) vaultProvider() (*secret.Vault, error) {
+	cfg := c.Secrets.Vault
+	address := cfg.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token := cfg.Token
+	if cfg.TokenFile != "" {
+		if token != "" {
+			return nil, fmt.Errorf("secrets.vault: token and token_file are mutually exclusive")
+		}
+		var file secret.FileProvider
+		var err error
+		if token, err = file.Lookup(context.Background(), cfg.TokenFile); err != nil {
+			return nil, fmt.Errorf("secrets.vault.token_file: %w", err)
+		}
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	vault, err := secret.NewVault(address, token, cfg.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("secrets.vault: %w", err)
+	}
+	return vault, nil
+}
+
+// awsProvider creates the Secrets Manager provider of secrets.aws, falling
+// back to the AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables
+func (c *Config) awsProvider() (*secret.AWSSecretsManager, error) {
+	cfg := c.Secrets.AWS
+	region := cfg.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	creds := sigv4.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if cfg.CredentialsFile != "" {
+		content, err := os.ReadFile(cfg.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("secrets.aws.credentials_file: %w", err)
+		}
+		if creds, err = sigv4.ParseCredentials(string(content)); err != nil {
+			return nil, fmt.Errorf("secrets.aws.credentials_file: %w", err)
+		}
+	}
+	sm, err := secret.NewAWSSecretsManager(region, cfg.Endpoint, creds)
+	if err != nil {
+		return nil, fmt.Errorf("secrets.aws: %w", err)
+	}
+	return sm, nil
+}
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables overriding
+// configuration fields. A field's variable is its yaml path in upper case
+// with underscores, so database.password is EDGECOM_DATABASE_PASSWORD
+// and server.cache_size is EDGECOM_SERVER_CACHE_SIZE. Lists take
+// comma-separated values. Maps and lists of sections cannot be set this
+// way; reference variables from the file, as in "${API_TOKEN}", instead.
+const EnvPrefix = "EDGECOM_"
+
+// DefaultPath is the configuration file read when no other is given.
+const DefaultPath = "config.yaml"
+
+// flagFields are the command line flags of the service, by the field
+// they set
+var flagFields = []struct {
+	name  string
+	path  string
+	usage string
+}{
+	{"port", "server.port", "The gRPC server port"},
+	{"cache-size", "server.cache_size", "Size of the LRU cache"},
+	{"cache-max-bytes", "server.cache_max_bytes", "Memory budget of the LRU cache, in bytes"},
+	{"rate-limit", "server.rate_limit", "Rate limit in requests per second"},
+	{"rate-limit-burst", "server.rate_limit_burst", "Maximum burst size for rate limiting"},
+	{"max-recv-msg-size", "server.max_recv_msg_size", "Largest gRPC request message accepted, in bytes"},
+	{"max-send-msg-size", "server.max_send_msg_size", "Largest gRPC response message sent, in bytes"},
+	{"conn-string", "database.connection_string", "Database connection string"},
+}
+
+// Flags are the command line flags selecting and overriding the
+// configuration.
+type Flags struct {
+	path string
+	// set holds the values of the flags given, by field path
+	set map[string]string
+}
+
+// RegisterFlags defines the -config flag, naming the configuration file,
+// and a flag for each field commonly set on the command line on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{set: make(map[string]string)}
+	fs.StringVar(&f.path, "config", DefaultPath, "Configuration file")
+	for _, field := range flagFields {
+		fs.Var(&fieldFlag{path: field.path, set: f.set}, field.name, fmt.Sprintf("%s (%s)", field.usage, field.path))
+	}
+	return f
+}
+
+// Path returns the configuration file named by the -config flag.
+func (f *Flags) Path() string {
+	return f.path
+}
+
+// Load reads the configuration as Load does, applying the flags given on
+// the command line, which take precedence over every other source, before
+// resolving secrets.
+func (f *Flags) Load() (*Config, error) {
+	config, err := load(f.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range sortedKeys(f.set) {
+		if err := set(config, path, f.set[path]); err != nil {
+			return nil, fmt.Errorf("flag for %s: %w", path, err)
+		}
+	}
+	if err := config.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+// fieldFlag records the value of a flag setting the field at path
+type fieldFlag struct {
+	path string
+	set  map[string]string
+}
+
+func (f *fieldFlag) String() string {
+	if f == nil || f.set == nil {
+		return ""
+	}
+	return f.set[f.path]
+}
+
+func (f *fieldFlag) Set(value string) error {
+	// Parsing errors are reported on flag parsing, not once loaded
+	if err := set(Default(), f.path, value); err != nil {
+		return err
+	}
+	f.set[f.path] = value
+	return nil
+}
+
+// applyEnv sets every field whose environment variable, as returned by
+// lookup, is set
+func applyEnv(config *Config, lookup func(string) (string, bool)) error {
+	var err error
+	walkFields(reflect.ValueOf(config).Elem(), "", func(path string, field reflect.Value) bool {
+		name := envName(path)
+		value, ok := lookup(name)
+		if !ok {
+			return true
+		}
+		if setErr := setValue(field, value); setErr != nil {
+			err = fmt.Errorf("%s: %w", name, setErr)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// envName returns the environment variable of the field at path
+func envName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// walkFields calls fn with every field of v that can be set from a single
+// value, along with its yaml path, until fn returns false
+func walkFields(v reflect.Value, prefix string, fn func(path string, field reflect.Value) bool) bool {
+	for i := 0; i < v.NumField(); i++ {
+		name := yamlName(v.Type().Field(i))
+		if name == "" {
+			continue
+		}
+		path := prefix + name
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			if !walkFields(field, path+".", fn) {
+				return false
+			}
+		case settable(field.Type()):
+			if !fn(path, field) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// set sets the field of config at the dotted yaml path from value
+func set(config *Config, path string, value string) error {
+	v := reflect.ValueOf(config).Elem()
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("unknown field %s", path)
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if yamlName(v.Type().Field(i)) == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown field %s", path)
+		}
+	}
+	return setValue(v, value)
+}
+
+// settable reports whether fields of type t can be set from a single value
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String || t.Elem().Kind() == reflect.Int
+	}
+	return false
+}
+
+// setValue parses value into field
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		if value != "" {
+			items = strings.Split(value, ",")
+		}
+		list := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(list.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		field.Set(list)
+	default:
+		return fmt.Errorf("cannot be set from a single value")
+	}
+	return nil
+}
+
+// checkKeys returns an error naming the first key of raw, in sorted
+// order, that is not a field of t, so that misspelled settings are not
+// silently ignored
+func checkKeys(raw map[string]interface{}, t reflect.Type, prefix string) error {
+	fields := make(map[string]reflect.Type, t.NumField())
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := yamlName(t.Field(i)); name != "" {
+			fields[name] = t.Field(i).Type
+			names = append(names, name)
+		}
+	}
+
+	for _, key := range sortedKeys(raw) {
+		fieldType, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown key %s%s, expected one of %s", prefix, key, strings.Join(names, ", "))
+		}
+		if err := checkValue(raw[key], fieldType, prefix+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue checks the keys of the sections within value, of type t
+func checkValue(value interface{}, t reflect.Type, path string) error {
+	switch t.Kind() {
+	case reflect.Struct:
+		if section, ok := value.(map[string]interface{}); ok {
+			return checkKeys(section, t, path+".")
+		}
+	case reflect.Slice:
+		if items, ok := value.([]interface{}); ok {
+			for i, item := range items {
+				if err := checkValue(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		if entries, ok := value.(map[string]interface{}); ok {
+			for _, key := range sortedKeys(entries) {
+				if err := checkValue(entries[key], t.Elem(), path+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// yamlName returns the yaml name of a field, or "" when it has none
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
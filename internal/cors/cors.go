@@ -0,0 +1,143 @@
+// Package cors implements Cross-Origin Resource Sharing for the service's
+// HTTP surfaces, so browser dashboards hosted on other domains can call the
+// gateway and admin endpoints directly.
+//
+// A Policy answers preflight requests, adds the CORS response headers to
+// requests from allowed origins, and provides the origin check used when
+// upgrading WebSocket connections. Requests without an Origin header, or
+// from origins that are not allowed, pass through unchanged; the browser
+// then enforces the same-origin policy.
+//
+// Example Usage:
+//
+//	policy := cors.New(cors.Config{
+//	    AllowedOrigins: []string{"https://dashboard.example.com"},
+//	})
+//	http.ListenAndServe(":8081", policy.Handler(mux))
+package cors
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Config describes which cross-origin requests are allowed.
+type Config struct {
+	// AllowedOrigins lists origins such as "https://dashboard.example.com".
+	// A single "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods lists methods allowed in preflight requests.
+	// Defaults to GET, HEAD and OPTIONS.
+	AllowedMethods []string
+	// AllowedHeaders lists request headers allowed in preflight requests.
+	// Defaults to Content-Type, If-None-Match and Last-Event-ID.
+	AllowedHeaders []string
+	// MaxAge is how long, in seconds, browsers may cache a preflight
+	// response. Zero leaves it to the browser.
+	MaxAge int
+}
+
+// exposedHeaders are response headers browsers may read from cross-origin
+// responses in addition to the CORS-safelisted ones.
+const exposedHeaders = "ETag"
+
+// Policy applies a Config to HTTP requests.
+type Policy struct {
+	origins   map[string]bool
+	anyOrigin bool
+	methods   string
+	headers   string
+	maxAge    string
+}
+
+// New creates a Policy from config, applying defaults for empty fields.
+func New(config Config) *Policy {
+	p := &Policy{origins: make(map[string]bool)}
+
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		p.origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+	p.methods = strings.Join(methods, ", ")
+
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", "If-None-Match", "Last-Event-ID"}
+	}
+	p.headers = strings.Join(headers, ", ")
+
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(config.MaxAge)
+	}
+
+	return p
+}
+
+// AllowsOrigin reports whether requests from origin are allowed.
+func (p *Policy) AllowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	return p.origins[strings.ToLower(origin)]
+}
+
+// CheckOrigin reports whether a WebSocket upgrade request may proceed. It
+// matches the signature of websocket.Upgrader.CheckOrigin. Requests without
+// an Origin header come from non-browser clients and are always allowed,
+// as are same-origin requests, from pages served by the request's own host.
+func (p *Policy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.AllowsOrigin(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// Handler wraps next with CORS handling.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !p.AllowsOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if p.anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", p.methods)
+			w.Header().Set("Access-Control-Allow-Headers", p.headers)
+			if p.maxAge != "" {
+				w.Header().Set("Access-Control-Max-Age", p.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
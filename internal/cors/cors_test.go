@@ -0,0 +1,136 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHandler(config Config) (http.Handler, *int) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})
+	return New(config).Handler(next), &calls
+}
+
+func TestHandler(t *testing.T) {
+	config := Config{
+		AllowedOrigins: []string{"https://dashboard.example.com"},
+		MaxAge:         600,
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		headers       map[string]string
+		expectedCode  int
+		expectedCalls int
+		expectedAllow string
+	}{
+		{
+			name:          "Same origin request",
+			method:        http.MethodGet,
+			expectedCode:  http.StatusOK,
+			expectedCalls: 1,
+		},
+		{
+			name:          "Allowed origin",
+			method:        http.MethodGet,
+			headers:       map[string]string{"Origin": "https://dashboard.example.com"},
+			expectedCode:  http.StatusOK,
+			expectedCalls: 1,
+			expectedAllow: "https://dashboard.example.com",
+		},
+		{
+			name:          "Origin matching is case-insensitive",
+			method:        http.MethodGet,
+			headers:       map[string]string{"Origin": "https://Dashboard.Example.com"},
+			expectedCode:  http.StatusOK,
+			expectedCalls: 1,
+			expectedAllow: "https://Dashboard.Example.com",
+		},
+		{
+			name:          "Disallowed origin",
+			method:        http.MethodGet,
+			headers:       map[string]string{"Origin": "https://evil.example.com"},
+			expectedCode:  http.StatusOK,
+			expectedCalls: 1,
+		},
+		{
+			name:   "Preflight",
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://dashboard.example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "If-None-Match",
+			},
+			expectedCode:  http.StatusNoContent,
+			expectedCalls: 0,
+			expectedAllow: "https://dashboard.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, calls := newTestHandler(config)
+
+			req := httptest.NewRequest(tt.method, "/v1/timeseries", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.Equal(t, tt.expectedCalls, *calls)
+			assert.Equal(t, tt.expectedAllow, rec.Header().Get("Access-Control-Allow-Origin"))
+
+			if tt.method == http.MethodOptions {
+				assert.Equal(t, "GET, HEAD, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+				assert.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "If-None-Match")
+				assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+			} else if tt.expectedAllow != "" {
+				assert.Equal(t, "ETag", rec.Header().Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+}
+
+func TestHandlerAnyOrigin(t *testing.T) {
+	handler, _ := newTestHandler(Config{AllowedOrigins: []string{"*"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCheckOrigin(t *testing.T) {
+	policy := New(Config{AllowedOrigins: []string{"https://dashboard.example.com/"}})
+
+	tests := []struct {
+		origin   string
+		expected bool
+	}{
+		{"", true},
+		{"https://dashboard.example.com", true},
+		{"https://evil.example.com", false},
+		// httptest requests are made to example.com
+		{"http://EXAMPLE.com", true},
+		{"http://example.com.evil.net", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/timeseries/live", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		assert.Equal(t, tt.expected, policy.CheckOrigin(req), "origin %q", tt.origin)
+	}
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// AppendAuditRecord appends the record seal returns to audit_log. seal is
+// given the latest record, or nil when the log is empty, and must chain
+// the new record to it. Appends are serialised across connections, so
+// seal always sees the record the new one follows.
+func (s *PostgresRepo) AppendAuditRecord(
+	ctx context.Context,
+	seal func(prev *models.AuditRecord) models.AuditRecord,
+) (_ models.AuditRecord, err error) {
+	ctx, span := startSpan(ctx, "INSERT", "audit_log", insertAuditRecordStatement)
+	defer func() { endSpan(span, err) }()
+
+	var r models.AuditRecord
+	err = s.db.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditLockKey); err != nil {
+			return fmt.Errorf("failed to lock audit log: %w", err)
+		}
+
+		var prev *models.AuditRecord
+		last, err := scanAuditRecord(tx.QueryRowContext(ctx, lastAuditRecordQuery))
+		switch {
+		case err == nil:
+			prev = &last
+		case !errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("failed to read latest audit record: %w", err)
+		}
+
+		r = seal(prev)
+		if _, err := tx.ExecContext(ctx, insertAuditRecordStatement,
+			r.Seq, r.Time, r.Subject, r.Provider, r.Source, r.Action, r.Request, r.Code, r.Peer, r.PrevHash, r.Hash,
+		); err != nil {
+			return fmt.Errorf("failed to insert audit record: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.AuditRecord{}, err
+	}
+	return r, nil
+}
+
+// AuditRecords returns up to limit audit records with sequence numbers
+// above after, in order.
+func (s *PostgresRepo) AuditRecords(ctx context.Context, after int64, limit int) (records []models.AuditRecord, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "audit_log", auditRecordsQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, auditRecordsQuery, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanAuditRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// scanAuditRecord scans a row of audit_log
+func scanAuditRecord(row interface{ Scan(...interface{}) error }) (r models.AuditRecord, err error) {
+	err = row.Scan(&r.Seq, &r.Time, &r.Subject, &r.Provider, &r.Source, &r.Action,
+		&r.Request, &r.Code, &r.Peer, &r.PrevHash, &r.Hash)
+	r.Time = r.Time.UTC()
+	return r, err
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Duplicate timestamp policies
+const (
+	// CollapseLast keeps the value that appears last in the batch, which
+	// suits devices that retry with corrected readings
+	CollapseLast = "last"
+	// CollapseAverage replaces duplicates with the mean of their values
+	CollapseAverage = "average"
+	// CollapseNone inserts every point as received
+	CollapseNone = "none"
+)
+
+// ValidateCollapsePolicy reports whether policy is a known duplicate
+// timestamp policy.
+func ValidateCollapsePolicy(policy string) error {
+	switch policy {
+	case CollapseLast, CollapseAverage, CollapseNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid duplicate policy %q, expected %q, %q or %q",
+			policy, CollapseLast, CollapseAverage, CollapseNone)
+	}
+}
+
+// CollapseDuplicates merges points of a batch that share a timestamp
+// according to policy. Timestamps are compared at the microsecond
+// precision Postgres stores. Points keep the order in which their
+// timestamp first appears; data itself is not modified.
+func CollapseDuplicates(data []models.TimeSeriesData, policy string) []models.TimeSeriesData {
+	if policy == CollapseNone || len(data) < 2 {
+		return data
+	}
+
+	type group struct {
+		index int
+		sum   float64
+		count int
+	}
+
+	groups := make(map[int64]*group, len(data))
+	collapsed := make([]models.TimeSeriesData, 0, len(data))
+	for _, point := range data {
+		key := point.Time.Round(time.Microsecond).UnixMicro()
+
+		g, ok := groups[key]
+		if !ok {
+			groups[key] = &group{index: len(collapsed), sum: point.Value, count: 1}
+			collapsed = append(collapsed, point)
+			continue
+		}
+
+		g.sum += point.Value
+		g.count++
+		switch policy {
+		case CollapseAverage:
+			collapsed[g.index].Value = g.sum / float64(g.count)
+		default:
+			collapsed[g.index].Value = point.Value
+		}
+	}
+
+	if len(collapsed) == len(data) {
+		return data
+	}
+	return collapsed
+}
+
+// CollapsingRepository decorates a TimeSeriesRepository so that batches are
+// written with duplicate timestamps collapsed. It should wrap every other
+// decorator but the ingest transforms, so that every layer below sees the
+// points as stored.
+type CollapsingRepository struct {
+	TimeSeriesRepository
+	policy string
+}
+
+// NewCollapsingRepository wraps repo to collapse duplicates using policy.
+func NewCollapsingRepository(repo TimeSeriesRepository, policy string) (*CollapsingRepository, error) {
+	if err := ValidateCollapsePolicy(policy); err != nil {
+		return nil, err
+	}
+	return &CollapsingRepository{
+		TimeSeriesRepository: repo,
+		policy:               policy,
+	}, nil
+}
+
+// BatchInsertTimeSeriesData collapses duplicate timestamps and inserts the
+// result.
+func (r *CollapsingRepository) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
+	return r.TimeSeriesRepository.BatchInsertTimeSeriesData(ctx, CollapseDuplicates(data, r.policy))
+}
+
+// Compile-time interface implementation check
+var _ TimeSeriesRepository = (*CollapsingRepository)(nil)
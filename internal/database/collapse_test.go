@@ -0,0 +1,76 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestCollapseDuplicates(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+
+	batch := []models.TimeSeriesData{
+		{Time: t0, Value: 1},
+		{Time: t1, Value: 10},
+		{Time: t0.In(time.FixedZone("CET", 3600)), Value: 2},
+		{Time: t0.Add(200 * time.Nanosecond), Value: 6},
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		data   []models.TimeSeriesData
+		want   []models.TimeSeriesData
+	}{
+		{
+			name:   "last wins",
+			policy: CollapseLast,
+			data:   batch,
+			want:   []models.TimeSeriesData{{Time: t0, Value: 6}, {Time: t1, Value: 10}},
+		},
+		{
+			name:   "average",
+			policy: CollapseAverage,
+			data:   batch,
+			want:   []models.TimeSeriesData{{Time: t0, Value: 3}, {Time: t1, Value: 10}},
+		},
+		{
+			name:   "none",
+			policy: CollapseNone,
+			data:   batch,
+			want:   batch,
+		},
+		{
+			name:   "no duplicates",
+			policy: CollapseLast,
+			data:   []models.TimeSeriesData{{Time: t0, Value: 1}, {Time: t1, Value: 2}},
+			want:   []models.TimeSeriesData{{Time: t0, Value: 1}, {Time: t1, Value: 2}},
+		},
+		{
+			name:   "distinct microseconds",
+			policy: CollapseLast,
+			data:   []models.TimeSeriesData{{Time: t0, Value: 1}, {Time: t0.Add(time.Microsecond), Value: 2}},
+			want:   []models.TimeSeriesData{{Time: t0, Value: 1}, {Time: t0.Add(time.Microsecond), Value: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]models.TimeSeriesData(nil), tt.data...)
+
+			assert.Equal(t, tt.want, CollapseDuplicates(tt.data, tt.policy))
+			assert.Equal(t, original, tt.data, "input must not be modified")
+		})
+	}
+}
+
+func TestValidateCollapsePolicy(t *testing.T) {
+	for _, policy := range []string{CollapseLast, CollapseAverage, CollapseNone} {
+		assert.NoError(t, ValidateCollapsePolicy(policy))
+	}
+	assert.ErrorContains(t, ValidateCollapsePolicy("first"), `invalid duplicate policy "first"`)
+}
@@ -0,0 +1,431 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrFailover is returned by statements made while the repository is
+// reconnecting after losing its primary, and by Ping until it has.
+var ErrFailover = errors.New("database failover in progress")
+
+// Reconnect defaults
+const (
+	// DefaultReconnectAttempts is the number of attempts to reach a new
+	// primary before giving up until the next failure
+	DefaultReconnectAttempts = 10
+	// DefaultReconnectBackoff is the delay between the first attempts,
+	// doubled after each one
+	DefaultReconnectBackoff = time.Second
+	// maxReconnectBackoff caps the delay between attempts
+	maxReconnectBackoff = 30 * time.Second
+	// connectTimeout bounds each attempt
+	connectTimeout = 10 * time.Second
+	// DefaultPingInterval is how often the pool is pinged to detect a lost
+	// primary between statements
+	DefaultPingInterval = 15 * time.Second
+	// pingTimeout bounds each ping of the monitor
+	pingTimeout = 5 * time.Second
+)
+
+// ReconnectPolicy bounds the attempts to reach a new primary once the
+// repository detects that it has lost its own.
+type ReconnectPolicy struct {
+	// MaxAttempts is DefaultReconnectAttempts when zero
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, doubling with each
+	// further attempt; DefaultReconnectBackoff when zero
+	Backoff time.Duration
+	// PingInterval is how often MonitorConnection pings the database;
+	// DefaultPingInterval when zero
+	PingInterval time.Duration
+}
+
+// Validate checks that the policy's values are usable.
+func (p ReconnectPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("reconnect attempts must not be negative")
+	}
+	if p.Backoff < 0 {
+		return fmt.Errorf("reconnect backoff must not be negative")
+	}
+	if p.PingInterval < 0 {
+		return fmt.Errorf("ping interval must not be negative")
+	}
+	return nil
+}
+
+// isFailoverError reports whether err shows that the connection pool no
+// longer reaches a writable primary: the server was demoted to a standby,
+// is shutting down or recovering, or connections to it broke.
+func isFailoverError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "25006", // read_only_sql_transaction: the server is a standby
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now: the server is starting or recovering
+			return true
+		}
+		// connection_exception
+		return pqErr.Code.Class() == "08"
+	}
+
+	var opErr *net.OpError
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.As(err, &opErr)
+}
+
+// pool is the repository's connection pool. Statements failing with a
+// failover error make it open a new pool in the background, resolving the
+// server's address afresh, and replace the old one once the new one
+// reaches a primary. Statements fail with ErrFailover in the meantime
+// rather than waiting on broken connections.
+type pool struct {
+	driverName  string
+	connStr     string
+	policy      ReconnectPolicy
+	retryPolicy RetryPolicy
+	logger      *logrus.Logger
+
+	mu sync.RWMutex
+	db *sql.DB
+	// reconnecting is set while a new pool is being opened, with the error
+	// that prompted it and the number of the attempt under way
+	reconnecting bool
+	cause        error
+	attempt      int
+	// done is closed when the current reconnection ends
+	done chan struct{}
+
+	// stop is closed by Close to end a reconnection under way and the
+	// connection monitor
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	reconnects *prometheus.CounterVec
+	attempts   prometheus.Counter
+	retries    prometheus.Counter
+	up         prometheus.Gauge
+	batchSizes prometheus.Histogram
+}
+
+// newPool wraps db, opened with driverName and connStr
+func newPool(driverName, connStr string, db *sql.DB) *pool {
+	return &pool{
+		driverName: driverName,
+		connStr:    connStr,
+		logger:     logrus.StandardLogger(),
+		db:         db,
+		stop:       make(chan struct{}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "edgecom_db_reconnects_total",
+			Help: "Reconnections to the database primary, by result",
+		}, []string{"result"}),
+		attempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "edgecom_db_reconnect_attempts_total",
+			Help: "Attempts to reach a new database primary",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "edgecom_db_retries_total",
+			Help: "Database statements retried after a transient error",
+		}),
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "edgecom_db_up",
+			Help: "Whether the last ping of the connection monitor reached the database primary",
+		}),
+		batchSizes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "edgecom_db_insert_batch_size",
+			Help:    "Points written by each committed batch insert",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+	}
+}
+
+// collectors returns the pool's metrics
+func (p *pool) collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.reconnects, p.attempts, p.retries, p.up, p.batchSizes}
+}
+
+// current returns the pool to run statements on, or ErrFailover while
+// reconnecting
+func (p *pool) current() (*sql.DB, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.reconnecting {
+		return nil, p.failoverError()
+	}
+	return p.db, nil
+}
+
+// failoverError describes the reconnection under way. The caller must
+// hold p.mu.
+func (p *pool) failoverError() error {
+	return fmt.Errorf("%w: reconnecting (attempt %d of %d) after: %v",
+		ErrFailover, p.attempt, p.maxAttempts(), p.cause)
+}
+
+func (p *pool) maxAttempts() int {
+	if p.policy.MaxAttempts == 0 {
+		return DefaultReconnectAttempts
+	}
+	return p.policy.MaxAttempts
+}
+
+// observe starts reconnecting if err, returned by a statement run on db,
+// is a failover error, and returns err. Errors of statements that were
+// still running on a pool already replaced are ignored; db is nil when
+// unknown.
+func (p *pool) observe(db *sql.DB, err error) error {
+	if !isFailoverError(err) {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reconnecting || (db != nil && db != p.db) {
+		return err
+	}
+	p.reconnecting, p.cause, p.attempt = true, err, 1
+	p.done = make(chan struct{})
+	p.logger.WithError(err).Warn("Lost the database primary, reconnecting")
+	go p.reconnect(p.done)
+	return err
+}
+
+// reconnect opens a new pool, retrying with backoff, and replaces the old
+// one with it. After the last failed attempt the old pool is used again,
+// and the next failover error starts over.
+func (p *pool) reconnect(done chan struct{}) {
+	defer close(done)
+
+	backoff := p.policy.Backoff
+	if backoff == 0 {
+		backoff = DefaultReconnectBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		p.mu.Lock()
+		p.attempt = attempt
+		p.mu.Unlock()
+
+		p.attempts.Inc()
+		db, err := p.open()
+		if err == nil {
+			select {
+			case <-p.stop:
+				db.Close()
+				return
+			default:
+			}
+
+			p.mu.Lock()
+			old := p.db
+			p.db, p.reconnecting = db, false
+			p.mu.Unlock()
+
+			p.reconnects.WithLabelValues("succeeded").Inc()
+			p.logger.WithField("attempts", attempt).Info("Reconnected to the database primary")
+			// Waits for statements still running on the old pool
+			old.Close()
+			return
+		}
+
+		p.logger.WithError(err).WithField("attempt", attempt).Warn("Failed to reconnect to the database primary")
+		if attempt >= p.maxAttempts() {
+			p.mu.Lock()
+			p.reconnecting = false
+			p.mu.Unlock()
+			p.reconnects.WithLabelValues("failed").Inc()
+			p.logger.WithField("attempts", attempt).Error("Giving up reconnecting to the database primary")
+			return
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// open opens a new pool and checks that it reaches a writable primary
+func (p *pool) open() (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	db, err := sql.Open(p.driverName, p.connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var standby bool
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&standby); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if standby {
+		db.Close()
+		return nil, errors.New("the server is a standby")
+	}
+	return db, nil
+}
+
+// ExecContext runs a statement on the current pool, retrying it on
+// transient errors that show it was not applied.
+func (p *pool) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	err = p.retry(ctx, isRetryableWrite, func() error {
+		db, err := p.current()
+		if err != nil {
+			return err
+		}
+		result, err = db.ExecContext(ctx, query, args...)
+		return p.observe(db, err)
+	})
+	return result, err
+}
+
+// QueryContext runs a query on the current pool, retrying it on transient
+// errors. Errors met while reading the rows are not retried.
+func (p *pool) QueryContext(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	err = p.retry(ctx, isRetryableRead, func() error {
+		db, err := p.current()
+		if err != nil {
+			return err
+		}
+		rows, err = db.QueryContext(ctx, query, args...)
+		return p.observe(db, err)
+	})
+	return rows, err
+}
+
+// QueryRowContext runs a query expected to return at most one row on the
+// current pool when the row is scanned.
+func (p *pool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *row {
+	return &row{pool: p, retryable: isRetryableRead, ctx: ctx, query: query, args: args}
+}
+
+// ExecReturningContext runs a statement returning one row, such as an
+// INSERT ... RETURNING, on the current pool when the row is scanned. Like
+// ExecContext, it is only retried when it was not applied.
+func (p *pool) ExecReturningContext(ctx context.Context, query string, args ...interface{}) *row {
+	return &row{pool: p, retryable: isRetryableWrite, ctx: ctx, query: query, args: args}
+}
+
+// BeginTx starts a transaction on the current pool. Errors of the
+// transaction's statements are not observed; callers pass them to
+// observe with a nil pool, or use inTx, which also retries transactions.
+func (p *pool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	db, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.BeginTx(ctx, opts)
+	return tx, p.observe(db, err)
+}
+
+// PingContext checks the current pool, and fails while reconnecting.
+func (p *pool) PingContext(ctx context.Context) error {
+	db, err := p.current()
+	if err != nil {
+		return err
+	}
+	return p.observe(db, db.PingContext(ctx))
+}
+
+// Close ends a reconnection under way and closes the current pool.
+func (p *pool) Close() error {
+	p.closeOnce.Do(func() { close(p.stop) })
+	p.mu.RLock()
+	done := p.done
+	p.mu.RUnlock()
+	if done != nil {
+		<-done
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.db.Close()
+}
+
+// monitor pings the current pool until Close, starting a reconnection
+// when a ping shows that the primary was lost, so that it is found between
+// statements rather than by the next one
+func (p *pool) monitor() {
+	interval := p.policy.PingInterval
+	if interval == 0 {
+		interval = DefaultPingInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err := p.PingContext(ctx)
+		cancel()
+		if err != nil {
+			p.up.Set(0)
+			if !errors.Is(err, ErrFailover) {
+				p.logger.WithError(err).Warn("Database ping failed")
+			}
+		} else {
+			p.up.Set(1)
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// row is a query expected to return at most one row, run when scanned
+type row struct {
+	pool      *pool
+	retryable func(error) bool
+	ctx       context.Context
+	query     string
+	args      []interface{}
+}
+
+// Scan runs the query and copies the row's columns into dest, like
+// (*sql.Row).Scan, retrying the query on the transient errors it allows.
+func (r *row) Scan(dest ...interface{}) error {
+	return r.pool.retry(r.ctx, r.retryable, func() error {
+		db, err := r.pool.current()
+		if err != nil {
+			return err
+		}
+		return r.pool.observe(db, db.QueryRowContext(r.ctx, r.query, r.args...).Scan(dest...))
+	})
+}
@@ -0,0 +1,435 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestIsFailoverError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "read only", err: fmt.Errorf("failed to insert data point: %w", &pq.Error{Code: "25006"}), want: true},
+		{name: "admin shutdown", err: &pq.Error{Code: "57P01"}, want: true},
+		{name: "recovering", err: &pq.Error{Code: "57P03"}, want: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		{name: "no rows", err: sql.ErrNoRows, want: false},
+		{name: "cancelled", err: context.Canceled, want: false},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isFailoverError(tt.err))
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "failover", err: fmt.Errorf("query failed: %w", ErrFailover), want: true},
+		{name: "serialization failure", err: &pq.Error{Code: "40001"}, want: true},
+		{name: "too many connections", err: &pq.Error{Code: "53300"}, want: true},
+		{name: "out of memory", err: &pq.Error{Code: "53200"}, want: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "connection done", err: sql.ErrConnDone, want: true},
+		{name: "undefined column", err: &pq.Error{Code: "42703"}, want: false},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "plain error", err: errors.New("scan failed"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTransient(tt.err))
+		})
+	}
+}
+
+func TestWithContextError(t *testing.T) {
+	canceled := &pq.Error{Code: "57014", Message: "canceling statement due to user request"}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	assert.Same(t, canceled, withContextError(ctx, canceled), "ctx is still running")
+	cancel()
+	err := withContextError(ctx, fmt.Errorf("query failed: %w", canceled))
+	assert.ErrorIs(t, err, context.Canceled)
+	var pqErr *pq.Error
+	assert.ErrorAs(t, err, &pqErr)
+
+	undefined := &pq.Error{Code: "42703"}
+	assert.Same(t, undefined, withContextError(ctx, undefined), "other errors are returned as they are")
+
+	deadline, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	assert.ErrorIs(t, withContextError(deadline, canceled), context.DeadlineExceeded)
+}
+
+func TestReconnect(t *testing.T) {
+	server := &fakeServer{}
+	sql.Register("fakepg-reconnect", fakeDriver{server})
+	db, err := sql.Open("fakepg-reconnect", "")
+	require.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	repo := &PostgresRepo{db: newPool("fakepg-reconnect", "", db)}
+	repo.SetReconnectPolicy(ReconnectPolicy{MaxAttempts: 3, Backoff: time.Millisecond}, logger)
+	// Retries are covered by TestRetry
+	repo.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	defer repo.Close()
+
+	ctx := context.Background()
+	require.NoError(t, repo.Ping(ctx))
+	require.NoError(t, repo.RecordGap(ctx, time.Now(), time.Now(), "test"))
+
+	t.Run("demoted primary", func(t *testing.T) {
+		// The server is demoted and no new primary is reachable yet
+		server.set(true, nil)
+		blocked := make(chan struct{})
+		server.block(blocked)
+
+		err := repo.RecordGap(ctx, time.Now(), time.Now(), "test")
+		var pqErr *pq.Error
+		require.ErrorAs(t, err, &pqErr)
+		assert.Equal(t, pq.ErrorCode("25006"), pqErr.Code)
+
+		// Statements fail fast while reconnecting, and the probe reports it
+		err = repo.RecordGap(ctx, time.Now(), time.Now(), "test")
+		assert.ErrorIs(t, err, ErrFailover)
+		err = repo.Ping(ctx)
+		assert.ErrorIs(t, err, ErrFailover)
+		assert.ErrorContains(t, err, "attempt 1 of 3")
+
+		// A new primary is promoted
+		server.set(false, nil)
+		close(blocked)
+		require.Eventually(t, func() bool { return repo.Ping(ctx) == nil }, time.Second, time.Millisecond)
+		assert.NoError(t, repo.RecordGap(ctx, time.Now(), time.Now(), "test"))
+		assert.Equal(t, 1, server.recoveryChecks())
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		server.set(true, nil)
+		server.resetRecoveryChecks()
+
+		err := repo.RecordGap(ctx, time.Now(), time.Now(), "test")
+		require.Error(t, err)
+		require.Eventually(t, func() bool {
+			return !errors.Is(repo.Ping(ctx), ErrFailover)
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 3, server.recoveryChecks())
+
+		// The old pool is used again, and the next failure starts over
+		server.set(false, nil)
+		assert.NoError(t, repo.RecordGap(ctx, time.Now(), time.Now(), "test"))
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		server.set(false, &pq.Error{Code: "23505"})
+		defer server.set(false, nil)
+
+		err := repo.RecordGap(ctx, time.Now(), time.Now(), "test")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrFailover)
+		assert.NoError(t, repo.Ping(ctx))
+	})
+}
+
+func TestRetry(t *testing.T) {
+	server := &fakeServer{}
+	sql.Register("fakepg-retry", fakeDriver{server})
+	db, err := sql.Open("fakepg-retry", "")
+	require.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	repo := &PostgresRepo{db: newPool("fakepg-retry", "", db)}
+	repo.SetReconnectPolicy(ReconnectPolicy{MaxAttempts: 3, Backoff: time.Millisecond}, logger)
+	repo.SetRetryPolicy(RetryPolicy{MaxAttempts: 4, Backoff: 5 * time.Millisecond})
+	defer repo.Close()
+
+	ctx := context.Background()
+	reset := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	points := []models.TimeSeriesData{{Time: time.Now(), Value: 1}}
+	// reconnected waits for a reconnection started by a connection error
+	reconnected := func() {
+		require.Eventually(t, func() bool { return repo.Ping(ctx) == nil }, time.Second, time.Millisecond)
+		server.counts()
+	}
+
+	t.Run("serialization failure", func(t *testing.T) {
+		server.fail(&pq.Error{Code: "40001"}, &pq.Error{Code: "40P01"})
+		require.NoError(t, repo.RecordGap(ctx, time.Now(), time.Now(), "test"))
+		statements, _ := server.counts()
+		assert.Equal(t, 3, statements)
+		assert.Equal(t, 2.0, testutil.ToFloat64(repo.db.retries))
+	})
+
+	t.Run("attempts are bounded", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			server.fail(&pq.Error{Code: "40001"})
+		}
+		err := repo.RecordGap(ctx, time.Now(), time.Now(), "test")
+		var pqErr *pq.Error
+		require.ErrorAs(t, err, &pqErr)
+		assert.Equal(t, pq.ErrorCode("40001"), pqErr.Code)
+		statements, _ := server.counts()
+		assert.Equal(t, 4, statements)
+	})
+
+	t.Run("writes interrupted by a reset are not retried", func(t *testing.T) {
+		server.fail(reset)
+		err := repo.RecordGap(ctx, time.Now(), time.Now(), "test")
+		assert.ErrorIs(t, err, syscall.ECONNRESET)
+		statements, _ := server.counts()
+		assert.Equal(t, 1, statements)
+		reconnected()
+	})
+
+	t.Run("reads retry across a reconnection", func(t *testing.T) {
+		server.fail(reset)
+		var standby bool
+		require.NoError(t, repo.db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&standby))
+		reconnected()
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		// The deadlock fails the first transaction's insert
+		server.fail(&pq.Error{Code: "40P01"})
+		require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, points))
+		_, begins := server.counts()
+		assert.Equal(t, 2, begins)
+
+		// A serialization failure at commit rolls the transaction back
+		server.failCommit(&pq.Error{Code: "40001"})
+		require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, points))
+		_, begins = server.counts()
+		assert.Equal(t, 2, begins)
+
+		// An interrupted commit may have been applied
+		server.failCommit(reset)
+		err := repo.BatchInsertTimeSeriesData(ctx, points)
+		assert.ErrorIs(t, err, syscall.ECONNRESET)
+		_, begins = server.counts()
+		assert.Equal(t, 1, begins)
+		reconnected()
+	})
+}
+
+// fakeServer is the state of the database fakeDriver connects to
+type fakeServer struct {
+	mu sync.Mutex
+	// standby makes statements fail as on a read-only standby, and
+	// pg_is_in_recovery() report true
+	standby bool
+	// execErr fails statements
+	execErr error
+	// detached makes a replica report that it is not receiving WAL
+	detached bool
+	// wal is the WAL position the server has reached or replayed
+	wal int64
+	// blocked, if set, delays pg_is_in_recovery() until it is closed
+	blocked chan struct{}
+	checks  int
+	// failures fail the next statements, one each, before the others apply
+	failures []error
+	// commitFailures fail the next commits, one each
+	commitFailures []error
+	// statements and begins count the statements run and the transactions
+	// started
+	statements int
+	begins     int
+}
+
+// fail queues errors for the next statements
+func (s *fakeServer) fail(errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, errs...)
+}
+
+// failCommit queues errors for the next commits
+func (s *fakeServer) failCommit(errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commitFailures = append(s.commitFailures, errs...)
+}
+
+// counts returns the statements run and transactions started, and resets
+// them
+func (s *fakeServer) counts() (statements, begins int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statements, begins = s.statements, s.begins
+	s.statements, s.begins = 0, 0
+	return statements, begins
+}
+
+// nextFailure pops the next queued failure. The caller must hold s.mu.
+func (s *fakeServer) nextFailure() error {
+	s.statements++
+	if len(s.failures) == 0 {
+		return nil
+	}
+	err := s.failures[0]
+	s.failures = s.failures[1:]
+	return err
+}
+
+func (s *fakeServer) set(standby bool, execErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.standby, s.execErr = standby, execErr
+}
+
+func (s *fakeServer) block(blocked chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked = blocked
+}
+
+func (s *fakeServer) recoveryChecks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checks
+}
+
+func (s *fakeServer) resetRecoveryChecks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks, s.blocked = 0, nil
+}
+
+// fakeDriver is a database/sql driver answering statements from a
+// fakeServer
+type fakeDriver struct{ server *fakeServer }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{server: d.server}, nil }
+
+type fakeConn struct{ server *fakeServer }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return &fakeStmt{conn: c}, nil }
+func (c *fakeConn) Close() error                        { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	c.server.begins++
+	return &fakeTx{server: c.server}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if err := c.server.nextFailure(); err != nil {
+		return nil, err
+	}
+	if c.server.standby {
+		return nil, &pq.Error{Code: "25006", Message: "cannot execute INSERT in a read-only transaction"}
+	}
+	if c.server.execErr != nil {
+		return nil, c.server.execErr
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.server.mu.Lock()
+	blocked := c.server.blocked
+	c.server.checks++
+	c.server.mu.Unlock()
+	if blocked != nil {
+		<-blocked
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if err := c.server.nextFailure(); err != nil {
+		return nil, err
+	}
+	switch query {
+	case replicaStateQuery:
+		// The standby flag reads as the replica lagging
+		return &fakeRows{values: []driver.Value{!c.server.detached, c.server.standby, c.server.wal}}, nil
+	case walPositionQuery:
+		return &fakeRows{values: []driver.Value{c.server.wal}}, nil
+	}
+	return &fakeRows{values: []driver.Value{c.server.standby}}, nil
+}
+
+// fakeStmt runs prepared statements as unprepared ones
+type fakeStmt struct{ conn *fakeConn }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), "", nil)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), "", nil)
+}
+
+// fakeTx commits unless a commit failure is queued
+type fakeTx struct{ server *fakeServer }
+
+func (t *fakeTx) Rollback() error { return nil }
+
+func (t *fakeTx) Commit() error {
+	t.server.mu.Lock()
+	defer t.server.mu.Unlock()
+	if len(t.server.commitFailures) == 0 {
+		return nil
+	}
+	err := t.server.commitFailures[0]
+	t.server.commitFailures = t.server.commitFailures[1:]
+	return err
+}
+
+// fakeRows holds a single row, such as that of pg_is_in_recovery()
+type fakeRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *fakeRows) Columns() []string { return make([]string, len(r.values)) }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	calendar "github.com/tejusbharadwaj/edgecom/internal/calendar"
+	labels "github.com/tejusbharadwaj/edgecom/internal/labels"
 	models "github.com/tejusbharadwaj/edgecom/internal/models"
 )
 
@@ -36,6 +38,20 @@ func (m *MockTimeSeriesRepository) EXPECT() *MockTimeSeriesRepositoryMockRecorde
 	return m.recorder
 }
 
+// AdvanceWatermark mocks base method.
+func (m *MockTimeSeriesRepository) AdvanceWatermark(arg0 context.Context, arg1 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdvanceWatermark", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AdvanceWatermark indicates an expected call of AdvanceWatermark.
+func (mr *MockTimeSeriesRepositoryMockRecorder) AdvanceWatermark(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdvanceWatermark", reflect.TypeOf((*MockTimeSeriesRepository)(nil).AdvanceWatermark), arg0, arg1)
+}
+
 // BatchInsertTimeSeriesData mocks base method.
 func (m *MockTimeSeriesRepository) BatchInsertTimeSeriesData(arg0 context.Context, arg1 []models.TimeSeriesData) error {
 	m.ctrl.T.Helper()
@@ -64,6 +80,80 @@ func (mr *MockTimeSeriesRepositoryMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockTimeSeriesRepository)(nil).Close))
 }
 
+// DailyWindowAverages mocks base method.
+func (m *MockTimeSeriesRepository) DailyWindowAverages(arg0 context.Context, arg1, arg2 time.Time, arg3 int) (map[int]float64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DailyWindowAverages", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(map[int]float64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DailyWindowAverages indicates an expected call of DailyWindowAverages.
+func (mr *MockTimeSeriesRepositoryMockRecorder) DailyWindowAverages(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DailyWindowAverages", reflect.TypeOf((*MockTimeSeriesRepository)(nil).DailyWindowAverages), arg0, arg1, arg2, arg3)
+}
+
+// DeleteVirtualSeries mocks base method.
+func (m *MockTimeSeriesRepository) DeleteVirtualSeries(arg0 context.Context, arg1 string, arg2 func([]models.VirtualSeries) error) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteVirtualSeries", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteVirtualSeries indicates an expected call of DeleteVirtualSeries.
+func (mr *MockTimeSeriesRepositoryMockRecorder) DeleteVirtualSeries(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteVirtualSeries", reflect.TypeOf((*MockTimeSeriesRepository)(nil).DeleteVirtualSeries), arg0, arg1, arg2)
+}
+
+// DemandResponseEvents mocks base method.
+func (m *MockTimeSeriesRepository) DemandResponseEvents(arg0 context.Context, arg1, arg2 time.Time) ([]models.DemandResponseEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DemandResponseEvents", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]models.DemandResponseEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DemandResponseEvents indicates an expected call of DemandResponseEvents.
+func (mr *MockTimeSeriesRepositoryMockRecorder) DemandResponseEvents(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DemandResponseEvents", reflect.TypeOf((*MockTimeSeriesRepository)(nil).DemandResponseEvents), arg0, arg1, arg2)
+}
+
+// DescribeSeries mocks base method.
+func (m *MockTimeSeriesRepository) DescribeSeries(arg0 context.Context, arg1 string, arg2 models.SeriesDescription) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DescribeSeries", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DescribeSeries indicates an expected call of DescribeSeries.
+func (mr *MockTimeSeriesRepositoryMockRecorder) DescribeSeries(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeSeries", reflect.TypeOf((*MockTimeSeriesRepository)(nil).DescribeSeries), arg0, arg1, arg2)
+}
+
+// IngestSources mocks base method.
+func (m *MockTimeSeriesRepository) IngestSources(arg0 context.Context) ([]models.IngestSource, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IngestSources", arg0)
+	ret0, _ := ret[0].([]models.IngestSource)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IngestSources indicates an expected call of IngestSources.
+func (mr *MockTimeSeriesRepositoryMockRecorder) IngestSources(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestSources", reflect.TypeOf((*MockTimeSeriesRepository)(nil).IngestSources), arg0)
+}
+
 // InsertTimeSeriesData mocks base method.
 func (m *MockTimeSeriesRepository) InsertTimeSeriesData(arg0 time.Time, arg1 float64) error {
 	m.ctrl.T.Helper()
@@ -78,6 +168,64 @@ func (mr *MockTimeSeriesRepositoryMockRecorder) InsertTimeSeriesData(arg0, arg1
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertTimeSeriesData", reflect.TypeOf((*MockTimeSeriesRepository)(nil).InsertTimeSeriesData), arg0, arg1)
 }
 
+// InsertTimeSeriesDataContext mocks base method.
+func (m *MockTimeSeriesRepository) InsertTimeSeriesDataContext(arg0 context.Context, arg1 time.Time, arg2 float64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertTimeSeriesDataContext", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertTimeSeriesDataContext indicates an expected call of InsertTimeSeriesDataContext.
+func (mr *MockTimeSeriesRepositoryMockRecorder) InsertTimeSeriesDataContext(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertTimeSeriesDataContext", reflect.TypeOf((*MockTimeSeriesRepository)(nil).InsertTimeSeriesDataContext), arg0, arg1, arg2)
+}
+
+// ListSeriesMetadata mocks base method.
+func (m *MockTimeSeriesRepository) ListSeriesMetadata(arg0 context.Context, arg1 []*labels.Matcher) ([]models.SeriesMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSeriesMetadata", arg0, arg1)
+	ret0, _ := ret[0].([]models.SeriesMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSeriesMetadata indicates an expected call of ListSeriesMetadata.
+func (mr *MockTimeSeriesRepositoryMockRecorder) ListSeriesMetadata(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSeriesMetadata", reflect.TypeOf((*MockTimeSeriesRepository)(nil).ListSeriesMetadata), arg0, arg1)
+}
+
+// PendingGaps mocks base method.
+func (m *MockTimeSeriesRepository) PendingGaps(arg0 context.Context) ([]models.Gap, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingGaps", arg0)
+	ret0, _ := ret[0].([]models.Gap)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PendingGaps indicates an expected call of PendingGaps.
+func (mr *MockTimeSeriesRepositoryMockRecorder) PendingGaps(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingGaps", reflect.TypeOf((*MockTimeSeriesRepository)(nil).PendingGaps), arg0)
+}
+
+// Ping mocks base method.
+func (m *MockTimeSeriesRepository) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockTimeSeriesRepositoryMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockTimeSeriesRepository)(nil).Ping), arg0)
+}
+
 // Query mocks base method.
 func (m *MockTimeSeriesRepository) Query(arg0 context.Context, arg1, arg2 time.Time, arg3, arg4 string) ([]models.TimeSeriesData, error) {
 	m.ctrl.T.Helper()
@@ -92,3 +240,211 @@ func (mr *MockTimeSeriesRepositoryMockRecorder) Query(arg0, arg1, arg2, arg3, ar
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockTimeSeriesRepository)(nil).Query), arg0, arg1, arg2, arg3, arg4)
 }
+
+// QueryBusinessHours mocks base method.
+func (m *MockTimeSeriesRepository) QueryBusinessHours(arg0 context.Context, arg1, arg2 time.Time, arg3, arg4 string, arg5 *calendar.Calendar) ([]models.TimeSeriesData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryBusinessHours", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].([]models.TimeSeriesData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryBusinessHours indicates an expected call of QueryBusinessHours.
+func (mr *MockTimeSeriesRepositoryMockRecorder) QueryBusinessHours(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryBusinessHours", reflect.TypeOf((*MockTimeSeriesRepository)(nil).QueryBusinessHours), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// QueryLatest mocks base method.
+func (m *MockTimeSeriesRepository) QueryLatest(arg0 context.Context, arg1 int) ([]models.TimeSeriesData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryLatest", arg0, arg1)
+	ret0, _ := ret[0].([]models.TimeSeriesData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryLatest indicates an expected call of QueryLatest.
+func (mr *MockTimeSeriesRepositoryMockRecorder) QueryLatest(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryLatest", reflect.TypeOf((*MockTimeSeriesRepository)(nil).QueryLatest), arg0, arg1)
+}
+
+// QueryPage mocks base method.
+func (m *MockTimeSeriesRepository) QueryPage(arg0 context.Context, arg1, arg2 time.Time, arg3, arg4 string, arg5 *calendar.Calendar, arg6 time.Time, arg7 int) ([]models.TimeSeriesData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryPage", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
+	ret0, _ := ret[0].([]models.TimeSeriesData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryPage indicates an expected call of QueryPage.
+func (mr *MockTimeSeriesRepositoryMockRecorder) QueryPage(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryPage", reflect.TypeOf((*MockTimeSeriesRepository)(nil).QueryPage), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
+}
+
+// QueryRaw mocks base method.
+func (m *MockTimeSeriesRepository) QueryRaw(arg0 context.Context, arg1, arg2 time.Time, arg3, arg4 int) ([]models.TimeSeriesData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryRaw", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].([]models.TimeSeriesData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryRaw indicates an expected call of QueryRaw.
+func (mr *MockTimeSeriesRepositoryMockRecorder) QueryRaw(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRaw", reflect.TypeOf((*MockTimeSeriesRepository)(nil).QueryRaw), arg0, arg1, arg2, arg3, arg4)
+}
+
+// RecordDemandResponseEvent mocks base method.
+func (m *MockTimeSeriesRepository) RecordDemandResponseEvent(arg0 context.Context, arg1 models.DemandResponseEvent) (models.DemandResponseEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordDemandResponseEvent", arg0, arg1)
+	ret0, _ := ret[0].(models.DemandResponseEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecordDemandResponseEvent indicates an expected call of RecordDemandResponseEvent.
+func (mr *MockTimeSeriesRepositoryMockRecorder) RecordDemandResponseEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordDemandResponseEvent", reflect.TypeOf((*MockTimeSeriesRepository)(nil).RecordDemandResponseEvent), arg0, arg1)
+}
+
+// RecordGap mocks base method.
+func (m *MockTimeSeriesRepository) RecordGap(arg0 context.Context, arg1, arg2 time.Time, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordGap", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordGap indicates an expected call of RecordGap.
+func (mr *MockTimeSeriesRepositoryMockRecorder) RecordGap(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordGap", reflect.TypeOf((*MockTimeSeriesRepository)(nil).RecordGap), arg0, arg1, arg2, arg3)
+}
+
+// ResolveGap mocks base method.
+func (m *MockTimeSeriesRepository) ResolveGap(arg0 context.Context, arg1 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveGap", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResolveGap indicates an expected call of ResolveGap.
+func (mr *MockTimeSeriesRepositoryMockRecorder) ResolveGap(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveGap", reflect.TypeOf((*MockTimeSeriesRepository)(nil).ResolveGap), arg0, arg1)
+}
+
+// SaveVirtualSeries mocks base method.
+func (m *MockTimeSeriesRepository) SaveVirtualSeries(arg0 context.Context, arg1 models.VirtualSeries, arg2 func([]models.VirtualSeries) error) (models.VirtualSeries, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveVirtualSeries", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.VirtualSeries)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveVirtualSeries indicates an expected call of SaveVirtualSeries.
+func (mr *MockTimeSeriesRepositoryMockRecorder) SaveVirtualSeries(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveVirtualSeries", reflect.TypeOf((*MockTimeSeriesRepository)(nil).SaveVirtualSeries), arg0, arg1, arg2)
+}
+
+// SeriesMetadata mocks base method.
+func (m *MockTimeSeriesRepository) SeriesMetadata(arg0 context.Context) (models.SeriesMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SeriesMetadata", arg0)
+	ret0, _ := ret[0].(models.SeriesMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SeriesMetadata indicates an expected call of SeriesMetadata.
+func (mr *MockTimeSeriesRepositoryMockRecorder) SeriesMetadata(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SeriesMetadata", reflect.TypeOf((*MockTimeSeriesRepository)(nil).SeriesMetadata), arg0)
+}
+
+// SetIngestSource mocks base method.
+func (m *MockTimeSeriesRepository) SetIngestSource(arg0 context.Context, arg1 models.IngestSource, arg2 bool) (models.IngestSource, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetIngestSource", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.IngestSource)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetIngestSource indicates an expected call of SetIngestSource.
+func (mr *MockTimeSeriesRepositoryMockRecorder) SetIngestSource(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetIngestSource", reflect.TypeOf((*MockTimeSeriesRepository)(nil).SetIngestSource), arg0, arg1, arg2)
+}
+
+// Statistics mocks base method.
+func (m *MockTimeSeriesRepository) Statistics(arg0 context.Context, arg1, arg2 time.Time) (models.Statistics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Statistics", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.Statistics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Statistics indicates an expected call of Statistics.
+func (mr *MockTimeSeriesRepositoryMockRecorder) Statistics(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Statistics", reflect.TypeOf((*MockTimeSeriesRepository)(nil).Statistics), arg0, arg1, arg2)
+}
+
+// Summaries mocks base method.
+func (m *MockTimeSeriesRepository) Summaries(arg0 context.Context, arg1 string, arg2, arg3 time.Time) ([]models.ConsumptionSummary, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Summaries", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]models.ConsumptionSummary)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Summaries indicates an expected call of Summaries.
+func (mr *MockTimeSeriesRepositoryMockRecorder) Summaries(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Summaries", reflect.TypeOf((*MockTimeSeriesRepository)(nil).Summaries), arg0, arg1, arg2, arg3)
+}
+
+// VirtualSeries mocks base method.
+func (m *MockTimeSeriesRepository) VirtualSeries(arg0 context.Context) ([]models.VirtualSeries, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VirtualSeries", arg0)
+	ret0, _ := ret[0].([]models.VirtualSeries)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VirtualSeries indicates an expected call of VirtualSeries.
+func (mr *MockTimeSeriesRepositoryMockRecorder) VirtualSeries(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VirtualSeries", reflect.TypeOf((*MockTimeSeriesRepository)(nil).VirtualSeries), arg0)
+}
+
+// Watermark mocks base method.
+func (m *MockTimeSeriesRepository) Watermark(arg0 context.Context) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Watermark", arg0)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Watermark indicates an expected call of Watermark.
+func (mr *MockTimeSeriesRepositoryMockRecorder) Watermark(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Watermark", reflect.TypeOf((*MockTimeSeriesRepository)(nil).Watermark), arg0)
+}
@@ -0,0 +1,509 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
+)
+
+// aggregateExpressions maps supported aggregation names to fixed SQL
+// expressions. Only these constant fragments are ever spliced into query
+// text; everything supplied by callers is bound as a parameter.
+//
+// LOAD_FACTOR is the average over the peak of each bucket, zero when the
+// peak is. UTILIZATION reads the peak, which is divided by the contracted
+// capacity once read.
+var aggregateExpressions = map[string]string{
+	"MIN":                  "MIN(value)",
+	"MAX":                  "MAX(value)",
+	"AVG":                  "AVG(value)",
+	"SUM":                  "SUM(value)",
+	"LOAD_FACTOR":          "COALESCE(AVG(value) / NULLIF(MAX(value), 0), 0)",
+	utilizationAggregation: "MAX(value)",
+}
+
+// utilizationAggregation relates the peak of each bucket to the contracted
+// capacity of the series
+const utilizationAggregation = "UTILIZATION"
+
+// windowPattern matches window strings such as "1m", "5m", "1h" or "1d".
+var windowPattern = regexp.MustCompile(`^([1-9][0-9]{0,5})([smhd])$`)
+
+// windowUnits maps window suffixes to Postgres interval units.
+var windowUnits = map[string]string{
+	"s": "seconds",
+	"m": "minutes",
+	"h": "hours",
+	"d": "days",
+}
+
+// aggregationQueryTemplate is the bucketed aggregation query. The only
+// substitution is the aggregate expression chosen from aggregateExpressions.
+const aggregationQueryTemplate = `
+        SELECT
+            time_bucket($3::interval, time) AS bucket_time,
+            %s AS agg_value,
+            COUNT(*) AS sample_count
+        FROM time_series_data
+        WHERE time BETWEEN $1 AND $2
+        GROUP BY bucket_time
+        ORDER BY bucket_time
+    `
+
+// businessHoursQueryTemplate is the bucketed aggregation restricted to the
+// working hours of a calendar. Buckets are aligned in the calendar's time
+// zone ($4) and holidays are bound as a date array ($5). The substitutions
+// are the aggregate expression and one working hours predicate per range,
+// built by businessHoursPredicate from parameter placeholders only.
+const businessHoursQueryTemplate = `
+        SELECT
+            time_bucket($3::interval, time, $4) AS bucket_time,
+            %s AS agg_value,
+            COUNT(*) AS sample_count
+        FROM time_series_data
+        WHERE time BETWEEN $1 AND $2
+          AND NOT ((time AT TIME ZONE $4)::date = ANY($5::date[]))
+          AND (%s)
+        GROUP BY bucket_time
+        ORDER BY bucket_time
+    `
+
+// businessHoursRangeTemplate matches samples whose local ISO weekday is in
+// the first parameter and whose local time is within [second, third).
+const businessHoursRangeTemplate = `(EXTRACT(ISODOW FROM time AT TIME ZONE $4) = ANY($%d::int[])` +
+	` AND (time AT TIME ZONE $4)::time >= $%d::time AND (time AT TIME ZONE $4)::time < $%d::time)`
+
+// pagedQueryTemplate returns the buckets of an aggregation query after a
+// keyset cursor, at most a limit of them. The substitutions are the inner
+// query and the placeholder numbers of the cursor and the limit.
+const pagedQueryTemplate = `
+        SELECT bucket_time, agg_value, sample_count
+        FROM (%s) AS buckets
+        WHERE bucket_time > $%d
+        ORDER BY bucket_time
+        LIMIT $%d
+    `
+
+// rawQuery selects stored samples in a time range with keyset-friendly
+// ordering.
+const rawQuery = `
+        SELECT time, value
+        FROM time_series_data
+        WHERE time BETWEEN $1 AND $2
+        ORDER BY time, value
+        LIMIT $3 OFFSET $4
+    `
+
+// statisticsQuery summarises the samples in a time range in one pass. The
+// aggregates are NULL when the range holds no samples.
+const statisticsQuery = `
+        SELECT
+            COUNT(*),
+            MIN(value),
+            MAX(value),
+            AVG(value),
+            SUM(value),
+            STDDEV_POP(value),
+            MIN(time),
+            MAX(time)
+        FROM time_series_data
+        WHERE time BETWEEN $1 AND $2
+    `
+
+// batchInsertStatement inserts one sample; batches prepare it once per
+// transaction.
+const batchInsertStatement = `
+        INSERT INTO time_series_data (time, value)
+        VALUES ($1, $2)
+    `
+
+// upsertSeriesMetadataStatement widens the stored range of a series and
+// adds to its point count. Arguments are the series, the earliest and
+// latest timestamps of the batch and the number of points.
+const upsertSeriesMetadataStatement = `
+        INSERT INTO series_metadata (series, first_time, last_time, point_count, updated_at)
+        VALUES ($1, $2, $3, $4, now())
+        ON CONFLICT (series) DO UPDATE SET
+            first_time = LEAST(series_metadata.first_time, EXCLUDED.first_time),
+            last_time = GREATEST(series_metadata.last_time, EXCLUDED.last_time),
+            point_count = series_metadata.point_count + EXCLUDED.point_count,
+            updated_at = EXCLUDED.updated_at
+    `
+
+// lockSummaryStatement takes a transaction-level advisory lock on a day or
+// a month of the consumption summaries, so that concurrent inserts update
+// its summary one after the other, each reading the data the other
+// committed. Arguments are summaryDayLockClass or summaryMonthLockClass
+// and the number of the day or month.
+const lockSummaryStatement = `SELECT pg_advisory_xact_lock($1, $2)`
+
+// refreshDaySummaryStatement recomputes the summary of the UTC day
+// [$1, $2) from the stored samples: their total, the highest hourly total
+// and the start of its hour, the hours with samples and the sample count.
+const refreshDaySummaryStatement = `
+        INSERT INTO consumption_summaries (period, start_time, total, peak, peak_time, hours, sample_count, updated_at)
+        SELECT 'day', $1, SUM(total), MAX(total), (array_agg(hour ORDER BY total DESC, hour))[1], COUNT(*), SUM(samples), now()
+        FROM (
+            SELECT time_bucket('1 hour', time) AS hour, SUM(value) AS total, COUNT(*) AS samples
+            FROM time_series_data
+            WHERE time >= $1 AND time < $2
+            GROUP BY hour
+        ) hours
+        HAVING COUNT(*) > 0
+        ON CONFLICT (period, start_time) DO UPDATE SET
+            total = EXCLUDED.total,
+            peak = EXCLUDED.peak,
+            peak_time = EXCLUDED.peak_time,
+            hours = EXCLUDED.hours,
+            sample_count = EXCLUDED.sample_count,
+            updated_at = EXCLUDED.updated_at
+    `
+
+// refreshMonthSummaryStatement recomputes the summary of the UTC month
+// [$1, $2) from the summaries of its days.
+const refreshMonthSummaryStatement = `
+        INSERT INTO consumption_summaries (period, start_time, total, peak, peak_time, hours, sample_count, updated_at)
+        SELECT 'month', $1, SUM(total), MAX(peak), (array_agg(peak_time ORDER BY peak DESC, peak_time))[1], SUM(hours), SUM(sample_count), now()
+        FROM consumption_summaries
+        WHERE period = 'day' AND start_time >= $1 AND start_time < $2
+        HAVING COUNT(*) > 0
+        ON CONFLICT (period, start_time) DO UPDATE SET
+            total = EXCLUDED.total,
+            peak = EXCLUDED.peak,
+            peak_time = EXCLUDED.peak_time,
+            hours = EXCLUDED.hours,
+            sample_count = EXCLUDED.sample_count,
+            updated_at = EXCLUDED.updated_at
+    `
+
+// summariesQuery selects the summaries of a period starting in [$2, $3),
+// earliest first.
+const summariesQuery = `
+        SELECT start_time, total, peak, peak_time, hours, sample_count, updated_at
+        FROM consumption_summaries
+        WHERE period = $1 AND start_time >= $2 AND start_time < $3
+        ORDER BY start_time
+    `
+
+// seriesMetadataQuery selects the metadata row of a series.
+const seriesMetadataQuery = `
+        SELECT first_time, last_time, point_count, updated_at, unit, description, tags
+        FROM series_metadata
+        WHERE series = $1
+    `
+
+// listSeriesMetadataQuery selects the metadata rows by series name whose
+// tags contain the JSON object $1 and carry every key of $2, both served
+// by the GIN index on tags.
+const listSeriesMetadataQuery = `
+        SELECT series, first_time, last_time, point_count, updated_at, unit, description, tags
+        FROM series_metadata
+        WHERE tags @> $1::jsonb AND tags ?& $2::text[]
+        ORDER BY series
+    `
+
+// describeSeriesStatement sets the unit, description and JSON tags of a
+// series, creating its metadata row if no samples have been stored yet.
+const describeSeriesStatement = `
+        INSERT INTO series_metadata (series, unit, description, tags, updated_at)
+        VALUES ($1, $2, $3, $4, now())
+        ON CONFLICT (series) DO UPDATE SET
+            unit = EXCLUDED.unit,
+            description = EXCLUDED.description,
+            tags = EXCLUDED.tags,
+            updated_at = EXCLUDED.updated_at
+    `
+
+// watermarkQuery selects the ingest watermark of a series.
+const watermarkQuery = `
+        SELECT watermark
+        FROM ingest_watermarks
+        WHERE series = $1
+    `
+
+// advanceWatermarkStatement moves the ingest watermark of a series forward.
+// It never moves backwards, so concurrent or late callers cannot undo
+// progress.
+const advanceWatermarkStatement = `
+        INSERT INTO ingest_watermarks (series, watermark, updated_at)
+        VALUES ($1, $2, now())
+        ON CONFLICT (series) DO UPDATE SET
+            watermark = GREATEST(ingest_watermarks.watermark, EXCLUDED.watermark),
+            updated_at = EXCLUDED.updated_at
+    `
+
+// dailyWindowAveragesQuery averages the samples in [$1, $2) shifted back
+// by each whole number of days from 0 to $3, in UTC, in a single query.
+// Days whose window holds no samples are omitted.
+const dailyWindowAveragesQuery = `
+        SELECT d.days, AVG(t.value)
+        FROM generate_series(0, $3::int) AS d(days)
+        JOIN time_series_data t
+          ON t.time >= ($1::timestamptz AT TIME ZONE 'UTC' - make_interval(days => d.days)) AT TIME ZONE 'UTC'
+         AND t.time < ($2::timestamptz AT TIME ZONE 'UTC' - make_interval(days => d.days)) AT TIME ZONE 'UTC'
+        GROUP BY d.days
+    `
+
+// insertDemandResponseEventStatement records an event and returns its
+// assigned ID and creation time.
+const insertDemandResponseEventStatement = `
+        INSERT INTO demand_response_events (name, start_time, end_time, target_reduction)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at
+    `
+
+// demandResponseEventsQuery selects the events overlapping [$1, $2),
+// earliest first.
+const demandResponseEventsQuery = `
+        SELECT id, name, start_time, end_time, target_reduction, created_at
+        FROM demand_response_events
+        WHERE start_time < $2 AND end_time > $1
+        ORDER BY start_time, id
+    `
+
+// saveVirtualSeriesStatement creates or replaces a virtual series and
+// returns its creation and update times.
+const saveVirtualSeriesStatement = `
+        INSERT INTO virtual_series (name, expression, unit, description, tags)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (name) DO UPDATE SET
+            expression = EXCLUDED.expression,
+            unit = EXCLUDED.unit,
+            description = EXCLUDED.description,
+            tags = EXCLUDED.tags,
+            updated_at = now()
+        RETURNING created_at, updated_at
+    `
+
+// virtualSeriesQuery selects the saved virtual series by name.
+const virtualSeriesQuery = `
+        SELECT name, expression, unit, description, tags, created_at, updated_at
+        FROM virtual_series
+        ORDER BY name
+    `
+
+// deleteVirtualSeriesStatement deletes the virtual series $1.
+const deleteVirtualSeriesStatement = `
+        DELETE FROM virtual_series
+        WHERE name = $1
+    `
+
+// virtualSeriesLockKey is the advisory lock serialising changes to
+// virtual_series across service instances, so each is checked against
+// the definitions it applies to.
+const virtualSeriesLockKey = 0x7669727475616c // "virtual"
+
+// setIngestSourceStatement records whether the ingestion source $1 is
+// enabled and returns the time of the change.
+const setIngestSourceStatement = `
+        INSERT INTO ingest_sources (name, enabled, reason)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (name) DO UPDATE SET
+            enabled = EXCLUDED.enabled,
+            reason = EXCLUDED.reason,
+            updated_at = now()
+        RETURNING updated_at
+    `
+
+// lockIngestSourceQuery selects whether the ingestion source $1 is
+// enabled, locking its state until the transaction ends.
+const lockIngestSourceQuery = `
+        SELECT enabled
+        FROM ingest_sources
+        WHERE name = $1
+        FOR UPDATE
+    `
+
+// ingestSourcesQuery selects the recorded ingestion source states by name.
+const ingestSourcesQuery = `
+        SELECT name, enabled, reason, updated_at
+        FROM ingest_sources
+        ORDER BY name
+    `
+
+// pendingGapsQuery selects unresolved ingest gaps, oldest range first.
+const pendingGapsQuery = `
+        SELECT id, start_time, end_time, reason, created_at
+        FROM ingest_gaps
+        WHERE resolved_at IS NULL
+        ORDER BY start_time
+    `
+
+// latestQuery selects the most recent samples, newest first. On a
+// hypertable the descending time index lets TimescaleDB's ordered append
+// read only the newest chunk, the same plan it uses for last(value, time),
+// so the cost does not grow with the amount of stored history.
+const latestQuery = `
+        SELECT time, value
+        FROM time_series_data
+        ORDER BY time DESC
+        LIMIT $1
+    `
+
+// schemaVersionQuery selects the highest applied migration.
+const schemaVersionQuery = `
+        SELECT COALESCE(MAX(version), 0)
+        FROM schema_migrations
+    `
+
+// tagFilter returns what the tags of the series matchers select must
+// include: the JSON object of the labels equal matchers require, and the
+// labels the other matchers not matching empty values require to be set.
+// Matchers of the series name and matchers satisfied by missing labels
+// do not narrow the tags.
+func tagFilter(matchers []*labels.Matcher) (string, []string, error) {
+	contains := make(map[string]string)
+	keys := []string{}
+	for _, m := range matchers {
+		if m.Name == labels.NameLabel || m.Matches("") {
+			continue
+		}
+		if m.Type == labels.MatchEqual {
+			contains[m.Name] = m.Value
+		} else {
+			keys = append(keys, m.Name)
+		}
+	}
+	encoded, err := json.Marshal(contains)
+	return string(encoded), keys, err
+}
+
+// windowInterval converts a window such as "5m" into a canonical Postgres
+// interval literal such as "5 minutes". The literal is always rebuilt from
+// the parsed number and unit, so the original string never reaches the
+// database.
+func windowInterval(window string) (string, error) {
+	match := windowPattern.FindStringSubmatch(window)
+	if match == nil {
+		return "", fmt.Errorf("invalid window: %q", window)
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid window: %q", window)
+	}
+
+	return fmt.Sprintf("%d %s", n, windowUnits[match[2]]), nil
+}
+
+// buildAggregationQuery returns the SQL and bound arguments for a bucketed
+// aggregation over [start, end].
+//
+// The window is validated and converted to an interval bound as $3, and
+// the aggregation is resolved against a fixed allow-list, so neither can
+// alter the structure of the statement.
+func buildAggregationQuery(
+	start, end time.Time,
+	window string,
+	aggregation string,
+) (string, []interface{}, error) {
+	expr, ok := aggregateExpressions[aggregation]
+	if !ok {
+		return "", nil, fmt.Errorf("invalid aggregation type: %s", aggregation)
+	}
+
+	interval, err := windowInterval(window)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf(aggregationQueryTemplate, expr), []interface{}{start, end, interval}, nil
+}
+
+// buildBusinessHoursQuery returns the SQL and bound arguments for a bucketed
+// aggregation over [start, end] that only includes samples within the
+// working hours of cal.
+func buildBusinessHoursQuery(
+	start, end time.Time,
+	window string,
+	aggregation string,
+	cal *calendar.Calendar,
+) (string, []interface{}, error) {
+	expr, ok := aggregateExpressions[aggregation]
+	if !ok {
+		return "", nil, fmt.Errorf("invalid aggregation type: %s", aggregation)
+	}
+
+	interval, err := windowInterval(window)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if cal == nil || len(cal.Hours) == 0 {
+		return "", nil, fmt.Errorf("calendar has no working hours")
+	}
+
+	holidays := cal.Holidays
+	if holidays == nil {
+		holidays = []string{}
+	}
+	args := []interface{}{start, end, interval, cal.Location.String(), pq.Array(holidays)}
+
+	ranges := make([]string, 0, len(cal.Hours))
+	for _, hours := range cal.Hours {
+		n := len(args)
+		ranges = append(ranges, fmt.Sprintf(businessHoursRangeTemplate, n+1, n+2, n+3))
+		args = append(args, pq.Array(isoWeekdays(hours.Days)), clockTime(hours.Start), clockTime(hours.End))
+	}
+
+	return fmt.Sprintf(businessHoursQueryTemplate, expr, strings.Join(ranges, " OR ")), args, nil
+}
+
+// buildPagedQuery wraps a bucketed aggregation query and its arguments so
+// that only the first limit buckets after the bucket at after are
+// returned.
+func buildPagedQuery(query string, args []interface{}, after time.Time, limit int) (string, []interface{}) {
+	n := len(args)
+	return fmt.Sprintf(pagedQueryTemplate, query, n+1, n+2), append(args, after, limit)
+}
+
+// isoWeekdays converts weekdays to ISO numbers, Monday being 1 and Sunday 7.
+func isoWeekdays(days []time.Weekday) []int64 {
+	iso := make([]int64, len(days))
+	for i, day := range days {
+		iso[i] = int64((day+6)%7) + 1
+	}
+	return iso
+}
+
+// clockTime formats an offset from midnight as a Postgres time literal.
+func clockTime(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d:%02d",
+		int(offset/time.Hour), int(offset%time.Hour/time.Minute), int(offset%time.Minute/time.Second))
+}
+
+// auditLockKey is the advisory lock serialising appends to audit_log
+// across service instances, so every record chains to the latest one.
+const auditLockKey = 0x65646765636f6d // "edgecom"
+
+// lastAuditRecordQuery selects the latest audit record.
+const lastAuditRecordQuery = `
+        SELECT seq, time, subject, provider, source, action, request, code, peer, prev_hash, hash
+        FROM audit_log
+        ORDER BY seq DESC
+        LIMIT 1
+    `
+
+// insertAuditRecordStatement appends an audit record.
+const insertAuditRecordStatement = `
+        INSERT INTO audit_log (seq, time, subject, provider, source, action, request, code, peer, prev_hash, hash)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    `
+
+// auditRecordsQuery selects up to $2 audit records after sequence number
+// $1, in order.
+const auditRecordsQuery = `
+        SELECT seq, time, subject, provider, source, action, request, code, peer, prev_hash, hash
+        FROM audit_log
+        WHERE seq > $1
+        ORDER BY seq
+        LIMIT $2
+    `
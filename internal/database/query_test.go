@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
+)
+
+func TestWindowInterval(t *testing.T) {
+	tests := []struct {
+		window  string
+		want    string
+		wantErr bool
+	}{
+		{window: "1m", want: "1 minutes"},
+		{window: "5m", want: "5 minutes"},
+		{window: "1h", want: "1 hours"},
+		{window: "1d", want: "1 days"},
+		{window: "30s", want: "30 seconds"},
+		{window: "", wantErr: true},
+		{window: "0m", wantErr: true},
+		{window: "1w", wantErr: true},
+		{window: "1 hour", wantErr: true},
+		{window: "1h'; DROP TABLE time_series_data; --", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			got, err := windowInterval(tt.window)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuildAggregationQuery(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	query, args, err := buildAggregationQuery(start, end, "1h", "AVG")
+	require.NoError(t, err)
+	assert.Contains(t, query, "time_bucket($3::interval, time)")
+	assert.Contains(t, query, "AVG(value)")
+	assert.Equal(t, []interface{}{start, end, "1 hours"}, args)
+
+	query, _, err = buildAggregationQuery(start, end, "1h", "LOAD_FACTOR")
+	require.NoError(t, err)
+	assert.Contains(t, query, "COALESCE(AVG(value) / NULLIF(MAX(value), 0), 0)")
+
+	_, _, err = buildAggregationQuery(start, end, "1h", "AVG(value)); DROP TABLE time_series_data; --")
+	assert.Error(t, err)
+
+	_, _, err = buildAggregationQuery(start, end, "1 hour') --", "AVG")
+	assert.Error(t, err)
+}
+
+func TestUtilizationWithoutCapacity(t *testing.T) {
+	repo := &PostgresRepo{}
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	_, err := repo.Query(context.Background(), start, end, "1h", "UTILIZATION")
+	assert.ErrorIs(t, err, ErrNoContractedCapacity)
+	_, err = repo.QueryPage(context.Background(), start, end, "1h", "UTILIZATION", nil, time.Time{}, 10)
+	assert.ErrorIs(t, err, ErrNoContractedCapacity)
+}
+
+func TestBuildBusinessHoursQuery(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+
+	cal, err := calendar.New(calendar.Config{
+		Timezone: "Europe/Berlin",
+		Hours: []calendar.HoursConfig{
+			{Days: []string{"mon", "fri"}, Start: "08:00", End: "18:30"},
+			{Days: []string{"sun"}, Start: "10:00", End: "24:00"},
+		},
+		Holidays: []string{"2024-11-25"},
+	})
+	require.NoError(t, err)
+
+	query, args, err := buildBusinessHoursQuery(start, end, "1d", "SUM", cal)
+	require.NoError(t, err)
+	assert.Contains(t, query, "time_bucket($3::interval, time, $4)")
+	assert.Contains(t, query, "SUM(value)")
+	assert.Contains(t, query, "ANY($6::int[])")
+	assert.Contains(t, query, "< $11::time)")
+	assert.Equal(t, []interface{}{
+		start, end, "1 days", "Europe/Berlin", pq.Array([]string{"2024-11-25"}),
+		pq.Array([]int64{1, 5}), "08:00:00", "18:30:00",
+		pq.Array([]int64{7}), "10:00:00", "24:00:00",
+	}, args)
+
+	_, _, err = buildBusinessHoursQuery(start, end, "1d", "MEDIAN", cal)
+	assert.Error(t, err)
+
+	_, _, err = buildBusinessHoursQuery(start, end, "1d", "SUM", &calendar.Calendar{Location: time.UTC})
+	assert.ErrorContains(t, err, "no working hours")
+}
+
+func TestBuildPagedQuery(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	after := start.Add(5 * time.Hour)
+
+	inner, args, err := buildAggregationQuery(start, end, "1h", "MAX")
+	require.NoError(t, err)
+
+	query, args := buildPagedQuery(inner, args, after, 100)
+	assert.Contains(t, query, "FROM (")
+	assert.Contains(t, query, "WHERE bucket_time > $4")
+	assert.Contains(t, query, "LIMIT $5")
+	assert.Equal(t, []interface{}{start, end, "1 hours", after, 100}, args)
+}
+
+func TestTagFilter(t *testing.T) {
+	matchers, err := labels.ParseSelector(`net_load{site="plant1",phase=~"A|B",feeder!="",env!="test",rack=~".*"}`)
+	require.NoError(t, err)
+
+	contains, keys, err := tagFilter(matchers)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"site": "plant1"}`, contains)
+	assert.Equal(t, []string{"phase", "feeder"}, keys, "matchers satisfied by missing labels do not narrow the tags")
+
+	contains, keys, err = tagFilter(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", contains)
+	assert.Empty(t, keys)
+}
+
+// canonicalIntervalPattern matches every interval literal windowInterval
+// may produce.
+var canonicalIntervalPattern = regexp.MustCompile(`^[1-9][0-9]{0,5} (seconds|minutes|hours|days)$`)
+
+// FuzzBuildAggregationQuery checks that no window or aggregation string can
+// change the SQL text: accepted inputs always yield one of the fixed
+// statements, and the bound interval is always a canonical literal.
+func FuzzBuildAggregationQuery(f *testing.F) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	// The complete set of statements the builder is allowed to emit
+	canonical := make(map[string]bool)
+	for aggregation := range aggregateExpressions {
+		query, _, err := buildAggregationQuery(start, end, "1m", aggregation)
+		require.NoError(f, err)
+		canonical[query] = true
+	}
+
+	seeds := []struct{ window, aggregation string }{
+		{"1m", "MIN"},
+		{"5m", "MAX"},
+		{"1h", "AVG"},
+		{"1d", "SUM"},
+		{"1h'); DROP TABLE time_series_data; --", "AVG"},
+		{"1h", "SUM(value)) FROM pg_user; --"},
+		{"1 day' OR '1'='1", "MIN"},
+		{"1h\n", "AVG"},
+		{"$1", "MAX"},
+		{"1h/*", "avg"},
+	}
+	for _, seed := range seeds {
+		f.Add(seed.window, seed.aggregation)
+	}
+
+	f.Fuzz(func(t *testing.T, window, aggregation string) {
+		query, args, err := buildAggregationQuery(start, end, window, aggregation)
+		if err != nil {
+			return
+		}
+
+		if !canonical[query] {
+			t.Fatalf("window %q and aggregation %q produced non-canonical SQL:\n%s", window, aggregation, query)
+		}
+		if len(args) != 3 {
+			t.Fatalf("expected 3 bound arguments, got %d", len(args))
+		}
+
+		interval, ok := args[2].(string)
+		if !ok || !canonicalIntervalPattern.MatchString(interval) {
+			t.Fatalf("window %q produced non-canonical interval %q", window, args[2])
+		}
+	})
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Replica defaults
+const (
+	// DefaultReplicaCheckInterval is how often the replicas are checked
+	DefaultReplicaCheckInterval = 5 * time.Second
+	// DefaultReplicaMaxLag is how far a replica may trail the primary
+	// before reads stop being routed to it
+	DefaultReplicaMaxLag = 30 * time.Second
+	// replicaCheckTimeout bounds each check of a replica
+	replicaCheckTimeout = 3 * time.Second
+)
+
+// replicaStateQuery reports whether a replica is receiving WAL from the
+// primary, whether it trails the primary by more than $1 seconds, and the
+// WAL position it has replayed up to. A replica that has replayed all the
+// WAL it received is not lagging however long ago the last transaction
+// was, as the primary may simply be idle, but only while its WAL receiver
+// is running: one that lost the primary has replayed all it received and
+// falls further behind unnoticed. A server that is not a replica never
+// lags. The receiver is listed in pg_stat_wal_receiver to any role.
+const replicaStateQuery = `
+    SELECT
+        NOT pg_is_in_recovery() OR EXISTS (SELECT 1 FROM pg_stat_wal_receiver),
+        pg_is_in_recovery() AND COALESCE(
+            pg_last_wal_receive_lsn() IS DISTINCT FROM pg_last_wal_replay_lsn()
+                AND now() - pg_last_xact_replay_timestamp() > make_interval(secs => $1),
+            false),
+        COALESCE(
+            CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END - '0/0'::pg_lsn,
+            0)::bigint`
+
+// walPositionQuery returns the WAL position the primary has reached
+const walPositionQuery = `SELECT (pg_current_wal_lsn() - '0/0'::pg_lsn)::bigint`
+
+// unknownWALPosition is recorded as the position of a write when it could
+// not be read, which no replica reaches
+const unknownWALPosition = math.MaxUint64
+
+// ReplicaPolicy controls how read replicas are checked.
+type ReplicaPolicy struct {
+	// CheckInterval is how often each replica is checked;
+	// DefaultReplicaCheckInterval when zero
+	CheckInterval time.Duration
+	// MaxLag is how far a replica may trail the primary and still serve
+	// reads; DefaultReplicaMaxLag when zero
+	MaxLag time.Duration
+}
+
+// Validate checks that the policy's values are usable.
+func (p ReplicaPolicy) Validate() error {
+	if p.CheckInterval < 0 {
+		return fmt.Errorf("replica check interval must not be negative")
+	}
+	if p.MaxLag < 0 {
+		return fmt.Errorf("replica max lag must not be negative")
+	}
+	return nil
+}
+
+// replica is the connection pool of a read replica
+type replica struct {
+	// name identifies the replica in logs without its credentials
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+	// replayed is the WAL position the replica had replayed up to when
+	// last checked
+	replayed atomic.Uint64
+}
+
+// replicaSet routes reads across the healthy replicas in turn. Replicas
+// are healthy once a check reaches them and finds them receiving WAL
+// within the maximum lag, and unhealthy once a check or a read fails to
+// reach them. Reads only go to replicas that had replayed this process's
+// latest write when last checked, so data just written is read back.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	policy   ReplicaPolicy
+	logger   *logrus.Logger
+	// written is the WAL position the primary had reached after the
+	// latest write
+	written atomic.Uint64
+
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	reads   *prometheus.CounterVec
+	healthy prometheus.Gauge
+}
+
+// newReplicaSet opens a pool for each of dsns with driverName. The
+// replicas are unhealthy until checked.
+func newReplicaSet(driverName string, dsns []string, policy ReplicaPolicy, logger *logrus.Logger) (*replicaSet, error) {
+	r := &replicaSet{
+		policy: policy,
+		logger: logger,
+		stop:   make(chan struct{}),
+		reads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "edgecom_db_replica_reads_total",
+			Help: "Reads eligible for a replica, by the server that answered them: replica or primary",
+		}, []string{"target"}),
+		healthy: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "edgecom_db_replicas_healthy",
+			Help: "Read replicas serving reads",
+		}),
+	}
+	for i, dsn := range dsns {
+		db, err := sql.Open(driverName, dsn)
+		if err != nil {
+			r.close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		r.replicas = append(r.replicas, &replica{name: replicaName(i, dsn), db: db})
+	}
+	return r, nil
+}
+
+// replicaName names the replica at index i of the configured list by its
+// host, leaving out the credentials its DSN may hold
+func replicaName(i int, dsn string) string {
+	host := ""
+	if u, err := url.Parse(dsn); err == nil && u.Host != "" {
+		host = u.Host
+	} else {
+		for _, field := range strings.Fields(dsn) {
+			if value, ok := strings.CutPrefix(field, "host="); ok {
+				host = value
+			}
+		}
+	}
+	if host == "" {
+		return fmt.Sprintf("replica %d", i+1)
+	}
+	return fmt.Sprintf("replica %d (%s)", i+1, host)
+}
+
+// pick returns the next healthy replica in turn that has replayed the
+// latest write, or nil if there is none
+func (r *replicaSet) pick() *replica {
+	if r == nil {
+		return nil
+	}
+	written := r.written.Load()
+	n := uint64(len(r.replicas))
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if replica := r.replicas[(start+i)%n]; replica.healthy.Load() && replica.replayed.Load() >= written {
+			return replica
+		}
+	}
+	return nil
+}
+
+// wrote records the WAL position the primary had reached after a write,
+// or unknownWALPosition if it could not be read, which keeps reads on the
+// primary until a later write records its position
+func (r *replicaSet) wrote(position uint64) {
+	for {
+		current := r.written.Load()
+		if current != unknownWALPosition && current >= position {
+			return
+		}
+		if r.written.CompareAndSwap(current, position) {
+			return
+		}
+	}
+}
+
+// countRead counts a read eligible for a replica by the target answering
+// it
+func (r *replicaSet) countRead(target string) {
+	if r != nil {
+		r.reads.WithLabelValues(target).Inc()
+	}
+}
+
+// setHealthy records the health of a replica, logging changes, with the
+// error that made it unhealthy
+func (r *replicaSet) setHealthy(replica *replica, healthy bool, err error) {
+	if replica.healthy.Swap(healthy) == healthy {
+		return
+	}
+	entry := r.logger.WithField("replica", replica.name)
+	if healthy {
+		entry.Info("Routing reads to database replica")
+	} else {
+		entry.WithError(err).Warn("Stopped routing reads to database replica")
+	}
+
+	n := 0
+	for _, other := range r.replicas {
+		if other.healthy.Load() {
+			n++
+		}
+	}
+	r.healthy.Set(float64(n))
+}
+
+// check checks every replica once
+func (r *replicaSet) check() {
+	maxLag := r.policy.MaxLag
+	if maxLag == 0 {
+		maxLag = DefaultReplicaMaxLag
+	}
+
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+			defer cancel()
+
+			var receiving, lagging bool
+			var replayed int64
+			err := rep.db.QueryRowContext(ctx, replicaStateQuery, maxLag.Seconds()).Scan(&receiving, &lagging, &replayed)
+			switch {
+			case err != nil:
+			case !receiving:
+				err = fmt.Errorf("replica is not receiving WAL from the primary")
+			case lagging:
+				err = fmt.Errorf("replica trails the primary by more than %s", maxLag)
+			default:
+				rep.replayed.Store(uint64(replayed))
+			}
+			r.setHealthy(rep, err == nil, err)
+		}(rep)
+	}
+	wg.Wait()
+}
+
+// run checks the replicas as often as the policy sets until close
+func (r *replicaSet) run() {
+	interval := r.policy.CheckInterval
+	if interval == 0 {
+		interval = DefaultReplicaCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+// observe marks replica unhealthy if err shows it cannot be reached, and
+// reports whether the read should be run on the primary instead: the
+// replica could not answer it, or cancelled it to replay conflicting WAL
+func (r *replicaSet) observe(replica *replica, err error) bool {
+	if isFailoverError(err) {
+		r.setHealthy(replica, false, err)
+	}
+	return isTransientError(err)
+}
+
+// collectors returns the replica metrics
+func (r *replicaSet) collectors() []prometheus.Collector {
+	return []prometheus.Collector{r.reads, r.healthy}
+}
+
+// close stops the checks and closes the replica pools
+func (r *replicaSet) close() error {
+	if r == nil {
+		return nil
+	}
+	r.closeOnce.Do(func() { close(r.stop) })
+	var firstErr error
+	for _, replica := range r.replicas {
+		if err := replica.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// recordWrite records the WAL position the primary has reached after a
+// write, so that reads are not routed to replicas that have not replayed
+// it yet
+func (s *PostgresRepo) recordWrite(ctx context.Context) {
+	if s.replicas == nil {
+		return
+	}
+	var position int64
+	if err := s.db.QueryRowContext(ctx, walPositionQuery).Scan(&position); err != nil {
+		s.db.logger.WithError(err).Warn("Failed to read the WAL position of a write, reading from the primary")
+		s.replicas.wrote(unknownWALPosition)
+		return
+	}
+	s.replicas.wrote(uint64(position))
+}
+
+// queryRead runs a read that tolerates replication lag on a healthy
+// replica that has replayed this process's latest write, or on the
+// primary when there is none or the replica could not answer it.
+func (s *PostgresRepo) queryRead(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if replica := s.replicas.pick(); replica != nil {
+		rows, err := replica.db.QueryContext(ctx, query, args...)
+		if err == nil {
+			s.replicas.countRead("replica")
+			return rows, nil
+		}
+		if ctx.Err() != nil || !s.replicas.observe(replica, err) {
+			return nil, withContextError(ctx, err)
+		}
+	}
+	s.replicas.countRead("primary")
+	return s.db.QueryContext(ctx, query, args...)
+}
+
+// queryRowRead is queryRead for reads of a single row.
+func (s *PostgresRepo) queryRowRead(ctx context.Context, query string, args ...interface{}) scanner {
+	return scanFunc(func(dest ...interface{}) error {
+		if replica := s.replicas.pick(); replica != nil {
+			err := replica.db.QueryRowContext(ctx, query, args...).Scan(dest...)
+			if err == nil || errors.Is(err, sql.ErrNoRows) {
+				s.replicas.countRead("replica")
+				return err
+			}
+			if ctx.Err() != nil || !s.replicas.observe(replica, err) {
+				return withContextError(ctx, err)
+			}
+		}
+		s.replicas.countRead("primary")
+		return s.db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	})
+}
+
+// scanner is a row to scan, such as *sql.Row
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanFunc is a scanner running a function
+type scanFunc func(dest ...interface{}) error
+
+func (f scanFunc) Scan(dest ...interface{}) error { return f(dest...) }
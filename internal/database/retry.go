@@ -0,0 +1,190 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Retry defaults
+const (
+	// DefaultRetryAttempts is the number of times a statement is run,
+	// including the first, before a transient error is returned
+	DefaultRetryAttempts = 3
+	// DefaultRetryBackoff is the delay before the first retry, doubled
+	// after each one
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// RetryPolicy bounds the retries of statements failing with transient
+// errors, such as serialization failures, deadlocks and connection resets.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, so 1 disables retries;
+	// DefaultRetryAttempts when zero
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubling with each
+	// further retry; DefaultRetryBackoff when zero
+	Backoff time.Duration
+}
+
+// Validate checks that the policy's values are usable.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("retry attempts must not be negative")
+	}
+	if p.Backoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative")
+	}
+	return nil
+}
+
+// isTransientError reports whether a statement failing with err may
+// succeed if run again shortly: the transaction lost a serialization
+// conflict or a deadlock, the connection was lost, or the repository is
+// reconnecting to a new primary.
+func isTransientError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"53300": // too_many_connections
+			return true
+		}
+	}
+	return errors.Is(err, ErrFailover) || isFailoverError(err)
+}
+
+// IsTransient reports whether an operation failing with err may succeed if
+// it is retried shortly: the database is failing over, unreachable, out of
+// resources such as connections, or the transaction lost a conflict. Other
+// errors, such as invalid statements or violated constraints, persist.
+func IsTransient(err error) bool {
+	if isTransientError(err) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection_exception
+			"53", // insufficient_resources
+			"57": // operator_intervention, such as query_canceled
+			return true
+		}
+	}
+	return errors.Is(err, sql.ErrConnDone)
+}
+
+// withContextError returns err, also wrapping the error of ctx if the
+// server canceled the statement (query_canceled) because ctx ended, so that
+// callers can tell a cancelled or timed out call from other failures with
+// errors.Is
+func withContextError(ctx context.Context, err error) error {
+	var pqErr *pq.Error
+	if ctx.Err() == nil || !errors.As(err, &pqErr) || pqErr.Code != "57014" || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ctx.Err(), err)
+}
+
+// isAmbiguousError reports whether err leaves it unknown if the statement
+// was applied: the connection was lost before the server answered. Writes
+// failing with such errors are not retried, as they may have been
+// committed.
+func isAmbiguousError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// transaction_resolution_unknown
+		return pqErr.Code == "08007"
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// The connection was never established
+		return false
+	}
+	var opErr *net.OpError
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.As(err, &opErr)
+}
+
+// retry runs fn until it succeeds, fails with an error retryable says is
+// not worth retrying, the policy's attempts are used up, or ctx is done.
+func (p *pool) retry(ctx context.Context, retryable func(error) bool, fn func() error) (err error) {
+	defer func() { err = withContextError(ctx, err) }()
+	attempts := p.retryPolicy.MaxAttempts
+	if attempts == 0 {
+		attempts = DefaultRetryAttempts
+	}
+	backoff := p.retryPolicy.Backoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+
+		p.retries.Inc()
+		p.logger.WithError(err).WithField("attempt", attempt).Debug("Retrying database statement")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isRetryableRead reports whether a read failing with err is worth
+// retrying
+func isRetryableRead(err error) bool {
+	return isTransientError(err)
+}
+
+// isRetryableWrite reports whether a write failing with err is worth
+// retrying, which it is only if it was certainly not applied
+func isRetryableWrite(err error) bool {
+	return isTransientError(err) && !isAmbiguousError(err)
+}
+
+// inTx runs fn in a transaction and commits it, retrying the whole
+// transaction on transient errors. A transaction whose commit was
+// interrupted is not retried, since it may have been committed.
+func (p *pool) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	var committing bool
+	return p.retry(ctx, func(err error) bool {
+		if committing {
+			return isRetryableWrite(err)
+		}
+		// Transactions interrupted before their commit are rolled back
+		return isTransientError(err)
+	}, func() (err error) {
+		committing = false
+		tx, err := p.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback() // rollback if not committed
+		defer func() { err = p.observe(nil, err) }()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		committing = true
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil
+	})
+}
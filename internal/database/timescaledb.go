@@ -23,11 +23,20 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
 	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // TimeSeriesRepository defines the interface for time series operations.
@@ -35,6 +44,10 @@ import (
 // This interface provides methods for:
 //   - Single and batch data insertion
 //   - Time series querying with aggregation
+//   - Tracking ranges that could not be ingested
+//   - Tracking how far ingestion has progressed
+//   - Recording demand response events
+//   - Saving virtual series definitions
 //   - Resource cleanup
 //
 // Supported aggregations:
@@ -51,23 +64,141 @@ import (
 type TimeSeriesRepository interface {
 	// InsertTimeSeriesData inserts a single time series data point.
 	// Returns an error if the insertion fails.
+	//
+	// Deprecated: Use InsertTimeSeriesDataContext, which honours cancellation
+	// and deadlines.
 	InsertTimeSeriesData(timestamp time.Time, value float64) error
 
+	// InsertTimeSeriesDataContext inserts a single time series data point.
+	// The insert is abandoned if ctx is cancelled or its deadline passes.
+	InsertTimeSeriesDataContext(ctx context.Context, timestamp time.Time, value float64) error
+
 	// Query retrieves time series data within the specified time range.
-	// Supports different time windows (1m, 5m, 1h, 1d) and aggregation methods (MIN, MAX, AVG, SUM).
+	// Supports different time windows (1m, 5m, 1h, 1d) and aggregation methods (MIN, MAX, AVG, SUM),
+	// and the derived LOAD_FACTOR (average over peak) and UTILIZATION (peak over contracted capacity).
+	// UTILIZATION fails with ErrNoContractedCapacity when no capacity is configured.
 	// Returns the aggregated data points and any error encountered.
 	Query(ctx context.Context, start, end time.Time, window string, aggregation string) ([]models.TimeSeriesData, error)
 
+	// QueryBusinessHours is Query restricted to samples within the working
+	// hours of cal. Buckets are aligned in the calendar's time zone, so
+	// daily buckets cover local days.
+	QueryBusinessHours(ctx context.Context, start, end time.Time, window string, aggregation string, cal *calendar.Calendar) ([]models.TimeSeriesData, error)
+
+	// QueryPage is Query, or QueryBusinessHours when cal is not nil, limited
+	// to at most limit buckets after the bucket at after, in bucket order.
+	// The zero time starts at the first bucket, which supports keyset
+	// pagination on bucket time.
+	QueryPage(ctx context.Context, start, end time.Time, window string, aggregation string, cal *calendar.Calendar, after time.Time, limit int) ([]models.TimeSeriesData, error)
+
+	// QueryRaw retrieves stored samples within [start, end] without aggregation,
+	// ordered by time. The first skip matching rows are omitted and at most
+	// limit rows are returned, which supports keyset-style pagination.
+	QueryRaw(ctx context.Context, start, end time.Time, skip, limit int) ([]models.TimeSeriesData, error)
+
+	// QueryLatest retrieves the n most recent samples, newest first.
+	QueryLatest(ctx context.Context, n int) ([]models.TimeSeriesData, error)
+
+	// Statistics summarises the stored samples within [start, end] in a
+	// single aggregate query.
+	Statistics(ctx context.Context, start, end time.Time) (models.Statistics, error)
+
 	// BatchInsertTimeSeriesData inserts multiple time series data points in a single transaction.
 	// This method is optimized for bulk insertions by reducing database round trips.
+	// The series metadata and consumption summaries are updated in the same transaction.
 	// Returns an error if any part of the batch insertion fails.
 	BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error
 
+	// SeriesMetadata returns the stored time range and point count of the
+	// series, as of the last committed insert.
+	SeriesMetadata(ctx context.Context) (models.SeriesMetadata, error)
+
+	// ListSeriesMetadata returns the metadata of every series that has
+	// stored samples or a description and matches every label matcher, by
+	// name. Without matchers it returns every series.
+	ListSeriesMetadata(ctx context.Context, matchers []*labels.Matcher) ([]models.SeriesMetadata, error)
+
+	// DescribeSeries sets the unit, description and tags of a series,
+	// replacing the previous ones.
+	DescribeSeries(ctx context.Context, series string, description models.SeriesDescription) error
+
+	// Summaries returns the consumption summaries of period, models.PeriodDay
+	// or models.PeriodMonth, starting in [start, end), earliest first.
+	// Periods without samples have no summary.
+	Summaries(ctx context.Context, period string, start, end time.Time) ([]models.ConsumptionSummary, error)
+
+	// Watermark returns the time up to which the series has been ingested
+	// without gaps, or the zero time if none has been recorded.
+	Watermark(ctx context.Context) (time.Time, error)
+
+	// AdvanceWatermark moves the watermark of the series forward to t.
+	// Earlier times are ignored.
+	AdvanceWatermark(ctx context.Context, t time.Time) error
+
+	// RecordGap records a time range that could not be ingested so that it
+	// can be fetched again later.
+	RecordGap(ctx context.Context, start, end time.Time, reason string) error
+
+	// PendingGaps returns recorded gaps that have not been resolved yet,
+	// oldest range first.
+	PendingGaps(ctx context.Context) ([]models.Gap, error)
+
+	// ResolveGap marks a recorded gap as ingested.
+	ResolveGap(ctx context.Context, id int64) error
+
+	// DailyWindowAverages returns the average of the samples in
+	// [start, end) shifted back by each whole number of days from 0 to
+	// days, keyed by the number of days. Days without samples are
+	// omitted.
+	DailyWindowAverages(ctx context.Context, start, end time.Time, days int) (map[int]float64, error)
+
+	// RecordDemandResponseEvent stores a demand response event and returns
+	// it with its assigned ID and creation time.
+	RecordDemandResponseEvent(ctx context.Context, event models.DemandResponseEvent) (models.DemandResponseEvent, error)
+
+	// DemandResponseEvents returns the events overlapping [start, end),
+	// earliest first.
+	DemandResponseEvents(ctx context.Context, start, end time.Time) ([]models.DemandResponseEvent, error)
+
+	// SaveVirtualSeries creates or replaces the definition of a virtual
+	// series and returns it with its creation and update times. It is
+	// only saved if check, given every definition as they would be once
+	// saved, returns nil; its error is returned otherwise. Changes to the
+	// virtual series are serialised, so that two of them checked against
+	// the same definitions cannot both be saved.
+	SaveVirtualSeries(ctx context.Context, series models.VirtualSeries, check func([]models.VirtualSeries) error) (models.VirtualSeries, error)
+
+	// VirtualSeries returns the saved virtual series, by name.
+	VirtualSeries(ctx context.Context) ([]models.VirtualSeries, error)
+
+	// DeleteVirtualSeries removes the virtual series name, returning false
+	// if it was not saved. As for SaveVirtualSeries, it is only removed if
+	// check, given the remaining definitions, returns nil.
+	DeleteVirtualSeries(ctx context.Context, name string, check func([]models.VirtualSeries) error) (bool, error)
+
+	// SetIngestSource records whether an ingestion source is enabled and
+	// returns the state with the time of the change. With
+	// advanceWatermark, enabling a disabled source also advances the
+	// ingest watermark to the time of the change, in the same
+	// transaction, so that collection resumes from there instead of
+	// catching up on the period the source was disabled for.
+	SetIngestSource(ctx context.Context, source models.IngestSource, advanceWatermark bool) (models.IngestSource, error)
+
+	// IngestSources returns the recorded ingestion source states, by
+	// name. Sources never disabled or re-enabled have none.
+	IngestSources(ctx context.Context) ([]models.IngestSource, error)
+
+	// Ping verifies that the database is reachable.
+	Ping(ctx context.Context) error
+
 	// Close releases any resources held by the repository.
 	// Should be called when the repository is no longer needed.
 	Close() error
 }
 
+// DefaultSeries names the single series stored in time_series_data.
+const DefaultSeries = "default"
+
 // PostgresRepo implements TimeSeriesRepository using TimescaleDB.
 //
 // Features:
@@ -80,10 +211,38 @@ type TimeSeriesRepository interface {
 //   - Automatic chunk management
 //   - Parallel query execution
 //   - Time-bucket optimization
+//
+// When statements show that the primary was lost, for example because it
+// was demoted to a standby or its connections were reset, the connection
+// pool is replaced by one reaching the new primary, following the
+// ReconnectPolicy. Statements fail with ErrFailover, and Ping reports the
+// failover, until it is reached. MonitorConnection pings the database in
+// the background, so a lost primary is also found between statements.
+//
+// Statements failing with transient errors, such as serialization
+// failures, deadlocks and connection resets, are retried following the
+// RetryPolicy. Writes are only retried when the error shows they were not
+// applied.
+//
+// With read replicas added by AddReplicas, aggregation, raw and statistics
+// queries and consumption summaries, which serve dashboards and reports
+// and tolerate a little replication lag, are spread across the healthy
+// replicas in turn. Everything else,
+// including the latest samples, the watermark and the series metadata,
+// reads from the primary, as do all reads while no replica is healthy.
 type PostgresRepo struct {
-	db *sql.DB
+	db       *pool
+	replicas *replicaSet
+
+	// capacity is the contracted capacity of the series, in stored units,
+	// zero when none is configured
+	capacity float64
 }
 
+// ErrNoContractedCapacity is returned by UTILIZATION queries when no
+// contracted capacity is configured for the series.
+var ErrNoContractedCapacity = errors.New("contracted capacity is not configured")
+
 // NewPostgresRepo creates and initializes a new PostgresRepo.
 //
 // The connection string should be in the format:
@@ -108,16 +267,89 @@ func NewPostgresRepo(connStr string) (*PostgresRepo, error) {
 		return nil, err
 	}
 
-	return &PostgresRepo{db: db}, nil
+	return &PostgresRepo{db: newPool("postgres", connStr, db)}, nil
+}
+
+// SetReconnectPolicy sets how the repository reconnects after losing its
+// primary, logging to logger. It must be called before the repository is
+// used.
+func (s *PostgresRepo) SetReconnectPolicy(policy ReconnectPolicy, logger *logrus.Logger) {
+	s.db.policy = policy
+	s.db.logger = logger
+}
+
+// SetContractedCapacity sets the capacity contracted for the series, in
+// stored units, which UTILIZATION divides the peak of each bucket by. It
+// must be called before the repository is used.
+func (s *PostgresRepo) SetContractedCapacity(capacity float64) {
+	s.capacity = capacity
+}
+
+// SetRetryPolicy sets how statements failing with transient errors are
+// retried. It must be called before the repository is used.
+func (s *PostgresRepo) SetRetryPolicy(policy RetryPolicy) {
+	s.db.retryPolicy = policy
+}
+
+// RegisterMetrics registers the reconnection, retry, connection monitor
+// and batch insert size metrics with reg, and those of the read replicas
+// if any were added.
+func (s *PostgresRepo) RegisterMetrics(reg prometheus.Registerer) error {
+	collectors := s.db.collectors()
+	if s.replicas != nil {
+		collectors = append(collectors, s.replicas.collectors()...)
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register database metric: %v", err)
+		}
+	}
+	return nil
+}
+
+// AddReplicas opens a connection pool to each read replica in dsns and
+// checks them once, following policy, so that reads are routed to those
+// found healthy. It must be called before the repository is used.
+func (s *PostgresRepo) AddReplicas(dsns []string, policy ReplicaPolicy) error {
+	return s.addReplicas("postgres", dsns, policy)
 }
 
+func (s *PostgresRepo) addReplicas(driverName string, dsns []string, policy ReplicaPolicy) error {
+	if len(dsns) == 0 {
+		return nil
+	}
+	replicas, err := newReplicaSet(driverName, dsns, policy, s.db.logger)
+	if err != nil {
+		return err
+	}
+	replicas.check()
+	s.replicas = replicas
+	return nil
+}
+
+// MonitorConnection pings the database every ReconnectPolicy.PingInterval
+// in the background until Close, reconnecting when a ping shows that the
+// primary was lost, and checks the read replicas every
+// ReplicaPolicy.CheckInterval. It must be called after SetReconnectPolicy.
+func (s *PostgresRepo) MonitorConnection() {
+	go s.db.monitor()
+	if s.replicas != nil {
+		go s.replicas.run()
+	}
+}
+
+// InsertTimeSeriesData inserts a single data point without a context.
+//
+// Deprecated: Use InsertTimeSeriesDataContext.
 func (s *PostgresRepo) InsertTimeSeriesData(timestamp time.Time, value float64) error {
-	_, err := s.db.Exec(
-		"INSERT INTO time_series_data (time, value) VALUES ($1, $2)",
-		timestamp,
-		value,
-	)
-	return err
+	return s.InsertTimeSeriesDataContext(context.Background(), timestamp, value)
+}
+
+// InsertTimeSeriesDataContext inserts a single data point, giving up when ctx
+// is done so that shutdown is not held up by a hung connection. It is a
+// batch of one, so the series metadata stays consistent.
+func (s *PostgresRepo) InsertTimeSeriesDataContext(ctx context.Context, timestamp time.Time, value float64) error {
+	return s.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{{Time: timestamp, Value: value}})
 }
 
 // QueryTimeSeriesData retrieves and aggregates time series data.
@@ -127,12 +359,13 @@ func (s *PostgresRepo) InsertTimeSeriesData(timestamp time.Time, value float64)
 //   - start: Beginning of time range (inclusive)
 //   - end: End of time range (exclusive)
 //   - window: Time bucket size ("1m", "5m", "1h", "1d")
-//   - aggregation: Aggregation function ("MIN", "MAX", "AVG", "SUM")
+//   - aggregation: Aggregation function ("MIN", "MAX", "AVG", "SUM", "LOAD_FACTOR", "UTILIZATION")
 //
 // SQL Implementation:
 //
 //	Uses time_bucket() from TimescaleDB for efficient time-based grouping
-//	Implements dynamic aggregation selection via CASE statement
+//	Binds the window as an interval parameter and selects the aggregate
+//	from a fixed allow-list, so request values never alter the SQL text
 //
 // Returns:
 //   - []models.TimeSeriesData: Array of aggregated data points
@@ -151,45 +384,232 @@ func (s *PostgresRepo) QueryTimeSeriesData(
 	start, end time.Time,
 	window string,
 	aggregation string,
-) ([]models.TimeSeriesData, error) {
-	// Validate window and aggregation
-	if aggregation != "MIN" && aggregation != "MAX" && aggregation != "AVG" && aggregation != "SUM" {
-		return nil, fmt.Errorf("invalid aggregation type: %s", aggregation)
-	}
-
-	query := fmt.Sprintf(`
-        SELECT 
-            time_bucket('%s', time) as bucket_time,
-            CASE 
-                WHEN $3 = 'MIN' THEN MIN(value)
-                WHEN $3 = 'MAX' THEN MAX(value)
-                WHEN $3 = 'AVG' THEN AVG(value)
-                WHEN $3 = 'SUM' THEN SUM(value)
-            END as agg_value
-        FROM time_series_data
-        WHERE time BETWEEN $1 AND $2
-        GROUP BY bucket_time
-        ORDER BY bucket_time
-    `, window)
-
-	rows, err := s.db.QueryContext(ctx, query, start, end, aggregation)
+) (results []models.TimeSeriesData, err error) {
+	if err := s.checkAggregation(aggregation); err != nil {
+		return nil, err
+	}
+	query, args, err := buildAggregationQuery(start, end, window, aggregation)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(ctx, "SELECT", "time_series_data", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.queryRead(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []models.TimeSeriesData
 	for rows.Next() {
 		var r models.TimeSeriesData
-		if err := rows.Scan(&r.Time, &r.Value); err != nil {
+		if err := rows.Scan(&r.Time, &r.Value, &r.Count); err != nil {
 			return nil, err
 		}
+		if aggregation == utilizationAggregation {
+			r.Value /= s.capacity
+		}
 		results = append(results, r)
 	}
 
 	return results, nil
 }
 
+// checkAggregation returns ErrNoContractedCapacity for UTILIZATION queries
+// without a contracted capacity
+func (s *PostgresRepo) checkAggregation(aggregation string) error {
+	if aggregation == utilizationAggregation && s.capacity <= 0 {
+		return ErrNoContractedCapacity
+	}
+	return nil
+}
+
+// QueryBusinessHours retrieves and aggregates the samples that fall within
+// the working hours of cal.
+//
+// The calendar's time zone, holidays and working hours are bound as
+// parameters; each range of working hours adds one fixed predicate, so
+// only the number of ranges affects the SQL text. The aggregate of a bucket
+// covers its business hours only, and buckets without any are omitted.
+func (s *PostgresRepo) QueryBusinessHours(
+	ctx context.Context,
+	start, end time.Time,
+	window string,
+	aggregation string,
+	cal *calendar.Calendar,
+) (results []models.TimeSeriesData, err error) {
+	if err := s.checkAggregation(aggregation); err != nil {
+		return nil, err
+	}
+	query, args, err := buildBusinessHoursQuery(start, end, window, aggregation, cal)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(ctx, "SELECT", "time_series_data", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.queryRead(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.TimeSeriesData
+		if err := rows.Scan(&r.Time, &r.Value, &r.Count); err != nil {
+			return nil, err
+		}
+		if aggregation == utilizationAggregation {
+			r.Value /= s.capacity
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// QueryPage retrieves one page of aggregated buckets.
+//
+// Buckets partition time, so samples before after can only belong to the
+// bucket at after or earlier ones, which are not returned; the scan starts
+// at after instead of start and the cost of a page does not grow with the
+// number of pages before it.
+func (s *PostgresRepo) QueryPage(
+	ctx context.Context,
+	start, end time.Time,
+	window string,
+	aggregation string,
+	cal *calendar.Calendar,
+	after time.Time,
+	limit int,
+) (results []models.TimeSeriesData, err error) {
+	if err := s.checkAggregation(aggregation); err != nil {
+		return nil, err
+	}
+	scanStart := start
+	if after.After(start) {
+		scanStart = after
+	}
+
+	var query string
+	var args []interface{}
+	if cal != nil {
+		query, args, err = buildBusinessHoursQuery(scanStart, end, window, aggregation, cal)
+	} else {
+		query, args, err = buildAggregationQuery(scanStart, end, window, aggregation)
+	}
+	if err != nil {
+		return nil, err
+	}
+	query, args = buildPagedQuery(query, args, after, limit)
+
+	ctx, span := startSpan(ctx, "SELECT", "time_series_data", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.queryRead(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.TimeSeriesData
+		if err := rows.Scan(&r.Time, &r.Value, &r.Count); err != nil {
+			return nil, err
+		}
+		if aggregation == utilizationAggregation {
+			r.Value /= s.capacity
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// QueryRaw retrieves stored samples without aggregation.
+//
+// Rows are ordered by time and then value so that samples sharing a
+// timestamp are returned in a stable order across pages. Callers paginate
+// by moving start to the last returned timestamp and setting skip to the
+// number of rows already seen at that timestamp, which keeps the OFFSET
+// bounded by the number of duplicates rather than the page number.
+func (s *PostgresRepo) QueryRaw(
+	ctx context.Context,
+	start, end time.Time,
+	skip, limit int,
+) (results []models.TimeSeriesData, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "time_series_data", rawQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.queryRead(ctx, rawQuery, start, end, limit, skip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.TimeSeriesData
+		if err := rows.Scan(&r.Time, &r.Value); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// QueryLatest retrieves the n most recent stored samples, newest first.
+//
+// The query is an ORDER BY time DESC LIMIT scan, which TimescaleDB answers
+// from the newest chunk only, so polling for the current reading stays
+// cheap regardless of retention.
+func (s *PostgresRepo) QueryLatest(ctx context.Context, n int) (results []models.TimeSeriesData, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "time_series_data", latestQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, latestQuery, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.TimeSeriesData
+		if err := rows.Scan(&r.Time, &r.Value); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// Statistics summarises the stored samples in [start, end] with a single
+// aggregate scan, so callers do not have to fetch and reduce the series.
+func (s *PostgresRepo) Statistics(ctx context.Context, start, end time.Time) (stats models.Statistics, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "time_series_data", statisticsQuery)
+	defer func() { endSpan(span, err) }()
+
+	var min, max, avg, sum, stddev sql.NullFloat64
+	var first, last sql.NullTime
+	err = s.queryRowRead(ctx, statisticsQuery, start, end).
+		Scan(&stats.Count, &min, &max, &avg, &sum, &stddev, &first, &last)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Min = min.Float64
+	stats.Max = max.Float64
+	stats.Avg = avg.Float64
+	stats.Sum = sum.Float64
+	stats.Stddev = stddev.Float64
+	stats.FirstTime = first.Time
+	stats.LastTime = last.Time
+	return stats, nil
+}
+
 // BatchInsertTimeSeriesData performs bulk data insertion.
 //
 // The operation is atomic - either all data points are inserted or none.
@@ -203,46 +623,315 @@ func (s *PostgresRepo) QueryTimeSeriesData(
 //  1. Begin transaction
 //  2. Prepare statement
 //  3. Execute batch inserts
-//  4. Commit or rollback
+//  4. Update the series metadata and consumption summaries
+//  5. Commit or rollback
+//
+// The whole transaction is retried following the RetryPolicy when it fails
+// with a transient error, unless its commit was interrupted, as it may
+// then have been applied.
 //
 // Returns error if:
 //   - Transaction fails to start
 //   - Statement preparation fails
 //   - Any insert fails
 //   - Commit fails
-func (s *PostgresRepo) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
-	// Begin transaction
-	tx, err := s.db.BeginTx(ctx, nil)
+func (s *PostgresRepo) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) (err error) {
+	ctx, span := startSpan(ctx, "INSERT", "time_series_data", batchInsertStatement)
+	span.SetAttributes(attribute.Int("db.operation.batch.size", len(data)))
+	defer func() { endSpan(span, err) }()
+
+	err = s.db.inTx(ctx, func(tx *sql.Tx) error {
+		// Prepare the statement
+		stmt, err := tx.PrepareContext(ctx, batchInsertStatement)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		defer stmt.Close()
+
+		// Execute batch inserts
+		for _, point := range data {
+			if _, err := stmt.ExecContext(ctx, point.Time, point.Value); err != nil {
+				return fmt.Errorf("failed to insert data point: %w", err)
+			}
+		}
+
+		// Update the metadata in the same transaction so it never runs ahead
+		// of or behind the stored data
+		if err := updateSeriesMetadata(ctx, tx, data); err != nil {
+			return fmt.Errorf("failed to update series metadata: %w", err)
+		}
+		if err := updateSummaries(ctx, tx, data); err != nil {
+			return fmt.Errorf("failed to update consumption summaries: %w", err)
+		}
+		return nil
+	})
+	if err == nil {
+		s.db.batchSizes.Observe(float64(len(data)))
+		s.recordWrite(ctx)
+	}
+	return err
+}
+
+// updateSeriesMetadata folds a batch into the series metadata row.
+func updateSeriesMetadata(ctx context.Context, tx *sql.Tx, data []models.TimeSeriesData) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	first, last := data[0].Time, data[0].Time
+	for _, point := range data[1:] {
+		if point.Time.Before(first) {
+			first = point.Time
+		}
+		if point.Time.After(last) {
+			last = point.Time
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, upsertSeriesMetadataStatement, DefaultSeries, first, last, len(data))
+	return err
+}
+
+// Classes namespacing the advisory locks taken on the consumption summaries
+const (
+	summaryMonthLockClass = 8
+	summaryDayLockClass   = 9
+)
+
+// updateSummaries recomputes the summaries of the UTC days a batch falls
+// in, and of their months. Each day is locked before it is recomputed, so
+// that only batches of the same day wait for each other. Months are only
+// locked once all days are done, to recompute them from the summaries of
+// their days, which is quick. Days and months are locked in order, days
+// first, so that concurrent batches cannot overwrite each other's updates
+// or deadlock.
+func updateSummaries(ctx context.Context, tx *sql.Tx, data []models.TimeSeriesData) error {
+	days := make(map[time.Time]bool)
+	months := make(map[time.Time]bool)
+	for _, point := range data {
+		t := point.Time.UTC()
+		days[time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)] = true
+		months[time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)] = true
+	}
+
+	for _, day := range sortedTimes(days) {
+		if _, err := tx.ExecContext(ctx, lockSummaryStatement, summaryDayLockClass, day.Unix()/(24*60*60)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, refreshDaySummaryStatement, day, day.AddDate(0, 0, 1)); err != nil {
+			return err
+		}
+	}
+	for _, month := range sortedTimes(months) {
+		if _, err := tx.ExecContext(ctx, lockSummaryStatement, summaryMonthLockClass, month.Year()*12+int(month.Month())-1); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, refreshMonthSummaryStatement, month, month.AddDate(0, 1, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedTimes returns the times of a set in order
+func sortedTimes(set map[time.Time]bool) []time.Time {
+	times := make([]time.Time, 0, len(set))
+	for t := range set {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+// Summaries reads the consumption summaries of a period.
+func (s *PostgresRepo) Summaries(ctx context.Context, period string, start, end time.Time) (summaries []models.ConsumptionSummary, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "consumption_summaries", summariesQuery)
+	defer func() { endSpan(span, err) }()
+
+	if period != models.PeriodDay && period != models.PeriodMonth {
+		return nil, fmt.Errorf("invalid summary period: %q", period)
+	}
+	rows, err := s.queryRead(ctx, summariesQuery, period, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		summary := models.ConsumptionSummary{Period: period}
+		if err := rows.Scan(&summary.Start, &summary.Total, &summary.Peak, &summary.PeakTime,
+			&summary.Hours, &summary.SampleCount, &summary.UpdatedAt); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+// SeriesMetadata reads the metadata row of the default series. A series
+// without data has zero times and count.
+func (s *PostgresRepo) SeriesMetadata(ctx context.Context) (meta models.SeriesMetadata, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "series_metadata", seriesMetadataQuery)
+	defer func() { endSpan(span, err) }()
+
+	meta.Series = DefaultSeries
+
+	var first, last sql.NullTime
+	var tags []byte
+	err = s.db.QueryRowContext(ctx, seriesMetadataQuery, DefaultSeries).
+		Scan(&first, &last, &meta.PointCount, &meta.UpdatedAt, &meta.Unit, &meta.Description, &tags)
+	if err == sql.ErrNoRows {
+		return meta, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return meta, err
 	}
-	defer tx.Rollback() // rollback if not committed
 
-	// Prepare the statement
-	stmt, err := tx.PrepareContext(ctx, `
-        INSERT INTO time_series_data (time, value) 
-        VALUES ($1, $2)
-    `)
+	meta.FirstTime = first.Time
+	meta.LastTime = last.Time
+	return meta, unmarshalTags(tags, &meta.Tags)
+}
+
+// ListSeriesMetadata reads the rows of series_metadata the matchers
+// select. The rows are narrowed in the database by the tags the matchers
+// require, then matched exactly.
+func (s *PostgresRepo) ListSeriesMetadata(
+	ctx context.Context,
+	matchers []*labels.Matcher,
+) (series []models.SeriesMetadata, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "series_metadata", listSeriesMetadataQuery)
+	defer func() { endSpan(span, err) }()
+
+	contains, keys, err := tagFilter(matchers)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, listSeriesMetadataQuery, contains, pq.Array(keys))
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+		return nil, err
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	// Execute batch inserts
-	for _, point := range data {
-		if _, err := stmt.ExecContext(ctx, point.Time, point.Value); err != nil {
-			return fmt.Errorf("failed to insert data point: %w", err)
+	for rows.Next() {
+		var meta models.SeriesMetadata
+		var first, last sql.NullTime
+		var tags []byte
+		if err := rows.Scan(&meta.Series, &first, &last, &meta.PointCount, &meta.UpdatedAt,
+			&meta.Unit, &meta.Description, &tags); err != nil {
+			return nil, err
+		}
+		meta.FirstTime = first.Time
+		meta.LastTime = last.Time
+		if err := unmarshalTags(tags, &meta.Tags); err != nil {
+			return nil, err
 		}
+		if labels.MatchSeries(matchers, meta.Series, meta.Tags) {
+			series = append(series, meta)
+		}
+	}
+
+	return series, rows.Err()
+}
+
+// DescribeSeries upserts the description columns of a series_metadata row.
+func (s *PostgresRepo) DescribeSeries(ctx context.Context, series string, description models.SeriesDescription) (err error) {
+	ctx, span := startSpan(ctx, "INSERT", "series_metadata", describeSeriesStatement)
+	defer func() { endSpan(span, err) }()
+
+	encoded, err := marshalTags(description.Tags)
+	if err != nil {
+		return err
 	}
+	_, err = s.db.ExecContext(ctx, describeSeriesStatement, series, description.Unit, description.Description, encoded)
+	return err
+}
 
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+// marshalTags encodes the tags of a series as a JSON object
+func marshalTags(tags map[string]string) ([]byte, error) {
+	if tags == nil {
+		tags = map[string]string{}
 	}
+	return json.Marshal(tags)
+}
 
+// unmarshalTags decodes the JSON tags of a series, leaving tags nil when
+// there are none
+func unmarshalTags(encoded []byte, tags *map[string]string) error {
+	if err := json.Unmarshal(encoded, tags); err != nil {
+		return fmt.Errorf("invalid series tags: %w", err)
+	}
+	if len(*tags) == 0 {
+		*tags = nil
+	}
 	return nil
 }
 
+// Watermark reads the ingest watermark of the default series.
+func (s *PostgresRepo) Watermark(ctx context.Context) (watermark time.Time, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "ingest_watermarks", watermarkQuery)
+	defer func() { endSpan(span, err) }()
+
+	err = s.db.QueryRowContext(ctx, watermarkQuery, DefaultSeries).Scan(&watermark)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return watermark, err
+}
+
+// AdvanceWatermark moves the ingest watermark of the default series
+// forward.
+func (s *PostgresRepo) AdvanceWatermark(ctx context.Context, t time.Time) (err error) {
+	ctx, span := startSpan(ctx, "INSERT", "ingest_watermarks", advanceWatermarkStatement)
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.db.ExecContext(ctx, advanceWatermarkStatement, DefaultSeries, t)
+	return err
+}
+
+// RecordGap stores an unfetched range in the ingest_gaps table.
+func (s *PostgresRepo) RecordGap(ctx context.Context, start, end time.Time, reason string) (err error) {
+	const statement = "INSERT INTO ingest_gaps (start_time, end_time, reason) VALUES ($1, $2, $3)"
+
+	ctx, span := startSpan(ctx, "INSERT", "ingest_gaps", statement)
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.db.ExecContext(ctx, statement, start, end, reason)
+	return err
+}
+
+// PendingGaps lists unresolved gaps ordered by the start of the range.
+func (s *PostgresRepo) PendingGaps(ctx context.Context) (gaps []models.Gap, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "ingest_gaps", pendingGapsQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, pendingGapsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Gap
+		if err := rows.Scan(&g.ID, &g.Start, &g.End, &g.Reason, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, g)
+	}
+
+	return gaps, rows.Err()
+}
+
+// ResolveGap sets resolved_at on a recorded gap.
+func (s *PostgresRepo) ResolveGap(ctx context.Context, id int64) (err error) {
+	const statement = "UPDATE ingest_gaps SET resolved_at = now() WHERE id = $1"
+
+	ctx, span := startSpan(ctx, "UPDATE", "ingest_gaps", statement)
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.db.ExecContext(ctx, statement, id)
+	return err
+}
+
 // Query implements the TimeSeriesRepository interface.
 // Delegates to QueryTimeSeriesData for actual implementation.
 //
@@ -256,11 +945,265 @@ func (s *PostgresRepo) Query(
 	return s.QueryTimeSeriesData(ctx, start, end, window, aggregation)
 }
 
+// DailyWindowAverages averages the samples in [start, end) and the same
+// window on each of the previous days in one query.
+func (s *PostgresRepo) DailyWindowAverages(ctx context.Context, start, end time.Time, days int) (averages map[int]float64, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "time_series_data", dailyWindowAveragesQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, dailyWindowAveragesQuery, start, end, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	averages = make(map[int]float64)
+	for rows.Next() {
+		var day int
+		var average float64
+		if err = rows.Scan(&day, &average); err != nil {
+			return nil, err
+		}
+		averages[day] = average
+	}
+	return averages, rows.Err()
+}
+
+// RecordDemandResponseEvent inserts an event into demand_response_events.
+func (s *PostgresRepo) RecordDemandResponseEvent(
+	ctx context.Context,
+	event models.DemandResponseEvent,
+) (_ models.DemandResponseEvent, err error) {
+	ctx, span := startSpan(ctx, "INSERT", "demand_response_events", insertDemandResponseEventStatement)
+	defer func() { endSpan(span, err) }()
+
+	err = s.db.ExecReturningContext(ctx, insertDemandResponseEventStatement,
+		event.Name, event.Start, event.End, event.TargetReduction,
+	).Scan(&event.ID, &event.CreatedAt)
+	return event, err
+}
+
+// DemandResponseEvents lists the events overlapping [start, end).
+func (s *PostgresRepo) DemandResponseEvents(
+	ctx context.Context,
+	start, end time.Time,
+) (events []models.DemandResponseEvent, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "demand_response_events", demandResponseEventsQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, demandResponseEventsQuery, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.DemandResponseEvent
+		if err := rows.Scan(&e.ID, &e.Name, &e.Start, &e.End, &e.TargetReduction, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// SaveVirtualSeries upserts a definition into virtual_series, holding
+// virtualSeriesLockKey while the definitions are checked.
+func (s *PostgresRepo) SaveVirtualSeries(
+	ctx context.Context,
+	series models.VirtualSeries,
+	check func([]models.VirtualSeries) error,
+) (_ models.VirtualSeries, err error) {
+	ctx, span := startSpan(ctx, "INSERT", "virtual_series", saveVirtualSeriesStatement)
+	defer func() { endSpan(span, err) }()
+
+	tags, err := marshalTags(series.Tags)
+	if err != nil {
+		return series, err
+	}
+	err = s.db.inTx(ctx, func(tx *sql.Tx) error {
+		virtual, err := lockVirtualSeries(ctx, tx)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(virtual, func(v models.VirtualSeries) bool { return v.Name == series.Name })
+		if i >= 0 {
+			virtual[i] = series
+		} else {
+			virtual = append(virtual, series)
+		}
+		if err := check(virtual); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, saveVirtualSeriesStatement,
+			series.Name, series.Expression, series.Unit, series.Description, tags,
+		).Scan(&series.CreatedAt, &series.UpdatedAt)
+	})
+	return series, err
+}
+
+// VirtualSeries lists the definitions in virtual_series.
+func (s *PostgresRepo) VirtualSeries(ctx context.Context) (series []models.VirtualSeries, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "virtual_series", virtualSeriesQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, virtualSeriesQuery)
+	if err != nil {
+		return nil, err
+	}
+	return scanVirtualSeries(rows)
+}
+
+// DeleteVirtualSeries deletes a definition from virtual_series, holding
+// virtualSeriesLockKey while the remaining definitions are checked.
+func (s *PostgresRepo) DeleteVirtualSeries(
+	ctx context.Context,
+	name string,
+	check func([]models.VirtualSeries) error,
+) (deleted bool, err error) {
+	ctx, span := startSpan(ctx, "DELETE", "virtual_series", deleteVirtualSeriesStatement)
+	defer func() { endSpan(span, err) }()
+
+	err = s.db.inTx(ctx, func(tx *sql.Tx) error {
+		virtual, err := lockVirtualSeries(ctx, tx)
+		if err != nil {
+			return err
+		}
+		remaining := slices.DeleteFunc(virtual, func(v models.VirtualSeries) bool { return v.Name == name })
+		if deleted = len(remaining) < len(virtual); !deleted {
+			return nil
+		}
+		if err := check(remaining); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, deleteVirtualSeriesStatement, name)
+		return err
+	})
+	return deleted && err == nil, err
+}
+
+// lockVirtualSeries takes virtualSeriesLockKey for the rest of tx and
+// reads the saved definitions.
+func lockVirtualSeries(ctx context.Context, tx *sql.Tx) ([]models.VirtualSeries, error) {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", virtualSeriesLockKey); err != nil {
+		return nil, fmt.Errorf("failed to lock virtual series: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, virtualSeriesQuery)
+	if err != nil {
+		return nil, err
+	}
+	return scanVirtualSeries(rows)
+}
+
+// scanVirtualSeries reads and closes rows of virtualSeriesQuery.
+func scanVirtualSeries(rows *sql.Rows) (series []models.VirtualSeries, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var v models.VirtualSeries
+		var tags []byte
+		if err := rows.Scan(&v.Name, &v.Expression, &v.Unit, &v.Description, &tags, &v.CreatedAt, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := unmarshalTags(tags, &v.Tags); err != nil {
+			return nil, err
+		}
+		series = append(series, v)
+	}
+
+	return series, rows.Err()
+}
+
+// SetIngestSource upserts a state into ingest_sources, locking the
+// previous one to tell whether the source is re-enabled.
+func (s *PostgresRepo) SetIngestSource(
+	ctx context.Context,
+	source models.IngestSource,
+	advanceWatermark bool,
+) (_ models.IngestSource, err error) {
+	ctx, span := startSpan(ctx, "INSERT", "ingest_sources", setIngestSourceStatement)
+	defer func() { endSpan(span, err) }()
+
+	err = s.db.inTx(ctx, func(tx *sql.Tx) error {
+		// Sources without a state are enabled
+		wasEnabled := true
+		err := tx.QueryRowContext(ctx, lockIngestSourceQuery, source.Name).Scan(&wasEnabled)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, setIngestSourceStatement,
+			source.Name, source.Enabled, source.Reason,
+		).Scan(&source.UpdatedAt); err != nil {
+			return err
+		}
+		if advanceWatermark && source.Enabled && !wasEnabled {
+			_, err = tx.ExecContext(ctx, advanceWatermarkStatement, DefaultSeries, source.UpdatedAt)
+		}
+		return err
+	})
+	return source, err
+}
+
+// IngestSources lists the states in ingest_sources.
+func (s *PostgresRepo) IngestSources(ctx context.Context) (sources []models.IngestSource, err error) {
+	ctx, span := startSpan(ctx, "SELECT", "ingest_sources", ingestSourcesQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, ingestSourcesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var source models.IngestSource
+		if err := rows.Scan(&source.Name, &source.Enabled, &source.Reason, &source.UpdatedAt); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, rows.Err()
+}
+
+// LatestSchemaVersion is the number of the latest migration in
+// migrations/, which the service expects to be applied.
+const LatestSchemaVersion = 12
+
+// SchemaVersion returns the number of the latest migration applied to the
+// database, or 0 if the database predates version tracking.
+func (s *PostgresRepo) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, schemaVersionQuery).Scan(&version)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "42P01" {
+		// undefined_table: schema_migrations was added in migration 6
+		return 0, nil
+	}
+	return version, err
+}
+
+// Now returns the database server's clock.
+func (s *PostgresRepo) Now(ctx context.Context) (now time.Time, err error) {
+	err = s.db.QueryRowContext(ctx, "SELECT now()").Scan(&now)
+	return now, err
+}
+
+// Ping checks database connectivity, establishing a connection if needed.
+func (s *PostgresRepo) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 // Close releases all database resources.
 //
 // Should be called when the repository is no longer needed.
 // Typically deferred after repository creation.
 func (s *PostgresRepo) Close() error {
+	if err := s.replicas.close(); err != nil {
+		s.db.Close()
+		return err
+	}
 	return s.db.Close()
 }
 
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates spans around repository statements. It uses the global
+// provider, so spans are no-ops until tracing is set up.
+var tracer = otel.Tracer("github.com/tejusbharadwaj/edgecom/internal/database")
+
+// startSpan starts a client span for a statement, named and annotated
+// following the OpenTelemetry database conventions.
+func startSpan(ctx context.Context, operation, table, statement string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName(table),
+			semconv.DBQueryText(statement),
+		),
+	)
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,115 @@
+// Package demandresponse evaluates demand response events: windows in which
+// a site was asked to reduce consumption.
+//
+// The achieved reduction of an event is its baseline minus the average
+// value measured during the event. The baseline is the average value in
+// the same window of the day on each of the previous BaselineDays days,
+// averaged over the days that have data.
+//
+// Example Usage:
+//
+//	performance, err := demandresponse.Evaluate(ctx, repo, event, time.Now())
+//	if err != nil {
+//	    return err
+//	}
+//	fmt.Printf("reduced by %.1f of %.1f\n", performance.AchievedReduction, event.TargetReduction)
+package demandresponse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// BaselineDays is the number of previous days the baseline is drawn from
+const BaselineDays = 10
+
+// MaxEventDuration bounds the length of an event, so that the baseline
+// windows on previous days do not overlap
+const MaxEventDuration = 24 * time.Hour
+
+// Averager is the subset of the repository needed to evaluate events.
+type Averager interface {
+	// DailyWindowAverages returns the average of the samples in
+	// [start, end) shifted back by each whole number of days from 0 to
+	// days, keyed by the number of days, omitting days without samples
+	DailyWindowAverages(ctx context.Context, start, end time.Time, days int) (map[int]float64, error)
+}
+
+// Performance is the outcome of a demand response event.
+type Performance struct {
+	Event models.DemandResponseEvent
+	// Evaluated is false when the event window or every baseline day
+	// lacks data; the figures below are then zero
+	Evaluated bool
+	// Complete is true once the event window has ended
+	Complete bool
+	// Baseline is the expected average value without the event
+	Baseline float64
+	// Actual is the average value measured during the event
+	Actual float64
+	// AchievedReduction is Baseline minus Actual
+	AchievedReduction float64
+	// TargetAchieved is AchievedReduction as a fraction of the target
+	TargetAchieved float64
+	// BaselineDays is the number of previous days with data
+	BaselineDays int
+}
+
+// Validate checks that an event can be recorded.
+func Validate(event models.DemandResponseEvent) error {
+	switch {
+	case event.Name == "":
+		return fmt.Errorf("event name is required")
+	case len(event.Name) > 200:
+		return fmt.Errorf("event name exceeds 200 characters")
+	case event.Start.IsZero() || event.End.IsZero():
+		return fmt.Errorf("event start and end are required")
+	case !event.Start.Before(event.End):
+		return fmt.Errorf("event start must be before its end")
+	case event.End.Sub(event.Start) > MaxEventDuration:
+		return fmt.Errorf("event exceeds the maximum duration of %s", MaxEventDuration)
+	case !(event.TargetReduction > 0):
+		return fmt.Errorf("target reduction must be positive")
+	}
+	return nil
+}
+
+// Evaluate computes the performance of event from the stored data, as of
+// now. Events still in progress are evaluated on the data so far.
+func Evaluate(ctx context.Context, averager Averager, event models.DemandResponseEvent, now time.Time) (Performance, error) {
+	performance := Performance{
+		Event:    event,
+		Complete: !now.Before(event.End),
+	}
+
+	// The event window and its baseline days are averaged together
+	averages, err := averager.DailyWindowAverages(ctx, event.Start, event.End, BaselineDays)
+	if err != nil {
+		return performance, fmt.Errorf("failed to average event %d: %w", event.ID, err)
+	}
+	actual, ok := averages[0]
+	if !ok {
+		return performance, nil
+	}
+
+	var sum float64
+	for day := 1; day <= BaselineDays; day++ {
+		if average, ok := averages[day]; ok {
+			sum += average
+			performance.BaselineDays++
+		}
+	}
+	if performance.BaselineDays == 0 {
+		return performance, nil
+	}
+
+	performance.Evaluated = true
+	performance.Actual = actual
+	performance.Baseline = sum / float64(performance.BaselineDays)
+	performance.AchievedReduction = performance.Baseline - actual
+	performance.TargetAchieved = performance.AchievedReduction / event.TargetReduction
+	return performance, nil
+}
@@ -0,0 +1,125 @@
+package demandresponse
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// fakeAverager returns the average stored for each window start.
+type fakeAverager map[time.Time]float64
+
+func (f fakeAverager) DailyWindowAverages(_ context.Context, start, _ time.Time, days int) (map[int]float64, error) {
+	averages := make(map[int]float64)
+	for day := 0; day <= days; day++ {
+		if value, ok := f[start.AddDate(0, 0, -day)]; ok {
+			averages[day] = value
+		}
+	}
+	return averages, nil
+}
+
+type failingAverager struct{}
+
+func (failingAverager) DailyWindowAverages(context.Context, time.Time, time.Time, int) (map[int]float64, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestEvaluate(t *testing.T) {
+	start := time.Date(2024, 7, 15, 14, 0, 0, 0, time.UTC)
+	event := models.DemandResponseEvent{
+		ID:              7,
+		Name:            "peak shave",
+		Start:           start,
+		End:             start.Add(2 * time.Hour),
+		TargetReduction: 20,
+	}
+
+	t.Run("reduction against the baseline", func(t *testing.T) {
+		averager := fakeAverager{
+			start:                   85,
+			start.AddDate(0, 0, -1): 100,
+			start.AddDate(0, 0, -2): 110,
+			start.AddDate(0, 0, -9): 90,
+			// Outside the baseline period
+			start.AddDate(0, 0, -11): 1000,
+		}
+
+		performance, err := Evaluate(context.Background(), averager, event, start.Add(3*time.Hour))
+		require.NoError(t, err)
+		assert.True(t, performance.Evaluated)
+		assert.True(t, performance.Complete)
+		assert.Equal(t, 3, performance.BaselineDays)
+		assert.Equal(t, 100.0, performance.Baseline)
+		assert.Equal(t, 85.0, performance.Actual)
+		assert.Equal(t, 15.0, performance.AchievedReduction)
+		assert.Equal(t, 0.75, performance.TargetAchieved)
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		averager := fakeAverager{start: 85, start.AddDate(0, 0, -1): 100}
+
+		performance, err := Evaluate(context.Background(), averager, event, start.Add(time.Hour))
+		require.NoError(t, err)
+		assert.True(t, performance.Evaluated)
+		assert.False(t, performance.Complete)
+	})
+
+	t.Run("no baseline data", func(t *testing.T) {
+		performance, err := Evaluate(context.Background(), fakeAverager{start: 85}, event, start.Add(3*time.Hour))
+		require.NoError(t, err)
+		assert.False(t, performance.Evaluated)
+		assert.Zero(t, performance.AchievedReduction)
+	})
+
+	t.Run("no event data", func(t *testing.T) {
+		averager := fakeAverager{start.AddDate(0, 0, -1): 100}
+
+		performance, err := Evaluate(context.Background(), averager, event, start.Add(3*time.Hour))
+		require.NoError(t, err)
+		assert.False(t, performance.Evaluated)
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		_, err := Evaluate(context.Background(), failingAverager{}, event, start)
+		assert.ErrorContains(t, err, "failed to average event 7")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	start := time.Date(2024, 7, 15, 14, 0, 0, 0, time.UTC)
+	valid := models.DemandResponseEvent{Name: "peak shave", Start: start, End: start.Add(time.Hour), TargetReduction: 5}
+
+	tests := []struct {
+		name    string
+		modify  func(*models.DemandResponseEvent)
+		wantErr string
+	}{
+		{name: "valid", modify: func(*models.DemandResponseEvent) {}},
+		{name: "missing name", modify: func(e *models.DemandResponseEvent) { e.Name = "" }, wantErr: "name is required"},
+		{name: "missing end", modify: func(e *models.DemandResponseEvent) { e.End = time.Time{} }, wantErr: "start and end are required"},
+		{name: "reversed window", modify: func(e *models.DemandResponseEvent) { e.End = e.Start }, wantErr: "before its end"},
+		{name: "too long", modify: func(e *models.DemandResponseEvent) { e.End = e.Start.Add(25 * time.Hour) }, wantErr: "maximum duration"},
+		{name: "no target", modify: func(e *models.DemandResponseEvent) { e.TargetReduction = 0 }, wantErr: "must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := valid
+			tt.modify(&event)
+
+			err := Validate(event)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
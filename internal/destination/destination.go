@@ -0,0 +1,186 @@
+// Package destination stores files produced by the service, such as
+// exports and scheduled reports, in a local directory, an S3-compatible
+// bucket, a Google Cloud Storage bucket or on an SFTP server.
+//
+// Destinations are configured once by name and shared by every feature
+// that writes files, so each feature only has to name where its files go.
+//
+// Example Usage:
+//
+//	dest, err := destination.New(destination.Config{
+//	    Type:            destination.TypeS3,
+//	    Endpoint:        "http://minio:9000",
+//	    PathStyle:       true,
+//	    Region:          "us-east-1",
+//	    Bucket:          "edgecom",
+//	    Prefix:          "reports",
+//	    AccessKeyID:     os.Getenv("MINIO_ACCESS_KEY"),
+//	    SecretAccessKey: os.Getenv("MINIO_SECRET_KEY"),
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//
+//	// Stored as s3://edgecom/reports/edgecom-month-2024-11-01.pdf
+//	err = dest.Put(ctx, "edgecom-month-2024-11-01.pdf", file)
+package destination
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/tejusbharadwaj/edgecom/internal/sigv4"
+	"github.com/tejusbharadwaj/edgecom/internal/spill"
+)
+
+// Destination types
+const (
+	TypeLocal = "local"
+	TypeS3    = "s3"
+	TypeGCS   = "gcs"
+	TypeSFTP  = "sftp"
+)
+
+// Destination stores named files.
+type Destination interface {
+	// Put stores the content read from r under name, replacing an earlier
+	// file of the same name. Names are slash-separated relative paths.
+	Put(ctx context.Context, name string, r io.Reader) error
+}
+
+// Secret is a credential that may change while the service runs, such as
+// a *secret.File kept current by a secret.Watcher
+type Secret interface {
+	Value() string
+}
+
+// Config describes a destination. Which fields apply depends on Type:
+//
+//   - local: Path, the directory files are written to
+//   - s3: Bucket, Prefix, Region, AccessKeyID and SecretAccessKey or
+//     Credentials, with Endpoint and PathStyle for S3-compatible stores
+//     such as MinIO
+//   - gcs: Bucket, Prefix, and an HMAC key as AccessKeyID and
+//     SecretAccessKey or Credentials
+//   - sftp: Host, Port, User, KeyFile, KnownHostsFile, and Path, the
+//     remote directory. The key file is read on every upload, so it can
+//     be rotated in place.
+type Config struct {
+	Type string
+
+	Path string
+
+	Bucket          string
+	Prefix          string
+	Endpoint        string
+	Region          string
+	PathStyle       bool
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Credentials, when set, holds an AWS shared credentials file whose
+	// default profile is used in place of AccessKeyID, SecretAccessKey
+	// and SessionToken. It is read for every upload, so rotated keys
+	// apply without a restart.
+	Credentials Secret
+
+	// Spill is the directory uploads to S3, GCS and SFTP are staged in,
+	// the system temporary directory when nil
+	Spill *spill.Dir
+
+	Host           string
+	Port           int
+	User           string
+	KeyFile        string
+	KnownHostsFile string
+}
+
+// Validate checks that the fields required by the destination type are
+// set.
+func (c Config) Validate() error {
+	switch c.Type {
+	case TypeLocal:
+		if c.Path == "" {
+			return fmt.Errorf("a path is required")
+		}
+	case TypeS3, TypeGCS:
+		if c.Bucket == "" {
+			return fmt.Errorf("a bucket is required")
+		}
+		if c.Credentials != nil {
+			if c.AccessKeyID != "" || c.SecretAccessKey != "" || c.SessionToken != "" {
+				return fmt.Errorf("credentials and an access key are mutually exclusive")
+			}
+			if _, err := sigv4.ParseCredentials(c.Credentials.Value()); err != nil {
+				return err
+			}
+		} else if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return fmt.Errorf("an access key ID and secret access key are required")
+		}
+		if c.Type == TypeS3 && c.Region == "" && c.Endpoint == "" {
+			return fmt.Errorf("a region or endpoint is required")
+		}
+	case TypeSFTP:
+		if c.Host == "" || c.User == "" {
+			return fmt.Errorf("a host and user are required")
+		}
+		if c.Port < 0 || c.Port > 65535 {
+			return fmt.Errorf("invalid port %d", c.Port)
+		}
+	default:
+		return fmt.Errorf("unsupported destination type %q, expected %q, %q, %q or %q", c.Type, TypeLocal, TypeS3, TypeGCS, TypeSFTP)
+	}
+	return nil
+}
+
+// New creates the destination described by cfg.
+func New(cfg Config) (Destination, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case TypeLocal:
+		return NewLocal(cfg.Path), nil
+	case TypeS3:
+		return NewS3(cfg)
+	case TypeGCS:
+		return NewGCS(cfg)
+	default:
+		return NewSFTP(cfg), nil
+	}
+}
+
+// cleanName validates a file name and returns it in canonical form. Names
+// must stay inside the destination.
+func cleanName(name string) (string, error) {
+	cleaned := path.Clean(name)
+	if name == "" || path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return cleaned, nil
+}
+
+// spool copies r to a sealed spill file in dir, for uploads that need the
+// content length and checksum up front. The caller closes the file, which
+// removes it.
+func spool(dir *spill.Dir, r io.Reader) (*spill.File, error) {
+	if dir == nil {
+		dir = spill.NewDir("")
+	}
+	f, err := dir.Create()
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer upload: %w", err)
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Seal()
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to buffer upload: %w", err)
+	}
+	return f, nil
+}
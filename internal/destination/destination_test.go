@@ -0,0 +1,200 @@
+package destination
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/spill"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"local", Config{Type: TypeLocal, Path: "/tmp"}, ""},
+		{"local without path", Config{Type: TypeLocal}, "a path is required"},
+		{"s3", Config{Type: TypeS3, Bucket: "b", Region: "eu-west-1", AccessKeyID: "id", SecretAccessKey: "secret"}, ""},
+		{"s3 without credentials", Config{Type: TypeS3, Bucket: "b", Region: "eu-west-1"}, "an access key ID and secret access key are required"},
+		{"s3 with credentials", Config{Type: TypeS3, Bucket: "b", Region: "eu-west-1", Credentials: staticSecret(sharedCredentials)}, ""},
+		{"s3 with credentials and a key", Config{Type: TypeS3, Bucket: "b", Region: "eu-west-1", AccessKeyID: "id", Credentials: staticSecret(sharedCredentials)}, "mutually exclusive"},
+		{"s3 with invalid credentials", Config{Type: TypeS3, Bucket: "b", Region: "eu-west-1", Credentials: staticSecret("[other]\naws_access_key_id = id")}, "default profile"},
+		{"s3 without region", Config{Type: TypeS3, Bucket: "b", AccessKeyID: "id", SecretAccessKey: "secret"}, "a region or endpoint is required"},
+		{"gcs without bucket", Config{Type: TypeGCS, AccessKeyID: "id", SecretAccessKey: "secret"}, "a bucket is required"},
+		{"sftp", Config{Type: TypeSFTP, Host: "backup", User: "edgecom"}, ""},
+		{"sftp without user", Config{Type: TypeSFTP, Host: "backup"}, "a host and user are required"},
+		{"unknown type", Config{Type: "ftp"}, "unsupported destination type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// staticSecret is a Secret with a fixed value
+type staticSecret string
+
+func (s staticSecret) Value() string {
+	return string(s)
+}
+
+const sharedCredentials = `# written by vault agent
+[other]
+aws_access_key_id = OTHER
+
+[default]
+aws_access_key_id = AKIDROTATED
+aws_secret_access_key = rotated-secret
+aws_session_token = session
+`
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	dest := NewLocal(dir)
+	ctx := context.Background()
+
+	require.NoError(t, dest.Put(ctx, "reports/2024/report.pdf", strings.NewReader("first")))
+	require.NoError(t, dest.Put(ctx, "reports/2024/report.pdf", strings.NewReader("second")))
+
+	content, err := os.ReadFile(filepath.Join(dir, "reports", "2024", "report.pdf"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	entries, err := os.ReadDir(filepath.Join(dir, "reports", "2024"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files are removed")
+
+	for _, name := range []string{"", "../outside", "/etc/passwd", "."} {
+		assert.ErrorContains(t, dest.Put(ctx, name, strings.NewReader("x")), "invalid file name", name)
+	}
+}
+
+func TestS3(t *testing.T) {
+	var got *http.Request
+	var body string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got, body = r, string(data)
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		}
+	}))
+	defer server.Close()
+
+	spillDir := t.TempDir()
+	dest, err := NewS3(Config{
+		Type:            TypeS3,
+		Endpoint:        server.URL,
+		PathStyle:       true,
+		Region:          "eu-central-1",
+		Bucket:          "edgecom",
+		Prefix:          "/exports/",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		Spill:           spill.NewDir(spillDir),
+	})
+	require.NoError(t, err)
+	dest.now = func() time.Time { return time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC) }
+
+	require.NoError(t, dest.Put(context.Background(), "november report+1.pdf", strings.NewReader("time,value\n")))
+
+	assert.Equal(t, http.MethodPut, got.Method)
+	assert.Equal(t, "/edgecom/exports/november%20report%2B1.pdf", got.URL.EscapedPath())
+	assert.Equal(t, "time,value\n", body)
+	assert.Equal(t, int64(len(body)), got.ContentLength)
+	assert.Equal(t, "20241123T120000Z", got.Header.Get("X-Amz-Date"))
+	sum := sha256.Sum256([]byte(body))
+	assert.Equal(t, hex.EncodeToString(sum[:]), got.Header.Get("X-Amz-Content-Sha256"))
+	assert.Regexp(t, `^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20241123/eu-central-1/s3/aws4_request, `+
+		`SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=[0-9a-f]{64}$`, got.Header.Get("Authorization"))
+	staged, err := os.ReadDir(spillDir)
+	require.NoError(t, err)
+	assert.Empty(t, staged, "the staged upload is removed")
+
+	t.Run("error response", func(t *testing.T) {
+		status = http.StatusForbidden
+		err := dest.Put(context.Background(), "data.csv", strings.NewReader("x"))
+		assert.EqualError(t, err, "failed to upload exports/data.csv: 403 Forbidden: AccessDenied: Access Denied")
+	})
+
+	t.Run("credentials file", func(t *testing.T) {
+		status = http.StatusOK
+		dest, err := NewS3(Config{
+			Endpoint:    server.URL,
+			PathStyle:   true,
+			Region:      "eu-central-1",
+			Bucket:      "edgecom",
+			Credentials: staticSecret(sharedCredentials),
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, dest.Put(context.Background(), "data.csv", strings.NewReader("x")))
+		assert.Contains(t, got.Header.Get("Authorization"), "Credential=AKIDROTATED/")
+		assert.Equal(t, "session", got.Header.Get("X-Amz-Security-Token"))
+	})
+
+	t.Run("virtual-hosted style", func(t *testing.T) {
+		dest, err := NewS3(Config{Region: "eu-central-1", Bucket: "edgecom", Prefix: "reports"})
+		require.NoError(t, err)
+		assert.Equal(t, "https://edgecom.s3.eu-central-1.amazonaws.com/reports/a.pdf", dest.objectURL("reports/a.pdf"))
+	})
+
+	t.Run("gcs", func(t *testing.T) {
+		dest, err := NewGCS(Config{Bucket: "edgecom"})
+		require.NoError(t, err)
+		assert.Equal(t, "https://storage.googleapis.com/edgecom/a.pdf", dest.objectURL("a.pdf"))
+		assert.Equal(t, "auto", dest.region)
+	})
+}
+
+func TestSFTP(t *testing.T) {
+	// A stand-in sftp client that records its arguments and batch script
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sftp")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > \""+dir+"/args\"\ncat > \""+dir+"/batch\"\n"), 0o755))
+
+	dest := NewSFTP(Config{Type: TypeSFTP, Host: "backup.example.com", User: "edgecom", KeyFile: "/keys/id_ed25519", Path: "/srv/edgecom"})
+	dest.command = script
+
+	require.NoError(t, dest.Put(context.Background(), "reports/report.pdf", strings.NewReader("pdf")))
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-b - -P 22 -o BatchMode=yes -o StrictHostKeyChecking=yes -i /keys/id_ed25519 edgecom@backup.example.com\n", string(args))
+
+	batch, err := os.ReadFile(filepath.Join(dir, "batch"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(batch)), "\n")
+	require.Len(t, lines, 6)
+	assert.Equal(t, []string{`-mkdir "/srv"`, `-mkdir "/srv/edgecom"`, `-mkdir "/srv/edgecom/reports"`}, lines[:3])
+	assert.Regexp(t, `^put ".+" "/srv/edgecom/reports/\.report\.pdf\.part"$`, lines[3])
+	assert.Equal(t, `-rm "/srv/edgecom/reports/report.pdf"`, lines[4])
+	assert.Equal(t, `rename "/srv/edgecom/reports/.report.pdf.part" "/srv/edgecom/reports/report.pdf"`, lines[5])
+
+	t.Run("failures include the client output", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho 'Host key verification failed.' >&2\nexit 1\n"), 0o755))
+		err := dest.Put(context.Background(), "report.pdf", strings.NewReader("pdf"))
+		assert.ErrorContains(t, err, "Host key verification failed.")
+	})
+}
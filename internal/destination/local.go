@@ -0,0 +1,55 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local writes files to a directory on the local filesystem.
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a destination writing to dir, which is created on the
+// first write if it does not exist.
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Put writes the content to a temporary file and renames it into place,
+// so readers of the directory never see a partial file.
+func (l *Local) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(l.dir, filepath.FromSlash(name))
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return os.Rename(tmp.Name(), target)
+}
@@ -0,0 +1,166 @@
+package destination
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/sigv4"
+	"github.com/tejusbharadwaj/edgecom/internal/spill"
+)
+
+// gcsEndpoint serves the S3-compatible XML API of Google Cloud Storage
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// S3 uploads files to a bucket over the S3 API, signing requests with AWS
+// Signature Version 4. It works with AWS S3 and compatible stores such as
+// MinIO and Google Cloud Storage.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	pathStyle bool
+
+	static      sigv4.Credentials
+	credentials Secret
+
+	spill  *spill.Dir
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3 creates an S3 destination. Without an Endpoint, the regional AWS
+// endpoint is used. PathStyle addresses the bucket in the path instead of
+// the host name, which MinIO and most other compatible stores expect.
+func NewS3(cfg Config) (*S3, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		endpoint:  u,
+		region:    region,
+		bucket:    cfg.Bucket,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		pathStyle: cfg.PathStyle,
+		static: sigv4.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+		},
+		credentials: cfg.Credentials,
+		spill:       cfg.Spill,
+		client:      &http.Client{Timeout: 10 * time.Minute},
+		now:         time.Now,
+	}, nil
+}
+
+// NewGCS creates a destination for a Google Cloud Storage bucket, using
+// its S3-compatible XML API with an HMAC key of a service account.
+func NewGCS(cfg Config) (*S3, error) {
+	cfg.Endpoint = gcsEndpoint
+	cfg.Region = "auto"
+	cfg.PathStyle = true
+	cfg.SessionToken = ""
+	return NewS3(cfg)
+}
+
+// Put uploads the content as the object Prefix/name. The content is
+// staged in a spill file first, since the request is signed with its
+// length and checksum; an upload read from a spill file that no longer
+// matches its checksum fails.
+func (s *S3) Put(ctx context.Context, name string, r io.Reader) error {
+	name, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+
+	body, err := spool(s.spill, r)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = body.Size()
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	creds, err := s.currentCredentials()
+	if err != nil {
+		return err
+	}
+	sigv4.Sign(req, creds, s.region, "s3", hex.EncodeToString(body.Sum()), s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to upload %s: %s", key, responseError(resp))
+	}
+	return nil
+}
+
+// objectURL returns the URL of an object, with the bucket in the path or
+// the host name
+func (s *S3) objectURL(key string) string {
+	u := *s.endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		u.Path = basePath + "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = basePath + "/" + key
+	}
+	// Send the path exactly as it is signed
+	u.RawPath = sigv4.URIEncode(u.Path)
+	return u.String()
+}
+
+// currentCredentials returns the access key to sign a request with
+func (s *S3) currentCredentials() (sigv4.Credentials, error) {
+	if s.credentials == nil {
+		return s.static, nil
+	}
+	return sigv4.ParseCredentials(s.credentials.Value())
+}
+
+// responseError describes a failed response from its S3 error document,
+// or its status if it has none
+func responseError(resp *http.Response) string {
+	var doc struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := xml.Unmarshal(data, &doc); err != nil || doc.Code == "" {
+		return resp.Status
+	}
+	return fmt.Sprintf("%s: %s: %s", resp.Status, doc.Code, doc.Message)
+}
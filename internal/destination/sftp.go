@@ -0,0 +1,119 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/tejusbharadwaj/edgecom/internal/spill"
+)
+
+// SFTP uploads files to an SFTP server with the OpenSSH sftp client,
+// authenticating with a private key. The server's host key must be known,
+// either from KnownHostsFile or the user's known_hosts.
+type SFTP struct {
+	host           string
+	port           int
+	user           string
+	keyFile        string
+	knownHostsFile string
+	dir            string
+	spill          *spill.Dir
+
+	// command is the sftp client binary
+	command string
+}
+
+// NewSFTP creates an SFTP destination. Port defaults to 22, and files are
+// written relative to the login directory when Path is empty.
+func NewSFTP(cfg Config) *SFTP {
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+	return &SFTP{
+		host:           cfg.Host,
+		port:           port,
+		user:           cfg.User,
+		keyFile:        cfg.KeyFile,
+		knownHostsFile: cfg.KnownHostsFile,
+		dir:            cfg.Path,
+		spill:          cfg.Spill,
+		command:        "sftp",
+	}
+}
+
+// Put uploads the content under a temporary name in the target directory
+// and renames it into place, so readers never see a partial file.
+func (s *SFTP) Put(ctx context.Context, name string, r io.Reader) error {
+	name, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(name, "\"\n") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	target := name
+	if s.dir != "" {
+		target = path.Join(s.dir, name)
+	}
+	partial := path.Join(path.Dir(target), "."+path.Base(target)+".part")
+
+	body, err := spool(s.spill, r)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// sftp reads the file by name, so check it before handing it over
+	if err := body.Verify(); err != nil {
+		return err
+	}
+
+	// Commands prefixed with - may fail: the directories may already
+	// exist, and there may be no earlier file to replace
+	var batch strings.Builder
+	dir := path.Dir(target)
+	for _, parent := range parents(dir) {
+		fmt.Fprintf(&batch, "-mkdir \"%s\"\n", parent)
+	}
+	fmt.Fprintf(&batch, "put \"%s\" \"%s\"\n", body.Name(), partial)
+	fmt.Fprintf(&batch, "-rm \"%s\"\n", target)
+	fmt.Fprintf(&batch, "rename \"%s\" \"%s\"\n", partial, target)
+
+	args := []string{
+		"-b", "-",
+		"-P", strconv.Itoa(s.port),
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+	}
+	if s.keyFile != "" {
+		args = append(args, "-i", s.keyFile)
+	}
+	if s.knownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+s.knownHostsFile)
+	}
+	args = append(args, s.user+"@"+s.host)
+
+	cmd := exec.CommandContext(ctx, s.command, args...)
+	cmd.Stdin = strings.NewReader(batch.String())
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to upload %s to %s: %w: %s", name, s.host, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// parents returns dir and each of its ancestors, outermost first
+func parents(dir string) []string {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	return append(parents(path.Dir(dir)), dir)
+}
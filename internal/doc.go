@@ -16,8 +16,9 @@
 //     maintains it through periodic updates.
 //
 //   - Time Series Operations:
-//     Supports various aggregations (MIN, MAX, AVG, SUM) over
-//     different time windows (1m, 5m, 1h, 1d).
+//     Supports various aggregations (MIN, MAX, AVG, SUM, and the derived
+//     LOAD_FACTOR and UTILIZATION) over different time windows (1m, 5m,
+//     1h, 1d).
 //
 //   - Performance:
 //     Uses TimescaleDB for efficient time series storage and
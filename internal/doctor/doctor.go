@@ -0,0 +1,175 @@
+// Package doctor runs installation self-tests and prints a pass/fail
+// report, so problems with a new site's configuration, database, upstream
+// API, certificates or clock show up before the service is started.
+//
+// Each check reports PASS, WARN, FAIL or SKIP with a one-line detail. A
+// report passes when no check fails; warnings point at problems that do
+// not stop the service yet, such as a certificate close to expiry.
+//
+// Example Usage:
+//
+//	ok := doctor.Run(ctx, os.Stdout, 10*time.Second, []doctor.Check{
+//	    {Name: "clock", Run: func(ctx context.Context) doctor.Result {
+//	        return doctor.CheckClockSkew("database", local, remote, rtt)
+//	    }},
+//	    {Name: "tls api.example.com", Run: func(ctx context.Context) doctor.Result {
+//	        return doctor.CheckCertificate(ctx, "api.example.com:443", nil)
+//	    }},
+//	})
+//	if !ok {
+//	    os.Exit(1)
+//	}
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Statuses of a check
+const (
+	StatusPass = "PASS"
+	StatusWarn = "WARN"
+	StatusFail = "FAIL"
+	StatusSkip = "SKIP"
+)
+
+const (
+	// CertificateWarning is how long before expiry a certificate is
+	// reported as a warning
+	CertificateWarning = 30 * 24 * time.Hour
+	// ClockWarning is the clock skew reported as a warning
+	ClockWarning = 5 * time.Second
+	// ClockFailure is the clock skew reported as a failure. Samples are
+	// stamped with the upstream API's times and compared with the local
+	// clock when collection ranges are chosen.
+	ClockFailure = time.Minute
+)
+
+// Result is the outcome of a check.
+type Result struct {
+	Status string
+	Detail string
+}
+
+// Pass returns a passing result with a formatted detail.
+func Pass(format string, args ...interface{}) Result {
+	return Result{Status: StatusPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Warn returns a warning with a formatted detail.
+func Warn(format string, args ...interface{}) Result {
+	return Result{Status: StatusWarn, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Fail returns a failure with a formatted detail.
+func Fail(format string, args ...interface{}) Result {
+	return Result{Status: StatusFail, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Skip returns a result for a check that does not apply.
+func Skip(format string, args ...interface{}) Result {
+	return Result{Status: StatusSkip, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Check is a named self-test.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) Result
+}
+
+// Run runs the checks in order, each bounded by timeout, and writes one
+// line per check followed by a summary to w. It reports whether no check
+// failed.
+func Run(ctx context.Context, w io.Writer, timeout time.Duration, checks []Check) bool {
+	width := 0
+	for _, check := range checks {
+		if len(check.Name) > width {
+			width = len(check.Name)
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		result := check.Run(checkCtx)
+		if result.Status == StatusPass && checkCtx.Err() != nil {
+			result = Fail("timed out after %s", timeout)
+		}
+		cancel()
+
+		counts[result.Status]++
+		fmt.Fprintf(w, "%s  %-*s  %s\n", result.Status, width, check.Name, result.Detail)
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		counts[StatusPass], counts[StatusWarn], counts[StatusFail], counts[StatusSkip])
+	return counts[StatusFail] == 0
+}
+
+// CheckCertificate connects to address, a host:port, with TLS and checks
+// that the server's certificate chain is valid for its host name against
+// roots, the system pool when nil. Certificates expiring within
+// CertificateWarning are reported as warnings.
+func CheckCertificate(ctx context.Context, address string, roots *x509.CertPool) Result {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return Fail("invalid address %q: %v", address, err)
+	}
+
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host, RootCAs: roots}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return Fail("%v", err)
+	}
+	defer conn.Close()
+
+	// The chain was verified during the handshake; the earliest expiry
+	// of its certificates is when it stops being valid
+	state := conn.(*tls.Conn).ConnectionState()
+	var expiry time.Time
+	var subject string
+	for _, cert := range state.PeerCertificates {
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+			subject = cert.Subject.CommonName
+		}
+	}
+
+	remaining := time.Until(expiry)
+	detail := fmt.Sprintf("valid until %s (%d days)", expiry.UTC().Format(time.DateOnly), int(remaining.Hours()/24))
+	if remaining < CertificateWarning {
+		return Warn("%s, certificate %q expires soon", detail, subject)
+	}
+	return Pass("%s", detail)
+}
+
+// CheckClockSkew compares the local clock with remote, read from source
+// in a request that took rtt and completed at local. The remote reading
+// is assumed to be taken halfway through the request, and skew within
+// half the round trip cannot be told apart from latency.
+func CheckClockSkew(source string, local, remote time.Time, rtt time.Duration) Result {
+	skew := local.Add(-rtt / 2).Sub(remote)
+	if skew < 0 {
+		skew = -skew
+	}
+	skew -= rtt / 2
+	if skew < 0 {
+		skew = 0
+	}
+
+	detail := fmt.Sprintf("within %s of %s", skew.Round(time.Millisecond), source)
+	switch {
+	case skew >= ClockFailure:
+		return Fail("%s, synchronize the clock with NTP", detail)
+	case skew >= ClockWarning:
+		return Warn("%s, synchronize the clock with NTP", detail)
+	default:
+		return Pass("%s", detail)
+	}
+}
@@ -0,0 +1,80 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	ok := Run(context.Background(), &out, 50*time.Millisecond, []Check{
+		{Name: "config", Run: func(context.Context) Result { return Pass("config.yaml is valid") }},
+		{Name: "upstream", Run: func(context.Context) Result { return Fail("got 401") }},
+		{Name: "tls", Run: func(context.Context) Result { return Skip("no https endpoints") }},
+		{Name: "slow", Run: func(ctx context.Context) Result {
+			<-ctx.Done()
+			return Pass("finished")
+		}},
+	})
+
+	assert.False(t, ok)
+	assert.Equal(t, strings.Join([]string{
+		"PASS  config    config.yaml is valid",
+		"FAIL  upstream  got 401",
+		"SKIP  tls       no https endpoints",
+		"FAIL  slow      timed out after 50ms",
+		"",
+		"1 passed, 0 warnings, 2 failed, 1 skipped",
+		"",
+	}, "\n"), out.String())
+
+	assert.True(t, Run(context.Background(), &out, time.Second, []Check{
+		{Name: "clock", Run: func(context.Context) Result { return Warn("within 6s of database") }},
+	}), "warnings do not fail the report")
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	local := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		remote time.Time
+		rtt    time.Duration
+		want   string
+	}{
+		{"in sync", local, 0, StatusPass},
+		{"latency is not skew", local.Add(-time.Second), 2 * time.Second, StatusPass},
+		{"local clock ahead", local.Add(-10 * time.Second), 0, StatusWarn},
+		{"local clock behind", local.Add(2 * time.Minute), 10 * time.Millisecond, StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CheckClockSkew("database", local, tt.remote, tt.rtt).Status)
+		})
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "https://")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	result := CheckCertificate(context.Background(), address, roots)
+	assert.Equal(t, StatusPass, result.Status, result.Detail)
+	assert.Contains(t, result.Detail, "valid until")
+
+	result = CheckCertificate(context.Background(), address, x509.NewCertPool())
+	assert.Equal(t, StatusFail, result.Status)
+	assert.Contains(t, result.Detail, "certificate")
+}
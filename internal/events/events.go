@@ -0,0 +1,245 @@
+// Package events connects the parts of the service through an in-process
+// event bus, so that producers such as ingestion do not need to know
+// who consumes what they publish.
+//
+// The bus carries two kinds of events:
+//   - data.arrived: a batch of points stored by any ingestion path,
+//     consumed by live subscriptions, cache invalidation and anomaly
+//     detection
+//   - notification: an alert or ingestion event for webhooks, published by
+//     the scheduler, budgets and the anomaly detector
+//
+// Each subscriber has its own queue and receives its events in publishing
+// order, one at a time. Publishing never blocks: a subscriber whose queue
+// is full misses the event, which is logged and counted, rather than
+// stalling ingestion. Subscribers that must not miss an event, such as
+// cache invalidation, subscribe with SubscribeSync instead and are called
+// before Publish returns. Adding a consumer of arrived data means
+// subscribing to the bus, without touching the fetcher or the repository.
+//
+// Example Usage:
+//
+//	bus := events.NewBus(logger)
+//	repo = stream.NewPublishingRepository(repo, bus)
+//	bus.Subscribe("live subscribers", func(_ context.Context, event events.Event) {
+//	    broker.Publish(event.Points)
+//	}, events.TopicDataArrived)
+//
+//	go bus.Run(ctx)
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+// Topics
+const (
+	// TopicDataArrived carries a batch of points once it has been stored
+	TopicDataArrived = "data.arrived"
+	// TopicNotification carries an event to be sent to webhooks
+	TopicNotification = "notification"
+)
+
+// DefaultQueueSize is the number of events queued per subscriber.
+const DefaultQueueSize = 256
+
+// Event is a message on the bus.
+type Event struct {
+	// Topic is the kind of event
+	Topic string
+	// Time is when the event was published
+	Time time.Time
+	// Points holds the batch of a TopicDataArrived event
+	Points []models.TimeSeriesData
+	// Notification holds the webhook event of a TopicNotification event
+	Notification webhook.Event
+}
+
+// Handler consumes the events of a subscriber. ctx is done once the bus
+// stops.
+type Handler func(ctx context.Context, event Event)
+
+// subscriber is a named handler with its queue of events, or without one
+// for a subscriber called as events are published
+type subscriber struct {
+	name    string
+	topics  map[string]bool
+	handler Handler
+	queue   chan Event
+}
+
+// Bus delivers published events to the subscribers of their topics.
+type Bus struct {
+	logger *logrus.Logger
+
+	mu          sync.RWMutex
+	subscribers []*subscriber
+
+	published *prometheus.CounterVec
+	dropped   *prometheus.CounterVec
+}
+
+// NewBus creates a bus without subscribers.
+func NewBus(logger *logrus.Logger) *Bus {
+	return &Bus{logger: logger}
+}
+
+// SetMetrics registers metrics of the events published and dropped with
+// reg. It must be called before events are published.
+func (b *Bus) SetMetrics(reg prometheus.Registerer) error {
+	published := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edgecom_events_published_total",
+		Help: "Events published on the internal event bus",
+	}, []string{"topic"})
+	dropped := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edgecom_events_dropped_total",
+		Help: "Events a subscriber missed because its queue was full",
+	}, []string{"subscriber"})
+	for _, c := range []prometheus.Collector{published, dropped} {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register event bus metric: %v", err)
+		}
+	}
+	b.published = published
+	b.dropped = dropped
+	return nil
+}
+
+// Subscribe registers handler for the events of the given topics, under a
+// name used in logs and metrics. Events published before Run are queued
+// for it. It must be called before Run.
+func (b *Bus) Subscribe(name string, handler Handler, topics ...string) {
+	b.subscribe(name, handler, make(chan Event, DefaultQueueSize), topics)
+}
+
+// SubscribeSync registers handler for the events of the given topics, to
+// be called by Publish and Notify before they return, so that it never
+// misses an event and has handled it once the publisher goes on. handler
+// must be quick, as it delays the publisher, and is called concurrently
+// by concurrent publishers. It must be called before Run.
+func (b *Bus) SubscribeSync(name string, handler Handler, topics ...string) {
+	b.subscribe(name, handler, nil, topics)
+}
+
+// subscribe registers a subscriber with queue, nil for one called by
+// publish
+func (b *Bus) subscribe(name string, handler Handler, queue chan Event, topics []string) {
+	s := &subscriber{
+		name:    name,
+		topics:  make(map[string]bool, len(topics)),
+		handler: handler,
+		queue:   queue,
+	}
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, s)
+}
+
+// Publish publishes a batch of stored points as a TopicDataArrived event.
+func (b *Bus) Publish(points []models.TimeSeriesData) {
+	if len(points) == 0 {
+		return
+	}
+	b.publish(Event{Topic: TopicDataArrived, Points: points})
+}
+
+// Notify publishes a webhook event as a TopicNotification event. It
+// returns without waiting for the webhooks, so ctx is not used.
+func (b *Bus) Notify(_ context.Context, event webhook.Event) {
+	b.publish(Event{Topic: TopicNotification, Notification: event})
+}
+
+// publish queues event for every subscriber of its topic without blocking,
+// after calling the handlers of synchronous subscribers
+func (b *Bus) publish(event Event) {
+	event.Time = time.Now()
+	if b.published != nil {
+		b.published.WithLabelValues(event.Topic).Inc()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subscribers {
+		if s.queue == nil && s.topics[event.Topic] {
+			b.handle(context.Background(), s, event)
+		}
+	}
+	for _, s := range b.subscribers {
+		if s.queue == nil || !s.topics[event.Topic] {
+			continue
+		}
+		select {
+		case s.queue <- event:
+		default:
+			b.logger.WithFields(logrus.Fields{
+				"subscriber": s.name,
+				"topic":      event.Topic,
+			}).Warn("Event queue full, dropping event")
+			if b.dropped != nil {
+				b.dropped.WithLabelValues(s.name).Inc()
+			}
+		}
+	}
+}
+
+// Run delivers events to every subscriber until ctx is done, and returns
+// once the handlers running then have returned. Events still queued are
+// not delivered.
+func (b *Bus) Run(ctx context.Context) error {
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, s := range subscribers {
+		if s.queue == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.deliver(ctx, s)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliver hands the queued events of s to its handler until ctx is done
+func (b *Bus) deliver(ctx context.Context, s *subscriber) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.queue:
+			b.handle(ctx, s, event)
+		}
+	}
+}
+
+// handle calls the handler of s, logging a panic instead of stopping the
+// subscriber's deliveries
+func (b *Bus) handle(ctx context.Context, s *subscriber, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.WithFields(logrus.Fields{
+				"subscriber": s.name,
+				"topic":      event.Topic,
+			}).Errorf("Event handler panicked: %v", r)
+		}
+	}()
+	s.handler(ctx, event)
+}
@@ -0,0 +1,102 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+// received records the events handled by a subscriber
+type received struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *received) handle(_ context.Context, event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *received) get() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+func newTestBus(t *testing.T) *Bus {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	bus := NewBus(logger)
+	require.NoError(t, bus.SetMetrics(prometheus.NewRegistry()))
+	return bus
+}
+
+func TestBus(t *testing.T) {
+	bus := newTestBus(t)
+	data, notifications, all := &received{}, &received{}, &received{}
+	bus.Subscribe("cache", data.handle, TopicDataArrived)
+	bus.Subscribe("webhooks", notifications.handle, TopicNotification)
+	bus.Subscribe("audit", all.handle, TopicDataArrived, TopicNotification)
+	bus.Subscribe("faulty", func(context.Context, Event) { panic("nil map") }, TopicDataArrived)
+
+	// Events published before Run are delivered once it starts
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	bus.Publish([]models.TimeSeriesData{{Time: now, Value: 1}})
+	bus.Publish(nil)
+	bus.Notify(context.Background(), webhook.Event{Type: webhook.EventIngestionCompleted})
+	bus.Publish([]models.TimeSeriesData{{Time: now.Add(time.Minute), Value: 2}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- bus.Run(ctx) }()
+	require.Eventually(t, func() bool { return len(all.get()) == 3 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	got := data.get()
+	require.Len(t, got, 2, "a panicking subscriber does not affect the others")
+	assert.Equal(t, 1.0, got[0].Points[0].Value)
+	assert.Equal(t, 2.0, got[1].Points[0].Value, "events arrive in order")
+	require.Len(t, notifications.get(), 1)
+	assert.Equal(t, webhook.EventIngestionCompleted, notifications.get()[0].Notification.Type)
+	assert.Equal(t, TopicNotification, all.get()[1].Topic)
+	assert.Equal(t, 2.0, testutil.ToFloat64(bus.published.WithLabelValues(TopicDataArrived)))
+}
+
+func TestBusDropsWhenFull(t *testing.T) {
+	bus := newTestBus(t)
+	slow := &received{}
+	bus.Subscribe("slow", slow.handle, TopicDataArrived)
+
+	for i := 0; i < DefaultQueueSize+2; i++ {
+		bus.Publish([]models.TimeSeriesData{{Value: float64(i)}})
+	}
+	assert.Equal(t, 2.0, testutil.ToFloat64(bus.dropped.WithLabelValues("slow")))
+}
+
+func TestBusSubscribeSync(t *testing.T) {
+	bus := newTestBus(t)
+	cache := &received{}
+	bus.SubscribeSync("cache", cache.handle, TopicDataArrived)
+	bus.SubscribeSync("faulty", func(context.Context, Event) { panic("nil map") }, TopicDataArrived)
+
+	// Handled before Publish returns, without Run and whatever the queue
+	for i := 0; i < DefaultQueueSize+2; i++ {
+		bus.Publish([]models.TimeSeriesData{{Value: float64(i)}})
+		require.Len(t, cache.get(), i+1)
+	}
+	bus.Notify(context.Background(), webhook.Event{Type: webhook.EventIngestionCompleted})
+	assert.Len(t, cache.get(), DefaultQueueSize+2, "only events of its topics are handled")
+	assert.Zero(t, testutil.ToFloat64(bus.dropped.WithLabelValues("cache")))
+}
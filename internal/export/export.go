@@ -0,0 +1,60 @@
+// Package export writes time series data to files for use outside the
+// service.
+//
+// Supported formats:
+//   - XLSX workbooks with a sheet per series, timestamps stored as Excel
+//     dates in a chosen time zone, and a summary row per sheet
+//   - CSV and NDJSON, optionally gzipped, and Parquet with optional GZIP
+//     page compression, written by a streaming Writer
+//   - TSZ, a compact cold-storage format of checksummed blocks compressed
+//     by a codec such as gorilla, read back by ReadTSZ
+//   - pivoted CSV, NDJSON and XLSX, with the points of several series or
+//     aggregations merged into one column each, keyed by timestamp
+//
+// Stream reads a range from the repository in batches, so CSV, NDJSON,
+// Parquet and TSZ exports of long ranges use bounded memory.
+//
+// Example Usage:
+//
+//	w, err := export.NewWriter(out, export.FormatParquet, true)
+//	if err != nil {
+//	    return err
+//	}
+//	err = export.Stream(ctx, repo, export.Range{Start: start, End: end}, w.Write)
+//	if err == nil {
+//	    err = w.Close()
+//	}
+//
+//	sheets := []export.Sheet{{
+//	    Name:        "default",
+//	    Aggregation: "AVG",
+//	    Points:      points,
+//	}}
+//	if err := export.WriteXLSX(w, sheets, time.UTC); err != nil {
+//	    return err
+//	}
+//
+//	columns := []export.Column{
+//	    {Name: "AVG", Aggregation: "AVG", Points: averages},
+//	    {Name: "MAX", Aggregation: "MAX", Points: peaks},
+//	}
+//	if err := export.WritePivot(w, export.FormatCSV, false, columns); err != nil {
+//	    return err
+//	}
+package export
+
+import (
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Sheet is the data of one series.
+type Sheet struct {
+	// Name is the series name, used as the sheet name
+	Name string
+	// Aggregation is how the points were aggregated (MIN, MAX, AVG, SUM,
+	// LOAD_FACTOR or UTILIZATION), which decides the summary; empty for
+	// raw samples, which are summed
+	Aggregation string
+	// Points are the data points in time order
+	Points []models.TimeSeriesData
+}
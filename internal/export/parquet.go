@@ -0,0 +1,392 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// parquetRowGroupSize is the number of rows buffered before a row group is
+// written, which bounds the memory used by a Parquet export
+const parquetRowGroupSize = 128 * 1024
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// Parquet enumerations, as defined by parquet.thrift
+const (
+	parquetTypeInt64  = 2
+	parquetTypeDouble = 5
+
+	parquetRequired = 0
+
+	parquetConvertedTimestampMicros = 10
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetCodecGzip         = 2
+
+	parquetPageData = 0
+)
+
+// parquetWriter writes points as a Parquet file with a required
+// time column (INT64, UTC timestamp in microseconds) and a required value
+// column (DOUBLE). Each row group holds one plain-encoded data page per
+// column, compressed with GZIP if requested.
+type parquetWriter struct {
+	w        *countingWriter
+	compress bool
+
+	times  []int64
+	values []float64
+
+	rowGroups []parquetRowGroup
+	rows      int64
+	started   bool
+}
+
+// parquetRowGroup records where a row group's column chunks were written
+type parquetRowGroup struct {
+	rows    int64
+	columns [2]parquetColumnChunk
+}
+
+type parquetColumnChunk struct {
+	offset             int64
+	uncompressedLength int64
+	compressedLength   int64
+}
+
+func newParquetWriter(w io.Writer, compress bool) *parquetWriter {
+	return &parquetWriter{w: &countingWriter{w: w}, compress: compress}
+}
+
+func (p *parquetWriter) Write(points []models.TimeSeriesData) error {
+	for _, point := range points {
+		p.times = append(p.times, point.Time.UnixMicro())
+		p.values = append(p.values, point.Value)
+		if len(p.times) == parquetRowGroupSize {
+			if err := p.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes the last row group and the footer. A file without rows
+// still has the schema, so it can be read as an empty table.
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	footer := p.fileMetaData()
+	if _, err := p.w.Write(footer); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	if _, err := p.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, parquetMagic)
+	return err
+}
+
+// start writes the leading magic number once
+func (p *parquetWriter) start() error {
+	if p.started {
+		return nil
+	}
+	p.started = true
+	_, err := io.WriteString(p.w, parquetMagic)
+	return err
+}
+
+// flush writes the buffered rows as a row group
+func (p *parquetWriter) flush() error {
+	if len(p.times) == 0 {
+		return nil
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	timeData := make([]byte, 8*len(p.times))
+	for i, t := range p.times {
+		binary.LittleEndian.PutUint64(timeData[8*i:], uint64(t))
+	}
+	valueData := make([]byte, 8*len(p.values))
+	for i, v := range p.values {
+		binary.LittleEndian.PutUint64(valueData[8*i:], math.Float64bits(v))
+	}
+
+	group := parquetRowGroup{rows: int64(len(p.times))}
+	for i, data := range [][]byte{timeData, valueData} {
+		chunk, err := p.writePage(data, len(p.times))
+		if err != nil {
+			return err
+		}
+		group.columns[i] = chunk
+	}
+
+	p.rowGroups = append(p.rowGroups, group)
+	p.rows += group.rows
+	p.times = p.times[:0]
+	p.values = p.values[:0]
+	return nil
+}
+
+// writePage writes a column chunk holding a single data page
+func (p *parquetWriter) writePage(data []byte, values int) (parquetColumnChunk, error) {
+	body := data
+	if p.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return parquetColumnChunk{}, err
+		}
+		if err := zw.Close(); err != nil {
+			return parquetColumnChunk{}, err
+		}
+		body = buf.Bytes()
+	}
+
+	var header thriftWriter
+	header.i32(1, parquetPageData)
+	header.i32(2, int32(len(data)))
+	header.i32(3, int32(len(body)))
+	header.beginStruct(5)
+	header.i32(1, int32(values))
+	header.i32(2, parquetEncodingPlain)
+	header.i32(3, parquetEncodingRLE)
+	header.i32(4, parquetEncodingRLE)
+	header.endStruct()
+	header.stop()
+
+	chunk := parquetColumnChunk{
+		offset:             p.w.n,
+		uncompressedLength: int64(header.buf.Len() + len(data)),
+		compressedLength:   int64(header.buf.Len() + len(body)),
+	}
+	if _, err := p.w.Write(header.buf.Bytes()); err != nil {
+		return chunk, err
+	}
+	_, err := p.w.Write(body)
+	return chunk, err
+}
+
+// fileMetaData encodes the footer describing the schema and row groups
+func (p *parquetWriter) fileMetaData() []byte {
+	codec := int32(parquetCodecUncompressed)
+	if p.compress {
+		codec = parquetCodecGzip
+	}
+
+	var t thriftWriter
+	t.i32(1, 1)
+
+	t.beginList(2, thriftStruct, 3)
+	t.beginElement()
+	t.binary(4, "schema")
+	t.i32(5, 2)
+	t.endElement()
+
+	t.beginElement()
+	t.i32(1, parquetTypeInt64)
+	t.i32(3, parquetRequired)
+	t.binary(4, "time")
+	t.i32(6, parquetConvertedTimestampMicros)
+	t.beginStruct(10) // LogicalType
+	t.beginStruct(8)  // TIMESTAMP
+	t.boolean(1, true)
+	t.beginStruct(2) // TimeUnit
+	t.beginStruct(2) // MICROS
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+	t.endElement()
+
+	t.beginElement()
+	t.i32(1, parquetTypeDouble)
+	t.i32(3, parquetRequired)
+	t.binary(4, "value")
+	t.endElement()
+
+	t.i64(3, p.rows)
+
+	t.beginList(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		t.beginElement()
+		t.beginList(1, thriftStruct, len(group.columns))
+		var totalSize int64
+		for i, column := range group.columns {
+			name, typ := "time", int32(parquetTypeInt64)
+			if i == 1 {
+				name, typ = "value", parquetTypeDouble
+			}
+			totalSize += column.uncompressedLength
+
+			t.beginElement()
+			t.i64(2, column.offset)
+			t.beginStruct(3)
+			t.i32(1, typ)
+			t.beginList(2, thriftI32, 2)
+			t.listI32(parquetEncodingPlain)
+			t.listI32(parquetEncodingRLE)
+			t.beginList(3, thriftBinary, 1)
+			t.listBinary(name)
+			t.i32(4, codec)
+			t.i64(5, group.rows)
+			t.i64(6, column.uncompressedLength)
+			t.i64(7, column.compressedLength)
+			t.i64(9, column.offset)
+			t.endStruct()
+			t.endElement()
+		}
+		t.i64(2, totalSize)
+		t.i64(3, group.rows)
+		t.endElement()
+	}
+
+	t.binary(6, "edgecom")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// countingWriter tracks the offset of the next byte written
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Thrift compact protocol field types
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftStruct    = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, which
+// Parquet uses for its page headers and footer. Only the types Parquet
+// metadata needs are supported.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// last is the id of the previous field in each open struct
+	last []int16
+	// current is the id of the previous field in the innermost struct
+	current int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.current; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(uint64(zigzag(int64(id))))
+	}
+	t.current = id
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	t.buf.Write(tmp[:n])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		t.fieldHeader(id, thriftBoolTrue)
+	} else {
+		t.fieldHeader(id, thriftBoolFalse)
+	}
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.listBinary(v)
+}
+
+// beginStruct starts a struct field, closed by endStruct
+func (t *thriftWriter) beginStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.endElement()
+}
+
+// beginList starts a list field of size elements, which are written with
+// the list* methods or, for structs, between beginElement and endElement
+func (t *thriftWriter) beginList(id int16, elem byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		t.varint(uint64(size))
+	}
+}
+
+// beginElement starts a struct without a field header
+func (t *thriftWriter) beginElement() {
+	t.last = append(t.last, t.current)
+	t.current = 0
+}
+
+// endElement writes the stop byte of the innermost struct
+func (t *thriftWriter) endElement() {
+	t.stop()
+	t.current = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) listBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+// stop ends the top-level struct
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
@@ -0,0 +1,170 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// MaxPivotColumns is the most columns a pivoted export may have
+const MaxPivotColumns = 20
+
+// Column is the data of one series and aggregation in a pivoted export.
+type Column struct {
+	// Name is the column header
+	Name string
+	// Aggregation is how the points were aggregated, which decides the
+	// column's summary in XLSX; empty for raw samples
+	Aggregation string
+	// Points are the data points in time order
+	Points []models.TimeSeriesData
+}
+
+// Row is the values of every column at one timestamp. Columns without a
+// point at the timestamp hold NaN.
+type Row struct {
+	Time   time.Time
+	Values []float64
+}
+
+// Pivot merges the points of columns into rows keyed by timestamp, in
+// time order.
+func Pivot(columns []Column) []Row {
+	index := make(map[time.Time]int)
+	var rows []Row
+	for c, column := range columns {
+		for _, point := range column.Points {
+			key := point.Time.UTC()
+			i, ok := index[key]
+			if !ok {
+				i = len(rows)
+				index[key] = i
+				values := make([]float64, len(columns))
+				for v := range values {
+					values[v] = math.NaN()
+				}
+				rows = append(rows, Row{Time: key, Values: values})
+			}
+			rows[i].Values[c] = point.Value
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Time.Before(rows[j].Time) })
+	return rows
+}
+
+// WritePivot writes columns pivoted by timestamp as FormatCSV, with a time
+// column followed by one column per Column, or as FormatNDJSON, with one
+// object per timestamp keyed by column name. Missing values are left empty
+// or null. With compress, the output is gzipped as a whole.
+func WritePivot(w io.Writer, format string, compress bool, columns []Column) error {
+	if err := validateColumns(columns); err != nil {
+		return err
+	}
+	if format != FormatCSV && format != FormatNDJSON {
+		return fmt.Errorf("unsupported pivoted export format %q, expected %q or %q", format, FormatCSV, FormatNDJSON)
+	}
+
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	buffered := bufio.NewWriter(w)
+
+	var err error
+	if format == FormatCSV {
+		err = writePivotCSV(buffered, columns)
+	} else {
+		err = writePivotNDJSON(buffered, columns)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+// writePivotCSV writes the header and a record per row
+func writePivotCSV(w io.Writer, columns []Column) error {
+	out := csv.NewWriter(w)
+	record := make([]string, len(columns)+1)
+	record[0] = "time"
+	for i, column := range columns {
+		record[i+1] = column.Name
+	}
+	if err := out.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range Pivot(columns) {
+		record[0] = row.Time.Format(time.RFC3339Nano)
+		for i, value := range row.Values {
+			record[i+1] = ""
+			if !math.IsNaN(value) {
+				record[i+1] = strconv.FormatFloat(value, 'g', -1, 64)
+			}
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// writePivotNDJSON writes an object per row
+func writePivotNDJSON(w io.Writer, columns []Column) error {
+	encoder := json.NewEncoder(w)
+	for _, row := range Pivot(columns) {
+		line := make(map[string]interface{}, len(columns)+1)
+		for i, value := range row.Values {
+			line[columns[i].Name] = nil
+			if !math.IsNaN(value) {
+				line[columns[i].Name] = value
+			}
+		}
+		line["time"] = row.Time
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateColumns checks that columns can be written side by side
+func validateColumns(columns []Column) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns to export")
+	}
+	if len(columns) > MaxPivotColumns {
+		return fmt.Errorf("%d columns exceed the limit of %d", len(columns), MaxPivotColumns)
+	}
+	names := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if column.Name == "" || column.Name == "time" {
+			return fmt.Errorf("invalid column name %q", column.Name)
+		}
+		if names[column.Name] {
+			return fmt.Errorf("duplicate column %q", column.Name)
+		}
+		names[column.Name] = true
+	}
+	return nil
+}
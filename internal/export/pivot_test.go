@@ -0,0 +1,122 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// pivotColumns returns two hourly columns that overlap in one bucket
+func pivotColumns(base time.Time) []Column {
+	return []Column{
+		{
+			Name:        "default AVG",
+			Aggregation: "AVG",
+			Points: []models.TimeSeriesData{
+				{Time: base, Value: 10},
+				{Time: base.Add(time.Hour), Value: 20},
+			},
+		},
+		{
+			Name:        "default MAX",
+			Aggregation: "MAX",
+			Points: []models.TimeSeriesData{
+				{Time: base.Add(time.Hour), Value: 25},
+				{Time: base.Add(2 * time.Hour), Value: math.NaN()},
+			},
+		},
+	}
+}
+
+func TestPivot(t *testing.T) {
+	base := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	rows := Pivot(pivotColumns(base))
+
+	require.Len(t, rows, 3)
+	assert.Equal(t, base, rows[0].Time)
+	assert.Equal(t, 10.0, rows[0].Values[0])
+	assert.True(t, math.IsNaN(rows[0].Values[1]))
+	assert.Equal(t, []float64{20, 25}, rows[1].Values)
+	assert.Equal(t, base.Add(2*time.Hour), rows[2].Time)
+	assert.True(t, math.IsNaN(rows[2].Values[0]))
+}
+
+func TestWritePivot(t *testing.T) {
+	base := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WritePivot(&buf, FormatCSV, false, pivotColumns(base)))
+		assert.Equal(t, "time,default AVG,default MAX\n"+
+			"2024-11-23T12:00:00Z,10,\n"+
+			"2024-11-23T13:00:00Z,20,25\n"+
+			"2024-11-23T14:00:00Z,,\n", buf.String())
+	})
+
+	t.Run("gzipped ndjson", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WritePivot(&buf, FormatNDJSON, true, pivotColumns(base)))
+
+		zr, err := gzip.NewReader(&buf)
+		require.NoError(t, err)
+		content, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, `{"default AVG":10,"default MAX":null,"time":"2024-11-23T12:00:00Z"}`+"\n"+
+			`{"default AVG":20,"default MAX":25,"time":"2024-11-23T13:00:00Z"}`+"\n"+
+			`{"default AVG":null,"default MAX":null,"time":"2024-11-23T14:00:00Z"}`+"\n", string(content))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.ErrorContains(t, WritePivot(io.Discard, FormatParquet, false, pivotColumns(base)), "unsupported")
+		assert.ErrorContains(t, WritePivot(io.Discard, FormatCSV, false, nil), "no columns")
+		assert.ErrorContains(t, WritePivot(io.Discard, FormatCSV, false, []Column{{Name: "a"}, {Name: "a"}}), "duplicate column")
+		assert.ErrorContains(t, WritePivot(io.Discard, FormatCSV, false, []Column{{Name: "time"}}), "invalid column name")
+		assert.ErrorContains(t, WritePivot(io.Discard, FormatCSV, false, make([]Column, MaxPivotColumns+1)), "exceed the limit")
+	})
+}
+
+func TestWritePivotXLSX(t *testing.T) {
+	base := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePivotXLSX(&buf, "comparison", pivotColumns(base), time.UTC))
+	parts := readWorkbook(t, buf.Bytes())
+	assert.Contains(t, string(parts["xl/workbook.xml"]), `name="comparison"`)
+
+	var sheet worksheet
+	require.NoError(t, xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet))
+	require.Len(t, sheet.Rows, 5)
+
+	header := sheet.Rows[0].Cells
+	require.Len(t, header, 3)
+	assert.Equal(t, "default AVG", header[1].Text)
+	assert.Equal(t, "default MAX", header[2].Text)
+
+	assert.Equal(t, "C3", sheet.Rows[2].Cells[2].Ref)
+	assert.Equal(t, "25", sheet.Rows[2].Cells[2].Value)
+	assert.Empty(t, sheet.Rows[1].Cells[2].Value, "missing values are left empty")
+
+	summary := sheet.Rows[4].Cells
+	assert.Equal(t, "Summary", summary[0].Text)
+	assert.Equal(t, "AVERAGE(B2:B4)", summary[1].Formula)
+	assert.Equal(t, "15", summary[1].Value)
+	assert.Equal(t, "MAX(C2:C4)", summary[2].Formula)
+	assert.Equal(t, "25", summary[2].Value)
+
+	assert.ErrorContains(t, WritePivotXLSX(io.Discard, "x", []Column{{Name: "a", Aggregation: "MEDIAN"}}, time.UTC), "unknown aggregation")
+}
+
+func TestColumnRef(t *testing.T) {
+	for index, want := range map[int]string{0: "A", 1: "B", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, want, columnRef(index), index)
+	}
+}
@@ -0,0 +1,108 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+)
+
+// Batch sizes of a streamed export
+const (
+	// rawPageSize is the number of stored samples read per query
+	rawPageSize = 10000
+	// aggregateChunkBuckets is the number of buckets aggregated per query
+	aggregateChunkBuckets = 10000
+)
+
+// Source is the subset of the repository an export reads from.
+type Source interface {
+	Query(ctx context.Context, start, end time.Time, window, aggregation string) ([]models.TimeSeriesData, error)
+	QueryRaw(ctx context.Context, start, end time.Time, skip, limit int) ([]models.TimeSeriesData, error)
+}
+
+// Range selects the data of an export: the stored samples in [Start, End]
+// when Window is empty, or the Aggregation of each Window bucket.
+type Range struct {
+	Start, End  time.Time
+	Window      string
+	Aggregation string
+}
+
+// Stream reads the range from src in batches, passing each to emit in
+// time order, so the range never has to fit in memory. Raw samples are
+// paged through by timestamp; aggregates are computed over consecutive
+// spans of whole buckets.
+func Stream(ctx context.Context, src Source, r Range, emit func([]models.TimeSeriesData) error) error {
+	if r.Window == "" {
+		return streamRaw(ctx, src, r, emit)
+	}
+	return streamAggregated(ctx, src, r, emit)
+}
+
+// streamRaw pages through stored samples. Each page resumes at the last
+// timestamp read, skipping the samples at that timestamp already emitted.
+func streamRaw(ctx context.Context, src Source, r Range, emit func([]models.TimeSeriesData) error) error {
+	cursor, skip := r.Start, 0
+	for {
+		page, err := src.QueryRaw(ctx, cursor, r.End, skip, rawPageSize)
+		if err != nil {
+			return err
+		}
+		if len(page) > 0 {
+			if err := emit(page); err != nil {
+				return err
+			}
+		}
+		if len(page) < rawPageSize {
+			return nil
+		}
+
+		last := page[len(page)-1].Time
+		seen := 0
+		for i := len(page) - 1; i >= 0 && page[i].Time.Equal(last); i-- {
+			seen++
+		}
+		if last.Equal(cursor) {
+			skip += seen
+		} else {
+			cursor, skip = last, seen
+		}
+	}
+}
+
+// streamAggregated queries spans of aggregateChunkBuckets buckets. Spans
+// end on bucket boundaries, just before the first sample of the next
+// span, so no bucket is split between two queries.
+func streamAggregated(ctx context.Context, src Source, r Range, emit func([]models.TimeSeriesData) error) error {
+	width, err := stream.WindowDuration(r.Window)
+	if err != nil {
+		return err
+	}
+	span := width * aggregateChunkBuckets
+
+	for start := r.Start; !start.After(r.End); {
+		next := start.Truncate(width).Add(span)
+		end := next.Add(-time.Microsecond)
+		if !next.Before(r.End) {
+			end = r.End
+		}
+
+		buckets, err := src.Query(ctx, start, end, r.Window, r.Aggregation)
+		if err != nil {
+			return fmt.Errorf("failed to aggregate %s to %s: %w", start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+		}
+		if len(buckets) > 0 {
+			if err := emit(buckets); err != nil {
+				return err
+			}
+		}
+		if end.Equal(r.End) {
+			return nil
+		}
+		start = next
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// fakeSource serves points ordered by time, with the inclusive ranges and
+// epoch-aligned buckets of the repository
+type fakeSource struct {
+	points  []models.TimeSeriesData
+	queries int
+}
+
+func (f *fakeSource) inRange(start, end time.Time) []models.TimeSeriesData {
+	var points []models.TimeSeriesData
+	for _, p := range f.points {
+		if !p.Time.Before(start) && !p.Time.After(end) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func (f *fakeSource) QueryRaw(_ context.Context, start, end time.Time, skip, limit int) ([]models.TimeSeriesData, error) {
+	f.queries++
+	points := f.inRange(start, end)
+	if skip > len(points) {
+		return nil, nil
+	}
+	points = points[skip:]
+	if len(points) > limit {
+		points = points[:limit]
+	}
+	return points, nil
+}
+
+func (f *fakeSource) Query(_ context.Context, start, end time.Time, window, aggregation string) ([]models.TimeSeriesData, error) {
+	f.queries++
+	width, err := time.ParseDuration(window)
+	if err != nil || aggregation != "SUM" {
+		return nil, errors.New("unexpected query")
+	}
+	var buckets []models.TimeSeriesData
+	for _, p := range f.inRange(start, end) {
+		bucket := p.Time.Truncate(width)
+		if n := len(buckets); n > 0 && buckets[n-1].Time.Equal(bucket) {
+			buckets[n-1].Value += p.Value
+			continue
+		}
+		buckets = append(buckets, models.TimeSeriesData{Time: bucket, Value: p.Value})
+	}
+	return buckets, nil
+}
+
+// collect streams r from src and returns every point emitted
+func collect(t *testing.T, src Source, r Range) []models.TimeSeriesData {
+	var all []models.TimeSeriesData
+	err := Stream(context.Background(), src, r, func(batch []models.TimeSeriesData) error {
+		all = append(all, batch...)
+		return nil
+	})
+	require.NoError(t, err)
+	return all
+}
+
+func TestStreamRaw(t *testing.T) {
+	base := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+
+	// A run of duplicate timestamps longer than a page spans the first
+	// page boundary
+	var points []models.TimeSeriesData
+	for i := 0; i < rawPageSize+5; i++ {
+		points = append(points, models.TimeSeriesData{Time: base, Value: float64(i)})
+	}
+	for i := 1; i <= rawPageSize; i++ {
+		points = append(points, models.TimeSeriesData{Time: base.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+	src := &fakeSource{points: points}
+
+	got := collect(t, src, Range{Start: base, End: base.Add(time.Hour * 24)})
+	assert.Equal(t, points, got)
+	assert.Equal(t, 3, src.queries)
+}
+
+func TestStreamAggregated(t *testing.T) {
+	// Points every 30 seconds over 15 days, starting mid-bucket
+	start := time.Date(2024, 11, 1, 0, 0, 30, 0, time.UTC)
+	end := start.Add(15 * 24 * time.Hour)
+	var points []models.TimeSeriesData
+	for ts := start; !ts.After(end); ts = ts.Add(30 * time.Second) {
+		points = append(points, models.TimeSeriesData{Time: ts, Value: 1})
+	}
+	src := &fakeSource{points: points}
+
+	got := collect(t, src, Range{Start: start, End: end, Window: "1m", Aggregation: "SUM"})
+
+	// 10000 buckets per query
+	assert.Equal(t, 3, src.queries)
+	require.Len(t, got, 15*24*60+1)
+	var total float64
+	for i, bucket := range got {
+		total += bucket.Value
+		if i > 0 {
+			assert.Equal(t, time.Minute, bucket.Time.Sub(got[i-1].Time), "bucket %d is split or missing", i)
+		}
+	}
+	assert.Equal(t, float64(len(points)), total)
+
+	t.Run("emit errors stop the export", func(t *testing.T) {
+		err := Stream(context.Background(), src, Range{Start: start, End: end, Window: "1m", Aggregation: "SUM"},
+			func([]models.TimeSeriesData) error { return errors.New("client went away") })
+		assert.EqualError(t, err, "client went away")
+	})
+}
@@ -0,0 +1,154 @@
+package export
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/tejusbharadwaj/edgecom/internal/codec"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// tszMagic starts every tsz file, followed by tszVersion
+const (
+	tszMagic   = "ETSZ"
+	tszVersion = 1
+)
+
+// tszBlockSize is the number of points encoded per block
+const tszBlockSize = 4096
+
+// maxTSZBlockBytes bounds the blocks ReadTSZ accepts, well above what a
+// block of tszBlockSize points takes with any built-in codec
+const maxTSZBlockBytes = 1 << 20
+
+// tszWriter writes the compact cold-storage format: a header naming the
+// codec, then blocks of at most tszBlockSize points, each the length of
+// its encoding, the encoding and its CRC-32, and a zero length ending
+// the file, so truncated files are detected.
+type tszWriter struct {
+	w       io.Writer
+	codec   codec.Codec
+	pending []models.TimeSeriesData
+	block   []byte
+	started bool
+}
+
+// NewTSZWriter creates a writer of FormatTSZ compressing points with c.
+func NewTSZWriter(w io.Writer, c codec.Codec) Writer {
+	return &tszWriter{w: w, codec: c}
+}
+
+func (t *tszWriter) Write(points []models.TimeSeriesData) error {
+	for len(points) > 0 {
+		n := min(tszBlockSize-len(t.pending), len(points))
+		t.pending = append(t.pending, points[:n]...)
+		points = points[n:]
+		if len(t.pending) == tszBlockSize {
+			if err := t.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *tszWriter) Close() error {
+	if err := t.flush(); err != nil {
+		return err
+	}
+	if err := t.start(); err != nil {
+		return err
+	}
+	_, err := t.w.Write(binary.AppendUvarint(nil, 0))
+	return err
+}
+
+// start writes the header once
+func (t *tszWriter) start() error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+	name := t.codec.Name()
+	header := append([]byte(tszMagic), tszVersion, byte(len(name)))
+	_, err := t.w.Write(append(header, name...))
+	return err
+}
+
+// flush writes the pending points as a block
+func (t *tszWriter) flush() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	if err := t.start(); err != nil {
+		return err
+	}
+	encoded := t.codec.Encode(nil, t.pending)
+	t.block = binary.AppendUvarint(t.block[:0], uint64(len(encoded)))
+	t.block = append(t.block, encoded...)
+	t.block = binary.BigEndian.AppendUint32(t.block, crc32.ChecksumIEEE(encoded))
+	t.pending = t.pending[:0]
+	_, err := t.w.Write(t.block)
+	return err
+}
+
+// ReadTSZ reads a file written in FormatTSZ, calling emit with the points
+// of each block in the order they were written. It fails on files that
+// are corrupt, truncated or use a codec that is not registered.
+func ReadTSZ(r io.Reader, emit func([]models.TimeSeriesData) error) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(tszMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("invalid tsz header: %w", err)
+	}
+	if string(header[:len(tszMagic)]) != tszMagic {
+		return fmt.Errorf("not a tsz file")
+	}
+	if header[len(tszMagic)] != tszVersion {
+		return fmt.Errorf("unsupported tsz version %d", header[len(tszMagic)])
+	}
+	name := make([]byte, header[len(tszMagic)+1])
+	if _, err := io.ReadFull(br, name); err != nil {
+		return fmt.Errorf("invalid tsz header: %w", err)
+	}
+	c, err := codec.Lookup(string(name))
+	if err != nil {
+		return err
+	}
+
+	for block := 0; ; block++ {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("tsz file truncated after %d blocks", block)
+			}
+			return fmt.Errorf("block %d: %w", block, err)
+		}
+		if size == 0 {
+			return nil
+		}
+		if size > maxTSZBlockBytes {
+			return fmt.Errorf("block %d: %w: %d bytes", block, codec.ErrCorrupt, size)
+		}
+		encoded := make([]byte, size+4)
+		if _, err := io.ReadFull(br, encoded); err != nil {
+			return fmt.Errorf("tsz file truncated in block %d", block)
+		}
+		sum := binary.BigEndian.Uint32(encoded[size:])
+		encoded = encoded[:size]
+		if crc32.ChecksumIEEE(encoded) != sum {
+			return fmt.Errorf("block %d: %w: checksum mismatch", block, codec.ErrCorrupt)
+		}
+		points, err := c.Decode(encoded)
+		if err != nil {
+			return fmt.Errorf("block %d: %w", block, err)
+		}
+		if err := emit(points); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/codec"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func readTSZ(t *testing.T, file []byte) []models.TimeSeriesData {
+	var points []models.TimeSeriesData
+	require.NoError(t, ReadTSZ(bytes.NewReader(file), func(block []models.TimeSeriesData) error {
+		points = append(points, block...)
+		return nil
+	}))
+	return points
+}
+
+func TestTSZ(t *testing.T) {
+	long := make([]models.TimeSeriesData, 2*tszBlockSize+10)
+	for i := range long {
+		long[i] = models.TimeSeriesData{
+			Time:  time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute),
+			Value: float64(i % 60),
+		}
+	}
+
+	t.Run("round trip across blocks", func(t *testing.T) {
+		for _, c := range []codec.Codec{codec.None, codec.Delta, codec.Gorilla} {
+			var buf bytes.Buffer
+			w := NewTSZWriter(&buf, c)
+			require.NoError(t, w.Write(long[:100]))
+			require.NoError(t, w.Write(long[100:]))
+			require.NoError(t, w.Close())
+
+			assert.Equal(t, long, readTSZ(t, buf.Bytes()), c.Name())
+		}
+	})
+
+	t.Run("compress selects the default codec", func(t *testing.T) {
+		compressed := writeAll(t, FormatTSZ, true, long)
+		raw := writeAll(t, FormatTSZ, false, long)
+		assert.Less(t, len(compressed), len(raw)/4)
+		assert.Equal(t, long, readTSZ(t, compressed))
+
+		points := readTSZ(t, writeAll(t, FormatTSZ, true, testPoints))
+		require.Len(t, points, len(testPoints))
+		assert.True(t, math.IsNaN(points[1].Value))
+		assert.Equal(t, testPoints[1].Time, points[1].Time)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, readTSZ(t, writeAll(t, FormatTSZ, true)))
+	})
+
+	t.Run("corrupt files", func(t *testing.T) {
+		file := writeAll(t, FormatTSZ, true, long)
+		noop := func([]models.TimeSeriesData) error { return nil }
+
+		assert.ErrorContains(t, ReadTSZ(bytes.NewReader(file[:len(file)-1]), noop), "truncated")
+		assert.ErrorContains(t, ReadTSZ(bytes.NewReader(file[:len(file)/2]), noop), "truncated")
+		assert.ErrorContains(t, ReadTSZ(bytes.NewReader([]byte("time,value\n")), noop), "not a tsz file")
+
+		flipped := bytes.Clone(file)
+		flipped[len(flipped)/2] ^= 0x10
+		assert.ErrorIs(t, ReadTSZ(bytes.NewReader(flipped), noop), codec.ErrCorrupt)
+
+		unknown := bytes.Clone(file)
+		copy(unknown[6:], "zzzzzzz")
+		assert.ErrorContains(t, ReadTSZ(bytes.NewReader(unknown), noop), `unknown codec "zzzzzzz"`)
+	})
+
+	t.Run("emit errors stop reading", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		err := ReadTSZ(bytes.NewReader(writeAll(t, FormatTSZ, true, long)), func([]models.TimeSeriesData) error {
+			calls++
+			return stop
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -0,0 +1,168 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/codec"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Export formats
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+	FormatTSZ     = "tsz"
+	FormatXLSX    = "xlsx"
+)
+
+// Media types of the streamed formats
+const (
+	ContentTypeCSV     = "text/csv"
+	ContentTypeNDJSON  = "application/x-ndjson"
+	ContentTypeParquet = "application/vnd.apache.parquet"
+	ContentTypeGzip    = "application/gzip"
+	ContentTypeTSZ     = "application/vnd.edgecom.tsz"
+)
+
+// Writer streams points to a file of one format. Points are written in
+// batches as they are read, so an export never holds the whole range in
+// memory. Close must be called to complete the file; it does not close
+// the underlying writer.
+type Writer interface {
+	Write(points []models.TimeSeriesData) error
+	Close() error
+}
+
+// NewWriter creates a streaming writer for FormatCSV, FormatNDJSON,
+// FormatParquet or FormatTSZ. With compress, CSV and NDJSON output is
+// gzipped as a whole, while Parquet pages are GZIP-compressed inside the
+// file, which keeps it readable by Parquet tools, and TSZ blocks are
+// encoded with the default codec instead of stored uncompressed; see
+// NewTSZWriter to choose another.
+func NewWriter(w io.Writer, format string, compress bool) (Writer, error) {
+	switch format {
+	case FormatCSV, FormatNDJSON:
+		var zw *gzip.Writer
+		if compress {
+			zw = gzip.NewWriter(w)
+			w = zw
+		}
+		buffered := bufio.NewWriter(w)
+		text := &textWriter{buf: buffered, gzip: zw}
+		if format == FormatCSV {
+			text.csv = csv.NewWriter(buffered)
+			if err := text.csv.Write([]string{"time", "value"}); err != nil {
+				return nil, err
+			}
+		}
+		return text, nil
+	case FormatParquet:
+		return newParquetWriter(w, compress), nil
+	case FormatTSZ:
+		c := codec.None
+		if compress {
+			var err error
+			if c, err = codec.Lookup(codec.DefaultName); err != nil {
+				return nil, err
+			}
+		}
+		return NewTSZWriter(w, c), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q, expected %q, %q, %q or %q", format, FormatCSV, FormatNDJSON, FormatParquet, FormatTSZ)
+	}
+}
+
+// ContentType returns the media type of a streamed export.
+func ContentType(format string, compress bool) string {
+	switch {
+	case format == FormatParquet:
+		return ContentTypeParquet
+	case format == FormatTSZ:
+		return ContentTypeTSZ
+	case compress:
+		return ContentTypeGzip
+	case format == FormatNDJSON:
+		return ContentTypeNDJSON
+	default:
+		return ContentTypeCSV
+	}
+}
+
+// FileExtension returns the file name extension of an export, without the
+// leading dot.
+func FileExtension(format string, compress bool) string {
+	if compress && format != FormatParquet && format != FormatTSZ {
+		return format + ".gz"
+	}
+	return format
+}
+
+// textWriter writes CSV with a time,value header row, or NDJSON with one
+// {"time": ..., "value": ...} object per line. Timestamps are RFC 3339 in
+// UTC and NaN values are left empty or null.
+type textWriter struct {
+	buf  *bufio.Writer
+	csv  *csv.Writer
+	gzip *gzip.Writer
+}
+
+// ndjsonPoint is a line of NDJSON output
+type ndjsonPoint struct {
+	Time  time.Time `json:"time"`
+	Value *float64  `json:"value"`
+}
+
+func (t *textWriter) Write(points []models.TimeSeriesData) error {
+	if t.csv != nil {
+		record := make([]string, 2)
+		for _, point := range points {
+			record[0] = point.Time.UTC().Format(time.RFC3339Nano)
+			record[1] = ""
+			if !math.IsNaN(point.Value) {
+				record[1] = strconv.FormatFloat(point.Value, 'g', -1, 64)
+			}
+			if err := t.csv.Write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	encoder := json.NewEncoder(t.buf)
+	for _, point := range points {
+		line := ndjsonPoint{Time: point.Time.UTC()}
+		if !math.IsNaN(point.Value) {
+			value := point.Value
+			line.Value = &value
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *textWriter) Close() error {
+	if t.csv != nil {
+		t.csv.Flush()
+		if err := t.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := t.buf.Flush(); err != nil {
+		return err
+	}
+	if t.gzip != nil {
+		return t.gzip.Close()
+	}
+	return nil
+}
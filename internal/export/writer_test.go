@@ -0,0 +1,234 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+var testPoints = []models.TimeSeriesData{
+	{Time: time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC), Value: 1.5},
+	{Time: time.Date(2024, 11, 23, 12, 0, 0, 500000000, time.UTC), Value: math.NaN()},
+	{Time: time.Date(2024, 11, 23, 13, 0, 0, 0, time.UTC), Value: -2},
+}
+
+func writeAll(t *testing.T, format string, compress bool, batches ...[]models.TimeSeriesData) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, compress)
+	require.NoError(t, err)
+	for _, batch := range batches {
+		require.NoError(t, w.Write(batch))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestTextWriters(t *testing.T) {
+	t.Run("csv", func(t *testing.T) {
+		out := writeAll(t, FormatCSV, false, testPoints[:1], testPoints[1:])
+		assert.Equal(t, "time,value\n"+
+			"2024-11-23T12:00:00Z,1.5\n"+
+			"2024-11-23T12:00:00.5Z,\n"+
+			"2024-11-23T13:00:00Z,-2\n", string(out))
+	})
+
+	t.Run("ndjson with gzip", func(t *testing.T) {
+		out := writeAll(t, FormatNDJSON, true, testPoints)
+
+		zr, err := gzip.NewReader(bytes.NewReader(out))
+		require.NoError(t, err)
+		plain, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, `{"time":"2024-11-23T12:00:00Z","value":1.5}`+"\n"+
+			`{"time":"2024-11-23T12:00:00.5Z","value":null}`+"\n"+
+			`{"time":"2024-11-23T13:00:00Z","value":-2}`+"\n", string(plain))
+	})
+
+	t.Run("empty csv has a header", func(t *testing.T) {
+		assert.Equal(t, "time,value\n", string(writeAll(t, FormatCSV, false)))
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := NewWriter(io.Discard, FormatXLSX, false)
+		assert.ErrorContains(t, err, "unsupported export format")
+	})
+}
+
+func TestContentTypeAndExtension(t *testing.T) {
+	assert.Equal(t, ContentTypeCSV, ContentType(FormatCSV, false))
+	assert.Equal(t, ContentTypeGzip, ContentType(FormatNDJSON, true))
+	assert.Equal(t, ContentTypeParquet, ContentType(FormatParquet, true))
+	assert.Equal(t, "csv.gz", FileExtension(FormatCSV, true))
+	assert.Equal(t, "parquet", FileExtension(FormatParquet, true))
+	assert.Equal(t, ContentTypeTSZ, ContentType(FormatTSZ, true))
+	assert.Equal(t, "tsz", FileExtension(FormatTSZ, true))
+}
+
+// thriftValue is a decoded Thrift compact protocol value: an int64, bool,
+// string, []thriftValue or thriftFields
+type thriftValue interface{}
+
+// thriftFields are the fields of a decoded struct by id
+type thriftFields map[int16]thriftValue
+
+// thriftReader decodes the Thrift compact protocol
+type thriftReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	require.Less(r.t, r.pos, len(r.data), "truncated thrift data")
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	require.Positive(r.t, n, "invalid varint")
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) thriftValue {
+	switch typ {
+	case thriftBoolTrue:
+		return true
+	case thriftBoolFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		header := r.byte()
+		size, elem := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]thriftValue, size)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	default:
+		r.t.Fatalf("unsupported thrift type %d", typ)
+		return nil
+	}
+}
+
+func (r *thriftReader) readStruct() thriftFields {
+	fields := thriftFields{}
+	var last int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		typ := header & 0x0f
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(typ)
+		last = id
+	}
+}
+
+// readColumn decodes the values of a column chunk's single data page
+func readColumn(t *testing.T, file []byte, chunk thriftFields) []uint64 {
+	meta := chunk[3].(thriftFields)
+	header := &thriftReader{t: t, data: file, pos: int(meta[9].(int64))}
+	page := header.readStruct()
+
+	body := file[header.pos : header.pos+int(page[3].(int64))]
+	if meta[4].(int64) == parquetCodecGzip {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		body, err = io.ReadAll(zr)
+		require.NoError(t, err)
+	}
+	require.Len(t, body, int(page[2].(int64)))
+
+	values := make([]uint64, len(body)/8)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint64(body[8*i:])
+	}
+	return values
+}
+
+func TestParquetWriter(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%t", compress), func(t *testing.T) {
+			// Enough rows for two row groups
+			points := make([]models.TimeSeriesData, parquetRowGroupSize+10)
+			base := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+			for i := range points {
+				points[i] = models.TimeSeriesData{Time: base.Add(time.Duration(i) * time.Second), Value: float64(i) / 2}
+			}
+			file := writeAll(t, FormatParquet, compress, points[:1000], points[1000:])
+
+			require.Equal(t, parquetMagic, string(file[:4]))
+			require.Equal(t, parquetMagic, string(file[len(file)-4:]))
+			footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+			footer := &thriftReader{t: t, data: file[len(file)-8-footerLength : len(file)-8]}
+			meta := footer.readStruct()
+			assert.Equal(t, len(footer.data), footer.pos, "the footer is fully consumed")
+
+			assert.Equal(t, int64(len(points)), meta[3])
+			schema := meta[2].([]thriftValue)
+			require.Len(t, schema, 3)
+			assert.Equal(t, int64(2), schema[0].(thriftFields)[5])
+			assert.Equal(t, "time", schema[1].(thriftFields)[4])
+			assert.Equal(t, int64(parquetConvertedTimestampMicros), schema[1].(thriftFields)[6])
+			assert.Equal(t, "value", schema[2].(thriftFields)[4])
+
+			rowGroups := meta[4].([]thriftValue)
+			require.Len(t, rowGroups, 2)
+
+			var times, values []uint64
+			for _, group := range rowGroups {
+				columns := group.(thriftFields)[1].([]thriftValue)
+				require.Len(t, columns, 2)
+				times = append(times, readColumn(t, file, columns[0].(thriftFields))...)
+				values = append(values, readColumn(t, file, columns[1].(thriftFields))...)
+			}
+
+			require.Len(t, times, len(points))
+			require.Len(t, values, len(points))
+			last := len(points) - 1
+			assert.Equal(t, points[last].Time.UnixMicro(), int64(times[last]))
+			assert.Equal(t, points[last].Value, math.Float64frombits(values[last]))
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		file := writeAll(t, FormatParquet, false)
+		footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+		meta := (&thriftReader{t: t, data: file[len(file)-8-footerLength : len(file)-8]}).readStruct()
+		assert.Equal(t, int64(0), meta[3])
+		assert.Empty(t, meta[4])
+	})
+}
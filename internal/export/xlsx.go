@@ -0,0 +1,388 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentTypeXLSX is the media type of XLSX workbooks
+const ContentTypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// maxSheetName is the longest sheet name Excel accepts
+const maxSheetName = 31
+
+// maxRows is the number of rows in an Excel worksheet; a header and a
+// summary row leave the rest for points
+const maxRows = 1048576
+
+// excelEpoch is day zero of Excel's 1900 date system
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Cell styles, indexes into cellXfs of xlsxStyles
+const (
+	styleDefault  = 0
+	styleBold     = 1
+	styleDateTime = 2
+)
+
+// summaries maps an aggregation to the label and spreadsheet function of
+// the summary row
+var summaries = map[string]struct{ label, function string }{
+	"":    {"Total", "SUM"},
+	"SUM": {"Total", "SUM"},
+	"AVG": {"Average", "AVERAGE"},
+	"MIN": {"Minimum", "MIN"},
+	"MAX": {"Maximum", "MAX"},
+	// Ratios do not add up; their average and highest bucket summarize them
+	"LOAD_FACTOR": {"Average", "AVERAGE"},
+	"UTILIZATION": {"Peak", "MAX"},
+}
+
+// WriteXLSX writes sheets as an XLSX workbook with one worksheet per
+// sheet. Each worksheet has a header row, one row per point with its
+// timestamp as an Excel date in location (Excel dates carry no zone), and
+// a summary row with a formula matching the sheet's aggregation.
+func WriteXLSX(w io.Writer, sheets []Sheet, location *time.Location) error {
+	if len(sheets) == 0 {
+		return fmt.Errorf("no sheets to export")
+	}
+	tables := make([]table, len(sheets))
+	for i, sheet := range sheets {
+		if _, ok := summaries[sheet.Aggregation]; !ok {
+			return fmt.Errorf("sheet %s: unknown aggregation %q", sheet.Name, sheet.Aggregation)
+		}
+		if len(sheet.Points) > maxRows-2 {
+			return fmt.Errorf("sheet %s: %d points exceed the worksheet limit of %d", sheet.Name, len(sheet.Points), maxRows-2)
+		}
+
+		rows := make([]Row, len(sheet.Points))
+		for j, p := range sheet.Points {
+			rows[j] = Row{Time: p.Time, Values: []float64{p.Value}}
+		}
+		tables[i] = table{
+			columns: []Column{{Name: "Value", Aggregation: sheet.Aggregation}},
+			rows:    rows,
+		}
+	}
+
+	return writeXLSX(w, sheetNames(sheets), tables, location)
+}
+
+// WritePivotXLSX writes columns pivoted by timestamp as an XLSX workbook
+// with a single worksheet called name. The worksheet has a time column
+// followed by one column per Column, with empty cells where a column has
+// no point, and a summary row matching each column's aggregation.
+func WritePivotXLSX(w io.Writer, name string, columns []Column, location *time.Location) error {
+	if err := validateColumns(columns); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if _, ok := summaries[column.Aggregation]; !ok {
+			return fmt.Errorf("column %s: unknown aggregation %q", column.Name, column.Aggregation)
+		}
+	}
+	rows := Pivot(columns)
+	if len(rows) > maxRows-2 {
+		return fmt.Errorf("%d rows exceed the worksheet limit of %d", len(rows), maxRows-2)
+	}
+
+	return writeXLSX(w, sheetNames([]Sheet{{Name: name}}), []table{{columns: columns, rows: rows}}, location)
+}
+
+// table is the content of one worksheet: a time column followed by the
+// values of columns, whose points are already merged into rows
+type table struct {
+	columns []Column
+	rows    []Row
+}
+
+// writeXLSX writes a workbook with a worksheet per table
+func writeXLSX(w io.Writer, names []string, tables []table, location *time.Location) error {
+	archive := zip.NewWriter(w)
+
+	parts := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"[Content_Types].xml", func(w io.Writer) error { return writeContentTypes(w, len(tables)) }},
+		{"_rels/.rels", writeString(xlsxRootRels)},
+		{"xl/workbook.xml", func(w io.Writer) error { return writeWorkbook(w, names) }},
+		{"xl/_rels/workbook.xml.rels", func(w io.Writer) error { return writeWorkbookRels(w, len(tables)) }},
+		{"xl/styles.xml", writeString(xlsxStyles)},
+	}
+	for i, t := range tables {
+		parts = append(parts, struct {
+			name  string
+			write func(io.Writer) error
+		}{
+			fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1),
+			func(w io.Writer) error { return writeWorksheet(w, t, location) },
+		})
+	}
+
+	for _, part := range parts {
+		pw, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if err := part.write(pw); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// sheetNames returns valid, unique worksheet names for sheets
+func sheetNames(sheets []Sheet) []string {
+	replacer := strings.NewReplacer("[", "_", "]", "_", ":", "_", "*", "_", "?", "_", "/", "_", "\\", "_")
+
+	used := make(map[string]bool, len(sheets))
+	names := make([]string, len(sheets))
+	for i, sheet := range sheets {
+		base := strings.Trim(replacer.Replace(sheet.Name), "'")
+		if base == "" {
+			base = "Sheet" + strconv.Itoa(i+1)
+		}
+
+		name := truncate(base, maxSheetName)
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			suffix := " (" + strconv.Itoa(n) + ")"
+			name = truncate(base, maxSheetName-len(suffix)) + suffix
+		}
+		used[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+// truncate shortens s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// excelTime converts t to an Excel serial date of its wall clock time in
+// location
+func excelTime(t time.Time, location *time.Location) float64 {
+	local := t.In(location)
+	wall := time.Date(local.Year(), local.Month(), local.Day(),
+		local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	return float64(wall.Sub(excelEpoch)) / float64(24*time.Hour)
+}
+
+// writeWorksheet writes the rows of one table
+func writeWorksheet(w io.Writer, t table, location *time.Location) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	fmt.Fprintf(&b, `<cols><col min="1" max="1" width="20" customWidth="1"/><col min="2" max="%d" width="14" customWidth="1"/></cols>`, len(t.columns)+1)
+	b.WriteString(`<sheetData>`)
+
+	cells := make([]string, len(t.columns)+1)
+	cells[0] = inlineCell("A1", "Time ("+location.String()+")", styleBold)
+	for i, column := range t.columns {
+		cells[i+1] = inlineCell(columnRef(i+1)+"1", column.Name, styleBold)
+	}
+	writeRow(&b, 1, cells...)
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	// Running summaries of each column; missing values are skipped, as
+	// spreadsheet functions skip empty cells
+	count := make([]int, len(t.columns))
+	total := make([]float64, len(t.columns))
+	minimum := make([]float64, len(t.columns))
+	maximum := make([]float64, len(t.columns))
+	for i, r := range t.rows {
+		b.Reset()
+		row := strconv.Itoa(i + 2)
+		cells[0] = numberCell("A"+row, excelTime(r.Time, location), styleDateTime)
+		for c, value := range r.Values {
+			cells[c+1] = numberCell(columnRef(c+1)+row, value, styleDefault)
+			if math.IsNaN(value) {
+				continue
+			}
+			total[c] += value
+			if count[c] == 0 || value < minimum[c] {
+				minimum[c] = value
+			}
+			if count[c] == 0 || value > maximum[c] {
+				maximum[c] = value
+			}
+			count[c]++
+		}
+		writeRow(&b, i+2, cells...)
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+
+	// The formulas are recalculated by spreadsheet applications; the
+	// cached values are shown by viewers that do not evaluate formulas
+	b.Reset()
+	row := len(t.rows) + 2
+	cells = cells[:1]
+	cells[0] = inlineCell("A"+strconv.Itoa(row), summaryLabel(t.columns), styleBold)
+	if len(t.rows) > 0 {
+		for c, column := range t.columns {
+			summary := summaries[column.Aggregation]
+			cached := total[c]
+			switch summary.function {
+			case "AVERAGE":
+				cached = total[c] / float64(count[c])
+			case "MIN":
+				cached = minimum[c]
+			case "MAX":
+				cached = maximum[c]
+			}
+			if count[c] == 0 && summary.function != "SUM" {
+				cached = math.NaN()
+			}
+			ref := columnRef(c + 1)
+			cells = append(cells, formulaCell(ref+strconv.Itoa(row), fmt.Sprintf("%s(%s2:%s%d)", summary.function, ref, ref, row-1), cached, styleBold))
+		}
+	}
+	writeRow(&b, row, cells...)
+	b.WriteString(`</sheetData></worksheet>`)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// summaryLabel labels the summary row after the aggregation of columns,
+// or generically if they are summarized differently
+func summaryLabel(columns []Column) string {
+	label := summaries[columns[0].Aggregation].label
+	for _, column := range columns[1:] {
+		if summaries[column.Aggregation].label != label {
+			return "Summary"
+		}
+	}
+	return label
+}
+
+// columnRef returns the letters of the zero-based column index
+func columnRef(index int) string {
+	ref := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		ref = string(rune('A'+(index-1)%26)) + ref
+	}
+	return ref
+}
+
+// writeRow appends a row of cells
+func writeRow(b *strings.Builder, row int, cells ...string) {
+	fmt.Fprintf(b, `<row r="%d">`, row)
+	for _, cell := range cells {
+		b.WriteString(cell)
+	}
+	b.WriteString(`</row>`)
+}
+
+// inlineCell returns a text cell
+func inlineCell(ref, text string, style int) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return fmt.Sprintf(`<c r="%s" s="%d" t="inlineStr"><is><t>%s</t></is></c>`, ref, style, escaped.String())
+}
+
+// numberCell returns a numeric cell. Excel has no representation of NaN
+// or infinity, so they are left empty.
+func numberCell(ref string, value float64, style int) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Sprintf(`<c r="%s" s="%d"/>`, ref, style)
+	}
+	return fmt.Sprintf(`<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// formulaCell returns a cell computed by formula, with its cached value
+func formulaCell(ref, formula string, cached float64, style int) string {
+	if math.IsNaN(cached) || math.IsInf(cached, 0) {
+		return fmt.Sprintf(`<c r="%s" s="%d"><f>%s</f></c>`, ref, style, formula)
+	}
+	return fmt.Sprintf(`<c r="%s" s="%d"><f>%s</f><v>%s</v></c>`, ref, style, formula, strconv.FormatFloat(cached, 'g', -1, 64))
+}
+
+// writeString returns a part writer for fixed content
+func writeString(content string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}
+}
+
+func writeContentTypes(w io.Writer, sheets int) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeWorkbook(w io.Writer, names []string) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, name := range names {
+		b.WriteString(`<sheet name="`)
+		xml.EscapeText(&b, []byte(name))
+		fmt.Fprintf(&b, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeWorkbookRels(w io.Writer, sheets int) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	b.WriteString(`</Relationships>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+const xlsxRootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// xlsxStyles defines the cell styles: default, bold, and date-time
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`</styleSheet>`
@@ -0,0 +1,118 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// worksheet is the part of a worksheet the tests inspect
+type worksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref     string `xml:"r,attr"`
+			Style   int    `xml:"s,attr"`
+			Value   string `xml:"v"`
+			Formula string `xml:"f"`
+			Text    string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readWorkbook returns the parts of an XLSX archive by name
+func readWorkbook(t *testing.T, data []byte) map[string][]byte {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	parts := make(map[string][]byte)
+	for _, file := range archive.File {
+		rc, err := file.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		parts[file.Name] = content
+	}
+	return parts
+}
+
+func TestWriteXLSX(t *testing.T) {
+	base := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	sheets := []Sheet{
+		{
+			Name:        "default",
+			Aggregation: "AVG",
+			Points: []models.TimeSeriesData{
+				{Time: base, Value: 10},
+				{Time: base.Add(time.Hour), Value: 20},
+				{Time: base.Add(2 * time.Hour), Value: math.NaN()},
+			},
+		},
+		{Name: "building a/b: <north>", Aggregation: "SUM"},
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteXLSX(&buf, sheets, berlin))
+	parts := readWorkbook(t, buf.Bytes())
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		assert.Contains(t, parts, name)
+	}
+	assert.Contains(t, string(parts["xl/workbook.xml"]), `name="default"`)
+	assert.Contains(t, string(parts["xl/workbook.xml"]), `name="building a_b_ &lt;north&gt;"`)
+
+	var sheet worksheet
+	require.NoError(t, xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet))
+	require.Len(t, sheet.Rows, 5)
+
+	header := sheet.Rows[0].Cells
+	assert.Equal(t, "Time (Europe/Berlin)", header[0].Text)
+	assert.Equal(t, "Value", header[1].Text)
+
+	// 13:00 in Berlin on 23 November 2024
+	first := sheet.Rows[1].Cells
+	assert.Equal(t, styleDateTime, first[0].Style)
+	assert.Equal(t, "45619.541666666664", first[0].Value)
+	assert.Equal(t, "10", first[1].Value)
+	assert.Empty(t, sheet.Rows[3].Cells[1].Value, "NaN is left empty")
+
+	summary := sheet.Rows[4].Cells
+	assert.Equal(t, "Average", summary[0].Text)
+	assert.Equal(t, "AVERAGE(B2:B4)", summary[1].Formula)
+
+	var empty worksheet
+	require.NoError(t, xml.Unmarshal(parts["xl/worksheets/sheet2.xml"], &empty))
+	require.Len(t, empty.Rows, 2)
+	assert.Equal(t, "Total", empty.Rows[1].Cells[0].Text)
+}
+
+func TestWriteXLSXErrors(t *testing.T) {
+	assert.ErrorContains(t, WriteXLSX(io.Discard, nil, time.UTC), "no sheets")
+	assert.ErrorContains(t, WriteXLSX(io.Discard, []Sheet{{Name: "a", Aggregation: "MEDIAN"}}, time.UTC), "unknown aggregation")
+}
+
+func TestSheetNames(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	names := sheetNames([]Sheet{{Name: "Energy"}, {Name: "energy"}, {Name: ""}, {Name: long}, {Name: long}})
+
+	assert.Equal(t, []string{
+		"Energy",
+		"energy (2)",
+		"Sheet3",
+		strings.Repeat("x", 31),
+		strings.Repeat("x", 27) + " (2)",
+	}, names)
+}
@@ -0,0 +1,171 @@
+package expression
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Derived is a set of named derived series, each defined by an expression
+// over base series, whose values are stored, and other derived series.
+type Derived struct {
+	expressions map[string]*Expression
+	base        map[string]bool
+}
+
+// NewDerived parses definitions, which map the names of derived series to
+// their expressions. Expressions may refer to the base series and to other
+// derived series, but not to themselves through any chain of references.
+func NewDerived(definitions map[string]string, base ...string) (*Derived, error) {
+	d := &Derived{
+		expressions: make(map[string]*Expression, len(definitions)),
+		base:        make(map[string]bool, len(base)),
+	}
+	for _, name := range base {
+		d.base[name] = true
+	}
+
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !validName(name) {
+			return nil, fmt.Errorf("invalid derived series name %q", name)
+		}
+		if d.base[name] {
+			return nil, fmt.Errorf("derived series %s: the name of a stored series", name)
+		}
+		expr, err := Parse(definitions[name])
+		if err != nil {
+			return nil, fmt.Errorf("derived series %s: %w", name, err)
+		}
+		d.expressions[name] = expr
+	}
+
+	for _, name := range names {
+		for _, variable := range d.expressions[name].Variables() {
+			if !d.base[variable] && d.expressions[variable] == nil {
+				return nil, fmt.Errorf("derived series %s: unknown series %s", name, variable)
+			}
+		}
+		if err := d.checkCycle(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Extend returns a set holding the series of d and those of definitions,
+// which may refer to the series of d but not redefine them. d is left
+// unchanged.
+func (d *Derived) Extend(definitions map[string]string) (*Derived, error) {
+	combined := make(map[string]string, len(d.expressions)+len(definitions))
+	for name, expr := range d.expressions {
+		combined[name] = expr.String()
+	}
+	for name, expr := range definitions {
+		if d.expressions[name] != nil {
+			return nil, fmt.Errorf("derived series %s is already defined", name)
+		}
+		combined[name] = expr
+	}
+	base := make([]string, 0, len(d.base))
+	for name := range d.base {
+		base = append(base, name)
+	}
+	return NewDerived(combined, base...)
+}
+
+// checkCycle returns an error if name refers back to a series in path
+func (d *Derived) checkCycle(name string, path []string) error {
+	for _, seen := range path {
+		if seen == name {
+			return fmt.Errorf("derived series %s refers to itself", name)
+		}
+	}
+	expr := d.expressions[name]
+	if expr == nil {
+		return nil
+	}
+	for _, variable := range expr.Variables() {
+		if err := d.checkCycle(variable, append(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Has reports whether name is a derived series. It is false for a nil set.
+func (d *Derived) Has(name string) bool {
+	return d != nil && d.expressions[name] != nil
+}
+
+// Names returns the names of the derived series, sorted.
+func (d *Derived) Names() []string {
+	if d == nil {
+		return nil
+	}
+	names := make([]string, 0, len(d.expressions))
+	for name := range d.expressions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expression returns the expression of the derived series name, or an
+// empty string if it is not one.
+func (d *Derived) Expression(name string) string {
+	if !d.Has(name) {
+		return ""
+	}
+	return d.expressions[name].String()
+}
+
+// Eval computes the value of the derived series name from the values of
+// the base series.
+func (d *Derived) Eval(name string, base map[string]float64) (float64, error) {
+	if !d.Has(name) {
+		return 0, fmt.Errorf("unknown derived series %s", name)
+	}
+	values := make(map[string]float64, len(base))
+	for k, v := range base {
+		values[k] = v
+	}
+	return d.eval(name, values)
+}
+
+// eval computes the derived series name, adding the derived series it
+// refers to to values
+func (d *Derived) eval(name string, values map[string]float64) (float64, error) {
+	expr := d.expressions[name]
+	for _, variable := range expr.Variables() {
+		if _, ok := values[variable]; ok || d.expressions[variable] == nil {
+			continue
+		}
+		v, err := d.eval(variable, values)
+		if err != nil {
+			return 0, err
+		}
+		values[variable] = v
+	}
+	return expr.Eval(values)
+}
+
+// validName reports whether name can be referred to in expressions
+func validName(name string) bool {
+	if name == "" || !isNameStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isNameStart(name[i]) && !isDigit(name[i]) {
+			return false
+		}
+	}
+	switch name {
+	case "min", "max", "abs":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDerived(t *testing.T) {
+	derived, err := NewDerived(map[string]string{
+		"net_load":  "default - 40",
+		"with_loss": "net_load * 1.05",
+		"ratio":     "net_load / default",
+	}, "default")
+	require.NoError(t, err)
+
+	assert.True(t, derived.Has("net_load"))
+	assert.False(t, derived.Has("default"), "stored series are not derived")
+	assert.Equal(t, []string{"net_load", "ratio", "with_loss"}, derived.Names())
+
+	v, err := derived.Eval("with_loss", map[string]float64{"default": 120})
+	require.NoError(t, err)
+	assert.InDelta(t, 84.0, v, 1e-9)
+
+	v, err = derived.Eval("ratio", map[string]float64{"default": 80})
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, v)
+
+	_, err = derived.Eval("ratio", map[string]float64{"default": 0})
+	assert.ErrorContains(t, err, "division by zero")
+	_, err = derived.Eval("unknown", nil)
+	assert.ErrorContains(t, err, "unknown derived series")
+
+	var none *Derived
+	assert.False(t, none.Has("net_load"))
+	assert.Empty(t, none.Names())
+}
+
+func TestDerivedErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions map[string]string
+		err         string
+	}{
+		{"invalid name", map[string]string{"net-load": "default"}, "invalid derived series name"},
+		{"function name", map[string]string{"max": "default"}, "invalid derived series name"},
+		{"stored name", map[string]string{"default": "default * 2"}, "the name of a stored series"},
+		{"invalid expression", map[string]string{"a": "default *"}, "invalid expression"},
+		{"unknown series", map[string]string{"a": "default - solar"}, "unknown series solar"},
+		{"self reference", map[string]string{"a": "a + 1"}, "refers to itself"},
+		{"cycle", map[string]string{"a": "b + 1", "b": "a * 2"}, "refers to itself"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDerived(tt.definitions, "default")
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestDerivedExtend(t *testing.T) {
+	derived, err := NewDerived(map[string]string{"net_load": "default - 40"}, "default")
+	require.NoError(t, err)
+
+	extended, err := derived.Extend(map[string]string{"billed": "net_load * 2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"billed", "net_load"}, extended.Names())
+	v, err := extended.Eval("billed", map[string]float64{"default": 50})
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, v)
+	assert.False(t, derived.Has("billed"), "the extended set is left unchanged")
+	assert.Equal(t, "net_load * 2", extended.Expression("billed"))
+	assert.Empty(t, derived.Expression("billed"))
+
+	_, err = derived.Extend(map[string]string{"net_load": "default"})
+	assert.ErrorContains(t, err, "already defined")
+	_, err = derived.Extend(map[string]string{"default": "net_load"})
+	assert.ErrorContains(t, err, "the name of a stored series")
+	_, err = derived.Extend(map[string]string{"billed": "solar * 2"})
+	assert.ErrorContains(t, err, "unknown series solar")
+}
+
+func TestDerivedShape(t *testing.T) {
+	derived, err := NewDerived(map[string]string{
+		"net_load": "default - 40",
+		"billed":   "(net_load * 3 + 6) / 2",
+		"reversed": "-default",
+		"constant": "default - default + max(2, 5)",
+		"clipped":  "max(net_load, 0) * 2",
+		"absolute": "abs(default)",
+		"ratio":    "net_load / default",
+		"solar":    "solar_feed * 2",
+	}, "default", "solar_feed")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		shape Shape
+	}{
+		{"net_load", Shape{Linear: true, Scale: 1, Offset: -40, Nondecreasing: true}},
+		{"billed", Shape{Linear: true, Scale: 1.5, Offset: -57, Nondecreasing: true}},
+		{"reversed", Shape{Linear: true, Scale: -1}},
+		{"constant", Shape{Linear: true, Offset: 5, Nondecreasing: true}},
+		{"clipped", Shape{Nondecreasing: true}},
+		{"absolute", Shape{}},
+		{"ratio", Shape{}},
+		{"solar", Shape{}},
+		{"default", Shape{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.shape, derived.Shape(tt.name, "default"))
+		})
+	}
+}
@@ -0,0 +1,329 @@
+// Package expression evaluates small arithmetic expressions over named
+// series, such as "default * 0.9" or "400 + 5 * outdoor_temp".
+//
+// Expressions support numbers, +, -, *, / and parentheses, and the
+// functions min, max and abs. Names refer to series, whose values are
+// supplied when an expression is evaluated. Derived series are named
+// expressions over stored series and each other, computed when they are
+// read instead of being materialized.
+//
+// Example Usage:
+//
+//	derived, err := expression.NewDerived(map[string]string{
+//	    "net_load":  "default - 40",
+//	    "with_loss": "net_load * 1.05",
+//	}, "default")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	value, err := derived.Eval("with_loss", map[string]float64{"default": 120})
+package expression
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed arithmetic expression over the values of named
+// series.
+type Expression struct {
+	source    string
+	root      node
+	variables []string
+}
+
+// node is a parsed part of an expression
+type node interface {
+	eval(values map[string]float64) (float64, error)
+	// shape returns how the node depends on a base series, given how the
+	// variables do
+	shape(resolve func(variable string) Shape) Shape
+}
+
+type number float64
+
+type variable string
+
+type negation struct {
+	operand node
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+type call struct {
+	name string
+	args []node
+}
+
+// Parse parses source into an expression.
+func Parse(source string) (*Expression, error) {
+	p := &parser{input: source}
+	root, err := p.expression()
+	if err == nil && p.skipSpace() < len(p.input) {
+		err = fmt.Errorf("unexpected %q at offset %d", p.input[p.pos], p.pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+
+	variables := make([]string, 0, len(p.variables))
+	for name := range p.variables {
+		variables = append(variables, name)
+	}
+	sort.Strings(variables)
+	return &Expression{source: source, root: root, variables: variables}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Variables returns the names of the series the expression refers to,
+// sorted.
+func (e *Expression) Variables() []string {
+	return e.variables
+}
+
+// Eval computes the expression with the given values of its variables.
+func (e *Expression) Eval(values map[string]float64) (float64, error) {
+	v, err := e.root.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%s is not a finite number", e.source)
+	}
+	return v, nil
+}
+
+func (n number) eval(map[string]float64) (float64, error) {
+	return float64(n), nil
+}
+
+func (n variable) eval(values map[string]float64) (float64, error) {
+	v, ok := values[string(n)]
+	if !ok {
+		return 0, fmt.Errorf("no value of %s", string(n))
+	}
+	return v, nil
+}
+
+func (n negation) eval(values map[string]float64) (float64, error) {
+	v, err := n.operand.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	return -v, nil
+}
+
+func (n binary) eval(values map[string]float64) (float64, error) {
+	left, err := n.left.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	}
+}
+
+func (n call) eval(values map[string]float64) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(values)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	switch n.name {
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "min":
+		result := args[0]
+		for _, v := range args[1:] {
+			result = math.Min(result, v)
+		}
+		return result, nil
+	default:
+		result := args[0]
+		for _, v := range args[1:] {
+			result = math.Max(result, v)
+		}
+		return result, nil
+	}
+}
+
+// parser is a recursive descent parser of expressions:
+//
+//	expression = term { ("+" | "-") term }
+//	term       = factor { ("*" | "/") factor }
+//	factor     = "-" factor | number | name | name "(" arguments ")" | "(" expression ")"
+type parser struct {
+	input     string
+	pos       int
+	variables map[string]bool
+}
+
+// skipSpace moves past white space and returns the position reached
+func (p *parser) skipSpace() int {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	return p.pos
+}
+
+// peek returns the next character, or 0 at the end of the input
+func (p *parser) peek() byte {
+	if p.skipSpace() == len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) expression() (node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) term() (node, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) factor() (node, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end")
+	case c == '-':
+		p.pos++
+		operand, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return negation{operand: operand}, nil
+	case c == '(':
+		p.pos++
+		inner, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		p.pos++
+		return inner, nil
+	case c == '.' || isDigit(c):
+		start := p.pos
+		for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+		}
+		return number(v), nil
+	case isNameStart(c):
+		start := p.pos
+		for p.pos < len(p.input) && (isNameStart(p.input[p.pos]) || isDigit(p.input[p.pos])) {
+			p.pos++
+		}
+		name := p.input[start:p.pos]
+		if p.peek() == '(' {
+			p.pos++
+			return p.call(name)
+		}
+		if p.variables == nil {
+			p.variables = make(map[string]bool)
+		}
+		p.variables[name] = true
+		return variable(name), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+	}
+}
+
+// call parses the arguments of a call to the function name, after its
+// opening parenthesis
+func (p *parser) call(name string) (node, error) {
+	switch strings.ToLower(name) {
+	case "abs", "min", "max":
+		name = strings.ToLower(name)
+	default:
+		return nil, fmt.Errorf("unknown function %s, expected min, max or abs", name)
+	}
+
+	var args []node
+	for {
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if p.peek() != ')' {
+		return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+	}
+	p.pos++
+	if name == "abs" && len(args) != 1 {
+		return nil, fmt.Errorf("abs takes one argument")
+	}
+	return call{name: name, args: args}, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,53 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpression(t *testing.T) {
+	values := map[string]float64{"outdoor_temp": 30, "humidity": 0.5}
+	tests := []struct {
+		source    string
+		want      float64
+		variables []string
+	}{
+		{"42", 42, []string{}},
+		{"400 + 5 * outdoor_temp", 550, []string{"outdoor_temp"}},
+		{"(400 + 5) * 2", 810, []string{}},
+		{"10 - 4 - 3", 3, []string{}},
+		{"12 / 4 / 3", 1, []string{}},
+		{"-outdoor_temp + -(-2)", -28, []string{"outdoor_temp"}},
+		{"max(0, outdoor_temp - 20) * 10 + humidity", 100.5, []string{"humidity", "outdoor_temp"}},
+		{"MIN(3, 1, 2) + abs(-4)", 5, []string{}},
+		{" .5*outdoor_temp ", 15, []string{"outdoor_temp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, err := Parse(tt.source)
+			require.NoError(t, err)
+			got, err := expr.Eval(values)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 1e-9)
+			assert.Equal(t, tt.variables, expr.Variables())
+		})
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for _, source := range []string{"", "1 +", "(1", "1 2", "2 * # 3", "sqrt(4)", "abs(1, 2)", "1..2", "max()"} {
+		t.Run(source, func(t *testing.T) {
+			_, err := Parse(source)
+			assert.ErrorContains(t, err, "invalid expression")
+		})
+	}
+
+	expr, err := Parse("100 / outdoor_temp")
+	require.NoError(t, err)
+	_, err = expr.Eval(map[string]float64{"outdoor_temp": 0})
+	assert.ErrorContains(t, err, "division by zero")
+	_, err = expr.Eval(nil)
+	assert.ErrorContains(t, err, "no value of outdoor_temp")
+}
@@ -0,0 +1,121 @@
+package expression
+
+// Shape describes how a derived series depends on a base series, which
+// tells whether aggregating the base series and then computing the derived
+// one gives the same result as aggregating the derived series itself.
+type Shape struct {
+	// Linear is set when the series is Scale * base + Offset, so that its
+	// averages are those of the base series computed, and its sums those
+	// of the base series scaled plus Offset per sample
+	Linear bool
+	Scale  float64
+	Offset float64
+	// Nondecreasing is set when the series never decreases as the base
+	// series increases, so that its minimum and maximum are those of the
+	// base series computed
+	Nondecreasing bool
+}
+
+// linear returns the shape of scale * base + offset
+func linear(scale, offset float64) Shape {
+	return Shape{Linear: true, Scale: scale, Offset: offset, Nondecreasing: scale >= 0}
+}
+
+// isConstant reports whether s does not depend on the base
+func (s Shape) isConstant() bool {
+	return s.Linear && s.Scale == 0
+}
+
+// Shape returns how the derived series name depends on the base series
+// base. Series referring to other base series, and those that are not
+// derived, have the zero Shape.
+func (d *Derived) Shape(name, base string) Shape {
+	if !d.Has(name) || !d.base[base] {
+		return Shape{}
+	}
+	var resolve func(variable string) Shape
+	resolve = func(variable string) Shape {
+		switch {
+		case variable == base:
+			return linear(1, 0)
+		case d.expressions[variable] != nil:
+			return d.expressions[variable].root.shape(resolve)
+		}
+		return Shape{}
+	}
+	return resolve(name)
+}
+
+func (n number) shape(func(string) Shape) Shape {
+	return linear(0, float64(n))
+}
+
+func (n variable) shape(resolve func(string) Shape) Shape {
+	return resolve(string(n))
+}
+
+func (n negation) shape(resolve func(string) Shape) Shape {
+	operand := n.operand.shape(resolve)
+	if !operand.Linear {
+		return Shape{}
+	}
+	return linear(-operand.Scale, -operand.Offset)
+}
+
+func (n binary) shape(resolve func(string) Shape) Shape {
+	left, right := n.left.shape(resolve), n.right.shape(resolve)
+	switch n.op {
+	case '+':
+		if left.Linear && right.Linear {
+			return linear(left.Scale+right.Scale, left.Offset+right.Offset)
+		}
+		return Shape{Nondecreasing: left.Nondecreasing && right.Nondecreasing}
+	case '-':
+		if left.Linear && right.Linear {
+			return linear(left.Scale-right.Scale, left.Offset-right.Offset)
+		}
+		return Shape{Nondecreasing: left.Nondecreasing && right.isConstant()}
+	case '*':
+		if right.isConstant() {
+			left, right = right, left
+		}
+		if !left.isConstant() {
+			return Shape{}
+		}
+		c := left.Offset
+		if right.Linear {
+			return linear(c*right.Scale, c*right.Offset)
+		}
+		return Shape{Nondecreasing: right.Nondecreasing && c >= 0}
+	default:
+		if !right.isConstant() || right.Offset == 0 {
+			return Shape{}
+		}
+		c := right.Offset
+		if left.Linear {
+			return linear(left.Scale/c, left.Offset/c)
+		}
+		return Shape{Nondecreasing: left.Nondecreasing && c > 0}
+	}
+}
+
+func (n call) shape(resolve func(string) Shape) Shape {
+	values := make([]float64, len(n.args))
+	constant := true
+	nondecreasing := n.name != "abs"
+	for i, arg := range n.args {
+		s := arg.shape(resolve)
+		nondecreasing = nondecreasing && s.Nondecreasing
+		constant = constant && s.isConstant()
+		values[i] = s.Offset
+	}
+	if !constant {
+		return Shape{Nondecreasing: nondecreasing}
+	}
+	args := make([]node, len(values))
+	for i, v := range values {
+		args[i] = number(v)
+	}
+	v, _ := call{name: n.name, args: args}.eval(nil)
+	return linear(0, v)
+}
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tejusbharadwaj/edgecom/internal/bucketcache"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// Headers flagging stale responses
+const (
+	// HeaderStale is set to "true" on responses served from the service's
+	// cache while the database was down
+	HeaderStale = "X-Edgecom-Stale"
+	// HeaderStaleAge is the number of seconds since a stale response was
+	// invalidated by newer data
+	HeaderStaleAge = "X-Edgecom-Stale-Age"
+)
+
+// Cache policy defaults
+const (
+	// DefaultCacheMaxAge is how long historical responses may be reused by
+	// default, the staleness the bucket cache accepts for data written by
+	// other processes
+	DefaultCacheMaxAge = bucketcache.DefaultMaxAge
+	// DefaultCacheHorizon is how far back data may still arrive by
+	// default, covering collection runs and their retries
+	DefaultCacheHorizon = time.Hour
+)
+
+// CachePolicy decides the Cache-Control and Expires headers of successful
+// responses, so that browsers and CDNs may reuse them by the same rules
+// the service's own caches follow:
+//   - responses that are never cached internally, such as the latest
+//     reading, are sent with no-cache, so they are revalidated against
+//     their ETag on every use
+//   - responses over a range ending within Horizon of now, where data may
+//     still arrive, like the open bucket the bucket cache always reads
+//     from the database, are sent with no-cache too
+//   - responses over historical ranges may be reused for MaxAge, as the
+//     bucket cache serves them; inserts evict the service's caches, but
+//     downstream caches can only be bounded in time
+//
+// Responses are marked private when callers are authenticated and public
+// otherwise. Errors are sent with no-store. Stale responses, served from
+// the service's cache while the database is down, are sent with no-cache
+// and flagged as stale, whatever their range.
+type CachePolicy struct {
+	// MaxAge is how long historical responses may be reused; zero sends
+	// every response with no-cache
+	MaxAge time.Duration
+	// Horizon is how far back from now data may still arrive
+	Horizon time.Duration
+	// Clock tells the time ranges are compared against, which is the
+	// simulated time when the service runs on a virtual clock
+	Clock clock.Clock
+}
+
+// DefaultCachePolicy returns the policy of a gateway on the wall clock.
+func DefaultCachePolicy() CachePolicy {
+	return CachePolicy{
+		MaxAge:  DefaultCacheMaxAge,
+		Horizon: DefaultCacheHorizon,
+		Clock:   clock.System,
+	}
+}
+
+// SetCachePolicy replaces the DefaultCachePolicy of the gateway. It must
+// be called before the gateway starts serving.
+func (g *Gateway) SetCachePolicy(policy CachePolicy) {
+	g.cachePolicy = policy
+}
+
+// setCacheHeaders sets the caching headers of a successful response over
+// a range ending at end, or without a range when end is zero
+func (g *Gateway) setCacheHeaders(header http.Header, end time.Time) {
+	policy := g.cachePolicy
+	if policy.MaxAge <= 0 || end.IsZero() || end.After(policy.Clock.Now().Add(-policy.Horizon)) {
+		header.Set("Cache-Control", "no-cache")
+		return
+	}
+
+	visibility := "public"
+	if g.authenticator != nil {
+		visibility = "private"
+	}
+	header.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(policy.MaxAge.Seconds())))
+	header.Set("Expires", time.Now().Add(policy.MaxAge).UTC().Format(http.TimeFormat))
+}
+
+// setStaleHeaders replaces the caching headers of msg if its metadata
+// flags it as stale, so that downstream caches do not keep it once the
+// database is back, and flags it with a Warning and the stale headers
+func setStaleHeaders(header http.Header, msg proto.Message) {
+	withMetadata, ok := msg.(interface{ GetMetadata() *pb.QueryMetadata })
+	if !ok || !withMetadata.GetMetadata().GetStale() {
+		return
+	}
+	age := withMetadata.GetMetadata().GetStaleAge().AsDuration()
+
+	header.Set("Cache-Control", "no-cache")
+	header.Del("Expires")
+	header.Set("Warning", `110 - "Response is Stale"`)
+	header.Set(HeaderStale, "true")
+	header.Set(HeaderStaleAge, strconv.FormatInt(int64(age.Seconds()), 10))
+}
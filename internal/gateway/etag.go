@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// computeETag returns a strong ETag for msg.
+//
+// The hash is taken over the deterministic protobuf wire encoding rather
+// than the JSON body, because protojson output is intentionally unstable
+// and would otherwise produce different tags for identical data.
+func computeETag(msg proto.Message) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+//
+// Per RFC 9110, If-None-Match uses weak comparison, so a W/ prefix on either
+// side is ignored. The header may contain a comma-separated list of tags or
+// the wildcard "*".
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
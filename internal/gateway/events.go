@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+)
+
+const (
+	// heartbeatInterval keeps idle event streams alive through proxies
+	// that close silent connections
+	heartbeatInterval = 15 * time.Second
+	// reconnectDelay is the retry hint sent to EventSource clients
+	reconnectDelay = 5 * time.Second
+)
+
+// handleEvents serves GET /v1/timeseries/events as a Server-Sent Events
+// stream of bucket updates for a subscribed range and window.
+//
+// Query parameters:
+//   - start: beginning of the range (RFC 3339, required)
+//   - end: end of the range (RFC 3339, optional, open-ended when omitted)
+//   - window, aggregation: bucket width and aggregation (required)
+//
+// The stream opens with a "snapshot" event holding the buckets currently
+// stored in the range, followed by an "update" event each time ingested
+// data inside the range changes one or more buckets. The optional overflow
+// and buffer_size parameters choose how a client that falls behind is
+// handled; one disconnected for it gets an "error" event first.
+func (g *Gateway) handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	window := query.Get("window")
+	aggregation := query.Get("aggregation")
+
+	start, err := parseTimestamp(query.Get("start"))
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid start: %v", err))
+		return
+	}
+
+	var end time.Time
+	if value := query.Get("end"); value != "" {
+		ts, err := parseTimestamp(value)
+		if err != nil {
+			g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid end: %v", err))
+			return
+		}
+		end = ts.AsTime()
+	}
+
+	snapshotEnd := end
+	if snapshotEnd.IsZero() {
+		snapshotEnd = time.Now()
+	}
+	if err := g.validator.Validate(start.AsTime(), snapshotEnd, window, aggregation); err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "%s", err.Error()))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// Subscribe before taking the snapshot so nothing ingested in between
+	// is missed
+	sub, err := g.subscribe(query)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	snapshot, err := g.querier.Query(r.Context(), start.AsTime(), snapshotEnd, window, aggregation)
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.Internal, "query failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Disable response buffering in nginx-style reverse proxies
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay.Milliseconds())
+
+	g.logger.WithFields(logrus.Fields{
+		"remote":      r.RemoteAddr,
+		"start":       start.AsTime(),
+		"end":         end,
+		"window":      window,
+		"aggregation": aggregation,
+	}).Debug("Event stream subscriber connected")
+
+	eventID := 0
+	send := func(event string, points []models.TimeSeriesData) error {
+		body, err := protojson.Marshal(toProtoResponse(points))
+		if err != nil {
+			return err
+		}
+		eventID++
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", eventID, event, body); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send("snapshot", snapshot); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case batch, ok := <-sub.C:
+			if !ok {
+				if errors.Is(sub.Err(), stream.ErrSlowConsumer) {
+					body, _ := json.Marshal(errorBody{
+						Code:    codes.ResourceExhausted.String(),
+						Message: "disconnected for falling behind",
+					})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", body)
+					rc.Flush()
+				}
+				return
+			}
+
+			batch = stream.InRange(batch, start.AsTime(), end)
+			if len(batch) == 0 {
+				continue
+			}
+
+			buckets, err := g.liveUpdate(r.Context(), batch, window, aggregation)
+			if err != nil {
+				g.logger.WithError(err).Error("Failed to aggregate event stream update")
+				continue
+			}
+
+			if err := send("update", buckets); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/export"
+	server "github.com/tejusbharadwaj/edgecom/internal/grpc"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// handleExport serves GET /v1/timeseries/export as a file download. XLSX,
+// the default format, holds the result of the equivalent QueryTimeSeries
+// call with timestamps in the timezone parameter (UTC by default). CSV,
+// NDJSON, Parquet and TSZ are streamed from ExportTimeSeries. Repeating the
+// series or aggregation parameter pivots the export into one column per
+// series and aggregation.
+func (g *Gateway) handleExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := rejectGroupBy(query); err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	if len(query["series"]) > 1 || len(query["aggregation"]) > 1 {
+		g.exportPivot(w, r, query)
+		return
+	}
+
+	switch format := query.Get("format"); format {
+	case "", export.FormatXLSX:
+		g.exportXLSX(w, r, query)
+	case export.FormatCSV, export.FormatNDJSON, export.FormatParquet, export.FormatTSZ:
+		g.exportStream(w, r, query, format)
+	default:
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "unsupported export format: %s", format))
+	}
+}
+
+// exportXLSX writes the query result as a workbook
+func (g *Gateway) exportXLSX(w http.ResponseWriter, r *http.Request, query url.Values) {
+	location, err := parseLocation(query.Get("timezone"))
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	req, err := timeSeriesRequest(query)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	resp, err := g.client.QueryTimeSeries(r.Context(), req)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	points := make([]models.TimeSeriesData, 0, len(resp.Data))
+	for _, dp := range resp.Data {
+		points = append(points, models.TimeSeriesData{Time: dp.Time.AsTime(), Value: dp.Value})
+	}
+
+	// Build the workbook before writing headers, so failures can still be
+	// reported as errors
+	name := database.DefaultSeries
+	if req.Series != "" {
+		name = req.Series
+	}
+	var body bytes.Buffer
+	err = export.WriteXLSX(&body, []export.Sheet{{
+		Name:        name,
+		Aggregation: summaryAggregation(req.Aggregation, req.Transform),
+		Points:      points,
+	}}, location)
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.Internal, "failed to build export: %v", err))
+		return
+	}
+
+	filename := fmt.Sprintf("edgecom-%s-%s.%s",
+		req.Start.AsTime().Format("20060102T1504"),
+		req.End.AsTime().Format("20060102T1504"),
+		export.FormatXLSX)
+
+	w.Header().Set("Content-Type", export.ContentTypeXLSX)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	g.setCacheHeaders(w.Header(), req.End.AsTime())
+	w.WriteHeader(http.StatusOK)
+	if _, err := body.WriteTo(w); err != nil {
+		g.logger.WithError(err).Debug("Failed to write export")
+	}
+}
+
+// exportStream relays an ExportTimeSeries stream. Raw samples are exported
+// unless a window is given; gzip=true compresses the file.
+func (g *Gateway) exportStream(w http.ResponseWriter, r *http.Request, query url.Values, format string) {
+	req, err := timeSeriesRequest(query)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	if req.Series != "" {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "derived series can only be exported with format=xlsx"))
+		return
+	}
+	if req.Transform != "" {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "transforms can only be exported with format=xlsx"))
+		return
+	}
+
+	compress, err := parseBool(query.Get("gzip"))
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid gzip: %v", err))
+		return
+	}
+
+	stream, err := g.client.ExportTimeSeries(r.Context(), &pb.ExportRequest{
+		Start:       req.Start,
+		End:         req.End,
+		Window:      req.Window,
+		Aggregation: req.Aggregation,
+		Format:      format,
+		Gzip:        compress,
+	})
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	// Validation errors arrive with the first message, before any headers
+	// are written
+	first, err := stream.Recv()
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", first.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", first.Filename))
+	g.setCacheHeaders(w.Header(), req.End.AsTime())
+	w.WriteHeader(http.StatusOK)
+
+	for chunk := first; ; {
+		if _, err := w.Write(chunk.Data); err != nil {
+			g.logger.WithError(err).Debug("Failed to write export")
+			return
+		}
+		if chunk, err = stream.Recv(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			// Headers are already sent, so a failure can only cut the
+			// download short. Aborting the response resets the connection
+			// instead of ending the body, so that neither the client nor a
+			// cache allowed by the headers takes the partial file for the
+			// export.
+			g.logger.WithError(err).Error("Export stream failed")
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// exportPivot writes the results of QueryTimeSeries for every combination
+// of the series and aggregation parameters side by side, as columns keyed
+// by bucket timestamp. XLSX, the default format, holds them in a single
+// worksheet; CSV and NDJSON may be gzipped.
+func (g *Gateway) exportPivot(w http.ResponseWriter, r *http.Request, query url.Values) {
+	format := query.Get("format")
+	if format == "" {
+		format = export.FormatXLSX
+	}
+	if format != export.FormatXLSX && format != export.FormatCSV && format != export.FormatNDJSON {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "unsupported pivoted export format: %s", format))
+		return
+	}
+
+	location, err := parseLocation(query.Get("timezone"))
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	compress, err := parseBool(query.Get("gzip"))
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid gzip: %v", err))
+		return
+	}
+	base, err := timeSeriesRequest(query)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	series := valuesOrDefault(query["series"])
+	aggregations := valuesOrDefault(query["aggregation"])
+	if n := len(series) * len(aggregations); n > export.MaxPivotColumns {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "%d columns exceed the limit of %d", n, export.MaxPivotColumns))
+		return
+	}
+
+	var requests []*pb.TimeSeriesRequest
+	var columns []export.Column
+	names := make(map[string]bool)
+	for _, name := range series {
+		for _, aggregation := range aggregations {
+			column := pivotColumnName(name, aggregation, len(series) > 1, len(aggregations) > 1)
+			if names[column] {
+				g.writeError(w, status.Errorf(codes.InvalidArgument, "duplicate column: %s", column))
+				return
+			}
+			names[column] = true
+
+			req := proto.Clone(base).(*pb.TimeSeriesRequest)
+			req.Series = name
+			req.Aggregation = aggregation
+			requests = append(requests, req)
+			columns = append(columns, export.Column{Name: column, Aggregation: summaryAggregation(aggregation, req.Transform)})
+		}
+	}
+
+	for i, req := range requests {
+		resp, err := g.client.QueryTimeSeries(r.Context(), req)
+		if err != nil {
+			g.writeError(w, err)
+			return
+		}
+		columns[i].Points = make([]models.TimeSeriesData, 0, len(resp.Data))
+		for _, dp := range resp.Data {
+			columns[i].Points = append(columns[i].Points, models.TimeSeriesData{Time: dp.Time.AsTime(), Value: dp.Value})
+		}
+	}
+
+	// Build the file before writing headers, so failures can still be
+	// reported as errors
+	var body bytes.Buffer
+	contentType := export.ContentTypeXLSX
+	if format == export.FormatXLSX {
+		compress = false
+		err = export.WritePivotXLSX(&body, "comparison", columns, location)
+	} else {
+		contentType = export.ContentType(format, compress)
+		err = export.WritePivot(&body, format, compress, columns)
+	}
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.Internal, "failed to build export: %v", err))
+		return
+	}
+
+	filename := fmt.Sprintf("edgecom-%s-%s-pivot.%s",
+		base.Start.AsTime().Format("20060102T1504"),
+		base.End.AsTime().Format("20060102T1504"),
+		export.FileExtension(format, compress))
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	g.setCacheHeaders(w.Header(), base.End.AsTime())
+	w.WriteHeader(http.StatusOK)
+	if _, err := body.WriteTo(w); err != nil {
+		g.logger.WithError(err).Debug("Failed to write export")
+	}
+}
+
+// summaryAggregation returns the aggregation whose summary fits buckets
+// of aggregation with transform applied: changes add up to a total, rates
+// are averaged and a running sum ends at its highest value
+func summaryAggregation(aggregation, transform string) string {
+	switch transform {
+	case server.TransformDelta:
+		return server.AggregationSum
+	case server.TransformRate:
+		return server.AggregationAvg
+	case server.TransformCumsum:
+		return server.AggregationMax
+	}
+	return aggregation
+}
+
+// pivotColumnName names the column of a series and aggregation after the
+// parameters that vary between columns
+func pivotColumnName(series, aggregation string, bySeries, byAggregation bool) string {
+	if series == "" {
+		series = database.DefaultSeries
+	}
+	if aggregation == "" {
+		aggregation = "RAW"
+	}
+	switch {
+	case bySeries && byAggregation:
+		return series + " " + aggregation
+	case byAggregation:
+		return aggregation
+	default:
+		return series
+	}
+}
+
+// valuesOrDefault returns the values of a repeated parameter, or the
+// default empty value if it is absent
+func valuesOrDefault(values []string) []string {
+	if len(values) == 0 {
+		return []string{""}
+	}
+	return values
+}
+
+// parseLocation parses an optional timezone parameter, UTC by default
+func parseLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid timezone: %s", name)
+	}
+	return location, nil
+}
+
+// parseBool parses an optional boolean parameter
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
@@ -0,0 +1,641 @@
+// Package gateway exposes the TimeSeriesService over plain HTTP/JSON.
+//
+// The gateway is a thin translation layer in front of the gRPC service.
+// Requests are forwarded through a regular gRPC client so they pass through
+// the same middleware chain (rate limiting, caching, metrics, logging) as
+// native gRPC callers.
+//
+// Endpoints:
+//   - GET /v1/timeseries?start=...&end=...&window=1h&aggregation=AVG[&calendar=office][&series=net_load][&transform=DELTA][&max_points=1000][&weather_normalized=true][&include_checksum=true][&selector=...[&group_by=site]]
+//   - GET /v1/timeseries/latest[?count=N]
+//   - GET /v1/timeseries/statistics?start=...&end=...
+//   - GET /v1/timeseries/summaries?start=...&end=...&period=day
+//   - GET /v1/timeseries/compare?start=...&end=...&window=1d&aggregation=SUM&offset=-7d[&offset=-52w][&calendar=office][&series=net_load][&weather_normalized=true]
+//   - GET /v1/timeseries/topk?start=...&end=...&window=1h&aggregation=MAX&k=10&selector=...[&bottom=true]
+//   - GET /v1/timeseries/export?start=...&end=...&window=1h&aggregation=AVG[&calendar=office][&series=net_load][&timezone=Europe/Berlin][&format=xlsx]
+//   - GET /v1/timeseries/live[?window=1h&aggregation=AVG] (WebSocket)
+//   - GET /v1/timeseries/events?start=...[&end=...]&window=1h&aggregation=AVG (SSE)
+//   - GET /v1/series[?kind=derived][&tag=site:north]
+//   - GET /v1/series/{name}
+//
+// Cross-origin browser access, including WebSocket upgrades, is governed by
+// an optional CORS policy; without one only same-origin WebSocket
+// connections are accepted.
+//
+// With an authenticator set, every request must carry credentials in an
+// Authorization or X-Api-Key header, or in the access_token query parameter
+// for browsers opening WebSocket and SSE connections, which cannot set
+// headers. With an authorizer set too, each endpoint is authorized as a
+// call of the RPC it serves: /v1/timeseries, live and events as
+// QueryTimeSeries, latest as GetLatest, statistics as GetStatistics,
+// summaries as GetSummaries, compare as CompareTimeSeries, topk as TopK,
+// export as ExportTimeSeries, the series list as ListSeries and a series as
+// GetSeries. With an auditor set, every authenticated request is recorded
+// once it is answered.
+//
+// Calls rejected by the service's rate limits are answered with 429 Too
+// Many Requests, with Retry-After and X-RateLimit-Limit, -Remaining and
+// -Reset headers taken from the rejection's details.
+//
+// Timestamps are accepted in RFC 3339 format. Successful responses carry a
+// strong ETag derived from the response content, and requests with a
+// matching If-None-Match header receive 304 Not Modified without a body.
+// Cache-Control and Expires headers let browsers and CDNs reuse responses
+// over historical ranges, as described for CachePolicy.
+//
+// Example Usage:
+//
+//	conn, err := grpc.NewClient("127.0.0.1:8080",
+//	    grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	if err != nil {
+//	    log.Fatalf("Failed to dial gRPC server: %v", err)
+//	}
+//
+//	gw := gateway.New(pb.NewTimeSeriesServiceClient(conn), broker, repo, policy, logger)
+//	http.ListenAndServe(":8081", gw)
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/cors"
+	server "github.com/tejusbharadwaj/edgecom/internal/grpc"
+	middleware "github.com/tejusbharadwaj/edgecom/internal/grpc/middlewares"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// Authenticator identifies the caller of a request from the credentials
+// in the incoming gRPC metadata of ctx, such as an *auth.Authenticator,
+// and returns the context carrying the caller's identity
+type Authenticator interface {
+	Authenticate(ctx context.Context) (context.Context, error)
+}
+
+// Authorizer decides whether the caller identified in ctx may call an RPC,
+// by its full method name, such as an *auth.Policy
+type Authorizer interface {
+	Authorize(ctx context.Context, method string) error
+}
+
+// Auditor records answered requests with their HTTP status code, such as
+// an *audit.Logger
+type Auditor interface {
+	AuditRequest(r *http.Request, code int)
+}
+
+// Gateway translates HTTP/JSON requests into TimeSeriesService calls.
+type Gateway struct {
+	client        pb.TimeSeriesServiceClient
+	broker        *stream.Broker
+	querier       stream.Querier
+	validator     *server.RequestValidator
+	upgrader      websocket.Upgrader
+	logger        *logrus.Logger
+	mux           *http.ServeMux
+	handler       http.Handler
+	authenticator Authenticator
+	authorizer    Authorizer
+	auditor       Auditor
+	cachePolicy   CachePolicy
+}
+
+// New creates a Gateway that forwards requests to the given client.
+//
+// Live endpoints are served from broker, with aggregated updates computed
+// through querier. They are disabled when broker is nil. A nil policy
+// disables cross-origin access.
+func New(
+	client pb.TimeSeriesServiceClient,
+	broker *stream.Broker,
+	querier stream.Querier,
+	policy *cors.Policy,
+	logger *logrus.Logger,
+) *Gateway {
+	g := &Gateway{
+		client:      client,
+		broker:      broker,
+		querier:     querier,
+		validator:   server.NewRequestValidator(),
+		logger:      logger,
+		mux:         http.NewServeMux(),
+		cachePolicy: DefaultCachePolicy(),
+	}
+
+	g.handle("GET /v1/timeseries", pb.TimeSeriesService_QueryTimeSeries_FullMethodName, g.handleQueryTimeSeries)
+	g.handle("GET /v1/timeseries/latest", pb.TimeSeriesService_GetLatest_FullMethodName, g.handleGetLatest)
+	g.handle("GET /v1/timeseries/statistics", pb.TimeSeriesService_GetStatistics_FullMethodName, g.handleGetStatistics)
+	g.handle("GET /v1/timeseries/summaries", pb.TimeSeriesService_GetSummaries_FullMethodName, g.handleGetSummaries)
+	g.handle("GET /v1/timeseries/compare", pb.TimeSeriesService_CompareTimeSeries_FullMethodName, g.handleCompareTimeSeries)
+	g.handle("GET /v1/timeseries/topk", pb.TimeSeriesService_TopK_FullMethodName, g.handleTopK)
+	g.handle("GET /v1/timeseries/export", pb.TimeSeriesService_ExportTimeSeries_FullMethodName, g.handleExport)
+	g.handle("GET /v1/series", pb.TimeSeriesService_ListSeries_FullMethodName, g.handleListSeries)
+	g.handle("GET /v1/series/{name}", pb.TimeSeriesService_GetSeries_FullMethodName, g.handleGetSeries)
+	if broker != nil {
+		g.handle("GET /v1/timeseries/live", pb.TimeSeriesService_QueryTimeSeries_FullMethodName, g.handleLive)
+		g.handle("GET /v1/timeseries/events", pb.TimeSeriesService_QueryTimeSeries_FullMethodName, g.handleEvents)
+	}
+
+	// CORS preflight requests carry no credentials, so the policy answers
+	// them before requests are authenticated
+	g.handler = http.HandlerFunc(g.authenticate)
+	if policy != nil {
+		g.upgrader.CheckOrigin = policy.CheckOrigin
+		g.handler = policy.Handler(g.handler)
+	}
+
+	return g
+}
+
+// SetAuthenticator requires every request to carry credentials that
+// authenticator accepts. The gateway's client must then be one the
+// service accepts for any caller the gateway has authenticated. It must be
+// called before the gateway starts serving.
+func (g *Gateway) SetAuthenticator(authenticator Authenticator) {
+	g.authenticator = authenticator
+}
+
+// SetAuthorizer lets authenticated callers request only the endpoints
+// whose RPC authorizer allows them to call, since the service sees every
+// call forwarded by the gateway as coming from the gateway itself. It has
+// no effect without an authenticator, and must be called before the
+// gateway starts serving.
+func (g *Gateway) SetAuthorizer(authorizer Authorizer) {
+	g.authorizer = authorizer
+}
+
+// SetAuditor records every request to an endpoint with auditor once it is
+// answered, including requests the authorizer denies. It must be called
+// before the gateway starts serving.
+func (g *Gateway) SetAuditor(auditor Auditor) {
+	g.auditor = auditor
+}
+
+// handle registers the handler for pattern, authorized as a call of the
+// RPC with the full name method
+func (g *Gateway) handle(pattern, method string, handler http.HandlerFunc) {
+	g.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if g.auditor != nil {
+			recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+			defer func() { g.auditor.AuditRequest(r, recorder.code) }()
+			w = recorder
+		}
+		if g.authenticator != nil && g.authorizer != nil {
+			if err := g.authorizer.Authorize(r.Context(), method); err != nil {
+				g.writeError(w, status.Errorf(codes.PermissionDenied, "%v", err))
+				return
+			}
+		}
+		handler(w, r)
+	})
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.handler.ServeHTTP(w, r)
+}
+
+// authenticate passes the credentials of a request to the authenticator
+// as gRPC metadata, and serves the request if they are accepted
+func (g *Gateway) authenticate(w http.ResponseWriter, r *http.Request) {
+	if g.authenticator == nil {
+		g.mux.ServeHTTP(w, r)
+		return
+	}
+
+	md := metadata.MD{}
+	if value := r.Header.Get("Authorization"); value != "" {
+		md.Set("authorization", value)
+	} else if token := r.URL.Query().Get("access_token"); token != "" {
+		md.Set("authorization", "Bearer "+token)
+	}
+	if value := r.Header.Get("X-Api-Key"); value != "" {
+		md.Set("x-api-key", value)
+	}
+
+	ctx, err := g.authenticator.Authenticate(metadata.NewIncomingContext(r.Context(), md))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		g.writeError(w, status.Errorf(codes.Unauthenticated, "%v", err))
+		return
+	}
+	g.mux.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// handleQueryTimeSeries serves GET /v1/timeseries. The page_size and
+// page_token parameters page the buckets, max_points caps their number
+// and include_checksum adds a checksum and the ingest watermark to the
+// metadata, as in the gRPC API.
+func (g *Gateway) handleQueryTimeSeries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req, err := timeSeriesRequest(query)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	if req.PageSize, err = int32Param(query, "page_size"); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	if req.MaxPoints, err = int32Param(query, "max_points"); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	if value := query.Get("include_checksum"); value != "" {
+		if req.IncludeChecksum, err = strconv.ParseBool(value); err != nil {
+			g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid include_checksum: %v", err))
+			return
+		}
+	}
+	req.PageToken = query.Get("page_token")
+
+	resp, err := g.client.QueryTimeSeries(r.Context(), req)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, req.End.AsTime())
+}
+
+// int32Param parses an optional integer query parameter, zero when absent.
+func int32Param(query url.Values, name string) (int32, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s: %v", name, err)
+	}
+	return int32(n), nil
+}
+
+// timeSeriesRequest builds a QueryTimeSeries request from query parameters.
+func timeSeriesRequest(query url.Values) (*pb.TimeSeriesRequest, error) {
+	start, err := parseTimestamp(query.Get("start"))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid start: %v", err)
+	}
+	end, err := parseTimestamp(query.Get("end"))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid end: %v", err)
+	}
+
+	req := &pb.TimeSeriesRequest{
+		Start:       start,
+		End:         end,
+		Window:      query.Get("window"),
+		Aggregation: query.Get("aggregation"),
+		Calendar:    query.Get("calendar"),
+		Series:      query.Get("series"),
+		Transform:   query.Get("transform"),
+		Selector:    query.Get("selector"),
+		GroupBy:     query["group_by"],
+	}
+	if value := query.Get("weather_normalized"); value != "" {
+		if req.WeatherNormalized, err = strconv.ParseBool(value); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid weather_normalized: %v", err)
+		}
+	}
+	return req, nil
+}
+
+// rejectGroupBy returns an error if query groups series by labels, which
+// only /v1/timeseries supports, so that other endpoints do not silently
+// answer for the series ungrouped
+func rejectGroupBy(query url.Values) error {
+	if _, ok := query["group_by"]; ok {
+		return status.Errorf(codes.InvalidArgument, "group_by is only supported by /v1/timeseries")
+	}
+	return nil
+}
+
+// handleGetLatest serves GET /v1/timeseries/latest.
+func (g *Gateway) handleGetLatest(w http.ResponseWriter, r *http.Request) {
+	var count int64
+	if value := r.URL.Query().Get("count"); value != "" {
+		var err error
+		count, err = strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid count: %v", err))
+			return
+		}
+	}
+
+	resp, err := g.client.GetLatest(r.Context(), &pb.LatestRequest{Count: int32(count)})
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, time.Time{})
+}
+
+// handleGetStatistics serves GET /v1/timeseries/statistics.
+func (g *Gateway) handleGetStatistics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start, err := parseTimestamp(query.Get("start"))
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid start: %v", err))
+		return
+	}
+	end, err := parseTimestamp(query.Get("end"))
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid end: %v", err))
+		return
+	}
+
+	resp, err := g.client.GetStatistics(r.Context(), &pb.StatisticsRequest{Start: start, End: end})
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, end.AsTime())
+}
+
+// handleGetSummaries serves GET /v1/timeseries/summaries. Summaries are
+// updated by ingests after the range, so they are always revalidated.
+func (g *Gateway) handleGetSummaries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start, err := parseTimestamp(query.Get("start"))
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid start: %v", err))
+		return
+	}
+	end, err := parseTimestamp(query.Get("end"))
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid end: %v", err))
+		return
+	}
+
+	resp, err := g.client.GetSummaries(r.Context(), &pb.SummariesRequest{
+		Start:  start,
+		End:    end,
+		Period: query.Get("period"),
+	})
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, time.Time{})
+}
+
+// handleCompareTimeSeries serves GET /v1/timeseries/compare. Each offset
+// parameter adds the range shifted back by it to the comparison.
+func (g *Gateway) handleCompareTimeSeries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := rejectGroupBy(query); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	base, err := timeSeriesRequest(query)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	resp, err := g.client.CompareTimeSeries(r.Context(), &pb.CompareRequest{
+		Start:             base.Start,
+		End:               base.End,
+		Window:            base.Window,
+		Aggregation:       base.Aggregation,
+		Calendar:          base.Calendar,
+		Series:            base.Series,
+		Transform:         base.Transform,
+		Selector:          base.Selector,
+		Offsets:           query["offset"],
+		WeatherNormalized: base.WeatherNormalized,
+	})
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, base.End.AsTime())
+}
+
+// handleTopK serves GET /v1/timeseries/topk. Setting bottom to true ranks
+// the lowest aggregates first.
+func (g *Gateway) handleTopK(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := rejectGroupBy(query); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	base, err := timeSeriesRequest(query)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	req := &pb.TopKRequest{
+		Start:       base.Start,
+		End:         base.End,
+		Window:      base.Window,
+		Aggregation: base.Aggregation,
+		Calendar:    base.Calendar,
+		Selector:    base.Selector,
+	}
+	if req.K, err = int32Param(query, "k"); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	if value := query.Get("bottom"); value != "" {
+		if req.Bottom, err = strconv.ParseBool(value); err != nil {
+			g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid bottom: %v", err))
+			return
+		}
+	}
+
+	resp, err := g.client.TopK(r.Context(), req)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, base.End.AsTime())
+}
+
+// handleListSeries serves GET /v1/series. Each tag parameter, a key and
+// value separated by a colon, narrows the list to the series carrying it,
+// as does a label selector in the selector parameter.
+func (g *Gateway) handleListSeries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := &pb.ListSeriesRequest{Kind: query.Get("kind"), Selector: query.Get("selector")}
+	for _, tag := range query["tag"] {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || key == "" {
+			g.writeError(w, status.Errorf(codes.InvalidArgument, "invalid tag %q, expected key:value", tag))
+			return
+		}
+		if req.Tags == nil {
+			req.Tags = make(map[string]string)
+		}
+		req.Tags[key] = value
+	}
+
+	resp, err := g.client.ListSeries(r.Context(), req)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, time.Time{})
+}
+
+// handleGetSeries serves GET /v1/series/{name}.
+func (g *Gateway) handleGetSeries(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.client.GetSeries(r.Context(), &pb.GetSeriesRequest{Name: r.PathValue("name")})
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeProto(w, r, resp, time.Time{})
+}
+
+// writeProto writes msg, the response over a range ending at end or zero
+// without one, as JSON with its caching headers, honoring If-None-Match
+// against the content-derived ETag.
+func (g *Gateway) writeProto(w http.ResponseWriter, r *http.Request, msg proto.Message, end time.Time) {
+	etag, err := computeETag(msg)
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.Internal, "failed to compute etag: %v", err))
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	g.setCacheHeaders(w.Header(), end)
+	setStaleHeaders(w.Header(), msg)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, err := protojson.Marshal(msg)
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.Internal, "failed to encode response: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		g.logger.WithError(err).Debug("Failed to write gateway response")
+	}
+}
+
+// errorBody is the JSON shape of gateway error responses.
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError converts a gRPC error into an HTTP error response.
+func (g *Gateway) writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	httpStatus := httpStatusFromCode(st.Code())
+
+	if httpStatus >= http.StatusInternalServerError {
+		g.logger.WithError(err).Error("Gateway request failed")
+	}
+	switch st.Code() {
+	case codes.ResourceExhausted, codes.Unavailable:
+		setRateLimitHeaders(w.Header(), st)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(errorBody{
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	})
+}
+
+// setRateLimitHeaders sets the X-RateLimit-* and Retry-After headers from
+// the details of a rate limited or temporarily unavailable call's status
+func setRateLimitHeaders(header http.Header, st *status.Status) {
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if d.Reason != middleware.RateLimitReason || d.Domain != middleware.RateLimitDomain {
+				continue
+			}
+			for name, key := range map[string]string{
+				"X-RateLimit-Limit":     "limit",
+				"X-RateLimit-Remaining": "remaining",
+				"X-RateLimit-Reset":     "reset",
+			} {
+				if value, ok := d.Metadata[key]; ok {
+					header.Set(name, value)
+				}
+			}
+		case *errdetails.RetryInfo:
+			seconds := math.Ceil(d.RetryDelay.AsDuration().Seconds())
+			header.Set("Retry-After", strconv.FormatFloat(seconds, 'f', 0, 64))
+		}
+	}
+}
+
+// httpStatusFromCode maps gRPC status codes to HTTP status codes.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseTimestamp parses an RFC 3339 timestamp query parameter.
+func parseTimestamp(value string) (*timestamppb.Timestamp, error) {
+	if value == "" {
+		return nil, fmt.Errorf("missing timestamp")
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return timestamppb.New(t), nil
+}
@@ -0,0 +1,1223 @@
+package gateway
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/auth"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/cors"
+	dbmocks "github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	middleware "github.com/tejusbharadwaj/edgecom/internal/grpc/middlewares"
+	"github.com/tejusbharadwaj/edgecom/internal/grpc/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+const queryURL = "/v1/timeseries?start=2024-11-23T00:00:00Z&end=2024-11-24T00:00:00Z&window=1h&aggregation=AVG"
+
+func newTestResponse() *pb.TimeSeriesResponse {
+	now := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	return &pb.TimeSeriesResponse{
+		Data: []*pb.TimeSeriesDataPoint{
+			{Time: timestamppb.New(now), Value: 100.0},
+			{Time: timestamppb.New(now.Add(time.Hour)), Value: 200.0},
+		},
+	}
+}
+
+func TestQueryTimeSeries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	client.EXPECT().
+		QueryTimeSeries(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+			assert.Equal(t, "1h", req.Window)
+			assert.Equal(t, "AVG", req.Aggregation)
+			assert.Equal(t, time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC), req.Start.AsTime())
+			return newTestResponse(), nil
+		})
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL, nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.NotEmpty(t, rec.Header().Get("ETag"))
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Len(t, body["data"], 2)
+
+	t.Run("page parameters are forwarded", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				assert.Equal(t, int32(100), req.PageSize)
+				assert.Equal(t, "abc", req.PageToken)
+				assert.Zero(t, req.MaxPoints)
+				return &pb.TimeSeriesResponse{Data: newTestResponse().Data, NextPageToken: "def"}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&page_size=100&page_token=abc", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"nextPageToken":"def"`)
+	})
+
+	t.Run("series is forwarded", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				assert.Equal(t, "net_load", req.Series)
+				return newTestResponse(), nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&series=net_load", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("selector and groups are forwarded", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				assert.Equal(t, `{feeder=~"f.*"}`, req.Selector)
+				assert.Equal(t, []string{"site", "phase"}, req.GroupBy)
+				return &pb.TimeSeriesResponse{Groups: []*pb.SeriesGroup{{
+					Labels: map[string]string{"site": "north", "phase": "A"},
+					Series: []string{"f1", "f2"},
+					Data:   newTestResponse().Data,
+				}}}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&selector="+url.QueryEscape(`{feeder=~"f.*"}`)+"&group_by=site&group_by=phase", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"groups"`)
+	})
+
+	t.Run("max points are forwarded", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				assert.Equal(t, int32(500), req.MaxPoints)
+				return &pb.TimeSeriesResponse{Data: newTestResponse().Data, Window: "5m"}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&max_points=500", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"window":"5m"`)
+	})
+
+	t.Run("checksum is requested", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				assert.True(t, req.IncludeChecksum)
+				return &pb.TimeSeriesResponse{
+					Data:     newTestResponse().Data,
+					Metadata: &pb.QueryMetadata{Checksum: "abc123"},
+				}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&include_checksum=true", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"checksum":"abc123"`)
+	})
+
+	t.Run("invalid include_checksum", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&include_checksum=maybe", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid page size", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&page_size=abc", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid page_size")
+	})
+}
+
+func TestQueryTimeSeriesWeatherNormalized(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	client.EXPECT().
+		QueryTimeSeries(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+			assert.True(t, req.WeatherNormalized)
+			resp := newTestResponse()
+			resp.WeatherModel = &pb.WeatherModel{BalancePoint: 18, NormalYears: 10}
+			return resp, nil
+		})
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL+"&weather_normalized=true", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "weatherModel")
+}
+
+func TestGetLatest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	t.Run("count is forwarded", func(t *testing.T) {
+		client.EXPECT().
+			GetLatest(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.LatestRequest, _ ...grpc.CallOption) (*pb.LatestResponse, error) {
+				assert.Equal(t, int32(5), req.Count)
+				return &pb.LatestResponse{Data: newTestResponse().Data}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/latest?count=5", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("ETag"))
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Len(t, body["data"], 2)
+	})
+
+	t.Run("invalid count", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/latest?count=abc", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid count")
+	})
+}
+
+func TestGetStatistics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	t.Run("range is forwarded", func(t *testing.T) {
+		client.EXPECT().
+			GetStatistics(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.StatisticsRequest, _ ...grpc.CallOption) (*pb.StatisticsResponse, error) {
+				assert.Equal(t, "2024-11-23T00:00:00Z", req.Start.AsTime().Format(time.RFC3339))
+				assert.Equal(t, "2024-11-24T00:00:00Z", req.End.AsTime().Format(time.RFC3339))
+				return &pb.StatisticsResponse{Count: 4, Max: 6}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/statistics?start=2024-11-23T00:00:00Z&end=2024-11-24T00:00:00Z", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, "4", body["count"])
+		assert.Equal(t, 6.0, body["max"])
+	})
+
+	t.Run("invalid start", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/statistics?start=yesterday&end=2024-11-24T00:00:00Z", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid start")
+	})
+}
+
+func TestGetSummaries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	t.Run("range and period are forwarded", func(t *testing.T) {
+		client.EXPECT().
+			GetSummaries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.SummariesRequest, _ ...grpc.CallOption) (*pb.SummariesResponse, error) {
+				assert.Equal(t, "2024-11-01T00:00:00Z", req.Start.AsTime().Format(time.RFC3339))
+				assert.Equal(t, "2024-12-01T00:00:00Z", req.End.AsTime().Format(time.RFC3339))
+				assert.Equal(t, "month", req.Period)
+				return &pb.SummariesResponse{Summaries: []*pb.ConsumptionSummary{
+					{Start: req.Start, TotalKwh: 720, PeakKw: 2, LoadFactor: 0.5},
+				}}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/summaries?start=2024-11-01T00:00:00Z&end=2024-12-01T00:00:00Z&period=month", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+		var body struct {
+			Summaries []map[string]interface{} `json:"summaries"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Len(t, body.Summaries, 1)
+		assert.Equal(t, 720.0, body.Summaries[0]["totalKwh"])
+		assert.Equal(t, 0.5, body.Summaries[0]["loadFactor"])
+	})
+
+	t.Run("invalid end", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/summaries?start=2024-11-01T00:00:00Z&end=tomorrow&period=day", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid end")
+	})
+}
+
+func TestCompareTimeSeries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	t.Run("offsets are forwarded", func(t *testing.T) {
+		client.EXPECT().
+			CompareTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.CompareRequest, _ ...grpc.CallOption) (*pb.CompareResponse, error) {
+				assert.Equal(t, "2024-11-18T00:00:00Z", req.Start.AsTime().Format(time.RFC3339))
+				assert.Equal(t, "1d", req.Window)
+				assert.Equal(t, "SUM", req.Aggregation)
+				assert.Equal(t, []string{"-7d", "-52w"}, req.Offsets)
+				return &pb.CompareResponse{Series: []*pb.ComparedSeries{
+					{Label: "current", Data: []*pb.TimeSeriesDataPoint{{Time: req.Start, Value: 120}}},
+					{Label: "-7d", Data: []*pb.TimeSeriesDataPoint{{Time: req.Start, Value: 100}}},
+					{Label: "-52w"},
+				}}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/compare?start=2024-11-18T00:00:00Z&end=2024-11-25T00:00:00Z&window=1d&aggregation=SUM&offset=-7d&offset=-52w", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Series []struct {
+				Label string                   `json:"label"`
+				Data  []map[string]interface{} `json:"data"`
+			} `json:"series"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Len(t, body.Series, 3)
+		assert.Equal(t, "-7d", body.Series[1].Label)
+		assert.Equal(t, 100.0, body.Series[1].Data[0]["value"])
+	})
+
+	t.Run("weather normalized", func(t *testing.T) {
+		client.EXPECT().
+			CompareTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.CompareRequest, _ ...grpc.CallOption) (*pb.CompareResponse, error) {
+				assert.True(t, req.WeatherNormalized)
+				return &pb.CompareResponse{}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/compare?start=2024-11-18T00:00:00Z&end=2024-11-25T00:00:00Z&window=1d&aggregation=SUM&offset=-7d&weather_normalized=true", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("invalid start", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/compare?start=yesterday&end=2024-11-25T00:00:00Z&window=1d&aggregation=SUM&offset=-7d", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid start")
+	})
+
+	t.Run("group_by", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/compare?start=2024-11-18T00:00:00Z&end=2024-11-25T00:00:00Z&window=1d&aggregation=SUM&offset=-7d&group_by=site", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "group_by is only supported")
+	})
+}
+
+func TestTopK(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	t.Run("ranking is forwarded", func(t *testing.T) {
+		client.EXPECT().
+			TopK(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TopKRequest, _ ...grpc.CallOption) (*pb.TopKResponse, error) {
+				assert.Equal(t, "1h", req.Window)
+				assert.Equal(t, "MAX", req.Aggregation)
+				assert.Equal(t, `{kind="feeder"}`, req.Selector)
+				assert.Equal(t, int32(10), req.K)
+				assert.True(t, req.Bottom)
+				return &pb.TopKResponse{Series: []*pb.RankedSeries{{Series: "f1", Value: 42}}, TotalSeries: 3}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/topk?start=2024-11-18T00:00:00Z&end=2024-11-25T00:00:00Z&window=1h&aggregation=MAX&k=10&bottom=true&selector="+url.QueryEscape(`{kind="feeder"}`), nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Series []struct {
+				Series string  `json:"series"`
+				Value  float64 `json:"value"`
+			} `json:"series"`
+			TotalSeries int `json:"totalSeries"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Len(t, body.Series, 1)
+		assert.Equal(t, "f1", body.Series[0].Series)
+		assert.Equal(t, 42.0, body.Series[0].Value)
+		assert.Equal(t, 3, body.TotalSeries)
+	})
+
+	t.Run("invalid k", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/timeseries/topk?start=2024-11-18T00:00:00Z&end=2024-11-25T00:00:00Z&window=1h&aggregation=MAX&k=ten", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid k")
+	})
+}
+
+func TestSeries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	t.Run("kind, tags and selector are forwarded", func(t *testing.T) {
+		client.EXPECT().
+			ListSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.ListSeriesRequest, _ ...grpc.CallOption) (*pb.ListSeriesResponse, error) {
+				assert.Equal(t, "derived", req.Kind)
+				assert.Equal(t, map[string]string{"site": "north", "phase": "l1:a"}, req.Tags)
+				assert.Equal(t, `{feeder=~"f1|f2"}`, req.Selector)
+				return &pb.ListSeriesResponse{Series: []*pb.Series{{Name: "net", Kind: "derived", Unit: "kWh"}}}, nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/series?kind=derived&tag=site:north&tag=phase:l1:a&selector="+url.QueryEscape(`{feeder=~"f1|f2"}`), nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+		var body struct {
+			Series []map[string]interface{} `json:"series"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Len(t, body.Series, 1)
+		assert.Equal(t, "kWh", body.Series[0]["unit"])
+	})
+
+	t.Run("invalid tag", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/series?tag=north", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid tag")
+	})
+
+	t.Run("series by name", func(t *testing.T) {
+		client.EXPECT().
+			GetSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.GetSeriesRequest, _ ...grpc.CallOption) (*pb.Series, error) {
+				assert.Equal(t, "net", req.Name)
+				return nil, status.Error(codes.NotFound, `unknown series "net"`)
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/series/net", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestQueryTimeSeriesETag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	client.EXPECT().
+		QueryTimeSeries(gomock.Any(), gomock.Any()).
+		Return(newTestResponse(), nil).
+		Times(3)
+
+	// First request establishes the ETag
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// Matching If-None-Match returns 304 without a body
+	req := httptest.NewRequest(http.MethodGet, queryURL, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Body.Bytes())
+
+	// A stale ETag returns the full response
+	req = httptest.NewRequest(http.MethodGet, queryURL, nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Body.Bytes())
+}
+
+func TestCacheHeaders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	client.EXPECT().QueryTimeSeries(gomock.Any(), gomock.Any()).Return(newTestResponse(), nil).AnyTimes()
+	client.EXPECT().GetLatest(gomock.Any(), gomock.Any()).Return(&pb.LatestResponse{}, nil).AnyTimes()
+
+	// The query range ends at 2024-11-24T00:00:00Z
+	clk := clock.NewFake(time.Date(2024, 11, 24, 0, 30, 0, 0, time.UTC))
+	gw := New(client, nil, nil, nil, logrus.New())
+	gw.SetCachePolicy(CachePolicy{MaxAge: 10 * time.Minute, Horizon: time.Hour, Clock: clk})
+
+	get := func(url string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("recent ranges are revalidated", func(t *testing.T) {
+		rec := get(queryURL)
+		assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+		assert.Empty(t, rec.Header().Get("Expires"))
+	})
+
+	t.Run("historical ranges are reused", func(t *testing.T) {
+		clk.Set(time.Date(2024, 11, 24, 1, 0, 0, 0, time.UTC))
+		rec := get(queryURL)
+		assert.Equal(t, "public, max-age=600", rec.Header().Get("Cache-Control"))
+		expires, err := http.ParseTime(rec.Header().Get("Expires"))
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(10*time.Minute), expires, 2*time.Second)
+
+		rec = get(queryURL, "If-None-Match", rec.Header().Get("ETag"))
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Equal(t, "public, max-age=600", rec.Header().Get("Cache-Control"), "revalidations refresh the freshness")
+	})
+
+	t.Run("latest is revalidated", func(t *testing.T) {
+		assert.Equal(t, "no-cache", get("/v1/timeseries/latest").Header().Get("Cache-Control"))
+	})
+
+	t.Run("errors are not stored", func(t *testing.T) {
+		rec := get("/v1/timeseries?start=invalid")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	})
+
+	t.Run("authenticated responses are private", func(t *testing.T) {
+		keys, err := auth.NewStaticKeys([]auth.StaticKey{{Key: "s3cret", Subject: "dashboard"}})
+		require.NoError(t, err)
+		gw.SetAuthenticator(auth.NewAuthenticator(keys))
+		rec := get(queryURL, "X-Api-Key", "s3cret")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "private, max-age=600", rec.Header().Get("Cache-Control"))
+	})
+}
+
+func TestStaleHeaders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stale := newTestResponse()
+	stale.Metadata = &pb.QueryMetadata{Stale: true, StaleAge: durationpb.New(90 * time.Second)}
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	client.EXPECT().QueryTimeSeries(gomock.Any(), gomock.Any()).Return(stale, nil)
+
+	// A historical range, otherwise reused for max-age
+	clk := clock.NewFake(time.Date(2024, 11, 25, 0, 0, 0, 0, time.UTC))
+	gw := New(client, nil, nil, nil, logrus.New())
+	gw.SetCachePolicy(CachePolicy{MaxAge: 10 * time.Minute, Horizon: time.Hour, Clock: clk})
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+	assert.Empty(t, rec.Header().Get("Expires"))
+	assert.Equal(t, `110 - "Response is Stale"`, rec.Header().Get("Warning"))
+	assert.Equal(t, "true", rec.Header().Get(HeaderStale))
+	assert.Equal(t, "90", rec.Header().Get(HeaderStaleAge))
+	assert.Contains(t, rec.Body.String(), `"stale":true`)
+}
+
+func TestExport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	exportURL := "/v1/timeseries/export?start=2024-11-23T00:00:00Z&end=2024-11-24T00:00:00Z&window=1h&aggregation=AVG"
+
+	t.Run("xlsx", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				assert.Equal(t, "AVG", req.Aggregation)
+				return newTestResponse(), nil
+			})
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportURL+"&timezone=Europe/Berlin", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="edgecom-20241123T0000-20241124T0000.xlsx"`, rec.Header().Get("Content-Disposition"))
+
+		archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		require.NoError(t, err)
+		var names []string
+		for _, file := range archive.File {
+			names = append(names, file.Name)
+		}
+		assert.Contains(t, names, "xl/worksheets/sheet1.xml")
+	})
+
+	t.Run("streamed csv", func(t *testing.T) {
+		stream := mocks.NewMockTimeSeriesService_ExportTimeSeriesClient(ctrl)
+		client.EXPECT().
+			ExportTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.ExportRequest, _ ...grpc.CallOption) (pb.TimeSeriesService_ExportTimeSeriesClient, error) {
+				assert.Equal(t, "csv", req.Format)
+				assert.True(t, req.Gzip)
+				assert.Equal(t, "1h", req.Window)
+				return stream, nil
+			})
+		gomock.InOrder(
+			stream.EXPECT().Recv().Return(&pb.ExportChunk{ContentType: "application/gzip", Filename: "export.csv.gz"}, nil),
+			stream.EXPECT().Recv().Return(&pb.ExportChunk{Data: []byte("first,")}, nil),
+			stream.EXPECT().Recv().Return(&pb.ExportChunk{Data: []byte("second")}, nil),
+			stream.EXPECT().Recv().Return(nil, io.EOF),
+		)
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportURL+"&format=csv&gzip=true", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/gzip", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="export.csv.gz"`, rec.Header().Get("Content-Disposition"))
+		assert.Equal(t, "first,second", rec.Body.String())
+	})
+
+	t.Run("streamed export failing", func(t *testing.T) {
+		stream := mocks.NewMockTimeSeriesService_ExportTimeSeriesClient(ctrl)
+		client.EXPECT().ExportTimeSeries(gomock.Any(), gomock.Any()).Return(stream, nil)
+		gomock.InOrder(
+			stream.EXPECT().Recv().Return(&pb.ExportChunk{ContentType: "text/csv", Filename: "export.csv", Data: []byte("first,")}, nil),
+			stream.EXPECT().Recv().Return(nil, status.Error(codes.Unavailable, "connection reset")),
+		)
+
+		// The response is aborted rather than ended as if complete
+		rec := httptest.NewRecorder()
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportURL+"&format=csv", nil))
+		})
+	})
+
+	t.Run("group_by", func(t *testing.T) {
+		for _, format := range []string{"xlsx", "csv"} {
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportURL+"&group_by=site&format="+format, nil))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, format)
+		}
+	})
+
+	t.Run("streamed export rejected", func(t *testing.T) {
+		stream := mocks.NewMockTimeSeriesService_ExportTimeSeriesClient(ctrl)
+		client.EXPECT().ExportTimeSeries(gomock.Any(), gomock.Any()).Return(stream, nil)
+		stream.EXPECT().Recv().Return(nil, status.Error(codes.InvalidArgument, "invalid window: 2h"))
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportURL+"&format=parquet", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("pivoted csv", func(t *testing.T) {
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				assert.Equal(t, "1h", req.Window)
+				resp := newTestResponse()
+				if req.Aggregation == "MAX" {
+					resp.Data = resp.Data[1:]
+				}
+				return resp, nil
+			}).
+			Times(2)
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportURL+"&aggregation=MAX&format=csv", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="edgecom-20241123T0000-20241124T0000-pivot.csv"`, rec.Header().Get("Content-Disposition"))
+		assert.Equal(t, "time,AVG,MAX\n"+
+			"2024-11-23T00:00:00Z,100,\n"+
+			"2024-11-23T01:00:00Z,200,200\n", rec.Body.String())
+	})
+
+	t.Run("pivoted xlsx by series", func(t *testing.T) {
+		var series []string
+		client.EXPECT().
+			QueryTimeSeries(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+				series = append(series, req.Series)
+				return newTestResponse(), nil
+			}).
+			Times(2)
+
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportURL+"&series=north&series=south", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", rec.Header().Get("Content-Type"))
+		assert.Equal(t, []string{"north", "south"}, series)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, url := range []string{
+			exportURL + "&aggregation=MAX&format=parquet",
+			exportURL + "&aggregation=AVG",
+			exportURL + "&series=a&series=b&series=c&series=d&series=e&series=f&aggregation=MAX&aggregation=MIN&aggregation=SUM",
+			exportURL + "&format=pdf",
+			exportURL + "&format=csv&gzip=maybe",
+			exportURL + "&format=csv&transform=DELTA",
+			exportURL + "&format=parquet&series=net_load",
+			exportURL + "&timezone=Mars/Olympus",
+			"/v1/timeseries/export?start=yesterday",
+		} {
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, url)
+		}
+	})
+}
+
+func TestQueryTimeSeriesErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	tests := []struct {
+		name       string
+		url        string
+		setupMock  func()
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "Invalid start",
+			url:        "/v1/timeseries?start=yesterday&end=2024-11-24T00:00:00Z&window=1h&aggregation=AVG",
+			setupMock:  func() {},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "InvalidArgument",
+		},
+		{
+			name:       "Invalid weather_normalized",
+			url:        queryURL + "&weather_normalized=maybe",
+			setupMock:  func() {},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "InvalidArgument",
+		},
+		{
+			name: "Validation error",
+			url:  queryURL,
+			setupMock: func() {
+				client.EXPECT().
+					QueryTimeSeries(gomock.Any(), gomock.Any()).
+					Return(nil, status.Error(codes.InvalidArgument, "invalid window: 2h"))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "InvalidArgument",
+		},
+		{
+			name: "Rate limited",
+			url:  queryURL,
+			setupMock: func() {
+				client.EXPECT().
+					QueryTimeSeries(gomock.Any(), gomock.Any()).
+					Return(nil, status.Error(codes.ResourceExhausted, "rate limit exceeded"))
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   "ResourceExhausted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Empty(t, rec.Header().Get("ETag"))
+
+			var body errorBody
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tt.wantCode, body.Code)
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// A rejection from the service's own rate limiter
+	interceptor := middleware.NewRateLimiter(0.5, 1).InterceptorFunc()
+	info := &grpc.UnaryServerInfo{FullMethod: pb.TimeSeriesService_QueryTimeSeries_FullMethodName}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	_, err := interceptor(context.Background(), nil, info, handler)
+	require.NoError(t, err)
+	_, rejected := interceptor(context.Background(), nil, info, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(rejected))
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	client.EXPECT().QueryTimeSeries(gomock.Any(), gomock.Any()).Return(nil, rejected)
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL, nil))
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+}
+
+func TestUnavailableRetryAfter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	st, err := status.New(codes.Unavailable, "query failed: connection refused").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Second)})
+	require.NoError(t, err)
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	client.EXPECT().QueryTimeSeries(gomock.Any(), gomock.Any()).Return(nil, st.Err())
+	gw := New(client, nil, nil, nil, logrus.New())
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryURL, nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+}
+
+func TestAuthentication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	keys, err := auth.NewStaticKeys([]auth.StaticKey{{Key: "s3cret", Subject: "dashboard"}})
+	require.NoError(t, err)
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	policy := cors.New(cors.Config{AllowedOrigins: []string{"https://dashboard.example.com"}})
+	gw := New(client, nil, nil, policy, logrus.New())
+	gw.SetAuthenticator(auth.NewAuthenticator(keys))
+
+	client.EXPECT().
+		QueryTimeSeries(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ *pb.TimeSeriesRequest, _ ...grpc.CallOption) (*pb.TimeSeriesResponse, error) {
+			identity, ok := auth.FromContext(ctx)
+			require.True(t, ok)
+			assert.Equal(t, "dashboard", identity.Subject)
+			return newTestResponse(), nil
+		}).
+		Times(3)
+
+	tests := []struct {
+		name   string
+		url    string
+		header map[string]string
+		want   int
+	}{
+		{"bearer token", queryURL, map[string]string{"Authorization": "Bearer s3cret"}, http.StatusOK},
+		{"api key header", queryURL, map[string]string{"X-Api-Key": "s3cret"}, http.StatusOK},
+		{"access token parameter", queryURL + "&access_token=s3cret", nil, http.StatusOK},
+		{"wrong key", queryURL, map[string]string{"X-Api-Key": "guess"}, http.StatusUnauthorized},
+		{"no credentials", queryURL, nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+			if tt.want == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	t.Run("preflight requests need no credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, queryURL, nil)
+		req.Header.Set("Origin", "https://dashboard.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		assert.Less(t, rec.Code, 300)
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	keys, err := auth.NewStaticKeys([]auth.StaticKey{
+		{Key: "reader", Subject: "dashboard", Roles: []string{"read"}},
+		{Key: "other", Subject: "billing"},
+	})
+	require.NoError(t, err)
+	policy, err := auth.NewPolicy(auth.PolicyConfig{
+		Methods: map[string][]string{"QueryTimeSeries": {"read"}},
+		Default: []string{},
+	})
+	require.NoError(t, err)
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	gw := New(client, nil, nil, nil, logrus.New())
+	gw.SetAuthenticator(auth.NewAuthenticator(keys))
+	gw.SetAuthorizer(policy)
+
+	client.EXPECT().
+		QueryTimeSeries(gomock.Any(), gomock.Any()).
+		Return(newTestResponse(), nil).
+		Times(1)
+
+	tests := []struct {
+		name string
+		url  string
+		key  string
+		want int
+	}{
+		{"allowed", queryURL, "reader", http.StatusOK},
+		{"missing role", queryURL, "other", http.StatusForbidden},
+		{"endpoint of another RPC", "/v1/timeseries/latest", "reader", http.StatusForbidden},
+		{"no credentials", queryURL, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.key != "" {
+				req.Header.Set("X-Api-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+// recordingAuditor remembers the requests it records
+type recordingAuditor struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (a *recordingAuditor) AuditRequest(r *http.Request, code int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests = append(a.requests, fmt.Sprintf("%s %d", r.URL.Path, code))
+}
+
+func (a *recordingAuditor) recorded() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.requests...)
+}
+
+func TestAudit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mocks.NewMockTimeSeriesServiceClient(ctrl)
+	broker := stream.NewBroker()
+	gw := New(client, broker, nil, nil, logrus.New())
+	auditor := &recordingAuditor{}
+	gw.SetAuditor(auditor)
+
+	client.EXPECT().QueryTimeSeries(gomock.Any(), gomock.Any()).Return(newTestResponse(), nil)
+	client.EXPECT().GetLatest(gomock.Any(), gomock.Any()).Return(nil, status.Error(codes.Unavailable, "database down"))
+
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	for _, path := range []string{queryURL, "/v1/timeseries/latest"} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/timeseries/live", nil)
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.Eventually(t, func() bool { return len(auditor.recorded()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{
+		"/v1/timeseries 200",
+		"/v1/timeseries/latest 503",
+		"/v1/timeseries/live 101",
+	}, auditor.recorded())
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := `"abc123"`
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"empty header", "", false},
+		{"exact match", `"abc123"`, true},
+		{"weak match", `W/"abc123"`, true},
+		{"wildcard", "*", true},
+		{"list match", `"other", "abc123"`, true},
+		{"no match", `"other"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, etagMatches(tt.header, etag))
+		})
+	}
+}
+
+func TestLiveWebSocket(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repo := dbmocks.NewMockTimeSeriesRepository(ctrl)
+	broker := stream.NewBroker()
+	gw := New(mocks.NewMockTimeSeriesServiceClient(ctrl), broker, repo, nil, logrus.New())
+
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/timeseries/live"
+
+	base := time.Date(2024, 11, 23, 10, 0, 0, 0, time.UTC)
+	batch := []models.TimeSeriesData{{Time: base.Add(5 * time.Minute), Value: 42.0}}
+
+	readUpdate := func(t *testing.T, url string) *pb.TimeSeriesResponse {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.Eventually(t, func() bool { return broker.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+		broker.Publish(batch)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+
+		var resp pb.TimeSeriesResponse
+		require.NoError(t, protojson.Unmarshal(msg, &resp))
+		return &resp
+	}
+
+	t.Run("raw points", func(t *testing.T) {
+		resp := readUpdate(t, wsURL)
+		require.Len(t, resp.Data, 1)
+		assert.Equal(t, 42.0, resp.Data[0].Value)
+	})
+
+	t.Run("aggregated buckets", func(t *testing.T) {
+		repo.EXPECT().
+			Query(gomock.Any(), base, gomock.Any(), "1h", "AVG").
+			Return([]models.TimeSeriesData{{Time: base, Value: 21.0}}, nil)
+
+		resp := readUpdate(t, wsURL+"?window=1h&aggregation=AVG")
+		require.Len(t, resp.Data, 1)
+		assert.Equal(t, 21.0, resp.Data[0].Value)
+		assert.Equal(t, base, resp.Data[0].Time.AsTime())
+	})
+
+	t.Run("invalid aggregation", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?window=2h&aggregation=AVG", nil)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("invalid flow control", func(t *testing.T) {
+		for _, query := range []string{"?overflow=block", "?buffer_size=many", "?buffer_size=100000"} {
+			_, resp, err := websocket.DefaultDialer.Dial(wsURL+query, nil)
+			require.Error(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+		assert.Zero(t, broker.Subscribers())
+	})
+}
+
+func TestLiveWebSocketOrigin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	policy := cors.New(cors.Config{AllowedOrigins: []string{"https://dashboard.example.com"}})
+	gw := New(mocks.NewMockTimeSeriesServiceClient(ctrl), stream.NewBroker(), nil, policy, logrus.New())
+
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/timeseries/live"
+
+	t.Run("allowed origin", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://dashboard.example.com"}})
+		require.NoError(t, err)
+		conn.Close()
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.example.com"}})
+		require.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestEventStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repo := dbmocks.NewMockTimeSeriesRepository(ctrl)
+	broker := stream.NewBroker()
+	gw := New(mocks.NewMockTimeSeriesServiceClient(ctrl), broker, repo, nil, logrus.New())
+
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	base := time.Date(2024, 11, 23, 10, 0, 0, 0, time.UTC)
+	url := srv.URL + "/v1/timeseries/events?start=2024-11-23T00:00:00Z&end=2024-11-24T00:00:00Z&window=1h&aggregation=AVG"
+
+	t.Run("snapshot and updates", func(t *testing.T) {
+		repo.EXPECT().
+			Query(gomock.Any(), base.Add(-10*time.Hour), base.Add(14*time.Hour), "1h", "AVG").
+			Return([]models.TimeSeriesData{{Time: base, Value: 1.0}}, nil)
+		repo.EXPECT().
+			Query(gomock.Any(), base, gomock.Any(), "1h", "AVG").
+			Return([]models.TimeSeriesData{{Time: base, Value: 2.0}}, nil)
+
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		events := readEvents(resp.Body)
+
+		snapshot := <-events
+		assert.Equal(t, "snapshot", snapshot.name)
+		assert.Equal(t, 1.0, snapshot.data.Data[0].Value)
+
+		// Points outside the subscribed range are ignored
+		broker.Publish([]models.TimeSeriesData{{Time: base.Add(48 * time.Hour), Value: 9.0}})
+		broker.Publish([]models.TimeSeriesData{{Time: base.Add(5 * time.Minute), Value: 3.0}})
+
+		update := <-events
+		assert.Equal(t, "update", update.name)
+		assert.Equal(t, 2.0, update.data.Data[0].Value)
+	})
+
+	t.Run("invalid window", func(t *testing.T) {
+		resp, err := http.Get(strings.Replace(url, "window=1h", "window=2h", 1))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+type sseEvent struct {
+	name string
+	data *pb.TimeSeriesResponse
+}
+
+// readEvents parses "event" and "data" fields from an SSE stream.
+func readEvents(body io.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(body)
+		var current sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				current.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				current.data = &pb.TimeSeriesResponse{}
+				protojson.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), current.data)
+			case line == "" && current.name != "":
+				events <- current
+				current = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+const (
+	// writeWait is the time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed to read the next pong from the peer
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// maxClientMessageSize bounds messages read from the peer, which are
+	// otherwise ignored
+	maxClientMessageSize = 512
+)
+
+// handleLive serves GET /v1/timeseries/live.
+//
+// The connection is upgraded to a WebSocket that pushes newly ingested
+// points as TimeSeriesResponse JSON messages. When window and aggregation
+// query parameters are given, each message instead carries the recomputed
+// buckets touched by the latest batch. The overflow and buffer_size
+// parameters choose how a client that falls behind is handled; one
+// disconnected for it gets a close frame with status 1013 (try again
+// later).
+func (g *Gateway) handleLive(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	window := query.Get("window")
+	aggregation := query.Get("aggregation")
+
+	if window != "" || aggregation != "" {
+		if err := g.validator.ValidateAggregation(window, aggregation); err != nil {
+			g.writeError(w, status.Errorf(codes.InvalidArgument, "%s", err.Error()))
+			return
+		}
+	}
+
+	sub, err := g.subscribe(r.URL.Query())
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		g.logger.WithError(err).Debug("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	g.logger.WithFields(logrus.Fields{
+		"remote":      r.RemoteAddr,
+		"window":      window,
+		"aggregation": aggregation,
+	}).Debug("Live subscriber connected")
+
+	// The read loop handles control frames and detects disconnects
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(maxClientMessageSize)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case batch, ok := <-sub.C:
+			if !ok {
+				if errors.Is(sub.Err(), stream.ErrSlowConsumer) {
+					message := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "disconnected for falling behind")
+					conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+				}
+				return
+			}
+
+			points, err := g.liveUpdate(r.Context(), batch, window, aggregation)
+			if err != nil {
+				g.logger.WithError(err).Error("Failed to aggregate live update")
+				continue
+			}
+
+			body, err := protojson.Marshal(toProtoResponse(points))
+			if err != nil {
+				g.logger.WithError(err).Error("Failed to encode live update")
+				continue
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, body); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// subscribe subscribes to the broker with the optional overflow and
+// buffer_size parameters of a live endpoint
+func (g *Gateway) subscribe(query url.Values) (*stream.Subscription, error) {
+	var bufferSize int64
+	if value := query.Get("buffer_size"); value != "" {
+		var err error
+		if bufferSize, err = strconv.ParseInt(value, 10, 32); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid buffer_size: %v", err)
+		}
+	}
+	sub, err := g.broker.SubscribeWith(stream.SubscribeOptions{
+		BufferSize: int(bufferSize),
+		Overflow:   stream.OverflowPolicy(query.Get("overflow")),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return sub, nil
+}
+
+// liveUpdate returns the points to push for a newly ingested batch: the
+// batch itself, or the recomputed buckets it touched when a window is set.
+func (g *Gateway) liveUpdate(
+	ctx context.Context,
+	batch []models.TimeSeriesData,
+	window string,
+	aggregation string,
+) ([]models.TimeSeriesData, error) {
+	if window == "" || len(batch) == 0 {
+		return batch, nil
+	}
+	return stream.BucketUpdates(ctx, g.querier, batch, window, aggregation)
+}
+
+// toProtoResponse converts data points into a TimeSeriesResponse.
+func toProtoResponse(points []models.TimeSeriesData) *pb.TimeSeriesResponse {
+	data := make([]*pb.TimeSeriesDataPoint, 0, len(points))
+	for _, p := range points {
+		data = append(data, &pb.TimeSeriesDataPoint{
+			Time:  timestamppb.New(p.Time),
+			Value: p.Value,
+		})
+	}
+	return &pb.TimeSeriesResponse{Data: data}
+}
@@ -0,0 +1,733 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	middleware "github.com/tejusbharadwaj/edgecom/internal/grpc/middlewares"
+	"github.com/tejusbharadwaj/edgecom/internal/ingest"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/scheduler"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// backfillChunk bounds the range fetched at a time by Backfill
+const backfillChunk = 24 * time.Hour
+
+// SchedulerControl pauses and resumes the periodic collection.
+type SchedulerControl interface {
+	Pause()
+	Resume()
+	Status() scheduler.Status
+}
+
+// Purger empties a cache, returning the number of entries removed.
+type Purger interface {
+	Purge() int
+}
+
+// ConfigReloader applies a changed configuration file to the running
+// service.
+type ConfigReloader interface {
+	// Reload reads and validates the configuration, applies the changed
+	// sections it can, and returns those it applied and those that only
+	// take effect on restart. Nothing is applied when the configuration
+	// is invalid.
+	Reload(ctx context.Context) (applied, restartRequired []string, err error)
+}
+
+// AdminService implements the gRPC service for operational actions:
+// backfilling ranges, clearing caches, pausing collection, reloading the
+// configuration, reporting ingest progress, saving virtual series,
+// switching ingestion sources and adjusting limits. Every component it
+// acts on is optional; calls needing one that is not set fail with
+// FailedPrecondition.
+type AdminService struct {
+	pb.UnimplementedAdminServiceServer
+	repository database.TimeSeriesRepository
+	cache      *middleware.Cache
+	validator  *RequestValidator
+	logger     *logrus.Logger
+
+	fetcher     api.DataFetcher
+	scheduler   SchedulerControl
+	bucketCache Purger
+	reloader    ConfigReloader
+	service     *TimeSeriesService
+	sources     *ingest.Switch
+	rateLimiter *middleware.RateLimiter
+	concurrency *middleware.ConcurrencyLimiter
+
+	// clock is the clock backfills may not reach past and lag is
+	// measured against
+	clock clock.Clock
+
+	// backfilling is held while a backfill runs, so that backfills do not
+	// fetch over each other
+	backfilling sync.Mutex
+
+	// limitsMu serializes SetLimits calls, so that concurrent changes to
+	// the same limits are not lost
+	limitsMu sync.Mutex
+}
+
+// NewAdminService creates an admin service reading ingest progress from
+// repo and clearing cache, the response cache of the server.
+func NewAdminService(repo database.TimeSeriesRepository, cache *middleware.Cache, logger *logrus.Logger) *AdminService {
+	return &AdminService{
+		repository: repo,
+		cache:      cache,
+		validator:  NewRequestValidator(),
+		logger:     logger,
+		clock:      clock.System,
+	}
+}
+
+// SetClock sets the clock backfills and lag are measured against, instead
+// of the system clock. It must be called before the service starts
+// serving.
+func (s *AdminService) SetClock(clk clock.Clock) {
+	s.clock = clk
+	s.validator.clock = clk
+}
+
+// SetFetcher sets the fetcher Backfill ingests through. It must be called
+// before the service starts serving.
+func (s *AdminService) SetFetcher(fetcher api.DataFetcher) {
+	s.fetcher = fetcher
+}
+
+// SetScheduler sets the scheduler PauseScheduler and ResumeScheduler
+// control. It must be called before the service starts serving.
+func (s *AdminService) SetScheduler(scheduler SchedulerControl) {
+	s.scheduler = scheduler
+}
+
+// SetBucketCache sets the bucket cache ClearCache empties along with the
+// response cache. It must be called before the service starts serving.
+func (s *AdminService) SetBucketCache(bucketCache Purger) {
+	s.bucketCache = bucketCache
+}
+
+// SetReloader sets the reloader ReloadConfig calls. It must be called
+// before the service starts serving.
+func (s *AdminService) SetReloader(reloader ConfigReloader) {
+	s.reloader = reloader
+}
+
+// SetIngestSwitch sets the switch ListIngestSources and SetIngestSource
+// report and change. It must be called before the service starts serving.
+func (s *AdminService) SetIngestSwitch(sources *ingest.Switch) {
+	s.sources = sources
+}
+
+// SetTimeSeriesService sets the service virtual series are checked against
+// and applied to once saved. It must be called before the service starts
+// serving.
+func (s *AdminService) SetTimeSeriesService(service *TimeSeriesService) {
+	s.service = service
+}
+
+// SetLimiters sets the rate and concurrency limiters GetLimits and
+// SetLimits report and change. It must be called before the service
+// starts serving.
+func (s *AdminService) SetLimiters(rateLimiter *middleware.RateLimiter, concurrency *middleware.ConcurrencyLimiter) {
+	s.rateLimiter = rateLimiter
+	s.concurrency = concurrency
+}
+
+// Backfill fetches the requested range from the data source in chunks of
+// at most a day, and returns once it has been stored. If a chunk fails,
+// the rest of the range is recorded as a gap for the hourly repair to
+// retry, and the call fails with Unavailable; while the source is
+// disabled, it fails with FailedPrecondition instead. Cached responses are
+// cleared once data has been stored, so queries see it straight away.
+// Only one backfill runs at a time.
+func (s *AdminService) Backfill(ctx context.Context, req *pb.BackfillRequest) (*pb.BackfillResponse, error) {
+	if s.fetcher == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "backfills are not configured")
+	}
+
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+	if err := s.validator.ValidateRange(start, end); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	if end.After(s.clock.Now()) {
+		return nil, status.Errorf(codes.InvalidArgument, "end time must not be in the future")
+	}
+
+	if !s.backfilling.TryLock() {
+		return nil, status.Errorf(codes.Aborted, "a backfill is already running")
+	}
+	defer s.backfilling.Unlock()
+
+	logger := s.logger.WithFields(logrus.Fields{
+		"start": start,
+		"end":   end,
+	})
+	logger.Info("Starting backfill")
+
+	began := time.Now()
+	var chunks int32
+	defer func() {
+		if chunks > 0 && s.cache != nil {
+			s.cache.Purge()
+		}
+	}()
+
+	for from := start; from.Before(end); {
+		to := from.Add(backfillChunk)
+		if to.After(end) {
+			to = end
+		}
+		if err := s.fetcher.FetchData(ctx, from, to); err != nil {
+			if errors.Is(err, ingest.ErrDisabled) {
+				return nil, status.Errorf(codes.FailedPrecondition, "backfill refused after %d chunks: %v", chunks, err)
+			}
+			logger.WithError(err).WithField("chunkStart", from).Error("Backfill failed")
+			// The gap outlives a call cancelled by its client
+			if recordErr := s.repository.RecordGap(context.WithoutCancel(ctx), from, end, err.Error()); recordErr != nil {
+				logger.WithError(recordErr).Error("Failed to record unfetched backfill range")
+			}
+			return nil, status.Errorf(codes.Unavailable, "backfill failed after %d chunks, the rest is recorded as a gap: %v", chunks, err)
+		}
+		chunks++
+		from = to
+	}
+
+	elapsed := time.Since(began)
+	logger.WithFields(logrus.Fields{
+		"chunks":   chunks,
+		"duration": elapsed,
+	}).Info("Backfill completed")
+
+	return &pb.BackfillResponse{
+		Chunks:   chunks,
+		Duration: durationpb.New(elapsed),
+	}, nil
+}
+
+// ClearCache empties the response cache and, if set, the bucket cache.
+func (s *AdminService) ClearCache(ctx context.Context, req *pb.ClearCacheRequest) (*pb.ClearCacheResponse, error) {
+	resp := &pb.ClearCacheResponse{}
+	if s.cache != nil {
+		resp.Responses = int64(s.cache.Purge())
+	}
+	if s.bucketCache != nil {
+		resp.Buckets = int64(s.bucketCache.Purge())
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"responses": resp.Responses,
+		"buckets":   resp.Buckets,
+	}).Info("Cleared caches")
+	return resp, nil
+}
+
+// PauseScheduler stops scheduled collection, gap repair and report runs
+// until ResumeScheduler is called. A run in progress is not interrupted.
+func (s *AdminService) PauseScheduler(ctx context.Context, req *pb.PauseSchedulerRequest) (*pb.SchedulerState, error) {
+	if s.scheduler == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "the scheduler is not running")
+	}
+	s.scheduler.Pause()
+	return toProtoSchedulerState(s.scheduler.Status()), nil
+}
+
+// ResumeScheduler restarts scheduled runs after PauseScheduler. The next
+// collection run catches up on the data missed while paused.
+func (s *AdminService) ResumeScheduler(ctx context.Context, req *pb.ResumeSchedulerRequest) (*pb.SchedulerState, error) {
+	if s.scheduler == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "the scheduler is not running")
+	}
+	s.scheduler.Resume()
+	return toProtoSchedulerState(s.scheduler.Status()), nil
+}
+
+// ReloadConfig applies the configuration file to the running service. An
+// invalid configuration is rejected with FailedPrecondition and leaves the
+// running configuration in place.
+func (s *AdminService) ReloadConfig(ctx context.Context, req *pb.ReloadConfigRequest) (*pb.ReloadConfigResponse, error) {
+	if s.reloader == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "configuration reloads are not configured")
+	}
+
+	applied, restartRequired, err := s.reloader.Reload(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "configuration not reloaded: %v", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"applied":         applied,
+		"restartRequired": restartRequired,
+	}).Info("Reloaded configuration")
+	return &pb.ReloadConfigResponse{
+		Applied:         applied,
+		RestartRequired: restartRequired,
+	}, nil
+}
+
+// GetWatermarks reports the ingest watermark, how far it trails the
+// current time, and the ranges waiting to be repaired.
+func (s *AdminService) GetWatermarks(ctx context.Context, req *pb.WatermarksRequest) (*pb.WatermarksResponse, error) {
+	watermark, err := s.repository.Watermark(ctx)
+	if err != nil {
+		return nil, storageError(err, "failed to read watermark: %v", err)
+	}
+	gaps, err := s.repository.PendingGaps(ctx)
+	if err != nil {
+		return nil, storageError(err, "failed to list gaps: %v", err)
+	}
+
+	resp := &pb.WatermarksResponse{}
+	if !watermark.IsZero() {
+		resp.Watermark = timestamppb.New(watermark)
+		resp.Lag = durationpb.New(max(s.clock.Now().Sub(watermark), 0))
+	}
+	for _, gap := range gaps {
+		resp.Gaps = append(resp.Gaps, &pb.IngestGap{
+			Id:        gap.ID,
+			Start:     timestamppb.New(gap.Start),
+			End:       timestamppb.New(gap.End),
+			Reason:    gap.Reason,
+			CreatedAt: timestamppb.New(gap.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+// SaveVirtualSeries creates a virtual series or replaces the one of the
+// same name. Series that cannot be queried along with the others, such as
+// those referring to unknown series, are rejected with InvalidArgument.
+// Once saved, queries to this instance can name the series at once, and
+// those to other instances once they reload the virtual series.
+func (s *AdminService) SaveVirtualSeries(ctx context.Context, req *pb.SaveVirtualSeriesRequest) (*pb.VirtualSeries, error) {
+	if s.service == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "virtual series are not configured")
+	}
+	series := req.GetSeries()
+	if series.GetName() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "series name is required")
+	}
+	if series.Expression == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "expression is required")
+	}
+	if err := labels.Validate(series.Tags); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	// The definitions are checked by the repository, under the lock
+	// serialising changes to them
+	var invalid error
+	saved, err := s.repository.SaveVirtualSeries(ctx, models.VirtualSeries{
+		Name:        series.Name,
+		Expression:  series.Expression,
+		Unit:        series.Unit,
+		Description: series.Description,
+		Tags:        series.Tags,
+	}, func(virtual []models.VirtualSeries) error {
+		invalid = s.service.CheckVirtualSeries(virtual)
+		return invalid
+	})
+	if invalid != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", invalid.Error())
+	}
+	if err != nil {
+		return nil, storageError(err, "failed to save virtual series: %v", err)
+	}
+	logger := s.logger.WithFields(logrus.Fields{
+		"series":     saved.Name,
+		"expression": saved.Expression,
+	})
+	logger.Info("Saved virtual series")
+	if err := s.service.ReloadVirtualSeries(ctx); err != nil {
+		logger.WithError(err).Warn("Failed to reload virtual series")
+	}
+	return toProtoVirtualSeries(saved), nil
+}
+
+// ListVirtualSeries returns the saved virtual series, by name.
+func (s *AdminService) ListVirtualSeries(ctx context.Context, req *pb.ListVirtualSeriesRequest) (*pb.ListVirtualSeriesResponse, error) {
+	virtual, err := s.repository.VirtualSeries(ctx)
+	if err != nil {
+		return nil, storageError(err, "failed to read virtual series: %v", err)
+	}
+	resp := &pb.ListVirtualSeriesResponse{}
+	for _, v := range virtual {
+		resp.Series = append(resp.Series, toProtoVirtualSeries(v))
+	}
+	return resp, nil
+}
+
+// DeleteVirtualSeries removes a virtual series. Series other virtual
+// series refer to are not removed, failing with FailedPrecondition.
+func (s *AdminService) DeleteVirtualSeries(ctx context.Context, req *pb.DeleteVirtualSeriesRequest) (*pb.DeleteVirtualSeriesResponse, error) {
+	if s.service == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "virtual series are not configured")
+	}
+
+	var inUse error
+	deleted, err := s.repository.DeleteVirtualSeries(ctx, req.Name, func(remaining []models.VirtualSeries) error {
+		inUse = s.service.CheckVirtualSeries(remaining)
+		return inUse
+	})
+	if inUse != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "series %s is still in use: %v", req.Name, inUse)
+	}
+	if err != nil {
+		return nil, storageError(err, "failed to delete virtual series: %v", err)
+	}
+	if !deleted {
+		return nil, status.Errorf(codes.NotFound, "virtual series %s not found", req.Name)
+	}
+	logger := s.logger.WithField("series", req.Name)
+	logger.Info("Deleted virtual series")
+	if err := s.service.ReloadVirtualSeries(ctx); err != nil {
+		logger.WithError(err).Warn("Failed to reload virtual series")
+	}
+	return &pb.DeleteVirtualSeriesResponse{}, nil
+}
+
+// ListIngestSources returns whether each ingestion source is enabled.
+func (s *AdminService) ListIngestSources(ctx context.Context, req *pb.ListIngestSourcesRequest) (*pb.ListIngestSourcesResponse, error) {
+	if s.sources == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "ingestion sources are not configured")
+	}
+	resp := &pb.ListIngestSourcesResponse{}
+	for _, source := range s.sources.States() {
+		resp.Sources = append(resp.Sources, toProtoIngestSource(source))
+	}
+	return resp, nil
+}
+
+// SetIngestSource enables or disables an ingestion source. The state is
+// persisted, so it survives restarts; this instance applies it at once
+// and other instances once they reload the sources. Writes of a disabled
+// source fail with FailedPrecondition and scheduled collection from it is
+// skipped. Once it is enabled again, collection resumes from that time,
+// or from the ingest watermark with catch_up.
+func (s *AdminService) SetIngestSource(ctx context.Context, req *pb.SetIngestSourceRequest) (*pb.IngestSource, error) {
+	if s.sources == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "ingestion sources are not configured")
+	}
+	if !ingest.Known(req.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown ingestion source %q, expected one of %v", req.Name, ingest.Sources)
+	}
+
+	state, err := s.sources.Set(ctx, req.Name, req.Enabled, req.Reason, req.CatchUp)
+	if err != nil {
+		return nil, storageError(err, "failed to save ingestion source: %v", err)
+	}
+	return toProtoIngestSource(state), nil
+}
+
+// GetLimits returns the limits in effect. Limits of components that are
+// not set are left unset.
+func (s *AdminService) GetLimits(ctx context.Context, req *pb.GetLimitsRequest) (*pb.Limits, error) {
+	return s.limits(), nil
+}
+
+// SetLimits changes the limits named by the update mask, returning the
+// limits in effect afterwards. Changes apply at once and last until the
+// service restarts. Every field is checked before any is applied, so a
+// rejected call changes nothing.
+func (s *AdminService) SetLimits(ctx context.Context, req *pb.SetLimitsRequest) (*pb.Limits, error) {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "update_mask is required")
+	}
+	limits := req.GetLimits()
+
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+
+	var (
+		apply       []func()
+		concurrency middleware.ConcurrencyLimits
+		points      PointLimits
+	)
+	if s.concurrency != nil {
+		concurrency = s.concurrency.Limits()
+	}
+	if s.service != nil {
+		points = s.service.PointLimits()
+	}
+	concurrencyChanged, pointsChanged := false, false
+
+	for _, path := range paths {
+		field, sub, _ := strings.Cut(path, ".")
+		switch {
+		case field == "rate_limit" || field == "method_rate_limits":
+			if s.rateLimiter == nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "rate limits are not configured")
+			}
+		case field == "concurrency":
+			if s.concurrency == nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "concurrency limits are not configured")
+			}
+		case field == "cache_error_ttl" || field == "cache_max_staleness":
+			if s.cache == nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "caching is not configured")
+			}
+		case field == "max_page_size" || field == "max_insert_points":
+			if s.service == nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "the time series service is not configured")
+			}
+		}
+		if sub != "" && field != "concurrency" {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown field %q in update_mask", path)
+		}
+
+		switch field {
+		case "rate_limit":
+			limit, err := fromProtoRateLimit(limits.GetRateLimit())
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid rate_limit: %v", err)
+			}
+			apply = append(apply, func() { s.rateLimiter.SetLimit(limit) })
+		case "method_rate_limits":
+			own := s.rateLimiter.MethodLimits()
+			for method, pbLimit := range limits.GetMethodRateLimits() {
+				if _, ok := own[method]; !ok {
+					return nil, status.Errorf(codes.InvalidArgument, "%s has no rate limit of its own", method)
+				}
+				limit, err := fromProtoRateLimit(pbLimit)
+				if err != nil {
+					return nil, status.Errorf(codes.InvalidArgument, "invalid rate limit of %s: %v", method, err)
+				}
+				apply = append(apply, func() { s.rateLimiter.UpdateMethodLimit(method, limit) })
+			}
+		case "concurrency":
+			if err := mergeConcurrencyLimits(&concurrency, limits.GetConcurrency(), sub); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+			}
+			concurrencyChanged = true
+		case "cache_error_ttl", "cache_max_staleness":
+			d := limits.GetCacheErrorTtl()
+			if field == "cache_max_staleness" {
+				d = limits.GetCacheMaxStaleness()
+			}
+			if err := d.CheckValid(); d != nil && err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", field, err)
+			}
+			ttl := d.AsDuration()
+			if ttl < 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "%s must not be negative", field)
+			}
+			if field == "cache_error_ttl" {
+				apply = append(apply, func() { s.cache.SetErrorTTL(ttl) })
+			} else {
+				apply = append(apply, func() { s.cache.SetServeStale(ttl) })
+			}
+		case "max_page_size":
+			points.MaxPageSize = int(limits.GetMaxPageSize())
+			pointsChanged = true
+		case "max_insert_points":
+			points.MaxInsertPoints = int(limits.GetMaxInsertPoints())
+			pointsChanged = true
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown field %q in update_mask", path)
+		}
+	}
+
+	if concurrencyChanged {
+		if err := concurrency.Validate(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		apply = append(apply, func() { s.concurrency.SetLimits(concurrency) })
+	}
+	if pointsChanged {
+		if points.MaxPageSize <= 0 || points.MaxInsertPoints <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "point limits must be positive")
+		}
+		apply = append(apply, func() {
+			// Checked above, so it cannot fail
+			_ = s.service.SetPointLimits(points)
+		})
+	}
+
+	for _, change := range apply {
+		change()
+	}
+	s.logger.WithField("fields", paths).Info("Changed limits")
+	return s.limits(), nil
+}
+
+// limits returns the limits of the components that are set
+func (s *AdminService) limits() *pb.Limits {
+	limits := &pb.Limits{}
+	if s.rateLimiter != nil {
+		limits.RateLimit = toProtoRateLimit(s.rateLimiter.Limit())
+		for method, limit := range s.rateLimiter.MethodLimits() {
+			if limits.MethodRateLimits == nil {
+				limits.MethodRateLimits = make(map[string]*pb.RateLimit)
+			}
+			limits.MethodRateLimits[method] = toProtoRateLimit(limit)
+		}
+	}
+	if s.concurrency != nil {
+		concurrency := s.concurrency.Limits()
+		limits.Concurrency = &pb.ConcurrencyLimits{
+			MaxInFlight:          int32(concurrency.MaxInFlight),
+			MaxInFlightPerClient: int32(concurrency.MaxInFlightPerClient),
+			MaxQueued:            int32(concurrency.MaxQueued),
+			QueueTimeout:         durationpb.New(concurrency.QueueTimeout),
+		}
+		for client, limit := range concurrency.ClientLimits {
+			if limits.Concurrency.ClientLimits == nil {
+				limits.Concurrency.ClientLimits = make(map[string]int32)
+			}
+			limits.Concurrency.ClientLimits[client] = int32(limit)
+		}
+	}
+	if s.cache != nil {
+		limits.CacheErrorTtl = durationpb.New(s.cache.ErrorTTL())
+		limits.CacheMaxStaleness = durationpb.New(s.cache.MaxStaleness())
+	}
+	if s.service != nil {
+		points := s.service.PointLimits()
+		limits.MaxPageSize = int32(points.MaxPageSize)
+		limits.MaxInsertPoints = int32(points.MaxInsertPoints)
+	}
+	return limits
+}
+
+// mergeConcurrencyLimits sets the field of limits named by sub, or all of
+// them when sub is empty, to its value in update
+func mergeConcurrencyLimits(limits *middleware.ConcurrencyLimits, update *pb.ConcurrencyLimits, sub string) error {
+	if sub == "" || sub == "queue_timeout" {
+		if d := update.GetQueueTimeout(); d != nil {
+			if err := d.CheckValid(); err != nil {
+				return fmt.Errorf("invalid concurrency.queue_timeout: %v", err)
+			}
+		}
+	}
+	switch sub {
+	case "":
+		limits.MaxInFlight = int(update.GetMaxInFlight())
+		limits.MaxInFlightPerClient = int(update.GetMaxInFlightPerClient())
+		limits.ClientLimits = fromProtoClientLimits(update.GetClientLimits())
+		limits.MaxQueued = int(update.GetMaxQueued())
+		limits.QueueTimeout = update.GetQueueTimeout().AsDuration()
+	case "max_in_flight":
+		limits.MaxInFlight = int(update.GetMaxInFlight())
+	case "max_in_flight_per_client":
+		limits.MaxInFlightPerClient = int(update.GetMaxInFlightPerClient())
+	case "client_limits":
+		limits.ClientLimits = fromProtoClientLimits(update.GetClientLimits())
+	case "max_queued":
+		limits.MaxQueued = int(update.GetMaxQueued())
+	case "queue_timeout":
+		limits.QueueTimeout = update.GetQueueTimeout().AsDuration()
+	default:
+		return fmt.Errorf("unknown field \"concurrency.%s\" in update_mask", sub)
+	}
+	return nil
+}
+
+// fromProtoClientLimits converts per-client concurrency limits from their
+// protobuf representation
+func fromProtoClientLimits(limits map[string]int32) map[string]int {
+	if len(limits) == 0 {
+		return nil
+	}
+	clients := make(map[string]int, len(limits))
+	for client, limit := range limits {
+		clients[client] = int(limit)
+	}
+	return clients
+}
+
+// fromProtoRateLimit converts a rate limit from its protobuf
+// representation. Missing limits are rejected, as are limits throttling
+// every call unless reject_all explicitly asks for that.
+func fromProtoRateLimit(limit *pb.RateLimit) (middleware.RateLimit, error) {
+	if limit == nil {
+		return middleware.RateLimit{}, fmt.Errorf("limit is required")
+	}
+	if limit.RejectAll {
+		if limit.Rps != 0 || limit.Burst != 0 {
+			return middleware.RateLimit{}, fmt.Errorf("rps and burst must be zero when reject_all is set")
+		}
+		return middleware.RateLimit{}, nil
+	}
+	if !(limit.Rps > 0) || limit.Burst <= 0 {
+		return middleware.RateLimit{}, fmt.Errorf("rps and burst must be positive, or reject_all set to reject every call")
+	}
+	return middleware.RateLimit{RPS: limit.Rps, Burst: int(limit.Burst)}, nil
+}
+
+// toProtoRateLimit converts a rate limit to its protobuf representation. A
+// zero burst, which rejects every call, is reported as reject_all.
+func toProtoRateLimit(limit middleware.RateLimit) *pb.RateLimit {
+	if limit.Burst == 0 {
+		return &pb.RateLimit{RejectAll: true}
+	}
+	return &pb.RateLimit{Rps: limit.RPS, Burst: int32(limit.Burst)}
+}
+
+// toProtoIngestSource converts an ingestion source state to its protobuf
+// representation, leaving the update time of sources never switched unset
+func toProtoIngestSource(source models.IngestSource) *pb.IngestSource {
+	state := &pb.IngestSource{
+		Name:    source.Name,
+		Enabled: source.Enabled,
+		Reason:  source.Reason,
+	}
+	if !source.UpdatedAt.IsZero() {
+		state.UpdatedAt = timestamppb.New(source.UpdatedAt)
+	}
+	return state
+}
+
+// toProtoVirtualSeries converts a virtual series to its protobuf
+// representation
+func toProtoVirtualSeries(v models.VirtualSeries) *pb.VirtualSeries {
+	return &pb.VirtualSeries{
+		Name:        v.Name,
+		Expression:  v.Expression,
+		Unit:        v.Unit,
+		Description: v.Description,
+		Tags:        v.Tags,
+		CreatedAt:   timestamppb.New(v.CreatedAt),
+		UpdatedAt:   timestamppb.New(v.UpdatedAt),
+	}
+}
+
+// toProtoSchedulerState converts a scheduler status to its protobuf
+// representation, leaving times that have not happened yet unset
+func toProtoSchedulerState(st scheduler.Status) *pb.SchedulerState {
+	state := &pb.SchedulerState{
+		Paused:    st.Paused,
+		Running:   st.Running,
+		LastError: st.LastError,
+	}
+	timestamp := func(t time.Time) *timestamppb.Timestamp {
+		if t.IsZero() {
+			return nil
+		}
+		return timestamppb.New(t)
+	}
+	state.LastRun = timestamp(st.LastRun)
+	state.LastSuccess = timestamp(st.LastSuccess)
+	state.NextRun = timestamp(st.NextRun)
+	return state
+}
+
+// Compile-time interface implementation check
+var _ pb.AdminServiceServer = (*AdminService)(nil)
@@ -0,0 +1,536 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	apimocks "github.com/tejusbharadwaj/edgecom/internal/api/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
+	server "github.com/tejusbharadwaj/edgecom/internal/grpc"
+	middleware "github.com/tejusbharadwaj/edgecom/internal/grpc/middlewares"
+	"github.com/tejusbharadwaj/edgecom/internal/ingest"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/scheduler"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// fakeScheduler records whether it is paused
+type fakeScheduler struct {
+	paused bool
+}
+
+func (s *fakeScheduler) Pause()  { s.paused = true }
+func (s *fakeScheduler) Resume() { s.paused = false }
+func (s *fakeScheduler) Status() scheduler.Status {
+	return scheduler.Status{Paused: s.paused, LastError: "upstream unavailable"}
+}
+
+// fakePurger reports a fixed number of purged entries
+type fakePurger int
+
+func (p fakePurger) Purge() int { return int(p) }
+
+// fakeReloader returns fixed sections, or err
+type fakeReloader struct {
+	err error
+}
+
+func (r fakeReloader) Reload(context.Context) ([]string, []string, error) {
+	return []string{"logging"}, []string{"server"}, r.err
+}
+
+func newTestAdminService(t *testing.T) (*server.AdminService, *mocks.MockTimeSeriesRepository, *middleware.Cache, *clock.Fake) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	cache, err := middleware.NewCache(10)
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	svc := server.NewAdminService(mockRepo, cache, logger)
+	clk := clock.NewFake(time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC))
+	svc.SetClock(clk)
+	return svc, mockRepo, cache, clk
+}
+
+// fillCache caches a response
+func fillCache(t *testing.T, cache *middleware.Cache) {
+	info := &grpc.UnaryServerInfo{FullMethod: pb.TimeSeriesService_QueryTimeSeries_FullMethodName}
+	handler := func(context.Context, interface{}) (interface{}, error) { return &pb.TimeSeriesResponse{}, nil }
+	_, err := cache.InterceptorFunc()(context.Background(), &pb.TimeSeriesRequest{}, info, handler)
+	require.NoError(t, err)
+	require.Equal(t, 1, cache.Stats().Entries)
+}
+
+func TestBackfill(t *testing.T) {
+	svc, mockRepo, cache, clk := newTestAdminService(t)
+	now := clk.Now()
+	ctx := context.Background()
+	request := func(start, end time.Time) *pb.BackfillRequest {
+		return &pb.BackfillRequest{Start: timestamppb.New(start), End: timestamppb.New(end)}
+	}
+
+	_, err := svc.Backfill(ctx, request(now.Add(-time.Hour), now))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "without a fetcher")
+
+	fetcher := apimocks.NewMockDataFetcher(gomock.NewController(t))
+	svc.SetFetcher(fetcher)
+
+	t.Run("invalid range", func(t *testing.T) {
+		_, err := svc.Backfill(ctx, request(now, now.Add(-time.Hour)))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = svc.Backfill(ctx, request(now, now.Add(time.Hour)))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.ErrorContains(t, err, "future")
+	})
+
+	t.Run("fetched a day at a time", func(t *testing.T) {
+		fillCache(t, cache)
+		start := now.Add(-60 * time.Hour)
+		gomock.InOrder(
+			fetcher.EXPECT().FetchData(gomock.Any(), start, start.Add(24*time.Hour)),
+			fetcher.EXPECT().FetchData(gomock.Any(), start.Add(24*time.Hour), start.Add(48*time.Hour)),
+			fetcher.EXPECT().FetchData(gomock.Any(), start.Add(48*time.Hour), now),
+		)
+
+		resp, err := svc.Backfill(ctx, request(start, now))
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), resp.Chunks)
+		assert.NotNil(t, resp.Duration)
+		assert.Zero(t, cache.Stats().Entries, "cached responses are cleared")
+	})
+
+	t.Run("failure records the rest as a gap", func(t *testing.T) {
+		start := now.Add(-36 * time.Hour)
+		gomock.InOrder(
+			fetcher.EXPECT().FetchData(gomock.Any(), start, start.Add(24*time.Hour)),
+			fetcher.EXPECT().FetchData(gomock.Any(), start.Add(24*time.Hour), now).Return(errors.New("upstream unavailable")),
+		)
+		mockRepo.EXPECT().RecordGap(gomock.Any(), start.Add(24*time.Hour), now, "upstream unavailable")
+
+		_, err := svc.Backfill(ctx, request(start, now))
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.ErrorContains(t, err, "after 1 chunks")
+	})
+
+	t.Run("refused while the source is disabled", func(t *testing.T) {
+		fetcher.EXPECT().FetchData(gomock.Any(), now.Add(-time.Hour), now).
+			Return(fmt.Errorf("failed to insert data points: %w", ingest.ErrDisabled))
+
+		_, err := svc.Backfill(ctx, request(now.Add(-time.Hour), now))
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err), "no gap is recorded")
+	})
+}
+
+func TestClearCache(t *testing.T) {
+	svc, _, cache, _ := newTestAdminService(t)
+	fillCache(t, cache)
+	svc.SetBucketCache(fakePurger(7))
+
+	resp, err := svc.ClearCache(context.Background(), &pb.ClearCacheRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.Responses)
+	assert.Equal(t, int64(7), resp.Buckets)
+	assert.Zero(t, cache.Stats().Entries)
+}
+
+func TestPauseScheduler(t *testing.T) {
+	svc, _, _, _ := newTestAdminService(t)
+	ctx := context.Background()
+
+	_, err := svc.PauseScheduler(ctx, &pb.PauseSchedulerRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	sched := &fakeScheduler{}
+	svc.SetScheduler(sched)
+
+	state, err := svc.PauseScheduler(ctx, &pb.PauseSchedulerRequest{})
+	require.NoError(t, err)
+	assert.True(t, state.Paused)
+	assert.True(t, sched.paused)
+	assert.Equal(t, "upstream unavailable", state.LastError)
+	assert.Nil(t, state.LastRun, "times that have not happened are unset")
+
+	state, err = svc.ResumeScheduler(ctx, &pb.ResumeSchedulerRequest{})
+	require.NoError(t, err)
+	assert.False(t, state.Paused)
+}
+
+func TestIngestSources(t *testing.T) {
+	svc, mockRepo, _, clk := newTestAdminService(t)
+	ctx := context.Background()
+
+	_, err := svc.ListIngestSources(ctx, &pb.ListIngestSourcesRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	sources := ingest.NewSwitch(mockRepo, logrus.New())
+	svc.SetIngestSwitch(sources)
+
+	_, err = svc.SetIngestSource(ctx, &pb.SetIngestSourceRequest{Name: "mqtt"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	mockRepo.EXPECT().
+		SetIngestSource(gomock.Any(), models.IngestSource{Name: ingest.SourceGRPC, Reason: "meter reports Wh"}, false).
+		Return(models.IngestSource{Name: ingest.SourceGRPC, Reason: "meter reports Wh", UpdatedAt: clk.Now()}, nil)
+	state, err := svc.SetIngestSource(ctx, &pb.SetIngestSourceRequest{Name: ingest.SourceGRPC, Reason: "meter reports Wh"})
+	require.NoError(t, err)
+	assert.False(t, state.Enabled)
+	assert.Equal(t, clk.Now(), state.UpdatedAt.AsTime())
+
+	list, err := svc.ListIngestSources(ctx, &pb.ListIngestSourcesRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Sources, len(ingest.Sources))
+	for _, source := range list.Sources {
+		assert.Equal(t, source.Name != ingest.SourceGRPC, source.Enabled, source.Name)
+	}
+
+	// Inserts of the disabled source are refused before they reach the
+	// repository
+	service := server.NewTimeSeriesService(ingest.NewRepository(mockRepo, sources, ingest.SourceGRPC))
+	_, err = service.InsertTimeSeries(ctx, &pb.InsertRequest{Data: []*pb.TimeSeriesDataPoint{
+		{Time: timestamppb.New(time.Now().Add(-time.Minute)), Value: 1},
+	}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestReloadConfig(t *testing.T) {
+	svc, _, _, _ := newTestAdminService(t)
+	ctx := context.Background()
+
+	_, err := svc.ReloadConfig(ctx, &pb.ReloadConfigRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	svc.SetReloader(fakeReloader{})
+	resp, err := svc.ReloadConfig(ctx, &pb.ReloadConfigRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"logging"}, resp.Applied)
+	assert.Equal(t, []string{"server"}, resp.RestartRequired)
+
+	svc.SetReloader(fakeReloader{err: errors.New("logging: unknown level")})
+	_, err = svc.ReloadConfig(ctx, &pb.ReloadConfigRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.ErrorContains(t, err, "unknown level")
+}
+
+func TestGetWatermarks(t *testing.T) {
+	svc, mockRepo, _, clk := newTestAdminService(t)
+	now := clk.Now()
+	ctx := context.Background()
+
+	t.Run("nothing ingested", func(t *testing.T) {
+		mockRepo.EXPECT().Watermark(gomock.Any()).Return(time.Time{}, nil)
+		mockRepo.EXPECT().PendingGaps(gomock.Any()).Return(nil, nil)
+
+		resp, err := svc.GetWatermarks(ctx, &pb.WatermarksRequest{})
+		require.NoError(t, err)
+		assert.Nil(t, resp.Watermark)
+		assert.Nil(t, resp.Lag)
+		assert.Empty(t, resp.Gaps)
+	})
+
+	t.Run("lag and gaps", func(t *testing.T) {
+		mockRepo.EXPECT().Watermark(gomock.Any()).Return(now.Add(-10*time.Minute), nil)
+		mockRepo.EXPECT().PendingGaps(gomock.Any()).Return([]models.Gap{
+			{ID: 3, Start: now.Add(-48 * time.Hour), End: now.Add(-47 * time.Hour), Reason: "timeout"},
+		}, nil)
+
+		resp, err := svc.GetWatermarks(ctx, &pb.WatermarksRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, now.Add(-10*time.Minute), resp.Watermark.AsTime())
+		assert.Equal(t, 10*time.Minute, resp.Lag.AsDuration())
+		require.Len(t, resp.Gaps, 1)
+		assert.Equal(t, int64(3), resp.Gaps[0].Id)
+		assert.Equal(t, "timeout", resp.Gaps[0].Reason)
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		mockRepo.EXPECT().Watermark(gomock.Any()).Return(time.Time{}, assert.AnError)
+
+		_, err := svc.GetWatermarks(ctx, &pb.WatermarksRequest{})
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+}
+
+func TestVirtualSeries(t *testing.T) {
+	svc, mockRepo, cache, clk := newTestAdminService(t)
+	ctx := context.Background()
+	now := clk.Now()
+
+	derived, err := expression.NewDerived(map[string]string{"net_load": "default - 40"}, "default")
+	require.NoError(t, err)
+	service := server.NewTimeSeriesService(mockRepo)
+	service.SetDerivedSeries(derived)
+	service.SetSeriesChangeFunc(func() { cache.Purge() })
+	svc.SetTimeSeriesService(service)
+
+	billed := models.VirtualSeries{Name: "billed", Expression: "net_load * 2", Unit: "kW", CreatedAt: now, UpdatedAt: now}
+	query := func(series string) (*pb.TimeSeriesResponse, error) {
+		return service.QueryTimeSeries(ctx, &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(now.Add(-time.Hour)),
+			End:         timestamppb.New(now),
+			Window:      "1h",
+			Aggregation: "AVG",
+			Series:      series,
+		})
+	}
+
+	t.Run("saved series can be queried", func(t *testing.T) {
+		fillCache(t, cache)
+		mockRepo.EXPECT().SaveVirtualSeries(gomock.Any(), models.VirtualSeries{Name: "billed", Expression: "net_load * 2", Unit: "kW"}, gomock.Any()).
+			DoAndReturn(saveVirtualSeries(billed, nil))
+		mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return([]models.VirtualSeries{billed}, nil)
+
+		resp, err := svc.SaveVirtualSeries(ctx, &pb.SaveVirtualSeriesRequest{
+			Series: &pb.VirtualSeries{Name: "billed", Expression: "net_load * 2", Unit: "kW"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "billed", resp.Name)
+		assert.Equal(t, now, resp.CreatedAt.AsTime())
+		assert.Zero(t, cache.Stats().Entries, "responses of the previous definitions are dropped")
+
+		mockRepo.EXPECT().Query(gomock.Any(), gomock.Any(), gomock.Any(), "1h", "AVG").
+			Return([]models.TimeSeriesData{{Time: now.Add(-time.Hour), Value: 50}}, nil)
+		result, err := query("billed")
+		require.NoError(t, err)
+		require.Len(t, result.Data, 1)
+		assert.Equal(t, 20.0, result.Data[0].Value)
+		assert.Equal(t, "billed", result.Metadata.Series)
+	})
+
+	t.Run("invalid series are rejected", func(t *testing.T) {
+		for _, series := range []*pb.VirtualSeries{
+			{Name: "solar_share", Expression: "solar / default"},
+			{Name: "net_load", Expression: "default"},
+			{Name: "billed", Expression: "billed + 1"},
+		} {
+			mockRepo.EXPECT().SaveVirtualSeries(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(saveVirtualSeries(models.VirtualSeries{}, []models.VirtualSeries{billed}))
+			_, err := svc.SaveVirtualSeries(ctx, &pb.SaveVirtualSeriesRequest{Series: series})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), series.Name)
+		}
+
+		_, err := svc.SaveVirtualSeries(ctx, &pb.SaveVirtualSeriesRequest{Series: &pb.VirtualSeries{Name: "empty"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = svc.SaveVirtualSeries(ctx, &pb.SaveVirtualSeriesRequest{Series: &pb.VirtualSeries{
+			Name: "tagged", Expression: "default", Tags: map[string]string{"site-id": "plant1"},
+		}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.ErrorContains(t, err, `invalid label name "site-id"`)
+	})
+
+	t.Run("changed tags drop cached responses", func(t *testing.T) {
+		fillCache(t, cache)
+		tagged := billed
+		tagged.Tags = map[string]string{"site": "plant1"}
+		mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return([]models.VirtualSeries{tagged}, nil)
+		require.NoError(t, service.ReloadVirtualSeries(ctx))
+		assert.Zero(t, cache.Stats().Entries)
+
+		fillCache(t, cache)
+		tagged.UpdatedAt = now.Add(time.Minute)
+		mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return([]models.VirtualSeries{tagged}, nil)
+		require.NoError(t, service.ReloadVirtualSeries(ctx))
+		assert.NotZero(t, cache.Stats().Entries, "an unchanged series keeps them")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return([]models.VirtualSeries{billed}, nil)
+
+		resp, err := svc.ListVirtualSeries(ctx, &pb.ListVirtualSeriesRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.Series, 1)
+		assert.Equal(t, "net_load * 2", resp.Series[0].Expression)
+		assert.Equal(t, "kW", resp.Series[0].Unit)
+	})
+
+	t.Run("series in use are not deleted", func(t *testing.T) {
+		double := models.VirtualSeries{Name: "double", Expression: "billed * 2"}
+		mockRepo.EXPECT().DeleteVirtualSeries(gomock.Any(), "billed", gomock.Any()).
+			DoAndReturn(deleteVirtualSeries([]models.VirtualSeries{billed, double}))
+
+		_, err := svc.DeleteVirtualSeries(ctx, &pb.DeleteVirtualSeriesRequest{Name: "billed"})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		mockRepo.EXPECT().DeleteVirtualSeries(gomock.Any(), "billed", gomock.Any()).
+			DoAndReturn(deleteVirtualSeries([]models.VirtualSeries{billed}))
+		mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return(nil, nil)
+
+		_, err := svc.DeleteVirtualSeries(ctx, &pb.DeleteVirtualSeriesRequest{Name: "billed"})
+		require.NoError(t, err)
+		_, err = query("billed")
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		mockRepo.EXPECT().DeleteVirtualSeries(gomock.Any(), "billed", gomock.Any()).
+			DoAndReturn(deleteVirtualSeries(nil))
+		_, err = svc.DeleteVirtualSeries(ctx, &pb.DeleteVirtualSeriesRequest{Name: "billed"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("invalid saved series are skipped", func(t *testing.T) {
+		mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return([]models.VirtualSeries{
+			{Name: "shared", Expression: "solar / default"},
+			{Name: "double", Expression: "billed * 2"},
+			{Name: "shared_double", Expression: "shared * 2"},
+			billed,
+		}, nil)
+		err := service.ReloadVirtualSeries(ctx)
+		assert.ErrorContains(t, err, "shared: ")
+		assert.ErrorContains(t, err, "shared_double: ")
+		assert.NotContains(t, err.Error(), "billed")
+
+		mockRepo.EXPECT().Query(gomock.Any(), gomock.Any(), gomock.Any(), "1h", "AVG").
+			Return([]models.TimeSeriesData{{Time: now.Add(-time.Hour), Value: 50}}, nil)
+		result, err := query("double")
+		require.NoError(t, err)
+		require.Len(t, result.Data, 1)
+		assert.Equal(t, 40.0, result.Data[0].Value)
+		_, err = query("shared")
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+// saveVirtualSeries returns a fake SaveVirtualSeries checking a series
+// along with saved, and returning result if it is saved
+func saveVirtualSeries(result models.VirtualSeries, saved []models.VirtualSeries) func(context.Context, models.VirtualSeries, func([]models.VirtualSeries) error) (models.VirtualSeries, error) {
+	return func(_ context.Context, series models.VirtualSeries, check func([]models.VirtualSeries) error) (models.VirtualSeries, error) {
+		virtual := slices.DeleteFunc(slices.Clone(saved), func(v models.VirtualSeries) bool { return v.Name == series.Name })
+		if err := check(append(virtual, series)); err != nil {
+			return models.VirtualSeries{}, err
+		}
+		return result, nil
+	}
+}
+
+// deleteVirtualSeries returns a fake DeleteVirtualSeries checking the
+// series of saved that remain
+func deleteVirtualSeries(saved []models.VirtualSeries) func(context.Context, string, func([]models.VirtualSeries) error) (bool, error) {
+	return func(_ context.Context, name string, check func([]models.VirtualSeries) error) (bool, error) {
+		remaining := slices.DeleteFunc(slices.Clone(saved), func(v models.VirtualSeries) bool { return v.Name == name })
+		if len(remaining) == len(saved) {
+			return false, nil
+		}
+		if err := check(remaining); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+func TestLimits(t *testing.T) {
+	svc, mockRepo, cache, _ := newTestAdminService(t)
+	ctx := context.Background()
+
+	_, err := svc.SetLimits(ctx, &pb.SetLimitsRequest{
+		Limits:     &pb.Limits{RateLimit: &pb.RateLimit{Rps: 5, Burst: 5}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"rate_limit"}},
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	exportMethod := pb.TimeSeriesService_ExportTimeSeries_FullMethodName
+	rateLimiter := middleware.NewRateLimiter(100, 10)
+	rateLimiter.SetMethodLimit(exportMethod, 1, 1)
+	concurrency := middleware.NewConcurrencyLimiter(middleware.ConcurrencyLimits{MaxInFlight: 4},
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_flight"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "queued"}))
+	svc.SetLimiters(rateLimiter, concurrency)
+	service := server.NewTimeSeriesService(mockRepo)
+	svc.SetTimeSeriesService(service)
+
+	limits, err := svc.GetLimits(ctx, &pb.GetLimitsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, limits.RateLimit.Rps)
+	assert.Equal(t, int32(1), limits.MethodRateLimits[exportMethod].Burst)
+	assert.Equal(t, int32(4), limits.Concurrency.MaxInFlight)
+	assert.Equal(t, int32(10000), limits.MaxPageSize)
+
+	t.Run("changes the masked fields", func(t *testing.T) {
+		limits, err := svc.SetLimits(ctx, &pb.SetLimitsRequest{
+			Limits: &pb.Limits{
+				RateLimit:        &pb.RateLimit{Rps: 5, Burst: 2},
+				MethodRateLimits: map[string]*pb.RateLimit{exportMethod: {Rps: 0.5, Burst: 1}},
+				Concurrency:      &pb.ConcurrencyLimits{MaxInFlight: 2, MaxQueued: 8},
+				CacheErrorTtl:    durationpb.New(time.Minute),
+				MaxPageSize:      500,
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{
+				"rate_limit", "method_rate_limits", "concurrency.max_in_flight", "cache_error_ttl", "max_page_size",
+			}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, middleware.RateLimit{RPS: 5, Burst: 2}, rateLimiter.Limit())
+		assert.Equal(t, middleware.RateLimit{RPS: 0.5, Burst: 1}, rateLimiter.MethodLimits()[exportMethod])
+		assert.Equal(t, middleware.ConcurrencyLimits{MaxInFlight: 2}, concurrency.Limits(), "only the masked concurrency field changes")
+		assert.Equal(t, time.Minute, cache.ErrorTTL())
+		assert.Equal(t, server.PointLimits{MaxPageSize: 500, MaxInsertPoints: 10000}, service.PointLimits())
+		assert.Equal(t, int32(500), limits.MaxPageSize)
+	})
+
+	t.Run("rejects invalid changes without applying any", func(t *testing.T) {
+		for name, req := range map[string]*pb.SetLimitsRequest{
+			"no mask": {Limits: &pb.Limits{}},
+			"unknown field": {
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"max_rows"}},
+			},
+			"method without its own limit": {
+				Limits:     &pb.Limits{MethodRateLimits: map[string]*pb.RateLimit{"/edgecom.TimeSeriesService/QueryTimeSeries": {Rps: 1}}},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"method_rate_limits"}},
+			},
+			"negative concurrency": {
+				Limits:     &pb.Limits{RateLimit: &pb.RateLimit{Rps: 1, Burst: 1}, Concurrency: &pb.ConcurrencyLimits{MaxQueued: -1}},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"rate_limit", "concurrency"}},
+			},
+			"zero burst": {
+				Limits:     &pb.Limits{RateLimit: &pb.RateLimit{Rps: 1}},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"rate_limit"}},
+			},
+			"zero rps": {
+				Limits:     &pb.Limits{RateLimit: &pb.RateLimit{Burst: 1}},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"rate_limit"}},
+			},
+			"disabled with a rate": {
+				Limits:     &pb.Limits{RateLimit: &pb.RateLimit{Rps: 1, Burst: 1, RejectAll: true}},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"rate_limit"}},
+			},
+			"zero page size": {
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"max_page_size"}},
+			},
+		} {
+			_, err := svc.SetLimits(ctx, req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+		}
+		assert.Equal(t, middleware.RateLimit{RPS: 5, Burst: 2}, rateLimiter.Limit())
+		assert.Equal(t, 500, service.PointLimits().MaxPageSize)
+	})
+
+	t.Run("disables a method", func(t *testing.T) {
+		limits, err := svc.SetLimits(ctx, &pb.SetLimitsRequest{
+			Limits:     &pb.Limits{MethodRateLimits: map[string]*pb.RateLimit{exportMethod: {RejectAll: true}}},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"method_rate_limits"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, middleware.RateLimit{}, rateLimiter.MethodLimits()[exportMethod])
+		assert.True(t, limits.MethodRateLimits[exportMethod].RejectAll)
+	})
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// Kinds of the series in the catalog
+const (
+	SeriesKindStored  = "stored"
+	SeriesKindDerived = "derived"
+	SeriesKindVirtual = "virtual"
+)
+
+// SetSeriesDescriptions sets the units, descriptions and tags the catalog
+// lists the derived series of the configuration with, by name. Stored
+// series are described in the repository instead. It must be called
+// before the service starts serving.
+func (s *TimeSeriesService) SetSeriesDescriptions(descriptions map[string]models.SeriesDescription) {
+	s.descriptions = descriptions
+}
+
+// ListSeries lists the series queries may name, by name: the stored
+// series, with the range and count of their samples as of the last
+// committed insert, the derived series of the configuration and the
+// virtual series. Requests may select a kind of series, tags the listed
+// series must all carry and a label selector, such as
+// {site="plant1",phase=~"A|B"}, they must match.
+func (s *TimeSeriesService) ListSeries(
+	ctx context.Context,
+	req *pb.ListSeriesRequest,
+) (*pb.ListSeriesResponse, error) {
+	switch req.Kind {
+	case "", SeriesKindStored, SeriesKindDerived, SeriesKindVirtual:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid kind %q, expected %q, %q or %q",
+			req.Kind, SeriesKindStored, SeriesKindDerived, SeriesKindVirtual)
+	}
+	var matchers []*labels.Matcher
+	if req.Selector != "" {
+		var err error
+		matchers, err = labels.ParseSelector(req.Selector)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	catalog, err := s.seriesCatalog(ctx, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListSeriesResponse{}
+	for _, series := range catalog {
+		if (req.Kind == "" || series.Kind == req.Kind) && hasTags(series.Tags, req.Tags) {
+			resp.Series = append(resp.Series, series)
+		}
+	}
+	return resp, nil
+}
+
+// GetSeries returns a series of the catalog by name, as ListSeries lists
+// it.
+func (s *TimeSeriesService) GetSeries(
+	ctx context.Context,
+	req *pb.GetSeriesRequest,
+) (*pb.Series, error) {
+	if req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "name is required")
+	}
+
+	catalog, err := s.seriesCatalog(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, series := range catalog {
+		if series.Name == req.Name {
+			return series, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "unknown series %q", req.Name)
+}
+
+// resolveSelector returns the series a request with series and selector
+// names: the one selector selects, or series without a selector. Setting
+// both is rejected.
+func (s *TimeSeriesService) resolveSelector(ctx context.Context, series, selector string) (string, error) {
+	if selector == "" {
+		return series, nil
+	}
+	if series != "" {
+		return "", status.Errorf(codes.InvalidArgument, "series and selector cannot be combined")
+	}
+	return s.selectSeries(ctx, selector)
+}
+
+// selectSeries returns the name of the one series of the catalog a label
+// selector selects. Selectors matching no series fail with NotFound and
+// those matching several with InvalidArgument.
+func (s *TimeSeriesService) selectSeries(ctx context.Context, selector string) (string, error) {
+	matchers, err := labels.ParseSelector(selector)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	catalog, err := s.seriesCatalog(ctx, matchers)
+	if err != nil {
+		return "", err
+	}
+	switch len(catalog) {
+	case 0:
+		return "", status.Errorf(codes.NotFound, "no series matches selector %s", selector)
+	case 1:
+		return catalog[0].Name, nil
+	}
+	names := make([]string, len(catalog))
+	for i, series := range catalog {
+		names[i] = series.Name
+	}
+	return "", status.Errorf(codes.InvalidArgument, "selector %s matches %d series, %s; it must select one",
+		selector, len(catalog), strings.Join(names, ", "))
+}
+
+// selectAll returns the series of the catalog a label selector selects,
+// which derived must be able to compute. Selectors matching no series
+// fail with NotFound.
+func (s *TimeSeriesService) selectAll(ctx context.Context, selector string, derived *expression.Derived) ([]*pb.Series, error) {
+	matchers, err := labels.ParseSelector(selector)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	catalog, err := s.seriesCatalog(ctx, matchers)
+	if err != nil {
+		return nil, err
+	}
+	if len(catalog) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no series matches selector %s", selector)
+	}
+	for _, series := range catalog {
+		if series.Name != database.DefaultSeries && !derived.Has(series.Name) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown series: %s", series.Name)
+		}
+	}
+	return catalog, nil
+}
+
+// seriesCatalog returns the series queries may name that match every
+// label matcher, by name. The stored series are matched by the
+// repository.
+func (s *TimeSeriesService) seriesCatalog(ctx context.Context, matchers []*labels.Matcher) ([]*pb.Series, error) {
+	stored, err := s.repository.ListSeriesMetadata(ctx, matchers)
+	if err != nil {
+		return nil, storageError(err, "failed to read series metadata: %v", err)
+	}
+	virtual, err := s.repository.VirtualSeries(ctx)
+	if err != nil {
+		return nil, storageError(err, "failed to read virtual series: %v", err)
+	}
+
+	var catalog []*pb.Series
+	listed := make(map[string]bool)
+	for _, meta := range stored {
+		catalog = append(catalog, storedSeries(meta))
+		listed[meta.Series] = true
+	}
+	// The stored series has no metadata before its first sample, nor
+	// labels a selector could match
+	if !listed[database.DefaultSeries] && len(matchers) == 0 {
+		catalog = append(catalog, storedSeries(models.SeriesMetadata{Series: database.DefaultSeries}))
+	}
+
+	for _, name := range s.derived.Names() {
+		description := s.descriptions[name]
+		if !labels.MatchSeries(matchers, name, description.Tags) {
+			continue
+		}
+		catalog = append(catalog, &pb.Series{
+			Name:        name,
+			Kind:        SeriesKindDerived,
+			Unit:        description.Unit,
+			Description: description.Description,
+			Tags:        description.Tags,
+			Expression:  s.derived.Expression(name),
+		})
+	}
+	for _, v := range virtual {
+		if !labels.MatchSeries(matchers, v.Name, v.Tags) {
+			continue
+		}
+		catalog = append(catalog, &pb.Series{
+			Name:        v.Name,
+			Kind:        SeriesKindVirtual,
+			Unit:        v.Unit,
+			Description: v.Description,
+			Tags:        v.Tags,
+			Expression:  v.Expression,
+		})
+	}
+
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Name < catalog[j].Name })
+	return catalog, nil
+}
+
+// storedSeries returns the catalog entry of a stored series
+func storedSeries(meta models.SeriesMetadata) *pb.Series {
+	series := &pb.Series{
+		Name:        meta.Series,
+		Kind:        SeriesKindStored,
+		Unit:        meta.Unit,
+		Description: meta.Description,
+		Tags:        meta.Tags,
+		PointCount:  meta.PointCount,
+	}
+	if meta.PointCount > 0 {
+		series.FirstTime = timestamppb.New(meta.FirstTime)
+		series.LastTime = timestamppb.New(meta.LastTime)
+	}
+	return series
+}
+
+// hasTags reports whether tags include every one of required
+func hasTags(tags, required map[string]string) bool {
+	for key, value := range required {
+		if v, ok := tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
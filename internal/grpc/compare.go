@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+const (
+	// maxCompareOffsets caps the number of ranges a comparison adds to
+	// the requested one
+	maxCompareOffsets = 12
+	// compareParallelism is the number of ranges of a comparison queried
+	// at once
+	compareParallelism = 4
+	// currentLabel labels the requested range of a comparison
+	currentLabel = "current"
+)
+
+// offsetPattern matches comparison offsets: a negative whole number of
+// hours, days or weeks
+var offsetPattern = regexp.MustCompile(`^-([1-9][0-9]*)([hdw])$`)
+
+// offsetUnits are the durations of the units of comparison offsets
+var offsetUnits = map[string]time.Duration{
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// parseOffset returns the duration of a comparison offset, such as -7d
+func parseOffset(offset string) (time.Duration, error) {
+	match := offsetPattern.FindStringSubmatch(offset)
+	if match == nil {
+		return 0, fmt.Errorf("invalid offset %q, expected a negative number of hours, days or weeks, e.g. -7d", offset)
+	}
+	n, err := strconv.ParseInt(match[1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %v", offset, err)
+	}
+	return -time.Duration(n) * offsetUnits[match[2]], nil
+}
+
+// CompareTimeSeries aggregates a range and the same range shifted back by
+// each offset, such as this week and last week, so that clients do not
+// have to issue and stitch the queries themselves. The ranges are queried
+// in parallel, each as QueryTimeSeries would.
+//
+// Offsets are negative whole hours, days or weeks, e.g. "-7d" or "-52w",
+// and must be multiples of the window, so that the buckets of every range
+// fall on the same times once shifted. The response holds the requested
+// range labelled "current", then one series per offset, labelled with it,
+// whose bucket times are shifted forward by the offset onto the requested
+// range; the range each series was queried over is returned with it. A
+// selector is resolved to its series once, before the ranges are queried.
+// With weather_normalized, each range is normalized on its own, and the
+// model fitted to it is returned with its series.
+func (s *TimeSeriesService) CompareTimeSeries(
+	ctx context.Context,
+	req *pb.CompareRequest,
+) (*pb.CompareResponse, error) {
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+	if err := s.validator.Validate(start, end, req.Window, req.Aggregation); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	if len(req.Offsets) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "at least one offset is required")
+	}
+	if len(req.Offsets) > maxCompareOffsets {
+		return nil, status.Errorf(codes.InvalidArgument, "%d offsets exceed the limit of %d", len(req.Offsets), maxCompareOffsets)
+	}
+
+	labels := append([]string{currentLabel}, req.Offsets...)
+	offsets := make([]time.Duration, len(labels))
+	seen := make(map[time.Duration]string, len(labels))
+	for i, offset := range req.Offsets {
+		d, err := parseOffset(offset)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+		if d%windowDurations[req.Window] != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "offset %s is not a multiple of the window %s", offset, req.Window)
+		}
+		if other, ok := seen[d]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "offsets %s and %s are the same", other, offset)
+		}
+		seen[d] = offset
+		offsets[i+1] = d
+	}
+	seriesName, err := s.resolveSelector(ctx, req.Series, req.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]*pb.ComparedSeries, len(labels))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(compareParallelism)
+	for i := range labels {
+		group.Go(func() error {
+			shiftedStart, shiftedEnd := start.Add(offsets[i]), end.Add(offsets[i])
+			resp, err := s.QueryTimeSeries(groupCtx, &pb.TimeSeriesRequest{
+				Start:             timestamppb.New(shiftedStart),
+				End:               timestamppb.New(shiftedEnd),
+				Window:            req.Window,
+				Aggregation:       req.Aggregation,
+				Calendar:          req.Calendar,
+				Series:            seriesName,
+				Transform:         req.Transform,
+				WeatherNormalized: req.WeatherNormalized,
+			})
+			if err != nil {
+				return err
+			}
+			for _, point := range resp.Data {
+				point.Time = timestamppb.New(point.Time.AsTime().Add(-offsets[i]))
+			}
+			series[i] = &pb.ComparedSeries{
+				Label:        labels[i],
+				Start:        timestamppb.New(shiftedStart),
+				End:          timestamppb.New(shiftedEnd),
+				Data:         resp.Data,
+				Metadata:     resp.Metadata,
+				WeatherModel: resp.WeatherModel,
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	return &pb.CompareResponse{Series: series}, nil
+}
@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 const (
 	Window1m = "1m"
 	Window5m = "5m"
@@ -10,4 +12,27 @@ const (
 	AggregationMax = "MAX"
 	AggregationAvg = "AVG"
 	AggregationSum = "SUM"
+
+	// AggregationLoadFactor is the average over the peak of each bucket
+	AggregationLoadFactor = "LOAD_FACTOR"
+	// AggregationUtilization is the peak of each bucket over the
+	// contracted capacity of the series
+	AggregationUtilization = "UTILIZATION"
+
+	// TransformDelta is the difference between each bucket and the one
+	// before it
+	TransformDelta = "DELTA"
+	// TransformRate is the difference between each bucket and the one
+	// before it, per second between them
+	TransformRate = "RATE"
+	// TransformCumsum is the running sum of the buckets
+	TransformCumsum = "CUMSUM"
 )
+
+// windowDurations maps each supported window to its length
+var windowDurations = map[string]time.Duration{
+	Window1m: time.Minute,
+	Window5m: 5 * time.Minute,
+	Window1h: time.Hour,
+	Window1d: 24 * time.Hour,
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// windowsBySize lists the supported windows from the finest to the
+// coarsest
+var windowsBySize = []string{Window1m, Window5m, Window1h, Window1d}
+
+// chooseWindow returns the finest window whose buckets over [start, end]
+// number at most maxPoints, or the coarsest window if none does. A range
+// not aligned to the window touches one bucket more than it spans.
+func chooseWindow(start, end time.Time, maxPoints int) string {
+	span := end.Sub(start)
+	for _, window := range windowsBySize {
+		buckets := int64(span/windowDurations[window]) + 1
+		if span%windowDurations[window] != 0 {
+			buckets++
+		}
+		if buckets <= int64(maxPoints) {
+			return window
+		}
+	}
+	return windowsBySize[len(windowsBySize)-1]
+}
+
+// downsampleLTTB reduces points, ordered by time, to at most threshold
+// points with the Largest-Triangle-Three-Buckets algorithm, which keeps the
+// first and last points and the ones that most shape the plotted line. The
+// points returned are a subset of the input.
+func downsampleLTTB(points []models.TimeSeriesData, threshold int) []models.TimeSeriesData {
+	if threshold >= len(points) || threshold <= 0 {
+		return points
+	}
+	if threshold < 3 {
+		// Too few points for any interior bucket: keep the ends
+		return append([]models.TimeSeriesData{points[0]}, points[len(points)-1])[:threshold]
+	}
+
+	sampled := make([]models.TimeSeriesData, 0, threshold)
+	sampled = append(sampled, points[0])
+
+	// The interior points are split into threshold-2 buckets; one point
+	// is picked from each
+	every := float64(len(points)-2) / float64(threshold-2)
+	selected := 0
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket, the third vertex of the triangle
+		nextStart := int(math.Floor(float64(i+1)*every)) + 1
+		nextEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if nextEnd > len(points) {
+			nextEnd = len(points)
+		}
+		var avgX, avgY float64
+		for _, p := range points[nextStart:nextEnd] {
+			avgX += x(p)
+			avgY += p.Value
+		}
+		n := float64(nextEnd - nextStart)
+		avgX /= n
+		avgY /= n
+
+		// The point of this bucket forming the largest triangle with the
+		// previously selected point and the next bucket's average
+		start := int(math.Floor(float64(i)*every)) + 1
+		end := int(math.Floor(float64(i+1)*every)) + 1
+		ax, ay := x(points[selected]), points[selected].Value
+		maxArea := -1.0
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(points[j].Value-ay) - (ax-x(points[j]))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				selected = j
+			}
+		}
+		sampled = append(sampled, points[selected])
+	}
+
+	return append(sampled, points[len(points)-1])
+}
+
+// x is the horizontal coordinate of a point, in seconds
+func x(p models.TimeSeriesData) float64 {
+	return float64(p.Time.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestChooseWindow(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		span      time.Duration
+		maxPoints int
+		want      string
+	}{
+		{"an hour of minutes", time.Hour, 1000, Window1m},
+		{"a day of minutes is too many", 24 * time.Hour, 1000, Window5m},
+		{"a month", 30 * 24 * time.Hour, 1000, Window1h},
+		{"two years", 2 * 365 * 24 * time.Hour, 1000, Window1d},
+		{"coarsest window when nothing fits", 2 * 365 * 24 * time.Hour, 10, Window1d},
+		{"unaligned end counts a partial bucket", 59*time.Minute + 30*time.Second, 60, Window5m},
+		{"aligned end counts the bucket at end", 59 * time.Minute, 60, Window1m},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, chooseWindow(start, start.Add(tt.span), tt.maxPoints))
+		})
+	}
+}
+
+func TestDownsampleLTTB(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+
+	// A flat line with a single spike, which must survive downsampling
+	points := make([]models.TimeSeriesData, 1000)
+	for i := range points {
+		points[i] = models.TimeSeriesData{Time: start.Add(time.Duration(i) * time.Minute), Value: math.Sin(float64(i) / 50)}
+	}
+	points[437].Value = 100
+
+	sampled := downsampleLTTB(points, 100)
+	require.Len(t, sampled, 100)
+	assert.Equal(t, points[0], sampled[0])
+	assert.Equal(t, points[len(points)-1], sampled[len(sampled)-1])
+	assert.Contains(t, sampled, points[437])
+	for i := 1; i < len(sampled); i++ {
+		assert.True(t, sampled[i].Time.After(sampled[i-1].Time), "points stay in time order")
+	}
+
+	assert.Equal(t, points[:50], downsampleLTTB(points[:50], 100), "short series are unchanged")
+	assert.Equal(t, []models.TimeSeriesData{points[0], points[999]}, downsampleLTTB(points, 2))
+	assert.Equal(t, []models.TimeSeriesData{points[0]}, downsampleLTTB(points, 1))
+}
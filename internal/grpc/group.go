@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// maxSeriesGroups caps the number of groups a grouped query may return
+const maxSeriesGroups = 100
+
+// validateGroupBy checks that a QueryTimeSeries request may group the
+// series its selector selects by its group_by labels.
+//
+// Unlike the buckets, the groups are not formed by the database: the
+// series are expressions over the stored buckets evaluated by the
+// service, which SQL cannot group, so every group is combined in memory.
+// How many groups the labels form is only known once the selector is
+// resolved, so queryGroups refuses those forming more than
+// maxSeriesGroups groups, keeping the response and the work bounded.
+func validateGroupBy(req *pb.TimeSeriesRequest) error {
+	switch {
+	case req.Selector == "":
+		return fmt.Errorf("group_by requires a selector")
+	case req.Series != "":
+		return fmt.Errorf("series and group_by cannot be combined")
+	case req.PageSize != 0 || req.PageToken != "":
+		return fmt.Errorf("group_by cannot be combined with paging")
+	case req.MaxPoints != 0:
+		return fmt.Errorf("group_by cannot be combined with max_points")
+	case req.IncludeChecksum:
+		return fmt.Errorf("group_by cannot be combined with include_checksum")
+	case req.WeatherNormalized:
+		return fmt.Errorf("group_by cannot be combined with weather_normalized")
+	case req.Aggregation == AggregationLoadFactor || req.Aggregation == AggregationUtilization:
+		return fmt.Errorf("%s buckets of different series cannot be combined", req.Aggregation)
+	}
+	seen := make(map[string]bool, len(req.GroupBy))
+	for _, name := range req.GroupBy {
+		if !labels.ValidName(name) && name != labels.NameLabel {
+			return fmt.Errorf("invalid group_by label %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("group_by label %s is repeated", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// seriesGroup is a group of the series a selector selects, with the values
+// of the group_by labels it shares
+type seriesGroup struct {
+	values []string
+	group  *pb.SeriesGroup
+}
+
+// queryGroups answers a validated QueryTimeSeries request with group_by.
+// The series its selector selects are grouped by the values of the
+// group_by labels, and the series of each group are combined bucket by
+// bucket with the aggregation of the request: summed for SUM, averaged for
+// AVG, and their minimum or maximum for MIN and MAX. Every series is
+// computed from the buckets of the stored series, so one query serves all
+// groups, and each must pass validateDerived.
+func (s *TimeSeriesService) queryGroups(
+	ctx context.Context,
+	req *pb.TimeSeriesRequest,
+	start, end time.Time,
+	window string,
+	cal *calendar.Calendar,
+	derived *expression.Derived,
+) (*pb.TimeSeriesResponse, error) {
+	selected, err := s.selectAll(ctx, req.Selector, derived)
+	if err != nil {
+		return nil, err
+	}
+	for _, series := range selected {
+		if err := validateDerived(derived, series.Name, req.Aggregation); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+	}
+
+	var groups []*seriesGroup
+	byKey := make(map[string]*seriesGroup)
+	for _, series := range selected {
+		values := make([]string, len(req.GroupBy))
+		for i, name := range req.GroupBy {
+			values[i] = series.Tags[name]
+			if name == labels.NameLabel {
+				values[i] = series.Name
+			}
+		}
+		key := fmt.Sprintf("%q", values)
+		g, ok := byKey[key]
+		if !ok {
+			g = &seriesGroup{values: values, group: &pb.SeriesGroup{Labels: make(map[string]string)}}
+			for i, name := range req.GroupBy {
+				if values[i] != "" {
+					g.group.Labels[name] = values[i]
+				}
+			}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.group.Series = append(g.group.Series, series.Name)
+	}
+	if len(groups) > maxSeriesGroups {
+		return nil, status.Errorf(codes.InvalidArgument, "group_by forms %d groups, more than the %d a query may return", len(groups), maxSeriesGroups)
+	}
+	sort.Slice(groups, func(i, j int) bool { return slices.Compare(groups[i].values, groups[j].values) < 0 })
+
+	queryStart := time.Now()
+	buckets, err := s.queryBuckets(ctx, start, end, window, req.Aggregation, cal)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(queryStart)
+
+	resp := &pb.TimeSeriesResponse{}
+	for _, g := range groups {
+		points := combineSeries(derived, g.group.Series, req.Aggregation, buckets)
+		g.group.Data = toProtoDataPoints(applyTransform(req.Transform, points))
+		resp.Groups = append(resp.Groups, g.group)
+	}
+	resp.Metadata = queryMetadata(window, req.Aggregation, req.Calendar, nil, elapsed)
+	resp.Metadata.Transform = req.Transform
+	for _, b := range buckets {
+		resp.Metadata.TotalSamples += b.Count
+	}
+	return resp, nil
+}
+
+// combineSeries computes the series names from each bucket of the stored
+// series and combines them with aggregation. Buckets where none of them is
+// defined are omitted.
+func combineSeries(derived *expression.Derived, names []string, aggregation string, buckets []models.TimeSeriesData) []models.TimeSeriesData {
+	points := make([]models.TimeSeriesData, 0, len(buckets))
+	for _, b := range buckets {
+		combined, n := 0.0, 0
+		for _, name := range names {
+			v, ok := seriesValue(derived, name, aggregation, b)
+			if !ok {
+				continue
+			}
+			switch {
+			case n == 0:
+				combined = v
+			case aggregation == AggregationMin:
+				combined = math.Min(combined, v)
+			case aggregation == AggregationMax:
+				combined = math.Max(combined, v)
+			default:
+				combined += v
+			}
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		if aggregation == AggregationAvg {
+			combined /= float64(n)
+		}
+		b.Value = combined
+		points = append(points, b)
+	}
+	return points
+}
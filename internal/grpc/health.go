@@ -2,23 +2,89 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
-// HealthChecker implements the gRPC health checking protocol
+// healthCheckTimeout bounds how long a single health check may take
+const healthCheckTimeout = 2 * time.Second
+
+// Health policy defaults
+const (
+	// DefaultHealthCheckInterval is how often Run evaluates the health
+	// checks
+	DefaultHealthCheckInterval = 10 * time.Second
+	// DefaultHealthFailureThreshold is the number of consecutive failed
+	// evaluations before a serving service is marked NOT_SERVING
+	DefaultHealthFailureThreshold = 3
+)
+
+// HealthPolicy controls how Run evaluates the health checks.
+type HealthPolicy struct {
+	// Interval between evaluations; DefaultHealthCheckInterval when zero
+	Interval time.Duration
+	// FailureThreshold is the number of consecutive failed evaluations
+	// before a serving service is marked NOT_SERVING, so that a single
+	// slow ping does not turn it away; DefaultHealthFailureThreshold when
+	// zero. A service that is not serving turns SERVING as soon as its
+	// checks pass.
+	FailureThreshold int
+}
+
+// Validate checks that the policy's values are usable.
+func (p HealthPolicy) Validate() error {
+	if p.Interval < 0 {
+		return fmt.Errorf("check interval must not be negative")
+	}
+	if p.FailureThreshold < 0 {
+		return fmt.Errorf("failure threshold must not be negative")
+	}
+	return nil
+}
+
+// HealthCheck reports whether a dependency of a service is healthy. A nil
+// error means healthy.
+type HealthCheck func(ctx context.Context) error
+
+// namedHealthCheck is a registered HealthCheck
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// HealthChecker implements the gRPC health checking protocol, including
+// Watch streams notified of every status change.
+//
+// Services with checks registered by AddCheck are SERVING once all their
+// checks pass, as evaluated by Run, and NOT_SERVING once they have failed
+// as many times in a row as the HealthPolicy allows; other services keep
+// the status set with SetServingStatus. Shutdown marks every service
+// NOT_SERVING.
 type HealthChecker struct {
 	grpc_health_v1.UnimplementedHealthServer
-	mu     sync.RWMutex
-	status map[string]grpc_health_v1.HealthCheckResponse_ServingStatus
+	mu       sync.RWMutex
+	status   map[string]grpc_health_v1.HealthCheckResponse_ServingStatus
+	checks   map[string][]namedHealthCheck
+	watchers map[string]map[chan grpc_health_v1.HealthCheckResponse_ServingStatus]struct{}
+	shutdown bool
+	// failures counts the consecutive failed evaluations of each service
+	failures map[string]int
 }
 
 func NewHealthChecker() *HealthChecker {
 	return &HealthChecker{
-		status: make(map[string]grpc_health_v1.HealthCheckResponse_ServingStatus),
+		status:   make(map[string]grpc_health_v1.HealthCheckResponse_ServingStatus),
+		checks:   make(map[string][]namedHealthCheck),
+		watchers: make(map[string]map[chan grpc_health_v1.HealthCheckResponse_ServingStatus]struct{}),
+		failures: make(map[string]int),
 	}
 }
 
@@ -36,14 +102,209 @@ func (h *HealthChecker) Check(ctx context.Context, req *grpc_health_v1.HealthChe
 	return nil, status.Error(codes.NotFound, "unknown service")
 }
 
+// Watch sends the serving status of the requested service, and again
+// each time it changes, until the client cancels the call. Unknown
+// services are reported as SERVICE_UNKNOWN, and reported again once they
+// are registered. When the server shuts down, the stream ends with
+// Unavailable after reporting NOT_SERVING.
 func (h *HealthChecker) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
-	// Implement watching logic if needed
-	return status.Error(codes.Unimplemented, "watching is not supported")
+	// Only the latest status matters, so a watcher that falls behind
+	// skips the statuses it missed
+	updates := make(chan grpc_health_v1.HealthCheckResponse_ServingStatus, 1)
+
+	h.mu.Lock()
+	current, ok := h.status[req.Service]
+	if !ok {
+		current = grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	updates <- current
+	if h.watchers[req.Service] == nil {
+		h.watchers[req.Service] = make(map[chan grpc_health_v1.HealthCheckResponse_ServingStatus]struct{})
+	}
+	h.watchers[req.Service][updates] = struct{}{}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.watchers[req.Service], updates)
+		if len(h.watchers[req.Service]) == 0 {
+			delete(h.watchers, req.Service)
+		}
+		h.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case update := <-updates:
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: update}); err != nil {
+				return err
+			}
+			h.mu.RLock()
+			shutdown := h.shutdown
+			h.mu.RUnlock()
+			if shutdown {
+				return status.Error(codes.Unavailable, "server is shutting down")
+			}
+		}
+	}
 }
 
-// SetServingStatus sets the serving status of a service
+// SetServingStatus sets the serving status of a service. It has no effect
+// once Shutdown has been called.
 func (h *HealthChecker) SetServingStatus(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	if h.shutdown {
+		return
+	}
+	h.setStatus(service, status)
+}
+
+// setStatus sets the serving status of a service and notifies its
+// watchers if it changed, reporting whether it did. The caller must hold
+// h.mu.
+func (h *HealthChecker) setStatus(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus) bool {
+	if current, ok := h.status[service]; ok && current == status {
+		return false
+	}
 	h.status[service] = status
+	h.notify(service, status)
+	return true
+}
+
+// notify sends status to the watchers of service, replacing any status
+// they have not received yet. The caller must hold h.mu.
+func (h *HealthChecker) notify(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	for updates := range h.watchers[service] {
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- status
+	}
+}
+
+// Shutdown marks every service NOT_SERVING, so that watchers and probes
+// stop sending traffic, and ends the Watch streams. Later status changes
+// are ignored.
+func (h *HealthChecker) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shutdown = true
+	for service := range h.status {
+		h.status[service] = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	// Every watcher is notified, including those of services that were
+	// not serving already, so that their streams end
+	for service := range h.watchers {
+		current, ok := h.status[service]
+		if !ok {
+			current = grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		h.notify(service, current)
+	}
+}
+
+// AddCheck registers a check that must pass for service to be SERVING.
+// The service is NOT_SERVING until Run first finds all its checks
+// passing. It must be called before Run.
+func (h *HealthChecker) AddCheck(service, name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[service] = append(h.checks[service], namedHealthCheck{name: name, check: check})
+	if !h.shutdown {
+		h.setStatus(service, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// Run evaluates the registered checks as often as policy sets until ctx is
+// done, setting the serving status of the services they belong to and
+// logging its changes.
+func (h *HealthChecker) Run(ctx context.Context, policy HealthPolicy, logger *logrus.Logger) {
+	interval := policy.Interval
+	if interval == 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	threshold := policy.FailureThreshold
+	if threshold == 0 {
+		threshold = DefaultHealthFailureThreshold
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.evaluate(ctx, threshold, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// evaluate runs the registered checks once
+func (h *HealthChecker) evaluate(ctx context.Context, threshold int, logger *logrus.Logger) {
+	h.mu.RLock()
+	services := make([]string, 0, len(h.checks))
+	for service := range h.checks {
+		services = append(services, service)
+	}
+	h.mu.RUnlock()
+	sort.Strings(services)
+
+	for _, service := range services {
+		h.mu.RLock()
+		checks := h.checks[service]
+		h.mu.RUnlock()
+
+		var failures []string
+		for _, c := range checks {
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			err := c.check(checkCtx)
+			cancel()
+			if err != nil {
+				failures = append(failures, c.name+": "+err.Error())
+			}
+		}
+		// Checks interrupted by shutdown say nothing about the service
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.mu.Lock()
+		if h.shutdown {
+			h.mu.Unlock()
+			return
+		}
+		serving := grpc_health_v1.HealthCheckResponse_SERVING
+		consecutive := 0
+		if len(failures) > 0 {
+			h.failures[service]++
+			consecutive = h.failures[service]
+			if h.status[service] != grpc_health_v1.HealthCheckResponse_SERVING || consecutive >= threshold {
+				serving = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+			}
+		} else {
+			h.failures[service] = 0
+		}
+		changed := h.setStatus(service, serving)
+		h.mu.Unlock()
+
+		entry := logger.WithField("service", service)
+		switch {
+		case changed && len(failures) > 0:
+			entry.WithField("failures", strings.Join(failures, "; ")).Warn("Service is not serving")
+		case changed:
+			entry.Info("Service is serving")
+		case len(failures) > 0 && serving == grpc_health_v1.HealthCheckResponse_SERVING:
+			entry.WithFields(logrus.Fields{
+				"failures":    strings.Join(failures, "; "),
+				"consecutive": consecutive,
+				"threshold":   threshold,
+			}).Warn("Health checks failed")
+		}
+	}
 }
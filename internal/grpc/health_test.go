@@ -0,0 +1,199 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	server "github.com/tejusbharadwaj/edgecom/internal/grpc"
+)
+
+// startHealthServer serves checker and returns a client of it
+func startHealthServer(t *testing.T, checker *server.HealthChecker) grpc_health_v1.HealthClient {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, checker)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func TestHealthWatch(t *testing.T) {
+	const service = "edgecom.TimeSeriesService"
+
+	checker := server.NewHealthChecker()
+	checker.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	client := startHealthServer(t, checker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	overall, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	watch, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+
+	next := func(stream grpc_health_v1.Health_WatchClient) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	// The current status is sent first, unknown services included
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, next(overall))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, next(watch))
+
+	// Then each change, and only changes
+	checker.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_SERVING)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, next(watch))
+	checker.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_SERVING)
+	checker.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, next(watch))
+
+	// Shutdown reports every service not serving and ends the streams
+	checker.Shutdown()
+	for _, stream := range []grpc_health_v1.Health_WatchClient{overall, watch} {
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, next(stream))
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	}
+
+	checker.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
+
+func TestHealthChecks(t *testing.T) {
+	const service = "edgecom.TimeSeriesService"
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var dbDown atomic.Bool
+	dbDown.Store(true)
+	var bootstrapped atomic.Bool
+
+	checker := server.NewHealthChecker()
+	checker.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	checker.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_SERVING)
+	checker.AddCheck(service, "database", func(context.Context) error {
+		if dbDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	checker.AddCheck(service, "bootstrap", func(context.Context) error {
+		if !bootstrapped.Load() {
+			return errors.New("bootstrap in progress")
+		}
+		return nil
+	})
+	client := startHealthServer(t, checker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+	next := func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := watch.Recv()
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	// Services with checks are not serving until they pass
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, next())
+
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go checker.Run(runCtx, server.HealthPolicy{Interval: time.Millisecond, FailureThreshold: 1}, logger)
+
+	// Both the database and the bootstrap must be ready
+	dbDown.Store(false)
+	time.Sleep(10 * time.Millisecond)
+	bootstrapped.Store(true)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, next())
+
+	dbDown.Store(true)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, next())
+	dbDown.Store(false)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, next())
+
+	// Services without checks are unaffected
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestHealthFailureThreshold(t *testing.T) {
+	const service = "edgecom.TimeSeriesService"
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Each evaluation takes the result of its check from results, so that
+	// a send returns once the previous evaluation has completed; the
+	// evaluation of the value sent may or may not have completed too
+	results := make(chan error)
+	checker := server.NewHealthChecker()
+	checker.AddCheck(service, "database", func(ctx context.Context) error {
+		select {
+		case err := <-results:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	client := startHealthServer(t, checker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go checker.Run(ctx, server.HealthPolicy{Interval: time.Millisecond, FailureThreshold: 3}, logger)
+
+	check := func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	results <- nil
+	results <- errors.New("connection refused")
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check())
+
+	// Failures are counted only while consecutive
+	results <- errors.New("connection refused")
+	results <- nil
+	results <- errors.New("connection refused")
+	results <- errors.New("connection refused")
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check())
+
+	results <- errors.New("connection refused")
+	results <- errors.New("connection refused")
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, check())
+
+	// A single passing evaluation brings the service back
+	results <- nil
+	results <- nil
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check())
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// Auditor records completed calls, such as an *audit.Logger
+type Auditor interface {
+	AuditCall(ctx context.Context, method string, req interface{}, err error)
+}
+
+// NewAuditInterceptor records every unary call with its request and
+// outcome once it completes. It must follow the auth interceptor, so the
+// caller is known, and precede the authz interceptor, so denied calls are
+// recorded too. Health checks are not recorded.
+func NewAuditInterceptor(auditor Auditor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(ctx, req)
+		}
+		resp, err := handler(ctx, req)
+		auditor.AuditCall(ctx, info.FullMethod, req, err)
+		return resp, err
+	}
+}
+
+// NewStreamAuditInterceptor is the streaming counterpart of
+// NewAuditInterceptor. The first message the client sends is recorded as
+// the request.
+func NewStreamAuditInterceptor(auditor Auditor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(srv, ss)
+		}
+		stream := &auditedStream{ServerStream: ss}
+		err := handler(srv, stream)
+		auditor.AuditCall(ss.Context(), info.FullMethod, stream.first, err)
+		return err
+	}
+}
+
+// auditedStream remembers the first message received
+type auditedStream struct {
+	grpc.ServerStream
+	first interface{}
+}
+
+func (s *auditedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.first == nil {
+		s.first = m
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// auditedCall is a call recorded by a recordingAuditor
+type auditedCall struct {
+	method string
+	req    interface{}
+	code   codes.Code
+}
+
+// recordingAuditor remembers the calls it records
+type recordingAuditor struct {
+	calls []auditedCall
+}
+
+func (a *recordingAuditor) AuditCall(ctx context.Context, method string, req interface{}, err error) {
+	a.calls = append(a.calls, auditedCall{method: method, req: req, code: status.Code(err)})
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	auditor := &recordingAuditor{}
+	interceptor := NewAuditInterceptor(auditor)
+	call := func(method string, req interface{}, err error) {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, err
+		}
+		interceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	call("/edgecom.TimeSeriesService/QueryTimeSeries", "query", nil)
+	call("/edgecom.TimeSeriesService/InsertTimeSeries", "insert", status.Error(codes.PermissionDenied, "denied"))
+	call("/grpc.health.v1.Health/Check", "check", nil)
+
+	assert.Equal(t, []auditedCall{
+		{"/edgecom.TimeSeriesService/QueryTimeSeries", "query", codes.OK},
+		{"/edgecom.TimeSeriesService/InsertTimeSeries", "insert", codes.PermissionDenied},
+	}, auditor.calls, "health checks are not recorded")
+}
+
+// recvStream is a server stream receiving a fixed message
+type recvStream struct {
+	contextStream
+	msg string
+}
+
+func (s *recvStream) RecvMsg(m interface{}) error {
+	*m.(*string) = s.msg
+	return nil
+}
+
+func TestStreamAuditInterceptor(t *testing.T) {
+	auditor := &recordingAuditor{}
+	interceptor := NewStreamAuditInterceptor(auditor)
+	info := &grpc.StreamServerInfo{FullMethod: "/edgecom.TimeSeriesService/ExportTimeSeries"}
+
+	stream := &recvStream{contextStream: contextStream{ctx: context.Background()}, msg: "export"}
+	err := interceptor(nil, stream, info, func(srv interface{}, ss grpc.ServerStream) error {
+		var req string
+		ss.RecvMsg(&req)
+		return status.Error(codes.Unavailable, "database down")
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	if assert.Len(t, auditor.calls, 1) {
+		assert.Equal(t, info.FullMethod, auditor.calls[0].method)
+		assert.Equal(t, "export", *auditor.calls[0].req.(*string))
+		assert.Equal(t, codes.Unavailable, auditor.calls[0].code)
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// healthServicePrefix starts the methods of the gRPC health service, which
+// load balancers and orchestrators probe without credentials
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// Authenticator identifies the caller of a call from its incoming context,
+// such as an *auth.Authenticator, and returns the context carrying the
+// caller's identity
+type Authenticator interface {
+	Authenticate(ctx context.Context) (context.Context, error)
+}
+
+// NewAuthInterceptor rejects unary calls whose caller authenticator cannot
+// identify with Unauthenticated. Health checks are not authenticated.
+func NewAuthInterceptor(authenticator Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticator.Authenticate(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewStreamAuthInterceptor is the streaming counterpart of
+// NewAuthInterceptor.
+func NewStreamAuthInterceptor(authenticator Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticator.Authenticate(ss.Context())
+		if err != nil {
+			return status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticatedStream carries the caller's identity in its context
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type subjectKey struct{}
+
+// keyAuthenticator accepts calls with the key "s3cret"
+type keyAuthenticator struct{}
+
+func (keyAuthenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get("x-api-key"); len(keys) == 1 && keys[0] == "s3cret" {
+		return context.WithValue(ctx, subjectKey{}, "dashboard"), nil
+	}
+	return ctx, errors.New("missing or unrecognized credentials")
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	interceptor := NewAuthInterceptor(keyAuthenticator{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return ctx.Value(subjectKey{}), nil
+	}
+	call := func(ctx context.Context, method string) (interface{}, error) {
+		return interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+	authorized := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "s3cret"))
+
+	subject, err := call(authorized, "/edgecom.TimeSeriesService/QueryTimeSeries")
+	require.NoError(t, err)
+	assert.Equal(t, "dashboard", subject, "the handler sees the caller's identity")
+
+	_, err = call(context.Background(), "/edgecom.TimeSeriesService/QueryTimeSeries")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Contains(t, err.Error(), "missing or unrecognized credentials")
+
+	_, err = call(context.Background(), "/grpc.health.v1.Health/Check")
+	assert.NoError(t, err, "health checks are not authenticated")
+}
+
+// contextStream is a server stream with a fixed context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+func TestStreamAuthInterceptor(t *testing.T) {
+	interceptor := NewStreamAuthInterceptor(keyAuthenticator{})
+	info := &grpc.StreamServerInfo{FullMethod: "/edgecom.TimeSeriesService/ExportTimeSeries"}
+
+	var subject interface{}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		subject = ss.Context().Value(subjectKey{})
+		return nil
+	}
+
+	authorized := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "s3cret"))
+	require.NoError(t, interceptor(nil, &contextStream{ctx: authorized}, info, handler))
+	assert.Equal(t, "dashboard", subject)
+
+	err := interceptor(nil, &contextStream{ctx: context.Background()}, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
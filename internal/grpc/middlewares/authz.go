@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Authorizer decides whether the caller identified in ctx may call a
+// method, by its full name, such as an *auth.Policy
+type Authorizer interface {
+	Authorize(ctx context.Context, method string) error
+}
+
+// NewAuthzInterceptor rejects unary calls authorizer does not allow with
+// PermissionDenied. It must follow the auth interceptor, which identifies
+// the caller. Health checks are not authorized.
+func NewAuthzInterceptor(authorizer Authorizer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(ctx, req)
+		}
+		if err := authorizer.Authorize(ctx, info.FullMethod); err != nil {
+			return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewStreamAuthzInterceptor is the streaming counterpart of
+// NewAuthzInterceptor.
+func NewStreamAuthzInterceptor(authorizer Authorizer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(srv, ss)
+		}
+		if err := authorizer.Authorize(ss.Context(), info.FullMethod); err != nil {
+			return status.Errorf(codes.PermissionDenied, "%v", err)
+		}
+		return handler(srv, ss)
+	}
+}
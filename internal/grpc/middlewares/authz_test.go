@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// methodAuthorizer allows the dashboard to call its methods
+type methodAuthorizer map[string]bool
+
+func (a methodAuthorizer) Authorize(ctx context.Context, method string) error {
+	if ctx.Value(subjectKey{}) == "dashboard" && a[method] {
+		return nil
+	}
+	return errors.New("permission denied")
+}
+
+func TestAuthzInterceptor(t *testing.T) {
+	interceptor := NewAuthzInterceptor(methodAuthorizer{"/edgecom.TimeSeriesService/QueryTimeSeries": true})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	call := func(ctx context.Context, method string) (interface{}, error) {
+		return interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+	dashboard := context.WithValue(context.Background(), subjectKey{}, "dashboard")
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{"allowed", dashboard, "/edgecom.TimeSeriesService/QueryTimeSeries", codes.OK},
+		{"other method", dashboard, "/edgecom.TimeSeriesService/InsertTimeSeries", codes.PermissionDenied},
+		{"other caller", context.Background(), "/edgecom.TimeSeriesService/QueryTimeSeries", codes.PermissionDenied},
+		{"health check", context.Background(), "/grpc.health.v1.Health/Check", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := call(tt.ctx, tt.method)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestStreamAuthzInterceptor(t *testing.T) {
+	interceptor := NewStreamAuthzInterceptor(methodAuthorizer{"/edgecom.TimeSeriesService/ExportTimeSeries": true})
+	handler := func(srv interface{}, ss grpc.ServerStream) error { return nil }
+	dashboard := context.WithValue(context.Background(), subjectKey{}, "dashboard")
+
+	info := &grpc.StreamServerInfo{FullMethod: "/edgecom.TimeSeriesService/ExportTimeSeries"}
+	require.NoError(t, interceptor(nil, &contextStream{ctx: dashboard}, info, handler))
+
+	info = &grpc.StreamServerInfo{FullMethod: "/edgecom.TimeSeriesService/ImportTimeSeries"}
+	err := interceptor(nil, &contextStream{ctx: dashboard}, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
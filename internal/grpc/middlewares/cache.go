@@ -4,45 +4,526 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// DefaultCacheMaxBytes is the default memory budget of the response cache
+const DefaultCacheMaxBytes = 64 * 1024 * 1024
+
+// DefaultErrorTTL is how long deterministic errors are cached by default
+const DefaultErrorTTL = 5 * time.Second
+
+// deterministicCodes are the codes of errors that a request fails with
+// whatever the state of the service, so they may be cached: repeating the
+// request cannot succeed
+var deterministicCodes = map[codes.Code]bool{
+	codes.InvalidArgument: true,
+	codes.OutOfRange:      true,
+	codes.Unimplemented:   true,
+}
+
+// storageFailureCodes are the codes of errors a call fails with while the
+// database cannot serve it, for which a stale response may be served
+// instead
+var storageFailureCodes = map[codes.Code]bool{
+	codes.Unavailable: true,
+	codes.Internal:    true,
+}
+
+// Headers sent with stale responses
+const (
+	// HeaderStale is set to "true" on responses served from the cache
+	// after the data they were computed from changed, because the call
+	// failed
+	HeaderStale = "x-edgecom-stale"
+	// HeaderStaleAge is the number of seconds since the response became
+	// stale
+	HeaderStaleAge = "x-edgecom-stale-age"
+)
+
+// cacheEntryOverhead approximates the memory an entry takes beyond its key
+// and response: the list element, map slot and interface headers
+const cacheEntryOverhead = 128
+
 type Cache struct {
-	cache *lru.Cache
+	cache    *lru.Cache
+	excluded map[string]bool
+	scopeOf  ScopeFunc
+	// errorTTL and maxStale are durations, changed while serving
+	errorTTL atomic.Int64
+	maxStale atomic.Int64
+	now      func() time.Time
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	stale    atomic.Uint64
+
+	// mu serializes additions, so that the size of the entries is tracked
+	// exactly; bytes is only changed under it, by add and onEvict
+	mu        sync.Mutex
+	maxBytes  int64
+	bytes     atomic.Int64
+	evictions atomic.Uint64
+	// generation is incremented by every invalidation, so that responses
+	// computed while one happened, possibly from the data it replaced, are
+	// not cached
+	generation atomic.Uint64
+	// written is set, under mu, once data has been invalidated for, and
+	// spans all data stored since the cache was created, from writtenFrom
+	// to writtenTo; responses restored from another process's handoff
+	// are checked against it
+	written                bool
+	writtenFrom, writtenTo time.Time
+}
+
+// cacheEntry is a cached response, or a deterministic error until it
+// expires, with its approximate size in bytes
+type cacheEntry struct {
+	resp    interface{}
+	err     error
+	expires time.Time
+	size    int64
+	// staleSince is when the response was invalidated, kept to be served
+	// if the call fails; zero for current responses
+	staleSince time.Time
+	// ranged is set for requests over a time range, from start to end; a
+	// zero end leaves the range open
+	ranged     bool
+	start, end time.Time
+}
+
+// changedBy reports whether data stored from start to end, inclusive, may
+// have changed the entry: whether its range overlaps it, or it has none
+func (e cacheEntry) changedBy(start, end time.Time) bool {
+	return !e.ranged || (!end.Before(e.start) && (e.end.IsZero() || start.Before(e.end)))
+}
+
+// ScopeFunc returns the scope of a call that responses are shared within,
+// such as its caller's identity, or an empty string for calls whose
+// responses any caller may share.
+type ScopeFunc func(ctx context.Context) string
+
+// noScope shares responses among all callers
+func noScope(context.Context) string { return "" }
+
+// rangedRequest is a request over a time range, such as a query
+type rangedRequest interface {
+	GetStart() *timestamppb.Timestamp
+	GetEnd() *timestamppb.Timestamp
+}
+
+// CacheStats is a point-in-time snapshot of cache effectiveness.
+type CacheStats struct {
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+	Entries   int     `json:"entries"`
+	Bytes     int64   `json:"bytes"`
+	MaxBytes  int64   `json:"max_bytes"`
+	Evictions uint64  `json:"evictions"`
+	Stale     uint64  `json:"stale"`
 }
 
 // This in-memory cache is used for simplicity purpose. It can be replaced with Redis.
 // golang-lru Automatically evicts the least recently accessed items, ensuring efficient memory usage.
 
+// NewCache creates a cache holding up to size entries whose approximate
+// sizes add up to at most DefaultCacheMaxBytes, or the budget set with
+// SetMaxBytes, evicting the least recently used entries to stay within
+// both.
 func NewCache(size int) (*Cache, error) {
-	c, err := lru.New(size)
+	c := &Cache{
+		excluded: make(map[string]bool),
+		scopeOf:  noScope,
+		now:      time.Now,
+		maxBytes: DefaultCacheMaxBytes,
+	}
+	c.errorTTL.Store(int64(DefaultErrorTTL))
+	cache, err := lru.NewWithEvict(size, c.onEvict)
 	if err != nil {
 		return nil, err
 	}
-	return &Cache{cache: c}, nil
+	c.cache = cache
+	return c, nil
+}
+
+// SetMaxBytes sets the memory budget of the cache, the approximate total
+// size of the cached responses and their keys. Responses larger than the
+// budget are not cached. It must be called before the interceptor starts
+// serving requests.
+func (c *Cache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+}
+
+// Exclude disables caching for the given full method names, for RPCs whose
+// responses change independently of the request. It must be called before
+// the interceptor starts serving requests.
+func (c *Cache) Exclude(methods ...string) {
+	for _, method := range methods {
+		c.excluded[method] = true
+	}
+}
+
+// SetErrorTTL sets how long errors that do not depend on the state of the
+// service, such as InvalidArgument, are cached, so that clients repeating
+// an invalid request do not reach the handler each time. Zero disables
+// caching errors. It defaults to DefaultErrorTTL and may be called while
+// the interceptor serves requests; errors cached before keep their expiry.
+func (c *Cache) SetErrorTTL(ttl time.Duration) {
+	c.errorTTL.Store(int64(ttl))
+}
+
+// ErrorTTL returns how long errors are cached.
+func (c *Cache) ErrorTTL() time.Duration {
+	return time.Duration(c.errorTTL.Load())
+}
+
+// SetScopeFunc keeps cached responses to the scope of the calls that read
+// them, so that a response is never served to a caller outside it. Without
+// one, responses are shared by all callers. It must be called before the
+// interceptor starts serving requests.
+func (c *Cache) SetScopeFunc(scopeOf ScopeFunc) {
+	c.scopeOf = scopeOf
+}
+
+// SetServeStale keeps invalidated responses for up to maxStaleness after
+// they are invalidated, and serves them, flagged with HeaderStale and
+// HeaderStaleAge, to calls failing with Unavailable or Internal, such as
+// while the database is unreachable. Zero, the default, removes responses
+// as they are invalidated. It may be called while the interceptor serves
+// requests.
+func (c *Cache) SetServeStale(maxStaleness time.Duration) {
+	c.maxStale.Store(int64(maxStaleness))
+}
+
+// MaxStaleness returns how long invalidated responses are served.
+func (c *Cache) MaxStaleness() time.Duration {
+	return time.Duration(c.maxStale.Load())
 }
 
 func (c *Cache) InterceptorFunc() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		key := generateCacheKey(info.FullMethod, req)
+		if c.excluded[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		key, ok := generateCacheKey(c.scopeOf(ctx), info.FullMethod, req)
+		if !ok {
+			return handler(ctx, req)
+		}
 
-		if cachedResp, ok := c.cache.Get(key); ok {
-			return cachedResp, nil
+		// Stale responses are only served if the call fails
+		var stale *cacheEntry
+		if cached, ok := c.cache.Get(key); ok {
+			entry := cached.(cacheEntry)
+			switch {
+			case !entry.staleSince.IsZero():
+				stale = &entry
+			case entry.err == nil:
+				c.hits.Add(1)
+				return entry.resp, nil
+			case c.now().Before(entry.expires):
+				c.hits.Add(1)
+				return nil, entry.err
+			default:
+				c.remove(key)
+			}
 		}
+		c.misses.Add(1)
 
+		generation := c.generation.Load()
 		resp, err := handler(ctx, req)
 		if err != nil {
+			if stale != nil && storageFailureCodes[status.Code(err)] {
+				if age := c.now().Sub(stale.staleSince); age <= c.MaxStaleness() {
+					c.stale.Add(1)
+					// Fails only outside a gRPC server, as in tests
+					_ = grpc.SetHeader(ctx, metadata.Pairs(
+						HeaderStale, "true",
+						HeaderStaleAge, strconv.FormatInt(int64(age.Seconds()), 10),
+					))
+					return markStale(stale.resp, age), nil
+				}
+				c.remove(key)
+			}
+			if ttl := c.ErrorTTL(); ttl > 0 && deterministicCodes[status.Code(err)] {
+				c.add(key, req, cacheEntry{err: err, expires: c.now().Add(ttl)}, generation)
+			}
 			return nil, err
 		}
 
-		c.cache.Add(key, resp)
+		c.add(key, req, cacheEntry{resp: resp}, generation)
 		return resp, nil
 	}
 }
 
-func generateCacheKey(method string, req interface{}) string {
-	reqBytes, _ := json.Marshal(req)
-	return fmt.Sprintf("%s:%s", method, string(reqBytes))
+// add caches entry, the response or error of req computed in generation,
+// under key
+func (c *Cache) add(key string, req interface{}, entry cacheEntry, generation uint64) {
+	if ranged, ok := req.(rangedRequest); ok {
+		entry.ranged = true
+		if start := ranged.GetStart(); start != nil {
+			entry.start = start.AsTime()
+		}
+		if end := ranged.GetEnd(); end != nil {
+			entry.end = end.AsTime()
+		}
+	}
+	c.store(key, entry, generation)
+}
+
+// store caches entry, computed in generation, under key, evicting the
+// least recently used entries until the cache is within its memory budget.
+// Entries of an earlier generation are not cached.
+func (c *Cache) store(key string, entry cacheEntry, generation uint64) {
+	size := int64(len(key)) + cacheEntryOverhead
+	if entry.err != nil {
+		size += int64(len(entry.err.Error()))
+	} else {
+		size += responseSize(entry.resp)
+	}
+	if size > c.maxBytes {
+		return
+	}
+	entry.size = size
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation.Load() != generation {
+		return
+	}
+	// Replacing an entry does not evict it, so its size is released here
+	if old, ok := c.cache.Peek(key); ok {
+		c.bytes.Add(-old.(cacheEntry).size)
+	}
+	c.cache.Add(key, entry)
+	c.bytes.Add(size)
+	for c.bytes.Load() > c.maxBytes {
+		if _, _, ok := c.cache.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+// remove drops the expired or too stale entry under key, which is not
+// counted as an eviction
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	evictions := c.evictions.Load()
+	c.cache.Remove(key)
+	c.evictions.Store(evictions)
+}
+
+// onEvict releases the size of an entry removed from the cache
+func (c *Cache) onEvict(_ interface{}, value interface{}) {
+	c.bytes.Add(-value.(cacheEntry).size)
+	c.evictions.Add(1)
+}
+
+// responseSize approximates the memory a response takes by its encoded
+// size, which is close to it for the repeated points of large responses
+func responseSize(resp interface{}) int64 {
+	if msg, ok := resp.(proto.Message); ok {
+		return int64(proto.Size(msg))
+	}
+	encoded, _ := json.Marshal(resp)
+	return int64(len(encoded))
+}
+
+// Purge removes every cached response, so that later requests are served
+// from the repository, and returns the number of responses removed.
+// Purged responses are not counted as evictions.
+func (c *Cache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation.Add(1)
+	evictions := c.evictions.Load()
+	entries := c.cache.Len()
+	c.cache.Purge()
+	c.evictions.Store(evictions)
+	return entries
+}
+
+// Invalidate removes the cached responses that data stored from start to
+// end, inclusive, may have changed: those of requests over ranges
+// overlapping it, and those of requests without a range, such as for the
+// latest point. With SetServeStale, responses are kept as stale instead,
+// until they are older than its bound. It returns the number of responses
+// invalidated; removed ones are not counted as evictions.
+//
+// It must be called once the data has been stored: responses being
+// computed meanwhile are not cached. Entries are checked one at a time, so
+// that calls are not held up by the whole scan.
+func (c *Cache) Invalidate(start, end time.Time) int {
+	c.mu.Lock()
+	c.generation.Add(1)
+	if !c.written || start.Before(c.writtenFrom) {
+		c.writtenFrom = start
+	}
+	if !c.written || end.After(c.writtenTo) {
+		c.writtenTo = end
+	}
+	c.written = true
+	c.mu.Unlock()
+	now := c.now()
+	maxStale := c.MaxStaleness()
+	invalidated := 0
+	for _, key := range c.cache.Keys() {
+		if c.invalidate(key, start, end, now, maxStale) {
+			invalidated++
+		}
+	}
+	return invalidated
+}
+
+// invalidate invalidates the entry under key if it may have been changed by
+// data stored from start to end, and reports whether it did
+func (c *Cache) invalidate(key interface{}, start, end, now time.Time, maxStale time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.cache.Peek(key)
+	if !ok {
+		return false
+	}
+	entry := value.(cacheEntry)
+	if !entry.changedBy(start, end) {
+		return false
+	}
+	evictions := c.evictions.Load()
+	defer c.evictions.Store(evictions)
+	switch {
+	case maxStale <= 0 || entry.err != nil:
+		c.cache.Remove(key)
+		return true
+	case entry.staleSince.IsZero():
+		// Replacing the entry keeps its size and does not evict it
+		entry.staleSince = now
+		c.cache.Add(key, entry)
+		return true
+	case now.Sub(entry.staleSince) > maxStale:
+		c.cache.Remove(key)
+	}
+	return false
+}
+
+// Stats returns the cache hit/miss counters and current size.
+func (c *Cache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Entries:   c.cache.Len(),
+		Bytes:     c.bytes.Load(),
+		MaxBytes:  c.maxBytes,
+		Evictions: c.evictions.Load(),
+		Stale:     c.stale.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// RegisterMetrics registers the cache's hit, miss, eviction and stale
+// response counters and its size with reg, read from the same counters as
+// Stats.
+func (c *Cache) RegisterMetrics(reg prometheus.Registerer) error {
+	collectors := []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "edgecom_response_cache_hits_total",
+			Help: "Calls answered from the response cache",
+		}, func() float64 { return float64(c.hits.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "edgecom_response_cache_misses_total",
+			Help: "Cacheable calls the response cache could not answer",
+		}, func() float64 { return float64(c.misses.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "edgecom_response_cache_evictions_total",
+			Help: "Responses evicted from the response cache to stay within its limits",
+		}, func() float64 { return float64(c.evictions.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "edgecom_response_cache_stale_served_total",
+			Help: "Stale responses served from the response cache to calls that failed",
+		}, func() float64 { return float64(c.stale.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "edgecom_response_cache_entries",
+			Help: "Responses held by the response cache",
+		}, func() float64 { return float64(c.cache.Len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "edgecom_response_cache_bytes",
+			Help: "Approximate memory held by the response cache",
+		}, func() float64 { return float64(c.bytes.Load()) }),
+	}
+	for _, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			return fmt.Errorf("failed to register response cache metric: %v", err)
+		}
+	}
+	return nil
+}
+
+// generateCacheKey returns the key identifying the response to req, a call
+// to method within scope, or false if req cannot be encoded. Messages are
+// encoded in their deterministic wire format, so that equal requests share
+// a key however they were encoded by the caller, while requests differing
+// in any field, unknown ones included, do not. The scope is length
+// prefixed, so that no scope and request can produce the key of another.
+func generateCacheKey(scope, method string, req interface{}) (string, bool) {
+	var encoded []byte
+	var err error
+	if msg, ok := req.(proto.Message); ok {
+		encoded, err = proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	} else {
+		encoded, err = json.Marshal(req)
+	}
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s\x00%d:%s\x00%s", method, len(scope), scope, encoded), true
+}
+
+// Fields of the response metadata flagging stale responses, such as
+// TimeSeriesResponse.metadata.stale
+const (
+	metadataField protoreflect.Name = "metadata"
+	staleField    protoreflect.Name = "stale"
+	staleAgeField protoreflect.Name = "stale_age"
+)
+
+// markStale returns a copy of resp, a stale response invalidated age ago,
+// with stale and stale_age set in its metadata, or resp itself if it
+// carries no such metadata. The cached response is left unchanged.
+func markStale(resp interface{}, age time.Duration) interface{} {
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		return resp
+	}
+	field := msg.ProtoReflect().Descriptor().Fields().ByName(metadataField)
+	if field == nil || field.Message() == nil {
+		return resp
+	}
+	stale := field.Message().Fields().ByName(staleField)
+	staleAge := field.Message().Fields().ByName(staleAgeField)
+	if stale == nil || staleAge == nil {
+		return resp
+	}
+
+	marked := proto.Clone(msg)
+	md := marked.ProtoReflect().Mutable(field).Message()
+	md.Set(stale, protoreflect.ValueOfBool(true))
+	md.Set(staleAge, protoreflect.ValueOfMessage(durationpb.New(age).ProtoReflect()))
+	return marked
 }
@@ -2,13 +2,21 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/tejusbharadwaj/edgecom/proto"
 )
 
 // Mock request for testing
@@ -19,6 +27,97 @@ type mockRequest struct {
 	Aggregation string
 }
 
+// testCacheKey returns the key of req, a call to method without a scope
+func testCacheKey(method string, req interface{}) string {
+	key, _ := generateCacheKey("", method, req)
+	return key
+}
+
+// scopeKey is the context key of the scope in tests
+type scopeKey struct{}
+
+// scopeOf returns the scope of a test context
+func scopeOf(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	return scope
+}
+
+func TestGenerateCacheKey(t *testing.T) {
+	const method = "/test.Service/Query"
+	day := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	newRequest := func() *pb.TimeSeriesRequest {
+		return &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(day),
+			End:         timestamppb.New(day.Add(24 * time.Hour)),
+			Window:      "1h",
+			Aggregation: "AVG",
+		}
+	}
+	key := func(scope string, req interface{}) string {
+		k, ok := generateCacheKey(scope, method, req)
+		require.True(t, ok)
+		return k
+	}
+
+	t.Run("equal requests share a key", func(t *testing.T) {
+		// A request decoded from a different field order equals the original
+		encoded, err := proto.Marshal(newRequest())
+		require.NoError(t, err)
+		var reordered []byte
+		for len(encoded) > 0 {
+			_, _, n := protowire.ConsumeField(encoded)
+			require.Positive(t, n)
+			reordered = append(append([]byte{}, encoded[:n]...), reordered...)
+			encoded = encoded[n:]
+		}
+		decoded := &pb.TimeSeriesRequest{}
+		require.NoError(t, proto.Unmarshal(reordered, decoded))
+
+		assert.Equal(t, key("", newRequest()), key("", decoded))
+	})
+
+	t.Run("distinct requests do not collide", func(t *testing.T) {
+		withUnknown := newRequest()
+		withUnknown.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 99, protowire.VarintType), 1))
+		requests := []proto.Message{
+			newRequest(),
+			&pb.TimeSeriesRequest{Start: timestamppb.New(day), End: timestamppb.New(day.Add(24 * time.Hour)), Window: "1h", Aggregation: "MAX"},
+			&pb.TimeSeriesRequest{Start: timestamppb.New(day), End: timestamppb.New(day.Add(24 * time.Hour)), Window: "1hA", Aggregation: "VG"},
+			&pb.TimeSeriesRequest{Start: timestamppb.New(day.Add(time.Nanosecond)), End: timestamppb.New(day.Add(24 * time.Hour)), Window: "1h", Aggregation: "AVG"},
+			&pb.StatisticsRequest{Start: timestamppb.New(day), End: timestamppb.New(day.Add(24 * time.Hour))},
+			withUnknown,
+		}
+		keys := make(map[string]int)
+		for i, req := range requests {
+			k := key("", req)
+			if j, ok := keys[k]; ok {
+				t.Fatalf("requests %d and %d share a key", j, i)
+			}
+			keys[k] = i
+		}
+	})
+
+	t.Run("scopes do not collide", func(t *testing.T) {
+		req := newRequest()
+		scopes := []string{"", "static:dashboard", "static:reports", "jwt:dashboard", "1:a", "a", "a\x00", "static:dashboard\x00"}
+		keys := make(map[string]string)
+		for _, scope := range scopes {
+			k := key(scope, req)
+			if other, ok := keys[k]; ok {
+				t.Fatalf("scopes %q and %q share a key", other, scope)
+			}
+			keys[k] = scope
+		}
+		// Nor can a scope pass for part of another request
+		assert.NotEqual(t, key("a", &mockRequest{Window: "b"}), key("", &mockRequest{Window: "b"}))
+	})
+
+	t.Run("requests that cannot be encoded", func(t *testing.T) {
+		_, ok := generateCacheKey("", method, &pb.TimeSeriesRequest{Window: "\xff"})
+		assert.False(t, ok)
+	})
+}
+
 func TestCache(t *testing.T) {
 	t.Run("cache operations", func(t *testing.T) {
 		// Initialize cache
@@ -70,6 +169,12 @@ func TestCache(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "response", resp3)
 		assert.Equal(t, 2, callCount)
+
+		stats := cache.Stats()
+		assert.Equal(t, uint64(1), stats.Hits)
+		assert.Equal(t, uint64(2), stats.Misses)
+		assert.InDelta(t, 1.0/3.0, stats.HitRate, 0.001)
+		assert.Equal(t, 2, stats.Entries)
 	})
 
 	t.Run("cache eviction", func(t *testing.T) {
@@ -98,7 +203,7 @@ func TestCache(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Verify first request was evicted
-		key := generateCacheKey(info.FullMethod, req1)
+		key := testCacheKey(info.FullMethod, req1)
 		_, ok := cache.cache.Get(key)
 		assert.False(t, ok, "First request should have been evicted")
 	})
@@ -127,8 +232,364 @@ func TestCache(t *testing.T) {
 		assert.Nil(t, resp)
 
 		// Verify the error response wasn't cached
-		key := generateCacheKey(info.FullMethod, req)
+		key := testCacheKey(info.FullMethod, req)
 		_, ok := cache.cache.Get(key)
 		assert.False(t, ok, "Error responses should not be cached")
 	})
+
+	t.Run("deterministic errors are cached briefly", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+		now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		calls := 0
+		code := codes.InvalidArgument
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls++
+			return nil, status.Errorf(code, "invalid window")
+		}
+		interceptor := cache.InterceptorFunc()
+		req := &mockRequest{Window: "7m"}
+
+		for i := 0; i < 2; i++ {
+			_, err := interceptor(context.Background(), req, info, handler)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Equal(t, "invalid window", status.Convert(err).Message())
+		}
+		assert.Equal(t, 1, calls, "the error is served from the cache")
+		assert.Equal(t, uint64(1), cache.Stats().Hits)
+
+		// Expired errors reach the handler again
+		now = now.Add(DefaultErrorTTL)
+		_, err = interceptor(context.Background(), req, info, handler)
+		assert.Error(t, err)
+		assert.Equal(t, 2, calls)
+
+		// Errors that depend on the state of the service are not cached
+		code = codes.Unavailable
+		other := &mockRequest{Window: "1h"}
+		for i := 0; i < 2; i++ {
+			_, err = interceptor(context.Background(), other, info, handler)
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+		}
+		assert.Equal(t, 4, calls)
+
+		// Nor is anything when disabled
+		cache.SetErrorTTL(0)
+		code = codes.InvalidArgument
+		third := &mockRequest{Window: "2h"}
+		for i := 0; i < 2; i++ {
+			_, err = interceptor(context.Background(), third, info, handler)
+			assert.Error(t, err)
+		}
+		assert.Equal(t, 6, calls)
+	})
+	t.Run("excluded method", func(t *testing.T) {
+		cache, err := NewCache(2)
+		require.NoError(t, err)
+		cache.Exclude("/test.Service/Latest")
+
+		info := &grpc.UnaryServerInfo{
+			FullMethod: "/test.Service/Latest",
+		}
+
+		callCount := 0
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			callCount++
+			return "response", nil
+		}
+
+		interceptor := cache.InterceptorFunc()
+		req := &mockRequest{Window: "1h"}
+
+		for i := 0; i < 2; i++ {
+			_, err := interceptor(context.Background(), req, info, handler)
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, callCount, "Excluded methods should always reach the handler")
+		assert.Equal(t, CacheStats{MaxBytes: DefaultCacheMaxBytes}, cache.Stats())
+	})
+
+	t.Run("memory budget", func(t *testing.T) {
+		cache, err := NewCache(100)
+		require.NoError(t, err)
+		cache.SetMaxBytes(4096)
+
+		info := &grpc.UnaryServerInfo{
+			FullMethod: "/test.Service/Method",
+		}
+		// Responses of the requested number of points
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			n, _ := strconv.Atoi(req.(*mockRequest).Window)
+			resp := &pb.TimeSeriesResponse{}
+			for i := 0; i < n; i++ {
+				resp.Data = append(resp.Data, &pb.TimeSeriesDataPoint{Time: timestamppb.Now(), Value: float64(i)})
+			}
+			return resp, nil
+		}
+		interceptor := cache.InterceptorFunc()
+		query := func(points int) {
+			_, err := interceptor(context.Background(), &mockRequest{Window: strconv.Itoa(points)}, info, handler)
+			require.NoError(t, err)
+		}
+
+		for _, points := range []int{10, 20, 30} {
+			query(points)
+		}
+		stats := cache.Stats()
+		assert.Equal(t, 3, stats.Entries)
+		assert.LessOrEqual(t, stats.Bytes, int64(4096))
+
+		// A large response evicts the least recently used entries
+		query(10)
+		query(100)
+		stats = cache.Stats()
+		assert.LessOrEqual(t, stats.Bytes, int64(4096))
+		assert.NotZero(t, stats.Evictions)
+		_, ok := cache.cache.Peek(testCacheKey(info.FullMethod, &mockRequest{Window: "20"}))
+		assert.False(t, ok, "least recently used entry should have been evicted")
+		_, ok = cache.cache.Peek(testCacheKey(info.FullMethod, &mockRequest{Window: "10"}))
+		assert.True(t, ok, "recently used entry should have been kept")
+
+		// Responses over the budget are not cached
+		query(1000)
+		_, ok = cache.cache.Peek(testCacheKey(info.FullMethod, &mockRequest{Window: "1000"}))
+		assert.False(t, ok)
+		assert.LessOrEqual(t, cache.Stats().Bytes, int64(4096))
+
+		// Removed entries release their sizes
+		cache.cache.Purge()
+		assert.Zero(t, cache.Stats().Bytes)
+	})
+
+	t.Run("responses are kept to their scope", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+		cache.SetScopeFunc(scopeOf)
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "response for " + scopeOf(ctx), nil
+		}
+		interceptor := cache.InterceptorFunc()
+		req := &pb.LatestRequest{Count: 1}
+		for _, scope := range []string{"static:a", "static:b", "static:a"} {
+			resp, err := interceptor(context.WithValue(context.Background(), scopeKey{}, scope), req, info, handler)
+			require.NoError(t, err)
+			assert.Equal(t, "response for "+scope, resp)
+		}
+		stats := cache.Stats()
+		assert.Equal(t, uint64(1), stats.Hits)
+		assert.Equal(t, uint64(2), stats.Misses)
+	})
+
+	t.Run("requests that cannot be encoded are not cached", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		callCount := 0
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			callCount++
+			return "response", nil
+		}
+		for i := 0; i < 2; i++ {
+			_, err := cache.InterceptorFunc()(context.Background(), &pb.TimeSeriesRequest{Window: "\xff"}, info, handler)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, callCount)
+		assert.Zero(t, cache.Stats().Entries)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+
+		info := &grpc.UnaryServerInfo{
+			FullMethod: "/test.Service/Method",
+		}
+		callCount := 0
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			callCount++
+			return "response", nil
+		}
+		interceptor := cache.InterceptorFunc()
+		for _, window := range []string{"1h", "2h", "1h"} {
+			_, err := interceptor(context.Background(), &mockRequest{Window: window}, info, handler)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2, callCount)
+
+		assert.Equal(t, 2, cache.Purge())
+		stats := cache.Stats()
+		assert.Zero(t, stats.Entries)
+		assert.Zero(t, stats.Bytes)
+		assert.Zero(t, stats.Evictions, "purged entries are not evictions")
+
+		// Purged responses are read again
+		_, err = interceptor(context.Background(), &mockRequest{Window: "1h"}, info, handler)
+		require.NoError(t, err)
+		assert.Equal(t, 3, callCount)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "response", nil
+		}
+		interceptor := cache.InterceptorFunc()
+		day := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+		for _, req := range []interface{}{
+			&pb.TimeSeriesRequest{Start: timestamppb.New(day), End: timestamppb.New(day.Add(time.Hour))},
+			&pb.TimeSeriesRequest{Start: timestamppb.New(day.Add(time.Hour)), End: timestamppb.New(day.Add(2 * time.Hour))},
+			&pb.SubscribeRequest{Start: timestamppb.New(day.Add(3 * time.Hour))},
+			&pb.LatestRequest{},
+		} {
+			_, err := interceptor(context.Background(), req, info, handler)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 4, cache.Stats().Entries)
+
+		// Data at the end of the first hour changes the second hour, the
+		// open range after it and the latest point
+		assert.Equal(t, 3, cache.Invalidate(day.Add(time.Hour), day.Add(4*time.Hour)))
+		stats := cache.Stats()
+		assert.Equal(t, 1, stats.Entries)
+		assert.Zero(t, stats.Evictions, "invalidated entries are not evictions")
+
+		assert.Equal(t, 1, cache.Invalidate(day, day))
+		assert.Zero(t, cache.Stats().Bytes)
+	})
+
+	t.Run("serve stale", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+		now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+		cache.SetServeStale(time.Hour)
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		var failure error
+		calls := 0
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls++
+			if failure != nil {
+				return nil, failure
+			}
+			return fmt.Sprintf("response %d", calls), nil
+		}
+		interceptor := cache.InterceptorFunc()
+		day := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+		req := &pb.TimeSeriesRequest{Start: timestamppb.New(day), End: timestamppb.New(day.Add(time.Hour))}
+
+		_, err = interceptor(context.Background(), req, info, handler)
+		require.NoError(t, err)
+		assert.Equal(t, 1, cache.Invalidate(day, day))
+		assert.Equal(t, 1, cache.Stats().Entries, "invalidated responses are kept")
+
+		// Stale responses are read again while the repository answers
+		resp, err := interceptor(context.Background(), req, info, handler)
+		require.NoError(t, err)
+		assert.Equal(t, "response 2", resp)
+		assert.Equal(t, 2, calls)
+
+		// and served when it fails
+		cache.Invalidate(day, day)
+		now = now.Add(30 * time.Minute)
+		failure = status.Error(codes.Unavailable, "connection refused")
+		resp, err = interceptor(context.Background(), req, info, handler)
+		require.NoError(t, err)
+		assert.Equal(t, "response 2", resp)
+		assert.Equal(t, uint64(1), cache.Stats().Stale)
+
+		// Errors the request caused are returned
+		failure = status.Error(codes.InvalidArgument, "invalid window")
+		_, err = interceptor(context.Background(), req, info, handler)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		// as are failures once the response is older than the bound
+		now = now.Add(31 * time.Minute)
+		failure = status.Error(codes.Internal, "relation does not exist")
+		_, err = interceptor(context.Background(), req, info, handler)
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Zero(t, cache.Stats().Entries, "responses past the bound are dropped")
+	})
+
+	t.Run("stale metadata", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+		now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+		cache.SetServeStale(time.Hour)
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		var failure error
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			if failure != nil {
+				return nil, failure
+			}
+			return &pb.TimeSeriesResponse{Metadata: &pb.QueryMetadata{Window: "1h"}}, nil
+		}
+		interceptor := cache.InterceptorFunc()
+		day := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+		req := &pb.TimeSeriesRequest{Start: timestamppb.New(day), End: timestamppb.New(day.Add(time.Hour))}
+
+		_, err = interceptor(context.Background(), req, info, handler)
+		require.NoError(t, err)
+		cache.Invalidate(day, day)
+		now = now.Add(90 * time.Second)
+		failure = status.Error(codes.Unavailable, "connection refused")
+
+		for i := 0; i < 2; i++ {
+			resp, err := interceptor(context.Background(), req, info, handler)
+			require.NoError(t, err)
+			metadata := resp.(*pb.TimeSeriesResponse).GetMetadata()
+			assert.True(t, metadata.GetStale())
+			assert.Equal(t, 90*time.Second, metadata.GetStaleAge().AsDuration())
+			assert.Equal(t, "1h", metadata.GetWindow())
+		}
+
+		// The cached response is not flagged
+		cached, ok := cache.cache.Get(testCacheKey(info.FullMethod, req))
+		require.True(t, ok)
+		assert.False(t, cached.(cacheEntry).resp.(*pb.TimeSeriesResponse).GetMetadata().GetStale())
+	})
+
+	t.Run("invalidated while computed", func(t *testing.T) {
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		day := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+		req := &pb.TimeSeriesRequest{Start: timestamppb.New(day), End: timestamppb.New(day.Add(time.Hour))}
+		interceptor := cache.InterceptorFunc()
+
+		// Data stored while the response is read from the old data
+		_, err = interceptor(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			cache.Invalidate(day.Add(time.Minute), day.Add(time.Minute))
+			return "old", nil
+		})
+		require.NoError(t, err)
+		assert.Zero(t, cache.Stats().Entries, "a response computed during an invalidation is not cached")
+
+		// as during a purge
+		_, err = interceptor(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			cache.Purge()
+			return "old", nil
+		})
+		require.NoError(t, err)
+		assert.Zero(t, cache.Stats().Entries)
+
+		_, err = interceptor(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "new", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, cache.Stats().Entries)
+	})
 }
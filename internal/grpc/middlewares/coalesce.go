@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Coalescer holds calls to selected methods for a short window and answers
+// identical calls arriving within it, such as a burst of dashboards
+// refreshing at the top of the minute, with a single execution of the
+// handler whose response or error they all share.
+//
+// Calls arriving after the window closed start a new window, so a call
+// never receives a response read before it was made. The execution is
+// canceled once every call waiting for it has been canceled.
+type Coalescer struct {
+	windows   map[string]time.Duration
+	scopeOf   ScopeFunc
+	coalesced *prometheus.CounterVec
+
+	mu      sync.Mutex
+	pending map[string]*coalescedCall
+}
+
+// coalescedCall is an execution shared by identical calls
+type coalescedCall struct {
+	done chan struct{}
+	resp interface{}
+	err  error
+	// waiters is the number of calls still waiting for the execution,
+	// which is canceled with cancel once it drops to zero
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewCoalescer creates a Coalescer counting the calls answered by another
+// call's execution in coalesced, by method name. No method is coalesced
+// until SetMethodWindow is called.
+func NewCoalescer(coalesced *prometheus.CounterVec) *Coalescer {
+	return &Coalescer{
+		windows:   make(map[string]time.Duration),
+		scopeOf:   noScope,
+		coalesced: coalesced,
+		pending:   make(map[string]*coalescedCall),
+	}
+}
+
+// SetMethodWindow holds calls to a full method name for window before
+// executing them, answering identical calls made in the meantime with the
+// same execution. It must be called before the interceptor starts serving
+// requests.
+func (c *Coalescer) SetMethodWindow(method string, window time.Duration) {
+	c.windows[method] = window
+}
+
+// SetScopeFunc keeps coalesced calls to the scope of the calls sharing an
+// execution, so that a response is never shared with a caller outside it.
+// Without one, calls of all callers are coalesced. It must be called
+// before the interceptor starts serving requests.
+func (c *Coalescer) SetScopeFunc(scopeOf ScopeFunc) {
+	c.scopeOf = scopeOf
+}
+
+func (c *Coalescer) InterceptorFunc() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		window, ok := c.windows[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		key, ok := generateCacheKey(c.scopeOf(ctx), info.FullMethod, req)
+		if !ok {
+			return handler(ctx, req)
+		}
+		c.mu.Lock()
+		call, joined := c.pending[key]
+		if joined {
+			call.waiters++
+		} else {
+			// The execution outlives the call starting it if others still
+			// wait for it
+			execCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+			call = &coalescedCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+			c.pending[key] = call
+			go c.execute(execCtx, key, call, window, req, handler)
+		}
+		c.mu.Unlock()
+		if joined {
+			c.coalesced.WithLabelValues(path.Base(info.FullMethod)).Inc()
+		}
+
+		select {
+		case <-call.done:
+			return call.resp, call.err
+		case <-ctx.Done():
+			c.mu.Lock()
+			call.waiters--
+			if call.waiters == 0 {
+				// Later calls must not join a canceled execution
+				if c.pending[key] == call {
+					delete(c.pending, key)
+				}
+				call.cancel()
+			}
+			c.mu.Unlock()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// execute runs the handler for call once its window has passed
+func (c *Coalescer) execute(ctx context.Context, key string, call *coalescedCall, window time.Duration, req interface{}, handler grpc.UnaryHandler) {
+	defer call.cancel()
+
+	timer := time.NewTimer(window)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+
+	c.mu.Lock()
+	if c.pending[key] == call {
+		delete(c.pending, key)
+	}
+	c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		call.err = status.FromContextError(err).Err()
+	} else {
+		call.resp, call.err = handler(ctx, req)
+	}
+	close(call.done)
+}
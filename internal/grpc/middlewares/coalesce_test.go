@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestCoalescer(window time.Duration) (*Coalescer, *prometheus.CounterVec) {
+	coalesced := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "coalesced"}, []string{"method"})
+	c := NewCoalescer(coalesced)
+	c.SetMethodWindow("/test.Service/Query", window)
+	return c, coalesced
+}
+
+func TestCoalescer(t *testing.T) {
+	query := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Query"}
+	other := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Other"}
+
+	t.Run("identical calls share an execution", func(t *testing.T) {
+		c, coalesced := newTestCoalescer(50 * time.Millisecond)
+		interceptor := c.InterceptorFunc()
+
+		var executions atomic.Int32
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			executions.Add(1)
+			return req.(*mockRequest).Window, nil
+		}
+
+		var wg sync.WaitGroup
+		for _, window := range []string{"1h", "1h", "1h", "1h", "1m"} {
+			wg.Add(1)
+			go func(window string) {
+				defer wg.Done()
+				resp, err := interceptor(context.Background(), &mockRequest{Window: window}, query, handler)
+				assert.NoError(t, err)
+				assert.Equal(t, window, resp)
+			}(window)
+		}
+		wg.Wait()
+		assert.Equal(t, int32(2), executions.Load())
+		assert.Equal(t, 3.0, testutil.ToFloat64(coalesced.WithLabelValues("Query")))
+	})
+
+	t.Run("scopes do not share an execution", func(t *testing.T) {
+		c, coalesced := newTestCoalescer(20 * time.Millisecond)
+		c.SetScopeFunc(scopeOf)
+		interceptor := c.InterceptorFunc()
+
+		var executions atomic.Int32
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			executions.Add(1)
+			return scopeOf(ctx), nil
+		}
+
+		var wg sync.WaitGroup
+		for _, scope := range []string{"static:a", "static:b"} {
+			wg.Add(1)
+			go func(scope string) {
+				defer wg.Done()
+				resp, err := interceptor(context.WithValue(context.Background(), scopeKey{}, scope), &mockRequest{Window: "1h"}, query, handler)
+				assert.NoError(t, err)
+				assert.Equal(t, scope, resp)
+			}(scope)
+		}
+		wg.Wait()
+		assert.Equal(t, int32(2), executions.Load())
+		assert.Zero(t, testutil.ToFloat64(coalesced.WithLabelValues("Query")))
+	})
+
+	t.Run("other methods are not held", func(t *testing.T) {
+		c, _ := newTestCoalescer(time.Hour)
+		resp, err := c.InterceptorFunc()(context.Background(), &mockRequest{}, other, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "response", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "response", resp)
+	})
+
+	t.Run("errors are shared", func(t *testing.T) {
+		c, _ := newTestCoalescer(20 * time.Millisecond)
+		interceptor := c.InterceptorFunc()
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.Internal, "query failed")
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := interceptor(context.Background(), &mockRequest{}, query, handler)
+				assert.Equal(t, codes.Internal, status.Code(err))
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("calls after the window start a new execution", func(t *testing.T) {
+		c, coalesced := newTestCoalescer(time.Millisecond)
+		interceptor := c.InterceptorFunc()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var executions atomic.Int32
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			if executions.Add(1) == 1 {
+				close(started)
+				<-release
+			}
+			return "response", nil
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := interceptor(context.Background(), &mockRequest{}, query, handler)
+			assert.NoError(t, err)
+		}()
+		<-started
+		// The first execution is still running
+		_, err := interceptor(context.Background(), &mockRequest{}, query, handler)
+		require.NoError(t, err)
+		close(release)
+		<-done
+		assert.Equal(t, int32(2), executions.Load())
+		assert.Zero(t, testutil.ToFloat64(coalesced.WithLabelValues("Query")))
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		c, _ := newTestCoalescer(50 * time.Millisecond)
+		interceptor := c.InterceptorFunc()
+
+		var executions atomic.Int32
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			executions.Add(1)
+			return "response", nil
+		}
+
+		// The call starting the execution leaves; the other still gets
+		// the response
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error)
+		go func() {
+			_, err := interceptor(ctx, &mockRequest{}, query, handler)
+			errs <- err
+		}()
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return len(c.pending) == 1
+		}, time.Second, time.Millisecond)
+		go func() {
+			resp, err := interceptor(context.Background(), &mockRequest{}, query, handler)
+			assert.Equal(t, "response", resp)
+			errs <- err
+		}()
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			for _, call := range c.pending {
+				return call.waiters == 2
+			}
+			return false
+		}, time.Second, time.Millisecond)
+		cancel()
+		assert.Equal(t, codes.Canceled, status.Code(<-errs))
+		assert.NoError(t, <-errs)
+		assert.Equal(t, int32(1), executions.Load())
+
+		// Once every call has left, the execution is abandoned
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		_, err := interceptor(ctx, &mockRequest{}, query, handler)
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, int32(1), executions.Load())
+	})
+}
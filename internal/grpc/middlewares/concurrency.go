@@ -0,0 +1,455 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ConcurrencyLimitReason is the ErrorInfo reason of calls rejected by a
+// ConcurrencyLimiter
+const ConcurrencyLimitReason = "CONCURRENCY_LIMIT_EXCEEDED"
+
+// concurrencyRetryDelay is the delay advised to callers rejected by a
+// ConcurrencyLimiter, long enough for a typical query to complete
+const concurrencyRetryDelay = time.Second
+
+// ConcurrencyLimits bounds the number of calls a ConcurrencyLimiter lets
+// execute at once.
+type ConcurrencyLimits struct {
+	// MaxInFlight is the number of calls executing at once across all
+	// clients; zero leaves it unbounded
+	MaxInFlight int
+	// MaxInFlightPerClient is the number of calls a single client may
+	// have executing at once; zero leaves it unbounded
+	MaxInFlightPerClient int
+	// ClientLimits overrides MaxInFlightPerClient for the clients it
+	// lists, as identified by the client function; zero leaves a client
+	// unbounded
+	ClientLimits map[string]int
+	// MaxQueued is the number of calls that may wait for a slot at once;
+	// calls arriving while as many wait are rejected
+	MaxQueued int
+	// QueueTimeout is how long a call waits for a slot before it is
+	// rejected; zero rejects calls that cannot execute immediately
+	QueueTimeout time.Duration
+}
+
+// Validate returns an error if a limit is negative.
+func (l ConcurrencyLimits) Validate() error {
+	if l.MaxInFlight < 0 || l.MaxInFlightPerClient < 0 || l.MaxQueued < 0 || l.QueueTimeout < 0 {
+		return fmt.Errorf("concurrency limits must not be negative")
+	}
+	for client, limit := range l.ClientLimits {
+		if limit < 0 {
+			return fmt.Errorf("concurrency limit of %s must not be negative", client)
+		}
+	}
+	return nil
+}
+
+// ConcurrencyLimiter bounds the number of calls to selected methods
+// executing at once, globally and per client, so that a handful of
+// expensive queries cannot saturate the database even while callers stay
+// within their rate limits. Calls beyond the limits wait for a slot, up
+// to the queue timeout or their own deadline, and are rejected with
+// ResourceExhausted if the queue is full or none frees up. Rejections
+// carry ErrorInfo and RetryInfo details.
+//
+// Slots are shared fairly: a slot that frees up goes to the waiting
+// client with the fewest calls executing, and among those to the call
+// that has waited longest, so a client running many long exports does not
+// keep others waiting behind its queued calls. A client's calls execute
+// in arrival order.
+//
+// Clients are told apart by the peer's host, unless SetClientFunc
+// identifies them otherwise. Calls without a client are scheduled as
+// clients of their own, with no call executing.
+type ConcurrencyLimiter struct {
+	limits   ConcurrencyLimits
+	methods  map[string]bool
+	clientOf func(ctx context.Context) string
+
+	inFlight       prometheus.Gauge
+	queued         prometheus.Gauge
+	clientInFlight *prometheus.GaugeVec
+	clientQueued   *prometheus.GaugeVec
+
+	mu sync.Mutex
+	// running and waiting count the calls executing and queued across
+	// clients
+	running int
+	waiting int
+	// seq orders waiting calls by arrival
+	seq uint64
+	// clients holds the clients with calls executing or waiting, and
+	// anonymous the waiting calls without a client
+	clients   map[string]*clientCalls
+	anonymous []*waiter
+}
+
+// clientCalls holds the calls of one client
+type clientCalls struct {
+	name    string
+	limit   int
+	running int
+	queue   []*waiter
+}
+
+// waiter is a call waiting for a slot, which is granted by closing
+// granted
+type waiter struct {
+	client  *clientCalls
+	seq     uint64
+	granted chan struct{}
+}
+
+// NewConcurrencyLimiter creates a limiter reporting the calls executing
+// and waiting in inFlight and queued. No method is limited until Include
+// is called.
+func NewConcurrencyLimiter(limits ConcurrencyLimits, inFlight, queued prometheus.Gauge) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		limits:   limits,
+		methods:  make(map[string]bool),
+		clientOf: PeerHost,
+		inFlight: inFlight,
+		queued:   queued,
+		clients:  make(map[string]*clientCalls),
+	}
+}
+
+// Include limits the calls to the given full method names. It must be
+// called before the interceptors start serving requests.
+func (l *ConcurrencyLimiter) Include(methods ...string) {
+	for _, method := range methods {
+		l.methods[method] = true
+	}
+}
+
+// SetClientFunc sets the function identifying the client of a call, such
+// as by its authenticated identity. Calls it returns an empty client for
+// are only held to the global limit. It must be called before the
+// interceptors start serving requests.
+func (l *ConcurrencyLimiter) SetClientFunc(clientOf func(ctx context.Context) string) {
+	l.clientOf = clientOf
+}
+
+// SetClientMetrics reports the calls of each client executing and waiting
+// in inFlight and queued, labelled with the client. Clients are removed
+// once they have no calls. It must be called before the interceptors
+// start serving requests.
+func (l *ConcurrencyLimiter) SetClientMetrics(inFlight, queued *prometheus.GaugeVec) {
+	l.clientInFlight = inFlight
+	l.clientQueued = queued
+}
+
+// Limits returns the limits in effect.
+func (l *ConcurrencyLimiter) Limits() ConcurrencyLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limits := l.limits
+	limits.ClientLimits = maps.Clone(l.limits.ClientLimits)
+	return limits
+}
+
+// SetLimits replaces the limits. It may be called while the interceptors
+// serve requests: executing calls keep their slots even beyond the new
+// limits, waiting calls are granted the slots the new limits free up and
+// keep the queue timeout they started waiting with.
+func (l *ConcurrencyLimiter) SetLimits(limits ConcurrencyLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	l.limits.ClientLimits = maps.Clone(limits.ClientLimits)
+	for name, c := range l.clients {
+		c.limit = l.clientLimit(name)
+	}
+	l.dispatch()
+}
+
+// InFlight returns the number of calls executing for each client with
+// calls executing or waiting.
+func (l *ConcurrencyLimiter) InFlight() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inFlight := make(map[string]int, len(l.clients))
+	for name, c := range l.clients {
+		inFlight[name] = c.running
+	}
+	return inFlight
+}
+
+// PeerHost returns the host of the peer of a call, without its port, or
+// an empty string outside a gRPC server.
+func PeerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func (l *ConcurrencyLimiter) InterceptorFunc() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !l.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		release, err := l.acquire(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptorFunc limits the streams of the included methods, which
+// hold their slot until they complete.
+func (l *ConcurrencyLimiter) StreamInterceptorFunc() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !l.methods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		release, err := l.acquire(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+// acquire waits for a slot, returning the function releasing it
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, method string) (func(), error) {
+	name := l.clientOf(ctx)
+
+	l.mu.Lock()
+	client := l.join(name)
+	// Calls take a free slot without queueing
+	if l.globalFree() && client.free() {
+		l.start(client)
+		l.mu.Unlock()
+		return l.releaser(client), nil
+	}
+	timeout := l.limits.QueueTimeout
+	if l.waiting >= l.limits.MaxQueued || timeout <= 0 {
+		l.leave(client)
+		l.mu.Unlock()
+		return nil, concurrencyError(method, "the queue is full")
+	}
+	w := &waiter{client: client, seq: l.seq, granted: make(chan struct{})}
+	l.seq++
+	l.enqueue(w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.granted:
+		return l.releaser(client), nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	select {
+	case <-w.granted:
+		// The slot was granted as the call stopped waiting
+		l.mu.Unlock()
+		l.releaser(client)()
+	default:
+		l.dequeue(w)
+		l.leave(client)
+		l.mu.Unlock()
+	}
+	return nil, waitError(method, err)
+}
+
+// join returns the calls of the client name, which stays known until it
+// has no calls; calls without a client get a client of their own
+func (l *ConcurrencyLimiter) join(name string) *clientCalls {
+	if name == "" {
+		return &clientCalls{}
+	}
+	c, ok := l.clients[name]
+	if !ok {
+		c = &clientCalls{name: name, limit: l.clientLimit(name)}
+		l.clients[name] = c
+	}
+	return c
+}
+
+// clientLimit returns the limit of the client name
+func (l *ConcurrencyLimiter) clientLimit(name string) int {
+	if limit, ok := l.limits.ClientLimits[name]; ok {
+		return limit
+	}
+	return l.limits.MaxInFlightPerClient
+}
+
+// leave forgets client once it has no calls
+func (l *ConcurrencyLimiter) leave(client *clientCalls) {
+	if client.name == "" || client.running > 0 || len(client.queue) > 0 {
+		return
+	}
+	delete(l.clients, client.name)
+	if l.clientInFlight != nil {
+		l.clientInFlight.DeleteLabelValues(client.name)
+		l.clientQueued.DeleteLabelValues(client.name)
+	}
+}
+
+// globalFree reports whether a call may start within the global limit
+func (l *ConcurrencyLimiter) globalFree() bool {
+	return l.limits.MaxInFlight <= 0 || l.running < l.limits.MaxInFlight
+}
+
+// free reports whether a call of the client may start within its limit
+func (c *clientCalls) free() bool {
+	return c.name == "" || c.limit <= 0 || c.running < c.limit
+}
+
+// start counts a call of client that took a slot
+func (l *ConcurrencyLimiter) start(client *clientCalls) {
+	l.running++
+	client.running++
+	l.inFlight.Inc()
+	if l.clientInFlight != nil && client.name != "" {
+		l.clientInFlight.WithLabelValues(client.name).Inc()
+	}
+}
+
+// releaser returns the function freeing the slot of a call of client once
+// it completes, which hands the slot to the next waiting call
+func (l *ConcurrencyLimiter) releaser(client *clientCalls) func() {
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.running--
+		client.running--
+		l.inFlight.Dec()
+		if l.clientInFlight != nil && client.name != "" {
+			l.clientInFlight.WithLabelValues(client.name).Dec()
+		}
+		l.dispatch()
+		l.leave(client)
+	}
+}
+
+// enqueue adds w to the calls waiting for a slot
+func (l *ConcurrencyLimiter) enqueue(w *waiter) {
+	l.waiting++
+	l.queued.Inc()
+	if w.client.name == "" {
+		l.anonymous = append(l.anonymous, w)
+		return
+	}
+	w.client.queue = append(w.client.queue, w)
+	if l.clientQueued != nil {
+		l.clientQueued.WithLabelValues(w.client.name).Inc()
+	}
+}
+
+// dequeue removes w from the calls waiting for a slot
+func (l *ConcurrencyLimiter) dequeue(w *waiter) {
+	l.waiting--
+	l.queued.Dec()
+	if w.client.name == "" {
+		l.anonymous = remove(l.anonymous, w)
+		return
+	}
+	w.client.queue = remove(w.client.queue, w)
+	if l.clientQueued != nil {
+		l.clientQueued.WithLabelValues(w.client.name).Dec()
+	}
+}
+
+// dispatch grants the free slots to waiting calls: each to the first
+// waiting call of the client with the fewest calls executing, and of the
+// longest waiting such client on a tie
+func (l *ConcurrencyLimiter) dispatch() {
+	for l.globalFree() {
+		var next *waiter
+		if len(l.anonymous) > 0 {
+			next = l.anonymous[0]
+		}
+		for _, c := range l.clients {
+			if len(c.queue) == 0 || !c.free() {
+				continue
+			}
+			head := c.queue[0]
+			if next == nil || c.running < next.client.running ||
+				(c.running == next.client.running && head.seq < next.seq) {
+				next = head
+			}
+		}
+		if next == nil {
+			return
+		}
+		l.dequeue(next)
+		l.start(next.client)
+		close(next.granted)
+	}
+}
+
+// remove returns queue without w
+func remove(queue []*waiter, w *waiter) []*waiter {
+	for i, queued := range queue {
+		if queued == w {
+			return append(queue[:i], queue[i+1:]...)
+		}
+	}
+	return queue
+}
+
+// errQueueTimeout reports that no slot freed up within the queue timeout
+var errQueueTimeout = errors.New("no slot freed up in time")
+
+// waitError returns the error of a call that stopped waiting for a slot
+// with err
+func waitError(method string, err error) error {
+	if errors.Is(err, errQueueTimeout) {
+		return concurrencyError(method, "no query completed within the queue timeout")
+	}
+	return status.FromContextError(err).Err()
+}
+
+// concurrencyError returns the ResourceExhausted status of a call to
+// method rejected for reason
+func concurrencyError(method, reason string) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("too many concurrent queries: %s", reason))
+	details := []protoadapt.MessageV1{
+		&errdetails.ErrorInfo{
+			Reason: ConcurrencyLimitReason,
+			Domain: RateLimitDomain,
+			Metadata: map[string]string{
+				"method": method,
+			},
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(concurrencyRetryDelay)},
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
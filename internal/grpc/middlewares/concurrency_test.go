@@ -0,0 +1,370 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// clientKey is the context key of the client in tests identifying
+// clients by context
+type clientKey struct{}
+
+func newTestConcurrencyLimiter(limits ConcurrencyLimits) (*ConcurrencyLimiter, prometheus.Gauge, prometheus.Gauge) {
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_flight"})
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queued"})
+	l := NewConcurrencyLimiter(limits, inFlight, queued)
+	l.Include("/test.Service/Query")
+	return l, inFlight, queued
+}
+
+// peerContext returns a context of a call from host
+func peerContext(host string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(host), Port: 40000},
+	})
+}
+
+// blockingHandler returns a handler signalling each call on started and
+// blocking until unblock is closed
+func blockingHandler(started chan<- struct{}, unblock <-chan struct{}) grpc.UnaryHandler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-unblock
+		return "response", nil
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	query := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Query"}
+	other := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Other"}
+
+	t.Run("queued calls wait for a slot", func(t *testing.T) {
+		l, inFlight, queued := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlight:  2,
+			MaxQueued:    10,
+			QueueTimeout: time.Second,
+		})
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 3)
+		unblock := make(chan struct{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+				assert.NoError(t, err)
+				assert.Equal(t, "response", resp)
+			}()
+		}
+		<-started
+		<-started
+		require.Eventually(t, func() bool { return testutil.ToFloat64(queued) == 1 }, time.Second, time.Millisecond)
+		assert.Equal(t, 2.0, testutil.ToFloat64(inFlight))
+		select {
+		case <-started:
+			t.Fatal("a third call executed beyond the limit")
+		default:
+		}
+
+		close(unblock)
+		wg.Wait()
+		assert.Len(t, started, 1)
+		assert.Zero(t, testutil.ToFloat64(inFlight))
+		assert.Zero(t, testutil.ToFloat64(queued))
+	})
+
+	t.Run("rejected when the queue is full", func(t *testing.T) {
+		l, _, _ := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlight:  1,
+			MaxQueued:    0,
+			QueueTimeout: time.Second,
+		})
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 1)
+		unblock := make(chan struct{})
+		defer close(unblock)
+		go interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+		<-started
+
+		_, err := interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+		st := status.Convert(err)
+		assert.Equal(t, codes.ResourceExhausted, st.Code())
+		assert.Contains(t, st.Message(), "too many concurrent queries")
+		var reason string
+		var retry time.Duration
+		for _, detail := range st.Details() {
+			switch d := detail.(type) {
+			case *errdetails.ErrorInfo:
+				reason = d.Reason
+			case *errdetails.RetryInfo:
+				retry = d.RetryDelay.AsDuration()
+			}
+		}
+		assert.Equal(t, ConcurrencyLimitReason, reason)
+		assert.Equal(t, time.Second, retry)
+	})
+
+	t.Run("rejected after the queue timeout", func(t *testing.T) {
+		l, _, queued := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlight:  1,
+			MaxQueued:    10,
+			QueueTimeout: 20 * time.Millisecond,
+		})
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 1)
+		unblock := make(chan struct{})
+		defer close(unblock)
+		go interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+		<-started
+
+		_, err := interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Contains(t, err.Error(), "queue timeout")
+		assert.Zero(t, testutil.ToFloat64(queued))
+	})
+
+	t.Run("waiting ends with the call", func(t *testing.T) {
+		l, _, _ := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlight:  1,
+			MaxQueued:    10,
+			QueueTimeout: time.Second,
+		})
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 1)
+		unblock := make(chan struct{})
+		defer close(unblock)
+		go interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := interceptor(ctx, nil, query, blockingHandler(started, unblock))
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	})
+
+	t.Run("clients are limited separately", func(t *testing.T) {
+		l, _, _ := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlight:          10,
+			MaxInFlightPerClient: 1,
+		})
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 2)
+		unblock := make(chan struct{})
+		go interceptor(peerContext("10.0.0.1"), nil, query, blockingHandler(started, unblock))
+		<-started
+
+		// Another client still executes, while the first one is held back
+		go interceptor(peerContext("10.0.0.2"), nil, query, blockingHandler(started, unblock))
+		<-started
+		_, err := interceptor(peerContext("10.0.0.1"), nil, query, blockingHandler(started, unblock))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		close(unblock)
+		require.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.clients) == 0
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("slots go to the client with the fewest calls executing", func(t *testing.T) {
+		l, _, queued := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlight:  2,
+			MaxQueued:    10,
+			QueueTimeout: 5 * time.Second,
+		})
+		l.SetClientFunc(func(ctx context.Context) string { return ctx.Value(clientKey{}).(string) })
+		interceptor := l.InterceptorFunc()
+
+		started := make(chan string, 5)
+		unblock := make(map[string]chan struct{})
+		var wg sync.WaitGroup
+		call := func(client, name string) {
+			unblock[name] = make(chan struct{})
+			done := unblock[name]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx := context.WithValue(context.Background(), clientKey{}, client)
+				_, err := interceptor(ctx, nil, query, func(ctx context.Context, req interface{}) (interface{}, error) {
+					started <- name
+					<-done
+					return nil, nil
+				})
+				assert.NoError(t, err)
+			}()
+		}
+
+		// The exporting client fills the slots and queues more calls
+		call("exporter", "export 1")
+		call("exporter", "export 2")
+		<-started
+		<-started
+		call("exporter", "export 3")
+		require.Eventually(t, func() bool { return testutil.ToFloat64(queued) == 1 }, time.Second, time.Millisecond)
+		call("exporter", "export 4")
+		require.Eventually(t, func() bool { return testutil.ToFloat64(queued) == 2 }, time.Second, time.Millisecond)
+		call("dashboard", "query")
+		require.Eventually(t, func() bool { return testutil.ToFloat64(queued) == 3 }, time.Second, time.Millisecond)
+		assert.Equal(t, map[string]int{"exporter": 2, "dashboard": 0}, l.InFlight())
+
+		// The interactive client waited least but runs next
+		close(unblock["export 1"])
+		assert.Equal(t, "query", <-started)
+		// Then the exporter's calls, in arrival order
+		close(unblock["query"])
+		assert.Equal(t, "export 3", <-started)
+		close(unblock["export 2"])
+		assert.Equal(t, "export 4", <-started)
+
+		close(unblock["export 3"])
+		close(unblock["export 4"])
+		wg.Wait()
+		assert.Empty(t, l.InFlight())
+	})
+
+	t.Run("client limits", func(t *testing.T) {
+		l, _, _ := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlightPerClient: 1,
+			ClientLimits:         map[string]int{"10.0.0.1": 2},
+		})
+		clientInFlight := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "client_in_flight"}, []string{"client"})
+		clientQueued := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "client_queued"}, []string{"client"})
+		l.SetClientMetrics(clientInFlight, clientQueued)
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 3)
+		unblock := make(chan struct{})
+
+		var wg sync.WaitGroup
+		for _, host := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				interceptor(peerContext(host), nil, query, blockingHandler(started, unblock))
+			}()
+		}
+		<-started
+		<-started
+		<-started
+		assert.Equal(t, 2.0, testutil.ToFloat64(clientInFlight.WithLabelValues("10.0.0.1")))
+
+		_, err := interceptor(peerContext("10.0.0.1"), nil, query, blockingHandler(started, unblock))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		_, err = interceptor(peerContext("10.0.0.2"), nil, query, blockingHandler(started, unblock))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		close(unblock)
+		wg.Wait()
+		assert.Zero(t, testutil.CollectAndCount(clientInFlight), "idle clients are removed")
+		assert.Zero(t, testutil.CollectAndCount(clientQueued))
+	})
+
+	t.Run("limits changed at runtime", func(t *testing.T) {
+		l, inFlight, queued := newTestConcurrencyLimiter(ConcurrencyLimits{
+			MaxInFlight:  1,
+			MaxQueued:    10,
+			QueueTimeout: time.Second,
+		})
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 3)
+		unblock := make(chan struct{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+				assert.NoError(t, err)
+			}()
+		}
+		<-started
+		require.Eventually(t, func() bool { return testutil.ToFloat64(queued) == 2 }, time.Second, time.Millisecond)
+
+		// Raising the limit grants the waiting calls at once
+		limits := l.Limits()
+		limits.MaxInFlight = 3
+		limits.ClientLimits = map[string]int{"10.0.0.1": 1}
+		l.SetLimits(limits)
+		<-started
+		<-started
+		assert.Equal(t, 3.0, testutil.ToFloat64(inFlight))
+		assert.Equal(t, 3, l.Limits().MaxInFlight)
+		assert.Equal(t, map[string]int{"10.0.0.1": 1}, l.Limits().ClientLimits)
+
+		// Lowering it leaves executing calls running
+		l.SetLimits(ConcurrencyLimits{MaxInFlight: 1})
+		_, err := interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		close(unblock)
+		wg.Wait()
+		assert.Zero(t, testutil.ToFloat64(inFlight))
+	})
+
+	t.Run("client function", func(t *testing.T) {
+		l, _, _ := newTestConcurrencyLimiter(ConcurrencyLimits{MaxInFlightPerClient: 1})
+		l.SetClientFunc(func(ctx context.Context) string { return "" })
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 2)
+		unblock := make(chan struct{})
+		defer close(unblock)
+
+		// Calls without a client are only held to the global limit
+		go interceptor(peerContext("10.0.0.1"), nil, query, blockingHandler(started, unblock))
+		go interceptor(peerContext("10.0.0.1"), nil, query, blockingHandler(started, unblock))
+		<-started
+		<-started
+	})
+
+	t.Run("other methods are not limited", func(t *testing.T) {
+		l, inFlight, _ := newTestConcurrencyLimiter(ConcurrencyLimits{MaxInFlight: 1})
+		interceptor := l.InterceptorFunc()
+		started := make(chan struct{}, 1)
+		unblock := make(chan struct{})
+		defer close(unblock)
+		go interceptor(context.Background(), nil, query, blockingHandler(started, unblock))
+		<-started
+
+		resp, err := interceptor(context.Background(), nil, other, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "response", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "response", resp)
+		assert.Equal(t, 1.0, testutil.ToFloat64(inFlight))
+	})
+
+	t.Run("streams hold their slot", func(t *testing.T) {
+		l, inFlight, _ := newTestConcurrencyLimiter(ConcurrencyLimits{MaxInFlight: 1})
+		interceptor := l.StreamInterceptorFunc()
+		info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Query"}
+		stream := &contextStream{ctx: context.Background()}
+
+		err := interceptor(nil, stream, info, func(srv interface{}, ss grpc.ServerStream) error {
+			assert.Equal(t, 1.0, testutil.ToFloat64(inFlight))
+			err := interceptor(nil, stream, info, func(srv interface{}, ss grpc.ServerStream) error {
+				t.Fatal("a second stream executed beyond the limit")
+				return nil
+			})
+			assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Zero(t, testutil.ToFloat64(inFlight))
+	})
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Deadlines bounds how long calls may run, so that a call made without a
+// deadline over a huge range cannot hold a database connection for
+// minutes. Unary calls without a deadline are given the default timeout,
+// and deadlines further away than the maximum are brought forward to it.
+// Repository queries run under the bounded context and are cancelled with
+// it; the call then fails with DeadlineExceeded and a message saying
+// which limit was reached.
+//
+// Methods given their own timeout with SetMethodTimeout use it as both
+// their default and maximum. Streaming calls are only bounded when their
+// method has its own timeout, since streams such as subscriptions and
+// ingestion are meant to stay open.
+type Deadlines struct {
+	timeout    time.Duration
+	maxTimeout time.Duration
+	methods    map[string]time.Duration
+}
+
+// NewDeadlines creates deadlines giving unary calls without a deadline
+// timeout and capping the deadline of any unary call to maxTimeout. Zero
+// leaves calls without a default or a maximum respectively.
+func NewDeadlines(timeout, maxTimeout time.Duration) *Deadlines {
+	if maxTimeout > 0 && timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+	return &Deadlines{
+		timeout:    timeout,
+		maxTimeout: maxTimeout,
+		methods:    make(map[string]time.Duration),
+	}
+}
+
+// SetMethodTimeout gives a full method name its own timeout, used as both
+// its default and maximum, such as a longer one for exports. Zero leaves
+// the method's calls without a server deadline, for calls such as
+// backfills that may legitimately run long. It must be called before the
+// interceptors start serving requests.
+func (d *Deadlines) SetMethodTimeout(method string, timeout time.Duration) {
+	d.methods[method] = timeout
+}
+
+// InterceptorFunc bounds the deadline of unary calls.
+func (d *Deadlines) InterceptorFunc() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		timeout, maxTimeout := d.limits(info.FullMethod)
+		ctx, cancel, limit := bound(ctx, timeout, maxTimeout)
+		defer cancel()
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, deadlineError(ctx, info.FullMethod, limit, err)
+		}
+		return resp, nil
+	}
+}
+
+// StreamInterceptorFunc bounds the deadline of streaming calls to methods
+// with their own timeout.
+func (d *Deadlines) StreamInterceptorFunc() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		timeout, ok := d.methods[info.FullMethod]
+		if !ok {
+			return handler(srv, ss)
+		}
+		ctx, cancel, limit := bound(ss.Context(), timeout, timeout)
+		defer cancel()
+
+		if err := handler(srv, &boundedStream{ServerStream: ss, ctx: ctx}); err != nil {
+			return deadlineError(ctx, info.FullMethod, limit, err)
+		}
+		return nil
+	}
+}
+
+// limits returns the default and maximum timeout of calls to method
+func (d *Deadlines) limits(method string) (time.Duration, time.Duration) {
+	if timeout, ok := d.methods[method]; ok {
+		return timeout, timeout
+	}
+	return d.timeout, d.maxTimeout
+}
+
+// bound returns ctx with its deadline bounded by timeout and maxTimeout,
+// and the limit applied, zero when the caller's own deadline is kept
+func bound(ctx context.Context, timeout, maxTimeout time.Duration) (context.Context, context.CancelFunc, time.Duration) {
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok && timeout > 0:
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, timeout
+	case ok && maxTimeout > 0 && time.Until(deadline) > maxTimeout:
+		ctx, cancel := context.WithTimeout(ctx, maxTimeout)
+		return ctx, cancel, maxTimeout
+	}
+	return ctx, func() {}, 0
+}
+
+// deadlineError returns the error of a call that failed with err: a
+// DeadlineExceeded error describing the limit when its deadline passed,
+// as the handler may have reported the cancelled query with another
+// code, and err otherwise
+func deadlineError(ctx context.Context, method string, limit time.Duration, err error) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if limit == 0 {
+		return status.Errorf(codes.DeadlineExceeded, "%s did not complete within the deadline set by the caller", method)
+	}
+	return status.Errorf(codes.DeadlineExceeded,
+		"%s did not complete within the server's limit of %s; request a shorter range or a coarser window, or page through the results",
+		method, limit)
+}
+
+// boundedStream is a server stream with a bounded context
+type boundedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *boundedStream) Context() context.Context {
+	return s.ctx
+}
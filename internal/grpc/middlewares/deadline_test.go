@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDeadlines(t *testing.T) {
+	d := NewDeadlines(time.Second, time.Minute)
+	d.SetMethodTimeout("/test.Service/Export", 10*time.Minute)
+	d.SetMethodTimeout("/test.Service/Backfill", 0)
+	interceptor := d.InterceptorFunc()
+
+	// remaining calls method with ctx and returns the time left before the
+	// deadline the handler sees, or zero without one
+	remaining := func(ctx context.Context, method string) time.Duration {
+		var left time.Duration
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				if deadline, ok := ctx.Deadline(); ok {
+					left = time.Until(deadline)
+				}
+				return "ok", nil
+			})
+		require.NoError(t, err)
+		return left
+	}
+
+	t.Run("default timeout", func(t *testing.T) {
+		left := remaining(context.Background(), "/test.Service/Query")
+		assert.InDelta(t, time.Second, left, float64(100*time.Millisecond))
+	})
+
+	t.Run("caller deadline kept", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		left := remaining(ctx, "/test.Service/Query")
+		assert.InDelta(t, 30*time.Second, left, float64(100*time.Millisecond))
+	})
+
+	t.Run("caller deadline capped", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		left := remaining(ctx, "/test.Service/Query")
+		assert.InDelta(t, time.Minute, left, float64(100*time.Millisecond))
+	})
+
+	t.Run("method timeout", func(t *testing.T) {
+		left := remaining(context.Background(), "/test.Service/Export")
+		assert.InDelta(t, 10*time.Minute, left, float64(100*time.Millisecond))
+		assert.Zero(t, remaining(context.Background(), "/test.Service/Backfill"))
+	})
+
+	t.Run("exceeded", func(t *testing.T) {
+		d := NewDeadlines(10*time.Millisecond, time.Minute)
+		// The handler reports the cancelled query as a storage failure
+		slow := func(ctx context.Context, _ interface{}) (interface{}, error) {
+			<-ctx.Done()
+			return nil, status.Errorf(codes.Internal, "query failed: %v", ctx.Err())
+		}
+
+		_, err := d.InterceptorFunc()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Query"}, slow)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+		assert.Equal(t, "/test.Service/Query did not complete within the server's limit of 10ms; "+
+			"request a shorter range or a coarser window, or page through the results", status.Convert(err).Message())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = d.InterceptorFunc()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Query"}, slow)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "deadline set by the caller")
+	})
+
+	t.Run("other errors unchanged", func(t *testing.T) {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Query"},
+			func(context.Context, interface{}) (interface{}, error) { return nil, fmt.Errorf("boom") })
+		assert.EqualError(t, err, "boom")
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		stream := d.StreamInterceptorFunc()
+		deadline := func(method string) bool {
+			var ok bool
+			require.NoError(t, stream(nil, &recordingStream{}, &grpc.StreamServerInfo{FullMethod: method},
+				func(_ interface{}, ss grpc.ServerStream) error {
+					_, ok = ss.Context().Deadline()
+					return nil
+				}))
+			return ok
+		}
+		assert.True(t, deadline("/test.Service/Export"))
+		assert.False(t, deadline("/test.Service/Subscribe"), "streams without a method timeout stay open")
+	})
+}
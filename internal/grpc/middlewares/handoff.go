@@ -0,0 +1,341 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// handoffVersion is the version of the handoff file format; files of
+// another version are discarded
+const handoffVersion = 2
+
+// DefaultHandoffMaxAge is how old handed off state may be by default
+// before it is discarded rather than restored
+const DefaultHandoffMaxAge = 5 * time.Minute
+
+// handoffSuffix ends the names of handoff files
+const handoffSuffix = ".json"
+
+// handoff is the state a stopping process hands to the next one: the
+// cached responses, least recently used first, the ingest watermark they
+// were computed at, and the tokens left in the rate limiter's buckets
+type handoff struct {
+	Version   int                `json:"version"`
+	SavedAt   time.Time          `json:"saved_at"`
+	Watermark time.Time          `json:"watermark"`
+	Buckets   map[string]float64 `json:"buckets,omitempty"`
+	Cache     []handoffEntry     `json:"cache,omitempty"`
+}
+
+// handoffEntry is a cached response, encoded as an Any so that it can be
+// decoded without knowing its method
+type handoffEntry struct {
+	Key        []byte    `json:"key"`
+	Response   []byte    `json:"response"`
+	StaleSince time.Time `json:"stale_since,omitempty"`
+	Ranged     bool      `json:"ranged,omitempty"`
+	Start      time.Time `json:"start,omitempty"`
+	End        time.Time `json:"end,omitempty"`
+}
+
+// HandoffStats describes the state restored by LoadHandoff.
+type HandoffStats struct {
+	// Age is how long before loading the state was saved
+	Age time.Duration
+	// Responses is the number of cached responses restored
+	Responses int
+	// Buckets is the number of rate limit buckets restored
+	Buckets int
+}
+
+// HandoffPath returns the file in dir that the replica named replica
+// saves its state to, so that replicas sharing dir do not overwrite each
+// other's.
+func HandoffPath(dir, replica string) string {
+	return filepath.Join(dir, replica+handoffSuffix)
+}
+
+// SaveHandoff writes the responses of cache and the tokens left in the
+// buckets of limiter to path, for the next process to restore with
+// LoadHandoff, so that a restart neither empties the cache nor refills
+// the buckets of clients that exhausted them. watermark is the ingest
+// watermark the responses were computed at. It must be called once the
+// interceptors have stopped serving requests. Either of cache and limiter
+// may be nil. Cached errors, and responses that are not protobuf messages,
+// are not saved. The file is replaced atomically.
+func SaveHandoff(path string, watermark time.Time, cache *Cache, limiter *RateLimiter) error {
+	state := handoff{Version: handoffVersion, SavedAt: time.Now(), Watermark: watermark}
+	if limiter != nil {
+		state.Buckets = limiter.tokens()
+	}
+	if cache != nil {
+		entries, err := cache.snapshot()
+		if err != nil {
+			return err
+		}
+		state.Cache = entries
+	}
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode handoff state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create handoff file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write handoff file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write handoff file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ClaimHandoff takes a handoff file saved to dir for replica to restore,
+// returning an empty path when there is none. The file replica saved
+// itself is preferred, then the oldest saved by another replica. Files
+// are claimed by renaming them, so that each is claimed by one replica
+// even when several start at once.
+func ClaimHandoff(dir, replica string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list handoff files: %w", err)
+	}
+
+	type candidate struct {
+		name    string
+		own     bool
+		modTime time.Time
+	}
+	var candidates []candidate
+	for _, entry := range entries {
+		name := entry.Name()
+		// Hidden files are being written or already claimed
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, handoffSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{name: name, own: name == replica+handoffSuffix, modTime: info.ModTime()})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].own != candidates[j].own {
+			return candidates[i].own
+		}
+		return candidates[i].modTime.Before(candidates[j].modTime)
+	})
+
+	for _, c := range candidates {
+		claimed := filepath.Join(dir, "."+c.name+".claimed-"+replica)
+		err := os.Rename(filepath.Join(dir, c.name), claimed)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Claimed by another replica in the meantime
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to claim handoff file: %w", err)
+		}
+		return claimed, nil
+	}
+	return "", nil
+}
+
+// LoadHandoff restores into cache and limiter the state saved to path by
+// SaveHandoff, and removes the file, so that the state is restored once.
+// Buckets refill for the time elapsed since the state was saved, as they
+// would have in the previous process. Responses over ranges ending after
+// the saved ingest watermark are dropped, as data may have arrived for
+// them since, as are responses over ranges cache has seen data stored in,
+// which the previous process did not see, responses already cached,
+// responses of unknown types or of methods no longer cached, and buckets
+// of methods no longer given their own limit. Data stored by other
+// processes is seen by neither, so their responses may be restored stale
+// until a later insert invalidates them. State older than maxAge is
+// discarded with an error, as are files of another format. A missing file
+// restores nothing. Either of cache and limiter may be nil; they may
+// already be serving requests.
+func LoadHandoff(path string, maxAge time.Duration, cache *Cache, limiter *RateLimiter) (HandoffStats, error) {
+	encoded, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return HandoffStats{}, nil
+	}
+	if err != nil {
+		return HandoffStats{}, fmt.Errorf("failed to read handoff file: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return HandoffStats{}, fmt.Errorf("failed to remove handoff file: %w", err)
+	}
+
+	var state handoff
+	if err := json.Unmarshal(encoded, &state); err != nil {
+		return HandoffStats{}, fmt.Errorf("failed to decode handoff file: %w", err)
+	}
+	if state.Version != handoffVersion {
+		return HandoffStats{}, fmt.Errorf("handoff file has version %d, expected %d", state.Version, handoffVersion)
+	}
+	stats := HandoffStats{Age: time.Since(state.SavedAt)}
+	if stats.Age > maxAge {
+		return stats, fmt.Errorf("handoff state saved %s ago is older than %s", stats.Age.Round(time.Second), maxAge)
+	}
+	if limiter != nil {
+		stats.Buckets = limiter.restoreTokens(state.Buckets, stats.Age)
+	}
+	if cache != nil {
+		stats.Responses = cache.restore(state.Cache, state.Watermark)
+	}
+	return stats, nil
+}
+
+// tokens returns the tokens left in each bucket of the limiter, by full
+// method name, the shared bucket being under the empty name. Buckets
+// without a limit are left out.
+func (r *RateLimiter) tokens() map[string]float64 {
+	now := r.now()
+	tokens := make(map[string]float64, len(r.methods)+1)
+	if r.limiter.Limit() != rate.Inf {
+		tokens[""] = r.limiter.TokensAt(now)
+	}
+	for method, limiter := range r.methods {
+		if limiter.Limit() != rate.Inf {
+			tokens[method] = limiter.TokensAt(now)
+		}
+	}
+	return tokens
+}
+
+// restoreTokens takes from each bucket of the limiter the tokens it had
+// beyond those saved, refilled over elapsed, and returns the number of
+// buckets restored
+func (r *RateLimiter) restoreTokens(saved map[string]float64, elapsed time.Duration) int {
+	now := r.now()
+	restored := 0
+	for method, tokens := range saved {
+		limiter := r.limiter
+		if method != "" {
+			var ok bool
+			if limiter, ok = r.methods[method]; !ok {
+				continue
+			}
+		}
+		if limiter.Limit() == rate.Inf {
+			continue
+		}
+		target := math.Max(tokens, 0) + float64(limiter.Limit())*elapsed.Seconds()
+		// Tokens are taken whole, rounding in favour of the limit
+		if take := math.Ceil(limiter.TokensAt(now) - target); take > 0 {
+			limiter.ReserveN(now, int(take))
+		}
+		restored++
+	}
+	return restored
+}
+
+// snapshot returns the cached responses, least recently used first,
+// leaving out errors and responses that are not protobuf messages
+func (c *Cache) snapshot() ([]handoffEntry, error) {
+	var entries []handoffEntry
+	for _, key := range c.cache.Keys() {
+		value, ok := c.cache.Peek(key)
+		if !ok {
+			continue
+		}
+		entry := value.(cacheEntry)
+		msg, ok := entry.resp.(proto.Message)
+		if entry.err != nil || !ok {
+			continue
+		}
+		resp, err := anypb.New(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode cached response: %w", err)
+		}
+		encoded, err := proto.Marshal(resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode cached response: %w", err)
+		}
+		entries = append(entries, handoffEntry{
+			Key:        []byte(key.(string)),
+			Response:   encoded,
+			StaleSince: entry.staleSince,
+			Ranged:     entry.ranged,
+			Start:      entry.start,
+			End:        entry.end,
+		})
+	}
+	return entries, nil
+}
+
+// restore caches the responses of entries, least recently used first, and
+// returns the number cached. Responses over ranges ending after watermark,
+// responses already cached, and those of unknown types or excluded methods
+// are dropped, as are responses that data this cache has seen stored may
+// have changed: they were computed by another process, which did not see
+// it. Should data be stored while entries are restored, the remaining
+// ones are dropped.
+func (c *Cache) restore(entries []handoffEntry, watermark time.Time) int {
+	c.mu.Lock()
+	generation := c.generation.Load()
+	written, writtenFrom, writtenTo := c.written, c.writtenFrom, c.writtenTo
+	c.mu.Unlock()
+
+	restored := 0
+	for _, e := range entries {
+		key := string(e.Key)
+		if c.excluded[keyMethod(key)] || (e.Ranged && e.End.After(watermark)) || c.cache.Contains(key) {
+			continue
+		}
+		entry := cacheEntry{
+			staleSince: e.StaleSince,
+			ranged:     e.Ranged,
+			start:      e.Start,
+			end:        e.End,
+		}
+		if written && entry.changedBy(writtenFrom, writtenTo) {
+			continue
+		}
+		var resp anypb.Any
+		if err := proto.Unmarshal(e.Response, &resp); err != nil {
+			continue
+		}
+		msg, err := resp.UnmarshalNew()
+		if err != nil {
+			continue
+		}
+		if c.generation.Load() != generation {
+			break
+		}
+		entry.resp = msg
+		c.store(key, entry, generation)
+		restored++
+	}
+	return restored
+}
+
+// keyMethod returns the full method name of a key made by
+// generateCacheKey
+func keyMethod(key string) string {
+	if i := strings.IndexByte(key, 0); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+func TestHandoff(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	query := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Query"}
+	insert := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Insert"}
+	newLimiter := func() *RateLimiter {
+		limiter := NewRateLimiter(0.001, 3)
+		limiter.SetMethodLimit(insert.FullMethod, 0.001, 2)
+		return limiter
+	}
+	call := func(limiter *RateLimiter, info *grpc.UnaryServerInfo) error {
+		_, err := limiter.InterceptorFunc()(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	t.Run("restores cache and buckets", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "handoff.json")
+		cache, err := NewCache(10)
+		require.NoError(t, err)
+		limiter := newLimiter()
+
+		requests := []*pb.TimeSeriesRequest{
+			{Start: timestamppb.New(day), End: timestamppb.New(day.Add(time.Hour))},
+			{Start: timestamppb.New(day.Add(time.Hour)), End: timestamppb.New(day.Add(2 * time.Hour))},
+		}
+		for i, req := range requests {
+			_, err := cache.InterceptorFunc()(context.Background(), req, query, func(context.Context, interface{}) (interface{}, error) {
+				return &pb.TimeSeriesResponse{Data: []*pb.TimeSeriesDataPoint{{Value: float64(i)}}}, nil
+			})
+			require.NoError(t, err)
+		}
+		// Errors are not handed off
+		_, err = cache.InterceptorFunc()(context.Background(), &pb.TimeSeriesRequest{Window: "x"}, query, func(context.Context, interface{}) (interface{}, error) {
+			return nil, status.Error(codes.InvalidArgument, "invalid window")
+		})
+		require.Error(t, err)
+		require.Equal(t, 3, cache.Stats().Entries)
+
+		// The shared bucket is exhausted and one insert is left
+		for i := 0; i < 3; i++ {
+			require.NoError(t, call(limiter, query))
+		}
+		require.NoError(t, call(limiter, insert))
+		require.NoError(t, SaveHandoff(path, day.Add(2*time.Hour), cache, limiter))
+
+		restoredCache, err := NewCache(10)
+		require.NoError(t, err)
+		restoredLimiter := newLimiter()
+		stats, err := LoadHandoff(path, time.Minute, restoredCache, restoredLimiter)
+		require.NoError(t, err)
+		assert.Equal(t, 2, stats.Responses)
+		assert.Equal(t, 2, stats.Buckets)
+		assert.Less(t, stats.Age, time.Minute)
+		assert.NoFileExists(t, path)
+
+		// Restored responses are served without reaching the handler,
+		// and stay invalidated by the range they cover
+		for i, req := range requests {
+			resp, err := restoredCache.InterceptorFunc()(context.Background(), req, query, func(context.Context, interface{}) (interface{}, error) {
+				t.Fatal("handler called for a restored response")
+				return nil, nil
+			})
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(&pb.TimeSeriesResponse{Data: []*pb.TimeSeriesDataPoint{{Value: float64(i)}}}, resp.(proto.Message)))
+		}
+		assert.Equal(t, 1, restoredCache.Invalidate(day.Add(90*time.Minute), day.Add(90*time.Minute)))
+
+		assert.Error(t, call(restoredLimiter, query))
+		assert.NoError(t, call(restoredLimiter, insert))
+		assert.Error(t, call(restoredLimiter, insert))
+
+		t.Run("responses after the watermark are dropped", func(t *testing.T) {
+			require.NoError(t, SaveHandoff(path, day.Add(90*time.Minute), restoredCache, nil))
+			cache, err := NewCache(10)
+			require.NoError(t, err)
+			// A response the new process computed is kept
+			_, err = cache.InterceptorFunc()(context.Background(), requests[0], query, func(context.Context, interface{}) (interface{}, error) {
+				return &pb.TimeSeriesResponse{}, nil
+			})
+			require.NoError(t, err)
+
+			stats, err := LoadHandoff(path, time.Minute, cache, nil)
+			require.NoError(t, err)
+			assert.Zero(t, stats.Responses)
+			assert.Equal(t, 1, cache.Stats().Entries)
+		})
+
+		t.Run("responses changed by data stored meanwhile are dropped", func(t *testing.T) {
+			require.NoError(t, SaveHandoff(path, day.Add(2*time.Hour), cache, nil))
+			restored, err := NewCache(10)
+			require.NoError(t, err)
+			// The new process stored data in the first hour while
+			// waiting for the handoff
+			restored.Invalidate(day.Add(30*time.Minute), day.Add(30*time.Minute))
+
+			stats, err := LoadHandoff(path, time.Minute, restored, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 1, stats.Responses)
+			calls := 0
+			for _, req := range requests {
+				_, err := restored.InterceptorFunc()(context.Background(), req, query, func(context.Context, interface{}) (interface{}, error) {
+					calls++
+					return &pb.TimeSeriesResponse{}, nil
+				})
+				require.NoError(t, err)
+			}
+			assert.Equal(t, 1, calls, "only the first hour is read again")
+		})
+	})
+
+	t.Run("replicas claim their own file, then the oldest", func(t *testing.T) {
+		dir := t.TempDir()
+		limiter := newLimiter()
+		saved := time.Now().Add(-time.Minute)
+		for i, replica := range []string{"edgecom-b", "edgecom-a", "edgecom-c"} {
+			path := HandoffPath(dir, replica)
+			require.NoError(t, SaveHandoff(path, day, nil, limiter))
+			// Files are ordered by modification time
+			at := saved.Add(time.Duration(i) * time.Second)
+			require.NoError(t, os.Chtimes(path, at, at))
+		}
+
+		own, err := ClaimHandoff(dir, "edgecom-c")
+		require.NoError(t, err)
+		assert.NoFileExists(t, HandoffPath(dir, "edgecom-c"))
+		oldest, err := ClaimHandoff(dir, "edgecom-d")
+		require.NoError(t, err)
+		assert.NoFileExists(t, HandoffPath(dir, "edgecom-b"))
+		assert.NotEqual(t, own, oldest)
+
+		_, err = LoadHandoff(own, time.Minute, nil, newLimiter())
+		require.NoError(t, err)
+		assert.NoFileExists(t, own)
+
+		last, err := ClaimHandoff(dir, "edgecom-e")
+		require.NoError(t, err)
+		assert.NotEmpty(t, last)
+		none, err := ClaimHandoff(dir, "edgecom-f")
+		require.NoError(t, err)
+		assert.Empty(t, none, "claimed files are not claimed again")
+
+		none, err = ClaimHandoff(filepath.Join(dir, "missing"), "edgecom-a")
+		require.NoError(t, err)
+		assert.Empty(t, none)
+	})
+
+	t.Run("buckets refill while stopped", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "handoff.json")
+		limiter := NewRateLimiter(1, 3)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, call(limiter, query))
+		}
+		require.NoError(t, SaveHandoff(path, day, nil, limiter))
+		rewrite(t, path, func(state *handoff) { state.SavedAt = state.SavedAt.Add(-2 * time.Second) })
+
+		restored := NewRateLimiter(1, 3)
+		at := time.Now()
+		restored.now = func() time.Time { return at }
+		_, err := LoadHandoff(path, time.Minute, nil, restored)
+		require.NoError(t, err)
+		assert.InDelta(t, 2, restored.limiter.TokensAt(at), 0.5)
+	})
+
+	t.Run("unknown methods are dropped", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "handoff.json")
+		limiter := newLimiter()
+		require.NoError(t, call(limiter, insert))
+		require.NoError(t, SaveHandoff(path, day, nil, limiter))
+
+		// The restarted process gives inserts no limit of their own
+		restored := NewRateLimiter(0.001, 3)
+		stats, err := LoadHandoff(path, time.Minute, nil, restored)
+		require.NoError(t, err)
+		assert.Equal(t, 1, stats.Buckets)
+		assert.InDelta(t, 3, restored.limiter.Tokens(), 0.01)
+	})
+
+	t.Run("old state is discarded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "handoff.json")
+		limiter := newLimiter()
+		require.NoError(t, call(limiter, query))
+		require.NoError(t, SaveHandoff(path, day, nil, limiter))
+		rewrite(t, path, func(state *handoff) { state.SavedAt = state.SavedAt.Add(-time.Hour) })
+
+		restored := newLimiter()
+		_, err := LoadHandoff(path, DefaultHandoffMaxAge, nil, restored)
+		assert.ErrorContains(t, err, "older than 5m0s")
+		assert.NoFileExists(t, path)
+		assert.InDelta(t, 3, restored.limiter.Tokens(), 0.01)
+	})
+
+	t.Run("missing and invalid files", func(t *testing.T) {
+		dir := t.TempDir()
+		stats, err := LoadHandoff(filepath.Join(dir, "missing.json"), time.Minute, nil, newLimiter())
+		assert.NoError(t, err)
+		assert.Equal(t, HandoffStats{}, stats)
+
+		path := filepath.Join(dir, "handoff.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"version":99}`), 0o644))
+		_, err = LoadHandoff(path, time.Minute, nil, newLimiter())
+		assert.ErrorContains(t, err, "version 99")
+		assert.NoFileExists(t, path)
+	})
+}
+
+// rewrite changes the handoff state saved to path
+func rewrite(t *testing.T, path string, change func(*handoff)) {
+	t.Helper()
+	encoded, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var state handoff
+	require.NoError(t, json.Unmarshal(encoded, &state))
+	change(&state)
+	encoded, err = json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, encoded, 0o644))
+}
@@ -2,29 +2,130 @@ package middleware
 
 import (
 	"context"
-	"log"
 	"time"
 
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
-func LoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	start := time.Now()
+// windowedRequest is implemented by requests that carry aggregation
+// parameters, such as TimeSeriesRequest
+type windowedRequest interface {
+	GetWindow() string
+	GetAggregation() string
+}
+
+// RequestLogger logs one structured entry per gRPC call.
+//
+// Entries are written at Info by default. Calls failing with a code that
+// indicates a server fault are logged at Error and other failures at no
+// less than Warn, whatever the method's level.
+type RequestLogger struct {
+	logger *logrus.Logger
+	level  logrus.Level
+	levels map[string]logrus.Level
+}
+
+func NewRequestLogger(logger *logrus.Logger) *RequestLogger {
+	return &RequestLogger{
+		logger: logger,
+		level:  logrus.InfoLevel,
+		levels: make(map[string]logrus.Level),
+	}
+}
+
+// SetMethodLevel overrides the level successful calls to a full method name
+// are logged at, e.g. to demote frequently polled methods to Debug. It must
+// be called before the interceptors start serving requests.
+func (l *RequestLogger) SetMethodLevel(method string, level logrus.Level) {
+	l.levels[method] = level
+}
+
+func (l *RequestLogger) InterceptorFunc() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		fields := l.fields(ctx, info.FullMethod, start, err)
+		if r, ok := req.(windowedRequest); ok {
+			fields["window"] = r.GetWindow()
+			fields["aggregation"] = r.GetAggregation()
+		}
+		l.log(info.FullMethod, fields, err)
+
+		return resp, err
+	}
+}
+
+// StreamInterceptorFunc logs one entry per stream when it finishes.
+func (l *RequestLogger) StreamInterceptorFunc() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+
+		err := handler(srv, ss)
+
+		l.log(info.FullMethod, l.fields(ss.Context(), info.FullMethod, start, err), err)
+
+		return err
+	}
+}
 
-	// Get request ID from context
-	requestID, _ := ctx.Value(requestIDKey).(string)
+// fields collects the request details common to unary and stream calls
+func (l *RequestLogger) fields(ctx context.Context, method string, start time.Time, err error) logrus.Fields {
+	fields := logrus.Fields{
+		"method":   method,
+		"code":     status.Code(err).String(),
+		"duration": time.Since(start),
+	}
 
-	// Execute the handler
-	resp, err := handler(ctx, req)
+	if requestID, ok := ctx.Value(requestIDKey).(string); ok {
+		fields["request_id"] = requestID
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields["peer"] = p.Addr.String()
+	}
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.HasTraceID() {
+		fields["trace_id"] = spanCtx.TraceID().String()
+	}
+	if err != nil {
+		fields["error"] = status.Convert(err).Message()
+	}
 
-	// Log the request with request ID
-	log.Printf(
-		"request_id: %s method: %s duration: %s error: %v",
-		requestID,
-		info.FullMethod,
-		time.Since(start),
-		err,
-	)
+	return fields
+}
+
+// log writes the entry at the level chosen for the method and outcome
+func (l *RequestLogger) log(method string, fields logrus.Fields, err error) {
+	level, ok := l.levels[method]
+	if !ok {
+		level = l.level
+	}
+
+	switch code := status.Code(err); {
+	case code == codes.OK:
+	case isServerFault(code):
+		level = logrus.ErrorLevel
+	case level > logrus.WarnLevel:
+		// Lower logrus levels are more severe
+		level = logrus.WarnLevel
+	}
+
+	l.logger.WithFields(fields).Log(level, "gRPC request")
+}
 
-	return resp, err
+// isServerFault reports whether code indicates a failure on the server side
+// rather than a problem with the request.
+func isServerFault(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
 }
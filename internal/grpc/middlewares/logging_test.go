@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+func TestRequestLogger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+
+	requestLogger := NewRequestLogger(logger)
+	requestLogger.SetMethodLevel("/test.Service/Poll", logrus.DebugLevel)
+	interceptor := requestLogger.InterceptorFunc()
+
+	ctx := context.WithValue(context.Background(), requestIDKey, "req-1")
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}})
+
+	tests := []struct {
+		name      string
+		method    string
+		err       error
+		wantLevel logrus.Level
+		wantCode  string
+	}{
+		{name: "success", method: "/test.Service/Query", wantLevel: logrus.InfoLevel, wantCode: "OK"},
+		{name: "method override", method: "/test.Service/Poll", wantLevel: logrus.DebugLevel, wantCode: "OK"},
+		{
+			name:      "client error",
+			method:    "/test.Service/Poll",
+			err:       status.Error(codes.InvalidArgument, "bad window"),
+			wantLevel: logrus.WarnLevel,
+			wantCode:  "InvalidArgument",
+		},
+		{
+			name:      "server error",
+			method:    "/test.Service/Query",
+			err:       status.Error(codes.Internal, "query failed"),
+			wantLevel: logrus.ErrorLevel,
+			wantCode:  "Internal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, tt.err
+			}
+
+			req := &pb.TimeSeriesRequest{Window: "1h", Aggregation: "AVG"}
+			_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			assert.Equal(t, tt.err, err)
+
+			entry := hook.LastEntry()
+			require.NotNil(t, entry)
+			assert.Equal(t, tt.wantLevel, entry.Level)
+			assert.Equal(t, tt.method, entry.Data["method"])
+			assert.Equal(t, tt.wantCode, entry.Data["code"])
+			assert.Equal(t, "req-1", entry.Data["request_id"])
+			assert.Equal(t, "10.0.0.1:5000", entry.Data["peer"])
+			assert.Equal(t, "1h", entry.Data["window"])
+			assert.Equal(t, "AVG", entry.Data["aggregation"])
+			assert.Contains(t, entry.Data, "duration")
+		})
+	}
+
+	t.Run("requests without a window", func(t *testing.T) {
+		hook.Reset()
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		}
+
+		_, err := interceptor(ctx, &pb.LatestRequest{}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Latest"}, handler)
+		require.NoError(t, err)
+
+		entry := hook.LastEntry()
+		require.NotNil(t, entry)
+		assert.NotContains(t, entry.Data, "window")
+		assert.NotContains(t, entry.Data, "aggregation")
+	})
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewMessageSizeInterceptor rejects unary responses larger than maxSize
+// bytes with a ResourceExhausted error that says how to request less data,
+// instead of the transport's generic failure to send the message.
+func NewMessageSizeInterceptor(maxSize int) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if err := checkMessageSize(resp, maxSize); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// NewStreamMessageSizeInterceptor is the streaming counterpart of
+// NewMessageSizeInterceptor, checking each message sent.
+func NewStreamMessageSizeInterceptor(maxSize int) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &sizeCheckedStream{ServerStream: ss, maxSize: maxSize})
+	}
+}
+
+type sizeCheckedStream struct {
+	grpc.ServerStream
+	maxSize int
+}
+
+func (s *sizeCheckedStream) SendMsg(m interface{}) error {
+	if err := checkMessageSize(m, s.maxSize); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// checkMessageSize returns an error if the encoded message is larger than
+// maxSize bytes
+func checkMessageSize(m interface{}, maxSize int) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return nil
+	}
+	if size := proto.Size(msg); size > maxSize {
+		return status.Errorf(codes.ResourceExhausted,
+			"response of %d bytes exceeds the maximum message size of %d bytes; request a shorter range or a coarser window, or page through the results",
+			size, maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// recordingStream records the messages sent on it
+type recordingStream struct {
+	grpc.ServerStream
+	sent []interface{}
+}
+
+func (r *recordingStream) Context() context.Context { return context.Background() }
+
+func (r *recordingStream) SendMsg(m interface{}) error {
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+func TestMessageSizeInterceptor(t *testing.T) {
+	small := wrapperspb.String("ok")
+	large := wrapperspb.String(string(make([]byte, 100)))
+	limit := proto.Size(small) + 10
+
+	t.Run("unary", func(t *testing.T) {
+		interceptor := NewMessageSizeInterceptor(limit)
+		call := func(resp interface{}) (interface{}, error) {
+			return interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Query"},
+				func(context.Context, interface{}) (interface{}, error) { return resp, nil })
+		}
+
+		resp, err := call(small)
+		assert.NoError(t, err)
+		assert.Equal(t, small, resp)
+
+		resp, err = call(large)
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "exceeds the maximum message size")
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		stream := &recordingStream{}
+		err := NewStreamMessageSizeInterceptor(limit)(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test.Service/Export"},
+			func(_ interface{}, ss grpc.ServerStream) error {
+				if err := ss.SendMsg(small); err != nil {
+					return err
+				}
+				return ss.SendMsg(large)
+			})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, []interface{}{small}, stream.sent)
+	})
+}
@@ -7,11 +7,23 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
+// pointsField is the repeated field responses carry their points in, such
+// as TimeSeriesResponse.data
+const pointsField protoreflect.Name = "data"
+
+// NewMetricsInterceptor counts calls in requests by method and status
+// code, observes their duration in latency by method and, for responses
+// carrying points in a repeated data field, observes the number of points
+// in points by method.
 func NewMetricsInterceptor(
 	requests *prometheus.CounterVec,
 	latency *prometheus.HistogramVec,
+	points *prometheus.HistogramVec,
 ) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		start := time.Now()
@@ -22,9 +34,29 @@ func NewMetricsInterceptor(
 		duration := time.Since(start).Seconds()
 		method := path.Base(info.FullMethod)
 
-		requests.WithLabelValues(method).Inc()
+		requests.WithLabelValues(method, status.Code(err).String()).Inc()
 		latency.WithLabelValues(method).Observe(duration)
+		if err == nil {
+			if n, ok := responsePoints(resp); ok {
+				points.WithLabelValues(method).Observe(float64(n))
+			}
+		}
 
 		return resp, err
 	}
 }
+
+// responsePoints returns the number of points in resp, or false if it
+// does not carry points
+func responsePoints(resp interface{}) (int, bool) {
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		return 0, false
+	}
+	m := msg.ProtoReflect()
+	field := m.Descriptor().Fields().ByName(pointsField)
+	if field == nil || !field.IsList() {
+		return 0, false
+	}
+	return m.Get(field).List().Len(), true
+}
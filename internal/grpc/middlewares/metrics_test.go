@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"method", "code"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"method"})
+	points := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "points",
+		Help:    "Points returned",
+		Buckets: []float64{1, 10},
+	}, []string{"method"})
+	interceptor := NewMetricsInterceptor(requests, latency, points)
+
+	call := func(method string, resp interface{}, err error) {
+		interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return resp, err
+			})
+	}
+
+	call("/test.Service/Query", &pb.TimeSeriesResponse{Data: make([]*pb.TimeSeriesDataPoint, 3)}, nil)
+	call("/test.Service/Query", &pb.TimeSeriesResponse{}, nil)
+	call("/test.Service/Query", nil, status.Error(codes.InvalidArgument, "invalid window"))
+	call("/test.Service/Stats", &pb.StatisticsResponse{}, nil)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(requests.WithLabelValues("Query", "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues("Query", "InvalidArgument")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues("Stats", "OK")))
+
+	// Only successful responses carrying points are observed
+	assert.Equal(t, 2, testutil.CollectAndCount(latency))
+	expected := `
+# HELP points Points returned
+# TYPE points histogram
+points_bucket{method="Query",le="1"} 1
+points_bucket{method="Query",le="10"} 2
+points_bucket{method="Query",le="+Inf"} 2
+points_sum{method="Query"} 3
+points_count{method="Query"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(points, strings.NewReader(expected)))
+}
@@ -2,28 +2,238 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// Rate limit trailers sent with rejected calls
+const (
+	// TrailerRateLimitLimit is the number of calls the limit allows in a
+	// burst
+	TrailerRateLimitLimit = "x-ratelimit-limit"
+	// TrailerRateLimitRemaining is the number of calls that may be made
+	// now, which is zero for rejected calls
+	TrailerRateLimitRemaining = "x-ratelimit-remaining"
+	// TrailerRateLimitReset is the number of seconds until the full burst
+	// is available again
+	TrailerRateLimitReset = "x-ratelimit-reset"
+)
+
+// Rate limit error details
+const (
+	// RateLimitReason is the ErrorInfo reason of rejected calls
+	RateLimitReason = "RATE_LIMIT_EXCEEDED"
+	// RateLimitDomain is the ErrorInfo domain of rejected calls
+	RateLimitDomain = "edgecom"
+)
+
+// RateLimiter rejects calls exceeding a token bucket limit with
+// ResourceExhausted. Rejections carry a RetryInfo detail with the delay
+// until the next call is allowed, QuotaFailure and ErrorInfo details
+// describing the limit, and the same figures in x-ratelimit-* trailers.
 type RateLimiter struct {
-	limiter *rate.Limiter
+	limiter    *rate.Limiter
+	methods    map[string]*rate.Limiter
+	now        func() time.Time
+	rejections *prometheus.CounterVec
 }
 
 func NewRateLimiter(rps float64, burst int) *RateLimiter {
 	return &RateLimiter{
 		limiter: rate.NewLimiter(rate.Limit(rps), burst),
+		methods: make(map[string]*rate.Limiter),
+		now:     time.Now,
+	}
+}
+
+// SetMethodLimit gives a full method name its own limit instead of the
+// shared one, so that e.g. writes and queries do not consume each other's
+// budget. It must be called before the interceptors start serving requests.
+func (r *RateLimiter) SetMethodLimit(method string, rps float64, burst int) {
+	r.methods[method] = rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+// RateLimit is a token bucket limit: the calls allowed per second, and at
+// once.
+type RateLimit struct {
+	RPS   float64
+	Burst int
+}
+
+// Limit returns the limit shared by the methods without their own.
+func (r *RateLimiter) Limit() RateLimit {
+	return limitOf(r.limiter)
+}
+
+// SetLimit changes the limit shared by the methods without their own. It
+// may be called while the interceptors serve requests.
+func (r *RateLimiter) SetLimit(limit RateLimit) {
+	now := r.now()
+	r.limiter.SetLimitAt(now, rate.Limit(limit.RPS))
+	r.limiter.SetBurstAt(now, limit.Burst)
+}
+
+// MethodLimits returns the limits of the methods given their own with
+// SetMethodLimit, by full method name.
+func (r *RateLimiter) MethodLimits() map[string]RateLimit {
+	limits := make(map[string]RateLimit, len(r.methods))
+	for method, limiter := range r.methods {
+		limits[method] = limitOf(limiter)
+	}
+	return limits
+}
+
+// UpdateMethodLimit changes the limit of a method given its own with
+// SetMethodLimit, returning false for other methods, which share the
+// limit changed with SetLimit. It may be called while the interceptors
+// serve requests.
+func (r *RateLimiter) UpdateMethodLimit(method string, limit RateLimit) bool {
+	limiter, ok := r.methods[method]
+	if !ok {
+		return false
+	}
+	now := r.now()
+	limiter.SetLimitAt(now, rate.Limit(limit.RPS))
+	limiter.SetBurstAt(now, limit.Burst)
+	return true
+}
+
+// limitOf returns the limit of limiter
+func limitOf(limiter *rate.Limiter) RateLimit {
+	return RateLimit{RPS: float64(limiter.Limit()), Burst: limiter.Burst()}
+}
+
+// SetMetrics registers a counter of the rejected calls, by method, with
+// reg. It must be called before the interceptors start serving requests.
+func (r *RateLimiter) SetMetrics(reg prometheus.Registerer) error {
+	rejections := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edgecom_grpc_rate_limited_requests_total",
+		Help: "Calls rejected for exceeding a rate limit",
+	}, []string{"method"})
+	if err := reg.Register(rejections); err != nil {
+		return fmt.Errorf("failed to register rate limit metric: %v", err)
 	}
+	r.rejections = rejections
+	return nil
+}
+
+// rejection describes a call rejected by a limit
+type rejection struct {
+	method string
+	// rps is the rate at which calls are allowed, and burst the number of
+	// calls allowed at once
+	rps   float64
+	burst int
+	// retryAfter is the delay until a call is allowed again, and reset the
+	// delay until the full burst is; both are zero without a refill rate
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// allow reports whether a call to method is within its limit, and
+// describes the rejection if not
+func (r *RateLimiter) allow(method string) (*rejection, bool) {
+	limiter, ok := r.methods[method]
+	if !ok {
+		limiter = r.limiter
+	}
+	now := r.now()
+	if limiter.AllowN(now, 1) {
+		return nil, true
+	}
+	if r.rejections != nil {
+		r.rejections.WithLabelValues(method).Inc()
+	}
+
+	rej := &rejection{
+		method: method,
+		rps:    float64(limiter.Limit()),
+		burst:  limiter.Burst(),
+	}
+	if rej.rps > 0 {
+		tokens := math.Max(limiter.TokensAt(now), 0)
+		rej.retryAfter = seconds((1 - tokens) / rej.rps)
+		rej.reset = seconds((float64(rej.burst) - tokens) / rej.rps)
+	}
+	return rej, false
+}
+
+// seconds converts a number of seconds to a duration, rounded up to the
+// millisecond so clients waiting for it are not early
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s*1000)) * time.Millisecond
+}
+
+// trailer returns the x-ratelimit-* trailers of the rejection
+func (rej *rejection) trailer() metadata.MD {
+	return metadata.Pairs(
+		TrailerRateLimitLimit, strconv.Itoa(rej.burst),
+		TrailerRateLimitRemaining, "0",
+		TrailerRateLimitReset, strconv.FormatInt(int64(math.Ceil(rej.reset.Seconds())), 10),
+	)
+}
+
+// err returns the ResourceExhausted status of the rejection, with its
+// details
+func (rej *rejection) err() error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	details := []protoadapt.MessageV1{
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     rej.method,
+			Description: fmt.Sprintf("at most %d calls at once and %g per second", rej.burst, rej.rps),
+		}}},
+		&errdetails.ErrorInfo{
+			Reason: RateLimitReason,
+			Domain: RateLimitDomain,
+			Metadata: map[string]string{
+				"method":    rej.method,
+				"limit":     strconv.Itoa(rej.burst),
+				"remaining": "0",
+				"reset":     strconv.FormatInt(int64(math.Ceil(rej.reset.Seconds())), 10),
+			},
+		},
+	}
+	// Without a refill rate no call will be allowed again, so there is no
+	// delay to advise
+	if rej.retryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(rej.retryAfter)})
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
 }
 
 func (r *RateLimiter) InterceptorFunc() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if !r.limiter.Allow() {
-			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded")
+		if rej, ok := r.allow(info.FullMethod); !ok {
+			// Fails only outside a gRPC server, as in tests
+			_ = grpc.SetTrailer(ctx, rej.trailer())
+			return nil, rej.err()
 		}
 		return handler(ctx, req)
 	}
 }
+
+// StreamInterceptorFunc limits the rate at which streams are opened.
+func (r *RateLimiter) StreamInterceptorFunc() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if rej, ok := r.allow(info.FullMethod); !ok {
+			ss.SetTrailer(rej.trailer())
+			return rej.err()
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimiter(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "response", nil
+	}
+	call := func(interceptor grpc.UnaryServerInterceptor, method string) error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	t.Run("shared limit", func(t *testing.T) {
+		interceptor := NewRateLimiter(0.001, 1).InterceptorFunc()
+
+		assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		err := call(interceptor, "/test.Service/Other")
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("method limit is separate", func(t *testing.T) {
+		limiter := NewRateLimiter(0.001, 1)
+		limiter.SetMethodLimit("/test.Service/Insert", 0.001, 2)
+		interceptor := limiter.InterceptorFunc()
+
+		assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		assert.Error(t, call(interceptor, "/test.Service/Query"))
+
+		assert.NoError(t, call(interceptor, "/test.Service/Insert"))
+		assert.NoError(t, call(interceptor, "/test.Service/Insert"))
+		assert.Error(t, call(interceptor, "/test.Service/Insert"))
+	})
+
+	t.Run("limits changed at runtime", func(t *testing.T) {
+		limiter := NewRateLimiter(0.001, 1)
+		limiter.SetMethodLimit("/test.Service/Insert", 0.001, 1)
+		at := time.Now()
+		limiter.now = func() time.Time { return at }
+		interceptor := limiter.InterceptorFunc()
+
+		assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		assert.Error(t, call(interceptor, "/test.Service/Query"))
+		limiter.SetLimit(RateLimit{RPS: 10, Burst: 5})
+		assert.Equal(t, RateLimit{RPS: 10, Burst: 5}, limiter.Limit())
+		// The bucket refills at the new rate
+		at = at.Add(time.Second)
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		}
+
+		assert.True(t, limiter.UpdateMethodLimit("/test.Service/Insert", RateLimit{RPS: 0, Burst: 0}))
+		assert.Error(t, call(interceptor, "/test.Service/Insert"), "a zero limit sheds every call")
+		assert.Equal(t, map[string]RateLimit{"/test.Service/Insert": {}}, limiter.MethodLimits())
+		assert.False(t, limiter.UpdateMethodLimit("/test.Service/Query", RateLimit{RPS: 1, Burst: 1}))
+	})
+
+	t.Run("stream limit", func(t *testing.T) {
+		limiter := NewRateLimiter(0.001, 1)
+		interceptor := limiter.StreamInterceptorFunc()
+		info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Ingest", IsClientStream: true}
+		streamHandler := func(srv interface{}, ss grpc.ServerStream) error { return nil }
+		stream := &trailerStream{}
+
+		assert.NoError(t, interceptor(nil, stream, info, streamHandler))
+		err := interceptor(nil, stream, info, streamHandler)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, []string{"1"}, stream.trailer.Get(TrailerRateLimitLimit))
+		assert.Equal(t, []string{"0"}, stream.trailer.Get(TrailerRateLimitRemaining))
+		assert.Equal(t, []string{"1000"}, stream.trailer.Get(TrailerRateLimitReset))
+	})
+
+	t.Run("rejection details", func(t *testing.T) {
+		limiter := NewRateLimiter(0.5, 2)
+		now := time.Unix(1700000000, 0)
+		limiter.now = func() time.Time { return now }
+		interceptor := limiter.InterceptorFunc()
+
+		assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		now = now.Add(time.Second)
+		// Half a token has been refilled
+		assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		err := call(interceptor, "/test.Service/Query")
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		var retry *errdetails.RetryInfo
+		var info *errdetails.ErrorInfo
+		var quota *errdetails.QuotaFailure
+		for _, detail := range status.Convert(err).Details() {
+			switch d := detail.(type) {
+			case *errdetails.RetryInfo:
+				retry = d
+			case *errdetails.ErrorInfo:
+				info = d
+			case *errdetails.QuotaFailure:
+				quota = d
+			}
+		}
+		require.NotNil(t, retry)
+		assert.Equal(t, time.Second, retry.RetryDelay.AsDuration())
+		require.NotNil(t, info)
+		assert.Equal(t, RateLimitReason, info.Reason)
+		assert.Equal(t, map[string]string{
+			"method":    "/test.Service/Query",
+			"limit":     "2",
+			"remaining": "0",
+			"reset":     "3",
+		}, info.Metadata)
+		require.NotNil(t, quota)
+		assert.Equal(t, "/test.Service/Query", quota.Violations[0].Subject)
+	})
+
+	t.Run("no refill", func(t *testing.T) {
+		interceptor := NewRateLimiter(0, 1).InterceptorFunc()
+
+		assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		err := call(interceptor, "/test.Service/Query")
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+		for _, detail := range status.Convert(err).Details() {
+			_, isRetry := detail.(*errdetails.RetryInfo)
+			assert.False(t, isRetry, "no retry delay without a refill rate")
+		}
+	})
+
+	t.Run("rejections are counted", func(t *testing.T) {
+		limiter := NewRateLimiter(0, 1)
+		require.NoError(t, limiter.SetMetrics(prometheus.NewRegistry()))
+		interceptor := limiter.InterceptorFunc()
+
+		assert.NoError(t, call(interceptor, "/test.Service/Query"))
+		assert.Error(t, call(interceptor, "/test.Service/Query"))
+		assert.Error(t, call(interceptor, "/test.Service/Query"))
+		assert.Equal(t, 2.0, testutil.ToFloat64(limiter.rejections.WithLabelValues("/test.Service/Query")))
+	})
+}
+
+// trailerStream records the trailer set on a stream
+type trailerStream struct {
+	grpc.ServerStream
+	trailer metadata.MD
+}
+
+func (s *trailerStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
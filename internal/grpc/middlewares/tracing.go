@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// metadataCarrier adapts incoming gRPC metadata to a propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// NewTracingInterceptor starts a server span for every unary request,
+// continuing any trace propagated by the client in the request metadata.
+// It should run first in the chain so the span covers all other middleware.
+func NewTracingInterceptor(tracer trace.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startServerSpan(ctx, tracer, info.FullMethod)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		endServerSpan(span, err)
+
+		return resp, err
+	}
+}
+
+// NewStreamTracingInterceptor is the streaming counterpart of
+// NewTracingInterceptor. The span lasts for the lifetime of the stream.
+func NewStreamTracingInterceptor(tracer trace.Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServerSpan(ss.Context(), tracer, info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &tracedStream{ServerStream: ss, ctx: ctx})
+		endServerSpan(span, err)
+
+		return err
+	}
+}
+
+// tracedStream overrides the stream context so handlers see the span
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
+
+func startServerSpan(ctx context.Context, tracer trace.Tracer, fullMethod string) (context.Context, trace.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	}
+
+	service, method := path.Split(fullMethod)
+	return tracer.Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemGRPC,
+			semconv.RPCService(strings.Trim(service, "/")),
+			semconv.RPCMethod(method),
+		),
+	)
+}
+
+// endServerSpan records the status code. Only codes that indicate a server
+// fault mark the span as failed; client errors such as InvalidArgument do
+// not.
+func endServerSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+
+	if isServerFault(code) {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+}
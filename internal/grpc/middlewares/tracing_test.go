@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTracingInterceptor(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	interceptor := NewTracingInterceptor(provider.Tracer("test"))
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/timeseries.TimeSeriesService/QueryTimeSeries"}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantStatus otelcodes.Code
+	}{
+		{name: "success", wantCode: codes.OK, wantStatus: otelcodes.Unset},
+		{
+			name:       "client error",
+			err:        status.Error(codes.InvalidArgument, "bad window"),
+			wantCode:   codes.InvalidArgument,
+			wantStatus: otelcodes.Unset,
+		},
+		{
+			name:       "server error",
+			err:        status.Error(codes.Internal, "query failed"),
+			wantCode:   codes.Internal,
+			wantStatus: otelcodes.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handlerSpan trace.SpanContext
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerSpan = trace.SpanContextFromContext(ctx)
+				return nil, tt.err
+			}
+
+			_, err := interceptor(context.Background(), nil, info, handler)
+			assert.Equal(t, tt.err, err)
+
+			spans := recorder.Ended()
+			require.NotEmpty(t, spans)
+			span := spans[len(spans)-1]
+
+			assert.Equal(t, "timeseries.TimeSeriesService/QueryTimeSeries", span.Name())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.Equal(t, handlerSpan.SpanID(), span.SpanContext().SpanID())
+			assert.Contains(t, span.Attributes(), attribute.String("rpc.method", "QueryTimeSeries"))
+			assert.Contains(t, span.Attributes(), attribute.String("rpc.service", "timeseries.TimeSeriesService"))
+			assert.Contains(t, span.Attributes(), attribute.Int("rpc.grpc.status_code", int(tt.wantCode)))
+			assert.Equal(t, tt.wantStatus, span.Status().Code)
+		})
+	}
+
+	t.Run("continues propagated trace", func(t *testing.T) {
+		traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		md := metadata.Pairs("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		}
+		_, err := interceptor(ctx, nil, info, handler)
+		require.NoError(t, err)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		assert.Equal(t, traceID, span.SpanContext().TraceID())
+		assert.True(t, span.Parent().IsRemote())
+	})
+}
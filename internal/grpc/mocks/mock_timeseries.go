@@ -11,6 +11,7 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	proto "github.com/tejusbharadwaj/edgecom/proto"
 	grpc "google.golang.org/grpc"
+	metadata "google.golang.org/grpc/metadata"
 )
 
 // MockTimeSeriesServiceClient is a mock of TimeSeriesServiceClient interface.
@@ -36,47 +37,943 @@ func (m *MockTimeSeriesServiceClient) EXPECT() *MockTimeSeriesServiceClientMockR
 	return m.recorder
 }
 
+// CompareTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceClient) CompareTimeSeries(ctx context.Context, in *proto.CompareRequest, opts ...grpc.CallOption) (*proto.CompareResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "CompareTimeSeries", varargs...)
+	ret0, _ := ret[0].(*proto.CompareResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompareTimeSeries indicates an expected call of CompareTimeSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) CompareTimeSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).CompareTimeSeries), varargs...)
+}
+
+// ExportTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceClient) ExportTimeSeries(ctx context.Context, in *proto.ExportRequest, opts ...grpc.CallOption) (proto.TimeSeriesService_ExportTimeSeriesClient, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ExportTimeSeries", varargs...)
+	ret0, _ := ret[0].(proto.TimeSeriesService_ExportTimeSeriesClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportTimeSeries indicates an expected call of ExportTimeSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) ExportTimeSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).ExportTimeSeries), varargs...)
+}
+
+// GetBudgetStatus mocks base method.
+func (m *MockTimeSeriesServiceClient) GetBudgetStatus(ctx context.Context, in *proto.BudgetStatusRequest, opts ...grpc.CallOption) (*proto.BudgetStatusResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetBudgetStatus", varargs...)
+	ret0, _ := ret[0].(*proto.BudgetStatusResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBudgetStatus indicates an expected call of GetBudgetStatus.
+func (mr *MockTimeSeriesServiceClientMockRecorder) GetBudgetStatus(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBudgetStatus", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).GetBudgetStatus), varargs...)
+}
+
+// GetLatest mocks base method.
+func (m *MockTimeSeriesServiceClient) GetLatest(ctx context.Context, in *proto.LatestRequest, opts ...grpc.CallOption) (*proto.LatestResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetLatest", varargs...)
+	ret0, _ := ret[0].(*proto.LatestResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLatest indicates an expected call of GetLatest.
+func (mr *MockTimeSeriesServiceClientMockRecorder) GetLatest(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLatest", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).GetLatest), varargs...)
+}
+
+// GetSeries mocks base method.
+func (m *MockTimeSeriesServiceClient) GetSeries(ctx context.Context, in *proto.GetSeriesRequest, opts ...grpc.CallOption) (*proto.Series, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetSeries", varargs...)
+	ret0, _ := ret[0].(*proto.Series)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSeries indicates an expected call of GetSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) GetSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).GetSeries), varargs...)
+}
+
+// GetStatistics mocks base method.
+func (m *MockTimeSeriesServiceClient) GetStatistics(ctx context.Context, in *proto.StatisticsRequest, opts ...grpc.CallOption) (*proto.StatisticsResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetStatistics", varargs...)
+	ret0, _ := ret[0].(*proto.StatisticsResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStatistics indicates an expected call of GetStatistics.
+func (mr *MockTimeSeriesServiceClientMockRecorder) GetStatistics(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStatistics", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).GetStatistics), varargs...)
+}
+
+// GetSummaries mocks base method.
+func (m *MockTimeSeriesServiceClient) GetSummaries(ctx context.Context, in *proto.SummariesRequest, opts ...grpc.CallOption) (*proto.SummariesResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetSummaries", varargs...)
+	ret0, _ := ret[0].(*proto.SummariesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSummaries indicates an expected call of GetSummaries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) GetSummaries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSummaries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).GetSummaries), varargs...)
+}
+
+// IngestTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceClient) IngestTimeSeries(ctx context.Context, opts ...grpc.CallOption) (proto.TimeSeriesService_IngestTimeSeriesClient, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "IngestTimeSeries", varargs...)
+	ret0, _ := ret[0].(proto.TimeSeriesService_IngestTimeSeriesClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IngestTimeSeries indicates an expected call of IngestTimeSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) IngestTimeSeries(ctx interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).IngestTimeSeries), varargs...)
+}
+
+// InsertTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceClient) InsertTimeSeries(ctx context.Context, in *proto.InsertRequest, opts ...grpc.CallOption) (*proto.InsertResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "InsertTimeSeries", varargs...)
+	ret0, _ := ret[0].(*proto.InsertResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertTimeSeries indicates an expected call of InsertTimeSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) InsertTimeSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).InsertTimeSeries), varargs...)
+}
+
+// ListDemandResponseEvents mocks base method.
+func (m *MockTimeSeriesServiceClient) ListDemandResponseEvents(ctx context.Context, in *proto.ListDemandResponseEventsRequest, opts ...grpc.CallOption) (*proto.ListDemandResponseEventsResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ListDemandResponseEvents", varargs...)
+	ret0, _ := ret[0].(*proto.ListDemandResponseEventsResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDemandResponseEvents indicates an expected call of ListDemandResponseEvents.
+func (mr *MockTimeSeriesServiceClientMockRecorder) ListDemandResponseEvents(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDemandResponseEvents", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).ListDemandResponseEvents), varargs...)
+}
+
+// ListSeries mocks base method.
+func (m *MockTimeSeriesServiceClient) ListSeries(ctx context.Context, in *proto.ListSeriesRequest, opts ...grpc.CallOption) (*proto.ListSeriesResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ListSeries", varargs...)
+	ret0, _ := ret[0].(*proto.ListSeriesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSeries indicates an expected call of ListSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) ListSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).ListSeries), varargs...)
+}
+
+// QueryEmissions mocks base method.
+func (m *MockTimeSeriesServiceClient) QueryEmissions(ctx context.Context, in *proto.EmissionsRequest, opts ...grpc.CallOption) (*proto.EmissionsResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryEmissions", varargs...)
+	ret0, _ := ret[0].(*proto.EmissionsResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryEmissions indicates an expected call of QueryEmissions.
+func (mr *MockTimeSeriesServiceClientMockRecorder) QueryEmissions(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryEmissions", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).QueryEmissions), varargs...)
+}
+
+// QueryRaw mocks base method.
+func (m *MockTimeSeriesServiceClient) QueryRaw(ctx context.Context, in *proto.RawQueryRequest, opts ...grpc.CallOption) (*proto.RawQueryResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryRaw", varargs...)
+	ret0, _ := ret[0].(*proto.RawQueryResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryRaw indicates an expected call of QueryRaw.
+func (mr *MockTimeSeriesServiceClientMockRecorder) QueryRaw(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRaw", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).QueryRaw), varargs...)
+}
+
 // QueryTimeSeries mocks base method.
 func (m *MockTimeSeriesServiceClient) QueryTimeSeries(ctx context.Context, in *proto.TimeSeriesRequest, opts ...grpc.CallOption) (*proto.TimeSeriesResponse, error) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{ctx, in}
-	for _, a := range opts {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "QueryTimeSeries", varargs...)
-	ret0, _ := ret[0].(*proto.TimeSeriesResponse)
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryTimeSeries", varargs...)
+	ret0, _ := ret[0].(*proto.TimeSeriesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryTimeSeries indicates an expected call of QueryTimeSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) QueryTimeSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).QueryTimeSeries), varargs...)
+}
+
+// RecordDemandResponseEvent mocks base method.
+func (m *MockTimeSeriesServiceClient) RecordDemandResponseEvent(ctx context.Context, in *proto.DemandResponseEvent, opts ...grpc.CallOption) (*proto.DemandResponseEvent, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RecordDemandResponseEvent", varargs...)
+	ret0, _ := ret[0].(*proto.DemandResponseEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecordDemandResponseEvent indicates an expected call of RecordDemandResponseEvent.
+func (mr *MockTimeSeriesServiceClientMockRecorder) RecordDemandResponseEvent(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordDemandResponseEvent", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).RecordDemandResponseEvent), varargs...)
+}
+
+// SubscribeTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceClient) SubscribeTimeSeries(ctx context.Context, in *proto.SubscribeRequest, opts ...grpc.CallOption) (proto.TimeSeriesService_SubscribeTimeSeriesClient, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SubscribeTimeSeries", varargs...)
+	ret0, _ := ret[0].(proto.TimeSeriesService_SubscribeTimeSeriesClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubscribeTimeSeries indicates an expected call of SubscribeTimeSeries.
+func (mr *MockTimeSeriesServiceClientMockRecorder) SubscribeTimeSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribeTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).SubscribeTimeSeries), varargs...)
+}
+
+// TopK mocks base method.
+func (m *MockTimeSeriesServiceClient) TopK(ctx context.Context, in *proto.TopKRequest, opts ...grpc.CallOption) (*proto.TopKResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "TopK", varargs...)
+	ret0, _ := ret[0].(*proto.TopKResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopK indicates an expected call of TopK.
+func (mr *MockTimeSeriesServiceClientMockRecorder) TopK(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopK", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).TopK), varargs...)
+}
+
+// MockTimeSeriesService_IngestTimeSeriesClient is a mock of TimeSeriesService_IngestTimeSeriesClient interface.
+type MockTimeSeriesService_IngestTimeSeriesClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder
+}
+
+// MockTimeSeriesService_IngestTimeSeriesClientMockRecorder is the mock recorder for MockTimeSeriesService_IngestTimeSeriesClient.
+type MockTimeSeriesService_IngestTimeSeriesClientMockRecorder struct {
+	mock *MockTimeSeriesService_IngestTimeSeriesClient
+}
+
+// NewMockTimeSeriesService_IngestTimeSeriesClient creates a new mock instance.
+func NewMockTimeSeriesService_IngestTimeSeriesClient(ctrl *gomock.Controller) *MockTimeSeriesService_IngestTimeSeriesClient {
+	mock := &MockTimeSeriesService_IngestTimeSeriesClient{ctrl: ctrl}
+	mock.recorder = &MockTimeSeriesService_IngestTimeSeriesClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeSeriesService_IngestTimeSeriesClient) EXPECT() *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder {
+	return m.recorder
+}
+
+// CloseAndRecv mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesClient) CloseAndRecv() (*proto.InsertResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseAndRecv")
+	ret0, _ := ret[0].(*proto.InsertResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CloseAndRecv indicates an expected call of CloseAndRecv.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) CloseAndRecv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseAndRecv", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).CloseAndRecv))
+}
+
+// CloseSend mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesClient) CloseSend() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseSend")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CloseSend indicates an expected call of CloseSend.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) CloseSend() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseSend", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).CloseSend))
+}
+
+// Context mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesClient) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).Context))
+}
+
+// Header mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesClient) Header() (metadata.MD, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Header")
+	ret0, _ := ret[0].(metadata.MD)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Header indicates an expected call of Header.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) Header() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Header", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).Header))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockTimeSeriesService_IngestTimeSeriesClient) RecvMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).RecvMsg), m)
+}
+
+// Send mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesClient) Send(arg0 *proto.InsertRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) Send(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).Send), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockTimeSeriesService_IngestTimeSeriesClient) SendMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).SendMsg), m)
+}
+
+// Trailer mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesClient) Trailer() metadata.MD {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Trailer")
+	ret0, _ := ret[0].(metadata.MD)
+	return ret0
+}
+
+// Trailer indicates an expected call of Trailer.
+func (mr *MockTimeSeriesService_IngestTimeSeriesClientMockRecorder) Trailer() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trailer", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesClient)(nil).Trailer))
+}
+
+// MockTimeSeriesService_ExportTimeSeriesClient is a mock of TimeSeriesService_ExportTimeSeriesClient interface.
+type MockTimeSeriesService_ExportTimeSeriesClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder
+}
+
+// MockTimeSeriesService_ExportTimeSeriesClientMockRecorder is the mock recorder for MockTimeSeriesService_ExportTimeSeriesClient.
+type MockTimeSeriesService_ExportTimeSeriesClientMockRecorder struct {
+	mock *MockTimeSeriesService_ExportTimeSeriesClient
+}
+
+// NewMockTimeSeriesService_ExportTimeSeriesClient creates a new mock instance.
+func NewMockTimeSeriesService_ExportTimeSeriesClient(ctrl *gomock.Controller) *MockTimeSeriesService_ExportTimeSeriesClient {
+	mock := &MockTimeSeriesService_ExportTimeSeriesClient{ctrl: ctrl}
+	mock.recorder = &MockTimeSeriesService_ExportTimeSeriesClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeSeriesService_ExportTimeSeriesClient) EXPECT() *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder {
+	return m.recorder
+}
+
+// CloseSend mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesClient) CloseSend() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseSend")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CloseSend indicates an expected call of CloseSend.
+func (mr *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder) CloseSend() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseSend", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesClient)(nil).CloseSend))
+}
+
+// Context mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesClient) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesClient)(nil).Context))
+}
+
+// Header mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesClient) Header() (metadata.MD, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Header")
+	ret0, _ := ret[0].(metadata.MD)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Header indicates an expected call of Header.
+func (mr *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder) Header() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Header", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesClient)(nil).Header))
+}
+
+// Recv mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesClient) Recv() (*proto.ExportChunk, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recv")
+	ret0, _ := ret[0].(*proto.ExportChunk)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recv indicates an expected call of Recv.
+func (mr *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder) Recv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recv", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesClient)(nil).Recv))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockTimeSeriesService_ExportTimeSeriesClient) RecvMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesClient)(nil).RecvMsg), m)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockTimeSeriesService_ExportTimeSeriesClient) SendMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesClient)(nil).SendMsg), m)
+}
+
+// Trailer mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesClient) Trailer() metadata.MD {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Trailer")
+	ret0, _ := ret[0].(metadata.MD)
+	return ret0
+}
+
+// Trailer indicates an expected call of Trailer.
+func (mr *MockTimeSeriesService_ExportTimeSeriesClientMockRecorder) Trailer() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trailer", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesClient)(nil).Trailer))
+}
+
+// MockTimeSeriesService_SubscribeTimeSeriesClient is a mock of TimeSeriesService_SubscribeTimeSeriesClient interface.
+type MockTimeSeriesService_SubscribeTimeSeriesClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder
+}
+
+// MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder is the mock recorder for MockTimeSeriesService_SubscribeTimeSeriesClient.
+type MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder struct {
+	mock *MockTimeSeriesService_SubscribeTimeSeriesClient
+}
+
+// NewMockTimeSeriesService_SubscribeTimeSeriesClient creates a new mock instance.
+func NewMockTimeSeriesService_SubscribeTimeSeriesClient(ctrl *gomock.Controller) *MockTimeSeriesService_SubscribeTimeSeriesClient {
+	mock := &MockTimeSeriesService_SubscribeTimeSeriesClient{ctrl: ctrl}
+	mock.recorder = &MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesClient) EXPECT() *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder {
+	return m.recorder
+}
+
+// CloseSend mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesClient) CloseSend() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseSend")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CloseSend indicates an expected call of CloseSend.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder) CloseSend() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseSend", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesClient)(nil).CloseSend))
+}
+
+// Context mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesClient) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesClient)(nil).Context))
+}
+
+// Header mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesClient) Header() (metadata.MD, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Header")
+	ret0, _ := ret[0].(metadata.MD)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Header indicates an expected call of Header.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder) Header() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Header", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesClient)(nil).Header))
+}
+
+// Recv mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesClient) Recv() (*proto.TimeSeriesResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recv")
+	ret0, _ := ret[0].(*proto.TimeSeriesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recv indicates an expected call of Recv.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder) Recv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recv", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesClient)(nil).Recv))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockTimeSeriesService_SubscribeTimeSeriesClient) RecvMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesClient)(nil).RecvMsg), m)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockTimeSeriesService_SubscribeTimeSeriesClient) SendMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesClient)(nil).SendMsg), m)
+}
+
+// Trailer mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesClient) Trailer() metadata.MD {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Trailer")
+	ret0, _ := ret[0].(metadata.MD)
+	return ret0
+}
+
+// Trailer indicates an expected call of Trailer.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesClientMockRecorder) Trailer() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trailer", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesClient)(nil).Trailer))
+}
+
+// MockTimeSeriesServiceServer is a mock of TimeSeriesServiceServer interface.
+type MockTimeSeriesServiceServer struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeSeriesServiceServerMockRecorder
+}
+
+// MockTimeSeriesServiceServerMockRecorder is the mock recorder for MockTimeSeriesServiceServer.
+type MockTimeSeriesServiceServerMockRecorder struct {
+	mock *MockTimeSeriesServiceServer
+}
+
+// NewMockTimeSeriesServiceServer creates a new mock instance.
+func NewMockTimeSeriesServiceServer(ctrl *gomock.Controller) *MockTimeSeriesServiceServer {
+	mock := &MockTimeSeriesServiceServer{ctrl: ctrl}
+	mock.recorder = &MockTimeSeriesServiceServerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeSeriesServiceServer) EXPECT() *MockTimeSeriesServiceServerMockRecorder {
+	return m.recorder
+}
+
+// CompareTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceServer) CompareTimeSeries(arg0 context.Context, arg1 *proto.CompareRequest) (*proto.CompareResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompareTimeSeries", arg0, arg1)
+	ret0, _ := ret[0].(*proto.CompareResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompareTimeSeries indicates an expected call of CompareTimeSeries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) CompareTimeSeries(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).CompareTimeSeries), arg0, arg1)
+}
+
+// ExportTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceServer) ExportTimeSeries(arg0 *proto.ExportRequest, arg1 proto.TimeSeriesService_ExportTimeSeriesServer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportTimeSeries", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportTimeSeries indicates an expected call of ExportTimeSeries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) ExportTimeSeries(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).ExportTimeSeries), arg0, arg1)
+}
+
+// GetBudgetStatus mocks base method.
+func (m *MockTimeSeriesServiceServer) GetBudgetStatus(arg0 context.Context, arg1 *proto.BudgetStatusRequest) (*proto.BudgetStatusResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBudgetStatus", arg0, arg1)
+	ret0, _ := ret[0].(*proto.BudgetStatusResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBudgetStatus indicates an expected call of GetBudgetStatus.
+func (mr *MockTimeSeriesServiceServerMockRecorder) GetBudgetStatus(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBudgetStatus", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).GetBudgetStatus), arg0, arg1)
+}
+
+// GetLatest mocks base method.
+func (m *MockTimeSeriesServiceServer) GetLatest(arg0 context.Context, arg1 *proto.LatestRequest) (*proto.LatestResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLatest", arg0, arg1)
+	ret0, _ := ret[0].(*proto.LatestResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLatest indicates an expected call of GetLatest.
+func (mr *MockTimeSeriesServiceServerMockRecorder) GetLatest(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLatest", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).GetLatest), arg0, arg1)
+}
+
+// GetSeries mocks base method.
+func (m *MockTimeSeriesServiceServer) GetSeries(arg0 context.Context, arg1 *proto.GetSeriesRequest) (*proto.Series, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSeries", arg0, arg1)
+	ret0, _ := ret[0].(*proto.Series)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSeries indicates an expected call of GetSeries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) GetSeries(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).GetSeries), arg0, arg1)
+}
+
+// GetStatistics mocks base method.
+func (m *MockTimeSeriesServiceServer) GetStatistics(arg0 context.Context, arg1 *proto.StatisticsRequest) (*proto.StatisticsResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStatistics", arg0, arg1)
+	ret0, _ := ret[0].(*proto.StatisticsResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStatistics indicates an expected call of GetStatistics.
+func (mr *MockTimeSeriesServiceServerMockRecorder) GetStatistics(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStatistics", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).GetStatistics), arg0, arg1)
+}
+
+// GetSummaries mocks base method.
+func (m *MockTimeSeriesServiceServer) GetSummaries(arg0 context.Context, arg1 *proto.SummariesRequest) (*proto.SummariesResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSummaries", arg0, arg1)
+	ret0, _ := ret[0].(*proto.SummariesResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// QueryTimeSeries indicates an expected call of QueryTimeSeries.
-func (mr *MockTimeSeriesServiceClientMockRecorder) QueryTimeSeries(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+// GetSummaries indicates an expected call of GetSummaries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) GetSummaries(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{ctx, in}, opts...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceClient)(nil).QueryTimeSeries), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSummaries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).GetSummaries), arg0, arg1)
 }
 
-// MockTimeSeriesServiceServer is a mock of TimeSeriesServiceServer interface.
-type MockTimeSeriesServiceServer struct {
-	ctrl     *gomock.Controller
-	recorder *MockTimeSeriesServiceServerMockRecorder
+// IngestTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceServer) IngestTimeSeries(arg0 proto.TimeSeriesService_IngestTimeSeriesServer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IngestTimeSeries", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// MockTimeSeriesServiceServerMockRecorder is the mock recorder for MockTimeSeriesServiceServer.
-type MockTimeSeriesServiceServerMockRecorder struct {
-	mock *MockTimeSeriesServiceServer
+// IngestTimeSeries indicates an expected call of IngestTimeSeries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) IngestTimeSeries(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).IngestTimeSeries), arg0)
 }
 
-// NewMockTimeSeriesServiceServer creates a new mock instance.
-func NewMockTimeSeriesServiceServer(ctrl *gomock.Controller) *MockTimeSeriesServiceServer {
-	mock := &MockTimeSeriesServiceServer{ctrl: ctrl}
-	mock.recorder = &MockTimeSeriesServiceServerMockRecorder{mock}
-	return mock
+// InsertTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceServer) InsertTimeSeries(arg0 context.Context, arg1 *proto.InsertRequest) (*proto.InsertResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertTimeSeries", arg0, arg1)
+	ret0, _ := ret[0].(*proto.InsertResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use.
-func (m *MockTimeSeriesServiceServer) EXPECT() *MockTimeSeriesServiceServerMockRecorder {
-	return m.recorder
+// InsertTimeSeries indicates an expected call of InsertTimeSeries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) InsertTimeSeries(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).InsertTimeSeries), arg0, arg1)
+}
+
+// ListDemandResponseEvents mocks base method.
+func (m *MockTimeSeriesServiceServer) ListDemandResponseEvents(arg0 context.Context, arg1 *proto.ListDemandResponseEventsRequest) (*proto.ListDemandResponseEventsResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDemandResponseEvents", arg0, arg1)
+	ret0, _ := ret[0].(*proto.ListDemandResponseEventsResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDemandResponseEvents indicates an expected call of ListDemandResponseEvents.
+func (mr *MockTimeSeriesServiceServerMockRecorder) ListDemandResponseEvents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDemandResponseEvents", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).ListDemandResponseEvents), arg0, arg1)
+}
+
+// ListSeries mocks base method.
+func (m *MockTimeSeriesServiceServer) ListSeries(arg0 context.Context, arg1 *proto.ListSeriesRequest) (*proto.ListSeriesResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSeries", arg0, arg1)
+	ret0, _ := ret[0].(*proto.ListSeriesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSeries indicates an expected call of ListSeries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) ListSeries(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).ListSeries), arg0, arg1)
+}
+
+// QueryEmissions mocks base method.
+func (m *MockTimeSeriesServiceServer) QueryEmissions(arg0 context.Context, arg1 *proto.EmissionsRequest) (*proto.EmissionsResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryEmissions", arg0, arg1)
+	ret0, _ := ret[0].(*proto.EmissionsResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryEmissions indicates an expected call of QueryEmissions.
+func (mr *MockTimeSeriesServiceServerMockRecorder) QueryEmissions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryEmissions", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).QueryEmissions), arg0, arg1)
+}
+
+// QueryRaw mocks base method.
+func (m *MockTimeSeriesServiceServer) QueryRaw(arg0 context.Context, arg1 *proto.RawQueryRequest) (*proto.RawQueryResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryRaw", arg0, arg1)
+	ret0, _ := ret[0].(*proto.RawQueryResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryRaw indicates an expected call of QueryRaw.
+func (mr *MockTimeSeriesServiceServerMockRecorder) QueryRaw(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRaw", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).QueryRaw), arg0, arg1)
 }
 
 // QueryTimeSeries mocks base method.
@@ -94,6 +991,50 @@ func (mr *MockTimeSeriesServiceServerMockRecorder) QueryTimeSeries(arg0, arg1 in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).QueryTimeSeries), arg0, arg1)
 }
 
+// RecordDemandResponseEvent mocks base method.
+func (m *MockTimeSeriesServiceServer) RecordDemandResponseEvent(arg0 context.Context, arg1 *proto.DemandResponseEvent) (*proto.DemandResponseEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordDemandResponseEvent", arg0, arg1)
+	ret0, _ := ret[0].(*proto.DemandResponseEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecordDemandResponseEvent indicates an expected call of RecordDemandResponseEvent.
+func (mr *MockTimeSeriesServiceServerMockRecorder) RecordDemandResponseEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordDemandResponseEvent", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).RecordDemandResponseEvent), arg0, arg1)
+}
+
+// SubscribeTimeSeries mocks base method.
+func (m *MockTimeSeriesServiceServer) SubscribeTimeSeries(arg0 *proto.SubscribeRequest, arg1 proto.TimeSeriesService_SubscribeTimeSeriesServer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubscribeTimeSeries", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SubscribeTimeSeries indicates an expected call of SubscribeTimeSeries.
+func (mr *MockTimeSeriesServiceServerMockRecorder) SubscribeTimeSeries(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribeTimeSeries", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).SubscribeTimeSeries), arg0, arg1)
+}
+
+// TopK mocks base method.
+func (m *MockTimeSeriesServiceServer) TopK(arg0 context.Context, arg1 *proto.TopKRequest) (*proto.TopKResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopK", arg0, arg1)
+	ret0, _ := ret[0].(*proto.TopKResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopK indicates an expected call of TopK.
+func (mr *MockTimeSeriesServiceServerMockRecorder) TopK(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopK", reflect.TypeOf((*MockTimeSeriesServiceServer)(nil).TopK), arg0, arg1)
+}
+
 // mustEmbedUnimplementedTimeSeriesServiceServer mocks base method.
 func (m *MockTimeSeriesServiceServer) mustEmbedUnimplementedTimeSeriesServiceServer() {
 	m.ctrl.T.Helper()
@@ -140,3 +1081,375 @@ func (mr *MockUnsafeTimeSeriesServiceServerMockRecorder) mustEmbedUnimplementedT
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "mustEmbedUnimplementedTimeSeriesServiceServer", reflect.TypeOf((*MockUnsafeTimeSeriesServiceServer)(nil).mustEmbedUnimplementedTimeSeriesServiceServer))
 }
+
+// MockTimeSeriesService_IngestTimeSeriesServer is a mock of TimeSeriesService_IngestTimeSeriesServer interface.
+type MockTimeSeriesService_IngestTimeSeriesServer struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder
+}
+
+// MockTimeSeriesService_IngestTimeSeriesServerMockRecorder is the mock recorder for MockTimeSeriesService_IngestTimeSeriesServer.
+type MockTimeSeriesService_IngestTimeSeriesServerMockRecorder struct {
+	mock *MockTimeSeriesService_IngestTimeSeriesServer
+}
+
+// NewMockTimeSeriesService_IngestTimeSeriesServer creates a new mock instance.
+func NewMockTimeSeriesService_IngestTimeSeriesServer(ctrl *gomock.Controller) *MockTimeSeriesService_IngestTimeSeriesServer {
+	mock := &MockTimeSeriesService_IngestTimeSeriesServer{ctrl: ctrl}
+	mock.recorder = &MockTimeSeriesService_IngestTimeSeriesServerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeSeriesService_IngestTimeSeriesServer) EXPECT() *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder {
+	return m.recorder
+}
+
+// Context mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesServer) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).Context))
+}
+
+// Recv mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesServer) Recv() (*proto.InsertRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recv")
+	ret0, _ := ret[0].(*proto.InsertRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recv indicates an expected call of Recv.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) Recv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recv", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).Recv))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockTimeSeriesService_IngestTimeSeriesServer) RecvMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).RecvMsg), m)
+}
+
+// SendAndClose mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesServer) SendAndClose(arg0 *proto.InsertResponse) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendAndClose", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendAndClose indicates an expected call of SendAndClose.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) SendAndClose(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendAndClose", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).SendAndClose), arg0)
+}
+
+// SendHeader mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesServer) SendHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendHeader indicates an expected call of SendHeader.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) SendHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendHeader", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).SendHeader), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockTimeSeriesService_IngestTimeSeriesServer) SendMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).SendMsg), m)
+}
+
+// SetHeader mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesServer) SetHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHeader indicates an expected call of SetHeader.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) SetHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHeader", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).SetHeader), arg0)
+}
+
+// SetTrailer mocks base method.
+func (m *MockTimeSeriesService_IngestTimeSeriesServer) SetTrailer(arg0 metadata.MD) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetTrailer", arg0)
+}
+
+// SetTrailer indicates an expected call of SetTrailer.
+func (mr *MockTimeSeriesService_IngestTimeSeriesServerMockRecorder) SetTrailer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTrailer", reflect.TypeOf((*MockTimeSeriesService_IngestTimeSeriesServer)(nil).SetTrailer), arg0)
+}
+
+// MockTimeSeriesService_ExportTimeSeriesServer is a mock of TimeSeriesService_ExportTimeSeriesServer interface.
+type MockTimeSeriesService_ExportTimeSeriesServer struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder
+}
+
+// MockTimeSeriesService_ExportTimeSeriesServerMockRecorder is the mock recorder for MockTimeSeriesService_ExportTimeSeriesServer.
+type MockTimeSeriesService_ExportTimeSeriesServerMockRecorder struct {
+	mock *MockTimeSeriesService_ExportTimeSeriesServer
+}
+
+// NewMockTimeSeriesService_ExportTimeSeriesServer creates a new mock instance.
+func NewMockTimeSeriesService_ExportTimeSeriesServer(ctrl *gomock.Controller) *MockTimeSeriesService_ExportTimeSeriesServer {
+	mock := &MockTimeSeriesService_ExportTimeSeriesServer{ctrl: ctrl}
+	mock.recorder = &MockTimeSeriesService_ExportTimeSeriesServerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeSeriesService_ExportTimeSeriesServer) EXPECT() *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder {
+	return m.recorder
+}
+
+// Context mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesServer) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesServer)(nil).Context))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockTimeSeriesService_ExportTimeSeriesServer) RecvMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesServer)(nil).RecvMsg), m)
+}
+
+// Send mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesServer) Send(arg0 *proto.ExportChunk) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder) Send(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesServer)(nil).Send), arg0)
+}
+
+// SendHeader mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesServer) SendHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendHeader indicates an expected call of SendHeader.
+func (mr *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder) SendHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendHeader", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesServer)(nil).SendHeader), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockTimeSeriesService_ExportTimeSeriesServer) SendMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesServer)(nil).SendMsg), m)
+}
+
+// SetHeader mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesServer) SetHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHeader indicates an expected call of SetHeader.
+func (mr *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder) SetHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHeader", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesServer)(nil).SetHeader), arg0)
+}
+
+// SetTrailer mocks base method.
+func (m *MockTimeSeriesService_ExportTimeSeriesServer) SetTrailer(arg0 metadata.MD) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetTrailer", arg0)
+}
+
+// SetTrailer indicates an expected call of SetTrailer.
+func (mr *MockTimeSeriesService_ExportTimeSeriesServerMockRecorder) SetTrailer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTrailer", reflect.TypeOf((*MockTimeSeriesService_ExportTimeSeriesServer)(nil).SetTrailer), arg0)
+}
+
+// MockTimeSeriesService_SubscribeTimeSeriesServer is a mock of TimeSeriesService_SubscribeTimeSeriesServer interface.
+type MockTimeSeriesService_SubscribeTimeSeriesServer struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder
+}
+
+// MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder is the mock recorder for MockTimeSeriesService_SubscribeTimeSeriesServer.
+type MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder struct {
+	mock *MockTimeSeriesService_SubscribeTimeSeriesServer
+}
+
+// NewMockTimeSeriesService_SubscribeTimeSeriesServer creates a new mock instance.
+func NewMockTimeSeriesService_SubscribeTimeSeriesServer(ctrl *gomock.Controller) *MockTimeSeriesService_SubscribeTimeSeriesServer {
+	mock := &MockTimeSeriesService_SubscribeTimeSeriesServer{ctrl: ctrl}
+	mock.recorder = &MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesServer) EXPECT() *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder {
+	return m.recorder
+}
+
+// Context mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesServer) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesServer)(nil).Context))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockTimeSeriesService_SubscribeTimeSeriesServer) RecvMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesServer)(nil).RecvMsg), m)
+}
+
+// Send mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesServer) Send(arg0 *proto.TimeSeriesResponse) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder) Send(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesServer)(nil).Send), arg0)
+}
+
+// SendHeader mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesServer) SendHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendHeader indicates an expected call of SendHeader.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder) SendHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendHeader", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesServer)(nil).SendHeader), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockTimeSeriesService_SubscribeTimeSeriesServer) SendMsg(m any) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesServer)(nil).SendMsg), m)
+}
+
+// SetHeader mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesServer) SetHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHeader indicates an expected call of SetHeader.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder) SetHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHeader", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesServer)(nil).SetHeader), arg0)
+}
+
+// SetTrailer mocks base method.
+func (m *MockTimeSeriesService_SubscribeTimeSeriesServer) SetTrailer(arg0 metadata.MD) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetTrailer", arg0)
+}
+
+// SetTrailer indicates an expected call of SetTrailer.
+func (mr *MockTimeSeriesService_SubscribeTimeSeriesServerMockRecorder) SetTrailer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTrailer", reflect.TypeOf((*MockTimeSeriesService_SubscribeTimeSeriesServer)(nil).SetTrailer), arg0)
+}
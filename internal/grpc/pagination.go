@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+const (
+	// defaultPageSize is used when a paged query does not set page_size
+	defaultPageSize = 1000
+	// defaultMaxPageSize caps the number of samples or buckets returned
+	// per page until the point limits are changed
+	defaultMaxPageSize = 10000
+)
+
+// pageCursor identifies where the next page of a raw query resumes: at
+// Time, after skipping the Skip samples at exactly that timestamp which
+// were already returned. Pages of aggregated buckets resume after the
+// bucket at Time and never skip.
+type pageCursor struct {
+	Time time.Time `json:"t"`
+	Skip int       `json:"s"`
+}
+
+// encodePageToken serializes a cursor into an opaque page token.
+func encodePageToken(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken parses a page token produced by encodePageToken.
+func decodePageToken(token string) (pageCursor, error) {
+	var c pageCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, fmt.Errorf("invalid page token")
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.Time.IsZero() || c.Skip < 0 {
+		return c, fmt.Errorf("invalid page token")
+	}
+	return c, nil
+}
+
+// nextPageCursor returns the cursor following a page of samples read from
+// cursor. Samples are ordered by time, so duplicates of the last timestamp
+// are always at the end of the page.
+func nextPageCursor(cursor pageCursor, page []models.TimeSeriesData) pageCursor {
+	last := page[len(page)-1].Time
+
+	seen := 0
+	for i := len(page) - 1; i >= 0 && page[i].Time.Equal(last); i-- {
+		seen++
+	}
+
+	// The whole page shared the cursor timestamp, keep counting from it
+	if last.Equal(cursor.Time) {
+		seen += cursor.Skip
+	}
+
+	return pageCursor{Time: last, Skip: seen}
+}
+
+// pageSize applies the default and the cap of maxPageSize to a requested
+// page size.
+func pageSize(requested int32, maxPageSize int) (int, error) {
+	switch {
+	case requested < 0:
+		return 0, fmt.Errorf("page_size must not be negative")
+	case requested == 0:
+		return defaultPageSize, nil
+	case int(requested) > maxPageSize:
+		return maxPageSize, nil
+	default:
+		return int(requested), nil
+	}
+}
@@ -1,7 +1,19 @@
 // Package server implements the gRPC service for time series data querying.
 //
 // The server provides:
-//   - Time series data querying with various aggregations
+//   - Time series data querying with various aggregations, optionally
+//     restricted to the working hours of a business calendar, of the
+//     stored series or of derived series computed from it
+//   - Paginated access to raw (unaggregated) samples
+//   - Latest-value lookups for dashboards
+//   - Unary and client-streaming writes for external producers
+//   - Carbon emissions reporting from grid intensity factors
+//   - Weather-normalized consumption for comparisons across years
+//   - Demand response event tracking against a historical baseline
+//   - Monthly budget status with month-end projections
+//   - Daily and monthly consumption summaries maintained on ingest
+//   - Streamed exports of raw or aggregated data as CSV, NDJSON or Parquet
+//   - Live subscriptions to newly written raw or aggregated data
 //   - Request validation and error handling
 //   - Middleware support for:
 //   - Request rate limiting
@@ -9,7 +21,12 @@
 //   - Metrics collection
 //   - Logging
 //   - Context management
+//   - An optional admin service for backfills, cache clearing, pausing
+//     the scheduler, configuration reloads, ingest watermarks and saving
+//     virtual series
 //   - Prometheus metrics integration
+//   - gzip compression negotiated by clients
+//   - Configurable message size limits with descriptive errors
 //   - gRPC reflection for debugging
 //
 // Example Usage:
@@ -35,37 +52,254 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	// Registers the gzip compressor, so clients can request compressed
+	// responses by compressing their requests
+	_ "google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"github.com/tejusbharadwaj/edgecom/internal/auth"
+	"github.com/tejusbharadwaj/edgecom/internal/budget"
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
+	"github.com/tejusbharadwaj/edgecom/internal/carbon"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/codec"
 	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/demandresponse"
+	"github.com/tejusbharadwaj/edgecom/internal/export"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
 	middleware "github.com/tejusbharadwaj/edgecom/internal/grpc/middlewares"
+	"github.com/tejusbharadwaj/edgecom/internal/ingest"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
+	"github.com/tejusbharadwaj/edgecom/internal/weather"
 	pb "github.com/tejusbharadwaj/edgecom/proto"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// emissionsResolution is the coarsest granularity at which consumption is
+// matched with carbon intensity, which grid operators publish hourly
+const emissionsResolution = time.Hour
+
+// maxLatestCount caps the number of points returned by GetLatest
+const maxLatestCount = 1000
+
+// Rate limits for the write and export methods, separate from the shared
+// query limit
+const (
+	insertRateLimit         = 50.0 // InsertTimeSeries requests per second
+	insertRateLimitBurst    = 100
+	ingestRateLimit         = 1.0 // IngestTimeSeries streams opened per second
+	ingestRateLimitBurst    = 10
+	exportRateLimit         = 0.2 // ExportTimeSeries streams opened per second
+	exportRateLimitBurst    = 3
+	subscribeRateLimit      = 1.0 // SubscribeTimeSeries streams opened per second
+	subscribeRateLimitBurst = 10
+)
+
+// exportChunkSize is the size of the data in each ExportTimeSeries message
+const exportChunkSize = 64 * 1024
+
+// Default limits on how long calls may run: calls made without a deadline
+// get DefaultRequestTimeout, and no call may run longer than
+// DefaultMaxRequestTimeout
+const (
+	DefaultRequestTimeout    = 30 * time.Second
+	DefaultMaxRequestTimeout = 5 * time.Minute
+)
+
+// exportTimeout bounds ExportTimeSeries streams, which may take longer
+// than queries to write a large range
+const exportTimeout = 10 * time.Minute
+
+// Default limits on the queries executing at once: calls beyond
+// DefaultMaxConcurrentQueries wait, up to DefaultMaxQueuedQueries at a
+// time, for DefaultQueryQueueTimeout
+const (
+	DefaultMaxConcurrentQueries = 32
+	DefaultMaxQueuedQueries     = 100
+	DefaultQueryQueueTimeout    = 5 * time.Second
+)
+
+// DefaultMaxMessageSize is the default limit on the size of request and
+// response messages, matching gRPC's default receive limit
+const DefaultMaxMessageSize = 4 * 1024 * 1024
+
+// DefaultCacheMaxBytes is the default memory budget of the response cache
+const DefaultCacheMaxBytes = middleware.DefaultCacheMaxBytes
+
+// DefaultCacheErrorTTL is how long the response cache keeps errors that
+// repeating a request cannot change, such as InvalidArgument
+const DefaultCacheErrorTTL = middleware.DefaultErrorTTL
+
+// DefaultHandoffMaxAge is how long after being saved by SaveHandoff state
+// is restored by default
+const DefaultHandoffMaxAge = middleware.DefaultHandoffMaxAge
+
+// DefaultCacheMaxStaleness is how long invalidated responses are served to
+// calls failing with database errors once enabled
+const DefaultCacheMaxStaleness = 15 * time.Minute
+
 // ServerConfig holds configuration options for the gRPC server.
 // It controls caching, rate limiting, and other server behaviors.
 type ServerConfig struct {
-	CacheSize      int     // Size of the LRU cache
-	RateLimit      float64 // Requests per second
-	RateLimitBurst int     // Maximum burst size for rate limiting
+	CacheSize      int           // Size of the LRU cache
+	CacheMaxBytes  int64         // Memory budget of the LRU cache, in bytes; DefaultCacheMaxBytes when zero
+	CacheErrorTTL  time.Duration // How long errors such as InvalidArgument are cached; zero disables it
+	RateLimit      float64       // Requests per second
+	RateLimitBurst int           // Maximum burst size for rate limiting
+	MaxRecvMsgSize int           // Largest request message accepted, in bytes; DefaultMaxMessageSize when zero
+	MaxSendMsgSize int           // Largest response message sent, in bytes; DefaultMaxMessageSize when zero
+
+	// CacheMaxStaleness is how long responses invalidated by new data are
+	// kept and served, flagged as stale, to calls failing with
+	// Unavailable or Internal, such as while the database is down; zero
+	// disables it
+	CacheMaxStaleness time.Duration
+
+	// RequestTimeout is the deadline given to unary calls made without
+	// one, and MaxRequestTimeout the furthest deadline a unary call may
+	// have; zero disables either. MethodTimeouts gives the methods it
+	// lists, by full method name, their own timeout used as both, zero
+	// disabling it; streaming methods are only bounded when listed, and
+	// exports are bounded to 10 minutes unless listed.
+	RequestTimeout    time.Duration
+	MaxRequestTimeout time.Duration
+	MethodTimeouts    map[string]time.Duration
+
+	// Concurrency bounds the queries executing at once, globally and per
+	// client, for the unary read methods and exports, sharing slots fairly
+	// between clients. Clients are told apart by their authenticated
+	// provider and subject, such as "static:reporting", or by their
+	// address when calls are not authenticated; the gateway's
+	// authenticated calls are only held to the global limit.
+	Concurrency middleware.ConcurrencyLimits
+
+	// CoalesceWindows holds calls to the read methods it lists, by full
+	// method name, for their window, so that identical calls arriving
+	// within it share a single execution
+	CoalesceWindows map[string]time.Duration
+
+	// Authenticator, when set, identifies the caller of every call except
+	// health checks; calls it cannot identify are rejected
+	Authenticator middleware.Authenticator
+	// Authorizer, when set along with Authenticator, decides which
+	// methods each identified caller may call; calls it does not allow
+	// are rejected
+	Authorizer middleware.Authorizer
+	// Auditor, when set, records every call except health checks once it
+	// completes
+	Auditor middleware.Auditor
+	// TLS, when set, serves over TLS with this configuration, which may
+	// request client certificates for mTLS authentication
+	TLS *tls.Config
+	// Admin registers the AdminService, whose methods change the running
+	// service; callers should be restricted with an Authorizer
+	Admin bool
 }
 
 // DefaultServerConfig returns a ServerConfig with sensible defaults
 func DefaultServerConfig() ServerConfig {
 	return ServerConfig{
 		CacheSize:      1000,
+		CacheMaxBytes:  DefaultCacheMaxBytes,
+		CacheErrorTTL:  DefaultCacheErrorTTL,
 		RateLimit:      5.0, // 5 requests per second
 		RateLimitBurst: 10,  // Burst of 10 requests
+		MaxRecvMsgSize: DefaultMaxMessageSize,
+		MaxSendMsgSize: DefaultMaxMessageSize,
+
+		RequestTimeout:    DefaultRequestTimeout,
+		MaxRequestTimeout: DefaultMaxRequestTimeout,
+
+		Concurrency: middleware.ConcurrencyLimits{
+			MaxInFlight:  DefaultMaxConcurrentQueries,
+			MaxQueued:    DefaultMaxQueuedQueries,
+			QueueTimeout: DefaultQueryQueueTimeout,
+		},
+	}
+}
+
+// Server bundles the gRPC server with the middleware components that other
+// parts of the service observe or control at runtime.
+type Server struct {
+	*grpc.Server
+
+	// Cache is the response cache used by the interceptor chain
+	Cache *middleware.Cache
+	// Health is the registered gRPC health service
+	Health *HealthChecker
+	// Service is the registered time series service
+	Service *TimeSeriesService
+	// Admin is the registered admin service, nil unless enabled
+	Admin *AdminService
+
+	rateLimiter *middleware.RateLimiter
+}
+
+// handoffPollInterval is how often AwaitHandoff looks for handed off state
+const handoffPollInterval = time.Second
+
+// SaveHandoff saves the cached responses, computed at the ingest watermark
+// watermark, and the tokens left in the rate limit buckets to the file of
+// replica in dir, for a starting process to restore with AwaitHandoff. It
+// must be called once the server has stopped.
+func (s *Server) SaveHandoff(dir, replica string, watermark time.Time) error {
+	return middleware.SaveHandoff(middleware.HandoffPath(dir, replica), watermark, s.Cache, s.rateLimiter)
+}
+
+// AwaitHandoff restores the cached responses and rate limit buckets saved
+// to dir by a stopping replica, unless saved more than maxAge before. In a
+// rolling deploy the replica being replaced only stops once its
+// replacement serves, so dir is watched until state is restored, for
+// maxAge at most, or until ctx is done. It returns zero stats when no
+// state was handed off, and may be called while the server serves.
+func (s *Server) AwaitHandoff(ctx context.Context, dir, replica string, maxAge time.Duration) (middleware.HandoffStats, error) {
+	deadline := time.NewTimer(maxAge)
+	defer deadline.Stop()
+	ticker := time.NewTicker(handoffPollInterval)
+	defer ticker.Stop()
+	for {
+		path, err := middleware.ClaimHandoff(dir, replica)
+		if err != nil {
+			return middleware.HandoffStats{}, err
+		}
+		if path != "" {
+			return middleware.LoadHandoff(path, maxAge, s.Cache, s.rateLimiter)
+		}
+		select {
+		case <-ctx.Done():
+			return middleware.HandoffStats{}, nil
+		case <-deadline.C:
+			return middleware.HandoffStats{}, nil
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -75,42 +309,1151 @@ type TimeSeriesService struct {
 	pb.UnimplementedTimeSeriesServiceServer
 	repository database.TimeSeriesRepository
 	validator  *RequestValidator
+	calendars  map[string]*calendar.Calendar
+
+	// derived holds the derived series of the configuration
+	derived *expression.Derived
+	// descriptions describe the derived series of the configuration
+	descriptions map[string]models.SeriesDescription
+
+	// series holds the derived series queries may name, including the
+	// virtual series, once they are loaded
+	series         atomic.Pointer[seriesSet]
+	seriesMu       sync.Mutex
+	onSeriesChange func()
+
+	// carbonSource converts consumption into emissions
+	carbonSource carbon.Source
+
+	// kWhPerUnit converts stored consumption into kWh
+	kWhPerUnit float64
+
+	// weatherSource provides the temperatures queries are normalized with,
+	// relative to balancePoint and against the normal weather of the
+	// previous normalYears years
+	weatherSource weather.Source
+	balancePoint  float64
+	normalYears   int
+
+	// budgets reports budget status, if configured
+	budgets *budget.Tracker
+
+	// broker delivers newly written points to SubscribeTimeSeries streams
+	broker *stream.Broker
+
+	// clock is the clock demand response events and budgets are evaluated
+	// against
+	clock clock.Clock
+
+	// exportCodec compresses gzipped TSZ exports; the default codec when
+	// nil
+	exportCodec codec.Codec
+
+	// maxPageSize caps the samples or buckets returned per page
+	maxPageSize atomic.Int64
+}
+
+// PointLimits bound the number of points a single call reads or writes.
+type PointLimits struct {
+	// MaxPageSize caps the samples or buckets returned per page of a query
+	MaxPageSize int
+	// MaxInsertPoints caps the points of a single write
+	MaxInsertPoints int
 }
 
 // NewTimeSeriesService creates a new service instance
 func NewTimeSeriesService(repo database.TimeSeriesRepository) *TimeSeriesService {
-	return &TimeSeriesService{
+	s := &TimeSeriesService{
 		repository: repo,
 		validator:  NewRequestValidator(),
+		kWhPerUnit: 1,
+		clock:      clock.System,
+	}
+	s.maxPageSize.Store(defaultMaxPageSize)
+	return s
+}
+
+// PointLimits returns the point limits in effect.
+func (s *TimeSeriesService) PointLimits() PointLimits {
+	return PointLimits{
+		MaxPageSize:     int(s.maxPageSize.Load()),
+		MaxInsertPoints: int(s.validator.maxInsertPoints.Load()),
+	}
+}
+
+// SetPointLimits changes the point limits, which must be positive. It may
+// be called while the service is serving.
+func (s *TimeSeriesService) SetPointLimits(limits PointLimits) error {
+	if limits.MaxPageSize <= 0 || limits.MaxInsertPoints <= 0 {
+		return fmt.Errorf("point limits must be positive")
 	}
+	s.maxPageSize.Store(int64(limits.MaxPageSize))
+	s.validator.maxInsertPoints.Store(int64(limits.MaxInsertPoints))
+	return nil
+}
+
+// SetClock sets the clock demand response events, budgets and the points
+// written are evaluated against, instead of the system clock. It must be
+// called before the service starts serving.
+func (s *TimeSeriesService) SetClock(clk clock.Clock) {
+	s.clock = clk
+	s.validator.clock = clk
+}
+
+// SetCalendars sets the business calendars requests may name. It must be
+// called before the service starts serving.
+func (s *TimeSeriesService) SetCalendars(calendars map[string]*calendar.Calendar) {
+	s.calendars = calendars
+}
+
+// SetDerivedSeries sets the derived series requests may name, computed
+// from the stored series. It must be called before the service starts
+// serving and before ReloadVirtualSeries.
+func (s *TimeSeriesService) SetDerivedSeries(derived *expression.Derived) {
+	s.derived = derived
+}
+
+// SetCarbon sets the carbon intensity source used by QueryEmissions. It
+// must be called before the service starts serving.
+func (s *TimeSeriesService) SetCarbon(source carbon.Source) {
+	s.carbonSource = source
+}
+
+// SetKWhPerUnit sets the number of kWh per stored unit of consumption,
+// which QueryEmissions and GetSummaries report in. It defaults to 1 and
+// must be called before the service starts serving.
+func (s *TimeSeriesService) SetKWhPerUnit(kWhPerUnit float64) {
+	s.kWhPerUnit = kWhPerUnit
+}
+
+// SetWeather sets the temperature source weather-normalized queries are
+// fitted with, the balance point their heating and cooling degrees are
+// relative to and the number of previous years their normal weather is
+// averaged over. It must be called before the service starts serving.
+func (s *TimeSeriesService) SetWeather(source weather.Source, balancePoint float64, normalYears int) {
+	s.weatherSource = source
+	s.balancePoint = balancePoint
+	s.normalYears = normalYears
+}
+
+// SetBudgets sets the budget tracker used by GetBudgetStatus. It must be
+// called before the service starts serving.
+func (s *TimeSeriesService) SetBudgets(budgets *budget.Tracker) {
+	s.budgets = budgets
+}
+
+// SetBroker sets the broker newly written points are published to, which
+// SubscribeTimeSeries streams them from. It must be called before the
+// service starts serving.
+func (s *TimeSeriesService) SetBroker(broker *stream.Broker) {
+	s.broker = broker
+}
+
+// SetExportCodec sets the codec compressing TSZ exports requested with
+// gzip, the default codec until it is called. It must be called before
+// the service starts serving.
+func (s *TimeSeriesService) SetExportCodec(c codec.Codec) {
+	s.exportCodec = c
 }
 
 // QueryTimeSeries retrieves time series data based on the provided request parameters.
-// It supports various time windows and aggregation methods.
+// It supports various time windows and aggregation methods. When the request
+// names a calendar, each bucket aggregates only the samples within its
+// working hours.
+//
+// Setting page_size or page_token pages the buckets in time order, with
+// page_size defaulting to 1000 and capped at 10000; next_page_token is set
+// while more buckets remain. Without either, all buckets are returned.
+//
+// Setting max_points caps the number of points returned, for clients that
+// plot the data. Without a window, the finest window with no more buckets
+// than max_points is chosen and returned in the response. If there are
+// still more buckets, they are reduced to max_points with LTTB
+// downsampling, which keeps the buckets that shape the plotted line.
+//
+// Every response carries metadata describing how its points were produced:
+// the effective window and aggregation, the number of samples behind each
+// point and the time the database query took. With include_checksum, it
+// also carries a checksum of the points and the ingest watermark at query
+// time, so that reports can later be re-verified: repeating the query
+// after backfills or corrections yields the same checksum only if the
+// data is unchanged, and a watermark before the end of the range shows
+// that the data may have been incomplete.
+//
+// Naming a derived series in series computes it from each bucket of the
+// stored series, before downsampling, for the aggregations validateDerived
+// accepts. A label selector
+// in selector, such as {site="plant1",phase="A"}, chooses the series by
+// its labels instead and must select exactly one, unless group_by names
+// labels to group the series it selects by, such as site. The series of
+// each group are then combined bucket by bucket, summed for SUM, averaged
+// for AVG and reduced to their minimum or maximum for MIN and MAX, and
+// returned in groups instead of data.
+//
+// Setting transform computes DELTA, the difference between each bucket and
+// the previous one, RATE, that difference per second, or CUMSUM, the
+// running sum of the buckets, after any derived series and before
+// downsampling, so that cumulative meter counters can be turned into
+// consumption and consumption into a running total server-side. DELTA and
+// RATE omit the first bucket, which has no previous one, and take a bucket
+// below the previous one for a counter reset, counting it from zero. A
+// transform cannot be combined with paging, as each page would start
+// afresh.
+//
+// With weather_normalized, SUM and AVG buckets are restated at the normal
+// weather of their time of year, so that years with different weather can
+// be compared; the fitted model is returned with them. See
+// normalizeWeather. It cannot be combined with paging or a transform.
 func (s *TimeSeriesService) QueryTimeSeries(
 	ctx context.Context,
 	req *pb.TimeSeriesRequest,
 ) (*pb.TimeSeriesResponse, error) {
+	if req.Selector != "" && len(req.GroupBy) == 0 {
+		series, err := s.resolveSelector(ctx, req.Series, req.Selector)
+		if err != nil {
+			return nil, err
+		}
+		req = proto.Clone(req).(*pb.TimeSeriesRequest)
+		req.Series, req.Selector = series, ""
+	}
+
 	// Convert protobuf timestamps
 	start := req.Start.AsTime()
 	end := req.End.AsTime()
 
+	if req.MaxPoints < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "max_points must not be negative")
+	}
+	if req.MaxPoints > 0 && (req.PageSize != 0 || req.PageToken != "") {
+		return nil, status.Errorf(codes.InvalidArgument, "max_points cannot be combined with paging")
+	}
+	if err := validateTransform(req.Transform); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	if req.Transform != "" && (req.PageSize != 0 || req.PageToken != "") {
+		return nil, status.Errorf(codes.InvalidArgument, "transform cannot be combined with paging")
+	}
+	if len(req.GroupBy) > 0 {
+		if err := validateGroupBy(req); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+	}
+
+	// Choose the window from the requested density
+	window := req.Window
+	if window == "" && req.MaxPoints > 0 {
+		if err := s.validator.ValidateRange(start, end); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+		window = chooseWindow(start, end, int(req.MaxPoints))
+	}
+
 	// Validate request
 	if err := s.validator.Validate(
-		start, end, req.Window, req.Aggregation,
+		start, end, window, req.Aggregation,
 	); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
 	}
+	if req.WeatherNormalized {
+		if err := s.validateWeatherNormalized(req); err != nil {
+			return nil, err
+		}
+	}
+
+	var cal *calendar.Calendar
+	if req.Calendar != "" {
+		var ok bool
+		cal, ok = s.calendars[req.Calendar]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown calendar: %s", req.Calendar)
+		}
+	}
+	derived := s.derivedSeries()
+	if req.Series != "" && req.Series != database.DefaultSeries && !derived.Has(req.Series) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown series: %s", req.Series)
+	}
+	if err := validateDerived(derived, req.Series, req.Aggregation); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	if len(req.GroupBy) > 0 {
+		return s.queryGroups(ctx, req, start, end, window, cal, derived)
+	}
+
+	if req.PageSize != 0 || req.PageToken != "" {
+		return s.queryTimeSeriesPage(ctx, req, start, end, cal, derived)
+	}
+
+	watermark, err := s.checksumWatermark(ctx, req)
+	if err != nil {
+		return nil, err
+	}
 
 	// Query data
-	dataPoints, err := s.repository.Query(
-		ctx, start, end, req.Window, req.Aggregation,
+	queryStart := time.Now()
+	dataPoints, err := s.queryBuckets(ctx, start, end, window, req.Aggregation, cal)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(queryStart)
+	dataPoints = deriveSeries(derived, req.Series, req.Aggregation, dataPoints)
+	dataPoints = applyTransform(req.Transform, dataPoints)
+
+	resp := &pb.TimeSeriesResponse{}
+	if req.WeatherNormalized {
+		dataPoints, resp.WeatherModel, err = s.normalizeWeather(ctx, start, end, window, dataPoints)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	downsampled := false
+	if req.MaxPoints > 0 {
+		downsampled = len(dataPoints) > int(req.MaxPoints)
+		dataPoints = downsampleLTTB(dataPoints, int(req.MaxPoints))
+		if req.Window == "" {
+			resp.Window = window
+		}
+	}
+	resp.Data = toProtoDataPoints(dataPoints)
+	resp.Metadata = queryMetadata(window, req.Aggregation, req.Calendar, dataPoints, elapsed)
+	resp.Metadata.Downsampled = downsampled
+	resp.Metadata.Series = derivedName(derived, req.Series)
+	resp.Metadata.Transform = req.Transform
+	if req.IncludeChecksum {
+		addChecksum(resp.Metadata, watermark, dataPoints)
+	}
+
+	return resp, nil
+}
+
+// queryBuckets aggregates the stored series over the windows from start
+// to end, within the working hours of cal if set
+func (s *TimeSeriesService) queryBuckets(
+	ctx context.Context,
+	start, end time.Time,
+	window, aggregation string,
+	cal *calendar.Calendar,
+) ([]models.TimeSeriesData, error) {
+	var buckets []models.TimeSeriesData
+	var err error
+	if cal != nil {
+		buckets, err = s.repository.QueryBusinessHours(ctx, start, end, window, aggregation, cal)
+	} else {
+		buckets, err = s.repository.Query(ctx, start, end, window, aggregation)
+	}
+	if err != nil {
+		return nil, storageError(err, "query failed: %v", err)
+	}
+	return buckets, nil
+}
+
+// validateDerived checks that the buckets of aggregation of the derived
+// series name can be computed from those of the stored series, as they
+// are instead of aggregating the series' own samples: averages of linear
+// series, sums of linear series, with the offset of each sample added, and
+// minimums and maximums of series that never decrease as the stored
+// series increases. Load factors and utilizations, being ratios, cannot be
+// computed for derived series.
+func validateDerived(derived *expression.Derived, name, aggregation string) error {
+	if !derived.Has(name) {
+		return nil
+	}
+	shape := derived.Shape(name, database.DefaultSeries)
+	switch aggregation {
+	case AggregationAvg, AggregationSum:
+		if !shape.Linear {
+			return fmt.Errorf("derived series %s is not linear in the stored series, so its %s buckets cannot be computed from those of the stored series", name, aggregation)
+		}
+	case AggregationMin, AggregationMax:
+		if !shape.Nondecreasing {
+			return fmt.Errorf("derived series %s can decrease as the stored series increases, so its %s buckets cannot be computed from those of the stored series", name, aggregation)
+		}
+	default:
+		return fmt.Errorf("%s buckets cannot be computed for derived series %s", aggregation, name)
+	}
+	return nil
+}
+
+// deriveSeries computes the derived series name from points of the stored
+// series aggregated with aggregation, omitting the points where it is
+// undefined. Points are returned as they are for the stored series. The
+// pair must have passed validateDerived.
+func deriveSeries(derived *expression.Derived, name, aggregation string, points []models.TimeSeriesData) []models.TimeSeriesData {
+	if !derived.Has(name) {
+		return points
+	}
+	values := make([]models.TimeSeriesData, 0, len(points))
+	for _, p := range points {
+		v, ok := seriesValue(derived, name, aggregation, p)
+		if !ok {
+			continue
+		}
+		p.Value = v
+		values = append(values, p)
+	}
+	return values
+}
+
+// seriesValue returns the value of the series name at a bucket of the
+// stored series aggregated with aggregation, or false where a derived
+// series is undefined. The sum of a linear series adds its offset once per
+// sample of the bucket.
+func seriesValue(derived *expression.Derived, name, aggregation string, bucket models.TimeSeriesData) (float64, bool) {
+	if !derived.Has(name) {
+		return bucket.Value, true
+	}
+	if aggregation == AggregationSum {
+		shape := derived.Shape(name, database.DefaultSeries)
+		return shape.Scale*bucket.Value + shape.Offset*float64(bucket.Count), shape.Linear
+	}
+	v, err := derived.Eval(name, map[string]float64{database.DefaultSeries: bucket.Value})
+	return v, err == nil
+}
+
+// derivedName returns name if it is a derived series, and an empty string
+// for the stored series
+func derivedName(derived *expression.Derived, name string) string {
+	if derived.Has(name) {
+		return name
+	}
+	return ""
+}
+
+// queryMetadata describes the aggregated points of a QueryTimeSeries
+// response
+func queryMetadata(window, aggregation, calendarName string, points []models.TimeSeriesData, elapsed time.Duration) *pb.QueryMetadata {
+	meta := &pb.QueryMetadata{
+		Window:        window,
+		Aggregation:   aggregation,
+		SampleCounts:  make([]int64, len(points)),
+		QueryDuration: durationpb.New(elapsed),
+		Calendar:      calendarName,
+	}
+	for i, p := range points {
+		meta.SampleCounts[i] = p.Count
+		meta.TotalSamples += p.Count
+	}
+	return meta
+}
+
+// checksumWatermark returns the ingest watermark for the checksum of req,
+// or the zero time if none is requested. It is read before the query, so
+// that the data checksummed includes at least all that was ingested up to
+// it.
+func (s *TimeSeriesService) checksumWatermark(ctx context.Context, req *pb.TimeSeriesRequest) (time.Time, error) {
+	if !req.IncludeChecksum {
+		return time.Time{}, nil
+	}
+	watermark, err := s.repository.Watermark(ctx)
+	if err != nil {
+		return time.Time{}, storageError(err, "failed to read watermark: %v", err)
+	}
+	return watermark, nil
+}
+
+// addChecksum sets the checksum of points and the ingest watermark read
+// before they were queried in meta
+func addChecksum(meta *pb.QueryMetadata, watermark time.Time, points []models.TimeSeriesData) {
+	if !watermark.IsZero() {
+		meta.Watermark = timestamppb.New(watermark)
+	}
+	meta.Checksum = checksum(points)
+}
+
+// checksum returns the hex-encoded SHA-256 of points, each encoded as its
+// time in Unix nanoseconds, the IEEE 754 bits of its value and its sample
+// count, all big-endian 64-bit integers
+func checksum(points []models.TimeSeriesData) string {
+	h := sha256.New()
+	var buf [24]byte
+	for _, p := range points {
+		binary.BigEndian.PutUint64(buf[0:], uint64(p.Time.UnixNano()))
+		binary.BigEndian.PutUint64(buf[8:], math.Float64bits(p.Value))
+		binary.BigEndian.PutUint64(buf[16:], uint64(p.Count))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// queryTimeSeriesPage returns one page of a validated QueryTimeSeries
+// request. The page token holds the time of the last bucket returned, so
+// pages stay consistent with each other however large the range is.
+func (s *TimeSeriesService) queryTimeSeriesPage(
+	ctx context.Context,
+	req *pb.TimeSeriesRequest,
+	start, end time.Time,
+	cal *calendar.Calendar,
+	derived *expression.Derived,
+) (*pb.TimeSeriesResponse, error) {
+	size, err := pageSize(req.PageSize, int(s.maxPageSize.Load()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	// The zero cursor starts at the first bucket, which may begin before
+	// start when start is not aligned to the window
+	var cursor pageCursor
+	if req.PageToken != "" {
+		cursor, err = decodePageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+		if cursor.Skip != 0 || cursor.Time.After(end) {
+			return nil, status.Errorf(codes.InvalidArgument, "page token does not match the requested range")
+		}
+	}
+
+	watermark, err := s.checksumWatermark(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra bucket to learn whether another page exists
+	queryStart := time.Now()
+	buckets, err := s.repository.QueryPage(
+		ctx, start, end, req.Window, req.Aggregation, cal, cursor.Time, size+1,
 	)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "query failed: %v", err)
+		return nil, storageError(err, "query failed: %v", err)
+	}
+	elapsed := time.Since(queryStart)
+
+	resp := &pb.TimeSeriesResponse{}
+	if len(buckets) > size {
+		buckets = buckets[:size]
+		resp.NextPageToken = encodePageToken(pageCursor{Time: buckets[size-1].Time})
+	}
+	buckets = deriveSeries(derived, req.Series, req.Aggregation, buckets)
+	resp.Data = toProtoDataPoints(buckets)
+	resp.Metadata = queryMetadata(req.Window, req.Aggregation, req.Calendar, buckets, elapsed)
+	resp.Metadata.Series = derivedName(derived, req.Series)
+	if req.IncludeChecksum {
+		addChecksum(resp.Metadata, watermark, buckets)
+	}
+
+	return resp, nil
+}
+
+// QueryRaw returns stored samples without aggregation, one page at a time.
+// Page size defaults to 1000 and is capped at 10000; next_page_token is set
+// while more samples remain in the range.
+func (s *TimeSeriesService) QueryRaw(
+	ctx context.Context,
+	req *pb.RawQueryRequest,
+) (*pb.RawQueryResponse, error) {
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+
+	if err := s.validator.ValidateRange(start, end); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	size, err := pageSize(req.PageSize, int(s.maxPageSize.Load()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	cursor := pageCursor{Time: start}
+	if req.PageToken != "" {
+		cursor, err = decodePageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+		if cursor.Time.Before(start) || cursor.Time.After(end) {
+			return nil, status.Errorf(codes.InvalidArgument, "page token does not match the requested range")
+		}
+	}
+
+	// Fetch one extra sample to learn whether another page exists
+	samples, err := s.repository.QueryRaw(ctx, cursor.Time, end, cursor.Skip, size+1)
+	if err != nil {
+		return nil, storageError(err, "query failed: %v", err)
+	}
+
+	resp := &pb.RawQueryResponse{}
+	if len(samples) > size {
+		samples = samples[:size]
+		resp.NextPageToken = encodePageToken(nextPageCursor(cursor, samples))
+	}
+	resp.Data = toProtoDataPoints(samples)
+
+	return resp, nil
+}
+
+// GetLatest returns the most recent samples, newest first, so dashboards
+// can show the current reading without requesting a full window. Count
+// defaults to 1 and is capped at 1000.
+func (s *TimeSeriesService) GetLatest(
+	ctx context.Context,
+	req *pb.LatestRequest,
+) (*pb.LatestResponse, error) {
+	count, err := latestCount(req.Count)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	dataPoints, err := s.repository.QueryLatest(ctx, count)
+	if err != nil {
+		return nil, storageError(err, "query failed: %v", err)
 	}
 
-	// Convert to protobuf response
+	return &pb.LatestResponse{
+		Data: toProtoDataPoints(dataPoints),
+	}, nil
+}
+
+// GetStatistics summarises the samples in a range with one aggregate
+// query, so capacity planning does not have to fetch and reduce the full
+// series. An empty range has a zero count and no other fields set.
+func (s *TimeSeriesService) GetStatistics(
+	ctx context.Context,
+	req *pb.StatisticsRequest,
+) (*pb.StatisticsResponse, error) {
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+
+	if err := s.validator.ValidateRange(start, end); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	stats, err := s.repository.Statistics(ctx, start, end)
+	if err != nil {
+		return nil, storageError(err, "query failed: %v", err)
+	}
+
+	resp := &pb.StatisticsResponse{Count: stats.Count}
+	if stats.Count > 0 {
+		resp.Min = stats.Min
+		resp.Max = stats.Max
+		resp.Avg = stats.Avg
+		resp.Sum = stats.Sum
+		resp.Stddev = stats.Stddev
+		resp.FirstTime = timestamppb.New(stats.FirstTime)
+		resp.LastTime = timestamppb.New(stats.LastTime)
+	}
+	return resp, nil
+}
+
+// latestCount applies the default and cap to a requested latest count.
+func latestCount(requested int32) (int, error) {
+	switch {
+	case requested < 0:
+		return 0, fmt.Errorf("count must not be negative")
+	case requested == 0:
+		return 1, nil
+	case requested > maxLatestCount:
+		return maxLatestCount, nil
+	default:
+		return int(requested), nil
+	}
+}
+
+// InsertTimeSeries stores the points of a single request in one
+// transaction.
+func (s *TimeSeriesService) InsertTimeSeries(
+	ctx context.Context,
+	req *pb.InsertRequest,
+) (*pb.InsertResponse, error) {
+	points := fromProtoDataPoints(req.Data)
+	if err := s.validator.ValidatePoints(points); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	if err := s.repository.BatchInsertTimeSeriesData(ctx, points); err != nil {
+		return nil, storageError(err, "insert failed: %v", err)
+	}
+
+	return &pb.InsertResponse{Inserted: int64(len(points))}, nil
+}
+
+// IngestTimeSeries stores points streamed by a producer. Each message is
+// validated and committed on its own, so points from messages received
+// before a failure remain stored; the response reports the total once the
+// client closes the stream.
+func (s *TimeSeriesService) IngestTimeSeries(stream pb.TimeSeriesService_IngestTimeSeriesServer) error {
+	var inserted int64
+
+	for message := 0; ; message++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.InsertResponse{Inserted: inserted})
+		}
+		if err != nil {
+			return err
+		}
+
+		points := fromProtoDataPoints(req.Data)
+		if err := s.validator.ValidatePoints(points); err != nil {
+			return status.Errorf(codes.InvalidArgument, "message %d: %s", message, err.Error())
+		}
+
+		if err := s.repository.BatchInsertTimeSeriesData(stream.Context(), points); err != nil {
+			return storageError(err, "insert failed after %d points: %v", inserted, err)
+		}
+		inserted += int64(len(points))
+	}
+}
+
+// fromProtoDataPoints converts protobuf data points to the internal
+// representation. Missing timestamps become the zero time, which the
+// validator rejects.
+func fromProtoDataPoints(dataPoints []*pb.TimeSeriesDataPoint) []models.TimeSeriesData {
+	points := make([]models.TimeSeriesData, len(dataPoints))
+	for i, dp := range dataPoints {
+		if dp.GetTime() != nil {
+			points[i].Time = dp.Time.AsTime()
+		}
+		points[i].Value = dp.GetValue()
+	}
+	return points
+}
+
+// QueryEmissions converts consumption into CO2e per bucket using the
+// configured carbon intensity source. Consumption is summed per hour, or
+// per window if finer, so that hourly intensity factors are applied to the
+// energy used in each hour before buckets are rolled up to the window.
+func (s *TimeSeriesService) QueryEmissions(
+	ctx context.Context,
+	req *pb.EmissionsRequest,
+) (*pb.EmissionsResponse, error) {
+	if s.carbonSource == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "carbon intensity is not configured")
+	}
+
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+
+	if err := s.validator.Validate(start, end, req.Window, AggregationSum); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	resolution := req.Window
+	if windowDurations[req.Window] > emissionsResolution {
+		resolution = Window1h
+	}
+
+	consumption, err := s.repository.Query(ctx, start, end, resolution, AggregationSum)
+	if err != nil {
+		return nil, storageError(err, "query failed: %v", err)
+	}
+
+	schedule, err := s.carbonSource.Schedule(ctx, start, end)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "carbon intensity unavailable: %v", err)
+	}
+
+	emissions, err := carbon.Compute(consumption, schedule, s.kWhPerUnit, windowDurations[req.Window])
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err.Error())
+	}
+
+	resp := &pb.EmissionsResponse{}
+	for _, e := range emissions {
+		resp.Data = append(resp.Data, &pb.EmissionsBucket{
+			Time:        timestamppb.New(e.Time),
+			Consumption: e.Consumption,
+			Intensity:   e.Intensity,
+			EmissionsKg: e.Kilograms,
+		})
+		resp.TotalEmissionsKg += e.Kilograms
+	}
+
+	return resp, nil
+}
+
+// RecordDemandResponseEvent stores a demand response event and returns it
+// with its assigned id.
+func (s *TimeSeriesService) RecordDemandResponseEvent(
+	ctx context.Context,
+	req *pb.DemandResponseEvent,
+) (*pb.DemandResponseEvent, error) {
+	if req.Start == nil || req.End == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "event start and end are required")
+	}
+
+	event := models.DemandResponseEvent{
+		Name:            req.Name,
+		Start:           req.Start.AsTime(),
+		End:             req.End.AsTime(),
+		TargetReduction: req.TargetReduction,
+	}
+	if err := demandresponse.Validate(event); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	recorded, err := s.repository.RecordDemandResponseEvent(ctx, event)
+	if err != nil {
+		return nil, storageError(err, "failed to record event: %v", err)
+	}
+
+	return toProtoDemandResponseEvent(recorded), nil
+}
+
+// ListDemandResponseEvents returns the demand response events overlapping
+// the requested range, each with the reduction it achieved against its
+// baseline. Events still in progress are evaluated on the data so far.
+func (s *TimeSeriesService) ListDemandResponseEvents(
+	ctx context.Context,
+	req *pb.ListDemandResponseEventsRequest,
+) (*pb.ListDemandResponseEventsResponse, error) {
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+
+	if err := s.validator.ValidateRange(start, end); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	events, err := s.repository.DemandResponseEvents(ctx, start, end)
+	if err != nil {
+		return nil, storageError(err, "query failed: %v", err)
+	}
+
+	now := s.clock.Now()
+	resp := &pb.ListDemandResponseEventsResponse{}
+	for _, event := range events {
+		performance, err := demandresponse.Evaluate(ctx, s.repository, event, now)
+		if err != nil {
+			return nil, storageError(err, "%s", err.Error())
+		}
+		resp.Events = append(resp.Events, &pb.DemandResponsePerformance{
+			Event:             toProtoDemandResponseEvent(event),
+			Evaluated:         performance.Evaluated,
+			Complete:          performance.Complete,
+			Baseline:          performance.Baseline,
+			Actual:            performance.Actual,
+			AchievedReduction: performance.AchievedReduction,
+			TargetAchieved:    performance.TargetAchieved,
+			BaselineDays:      int32(performance.BaselineDays),
+		})
+	}
+
+	return resp, nil
+}
+
+// GetBudgetStatus returns the month-to-date actual and projected month-end
+// value of each configured budget.
+func (s *TimeSeriesService) GetBudgetStatus(
+	ctx context.Context,
+	req *pb.BudgetStatusRequest,
+) (*pb.BudgetStatusResponse, error) {
+	if s.budgets == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "budgets are not configured")
+	}
+
+	statuses, err := s.budgets.Status(ctx, s.clock.Now())
+	if err != nil {
+		return nil, storageError(err, "%s", err.Error())
+	}
+
+	resp := &pb.BudgetStatusResponse{}
+	for _, st := range statuses {
+		resp.Budgets = append(resp.Budgets, &pb.BudgetStatus{
+			Name:              st.Budget.Name,
+			Kind:              st.Budget.Kind,
+			Limit:             st.Budget.Limit,
+			MonthStart:        timestamppb.New(st.MonthStart),
+			MonthEnd:          timestamppb.New(st.MonthEnd),
+			Actual:            st.Actual,
+			Projected:         st.Projected,
+			UsedFraction:      st.UsedFraction,
+			ProjectedFraction: st.ProjectedFraction,
+			CrossedThresholds: st.Crossed,
+		})
+	}
+
+	return resp, nil
+}
+
+// GetSummaries returns the daily or monthly consumption summaries of the
+// periods starting in the requested range, read from the summaries table
+// maintained on ingest rather than aggregated from the samples. Start is
+// aligned down to the start of its period, so a range within a single
+// month returns that month. Peak demand is the highest hourly consumption,
+// the hour's average kW, and the load factor relates it to the average
+// demand of the hours with samples.
+func (s *TimeSeriesService) GetSummaries(
+	ctx context.Context,
+	req *pb.SummariesRequest,
+) (*pb.SummariesResponse, error) {
+	start := req.Start.AsTime().UTC()
+	end := req.End.AsTime()
+
+	var next func(time.Time) time.Time
+	switch req.Period {
+	case models.PeriodDay:
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case models.PeriodMonth:
+		start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid period %q, expected %q or %q",
+			req.Period, models.PeriodDay, models.PeriodMonth)
+	}
+	if err := s.validator.ValidateRange(start, end); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	summaries, err := s.repository.Summaries(ctx, req.Period, start, end)
+	if err != nil {
+		return nil, storageError(err, "query failed: %v", err)
+	}
+
+	resp := &pb.SummariesResponse{}
+	for _, summary := range summaries {
+		var loadFactor float64
+		if summary.Peak > 0 && summary.Hours > 0 {
+			loadFactor = summary.Total / (summary.Peak * float64(summary.Hours))
+		}
+		resp.Summaries = append(resp.Summaries, &pb.ConsumptionSummary{
+			Start:       timestamppb.New(summary.Start),
+			End:         timestamppb.New(next(summary.Start.UTC())),
+			TotalKwh:    summary.Total * s.kWhPerUnit,
+			PeakKw:      summary.Peak * s.kWhPerUnit,
+			PeakTime:    timestamppb.New(summary.PeakTime),
+			LoadFactor:  loadFactor,
+			SampleCount: summary.SampleCount,
+		})
+	}
+
+	return resp, nil
+}
+
+// ExportTimeSeries streams raw samples, or the aggregation of each window
+// when a window is set, as a file in the requested format. The first
+// message carries the content type and a suggested file name; the file's
+// bytes follow in chunks of at most exportChunkSize. The range is read
+// from the repository in batches, so long ranges are not held in memory.
+func (s *TimeSeriesService) ExportTimeSeries(req *pb.ExportRequest, stream pb.TimeSeriesService_ExportTimeSeriesServer) error {
+	if req.Start == nil || req.End == nil {
+		return status.Errorf(codes.InvalidArgument, "missing timestamp")
+	}
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+
+	if req.Window == "" {
+		if req.Aggregation != "" {
+			return status.Errorf(codes.InvalidArgument, "aggregation requires a window")
+		}
+		if err := s.validator.ValidateRange(start, end); err != nil {
+			return status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+	} else if err := s.validator.Validate(start, end, req.Window, req.Aggregation); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	format := req.Format
+	if format == "" {
+		format = export.FormatCSV
+	}
+
+	sender := &chunkSender{stream: stream}
+	buffered := bufio.NewWriterSize(sender, exportChunkSize)
+	writer, err := s.exportWriter(buffered, format, req.Gzip)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	filename := fmt.Sprintf("edgecom-%s-%s", start.UTC().Format("20060102T1504"), end.UTC().Format("20060102T1504"))
+	if req.Window != "" {
+		filename += fmt.Sprintf("-%s-%s", req.Window, strings.ToLower(req.Aggregation))
+	}
+	if err := stream.Send(&pb.ExportChunk{
+		ContentType: export.ContentType(format, req.Gzip),
+		Filename:    filename + "." + export.FileExtension(format, req.Gzip),
+	}); err != nil {
+		return err
+	}
+
+	err = export.Stream(stream.Context(), s.repository, export.Range{
+		Start:       start,
+		End:         end,
+		Window:      req.Window,
+		Aggregation: req.Aggregation,
+	}, writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		if sender.err != nil {
+			return sender.err
+		}
+		return storageError(err, "export failed: %v", err)
+	}
+	return nil
+}
+
+// exportWriter returns the writer of an export, compressing gzipped TSZ
+// exports with the configured codec
+func (s *TimeSeriesService) exportWriter(w io.Writer, format string, compress bool) (export.Writer, error) {
+	if format == export.FormatTSZ && compress && s.exportCodec != nil {
+		return export.NewTSZWriter(w, s.exportCodec), nil
+	}
+	return export.NewWriter(w, format, compress)
+}
+
+// SubscribeTimeSeries streams points as they are written, by the
+// scheduler or by producers, until the client cancels the call. Without a
+// window, each message holds a newly written batch; with one, the buckets
+// the batch touched, recomputed from storage so they are exact even when
+// a bucket spans several batches. Points outside the optional start and
+// end are ignored.
+//
+// Each stream buffers up to buffer_size batches, and one that falls
+// behind never stalls ingestion: by its overflow policy it skips the
+// oldest buffered batches, pauses until it has drained its buffer and is
+// then resynced from the repository, or is ended with ResourceExhausted,
+// as is one paused for too long. When the server shuts down, streams end
+// with Unavailable so clients reconnect elsewhere.
+func (s *TimeSeriesService) SubscribeTimeSeries(req *pb.SubscribeRequest, srv pb.TimeSeriesService_SubscribeTimeSeriesServer) error {
+	if s.broker == nil {
+		return status.Errorf(codes.Unimplemented, "live updates are not enabled")
+	}
+	if req.Window != "" || req.Aggregation != "" {
+		if err := s.validator.ValidateAggregation(req.Window, req.Aggregation); err != nil {
+			return status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+	}
+	var start, end time.Time
+	if req.Start != nil {
+		start = req.Start.AsTime()
+	}
+	if req.End != nil {
+		end = req.End.AsTime()
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return status.Errorf(codes.InvalidArgument, "end must not be before start")
+	}
+
+	sub, err := s.broker.SubscribeWith(stream.SubscribeOptions{
+		BufferSize: int(req.BufferSize),
+		Overflow:   stream.OverflowPolicy(req.Overflow),
+	})
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	defer sub.Close()
+
+	ctx := srv.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case batch, ok := <-sub.C:
+			if !ok {
+				if errors.Is(sub.Err(), stream.ErrSlowConsumer) {
+					return status.Errorf(codes.ResourceExhausted,
+						"disconnected for falling behind: buffer of %d updates full; subscribe again, with a larger buffer_size or another overflow policy", cap(sub.C))
+				}
+				return status.Errorf(codes.Unavailable, "server is shutting down")
+			}
+
+			batch = stream.InRange(batch, start, end)
+			if len(batch) == 0 {
+				continue
+			}
+			points := batch
+			if req.Window != "" {
+				var err error
+				points, err = stream.BucketUpdates(ctx, s.repository, batch, req.Window, req.Aggregation)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return storageError(err, "failed to aggregate update: %v", err)
+				}
+			}
+
+			if err := srv.Send(&pb.TimeSeriesResponse{
+				Data:   toProtoDataPoints(points),
+				Window: req.Window,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// chunkSender sends written bytes as ExportTimeSeries messages, remembering
+// the first send error so it is reported instead of the writer's wrapping
+type chunkSender struct {
+	stream pb.TimeSeriesService_ExportTimeSeriesServer
+	err    error
+}
+
+func (c *chunkSender) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for written := 0; written < len(p); {
+		n := min(len(p)-written, exportChunkSize)
+		// Interceptors may hold on to sent messages, and the caller reuses p
+		data := append([]byte(nil), p[written:written+n]...)
+		if err := c.stream.Send(&pb.ExportChunk{Data: data}); err != nil {
+			c.err = err
+			return written, err
+		}
+		written += n
+	}
+	return len(p), nil
+}
+
+// storageCode returns the status code of a call failing with a repository
+// error, telling clients whether to retry: Unavailable for transient
+// errors, such as while the database fails over, DeadlineExceeded and
+// Canceled when the call's context ended the query, FailedPrecondition for
+// utilization without a contracted capacity and writes of a disabled
+// ingestion source, and Internal for errors that retrying cannot fix
+func storageCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, database.ErrNoContractedCapacity), errors.Is(err, ingest.ErrDisabled):
+		return codes.FailedPrecondition
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case database.IsTransient(err):
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// storageRetryDelay is the delay advised to callers of calls failing with
+// a transient repository error, about as long as a failover takes to
+// detect
+const storageRetryDelay = time.Second
+
+// storageError returns the status of a call failing with the repository
+// error err, with the message given by format and args. Transient errors
+// carry RetryInfo advising when to try again.
+func storageError(err error, format string, args ...interface{}) error {
+	code := storageCode(err)
+	st := status.Newf(code, format, args...)
+	if code == codes.Unavailable {
+		if withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(storageRetryDelay)}); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+// toProtoDemandResponseEvent converts an event to its protobuf representation
+func toProtoDemandResponseEvent(event models.DemandResponseEvent) *pb.DemandResponseEvent {
+	return &pb.DemandResponseEvent{
+		Id:              event.ID,
+		Name:            event.Name,
+		Start:           timestamppb.New(event.Start),
+		End:             timestamppb.New(event.End),
+		TargetReduction: event.TargetReduction,
+	}
+}
+
+// toProtoDataPoints converts data points to their protobuf representation
+func toProtoDataPoints(dataPoints []models.TimeSeriesData) []*pb.TimeSeriesDataPoint {
 	var pbResults []*pb.TimeSeriesDataPoint
 	for _, dp := range dataPoints {
 		pbResults = append(pbResults, &pb.TimeSeriesDataPoint{
@@ -118,10 +1461,7 @@ func (s *TimeSeriesService) QueryTimeSeries(
 			Value: dp.Value,
 		})
 	}
-
-	return &pb.TimeSeriesResponse{
-		Data: pbResults,
-	}, nil
+	return pbResults
 }
 
 // gRPC Server Configuration without the middleware (for development and debug only)
@@ -140,28 +1480,156 @@ func ConfigureGRPCServer(
 }
 
 // SetupServer initializes and configures the gRPC server with all middleware
-func SetupServer(repo database.TimeSeriesRepository, config ServerConfig) (*grpc.Server, error) {
+func SetupServer(repo database.TimeSeriesRepository, config ServerConfig) (*Server, error) {
 	// Use the default registry
-	return SetupServerWithRegistry(repo, logrus.StandardLogger(), prometheus.DefaultRegisterer)
+	return setupServer(repo, config, logrus.StandardLogger(), prometheus.DefaultRegisterer)
+}
+
+// SetupServerWithRegistry initializes the server with the default
+// configuration and a custom registry
+func SetupServerWithRegistry(repo database.TimeSeriesRepository, logger *logrus.Logger, reg prometheus.Registerer) (*Server, error) {
+	return setupServer(repo, DefaultServerConfig(), logger, reg)
 }
 
-// SetupServerWithRegistry initializes the server with a custom registry
-func SetupServerWithRegistry(repo database.TimeSeriesRepository, logger *logrus.Logger, reg prometheus.Registerer) (*grpc.Server, error) {
+func setupServer(repo database.TimeSeriesRepository, config ServerConfig, logger *logrus.Logger, reg prometheus.Registerer) (*Server, error) {
+	if config.MaxRecvMsgSize < 0 || config.MaxSendMsgSize < 0 {
+		return nil, fmt.Errorf("message size limits must not be negative")
+	}
+	if config.CacheMaxBytes < 0 {
+		return nil, fmt.Errorf("cache memory budget must not be negative")
+	}
+	if config.CacheMaxStaleness < 0 {
+		return nil, fmt.Errorf("cache staleness bound must not be negative")
+	}
+	if config.CacheMaxBytes == 0 {
+		config.CacheMaxBytes = DefaultCacheMaxBytes
+	}
+	if config.MaxRecvMsgSize == 0 {
+		config.MaxRecvMsgSize = DefaultMaxMessageSize
+	}
+	if config.MaxSendMsgSize == 0 {
+		config.MaxSendMsgSize = DefaultMaxMessageSize
+	}
+
 	// Initialize middleware components
-	cache, err := middleware.NewCache(1000)
+	cache, err := middleware.NewCache(config.CacheSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cache: %v", err)
 	}
+	cache.SetMaxBytes(config.CacheMaxBytes)
+	cache.SetErrorTTL(config.CacheErrorTTL)
+	cache.SetServeStale(config.CacheMaxStaleness)
+	cache.SetScopeFunc(responseScope)
+
+	// The latest reading, event performance, budget status and the series
+	// catalog change with every ingest and the cache has no expiry, so they
+	// must always be read from the repository. Monthly summaries change
+	// with ingests after the requested range, which do not evict it, so
+	// they are not cached either.
+	cache.Exclude(writeMethods...)
+	cache.Exclude(
+		pb.TimeSeriesService_GetLatest_FullMethodName,
+		pb.TimeSeriesService_ListDemandResponseEvents_FullMethodName,
+		pb.TimeSeriesService_GetBudgetStatus_FullMethodName,
+		pb.TimeSeriesService_GetSummaries_FullMethodName,
+		pb.TimeSeriesService_ListSeries_FullMethodName,
+		pb.TimeSeriesService_GetSeries_FullMethodName,
+	)
+	// Admin calls act on the running service, so every call must reach it
+	for _, m := range pb.AdminService_ServiceDesc.Methods {
+		cache.Exclude("/" + pb.AdminService_ServiceDesc.ServiceName + "/" + m.MethodName)
+	}
 
-	rateLimiter := middleware.NewRateLimiter(5.0, 10)
+	// Identical cache misses arriving within a method's window share one
+	// execution. Writes must each be applied, so they are never coalesced.
+	coalesced := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edgecom_grpc_coalesced_requests_total",
+			Help: "Calls answered by the execution of an identical call",
+		},
+		[]string{"method"},
+	)
+	coalescer := middleware.NewCoalescer(coalesced)
+	coalescer.SetScopeFunc(responseScope)
+	for method, window := range config.CoalesceWindows {
+		if !isCoalescable(method) {
+			return nil, fmt.Errorf("method %s cannot be coalesced", method)
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("coalescing window of %s must be positive", method)
+		}
+		coalescer.SetMethodWindow(method, window)
+	}
+
+	// Writes and exports get their own limits so producers, analysts and
+	// dashboards do not starve each other
+	rateLimiter := middleware.NewRateLimiter(config.RateLimit, config.RateLimitBurst)
+	rateLimiter.SetMethodLimit(pb.TimeSeriesService_InsertTimeSeries_FullMethodName, insertRateLimit, insertRateLimitBurst)
+	rateLimiter.SetMethodLimit(pb.TimeSeriesService_IngestTimeSeries_FullMethodName, ingestRateLimit, ingestRateLimitBurst)
+	rateLimiter.SetMethodLimit(pb.TimeSeriesService_ExportTimeSeries_FullMethodName, exportRateLimit, exportRateLimitBurst)
+	rateLimiter.SetMethodLimit(pb.TimeSeriesService_SubscribeTimeSeries_FullMethodName, subscribeRateLimit, subscribeRateLimitBurst)
+
+	// Calls without a deadline must not tie up a database connection
+	// indefinitely. Backfills record their progress and are bounded by
+	// their range instead.
+	if config.RequestTimeout < 0 || config.MaxRequestTimeout < 0 {
+		return nil, fmt.Errorf("request timeouts must not be negative")
+	}
+	deadlines := middleware.NewDeadlines(config.RequestTimeout, config.MaxRequestTimeout)
+	deadlines.SetMethodTimeout(pb.TimeSeriesService_ExportTimeSeries_FullMethodName, exportTimeout)
+	deadlines.SetMethodTimeout(pb.AdminService_Backfill_FullMethodName, 0)
+	for method, timeout := range config.MethodTimeouts {
+		if timeout < 0 {
+			return nil, fmt.Errorf("timeout of %s must not be negative", method)
+		}
+		deadlines.SetMethodTimeout(method, timeout)
+	}
+
+	// Expensive queries must not saturate the database even within the
+	// rate limits. Only executions are limited: cache hits and coalesced
+	// calls are answered before they take a slot.
+	limits := config.Concurrency
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "edgecom_grpc_queries_in_flight",
+		Help: "Queries executing, bounded by the concurrency limits",
+	})
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "edgecom_grpc_queries_queued",
+		Help: "Queries waiting for the concurrency limits",
+	})
+	clientInFlight := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "edgecom_grpc_client_queries_in_flight",
+		Help: "Queries executing, by client",
+	}, []string{"client"})
+	clientQueued := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "edgecom_grpc_client_queries_queued",
+		Help: "Queries waiting for the concurrency limits, by client",
+	}, []string{"client"})
+	concurrency := middleware.NewConcurrencyLimiter(limits, inFlight, queued)
+	concurrency.SetClientFunc(concurrencyClient)
+	concurrency.SetClientMetrics(clientInFlight, clientQueued)
+	for _, m := range pb.TimeSeriesService_ServiceDesc.Methods {
+		if method := "/" + pb.TimeSeriesService_ServiceDesc.ServiceName + "/" + m.MethodName; isCoalescable(method) {
+			concurrency.Include(method)
+		}
+	}
+	concurrency.Include(pb.TimeSeriesService_ExportTimeSeries_FullMethodName)
+
+	// Log every call, including those rejected by the rate limiter.
+	// GetLatest is polled by dashboards and would drown out other entries.
+	requestLogger := middleware.NewRequestLogger(logger)
+	requestLogger.SetMethodLevel(pb.TimeSeriesService_GetLatest_FullMethodName, logrus.DebugLevel)
 
 	// Initialize metrics
 	requests := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "grpc_requests_total",
-			Help: "Total number of gRPC requests handled",
+			Help: "Total number of gRPC requests handled, by status code",
 		},
-		[]string{"method"},
+		[]string{"method", "code"},
 	)
 
 	latency := prometheus.NewHistogramVec(
@@ -173,6 +1641,15 @@ func SetupServerWithRegistry(repo database.TimeSeriesRepository, logger *logrus.
 		[]string{"method"},
 	)
 
+	responsePoints := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "edgecom_grpc_response_points",
+			Help:    "Points returned by successful requests",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		},
+		[]string{"method"},
+	)
+
 	// Register metrics
 	if err := reg.Register(requests); err != nil {
 		return nil, fmt.Errorf("failed to register requests metric: %v", err)
@@ -180,36 +1657,167 @@ func SetupServerWithRegistry(repo database.TimeSeriesRepository, logger *logrus.
 	if err := reg.Register(latency); err != nil {
 		return nil, fmt.Errorf("failed to register latency metric: %v", err)
 	}
+	if err := reg.Register(responsePoints); err != nil {
+		return nil, fmt.Errorf("failed to register response points metric: %v", err)
+	}
+	if err := reg.Register(coalesced); err != nil {
+		return nil, fmt.Errorf("failed to register coalescing metric: %v", err)
+	}
+	if err := reg.Register(inFlight); err != nil {
+		return nil, fmt.Errorf("failed to register in-flight queries metric: %v", err)
+	}
+	if err := reg.Register(queued); err != nil {
+		return nil, fmt.Errorf("failed to register queued queries metric: %v", err)
+	}
+	if err := reg.Register(clientInFlight); err != nil {
+		return nil, fmt.Errorf("failed to register client in-flight queries metric: %v", err)
+	}
+	if err := reg.Register(clientQueued); err != nil {
+		return nil, fmt.Errorf("failed to register client queued queries metric: %v", err)
+	}
+	if err := cache.RegisterMetrics(reg); err != nil {
+		return nil, err
+	}
+	if err := rateLimiter.SetMetrics(reg); err != nil {
+		return nil, err
+	}
 
-	// Create server with chained interceptors
-	server := grpc.NewServer(
-		grpc.UnaryInterceptor(
-			chainUnaryInterceptors(
-				middleware.ContextMiddleware,
-				rateLimiter.InterceptorFunc(),
-				middleware.LoggingInterceptor,
-				middleware.NewMetricsInterceptor(requests, latency),
-				cache.InterceptorFunc(),
-			),
-		),
+	// Spans come from the global provider, which is a no-op unless tracing
+	// is configured
+	tracer := otel.Tracer("github.com/tejusbharadwaj/edgecom/internal/grpc")
+
+	// Unauthenticated calls are logged, and rejected before they count
+	// against the rate limits
+	unary := []grpc.UnaryServerInterceptor{
+		middleware.NewTracingInterceptor(tracer),
+		middleware.ContextMiddleware,
+		requestLogger.InterceptorFunc(),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		middleware.NewStreamTracingInterceptor(tracer),
+		requestLogger.StreamInterceptorFunc(),
+	}
+	if config.Authenticator != nil {
+		unary = append(unary, middleware.NewAuthInterceptor(config.Authenticator))
+		stream = append(stream, middleware.NewStreamAuthInterceptor(config.Authenticator))
+	}
+	if config.Auditor != nil {
+		unary = append(unary, middleware.NewAuditInterceptor(config.Auditor))
+		stream = append(stream, middleware.NewStreamAuditInterceptor(config.Auditor))
+	}
+	if config.Authenticator != nil && config.Authorizer != nil {
+		unary = append(unary, middleware.NewAuthzInterceptor(config.Authorizer))
+		stream = append(stream, middleware.NewStreamAuthzInterceptor(config.Authorizer))
+	}
+	unary = append(unary,
+		rateLimiter.InterceptorFunc(),
+		deadlines.InterceptorFunc(),
+		middleware.NewMetricsInterceptor(requests, latency, responsePoints),
+		middleware.NewMessageSizeInterceptor(config.MaxSendMsgSize),
+		cache.InterceptorFunc(),
+		coalescer.InterceptorFunc(),
+		concurrency.InterceptorFunc(),
 	)
+	stream = append(stream,
+		rateLimiter.StreamInterceptorFunc(),
+		deadlines.StreamInterceptorFunc(),
+		concurrency.StreamInterceptorFunc(),
+		middleware.NewStreamMessageSizeInterceptor(config.MaxSendMsgSize),
+	)
+
+	// Create server with chained interceptors. Oversized responses,
+	// including cached ones, are rejected before the transport sees them.
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(config.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(config.MaxSendMsgSize),
+		grpc.UnaryInterceptor(chainUnaryInterceptors(unary...)),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if config.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config.TLS)))
+	}
+	server := grpc.NewServer(opts...)
 
 	// Register the time series service
 	timeSeriesService := NewTimeSeriesService(repo)
+	// Responses computed from previous definitions of the virtual series
+	// are stale
+	timeSeriesService.SetSeriesChangeFunc(func() { cache.Purge() })
 	pb.RegisterTimeSeriesServiceServer(server, timeSeriesService)
 
+	var adminService *AdminService
+	if config.Admin {
+		adminService = NewAdminService(repo, cache, logger)
+		adminService.SetTimeSeriesService(timeSeriesService)
+		adminService.SetLimiters(rateLimiter, concurrency)
+		pb.RegisterAdminServiceServer(server, adminService)
+	}
+
 	// Register health service
 	healthChecker := NewHealthChecker()
 	grpc_health_v1.RegisterHealthServer(server, healthChecker)
 
 	// Set initial status
 	healthChecker.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
-	healthChecker.SetServingStatus("timeseries.TimeSeriesService", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthChecker.SetServingStatus(pb.TimeSeriesService_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
 
 	// Enable reflection for debugging
 	reflection.Register(server)
 
-	return server, nil
+	return &Server{
+		Server:      server,
+		Cache:       cache,
+		Health:      healthChecker,
+		Service:     timeSeriesService,
+		Admin:       adminService,
+		rateLimiter: rateLimiter,
+	}, nil
+}
+
+// writeMethods are the unary methods of the service that write, whose
+// calls must each be executed: they are neither cached nor coalesced
+var writeMethods = []string{
+	pb.TimeSeriesService_InsertTimeSeries_FullMethodName,
+	pb.TimeSeriesService_RecordDemandResponseEvent_FullMethodName,
+}
+
+// isCoalescable reports whether calls to a full method name may share an
+// execution: it must be a unary method of the service that only reads
+func isCoalescable(method string) bool {
+	if slices.Contains(writeMethods, method) {
+		return false
+	}
+	for _, m := range pb.TimeSeriesService_ServiceDesc.Methods {
+		if method == "/"+pb.TimeSeriesService_ServiceDesc.ServiceName+"/"+m.MethodName {
+			return true
+		}
+	}
+	return false
+}
+
+// responseScope keeps cached and coalesced responses to the caller that
+// read them: its authenticated identity, which for the gateway is its own
+// identity, as it authorizes its users itself. Unauthenticated calls share
+// responses, as every caller may read the same data.
+func responseScope(ctx context.Context) string {
+	if identity, ok := auth.FromContext(ctx); ok {
+		return identity.Provider + ":" + identity.Subject
+	}
+	return ""
+}
+
+// concurrencyClient identifies the client of a call for the per-client
+// concurrency limit: its authenticated subject, nobody for the service's
+// own clients such as the gateway, whose calls come from many users, or
+// the address of its peer when calls are not authenticated
+func concurrencyClient(ctx context.Context) string {
+	if identity, ok := auth.FromContext(ctx); ok {
+		if identity.HasRole(auth.RoleInternal) {
+			return ""
+		}
+		return identity.Provider + ":" + identity.Subject
+	}
+	return middleware.PeerHost(ctx)
 }
 
 // chainUnaryInterceptors creates a single interceptor from multiple interceptors
@@ -1,20 +1,45 @@
 package server_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/tejusbharadwaj/edgecom/internal/budget"
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
+	"github.com/tejusbharadwaj/edgecom/internal/carbon"
+	"github.com/tejusbharadwaj/edgecom/internal/codec"
+	"github.com/tejusbharadwaj/edgecom/internal/database"
 	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/export"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
 	server "github.com/tejusbharadwaj/edgecom/internal/grpc"
+	grpcmocks "github.com/tejusbharadwaj/edgecom/internal/grpc/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/labels"
 	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/stream"
 	pb "github.com/tejusbharadwaj/edgecom/proto"
 )
 
@@ -26,6 +51,12 @@ func TestQueryTimeSeries(t *testing.T) {
 
 	svc := server.NewTimeSeriesService(mockRepo)
 
+	office, err := calendar.New(calendar.Config{
+		Hours: []calendar.HoursConfig{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00"}},
+	})
+	require.NoError(t, err)
+	svc.SetCalendars(map[string]*calendar.Calendar{"office": office})
+
 	tests := []struct {
 		name          string
 		request       *pb.TimeSeriesRequest
@@ -75,6 +106,130 @@ func TestQueryTimeSeries(t *testing.T) {
 			expectedCode:  codes.InvalidArgument,
 			expectedError: "invalid aggregation: INVALID",
 		},
+		{
+			name: "Business hours",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1d",
+				Aggregation: "SUM",
+				Calendar:    "office",
+			},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					QueryBusinessHours(gomock.Any(), gomock.Any(), gomock.Any(), "1d", "SUM", office).
+					Return([]models.TimeSeriesData{{Time: time.Now(), Value: 42.0}}, nil)
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "Load factor",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1d",
+				Aggregation: "LOAD_FACTOR",
+			},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Query(gomock.Any(), gomock.Any(), gomock.Any(), "1d", "LOAD_FACTOR").
+					Return([]models.TimeSeriesData{{Time: time.Now(), Value: 0.6}}, nil)
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "Utilization without a contracted capacity",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1d",
+				Aggregation: "UTILIZATION",
+			},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Query(gomock.Any(), gomock.Any(), gomock.Any(), "1d", "UTILIZATION").
+					Return(nil, database.ErrNoContractedCapacity)
+			},
+			expectedCode:  codes.FailedPrecondition,
+			expectedError: "contracted capacity is not configured",
+		},
+		{
+			name: "Transient database error",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1h",
+				Aggregation: "AVG",
+			},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Query(gomock.Any(), gomock.Any(), gomock.Any(), "1h", "AVG").
+					Return(nil, &pq.Error{Code: "53300", Message: "too many connections"})
+			},
+			expectedCode:  codes.Unavailable,
+			expectedError: "too many connections",
+		},
+		{
+			name: "Permanent database error",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1h",
+				Aggregation: "AVG",
+			},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Query(gomock.Any(), gomock.Any(), gomock.Any(), "1h", "AVG").
+					Return(nil, fmt.Errorf("failed to query data: %w", &pq.Error{Code: "42703", Message: "column does not exist"}))
+			},
+			expectedCode:  codes.Internal,
+			expectedError: "column does not exist",
+		},
+		{
+			name: "Query cancelled by the caller",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1h",
+				Aggregation: "AVG",
+			},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Query(gomock.Any(), gomock.Any(), gomock.Any(), "1h", "AVG").
+					Return(nil, fmt.Errorf("failed to query data: %w", context.Canceled))
+			},
+			expectedCode:  codes.Canceled,
+			expectedError: "query failed",
+		},
+		{
+			name: "Query canceled by the server at the deadline",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1h",
+				Aggregation: "AVG",
+			},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Query(gomock.Any(), gomock.Any(), gomock.Any(), "1h", "AVG").
+					Return(nil, fmt.Errorf("failed to query data: %w: %w", context.DeadlineExceeded, &pq.Error{Code: "57014"}))
+			},
+			expectedCode:  codes.DeadlineExceeded,
+			expectedError: "query failed",
+		},
+		{
+			name: "Unknown calendar",
+			request: &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(time.Now()),
+				End:         timestamppb.New(time.Now().Add(24 * time.Hour)),
+				Window:      "1d",
+				Aggregation: "SUM",
+				Calendar:    "warehouse",
+			},
+			setupMock:     func() {},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "unknown calendar: warehouse",
+		},
 		{
 			name: "Invalid time range",
 			request: &pb.TimeSeriesRequest{
@@ -102,6 +257,10 @@ func TestQueryTimeSeries(t *testing.T) {
 				assert.Equal(t, tt.expectedCode, st.Code())
 				assert.Contains(t, st.Message(), tt.expectedError)
 				assert.Nil(t, resp)
+				if tt.expectedCode == codes.Unavailable {
+					require.Len(t, st.Details(), 1, "transient errors advise when to retry")
+					assert.IsType(t, &errdetails.RetryInfo{}, st.Details()[0])
+				}
 			} else {
 				require.NoError(t, err)
 				require.NotNil(t, resp)
@@ -111,118 +270,2311 @@ func TestQueryTimeSeries(t *testing.T) {
 	}
 }
 
-func TestSetupServer(t *testing.T) {
+func TestQueryTimeSeriesPages(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
 
-	config := server.ServerConfig{
-		CacheSize:      1000,
-		RateLimit:      5.0,
-		RateLimitBurst: 10,
+	start := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Minute)
+	end := start.Add(5 * time.Minute)
+
+	var buckets []models.TimeSeriesData
+	for i := 0; i < 5; i++ {
+		buckets = append(buckets, models.TimeSeriesData{Time: start.Add(time.Duration(i) * time.Minute), Value: float64(i)})
 	}
 
-	srv, err := server.SetupServer(mockRepo, config)
+	// queryPage emulates the repository's keyset read on bucket time
+	queryPage := func(_ context.Context, _, _ time.Time, _, _ string, _ *calendar.Calendar, after time.Time, limit int) ([]models.TimeSeriesData, error) {
+		var page []models.TimeSeriesData
+		for _, b := range buckets {
+			if b.Time.After(after) && len(page) < limit {
+				page = append(page, b)
+			}
+		}
+		return page, nil
+	}
+
+	t.Run("pages cover every bucket once", func(t *testing.T) {
+		mockRepo.EXPECT().
+			QueryPage(gomock.Any(), start, end, "1m", "AVG", gomock.Nil(), gomock.Any(), 3).
+			DoAndReturn(queryPage).
+			Times(3)
+
+		var values []float64
+		token := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, len(buckets), "pagination did not terminate")
+
+			resp, err := svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(start),
+				End:         timestamppb.New(end),
+				Window:      "1m",
+				Aggregation: "AVG",
+				PageSize:    2,
+				PageToken:   token,
+			})
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(resp.Data), 2)
+
+			for _, dp := range resp.Data {
+				values = append(values, dp.Value)
+			}
+			if resp.NextPageToken == "" {
+				break
+			}
+			token = resp.NextPageToken
+		}
+
+		assert.Equal(t, []float64{0, 1, 2, 3, 4}, values)
+	})
+
+	t.Run("page size is capped", func(t *testing.T) {
+		cappedRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		cappedRepo.EXPECT().
+			QueryPage(gomock.Any(), gomock.Any(), gomock.Any(), "1m", "AVG", gomock.Nil(), time.Time{}, 10001).
+			Return(nil, nil)
+
+		_, err := server.NewTimeSeriesService(cappedRepo).QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1m",
+			Aggregation: "AVG",
+			PageSize:    50000,
+		})
+		require.NoError(t, err)
+	})
+
+	invalid := []struct {
+		name          string
+		pageSize      int32
+		pageToken     string
+		expectedError string
+	}{
+		{"negative page size", -1, "", "page_size must not be negative"},
+		{"malformed token", 0, "not-a-token", "invalid page token"},
+		{"token after the range", 0, base64.RawURLEncoding.EncodeToString(
+			[]byte(`{"t":"` + end.Add(time.Hour).Format(time.RFC3339) + `","s":0}`)), "page token does not match"},
+		{"raw query token", 0, base64.RawURLEncoding.EncodeToString(
+			[]byte(`{"t":"` + start.Format(time.RFC3339) + `","s":2}`)), "page token does not match"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(start),
+				End:         timestamppb.New(end),
+				Window:      "1m",
+				Aggregation: "AVG",
+				PageSize:    tt.pageSize,
+				PageToken:   tt.pageToken,
+			})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestQueryTimeSeriesMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	start := time.Now().UTC().Add(-3 * time.Hour).Truncate(time.Hour)
+	end := start.Add(2 * time.Hour)
+	buckets := []models.TimeSeriesData{
+		{Time: start, Value: 1.5, Count: 60},
+		{Time: start.Add(time.Hour), Value: 2.5, Count: 42},
+	}
+
+	t.Run("query", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, end, "1h", "AVG").
+			Return(buckets, nil)
+
+		resp, err := svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1h",
+			Aggregation: "AVG",
+		})
+		require.NoError(t, err)
+		meta := resp.Metadata
+		require.NotNil(t, meta)
+		assert.Equal(t, "1h", meta.Window)
+		assert.Equal(t, "AVG", meta.Aggregation)
+		assert.Equal(t, []int64{60, 42}, meta.SampleCounts)
+		assert.Equal(t, int64(102), meta.TotalSamples)
+		assert.NotNil(t, meta.QueryDuration)
+		assert.False(t, meta.GapsFilled)
+		assert.False(t, meta.Downsampled)
+	})
+
+	t.Run("page", func(t *testing.T) {
+		mockRepo.EXPECT().
+			QueryPage(gomock.Any(), start, end, "1h", "SUM", gomock.Nil(), time.Time{}, 2).
+			Return(buckets[:1], nil)
+
+		resp, err := svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1h",
+			Aggregation: "SUM",
+			PageSize:    1,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{60}, resp.Metadata.SampleCounts)
+		assert.Equal(t, "SUM", resp.Metadata.Aggregation)
+	})
+}
+
+func TestQueryTimeSeriesDerivedSeries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+	derived, err := expression.NewDerived(map[string]string{
+		"net":     "default - 2",
+		"inverse": "1 / net",
+	}, database.DefaultSeries)
 	require.NoError(t, err)
-	require.NotNil(t, srv)
+	svc.SetDerivedSeries(derived)
 
-	// Test with invalid config
-	invalidConfig := server.ServerConfig{
-		CacheSize: -1,
+	start := time.Now().UTC().Add(-4 * time.Hour).Truncate(time.Hour)
+	end := start.Add(3 * time.Hour)
+	buckets := []models.TimeSeriesData{
+		{Time: start, Value: 6, Count: 60},
+		{Time: start.Add(time.Hour), Value: 2, Count: 60},
+		{Time: start.Add(2 * time.Hour), Value: 4, Count: 30},
 	}
-	srv, err = server.SetupServer(mockRepo, invalidConfig)
-	require.Error(t, err)
-	require.Nil(t, srv)
+	request := func(series string) *pb.TimeSeriesRequest {
+		return &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1h",
+			Aggregation: "AVG",
+			Series:      series,
+		}
+	}
+
+	t.Run("derived series", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "AVG").Return(buckets, nil)
+		resp, err := svc.QueryTimeSeries(context.Background(), request("net"))
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 3)
+		assert.Equal(t, 4.0, resp.Data[0].Value)
+		assert.Equal(t, 2.0, resp.Data[2].Value)
+		assert.Equal(t, []int64{60, 60, 30}, resp.Metadata.SampleCounts)
+		assert.Equal(t, "net", resp.Metadata.Series)
+	})
+
+	t.Run("sums of a derived series", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "SUM").Return(buckets, nil)
+		req := request("net")
+		req.Aggregation = "SUM"
+		resp, err := svc.QueryTimeSeries(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 3)
+		// The offset applies to every sample of the bucket
+		assert.Equal(t, 6.0-2*60, resp.Data[0].Value)
+		assert.Equal(t, 4.0-2*30, resp.Data[2].Value)
+	})
+
+	t.Run("aggregation that does not commute", func(t *testing.T) {
+		// The average of 1 / net is not 1 over the average of net
+		_, err := svc.QueryTimeSeries(context.Background(), request("inverse"))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "not linear")
+
+		req := request("inverse")
+		req.Aggregation = "MAX"
+		_, err = svc.QueryTimeSeries(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("stored series", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "AVG").Return(buckets, nil)
+		resp, err := svc.QueryTimeSeries(context.Background(), request(database.DefaultSeries))
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 3)
+		assert.Equal(t, 6.0, resp.Data[0].Value)
+		assert.Empty(t, resp.Metadata.Series)
+	})
+
+	t.Run("page", func(t *testing.T) {
+		mockRepo.EXPECT().
+			QueryPage(gomock.Any(), start, end, "1h", "AVG", gomock.Nil(), time.Time{}, 3).
+			Return(buckets, nil)
+		req := request("net")
+		req.PageSize = 2
+		resp, err := svc.QueryTimeSeries(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 2)
+		assert.Equal(t, 4.0, resp.Data[0].Value)
+		assert.Equal(t, 0.0, resp.Data[1].Value)
+		assert.NotEmpty(t, resp.NextPageToken)
+	})
+
+	t.Run("unknown series", func(t *testing.T) {
+		_, err := svc.QueryTimeSeries(context.Background(), request("solar"))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "unknown series: solar")
+	})
 }
 
-func TestValidateRequest(t *testing.T) {
+func TestQueryTimeSeriesTransform(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
 	svc := server.NewTimeSeriesService(mockRepo)
 
-	tests := []struct {
-		name        string
-		start       time.Time
-		end         time.Time
-		window      string
-		aggregation string
-		wantErr     bool
+	start := time.Now().UTC().Add(-4 * time.Hour).Truncate(time.Hour)
+	end := start.Add(3 * time.Hour)
+	// Hourly readings of a cumulative meter counter
+	buckets := []models.TimeSeriesData{
+		{Time: start, Value: 1000, Count: 60},
+		{Time: start.Add(time.Hour), Value: 1360, Count: 60},
+		{Time: start.Add(2 * time.Hour), Value: 1720, Count: 60},
+	}
+	request := func(transform string) *pb.TimeSeriesRequest {
+		return &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1h",
+			Aggregation: "MAX",
+			Transform:   transform,
+		}
+	}
+
+	t.Run("rate", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "MAX").Return(buckets, nil)
+		resp, err := svc.QueryTimeSeries(context.Background(), request(server.TransformRate))
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 2, "the first bucket has no previous one")
+		assert.Equal(t, start.Add(time.Hour), resp.Data[0].Time.AsTime())
+		assert.Equal(t, 0.1, resp.Data[0].Value)
+		assert.Equal(t, server.TransformRate, resp.Metadata.Transform)
+		assert.Equal(t, int64(120), resp.Metadata.TotalSamples)
+	})
+
+	t.Run("invalid transform", func(t *testing.T) {
+		_, err := svc.QueryTimeSeries(context.Background(), request("DERIVATIVE"))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "invalid transform: DERIVATIVE")
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		req := request(server.TransformDelta)
+		req.PageSize = 2
+		_, err := svc.QueryTimeSeries(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "cannot be combined with paging")
+	})
+}
+
+func TestQueryTimeSeriesChecksum(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	start := time.Now().UTC().Add(-3 * time.Hour).Truncate(time.Hour)
+	end := start.Add(2 * time.Hour)
+	watermark := end.Add(time.Minute)
+	buckets := []models.TimeSeriesData{
+		{Time: start, Value: 1.5, Count: 60},
+		{Time: start.Add(time.Hour), Value: 2.5, Count: 42},
+	}
+	request := func(includeChecksum bool) *pb.TimeSeriesRequest {
+		return &pb.TimeSeriesRequest{
+			Start:           timestamppb.New(start),
+			End:             timestamppb.New(end),
+			Window:          "1h",
+			Aggregation:     "AVG",
+			IncludeChecksum: includeChecksum,
+		}
+	}
+	query := func(points []models.TimeSeriesData) *pb.QueryMetadata {
+		// The watermark is read first, so that the points include all
+		// data ingested up to it
+		gomock.InOrder(
+			mockRepo.EXPECT().Watermark(gomock.Any()).Return(watermark, nil),
+			mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "AVG").Return(points, nil),
+		)
+		resp, err := svc.QueryTimeSeries(context.Background(), request(true))
+		require.NoError(t, err)
+		return resp.Metadata
+	}
+
+	t.Run("omitted by default", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "AVG").Return(buckets, nil)
+		resp, err := svc.QueryTimeSeries(context.Background(), request(false))
+		require.NoError(t, err)
+		assert.Empty(t, resp.Metadata.Checksum)
+		assert.Nil(t, resp.Metadata.Watermark)
+	})
+
+	t.Run("reproducible", func(t *testing.T) {
+		first := query(buckets)
+		assert.Len(t, first.Checksum, 64)
+		assert.Equal(t, watermark, first.Watermark.AsTime())
+
+		again := query(append([]models.TimeSeriesData(nil), buckets...))
+		assert.Equal(t, first.Checksum, again.Checksum)
+	})
+
+	t.Run("changes with the data", func(t *testing.T) {
+		first := query(buckets)
+		corrected := append([]models.TimeSeriesData(nil), buckets...)
+		corrected[1].Value = 2.25
+		assert.NotEqual(t, first.Checksum, query(corrected).Checksum)
+		backfilled := append([]models.TimeSeriesData(nil), buckets...)
+		backfilled[0].Count = 61
+		assert.NotEqual(t, first.Checksum, query(backfilled).Checksum)
+	})
+
+	t.Run("page", func(t *testing.T) {
+		gomock.InOrder(
+			mockRepo.EXPECT().Watermark(gomock.Any()).Return(watermark, nil),
+			mockRepo.EXPECT().
+				QueryPage(gomock.Any(), start, end, "1h", "AVG", gomock.Nil(), time.Time{}, 3).
+				Return(buckets, nil),
+		)
+		req := request(true)
+		req.PageSize = 2
+		resp, err := svc.QueryTimeSeries(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, query(buckets).Checksum, resp.Metadata.Checksum)
+	})
+
+	t.Run("watermark error", func(t *testing.T) {
+		mockRepo.EXPECT().Watermark(gomock.Any()).Return(time.Time{}, fmt.Errorf("connection refused"))
+		_, err := svc.QueryTimeSeries(context.Background(), request(true))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read watermark")
+	})
+}
+
+func TestQueryTimeSeriesMaxPoints(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	start := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+	end := start.Add(24 * time.Hour)
+
+	buckets := make([]models.TimeSeriesData, 288)
+	for i := range buckets {
+		buckets[i] = models.TimeSeriesData{Time: start.Add(time.Duration(i) * 5 * time.Minute), Value: float64(i % 7)}
+	}
+
+	t.Run("window is chosen from the density", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, end, "5m", "AVG").
+			Return(buckets, nil)
+
+		resp, err := svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Aggregation: "AVG",
+			MaxPoints:   1000,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "5m", resp.Window)
+		assert.Len(t, resp.Data, 288)
+		assert.Equal(t, "5m", resp.Metadata.Window)
+		assert.False(t, resp.Metadata.Downsampled)
+	})
+
+	t.Run("excess buckets are downsampled", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, end, "5m", "MAX").
+			Return(buckets, nil)
+
+		resp, err := svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "5m",
+			Aggregation: "MAX",
+			MaxPoints:   100,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, resp.Window, "the requested window is not echoed")
+		require.Len(t, resp.Data, 100)
+		assert.Equal(t, start, resp.Data[0].Time.AsTime())
+		assert.Equal(t, buckets[287].Time, resp.Data[99].Time.AsTime())
+		assert.True(t, resp.Metadata.Downsampled)
+		assert.Len(t, resp.Metadata.SampleCounts, 100)
+	})
+
+	invalid := []struct {
+		name          string
+		request       *pb.TimeSeriesRequest
+		expectedError string
 	}{
 		{
-			name:        "Valid input",
-			start:       time.Now(),
-			end:         time.Now().Add(time.Hour),
-			window:      "1h",
-			aggregation: "AVG",
-			wantErr:     false,
+			name:          "negative max points",
+			request:       &pb.TimeSeriesRequest{Start: timestamppb.New(start), End: timestamppb.New(end), Aggregation: "AVG", MaxPoints: -1},
+			expectedError: "max_points must not be negative",
 		},
 		{
-			name:        "Invalid window",
-			start:       time.Now(),
-			end:         time.Now().Add(time.Hour),
-			window:      "2h",
-			aggregation: "AVG",
-			wantErr:     true,
+			name:          "combined with paging",
+			request:       &pb.TimeSeriesRequest{Start: timestamppb.New(start), End: timestamppb.New(end), Aggregation: "AVG", MaxPoints: 100, PageSize: 10},
+			expectedError: "cannot be combined with paging",
 		},
 		{
-			name:        "Invalid aggregation",
-			start:       time.Now(),
-			end:         time.Now().Add(time.Hour),
-			window:      "1h",
-			aggregation: "MEDIAN",
-			wantErr:     true,
+			name:          "no window without max points",
+			request:       &pb.TimeSeriesRequest{Start: timestamppb.New(start), End: timestamppb.New(end), Aggregation: "AVG"},
+			expectedError: "invalid window",
 		},
 		{
-			name:        "Invalid time range",
-			start:       time.Now().Add(time.Hour),
-			end:         time.Now(),
-			window:      "1h",
-			aggregation: "AVG",
-			wantErr:     true,
+			name:          "invalid range",
+			request:       &pb.TimeSeriesRequest{Start: timestamppb.New(end), End: timestamppb.New(start), Aggregation: "AVG", MaxPoints: 100},
+			expectedError: "start time must be before end time",
 		},
 	}
-
-	for _, tt := range tests {
+	for _, tt := range invalid {
 		t.Run(tt.name, func(t *testing.T) {
-			req := &pb.TimeSeriesRequest{
-				Start:       timestamppb.New(tt.start),
-				End:         timestamppb.New(tt.end),
-				Window:      tt.window,
-				Aggregation: tt.aggregation,
-			}
-
-			// Set up mock expectations BEFORE calling the method
-			if !tt.wantErr {
-				mockRepo.EXPECT().
-					Query(
-						gomock.Any(),
-						gomock.Any(), // Use matchers for time values
-						gomock.Any(),
-						tt.window,
-						tt.aggregation,
-					).
-					Return([]models.TimeSeriesData{
-						{Time: tt.start, Value: 100.0},
-					}, nil)
-			}
+			_, err := svc.QueryTimeSeries(context.Background(), tt.request)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
 
-			// Call the method after setting up expectations
+func TestQueryEmissions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	request := &pb.EmissionsRequest{
+		Start:  timestamppb.New(start),
+		End:    timestamppb.New(start.Add(48 * time.Hour)),
+		Window: "1d",
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		_, err := svc.QueryEmissions(context.Background(), request)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	source, err := carbon.NewStaticSource([]carbon.Step{
+		{Start: start, Intensity: 400},
+		{Start: start.Add(time.Hour), Intensity: 100},
+	})
+	require.NoError(t, err)
+	svc.SetCarbon(source)
+
+	t.Run("daily buckets from hourly consumption", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, start.Add(48*time.Hour), "1h", "SUM").
+			Return([]models.TimeSeriesData{
+				{Time: start, Value: 1},
+				{Time: start.Add(time.Hour), Value: 3},
+				{Time: start.Add(25 * time.Hour), Value: 2},
+			}, nil)
+
+		resp, err := svc.QueryEmissions(context.Background(), request)
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 2)
+		assert.Equal(t, 4.0, resp.Data[0].Consumption)
+		assert.InDelta(t, 0.7, resp.Data[0].EmissionsKg, 1e-9)
+		assert.InDelta(t, 175, resp.Data[0].Intensity, 1e-9)
+		assert.InDelta(t, 0.2, resp.Data[1].EmissionsKg, 1e-9)
+		assert.InDelta(t, 0.9, resp.TotalEmissionsKg, 1e-9)
+	})
+
+	t.Run("fine windows are queried directly", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), gomock.Any(), gomock.Any(), "5m", "SUM").
+			Return(nil, nil)
+
+		_, err := svc.QueryEmissions(context.Background(), &pb.EmissionsRequest{
+			Start:  request.Start,
+			End:    timestamppb.New(start.Add(time.Hour)),
+			Window: "5m",
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid window", func(t *testing.T) {
+		_, err := svc.QueryEmissions(context.Background(), &pb.EmissionsRequest{
+			Start:  request.Start,
+			End:    request.End,
+			Window: "1w",
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("consumption before the schedule", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), gomock.Any(), gomock.Any(), "1h", "SUM").
+			Return([]models.TimeSeriesData{{Time: start.Add(-time.Hour), Value: 1}}, nil)
+
+		_, err := svc.QueryEmissions(context.Background(), &pb.EmissionsRequest{
+			Start:  timestamppb.New(start.Add(-time.Hour)),
+			End:    request.End,
+			Window: "1d",
+		})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
+
+func TestDemandResponseEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	start := time.Date(2024, 7, 15, 14, 0, 0, 0, time.UTC)
+	event := models.DemandResponseEvent{
+		Name:            "peak shave",
+		Start:           start,
+		End:             start.Add(2 * time.Hour),
+		TargetReduction: 20,
+	}
+
+	t.Run("record", func(t *testing.T) {
+		recorded := event
+		recorded.ID = 7
+		mockRepo.EXPECT().RecordDemandResponseEvent(gomock.Any(), event).Return(recorded, nil)
+
+		resp, err := svc.RecordDemandResponseEvent(context.Background(), &pb.DemandResponseEvent{
+			Name:            event.Name,
+			Start:           timestamppb.New(event.Start),
+			End:             timestamppb.New(event.End),
+			TargetReduction: event.TargetReduction,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), resp.Id)
+		assert.Equal(t, event.Name, resp.Name)
+	})
+
+	t.Run("record invalid event", func(t *testing.T) {
+		requests := []*pb.DemandResponseEvent{
+			{Name: event.Name, Start: timestamppb.New(event.Start), TargetReduction: 20},
+			{Name: event.Name, Start: timestamppb.New(event.End), End: timestamppb.New(event.Start), TargetReduction: 20},
+			{Name: event.Name, Start: timestamppb.New(event.Start), End: timestamppb.New(event.End)},
+		}
+		for _, req := range requests {
+			_, err := svc.RecordDemandResponseEvent(context.Background(), req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		}
+	})
+
+	t.Run("list with performance", func(t *testing.T) {
+		recorded := event
+		recorded.ID = 7
+		mockRepo.EXPECT().
+			DemandResponseEvents(gomock.Any(), start.Add(-time.Hour), start.Add(time.Hour)).
+			Return([]models.DemandResponseEvent{recorded}, nil)
+		mockRepo.EXPECT().
+			DailyWindowAverages(gomock.Any(), event.Start, event.End, 10).
+			Return(map[int]float64{0: 90, 1: 100}, nil)
+
+		resp, err := svc.ListDemandResponseEvents(context.Background(), &pb.ListDemandResponseEventsRequest{
+			Start: timestamppb.New(start.Add(-time.Hour)),
+			End:   timestamppb.New(start.Add(time.Hour)),
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Events, 1)
+
+		performance := resp.Events[0]
+		assert.Equal(t, int64(7), performance.Event.Id)
+		assert.True(t, performance.Evaluated)
+		assert.True(t, performance.Complete)
+		assert.Equal(t, int32(1), performance.BaselineDays)
+		assert.Equal(t, 10.0, performance.AchievedReduction)
+		assert.Equal(t, 0.5, performance.TargetAchieved)
+	})
+
+	t.Run("list invalid range", func(t *testing.T) {
+		_, err := svc.ListDemandResponseEvents(context.Background(), &pb.ListDemandResponseEventsRequest{
+			Start: timestamppb.New(start),
+			End:   timestamppb.New(start.Add(-time.Hour)),
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestQueryTimeSeriesWeatherNormalized(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	start := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	request := func(aggregation string) *pb.TimeSeriesRequest {
+		return &pb.TimeSeriesRequest{
+			Start:             timestamppb.New(start),
+			End:               timestamppb.New(start.Add(3 * day)),
+			Window:            "1d",
+			Aggregation:       aggregation,
+			WeatherNormalized: true,
+		}
+	}
+
+	_, err := svc.QueryTimeSeries(context.Background(), request("SUM"))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "without a temperature source")
+
+	// 10, 5 and 15 heating degrees this year, 10 each day a year ago, none
+	// two years ago and no readings three years ago
+	svc.SetWeather(temperatures{
+		2024: {8, 13, 3},
+		2023: {8, 8, 8},
+		2022: {18, 18, 18},
+	}, 18, 3)
+
+	t.Run("restated at the normal weather", func(t *testing.T) {
+		// Consumption is 100 plus 2 per heating degree
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, start.Add(3*day), "1d", "SUM").
+			Return([]models.TimeSeriesData{
+				{Time: start, Value: 120},
+				{Time: start.Add(day), Value: 110},
+				{Time: start.Add(2 * day), Value: 130},
+			}, nil)
+
+		resp, err := svc.QueryTimeSeries(context.Background(), request("SUM"))
+		require.NoError(t, err)
+		assert.InDelta(t, 100, resp.WeatherModel.BaseLoad, 1e-6)
+		assert.InDelta(t, 2, resp.WeatherModel.HeatingSlope, 1e-6)
+		assert.Equal(t, int32(3), resp.WeatherModel.Observations)
+		assert.Equal(t, int32(3), resp.WeatherModel.NormalYears)
+		// Every day at the normal 5 heating degrees
+		require.Len(t, resp.Data, 3)
+		for _, point := range resp.Data {
+			assert.InDelta(t, 110, point.Value, 1e-6)
+		}
+	})
+
+	t.Run("too few buckets", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, start.Add(3*day), "1d", "AVG").
+			Return([]models.TimeSeriesData{{Time: start, Value: 5}}, nil)
+
+		_, err := svc.QueryTimeSeries(context.Background(), request("AVG"))
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("MAX buckets", func(t *testing.T) {
+		_, err := svc.QueryTimeSeries(context.Background(), request("MAX"))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		req := request("SUM")
+		req.PageSize = 2
+		_, err := svc.QueryTimeSeries(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("transform", func(t *testing.T) {
+		req := request("SUM")
+		req.Transform = server.TransformCumsum
+		_, err := svc.QueryTimeSeries(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "transform")
+	})
+}
+
+// temperatures is a weather.Source with a reading per day of the range,
+// from the start of the range, by the year of its start
+type temperatures map[int][]float64
+
+func (t temperatures) Temperatures(_ context.Context, start, _ time.Time) ([]models.TimeSeriesData, error) {
+	var readings []models.TimeSeriesData
+	for i, value := range t[start.Year()] {
+		readings = append(readings, models.TimeSeriesData{Time: start.AddDate(0, 0, i), Value: value})
+	}
+	return readings, nil
+}
+
+func TestGetBudgetStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	t.Run("not configured", func(t *testing.T) {
+		_, err := svc.GetBudgetStatus(context.Background(), &pb.BudgetStatusRequest{})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	tracker, err := budget.NewTracker(mockRepo, []budget.Budget{
+		{Name: "energy", Kind: budget.KindConsumption, Limit: 100},
+		{Name: "bill", Kind: budget.KindCost, Limit: 1000},
+	}, 0.5, time.UTC, logrus.New(), prometheus.NewRegistry())
+	require.NoError(t, err)
+	svc.SetBudgets(tracker)
+
+	t.Run("month to date", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), gomock.Any(), gomock.Any(), "1d", "SUM").
+			Return([]models.TimeSeriesData{{Value: 50}, {Value: 40}}, nil)
+
+		resp, err := svc.GetBudgetStatus(context.Background(), &pb.BudgetStatusRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.Budgets, 2)
+
+		energy := resp.Budgets[0]
+		assert.Equal(t, "energy", energy.Name)
+		assert.Equal(t, 90.0, energy.Actual)
+		assert.GreaterOrEqual(t, energy.Projected, energy.Actual)
+		assert.Equal(t, []float64{0.8}, energy.CrossedThresholds)
+		assert.Equal(t, 1, energy.MonthStart.AsTime().Day())
+
+		assert.Equal(t, 45.0, resp.Budgets[1].Actual)
+		assert.Empty(t, resp.Budgets[1].CrossedThresholds)
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(nil, assert.AnError)
+
+		_, err := svc.GetBudgetStatus(context.Background(), &pb.BudgetStatusRequest{})
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+}
+
+func TestGetSummaries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+	svc.SetKWhPerUnit(0.001)
+
+	t.Run("monthly summaries", func(t *testing.T) {
+		november := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+		december := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+		mockRepo.EXPECT().
+			Summaries(gomock.Any(), models.PeriodMonth, november, december).
+			Return([]models.ConsumptionSummary{{
+				Period:      models.PeriodMonth,
+				Start:       november,
+				Total:       360000,
+				Peak:        1000,
+				PeakTime:    november.Add(36 * time.Hour),
+				Hours:       720,
+				SampleCount: 2880,
+			}}, nil)
+
+		// Start is aligned down to the start of its month
+		resp, err := svc.GetSummaries(context.Background(), &pb.SummariesRequest{
+			Start:  timestamppb.New(november.Add(15 * 24 * time.Hour)),
+			End:    timestamppb.New(december),
+			Period: models.PeriodMonth,
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Summaries, 1)
+
+		summary := resp.Summaries[0]
+		assert.Equal(t, november, summary.Start.AsTime())
+		assert.Equal(t, december, summary.End.AsTime())
+		assert.InDelta(t, 360.0, summary.TotalKwh, 1e-9)
+		assert.InDelta(t, 1.0, summary.PeakKw, 1e-9)
+		assert.InDelta(t, 0.5, summary.LoadFactor, 1e-9)
+		assert.Equal(t, november.Add(36*time.Hour), summary.PeakTime.AsTime())
+		assert.Equal(t, int64(2880), summary.SampleCount)
+	})
+
+	t.Run("invalid period", func(t *testing.T) {
+		_, err := svc.GetSummaries(context.Background(), &pb.SummariesRequest{
+			Start:  timestamppb.New(time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)),
+			End:    timestamppb.New(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)),
+			Period: "week",
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("missing range", func(t *testing.T) {
+		_, err := svc.GetSummaries(context.Background(), &pb.SummariesRequest{Period: models.PeriodDay})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Summaries(gomock.Any(), models.PeriodDay, gomock.Any(), gomock.Any()).
+			Return(nil, assert.AnError)
+
+		_, err := svc.GetSummaries(context.Background(), &pb.SummariesRequest{
+			Start:  timestamppb.New(time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)),
+			End:    timestamppb.New(time.Date(2024, 11, 8, 0, 0, 0, 0, time.UTC)),
+			Period: models.PeriodDay,
+		})
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+}
+
+func TestCompareTimeSeries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	start := time.Date(2024, 11, 18, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+	request := func(offsets ...string) *pb.CompareRequest {
+		return &pb.CompareRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1d",
+			Aggregation: "SUM",
+			Offsets:     offsets,
+		}
+	}
+
+	t.Run("week over week", func(t *testing.T) {
+		lastWeek := start.Add(-7 * 24 * time.Hour)
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, end, "1d", "SUM").
+			Return([]models.TimeSeriesData{{Time: start, Value: 120, Count: 288}}, nil)
+		mockRepo.EXPECT().
+			Query(gomock.Any(), lastWeek, start, "1d", "SUM").
+			Return([]models.TimeSeriesData{{Time: lastWeek, Value: 100, Count: 288}}, nil)
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start.Add(-364*24*time.Hour), end.Add(-364*24*time.Hour), "1d", "SUM").
+			Return(nil, nil)
+
+		resp, err := svc.CompareTimeSeries(context.Background(), request("-7d", "-52w"))
+		require.NoError(t, err)
+		require.Len(t, resp.Series, 3)
+
+		current, previous, lastYear := resp.Series[0], resp.Series[1], resp.Series[2]
+		assert.Equal(t, "current", current.Label)
+		assert.Equal(t, 120.0, current.Data[0].Value)
+		assert.Equal(t, "-7d", previous.Label)
+		assert.Equal(t, lastWeek, previous.Start.AsTime())
+		assert.Equal(t, start, previous.End.AsTime())
+		require.Len(t, previous.Data, 1)
+		assert.Equal(t, start, previous.Data[0].Time.AsTime(), "buckets are shifted onto the requested range")
+		assert.Equal(t, 100.0, previous.Data[0].Value)
+		assert.Equal(t, int64(288), previous.Metadata.TotalSamples)
+		assert.Equal(t, "-52w", lastYear.Label)
+		assert.Empty(t, lastYear.Data)
+	})
+
+	t.Run("invalid offsets", func(t *testing.T) {
+		for name, offsets := range map[string][]string{
+			"none":                  nil,
+			"positive":              {"7d"},
+			"unknown unit":          {"-1mo"},
+			"not a window multiple": {"-36h"},
+			"duplicate":             {"-7d", "-1w"},
+			"too many":              {"-1d", "-2d", "-3d", "-4d", "-5d", "-6d", "-7d", "-8d", "-9d", "-10d", "-11d", "-12d", "-13d"},
+		} {
+			_, err := svc.CompareTimeSeries(context.Background(), request(offsets...))
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+		}
+	})
+
+	t.Run("weather normalized", func(t *testing.T) {
+		// Both weeks see 10, 5 and 15 heating degrees against a normal 10
+		// and are fitted on their own: the current one uses 100 plus 2
+		// per degree, the previous one 110 plus 2
+		svc.SetWeather(temperatures{2024: {8, 13, 3}, 2023: {8, 8, 8}}, 18, 1)
+		day := 24 * time.Hour
+		lastWeek := start.Add(-7 * day)
+		mockRepo.EXPECT().
+			Query(gomock.Any(), start, end, "1d", "SUM").
+			Return([]models.TimeSeriesData{
+				{Time: start, Value: 120}, {Time: start.Add(day), Value: 110}, {Time: start.Add(2 * day), Value: 130},
+			}, nil)
+		mockRepo.EXPECT().
+			Query(gomock.Any(), lastWeek, start, "1d", "SUM").
+			Return([]models.TimeSeriesData{
+				{Time: lastWeek, Value: 130}, {Time: lastWeek.Add(day), Value: 120}, {Time: lastWeek.Add(2 * day), Value: 140},
+			}, nil)
+
+		req := request("-7d")
+		req.WeatherNormalized = true
+		resp, err := svc.CompareTimeSeries(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, resp.Series, 2)
+		assert.InDelta(t, 100, resp.Series[0].WeatherModel.BaseLoad, 1e-6)
+		assert.InDelta(t, 110, resp.Series[1].WeatherModel.BaseLoad, 1e-6)
+		assert.InDelta(t, 120, resp.Series[0].Data[0].Value, 1e-6)
+		assert.InDelta(t, 130, resp.Series[1].Data[0].Value, 1e-6)
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		mockRepo.EXPECT().
+			Query(gomock.Any(), gomock.Any(), gomock.Any(), "1d", "SUM").
+			Return(nil, assert.AnError).
+			MinTimes(1)
+
+		_, err := svc.CompareTimeSeries(context.Background(), request("-7d"))
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+}
+
+func TestSeriesCatalog(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+	derived, err := expression.NewDerived(map[string]string{"net": "default - 2"}, database.DefaultSeries)
+	require.NoError(t, err)
+	svc.SetDerivedSeries(derived)
+	svc.SetSeriesDescriptions(map[string]models.SeriesDescription{
+		"net": {Unit: "kWh", Description: "Consumption net of the base load", Tags: map[string]string{"site": "north"}},
+	})
+
+	first := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	stored := models.SeriesMetadata{
+		Series:            database.DefaultSeries,
+		SeriesDescription: models.SeriesDescription{Unit: "kWh", Tags: map[string]string{"site": "north", "meter": "main"}},
+		FirstTime:         first,
+		LastTime:          last,
+		PointCount:        31680,
+	}
+	// The repository selects the stored series by their labels
+	mockRepo.EXPECT().ListSeriesMetadata(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, matchers []*labels.Matcher) ([]models.SeriesMetadata, error) {
+			if labels.MatchSeries(matchers, stored.Series, stored.Tags) {
+				return []models.SeriesMetadata{stored}, nil
+			}
+			return nil, nil
+		}).AnyTimes()
+	mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return([]models.VirtualSeries{
+		{Name: "billed", Expression: "net * 2", Unit: "EUR", Tags: map[string]string{"site": "south"}},
+	}, nil).AnyTimes()
+
+	t.Run("lists every kind by name", func(t *testing.T) {
+		resp, err := svc.ListSeries(context.Background(), &pb.ListSeriesRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.Series, 3)
+
+		billed, stored, net := resp.Series[0], resp.Series[1], resp.Series[2]
+		assert.Equal(t, "billed", billed.Name)
+		assert.Equal(t, server.SeriesKindVirtual, billed.Kind)
+		assert.Equal(t, "net * 2", billed.Expression)
+		assert.Equal(t, database.DefaultSeries, stored.Name)
+		assert.Equal(t, server.SeriesKindStored, stored.Kind)
+		assert.Equal(t, first, stored.FirstTime.AsTime())
+		assert.Equal(t, last, stored.LastTime.AsTime())
+		assert.Equal(t, int64(31680), stored.PointCount)
+		assert.Equal(t, "net", net.Name)
+		assert.Equal(t, server.SeriesKindDerived, net.Kind)
+		assert.Equal(t, "default - 2", net.Expression)
+		assert.Equal(t, "Consumption net of the base load", net.Description)
+		assert.Nil(t, net.FirstTime)
+	})
+
+	t.Run("filters by kind and tags", func(t *testing.T) {
+		resp, err := svc.ListSeries(context.Background(), &pb.ListSeriesRequest{Kind: server.SeriesKindDerived})
+		require.NoError(t, err)
+		require.Len(t, resp.Series, 1)
+		assert.Equal(t, "net", resp.Series[0].Name)
+
+		resp, err = svc.ListSeries(context.Background(), &pb.ListSeriesRequest{Tags: map[string]string{"site": "north", "meter": "main"}})
+		require.NoError(t, err)
+		require.Len(t, resp.Series, 1)
+		assert.Equal(t, database.DefaultSeries, resp.Series[0].Name)
+
+		_, err = svc.ListSeries(context.Background(), &pb.ListSeriesRequest{Kind: "external"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("filters by selector", func(t *testing.T) {
+		names := func(selector string) []string {
+			resp, err := svc.ListSeries(context.Background(), &pb.ListSeriesRequest{Selector: selector})
+			require.NoError(t, err, selector)
+			var names []string
+			for _, series := range resp.Series {
+				names = append(names, series.Name)
+			}
+			return names
+		}
+		assert.Equal(t, []string{database.DefaultSeries, "net"}, names(`{site="north"}`))
+		assert.Equal(t, []string{"billed", "net"}, names(`{site=~"south|north",meter=""}`))
+		assert.Equal(t, []string{"billed"}, names(`{site!="north",site!=""}`))
+		assert.Equal(t, []string{"net"}, names(`net{site="north"}`))
+		assert.Empty(t, names(`{site="west"}`))
+
+		_, err := svc.ListSeries(context.Background(), &pb.ListSeriesRequest{Selector: `{site=""}`})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("queries the series a selector selects", func(t *testing.T) {
+		request := func(series, selector string) *pb.TimeSeriesRequest {
+			return &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(first),
+				End:         timestamppb.New(last),
+				Window:      "1h",
+				Aggregation: "AVG",
+				Series:      series,
+				Selector:    selector,
+			}
+		}
+		mockRepo.EXPECT().Query(gomock.Any(), first, last, "1h", "AVG").
+			Return([]models.TimeSeriesData{{Time: first, Value: 10}}, nil)
+		resp, err := svc.QueryTimeSeries(context.Background(), request("", `{site="north",meter!="main"}`))
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 1)
+		assert.Equal(t, 8.0, resp.Data[0].Value)
+		assert.Equal(t, "net", resp.Metadata.Series)
+
+		_, err = svc.QueryTimeSeries(context.Background(), request("", `{site="north"}`))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.ErrorContains(t, err, "matches 2 series, default, net")
+		_, err = svc.QueryTimeSeries(context.Background(), request("", `{site="west"}`))
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, err = svc.QueryTimeSeries(context.Background(), request("net", `{site="north"}`))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = svc.QueryTimeSeries(context.Background(), request("", `{site=north}`))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		// Requests are validated before the selector is resolved
+		_, err = svc.CompareTimeSeries(context.Background(), &pb.CompareRequest{
+			Start:       timestamppb.New(first),
+			End:         timestamppb.New(last),
+			Window:      "1h",
+			Aggregation: "AVG",
+			Selector:    `{site="west"}`,
+			Offsets:     make([]string, 100),
+		})
+		assert.ErrorContains(t, err, "exceed the limit")
+	})
+
+	t.Run("groups the series a selector selects", func(t *testing.T) {
+		require.NoError(t, svc.ReloadVirtualSeries(context.Background()))
+		request := func(aggregation string, groupBy ...string) *pb.TimeSeriesRequest {
+			return &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(first),
+				End:         timestamppb.New(last),
+				Window:      "1h",
+				Aggregation: aggregation,
+				Selector:    `{site=~"north|south"}`,
+				GroupBy:     groupBy,
+			}
+		}
+		// One query serves every group
+		mockRepo.EXPECT().Query(gomock.Any(), first, last, "1h", "SUM").Return([]models.TimeSeriesData{
+			{Time: first, Value: 10, Count: 60},
+			{Time: first.Add(time.Hour), Value: 1, Count: 30},
+		}, nil)
+		resp, err := svc.QueryTimeSeries(context.Background(), request("SUM", "site"))
+		require.NoError(t, err)
+		assert.Empty(t, resp.Data)
+		assert.Equal(t, int64(90), resp.Metadata.TotalSamples)
+		require.Len(t, resp.Groups, 2)
+
+		north, south := resp.Groups[0], resp.Groups[1]
+		assert.Equal(t, map[string]string{"site": "north"}, north.Labels)
+		assert.Equal(t, []string{database.DefaultSeries, "net"}, north.Series)
+		require.Len(t, north.Data, 2)
+		// The sums of net subtract 2 per sample
+		assert.Equal(t, 10.0+(10-2*60), north.Data[0].Value)
+		assert.Equal(t, 1.0+(1-2*30), north.Data[1].Value)
+		assert.Equal(t, map[string]string{"site": "south"}, south.Labels)
+		assert.Equal(t, []string{"billed"}, south.Series)
+		require.Len(t, south.Data, 2)
+		assert.Equal(t, 2*(10.0-2*60), south.Data[0].Value)
+		assert.Equal(t, 2*(1.0-2*30), south.Data[1].Value)
+
+		// Series lacking a label share the group without it
+		mockRepo.EXPECT().Query(gomock.Any(), first, last, "1h", "MAX").
+			Return([]models.TimeSeriesData{{Time: first, Value: 10, Count: 60}}, nil)
+		resp, err = svc.QueryTimeSeries(context.Background(), request("MAX", "meter"))
+		require.NoError(t, err)
+		require.Len(t, resp.Groups, 2)
+		assert.Empty(t, resp.Groups[0].Labels)
+		assert.Equal(t, []string{"billed", "net"}, resp.Groups[0].Series)
+		assert.Equal(t, 16.0, resp.Groups[0].Data[0].Value)
+		assert.Equal(t, map[string]string{"meter": "main"}, resp.Groups[1].Labels)
+		assert.Equal(t, 10.0, resp.Groups[1].Data[0].Value)
+
+		for _, req := range []*pb.TimeSeriesRequest{
+			{Start: timestamppb.New(first), End: timestamppb.New(last), Window: "1h", Aggregation: "SUM", GroupBy: []string{"site"}},
+			request("LOAD_FACTOR", "site"),
+			request("SUM", "site", "site"),
+			request("SUM", "site-id"),
+			func() *pb.TimeSeriesRequest { req := request("SUM", "site"); req.PageSize = 10; return req }(),
+			func() *pb.TimeSeriesRequest { req := request("SUM", "site"); req.Series = "net"; return req }(),
+			func() *pb.TimeSeriesRequest { req := request("SUM", "site"); req.WeatherNormalized = true; return req }(),
+		} {
+			_, err := svc.QueryTimeSeries(context.Background(), req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), req.String())
+		}
+		_, err = svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+			Start: timestamppb.New(first), End: timestamppb.New(last), Window: "1h", Aggregation: "SUM",
+			Selector: `{site="west"}`, GroupBy: []string{"site"},
+		})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("gets a series by name", func(t *testing.T) {
+		series, err := svc.GetSeries(context.Background(), &pb.GetSeriesRequest{Name: "billed"})
+		require.NoError(t, err)
+		assert.Equal(t, "EUR", series.Unit)
+
+		_, err = svc.GetSeries(context.Background(), &pb.GetSeriesRequest{Name: "solar"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, err = svc.GetSeries(context.Background(), &pb.GetSeriesRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestQueryGroupsOfDerivedSeries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+	derived, err := expression.NewDerived(map[string]string{
+		"scaled":   "default * 2",
+		"inverted": "1 / default",
+	}, database.DefaultSeries)
+	require.NoError(t, err)
+	svc.SetDerivedSeries(derived)
+	svc.SetSeriesDescriptions(map[string]models.SeriesDescription{
+		"scaled":   {Tags: map[string]string{"kind": "feeder"}},
+		"inverted": {Tags: map[string]string{"kind": "feeder"}},
+	})
+	mockRepo.EXPECT().ListSeriesMetadata(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return(nil, nil).AnyTimes()
+
+	// The averages of the buckets of inverted are not the inverses of
+	// the averages of the stored series, so no group is computed
+	start := time.Date(2024, 11, 18, 0, 0, 0, 0, time.UTC)
+	_, err = svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+		Start:       timestamppb.New(start),
+		End:         timestamppb.New(start.Add(24 * time.Hour)),
+		Window:      "1h",
+		Aggregation: "AVG",
+		Selector:    `{kind="feeder"}`,
+		GroupBy:     []string{"kind"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.ErrorContains(t, err, "inverted is not linear")
+}
+
+func TestQueryGroupsLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+	expressions := make(map[string]string)
+	descriptions := make(map[string]models.SeriesDescription)
+	for i := 0; i <= 100; i++ {
+		name := fmt.Sprintf("m%d", i)
+		expressions[name] = "default"
+		descriptions[name] = models.SeriesDescription{Tags: map[string]string{"kind": "meter"}}
+	}
+	derived, err := expression.NewDerived(expressions, database.DefaultSeries)
+	require.NoError(t, err)
+	svc.SetDerivedSeries(derived)
+	svc.SetSeriesDescriptions(descriptions)
+	mockRepo.EXPECT().ListSeriesMetadata(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return(nil, nil).AnyTimes()
+
+	// One group per series is one too many, and the database is not
+	// queried
+	start := time.Date(2024, 11, 18, 0, 0, 0, 0, time.UTC)
+	_, err = svc.QueryTimeSeries(context.Background(), &pb.TimeSeriesRequest{
+		Start:       timestamppb.New(start),
+		End:         timestamppb.New(start.Add(24 * time.Hour)),
+		Window:      "1h",
+		Aggregation: "SUM",
+		Selector:    `{kind="meter"}`,
+		GroupBy:     []string{"__name__"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.ErrorContains(t, err, "forms 101 groups")
+}
+
+func TestTopK(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+	derived, err := expression.NewDerived(map[string]string{
+		"f1": "default * 0.5",
+		"f2": "default - 5",
+		"f3": "min(default, 12)",
+	}, database.DefaultSeries)
+	require.NoError(t, err)
+	svc.SetDerivedSeries(derived)
+	feeder := models.SeriesDescription{Tags: map[string]string{"kind": "feeder"}}
+	svc.SetSeriesDescriptions(map[string]models.SeriesDescription{"f1": feeder, "f2": feeder, "f3": feeder})
+	mockRepo.EXPECT().ListSeriesMetadata(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return(nil, nil).AnyTimes()
+
+	start := time.Date(2024, 11, 18, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+	request := func(aggregation string, k int32, bottom bool) *pb.TopKRequest {
+		return &pb.TopKRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1h",
+			Aggregation: aggregation,
+			Selector:    `{kind="feeder"}`,
+			K:           k,
+			Bottom:      bottom,
+		}
+	}
+	ranking := func(resp *pb.TopKResponse) map[string]float64 {
+		values := make(map[string]float64)
+		for _, r := range resp.Series {
+			values[r.Series] = r.Value
+		}
+		return values
+	}
+	names := func(resp *pb.TopKResponse) []string {
+		var names []string
+		for _, r := range resp.Series {
+			names = append(names, r.Series)
+		}
+		return names
+	}
+	buckets := func(aggregation string) {
+		// One query serves the whole ranking
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", aggregation).Return([]models.TimeSeriesData{
+			{Time: start, Value: 10, Count: 1},
+			{Time: start.Add(time.Hour), Value: 20, Count: 3},
+		}, nil)
+	}
+
+	t.Run("highest peaks", func(t *testing.T) {
+		buckets("MAX")
+		resp, err := svc.TopK(context.Background(), request("MAX", 2, false))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"f2", "f3"}, names(resp))
+		assert.Equal(t, map[string]float64{"f2": 15, "f3": 12}, ranking(resp))
+		assert.Equal(t, start.Add(time.Hour), resp.Series[0].Time.AsTime())
+		assert.Equal(t, map[string]string{"kind": "feeder"}, resp.Series[0].Tags)
+		assert.Equal(t, int32(3), resp.TotalSeries)
+		assert.Equal(t, int64(4), resp.Metadata.TotalSamples)
+
+		buckets("MAX")
+		resp, err = svc.TopK(context.Background(), request("MAX", 10, true))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"f1", "f3", "f2"}, names(resp))
+	})
+
+	t.Run("averages weighted by samples", func(t *testing.T) {
+		buckets("AVG")
+		req := request("AVG", 2, false)
+		req.Selector = `{__name__=~"f1|f2"}`
+		resp, err := svc.TopK(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]float64{"f2": 12.5, "f1": 8.75}, ranking(resp))
+		assert.Nil(t, resp.Series[0].Time)
+	})
+
+	t.Run("values that are not finite are skipped", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "SUM").Return([]models.TimeSeriesData{
+			{Time: start, Value: 10, Count: 1},
+			{Time: start.Add(time.Hour), Value: math.NaN(), Count: 1},
+			{Time: start.Add(2 * time.Hour), Value: math.Inf(1), Count: 1},
+		}, nil)
+		req := request("SUM", 2, false)
+		req.Selector = `{__name__=~"f1|f2"}`
+		resp, err := svc.TopK(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]float64{"f2": 5, "f1": 5}, ranking(resp))
+		assert.Equal(t, []string{"f1", "f2"}, names(resp))
+	})
+
+	t.Run("ties are ranked by name", func(t *testing.T) {
+		buckets("MIN")
+		req := request("MIN", 2, true)
+		req.Selector = `{__name__=~"f1|f2"}`
+		resp, err := svc.TopK(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"f1", "f2"}, names(resp))
+		assert.Equal(t, start, resp.Series[0].Time.AsTime())
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, req := range []*pb.TopKRequest{
+			request("MAX", 0, false),
+			request("MAX", 1001, false),
+			request("LOAD_FACTOR", 1, false),
+			request("MEDIAN", 1, false),
+			// The averages of f3 cannot be computed from those of default
+			request("AVG", 1, false),
+			func() *pb.TopKRequest { req := request("MAX", 1, false); req.Selector = ""; return req }(),
+			func() *pb.TopKRequest { req := request("MAX", 1, false); req.Calendar = "office"; return req }(),
+		} {
+			_, err := svc.TopK(context.Background(), req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), req.String())
+		}
+		req := request("MAX", 1, false)
+		req.Selector = `{kind="inverter"}`
+		_, err := svc.TopK(context.Background(), req)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("too many series", func(t *testing.T) {
+		expressions := make(map[string]string)
+		descriptions := make(map[string]models.SeriesDescription)
+		for i := 0; i <= 1000; i++ {
+			name := fmt.Sprintf("m%d", i)
+			expressions[name] = "default"
+			descriptions[name] = models.SeriesDescription{Tags: map[string]string{"kind": "meter"}}
+		}
+		derived, err := expression.NewDerived(expressions, database.DefaultSeries)
+		require.NoError(t, err)
+		svc := server.NewTimeSeriesService(mockRepo)
+		svc.SetDerivedSeries(derived)
+		svc.SetSeriesDescriptions(descriptions)
+
+		req := request("MAX", 10, false)
+		req.Selector = `{kind="meter"}`
+		_, err = svc.TopK(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "1001 series")
+	})
+}
+
+func TestSeriesCatalogBeforeFirstSample(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+	mockRepo.EXPECT().ListSeriesMetadata(gomock.Any(), gomock.Nil()).Return(nil, nil)
+	mockRepo.EXPECT().VirtualSeries(gomock.Any()).Return(nil, nil)
+
+	series, err := svc.GetSeries(context.Background(), &pb.GetSeriesRequest{Name: database.DefaultSeries})
+	require.NoError(t, err)
+	assert.Equal(t, server.SeriesKindStored, series.Kind)
+	assert.Zero(t, series.PointCount)
+	assert.Nil(t, series.LastTime)
+}
+
+func TestSetupServer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+	config := server.ServerConfig{
+		CacheSize:      1000,
+		RateLimit:      5.0,
+		RateLimitBurst: 10,
+	}
+
+	srv, err := server.SetupServer(mockRepo, config)
+	require.NoError(t, err)
+	require.NotNil(t, srv)
+
+	// Test with invalid config
+	invalidConfig := server.ServerConfig{
+		CacheSize: -1,
+	}
+	srv, err = server.SetupServer(mockRepo, invalidConfig)
+	require.Error(t, err)
+	require.Nil(t, srv)
+
+	invalidConfig = server.DefaultServerConfig()
+	invalidConfig.MaxSendMsgSize = -1
+	_, err = server.SetupServer(mockRepo, invalidConfig)
+	assert.ErrorContains(t, err, "message size limits must not be negative")
+
+	invalidConfig = server.DefaultServerConfig()
+	invalidConfig.CoalesceWindows = map[string]time.Duration{
+		pb.TimeSeriesService_InsertTimeSeries_FullMethodName: 10 * time.Millisecond,
+	}
+	_, err = server.SetupServer(mockRepo, invalidConfig)
+	assert.ErrorContains(t, err, "cannot be coalesced")
+
+	invalidConfig = server.DefaultServerConfig()
+	invalidConfig.MaxRequestTimeout = -time.Second
+	_, err = server.SetupServer(mockRepo, invalidConfig)
+	assert.ErrorContains(t, err, "request timeouts must not be negative")
+
+	invalidConfig = server.DefaultServerConfig()
+	invalidConfig.Concurrency.MaxInFlightPerClient = -1
+	_, err = server.SetupServer(mockRepo, invalidConfig)
+	assert.ErrorContains(t, err, "concurrency limits must not be negative")
+}
+
+func TestCompressionAndMessageSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	srv, err := server.SetupServerWithRegistry(mockRepo, logger, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(64*1024*1024)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewTimeSeriesServiceClient(conn)
+
+	start := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+	request := func(aggregation string) *pb.TimeSeriesRequest {
+		return &pb.TimeSeriesRequest{Start: timestamppb.New(start), End: timestamppb.New(end), Window: "1h", Aggregation: aggregation}
+	}
+
+	t.Run("gzip is negotiated", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "AVG").
+			Return([]models.TimeSeriesData{{Time: start, Value: 1.5}}, nil)
+
+		resp, err := client.QueryTimeSeries(context.Background(), request("AVG"), grpc.UseCompressor(gzip.Name))
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 1)
+		assert.Equal(t, 1.5, resp.Data[0].Value)
+	})
+
+	t.Run("oversized responses are explained", func(t *testing.T) {
+		points := make([]models.TimeSeriesData, 300000)
+		for i := range points {
+			points[i] = models.TimeSeriesData{Time: start.Add(time.Duration(i) * time.Second), Value: float64(i)}
+		}
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "MAX").Return(points, nil)
+
+		_, err := client.QueryTimeSeries(context.Background(), request("MAX"))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "exceeds the maximum message size of 4194304 bytes")
+	})
+
+	t.Run("queries without a deadline are bounded", func(t *testing.T) {
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1h", "MIN").
+			DoAndReturn(func(ctx context.Context, _, _ time.Time, _, _ string) ([]models.TimeSeriesData, error) {
+				deadline, ok := ctx.Deadline()
+				require.True(t, ok)
+				assert.WithinDuration(t, time.Now().Add(server.DefaultRequestTimeout), deadline, time.Second)
+				return nil, nil
+			})
+
+		_, err := client.QueryTimeSeries(context.Background(), request("MIN"))
+		require.NoError(t, err)
+	})
+}
+
+func TestValidateRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	tests := []struct {
+		name        string
+		start       time.Time
+		end         time.Time
+		window      string
+		aggregation string
+		wantErr     bool
+	}{
+		{
+			name:        "Valid input",
+			start:       time.Now(),
+			end:         time.Now().Add(time.Hour),
+			window:      "1h",
+			aggregation: "AVG",
+			wantErr:     false,
+		},
+		{
+			name:        "Invalid window",
+			start:       time.Now(),
+			end:         time.Now().Add(time.Hour),
+			window:      "2h",
+			aggregation: "AVG",
+			wantErr:     true,
+		},
+		{
+			name:        "Invalid aggregation",
+			start:       time.Now(),
+			end:         time.Now().Add(time.Hour),
+			window:      "1h",
+			aggregation: "MEDIAN",
+			wantErr:     true,
+		},
+		{
+			name:        "Invalid time range",
+			start:       time.Now().Add(time.Hour),
+			end:         time.Now(),
+			window:      "1h",
+			aggregation: "AVG",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &pb.TimeSeriesRequest{
+				Start:       timestamppb.New(tt.start),
+				End:         timestamppb.New(tt.end),
+				Window:      tt.window,
+				Aggregation: tt.aggregation,
+			}
+
+			// Set up mock expectations BEFORE calling the method
+			if !tt.wantErr {
+				mockRepo.EXPECT().
+					Query(
+						gomock.Any(),
+						gomock.Any(), // Use matchers for time values
+						gomock.Any(),
+						tt.window,
+						tt.aggregation,
+					).
+					Return([]models.TimeSeriesData{
+						{Time: tt.start, Value: 100.0},
+					}, nil)
+			}
+
+			// Call the method after setting up expectations
 			resp, err := svc.QueryTimeSeries(context.Background(), req)
 
-			if tt.wantErr {
-				assert.Error(t, err)
+			if tt.wantErr {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				require.True(t, ok)
+				assert.Equal(t, codes.InvalidArgument, st.Code())
+				assert.Nil(t, resp)
+			} else {
+				assert.NoError(t, err)
+				require.NotNil(t, resp)
+				assert.NotEmpty(t, resp.Data)
+			}
+		})
+	}
+}
+
+func TestQueryRaw(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	start := base.Add(-time.Minute)
+	end := base.Add(time.Minute)
+
+	// Three samples share the first timestamp so page boundaries fall
+	// inside a run of duplicates.
+	stored := []models.TimeSeriesData{
+		{Time: base, Value: 1},
+		{Time: base, Value: 2},
+		{Time: base, Value: 3},
+		{Time: base.Add(time.Second), Value: 4},
+		{Time: base.Add(2 * time.Second), Value: 5},
+	}
+
+	// queryRaw emulates the repository's ordered LIMIT/OFFSET read
+	queryRaw := func(_ context.Context, from, to time.Time, skip, limit int) ([]models.TimeSeriesData, error) {
+		var matched []models.TimeSeriesData
+		for _, dp := range stored {
+			if !dp.Time.Before(from) && !dp.Time.After(to) {
+				matched = append(matched, dp)
+			}
+		}
+		if skip > len(matched) {
+			skip = len(matched)
+		}
+		matched = matched[skip:]
+		if len(matched) > limit {
+			matched = matched[:limit]
+		}
+		return matched, nil
+	}
+
+	t.Run("pages cover every sample once", func(t *testing.T) {
+		mockRepo.EXPECT().
+			QueryRaw(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(queryRaw).
+			AnyTimes()
+
+		var values []float64
+		token := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, len(stored), "pagination did not terminate")
+
+			resp, err := svc.QueryRaw(context.Background(), &pb.RawQueryRequest{
+				Start:     timestamppb.New(start),
+				End:       timestamppb.New(end),
+				PageSize:  2,
+				PageToken: token,
+			})
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(resp.Data), 2)
+
+			for _, dp := range resp.Data {
+				values = append(values, dp.Value)
+			}
+			if resp.NextPageToken == "" {
+				break
+			}
+			token = resp.NextPageToken
+		}
+
+		assert.Equal(t, []float64{1, 2, 3, 4, 5}, values)
+	})
+
+	t.Run("page size is capped", func(t *testing.T) {
+		cappedRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		cappedRepo.EXPECT().
+			QueryRaw(gomock.Any(), gomock.Any(), gomock.Any(), 0, 10001).
+			Return(nil, nil)
+
+		_, err := server.NewTimeSeriesService(cappedRepo).QueryRaw(context.Background(), &pb.RawQueryRequest{
+			Start:    timestamppb.New(start),
+			End:      timestamppb.New(end),
+			PageSize: 50000,
+		})
+		require.NoError(t, err)
+	})
+
+	invalid := []struct {
+		name          string
+		request       *pb.RawQueryRequest
+		expectedError string
+	}{
+		{
+			name: "Invalid time range",
+			request: &pb.RawQueryRequest{
+				Start: timestamppb.New(end),
+				End:   timestamppb.New(start),
+			},
+			expectedError: "start time must be before end time",
+		},
+		{
+			name: "Negative page size",
+			request: &pb.RawQueryRequest{
+				Start:    timestamppb.New(start),
+				End:      timestamppb.New(end),
+				PageSize: -1,
+			},
+			expectedError: "page_size must not be negative",
+		},
+		{
+			name: "Malformed page token",
+			request: &pb.RawQueryRequest{
+				Start:     timestamppb.New(start),
+				End:       timestamppb.New(end),
+				PageToken: "not-a-token",
+			},
+			expectedError: "invalid page token",
+		},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := svc.QueryRaw(context.Background(), tt.request)
+
+			require.Error(t, err)
+			st, ok := status.FromError(err)
+			require.True(t, ok)
+			assert.Equal(t, codes.InvalidArgument, st.Code())
+			assert.Contains(t, st.Message(), tt.expectedError)
+			assert.Nil(t, resp)
+		})
+	}
+}
+
+func TestGetLatest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		request       *pb.LatestRequest
+		setupMock     func()
+		expectedCode  codes.Code
+		expectedError string
+		expectedLen   int
+	}{
+		{
+			name:    "Defaults to one point",
+			request: &pb.LatestRequest{},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					QueryLatest(gomock.Any(), 1).
+					Return([]models.TimeSeriesData{{Time: now, Value: 42.0}}, nil)
+			},
+			expectedCode: codes.OK,
+			expectedLen:  1,
+		},
+		{
+			name:    "Last N points",
+			request: &pb.LatestRequest{Count: 3},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					QueryLatest(gomock.Any(), 3).
+					Return([]models.TimeSeriesData{
+						{Time: now, Value: 3.0},
+						{Time: now.Add(-time.Minute), Value: 2.0},
+						{Time: now.Add(-2 * time.Minute), Value: 1.0},
+					}, nil)
+			},
+			expectedCode: codes.OK,
+			expectedLen:  3,
+		},
+		{
+			name:    "Count is capped",
+			request: &pb.LatestRequest{Count: 5000},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					QueryLatest(gomock.Any(), 1000).
+					Return(nil, nil)
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:          "Negative count",
+			request:       &pb.LatestRequest{Count: -1},
+			setupMock:     func() {},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "count must not be negative",
+		},
+		{
+			name:    "Repository error",
+			request: &pb.LatestRequest{},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					QueryLatest(gomock.Any(), 1).
+					Return(nil, assert.AnError)
+			},
+			expectedCode:  codes.Internal,
+			expectedError: "query failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+
+			resp, err := svc.GetLatest(context.Background(), tt.request)
+
+			if tt.expectedCode != codes.OK {
+				require.Error(t, err)
 				st, ok := status.FromError(err)
 				require.True(t, ok)
-				assert.Equal(t, codes.InvalidArgument, st.Code())
+				assert.Equal(t, tt.expectedCode, st.Code())
+				assert.Contains(t, st.Message(), tt.expectedError)
 				assert.Nil(t, resp)
 			} else {
-				assert.NoError(t, err)
+				require.NoError(t, err)
 				require.NotNil(t, resp)
-				assert.NotEmpty(t, resp.Data)
+				assert.Len(t, resp.Data, tt.expectedLen)
+			}
+		})
+	}
+}
+
+func TestGetStatistics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	end := time.Date(2024, 11, 24, 0, 0, 0, 0, time.UTC)
+	start := end.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name          string
+		request       *pb.StatisticsRequest
+		setupMock     func()
+		expectedCode  codes.Code
+		expectedError string
+		expected      *pb.StatisticsResponse
+	}{
+		{
+			name:    "Summary of the range",
+			request: &pb.StatisticsRequest{Start: timestamppb.New(start), End: timestamppb.New(end)},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Statistics(gomock.Any(), start, end).
+					Return(models.Statistics{
+						Count: 4, Min: 2, Max: 6, Avg: 4, Sum: 16, Stddev: 1.5,
+						FirstTime: start, LastTime: end.Add(-time.Minute),
+					}, nil)
+			},
+			expectedCode: codes.OK,
+			expected: &pb.StatisticsResponse{
+				Count: 4, Min: 2, Max: 6, Avg: 4, Sum: 16, Stddev: 1.5,
+				FirstTime: timestamppb.New(start),
+				LastTime:  timestamppb.New(end.Add(-time.Minute)),
+			},
+		},
+		{
+			name:    "Empty range",
+			request: &pb.StatisticsRequest{Start: timestamppb.New(start), End: timestamppb.New(end)},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Statistics(gomock.Any(), start, end).
+					Return(models.Statistics{}, nil)
+			},
+			expectedCode: codes.OK,
+			expected:     &pb.StatisticsResponse{},
+		},
+		{
+			name:          "Reversed range",
+			request:       &pb.StatisticsRequest{Start: timestamppb.New(end), End: timestamppb.New(start)},
+			setupMock:     func() {},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "start time must be before end time",
+		},
+		{
+			name:    "Database error",
+			request: &pb.StatisticsRequest{Start: timestamppb.New(start), End: timestamppb.New(end)},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					Statistics(gomock.Any(), start, end).
+					Return(models.Statistics{}, assert.AnError)
+			},
+			expectedCode:  codes.Internal,
+			expectedError: "query failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+
+			resp, err := svc.GetStatistics(context.Background(), tt.request)
+
+			if tt.expectedCode != codes.OK {
+				require.Error(t, err)
+				st, ok := status.FromError(err)
+				require.True(t, ok)
+				assert.Equal(t, tt.expectedCode, st.Code())
+				assert.Contains(t, st.Message(), tt.expectedError)
+				assert.Nil(t, resp)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, proto.Equal(tt.expected, resp), "got %v", resp)
+			}
+		})
+	}
+}
+
+func TestInsertTimeSeries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	svc := server.NewTimeSeriesService(mockRepo)
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name          string
+		request       *pb.InsertRequest
+		setupMock     func()
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		{
+			name: "Success case",
+			request: &pb.InsertRequest{Data: []*pb.TimeSeriesDataPoint{
+				{Time: timestamppb.New(now), Value: 1.0},
+				{Time: timestamppb.New(now.Add(time.Second)), Value: 2.0},
+			}},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					BatchInsertTimeSeriesData(gomock.Any(), []models.TimeSeriesData{
+						{Time: now, Value: 1.0},
+						{Time: now.Add(time.Second), Value: 2.0},
+					}).
+					Return(nil)
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:          "Empty request",
+			request:       &pb.InsertRequest{},
+			setupMock:     func() {},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "no data points",
+		},
+		{
+			name: "Missing timestamp",
+			request: &pb.InsertRequest{Data: []*pb.TimeSeriesDataPoint{
+				{Value: 1.0},
+			}},
+			setupMock:     func() {},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "missing timestamp at index 0",
+		},
+		{
+			name: "Repository error",
+			request: &pb.InsertRequest{Data: []*pb.TimeSeriesDataPoint{
+				{Time: timestamppb.New(now), Value: 1.0},
+			}},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).
+					Return(assert.AnError)
+			},
+			expectedCode:  codes.Internal,
+			expectedError: "insert failed",
+		},
+		{
+			name: "Database failover",
+			request: &pb.InsertRequest{Data: []*pb.TimeSeriesDataPoint{
+				{Time: timestamppb.New(now), Value: 1.0},
+			}},
+			setupMock: func() {
+				mockRepo.EXPECT().
+					BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).
+					Return(fmt.Errorf("%w: reconnecting", database.ErrFailover))
+			},
+			expectedCode:  codes.Unavailable,
+			expectedError: "database failover in progress",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+
+			resp, err := svc.InsertTimeSeries(context.Background(), tt.request)
+
+			if tt.expectedCode != codes.OK {
+				require.Error(t, err)
+				st, ok := status.FromError(err)
+				require.True(t, ok)
+				assert.Equal(t, tt.expectedCode, st.Code())
+				assert.Contains(t, st.Message(), tt.expectedError)
+				assert.Nil(t, resp)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(tt.request.Data)), resp.Inserted)
 			}
 		})
 	}
 }
+
+func TestIngestTimeSeries(t *testing.T) {
+	now := time.Now()
+	message := func(values ...float64) *pb.InsertRequest {
+		req := &pb.InsertRequest{}
+		for _, v := range values {
+			req.Data = append(req.Data, &pb.TimeSeriesDataPoint{Time: timestamppb.New(now), Value: v})
+		}
+		return req
+	}
+
+	t.Run("inserts each message", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		stream := grpcmocks.NewMockTimeSeriesService_IngestTimeSeriesServer(ctrl)
+
+		gomock.InOrder(
+			stream.EXPECT().Recv().Return(message(1, 2), nil),
+			stream.EXPECT().Recv().Return(message(3), nil),
+			stream.EXPECT().Recv().Return(nil, io.EOF),
+		)
+		stream.EXPECT().Context().Return(context.Background()).AnyTimes()
+		mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(2)).Return(nil)
+		mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(1)).Return(nil)
+		stream.EXPECT().SendAndClose(&pb.InsertResponse{Inserted: 3}).Return(nil)
+
+		assert.NoError(t, server.NewTimeSeriesService(mockRepo).IngestTimeSeries(stream))
+	})
+
+	t.Run("invalid message aborts the stream", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		stream := grpcmocks.NewMockTimeSeriesService_IngestTimeSeriesServer(ctrl)
+
+		gomock.InOrder(
+			stream.EXPECT().Recv().Return(message(1), nil),
+			stream.EXPECT().Recv().Return(message(math.NaN()), nil),
+		)
+		stream.EXPECT().Context().Return(context.Background()).AnyTimes()
+		mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Len(1)).Return(nil)
+
+		err := server.NewTimeSeriesService(mockRepo).IngestTimeSeries(stream)
+		st, ok := status.FromError(err)
+		require.True(t, ok)
+		assert.Equal(t, codes.InvalidArgument, st.Code())
+		assert.Equal(t, "message 1: invalid value at index 0", st.Message())
+	})
+}
+
+func TestExportTimeSeries(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	points := []models.TimeSeriesData{
+		{Time: start, Value: 1.5},
+		{Time: start.Add(time.Minute), Value: 2},
+	}
+
+	t.Run("raw csv", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		stream := grpcmocks.NewMockTimeSeriesService_ExportTimeSeriesServer(ctrl)
+
+		stream.EXPECT().Context().Return(context.Background()).AnyTimes()
+		mockRepo.EXPECT().QueryRaw(gomock.Any(), start, end, 0, gomock.Any()).Return(points, nil)
+
+		var chunks []*pb.ExportChunk
+		stream.EXPECT().Send(gomock.Any()).DoAndReturn(func(chunk *pb.ExportChunk) error {
+			chunks = append(chunks, chunk)
+			return nil
+		}).AnyTimes()
+
+		err := server.NewTimeSeriesService(mockRepo).ExportTimeSeries(&pb.ExportRequest{
+			Start: timestamppb.New(start),
+			End:   timestamppb.New(end),
+		}, stream)
+		require.NoError(t, err)
+
+		require.Len(t, chunks, 2)
+		assert.Equal(t, "text/csv", chunks[0].ContentType)
+		assert.Equal(t, "edgecom-20241123T0000-20241123T0100.csv", chunks[0].Filename)
+		assert.Empty(t, chunks[0].Data)
+		assert.Equal(t, "time,value\n2024-11-23T00:00:00Z,1.5\n2024-11-23T00:01:00Z,2\n", string(chunks[1].Data))
+	})
+
+	t.Run("aggregated parquet", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		stream := grpcmocks.NewMockTimeSeriesService_ExportTimeSeriesServer(ctrl)
+
+		stream.EXPECT().Context().Return(context.Background()).AnyTimes()
+		mockRepo.EXPECT().Query(gomock.Any(), start, end, "1m", "AVG").Return(points, nil)
+
+		var filename string
+		var file []byte
+		stream.EXPECT().Send(gomock.Any()).DoAndReturn(func(chunk *pb.ExportChunk) error {
+			if chunk.Filename != "" {
+				filename = chunk.Filename
+			}
+			file = append(file, chunk.Data...)
+			return nil
+		}).AnyTimes()
+
+		err := server.NewTimeSeriesService(mockRepo).ExportTimeSeries(&pb.ExportRequest{
+			Start:       timestamppb.New(start),
+			End:         timestamppb.New(end),
+			Window:      "1m",
+			Aggregation: "AVG",
+			Format:      "parquet",
+			Gzip:        true,
+		}, stream)
+		require.NoError(t, err)
+		assert.Equal(t, "edgecom-20241123T0000-20241123T0100-1m-avg.parquet", filename)
+		assert.Equal(t, "PAR1", string(file[:4]))
+		assert.Equal(t, "PAR1", string(file[len(file)-4:]))
+	})
+
+	t.Run("tsz with the configured codec", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		stream := grpcmocks.NewMockTimeSeriesService_ExportTimeSeriesServer(ctrl)
+
+		stream.EXPECT().Context().Return(context.Background()).AnyTimes()
+		mockRepo.EXPECT().QueryRaw(gomock.Any(), start, end, 0, gomock.Any()).Return(points, nil)
+
+		var chunks []*pb.ExportChunk
+		var file []byte
+		stream.EXPECT().Send(gomock.Any()).DoAndReturn(func(chunk *pb.ExportChunk) error {
+			chunks = append(chunks, chunk)
+			file = append(file, chunk.Data...)
+			return nil
+		}).AnyTimes()
+
+		service := server.NewTimeSeriesService(mockRepo)
+		service.SetExportCodec(codec.Delta)
+		err := service.ExportTimeSeries(&pb.ExportRequest{
+			Start:  timestamppb.New(start),
+			End:    timestamppb.New(end),
+			Format: "tsz",
+			Gzip:   true,
+		}, stream)
+		require.NoError(t, err)
+
+		assert.Equal(t, "application/vnd.edgecom.tsz", chunks[0].ContentType)
+		assert.Equal(t, "edgecom-20241123T0000-20241123T0100.tsz", chunks[0].Filename)
+		assert.Equal(t, "ETSZ\x01\x05delta", string(file[:11]))
+		var decoded []models.TimeSeriesData
+		require.NoError(t, export.ReadTSZ(bytes.NewReader(file), func(block []models.TimeSeriesData) error {
+			decoded = append(decoded, block...)
+			return nil
+		}))
+		assert.Equal(t, points, decoded)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			req     *pb.ExportRequest
+			wantMsg string
+		}{
+			{name: "missing start", req: &pb.ExportRequest{End: timestamppb.New(end)}, wantMsg: "missing timestamp"},
+			{name: "aggregation without window", req: &pb.ExportRequest{Start: timestamppb.New(start), End: timestamppb.New(end), Aggregation: "SUM"}, wantMsg: "aggregation requires a window"},
+			{name: "invalid window", req: &pb.ExportRequest{Start: timestamppb.New(start), End: timestamppb.New(end), Window: "2m", Aggregation: "SUM"}, wantMsg: "invalid window: 2m"},
+			{name: "unknown format", req: &pb.ExportRequest{Start: timestamppb.New(start), End: timestamppb.New(end), Format: "xlsx"}, wantMsg: `unsupported export format "xlsx"`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				stream := grpcmocks.NewMockTimeSeriesService_ExportTimeSeriesServer(ctrl)
+
+				err := server.NewTimeSeriesService(mocks.NewMockTimeSeriesRepository(ctrl)).ExportTimeSeries(tt.req, stream)
+				st, ok := status.FromError(err)
+				require.True(t, ok)
+				assert.Equal(t, codes.InvalidArgument, st.Code())
+				assert.Contains(t, st.Message(), tt.wantMsg)
+			})
+		}
+	})
+}
+
+func TestSubscribeTimeSeries(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	batch := []models.TimeSeriesData{
+		{Time: start.Add(-time.Minute), Value: 1},
+		{Time: start.Add(time.Minute), Value: 2},
+		{Time: start.Add(2 * time.Minute), Value: 3},
+	}
+
+	// subscribe runs SubscribeTimeSeries until it returns, sending the
+	// streamed responses on the returned channel
+	subscribe := func(t *testing.T, service *server.TimeSeriesService, broker *stream.Broker, req *pb.SubscribeRequest) (chan *pb.TimeSeriesResponse, chan error, context.CancelFunc) {
+		ctrl := gomock.NewController(t)
+		srv := grpcmocks.NewMockTimeSeriesService_SubscribeTimeSeriesServer(ctrl)
+		ctx, cancel := context.WithCancel(context.Background())
+		srv.EXPECT().Context().Return(ctx).AnyTimes()
+
+		responses := make(chan *pb.TimeSeriesResponse, 10)
+		srv.EXPECT().Send(gomock.Any()).DoAndReturn(func(resp *pb.TimeSeriesResponse) error {
+			responses <- resp
+			return nil
+		}).AnyTimes()
+
+		subscribers := broker.Subscribers()
+		done := make(chan error, 1)
+		go func() { done <- service.SubscribeTimeSeries(req, srv) }()
+		require.Eventually(t, func() bool { return broker.Subscribers() > subscribers }, time.Second, time.Millisecond)
+		return responses, done, cancel
+	}
+
+	t.Run("raw points in range", func(t *testing.T) {
+		broker := stream.NewBroker()
+		service := server.NewTimeSeriesService(mocks.NewMockTimeSeriesRepository(gomock.NewController(t)))
+		service.SetBroker(broker)
+
+		responses, done, cancel := subscribe(t, service, broker, &pb.SubscribeRequest{Start: timestamppb.New(start)})
+		broker.Publish(batch[:1])
+		broker.Publish(batch)
+
+		resp := <-responses
+		require.Len(t, resp.Data, 2)
+		assert.Equal(t, 2.0, resp.Data[0].Value)
+		assert.Equal(t, 3.0, resp.Data[1].Value)
+		assert.Empty(t, responses, "batches outside the range are not sent")
+
+		cancel()
+		assert.NoError(t, <-done)
+		assert.Zero(t, broker.Subscribers())
+	})
+
+	t.Run("aggregated buckets", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+		broker := stream.NewBroker()
+		service := server.NewTimeSeriesService(mockRepo)
+		service.SetBroker(broker)
+
+		bucketStart := start.Add(-time.Hour)
+		mockRepo.EXPECT().
+			Query(gomock.Any(), bucketStart, start.Add(time.Hour-time.Microsecond), "1h", "SUM").
+			Return([]models.TimeSeriesData{{Time: bucketStart, Value: 1}, {Time: start, Value: 5}}, nil)
+
+		responses, done, cancel := subscribe(t, service, broker, &pb.SubscribeRequest{Window: "1h", Aggregation: "SUM"})
+		broker.Publish(batch)
+
+		resp := <-responses
+		assert.Equal(t, "1h", resp.Window)
+		require.Len(t, resp.Data, 2)
+		assert.Equal(t, 5.0, resp.Data[1].Value)
+
+		cancel()
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		broker := stream.NewBroker()
+		service := server.NewTimeSeriesService(mocks.NewMockTimeSeriesRepository(gomock.NewController(t)))
+		service.SetBroker(broker)
+
+		_, done, cancel := subscribe(t, service, broker, &pb.SubscribeRequest{})
+		defer cancel()
+		broker.Close()
+		assert.Equal(t, codes.Unavailable, status.Code(<-done))
+	})
+
+	t.Run("slow consumer disconnected", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		broker := stream.NewBroker()
+		service := server.NewTimeSeriesService(mocks.NewMockTimeSeriesRepository(ctrl))
+		service.SetBroker(broker)
+
+		srv := grpcmocks.NewMockTimeSeriesService_SubscribeTimeSeriesServer(ctrl)
+		srv.EXPECT().Context().Return(context.Background()).AnyTimes()
+		sending := make(chan struct{}, 10)
+		release := make(chan struct{})
+		srv.EXPECT().Send(gomock.Any()).DoAndReturn(func(*pb.TimeSeriesResponse) error {
+			sending <- struct{}{}
+			<-release
+			return nil
+		}).AnyTimes()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- service.SubscribeTimeSeries(&pb.SubscribeRequest{Overflow: "disconnect", BufferSize: 1}, srv)
+		}()
+		require.Eventually(t, func() bool { return broker.Subscribers() == 1 }, time.Second, time.Millisecond)
+
+		// The first batch is stuck in Send, the second fills the buffer
+		// and the third overflows it
+		broker.Publish(batch)
+		<-sending
+		broker.Publish(batch)
+		broker.Publish(batch)
+		assert.Zero(t, broker.Subscribers())
+
+		close(release)
+		err := <-done
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "falling behind")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		srv := grpcmocks.NewMockTimeSeriesService_SubscribeTimeSeriesServer(ctrl)
+		service := server.NewTimeSeriesService(mocks.NewMockTimeSeriesRepository(ctrl))
+
+		err := service.SubscribeTimeSeries(&pb.SubscribeRequest{}, srv)
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+		service.SetBroker(stream.NewBroker())
+		err = service.SubscribeTimeSeries(&pb.SubscribeRequest{Window: "2m", Aggregation: "SUM"}, srv)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		err = service.SubscribeTimeSeries(&pb.SubscribeRequest{Start: timestamppb.New(start), End: timestamppb.New(start.Add(-time.Hour))}, srv)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		err = service.SubscribeTimeSeries(&pb.SubscribeRequest{Overflow: "block"}, srv)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		err = service.SubscribeTimeSeries(&pb.SubscribeRequest{BufferSize: stream.MaxBufferSize + 1}, srv)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
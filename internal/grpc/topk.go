@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tejusbharadwaj/edgecom/internal/calendar"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+const (
+	// maxTopK caps the number of series TopK returns
+	maxTopK = 1000
+	// maxRankedSeries caps the number of series a TopK selector may
+	// select, all of which are evaluated in memory
+	maxRankedSeries = 1000
+)
+
+// TopK ranks the series a label selector selects by their aggregate over a
+// range, such as the ten feeders with the highest peak load last week, and
+// returns the k highest, or the k lowest with bottom, so that clients do
+// not have to query every series and rank them themselves.
+//
+// Each series is computed from the buckets of the stored series over the
+// window, as QueryTimeSeries would, and reduced over the range with the
+// aggregation: its highest bucket for MAX, with the time of the bucket,
+// its lowest for MIN, the sum of its buckets for SUM and their average,
+// weighted by their samples, for AVG. Every series is computed from the
+// same buckets, so one query serves the whole ranking, and each selected
+// series must pass validateDerived. Buckets where a series is undefined or
+// not finite are skipped, and series undefined at every bucket, such as
+// those without samples in the range, are not ranked but are counted in
+// total_series. Ties are broken by name.
+//
+// Only the buckets are aggregated by the database: the series are
+// expressions over them evaluated by the service, which SQL cannot rank
+// them by, so the ranking itself is computed in memory, over every
+// selected series. Its cost grows with the series times the buckets, so a
+// selector selecting more than maxRankedSeries series is refused.
+func (s *TimeSeriesService) TopK(
+	ctx context.Context,
+	req *pb.TopKRequest,
+) (*pb.TopKResponse, error) {
+	start := req.Start.AsTime()
+	end := req.End.AsTime()
+	if err := s.validator.Validate(start, end, req.Window, req.Aggregation); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	if req.Aggregation == AggregationLoadFactor || req.Aggregation == AggregationUtilization {
+		return nil, status.Errorf(codes.InvalidArgument, "series cannot be ranked by %s", req.Aggregation)
+	}
+	if req.K < 1 || req.K > maxTopK {
+		return nil, status.Errorf(codes.InvalidArgument, "k must be between 1 and %d", maxTopK)
+	}
+	if req.Selector == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "selector is required")
+	}
+	var cal *calendar.Calendar
+	if req.Calendar != "" {
+		var ok bool
+		cal, ok = s.calendars[req.Calendar]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown calendar: %s", req.Calendar)
+		}
+	}
+
+	derived := s.derivedSeries()
+	selected, err := s.selectAll(ctx, req.Selector, derived)
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) > maxRankedSeries {
+		return nil, status.Errorf(codes.InvalidArgument, "selector selects %d series, more than the %d that can be ranked", len(selected), maxRankedSeries)
+	}
+	for _, series := range selected {
+		if err := validateDerived(derived, series.Name, req.Aggregation); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+	}
+
+	queryStart := time.Now()
+	buckets, err := s.queryBuckets(ctx, start, end, req.Window, req.Aggregation, cal)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(queryStart)
+
+	var ranked []*pb.RankedSeries
+	for _, series := range selected {
+		value, at, ok := rangeAggregate(derived, series.Name, req.Aggregation, buckets)
+		if !ok {
+			continue
+		}
+		r := &pb.RankedSeries{Series: series.Name, Tags: series.Tags, Value: value}
+		if !at.IsZero() {
+			r.Time = timestamppb.New(at)
+		}
+		ranked = append(ranked, r)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Value != ranked[j].Value {
+			return (ranked[i].Value > ranked[j].Value) != req.Bottom
+		}
+		return ranked[i].Series < ranked[j].Series
+	})
+	if len(ranked) > int(req.K) {
+		ranked = ranked[:req.K]
+	}
+
+	resp := &pb.TopKResponse{
+		Series:      ranked,
+		TotalSeries: int32(len(selected)),
+		Metadata:    queryMetadata(req.Window, req.Aggregation, req.Calendar, nil, elapsed),
+	}
+	for _, b := range buckets {
+		resp.Metadata.TotalSamples += b.Count
+	}
+	return resp, nil
+}
+
+// rangeAggregate reduces the series name, computed from buckets of the
+// stored series, to its aggregate over their range, returning the time of
+// the bucket reached for MIN and MAX. Values that are not finite, which
+// would leave the ranking unordered, are skipped. It returns false if the
+// series is undefined at every bucket, or its aggregate is not finite.
+func rangeAggregate(derived *expression.Derived, name, aggregation string, buckets []models.TimeSeriesData) (float64, time.Time, bool) {
+	var value, samples float64
+	var at time.Time
+	n := 0
+	for _, b := range buckets {
+		v, ok := seriesValue(derived, name, aggregation, b)
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		switch aggregation {
+		case AggregationMin:
+			if n == 0 || v < value {
+				value, at = v, b.Time
+			}
+		case AggregationMax:
+			if n == 0 || v > value {
+				value, at = v, b.Time
+			}
+		case AggregationAvg:
+			value += v * float64(b.Count)
+			samples += float64(b.Count)
+		default:
+			value += v
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, time.Time{}, false
+	}
+	if aggregation == AggregationAvg {
+		if samples == 0 {
+			return 0, time.Time{}, false
+		}
+		value /= samples
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, time.Time{}, false
+	}
+	return value, at, true
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// validateTransform checks that transform is empty or a supported
+// transform
+func validateTransform(transform string) error {
+	switch transform {
+	case "", TransformDelta, TransformRate, TransformCumsum:
+		return nil
+	}
+	return fmt.Errorf("invalid transform: %s, expected %s, %s or %s", transform, TransformDelta, TransformRate, TransformCumsum)
+}
+
+// applyTransform computes transform over points, which are in time order,
+// and returns them unchanged when transform is empty. DELTA and RATE have
+// no value for the first point, which is omitted; the gap before a point
+// whose previous bucket had no samples is spanned, so RATE divides by the
+// actual time between the points. They take points to be a counter, which
+// only decreases when it is reset: a point below the previous one counts
+// from zero, so its change is its own value. Each point keeps its sample
+// count.
+func applyTransform(transform string, points []models.TimeSeriesData) []models.TimeSeriesData {
+	switch transform {
+	case TransformDelta, TransformRate:
+		if len(points) < 2 {
+			return nil
+		}
+		values := make([]models.TimeSeriesData, 0, len(points)-1)
+		for i := 1; i < len(points); i++ {
+			p := points[i]
+			if p.Value >= points[i-1].Value {
+				p.Value -= points[i-1].Value
+			}
+			if transform == TransformRate {
+				p.Value /= points[i].Time.Sub(points[i-1].Time).Seconds()
+			}
+			values = append(values, p)
+		}
+		return values
+	case TransformCumsum:
+		values := make([]models.TimeSeriesData, len(points))
+		sum := 0.0
+		for i, p := range points {
+			sum += p.Value
+			p.Value = sum
+			values[i] = p
+		}
+		return values
+	}
+	return points
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestApplyTransform(t *testing.T) {
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	// A meter's cumulative counter, with the bucket at 02:00 missing
+	points := []models.TimeSeriesData{
+		{Time: start, Value: 1000, Count: 60},
+		{Time: start.Add(time.Hour), Value: 1360, Count: 60},
+		{Time: start.Add(3 * time.Hour), Value: 2080, Count: 30},
+	}
+	values := func(points []models.TimeSeriesData) []float64 {
+		var values []float64
+		for _, p := range points {
+			values = append(values, p.Value)
+		}
+		return values
+	}
+
+	delta := applyTransform(TransformDelta, points)
+	assert.Equal(t, []float64{360, 720}, values(delta))
+	assert.Equal(t, start.Add(time.Hour), delta[0].Time)
+	assert.Equal(t, int64(30), delta[1].Count)
+
+	assert.Equal(t, []float64{0.1, 0.1}, values(applyTransform(TransformRate, points)), "the gap is divided over")
+	assert.Equal(t, []float64{1000, 2360, 4440}, values(applyTransform(TransformCumsum, points)))
+	assert.Equal(t, points, applyTransform("", points))
+	assert.Empty(t, applyTransform(TransformDelta, points[:1]))
+	assert.Equal(t, 1000.0, points[0].Value, "points are not modified")
+
+	// The meter restarts from zero after 01:00
+	reset := []models.TimeSeriesData{
+		{Time: start, Value: 1000},
+		{Time: start.Add(time.Hour), Value: 1360},
+		{Time: start.Add(2 * time.Hour), Value: 90},
+		{Time: start.Add(3 * time.Hour), Value: 450},
+	}
+	assert.Equal(t, []float64{360, 90, 360}, values(applyTransform(TransformDelta, reset)))
+	assert.Equal(t, []float64{0.1, 0.025, 0.1}, values(applyTransform(TransformRate, reset)))
+
+	assert.NoError(t, validateTransform(TransformRate))
+	assert.ErrorContains(t, validateTransform("DERIVATIVE"), "invalid transform: DERIVATIVE")
+}
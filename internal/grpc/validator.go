@@ -2,18 +2,37 @@ package server
 
 import (
 	"fmt"
+	"math"
+	"sync/atomic"
 	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
 )
 
 const maxTimeRange = 2 * 365 * 24 * time.Hour
 
+const (
+	// defaultMaxInsertPoints caps the number of points in a single write
+	// until the point limits are changed
+	defaultMaxInsertPoints = 10000
+	// maxClockSkew is how far in the future a written point may be
+	maxClockSkew = 5 * time.Minute
+)
+
 type RequestValidator struct {
 	validWindows      map[string]bool
 	validAggregations map[string]bool
+
+	// clock is the time written points may not be later than
+	clock clock.Clock
+
+	// maxInsertPoints caps the number of points in a single write
+	maxInsertPoints atomic.Int64
 }
 
 func NewRequestValidator() *RequestValidator {
-	return &RequestValidator{
+	v := &RequestValidator{
 		validWindows: map[string]bool{
 			"1m": true,
 			"5m": true,
@@ -21,16 +40,30 @@ func NewRequestValidator() *RequestValidator {
 			"1d": true,
 		},
 		validAggregations: map[string]bool{
-			"MIN": true,
-			"MAX": true,
-			"AVG": true,
-			"SUM": true,
+			"MIN":         true,
+			"MAX":         true,
+			"AVG":         true,
+			"SUM":         true,
+			"LOAD_FACTOR": true,
+			"UTILIZATION": true,
 		},
+		clock: clock.System,
 	}
+	v.maxInsertPoints.Store(defaultMaxInsertPoints)
+	return v
 }
 
 // Validate checks if the request parameters are valid
 func (v *RequestValidator) Validate(start, end time.Time, window, aggregation string) error {
+	if err := v.ValidateRange(start, end); err != nil {
+		return err
+	}
+
+	return v.ValidateAggregation(window, aggregation)
+}
+
+// ValidateRange checks that the time range is present, ordered and bounded
+func (v *RequestValidator) ValidateRange(start, end time.Time) error {
 	// Validate timestamps are present
 	if start.IsZero() || end.IsZero() || start.Equal(time.Unix(0, 0)) || end.Equal(time.Unix(0, 0)) {
 		return fmt.Errorf("missing timestamp")
@@ -46,6 +79,11 @@ func (v *RequestValidator) Validate(start, end time.Time, window, aggregation st
 		return fmt.Errorf("time range exceeds maximum allowed")
 	}
 
+	return nil
+}
+
+// ValidateAggregation checks that the window and aggregation are supported
+func (v *RequestValidator) ValidateAggregation(window, aggregation string) error {
 	// Validate window
 	if window == "" {
 		return fmt.Errorf("invalid window: ")
@@ -64,3 +102,30 @@ func (v *RequestValidator) Validate(start, end time.Time, window, aggregation st
 
 	return nil
 }
+
+// ValidatePoints checks that a write contains a bounded number of points,
+// each with a timestamp that is set and not in the future, and a finite
+// value
+func (v *RequestValidator) ValidatePoints(points []models.TimeSeriesData) error {
+	if len(points) == 0 {
+		return fmt.Errorf("no data points")
+	}
+	if limit := v.maxInsertPoints.Load(); int64(len(points)) > limit {
+		return fmt.Errorf("too many data points: %d exceeds maximum of %d", len(points), limit)
+	}
+
+	latest := v.clock.Now().Add(maxClockSkew)
+	for i, p := range points {
+		if p.Time.IsZero() || p.Time.Equal(time.Unix(0, 0)) {
+			return fmt.Errorf("missing timestamp at index %d", i)
+		}
+		if p.Time.After(latest) {
+			return fmt.Errorf("timestamp in the future at index %d", i)
+		}
+		if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			return fmt.Errorf("invalid value at index %d", i)
+		}
+	}
+
+	return nil
+}
@@ -1,13 +1,18 @@
 package server
 
 import (
+	"math"
 	"testing"
 	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
 )
 
 func TestRequestValidator_Validate(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
 	validator := NewRequestValidator()
-	now := time.Now()
+	validator.clock = clock.NewFake(now)
 
 	tests := []struct {
 		name        string
@@ -104,3 +109,75 @@ func TestRequestValidator_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestValidator_ValidatePoints(t *testing.T) {
+	validator := NewRequestValidator()
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		points     []models.TimeSeriesData
+		wantErr    bool
+		errMessage string
+	}{
+		{
+			name:    "valid points",
+			points:  []models.TimeSeriesData{{Time: now.Add(-time.Minute), Value: 1.5}, {Time: now, Value: -2}},
+			wantErr: false,
+		},
+		{
+			name:       "no points",
+			points:     nil,
+			wantErr:    true,
+			errMessage: "no data points",
+		},
+		{
+			name:       "too many points",
+			points:     make([]models.TimeSeriesData, defaultMaxInsertPoints+1),
+			wantErr:    true,
+			errMessage: "too many data points: 10001 exceeds maximum of 10000",
+		},
+		{
+			name:       "missing timestamp",
+			points:     []models.TimeSeriesData{{Time: now, Value: 1}, {Value: 2}},
+			wantErr:    true,
+			errMessage: "missing timestamp at index 1",
+		},
+		{
+			name:    "timestamp within the clock skew",
+			points:  []models.TimeSeriesData{{Time: now.Add(maxClockSkew), Value: 1}},
+			wantErr: false,
+		},
+		{
+			name:       "future timestamp",
+			points:     []models.TimeSeriesData{{Time: now.Add(maxClockSkew + time.Second), Value: 1}},
+			wantErr:    true,
+			errMessage: "timestamp in the future at index 0",
+		},
+		{
+			name:       "NaN value",
+			points:     []models.TimeSeriesData{{Time: now, Value: math.NaN()}},
+			wantErr:    true,
+			errMessage: "invalid value at index 0",
+		},
+		{
+			name:       "infinite value",
+			points:     []models.TimeSeriesData{{Time: now, Value: math.Inf(1)}},
+			wantErr:    true,
+			errMessage: "invalid value at index 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidatePoints(tt.points)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePoints() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && err.Error() != tt.errMessage {
+				t.Errorf("ValidatePoints() error message = %v, want %v", err.Error(), tt.errMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/expression"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// VirtualSeriesRefreshInterval is how often RefreshVirtualSeries reloads
+// the virtual series, so that those saved through another instance are
+// picked up.
+const VirtualSeriesRefreshInterval = time.Minute
+
+// seriesSet is the derived series queries may name: those of the
+// configuration and the saved virtual series
+type seriesSet struct {
+	derived *expression.Derived
+	// virtual maps the names of the virtual series to their definitions,
+	// whose units, descriptions and tags cached responses such as the
+	// series catalog depend on as well
+	virtual map[string]models.VirtualSeries
+}
+
+// derivedSeries returns the derived series queries may name
+func (s *TimeSeriesService) derivedSeries() *expression.Derived {
+	if set := s.series.Load(); set != nil {
+		return set.derived
+	}
+	return s.derived
+}
+
+// SetSeriesChangeFunc sets the function called once the virtual series
+// change, such as one dropping cached responses computed from previous
+// definitions. It must be called before the service starts serving.
+func (s *TimeSeriesService) SetSeriesChangeFunc(onChange func()) {
+	s.onSeriesChange = onChange
+}
+
+// CheckVirtualSeries returns an error if the virtual series cannot be
+// queried together: if a name is taken or invalid, or an expression is
+// invalid or refers to an unknown series or, through others, to itself.
+func (s *TimeSeriesService) CheckVirtualSeries(virtual []models.VirtualSeries) error {
+	_, err := s.buildSeries(virtual)
+	return err
+}
+
+// ReloadVirtualSeries reads the saved virtual series and makes them
+// available to queries in place of the previous ones. Series that cannot
+// be queried, such as those saved by an instance whose configuration
+// defines other derived series, are skipped along with those referring
+// to them, and an error naming them is returned once the others are in
+// place.
+func (s *TimeSeriesService) ReloadVirtualSeries(ctx context.Context) error {
+	virtual, err := s.repository.VirtualSeries(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read virtual series: %w", err)
+	}
+
+	s.seriesMu.Lock()
+	defer s.seriesMu.Unlock()
+	set, skipped, err := s.buildValidSeries(virtual)
+	if err != nil {
+		return err
+	}
+	if previous := s.series.Load(); previous == nil || !maps.EqualFunc(previous.virtual, set.virtual, sameVirtualSeries) {
+		s.series.Store(set)
+		if s.onSeriesChange != nil {
+			s.onSeriesChange()
+		}
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("skipped invalid virtual series: %w", errors.Join(skipped...))
+	}
+	return nil
+}
+
+// RefreshVirtualSeries reloads the virtual series every interval until
+// ctx is done, logging failures.
+func (s *TimeSeriesService) RefreshVirtualSeries(ctx context.Context, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.ReloadVirtualSeries(ctx); err != nil && ctx.Err() == nil {
+			logger.WithError(err).Warn("Failed to reload virtual series")
+		}
+	}
+}
+
+// buildSeries returns the series of the configuration extended with the
+// virtual series
+func (s *TimeSeriesService) buildSeries(virtual []models.VirtualSeries) (*seriesSet, error) {
+	base := s.derived
+	if base == nil {
+		var err error
+		if base, err = expression.NewDerived(nil, database.DefaultSeries); err != nil {
+			return nil, err
+		}
+	}
+
+	definitions := make(map[string]string, len(virtual))
+	series := make(map[string]models.VirtualSeries, len(virtual))
+	for _, v := range virtual {
+		definitions[v.Name] = v.Expression
+		series[v.Name] = v
+	}
+	derived, err := base.Extend(definitions)
+	if err != nil {
+		return nil, err
+	}
+	return &seriesSet{derived: derived, virtual: series}, nil
+}
+
+// sameVirtualSeries reports whether a and b define the same series, with
+// the same unit, description and tags, whenever they were saved
+func sameVirtualSeries(a, b models.VirtualSeries) bool {
+	return a.Name == b.Name && a.Expression == b.Expression && a.Unit == b.Unit &&
+		a.Description == b.Description && maps.Equal(a.Tags, b.Tags)
+}
+
+// buildValidSeries returns the series of the configuration extended with
+// those of virtual that can be queried, and the errors of the others.
+// Series are added while any of the remaining ones can be, so that those
+// referring to others are added once these are.
+func (s *TimeSeriesService) buildValidSeries(virtual []models.VirtualSeries) (*seriesSet, []error, error) {
+	if set, err := s.buildSeries(virtual); err == nil {
+		return set, nil, nil
+	}
+
+	var valid []models.VirtualSeries
+	remaining := virtual
+	for added := true; added; {
+		added = false
+		var invalid []models.VirtualSeries
+		for _, v := range remaining {
+			if _, err := s.buildSeries(append(valid, v)); err != nil {
+				invalid = append(invalid, v)
+				continue
+			}
+			valid = append(valid, v)
+			added = true
+		}
+		remaining = invalid
+	}
+
+	set, err := s.buildSeries(valid)
+	if err != nil {
+		return nil, nil, err
+	}
+	skipped := make([]error, len(remaining))
+	for i, v := range remaining {
+		_, err := s.buildSeries(append(valid, v))
+		skipped[i] = fmt.Errorf("%s: %w", v.Name, err)
+	}
+	return set, skipped, nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+	"github.com/tejusbharadwaj/edgecom/internal/weather"
+	pb "github.com/tejusbharadwaj/edgecom/proto"
+)
+
+// validateWeatherNormalized checks that a query may be normalized for the
+// weather. The model is linear in the buckets, so only sums and averages
+// can be restated with it, and it is fitted to the whole range, which
+// pages would each see only part of. Transformed buckets are no longer
+// consumption the model could be fitted to.
+func (s *TimeSeriesService) validateWeatherNormalized(req *pb.TimeSeriesRequest) error {
+	switch {
+	case s.weatherSource == nil:
+		return status.Errorf(codes.FailedPrecondition, "weather normalization is not configured")
+	case req.Aggregation != AggregationSum && req.Aggregation != AggregationAvg:
+		return status.Errorf(codes.InvalidArgument, "%s buckets cannot be weather-normalized, use SUM or AVG", req.Aggregation)
+	case req.PageSize != 0 || req.PageToken != "":
+		return status.Errorf(codes.InvalidArgument, "weather_normalized cannot be combined with paging")
+	case req.Transform != "":
+		return status.Errorf(codes.InvalidArgument, "weather_normalized cannot be combined with a transform")
+	}
+	return nil
+}
+
+// normalizeWeather restates the buckets of the range from start to end at
+// the normal weather of their time of year. A model of consumption over
+// heating and cooling degrees is fitted to the buckets, and each bucket is
+// then corrected by the consumption the model attributes to the difference
+// between its degrees and its normal degrees: the mean degrees of the same
+// bucket in each of the previous normalYears years with temperatures. A
+// mild year and a cold one are thereby both restated as if they had had
+// the usual weather, so that they can be compared.
+func (s *TimeSeriesService) normalizeWeather(
+	ctx context.Context,
+	start, end time.Time,
+	window string,
+	buckets []models.TimeSeriesData,
+) ([]models.TimeSeriesData, *pb.WeatherModel, error) {
+	length := windowDurations[window]
+	readings, err := s.weatherSource.Temperatures(ctx, start, end)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Unavailable, "temperatures unavailable: %v", err)
+	}
+	observations := make([]weather.Observation, len(buckets))
+	for i, bucket := range buckets {
+		heating, cooling, ok := weather.Degrees(readings, bucket.Time, length, s.balancePoint)
+		if !ok {
+			return nil, nil, status.Errorf(codes.FailedPrecondition, "no temperature for %s", bucket.Time.UTC().Format(time.RFC3339))
+		}
+		observations[i] = weather.Observation{Value: bucket.Value, Heating: heating, Cooling: cooling}
+	}
+
+	// Sum the degrees of each bucket in the previous years
+	type normal struct {
+		heating, cooling float64
+		n                int
+	}
+	normals := make([]normal, len(buckets))
+	for year := 1; year <= s.normalYears; year++ {
+		readings, err := s.weatherSource.Temperatures(ctx, start.AddDate(-year, 0, 0), end.AddDate(-year, 0, 0))
+		if err != nil {
+			return nil, nil, status.Errorf(codes.Unavailable, "temperatures unavailable: %v", err)
+		}
+		for i, bucket := range buckets {
+			heating, cooling, ok := weather.Degrees(readings, bucket.Time.AddDate(-year, 0, 0), length, s.balancePoint)
+			if !ok {
+				continue
+			}
+			normals[i].heating += heating
+			normals[i].cooling += cooling
+			normals[i].n++
+		}
+	}
+
+	model, err := weather.Fit(observations, s.balancePoint)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.FailedPrecondition, "%s", err.Error())
+	}
+	normalized := make([]models.TimeSeriesData, len(buckets))
+	for i, bucket := range buckets {
+		n := normals[i]
+		if n.n == 0 {
+			return nil, nil, status.Errorf(codes.FailedPrecondition, "no normal temperature for %s", bucket.Time.UTC().Format(time.RFC3339))
+		}
+		bucket.Value = model.Normalize(observations[i], n.heating/float64(n.n), n.cooling/float64(n.n))
+		normalized[i] = bucket
+	}
+
+	return normalized, &pb.WeatherModel{
+		BalancePoint: model.BalancePoint,
+		BaseLoad:     model.BaseLoad,
+		HeatingSlope: model.HeatingSlope,
+		CoolingSlope: model.CoolingSlope,
+		RSquared:     model.RSquared,
+		Observations: int32(model.Observations),
+		NormalYears:  int32(s.normalYears),
+	}, nil
+}
@@ -0,0 +1,270 @@
+// Package importer bulk-loads time series files, such as historical
+// exports that predate the upstream API, into the repository.
+//
+// Supported formats:
+//   - CSV with a header row, the time and value columns located by name
+//   - InfluxDB line protocol, reading one field of one measurement
+//   - TSZ cold-storage exports, whatever codec they were written with
+//
+// Files are streamed, so memory stays bounded regardless of their size,
+// and stored through the repository's batch insert.
+//
+// Example Usage:
+//
+//	imp := importer.NewImporter(repo, logger)
+//	result, err := imp.Import(ctx, file, importer.Config{
+//	    Format:     importer.FormatCSV,
+//	    TimeColumn: "timestamp",
+//	    TimeFormat: "rfc3339",
+//	})
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/export"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Input formats
+const (
+	FormatCSV  = "csv"
+	FormatLine = "line"
+	FormatTSZ  = "tsz"
+)
+
+// Line protocol timestamp precisions
+const (
+	PrecisionNanoseconds  = "ns"
+	PrecisionMicroseconds = "us"
+	PrecisionMilliseconds = "ms"
+	PrecisionSeconds      = "s"
+)
+
+// Defaults for unset Config fields
+const (
+	DefaultBatchSize        = 5000
+	DefaultProgressInterval = 10 * time.Second
+)
+
+// Config describes the input file. Empty fields take the defaults noted
+// on each.
+type Config struct {
+	// Format is FormatCSV (default), FormatLine or FormatTSZ
+	Format string
+	// TimeColumn is the CSV column holding the timestamp (default "time")
+	TimeColumn string
+	// ValueColumn is the CSV column holding the value (default "value")
+	ValueColumn string
+	// TimeFormat is the CSV timestamp encoding, as for the upstream API:
+	// "unix" (default), "unix_ms" or "rfc3339"
+	TimeFormat string
+	// Measurement restricts line protocol input to one measurement; all
+	// lines are read when empty
+	Measurement string
+	// Field is the line protocol field holding the value (default
+	// "value"). Lines without it are skipped.
+	Field string
+	// Precision is the unit of line protocol timestamps (default ns)
+	Precision string
+	// BatchSize is the number of points inserted per transaction
+	BatchSize int
+	// ProgressInterval is how often progress is logged
+	ProgressInterval time.Duration
+}
+
+// withDefaults fills empty fields
+func (c Config) withDefaults() Config {
+	if c.Format == "" {
+		c.Format = FormatCSV
+	}
+	if c.Field == "" {
+		c.Field = "value"
+	}
+	if c.Precision == "" {
+		c.Precision = PrecisionNanoseconds
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.ProgressInterval == 0 {
+		c.ProgressInterval = DefaultProgressInterval
+	}
+	return c
+}
+
+// Result summarises an import.
+type Result struct {
+	// Points is the number of points stored
+	Points int
+	// Skipped is the number of line protocol lines without the field or
+	// of another measurement
+	Skipped int
+	// First and Last are the earliest and latest timestamps stored
+	First, Last time.Time
+	// Duration is how long the import took
+	Duration time.Duration
+}
+
+// Importer loads files into a repository.
+type Importer struct {
+	repo   database.TimeSeriesRepository
+	logger *logrus.Logger
+	now    func() time.Time
+}
+
+// NewImporter creates an importer storing points in repo.
+func NewImporter(repo database.TimeSeriesRepository, logger *logrus.Logger) *Importer {
+	return &Importer{repo: repo, logger: logger, now: time.Now}
+}
+
+// Import reads r to the end and stores its points in batches. Batches are
+// committed independently; if the input turns out to be malformed part
+// way through, the points before the last committed batch remain stored
+// and are reported in the result alongside the error.
+func (i *Importer) Import(ctx context.Context, r io.Reader, cfg Config) (Result, error) {
+	cfg = cfg.withDefaults()
+	if cfg.BatchSize < 0 {
+		return Result{}, fmt.Errorf("batch size must be positive, got %d", cfg.BatchSize)
+	}
+
+	decode, err := i.decoder(cfg)
+	if err != nil {
+		return Result{}, err
+	}
+
+	started := i.now()
+	run := &run{
+		ctx:      ctx,
+		importer: i,
+		cfg:      cfg,
+		started:  started,
+		reported: started,
+		batch:    make([]models.TimeSeriesData, 0, cfg.BatchSize),
+	}
+
+	err = decode(r, run)
+	if err == nil {
+		err = run.flush()
+	}
+	run.result.Duration = i.now().Sub(started)
+	if err != nil {
+		return run.result, fmt.Errorf("import stopped after %d points: %w", run.result.Points, err)
+	}
+
+	i.logger.WithFields(logrus.Fields{
+		"points":   run.result.Points,
+		"skipped":  run.result.Skipped,
+		"first":    run.result.First,
+		"last":     run.result.Last,
+		"duration": run.result.Duration,
+	}).Info("Import complete")
+	return run.result, nil
+}
+
+// decoder returns the function reading points of the configured format
+func (i *Importer) decoder(cfg Config) (func(io.Reader, *run) error, error) {
+	switch cfg.Format {
+	case FormatCSV:
+		decoder, err := api.NewDecoder(api.DecoderConfig{
+			Format:     api.FormatCSV,
+			TimeField:  cfg.TimeColumn,
+			ValueField: cfg.ValueColumn,
+			TimeFormat: cfg.TimeFormat,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func(r io.Reader, run *run) error {
+			return decoder.Decode(r, run.add)
+		}, nil
+	case FormatLine:
+		parser, err := newLineParser(cfg.Measurement, cfg.Field, cfg.Precision)
+		if err != nil {
+			return nil, err
+		}
+		return func(r io.Reader, run *run) error {
+			return parser.parse(r, run.add, run.skip)
+		}, nil
+	case FormatTSZ:
+		return func(r io.Reader, run *run) error {
+			return export.ReadTSZ(r, func(points []models.TimeSeriesData) error {
+				for _, point := range points {
+					if err := run.add(point); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown import format %q, expected %q, %q or %q", cfg.Format, FormatCSV, FormatLine, FormatTSZ)
+	}
+}
+
+// run is the state of one import
+type run struct {
+	ctx      context.Context
+	importer *Importer
+	cfg      Config
+	batch    []models.TimeSeriesData
+	result   Result
+	started  time.Time
+	reported time.Time
+}
+
+func (r *run) add(point models.TimeSeriesData) error {
+	r.batch = append(r.batch, point)
+	if len(r.batch) == r.cfg.BatchSize {
+		return r.flush()
+	}
+	return nil
+}
+
+func (r *run) skip() {
+	r.result.Skipped++
+}
+
+// flush stores the pending batch and logs progress when due
+func (r *run) flush() error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if err := r.importer.repo.BatchInsertTimeSeriesData(r.ctx, r.batch); err != nil {
+		return err
+	}
+
+	for _, point := range r.batch {
+		if r.result.First.IsZero() || point.Time.Before(r.result.First) {
+			r.result.First = point.Time
+		}
+		if point.Time.After(r.result.Last) {
+			r.result.Last = point.Time
+		}
+	}
+	r.result.Points += len(r.batch)
+	r.batch = r.batch[:0]
+
+	now := r.importer.now()
+	if now.Sub(r.reported) >= r.cfg.ProgressInterval {
+		r.reported = now
+		elapsed := now.Sub(r.started)
+		r.importer.logger.WithFields(logrus.Fields{
+			"points":         r.result.Points,
+			"skipped":        r.result.Skipped,
+			"last":           r.result.Last,
+			"points_per_sec": int(float64(r.result.Points) / elapsed.Seconds()),
+		}).Info("Import progress")
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/codec"
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/export"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// newTestImporter returns an importer recording the size of every batch
+// and the points inserted
+func newTestImporter(t *testing.T) (*Importer, *[]int, *[]models.TimeSeriesData) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	var sizes []int
+	var inserted []models.TimeSeriesData
+	repo := mocks.NewMockTimeSeriesRepository(gomock.NewController(t))
+	repo.EXPECT().
+		BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, data []models.TimeSeriesData) error {
+			sizes = append(sizes, len(data))
+			inserted = append(inserted, data...)
+			return nil
+		}).
+		AnyTimes()
+
+	return NewImporter(repo, logger), &sizes, &inserted
+}
+
+func TestImportCSV(t *testing.T) {
+	imp, sizes, inserted := newTestImporter(t)
+
+	input := "site,timestamp,kwh\n" +
+		"a,2024-11-23T00:00:00Z,1.5\n" +
+		"a,2024-11-23T01:00:00Z,2\n" +
+		"a,2024-11-23T02:00:00Z,3.25\n"
+
+	result, err := imp.Import(context.Background(), strings.NewReader(input), Config{
+		TimeColumn:  "timestamp",
+		ValueColumn: "kwh",
+		TimeFormat:  "rfc3339",
+		BatchSize:   2,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{2, 1}, *sizes)
+	require.Len(t, *inserted, 3)
+	assert.Equal(t, 3.25, (*inserted)[2].Value)
+	assert.Equal(t, 3, result.Points)
+	assert.Equal(t, time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC), result.First.UTC())
+	assert.Equal(t, time.Date(2024, 11, 23, 2, 0, 0, 0, time.UTC), result.Last.UTC())
+}
+
+func TestImportLineProtocol(t *testing.T) {
+	input := strings.Join([]string{
+		"# exported 2024-11-24",
+		"energy,site=plant\\ 7 value=1.5,quality=\"good, checked\" 1732320000000000000",
+		"energy,site=plant\\ 7 value=2i 1732323600000000000",
+		"",
+		"energy,site=plant\\ 7 quality=\"missing\" 1732327200000000000",
+		"weather temperature=4.5 1732320000000000000",
+		"energy value=3u 1732330800000000000",
+	}, "\n")
+
+	imp, _, inserted := newTestImporter(t)
+	result, err := imp.Import(context.Background(), strings.NewReader(input), Config{
+		Format:      FormatLine,
+		Measurement: "energy",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, result.Points)
+	assert.Equal(t, 2, result.Skipped)
+	require.Len(t, *inserted, 3)
+	assert.Equal(t, models.TimeSeriesData{Time: time.Unix(1732320000, 0), Value: 1.5}, (*inserted)[0])
+	assert.Equal(t, 2.0, (*inserted)[1].Value)
+	assert.Equal(t, time.Unix(1732330800, 0), (*inserted)[2].Time)
+
+	t.Run("precision and field", func(t *testing.T) {
+		imp, _, inserted := newTestImporter(t)
+		_, err := imp.Import(context.Background(), strings.NewReader("weather temperature=4.5 1732320000\n"), Config{
+			Format:    FormatLine,
+			Field:     "temperature",
+			Precision: PrecisionSeconds,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []models.TimeSeriesData{{Time: time.Unix(1732320000, 0), Value: 4.5}}, *inserted)
+	})
+}
+
+func TestImportTSZ(t *testing.T) {
+	points := make([]models.TimeSeriesData, 5)
+	for i := range points {
+		points[i] = models.TimeSeriesData{
+			Time:  time.Date(2024, 11, 23, i, 0, 0, 0, time.UTC),
+			Value: float64(i) * 1.5,
+		}
+	}
+	var file bytes.Buffer
+	w := export.NewTSZWriter(&file, codec.Gorilla)
+	require.NoError(t, w.Write(points))
+	require.NoError(t, w.Close())
+
+	imp, sizes, inserted := newTestImporter(t)
+	result, err := imp.Import(context.Background(), &file, Config{Format: FormatTSZ, BatchSize: 2})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{2, 2, 1}, *sizes)
+	assert.Equal(t, points, *inserted)
+	assert.Equal(t, 5, result.Points)
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "unknown format", cfg: Config{Format: "parquet"}, wantErr: "unknown import format"},
+		{name: "unknown precision", cfg: Config{Format: FormatLine, Precision: "h"}, wantErr: "unknown precision"},
+		{name: "unknown time format", cfg: Config{TimeFormat: "excel"}, wantErr: "unknown time format"},
+		{name: "negative batch size", cfg: Config{BatchSize: -1}, wantErr: "batch size"},
+		{name: "missing csv column", input: "time,kwh\n1,2\n", wantErr: `"value" columns`},
+		{name: "missing timestamp", input: "energy value=1\n", cfg: Config{Format: FormatLine}, wantErr: "line 1: expected measurement, fields and timestamp"},
+		{name: "string value", input: "energy value=1 1\nenergy value=\"high\" 2\n", cfg: Config{Format: FormatLine}, wantErr: `line 2: field "value": value "\"high\"" is not numeric`},
+		{name: "bad timestamp", input: "energy value=1 yesterday\n", cfg: Config{Format: FormatLine}, wantErr: "invalid timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imp, _, _ := newTestImporter(t)
+			_, err := imp.Import(context.Background(), strings.NewReader(tt.input), tt.cfg)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("insert failure keeps the earlier batches", func(t *testing.T) {
+		logger := logrus.New()
+		logger.SetLevel(logrus.PanicLevel)
+
+		repo := mocks.NewMockTimeSeriesRepository(gomock.NewController(t))
+		gomock.InOrder(
+			repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(nil),
+			repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(errors.New("connection reset")),
+		)
+
+		input := "time,value\n1,1\n2,2\n3,3\n"
+		result, err := NewImporter(repo, logger).Import(context.Background(), strings.NewReader(input), Config{BatchSize: 2})
+		assert.ErrorContains(t, err, "import stopped after 2 points: connection reset")
+		assert.Equal(t, 2, result.Points)
+	})
+}
+
+func TestImportProgress(t *testing.T) {
+	imp, _, _ := newTestImporter(t)
+
+	logger, hook := logrus.New(), &progressHook{}
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+	imp.logger = logger
+
+	// Every batch advances the clock past the progress interval
+	clock := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	imp.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	_, err := imp.Import(context.Background(), strings.NewReader("time,value\n1,1\n2,2\n3,3\n"), Config{
+		BatchSize:        1,
+		ProgressInterval: time.Second,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, hook.progress)
+}
+
+// progressHook counts progress log entries
+type progressHook struct {
+	progress int
+}
+
+func (h *progressHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *progressHook) Fire(entry *logrus.Entry) error {
+	if entry.Message == "Import progress" {
+		h.progress++
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// maxLineLength bounds a single line protocol line
+const maxLineLength = 1 << 20
+
+// lineParser reads one field of InfluxDB line protocol, in which each line
+// is
+//
+//	measurement[,tag=value...] field=value[,field=value...] timestamp
+//
+// Blank lines and comments starting with # are ignored. Timestamps are
+// required, as imported history cannot be stamped with the time of import.
+type lineParser struct {
+	measurement string
+	field       string
+	unit        time.Duration
+}
+
+func newLineParser(measurement, field, precision string) (*lineParser, error) {
+	units := map[string]time.Duration{
+		PrecisionNanoseconds:  time.Nanosecond,
+		PrecisionMicroseconds: time.Microsecond,
+		PrecisionMilliseconds: time.Millisecond,
+		PrecisionSeconds:      time.Second,
+	}
+	unit, ok := units[precision]
+	if !ok {
+		return nil, fmt.Errorf("unknown precision %q, expected ns, us, ms or s", precision)
+	}
+	return &lineParser{measurement: measurement, field: field, unit: unit}, nil
+}
+
+// parse passes the value of every matching line to emit and reports other
+// lines to skip
+func (p *lineParser) parse(r io.Reader, emit func(models.TimeSeriesData) error, skip func()) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		point, ok, err := p.parseLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+		if !ok {
+			skip()
+			continue
+		}
+		if err := emit(point); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseLine returns the point of a line, or false if the line is of
+// another measurement or lacks the field
+func (p *lineParser) parseLine(line string) (models.TimeSeriesData, bool, error) {
+	sections := splitUnescaped(line, ' ')
+	if len(sections) != 3 {
+		return models.TimeSeriesData{}, false, fmt.Errorf("expected measurement, fields and timestamp, got %d sections", len(sections))
+	}
+
+	series := splitUnescaped(sections[0], ',')
+	if p.measurement != "" && unescape(series[0]) != p.measurement {
+		return models.TimeSeriesData{}, false, nil
+	}
+
+	var raw string
+	found := false
+	for _, field := range splitUnescaped(sections[1], ',') {
+		key, value, ok := cutUnescaped(field, '=')
+		if !ok {
+			return models.TimeSeriesData{}, false, fmt.Errorf("invalid field %q", field)
+		}
+		if unescape(key) == p.field {
+			raw, found = value, true
+			break
+		}
+	}
+	if !found {
+		return models.TimeSeriesData{}, false, nil
+	}
+
+	value, err := parseFieldValue(raw)
+	if err != nil {
+		return models.TimeSeriesData{}, false, fmt.Errorf("field %q: %w", p.field, err)
+	}
+
+	timestamp, err := strconv.ParseInt(sections[2], 10, 64)
+	if err != nil {
+		return models.TimeSeriesData{}, false, fmt.Errorf("invalid timestamp %q", sections[2])
+	}
+
+	return models.TimeSeriesData{
+		Time:  time.Unix(0, 0).Add(time.Duration(timestamp) * p.unit),
+		Value: value,
+	}, true, nil
+}
+
+// parseFieldValue converts a float, integer (1i) or unsigned (1u) field
+// value; strings and booleans cannot be stored
+func parseFieldValue(raw string) (float64, error) {
+	switch {
+	case strings.HasSuffix(raw, "i"):
+		n, err := strconv.ParseInt(strings.TrimSuffix(raw, "i"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", raw)
+		}
+		return float64(n), nil
+	case strings.HasSuffix(raw, "u"):
+		n, err := strconv.ParseUint(strings.TrimSuffix(raw, "u"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		return float64(n), nil
+	default:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value %q is not numeric", raw)
+		}
+		return n, nil
+	}
+}
+
+// splitUnescaped splits s at separators that are neither escaped with a
+// backslash nor inside a double-quoted string
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutUnescaped cuts s at the first unescaped separator
+func cutUnescaped(s string, sep byte) (before, after string, found bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescape removes backslash escapes from a measurement name or key
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
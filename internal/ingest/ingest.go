@@ -0,0 +1,277 @@
+// Package ingest switches ingestion sources on and off at runtime.
+//
+// A misbehaving source, such as a meter feeding garbage through the
+// upstream API, can be isolated without a redeploy. Switch holds whether
+// each source is enabled, persisted in the database so that the state
+// survives restarts and is shared by every replica, and Repository
+// refuses the writes of a disabled source with ErrDisabled. Collection
+// resumes from the time a source is enabled again, unless the data it
+// produced meanwhile is asked to be caught up on.
+//
+// The sources are:
+//   - upstream: the upstream API, collected by the scheduler
+//   - simulation: simulated data collected in place of the upstream API
+//   - import: the import command
+//   - grpc: points inserted through the gRPC service or the HTTP gateway
+//
+// Example Usage:
+//
+//	sources := ingest.NewSwitch(repo, logger)
+//	if err := sources.Load(ctx); err != nil {
+//	    log.Printf("sources stay enabled: %v", err)
+//	}
+//	fetcherRepo := ingest.NewRepository(repo, sources, ingest.SourceUpstream)
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Source names
+const (
+	SourceUpstream   = "upstream"
+	SourceSimulation = "simulation"
+	SourceImport     = "import"
+	SourceGRPC       = "grpc"
+)
+
+// Sources lists the ingestion sources in the order they are reported.
+var Sources = []string{SourceUpstream, SourceSimulation, SourceImport, SourceGRPC}
+
+// RefreshInterval is how often Refresh reloads the states, so that sources
+// switched through another instance are picked up.
+const RefreshInterval = time.Minute
+
+// ErrDisabled is returned for writes of a disabled source.
+var ErrDisabled = errors.New("ingestion source disabled")
+
+// Known reports whether source is an ingestion source.
+func Known(source string) bool {
+	return slices.Contains(Sources, source)
+}
+
+// collected reports whether source is collected from the ingest
+// watermark, so that enabling it again catches up on the data missed
+// while it was disabled unless the watermark is advanced
+func collected(source string) bool {
+	return source == SourceUpstream || source == SourceSimulation
+}
+
+// Store persists the states of the ingestion sources.
+type Store interface {
+	SetIngestSource(ctx context.Context, source models.IngestSource, advanceWatermark bool) (models.IngestSource, error)
+	IngestSources(ctx context.Context) ([]models.IngestSource, error)
+}
+
+// Switch holds whether each ingestion source is enabled. Sources are
+// enabled until disabled with Set.
+type Switch struct {
+	store  Store
+	logger *logrus.Logger
+
+	// writeMu serialises Set and Load, so that states read before a Set
+	// do not replace the state it wrote
+	writeMu sync.Mutex
+	mu      sync.RWMutex
+	states  map[string]models.IngestSource
+}
+
+// NewSwitch creates a switch persisting its states in store, with every
+// source enabled until Load reads the stored states.
+func NewSwitch(store Store, logger *logrus.Logger) *Switch {
+	return &Switch{
+		store:  store,
+		logger: logger,
+		states: make(map[string]models.IngestSource),
+	}
+}
+
+// Load reads the stored states in place of the current ones, logging the
+// sources whose state changed.
+func (s *Switch) Load(ctx context.Context) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	stored, err := s.store.IngestSources(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read ingestion sources: %w", err)
+	}
+
+	states := make(map[string]models.IngestSource, len(stored))
+	for _, state := range stored {
+		if Known(state.Name) {
+			states[state.Name] = state
+		}
+	}
+
+	s.mu.Lock()
+	previous := s.states
+	s.states = states
+	s.mu.Unlock()
+
+	for _, source := range Sources {
+		if enabledIn(states, source) != enabledIn(previous, source) {
+			s.logChange(stateIn(states, source))
+		}
+	}
+	return nil
+}
+
+// Refresh reloads the states every interval until ctx is done, logging
+// failures.
+func (s *Switch) Refresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.Load(ctx); err != nil && ctx.Err() == nil {
+			s.logger.WithError(err).Warn("Failed to reload ingestion sources")
+		}
+	}
+}
+
+// Enabled reports whether data from source is accepted.
+func (s *Switch) Enabled(source string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return enabledIn(s.states, source)
+}
+
+// Set enables or disables source for the reason given, persisting the
+// state before it takes effect, and returns the new state. Enabling a
+// disabled source the scheduler collects from advances the ingest
+// watermark to the time it is enabled, so that the data it produced while
+// isolated is not ingested, unless catchUp is set.
+func (s *Switch) Set(ctx context.Context, source string, enabled bool, reason string, catchUp bool) (models.IngestSource, error) {
+	if !Known(source) {
+		return models.IngestSource{}, fmt.Errorf("unknown ingestion source %q", source)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	state, err := s.store.SetIngestSource(ctx, models.IngestSource{
+		Name:    source,
+		Enabled: enabled,
+		Reason:  reason,
+	}, collected(source) && !catchUp)
+	if err != nil {
+		return models.IngestSource{}, err
+	}
+
+	s.mu.Lock()
+	changed := enabledIn(s.states, source) != enabled
+	s.states[source] = state
+	s.mu.Unlock()
+
+	if changed {
+		s.logChange(state)
+	}
+	return state, nil
+}
+
+// States returns the state of every source, in the order of Sources.
+func (s *Switch) States() []models.IngestSource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := make([]models.IngestSource, len(Sources))
+	for i, source := range Sources {
+		states[i] = stateIn(s.states, source)
+	}
+	return states
+}
+
+// stateIn returns the state of source in states, enabled if it has none
+func stateIn(states map[string]models.IngestSource, source string) models.IngestSource {
+	if state, ok := states[source]; ok {
+		return state
+	}
+	return models.IngestSource{Name: source, Enabled: true}
+}
+
+// logChange logs that a source was switched to state
+func (s *Switch) logChange(state models.IngestSource) {
+	entry := s.logger.WithFields(logrus.Fields{
+		"source": state.Name,
+		"reason": state.Reason,
+	})
+	if state.Enabled {
+		entry.Info("Ingestion source enabled")
+	} else {
+		entry.Warn("Ingestion source disabled")
+	}
+}
+
+// enabledIn reports whether source is enabled in states, which enables
+// sources it has no state for
+func enabledIn(states map[string]models.IngestSource, source string) bool {
+	state, ok := states[source]
+	return !ok || state.Enabled
+}
+
+// Repository decorates the TimeSeriesRepository of a single ingestion
+// source so that its writes fail with ErrDisabled while it is disabled.
+type Repository struct {
+	database.TimeSeriesRepository
+	sources *Switch
+	source  string
+}
+
+// NewRepository wraps repo to refuse the writes of source while sources
+// has it disabled.
+func NewRepository(repo database.TimeSeriesRepository, sources *Switch, source string) *Repository {
+	return &Repository{
+		TimeSeriesRepository: repo,
+		sources:              sources,
+		source:               source,
+	}
+}
+
+// InsertTimeSeriesData inserts a single point if the source is enabled.
+//
+// Deprecated: Use InsertTimeSeriesDataContext.
+func (r *Repository) InsertTimeSeriesData(timestamp time.Time, value float64) error {
+	return r.InsertTimeSeriesDataContext(context.Background(), timestamp, value)
+}
+
+// InsertTimeSeriesDataContext inserts a single point if the source is
+// enabled.
+func (r *Repository) InsertTimeSeriesDataContext(ctx context.Context, timestamp time.Time, value float64) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return r.TimeSeriesRepository.InsertTimeSeriesDataContext(ctx, timestamp, value)
+}
+
+// BatchInsertTimeSeriesData inserts a batch if the source is enabled.
+func (r *Repository) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return r.TimeSeriesRepository.BatchInsertTimeSeriesData(ctx, data)
+}
+
+// check returns ErrDisabled, naming the source, while it is disabled
+func (r *Repository) check() error {
+	if !r.sources.Enabled(r.source) {
+		return fmt.Errorf("%s: %w", r.source, ErrDisabled)
+	}
+	return nil
+}
+
+// Compile-time interface implementation check
+var _ database.TimeSeriesRepository = (*Repository)(nil)
@@ -0,0 +1,145 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestSwitch(t *testing.T) {
+	ctx := context.Background()
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("sources are enabled by default", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		sources := NewSwitch(mocks.NewMockTimeSeriesRepository(ctrl), logrus.New())
+
+		for _, state := range sources.States() {
+			assert.True(t, state.Enabled, state.Name)
+		}
+		assert.True(t, sources.Enabled(SourceUpstream))
+	})
+
+	t.Run("set persists the state", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := mocks.NewMockTimeSeriesRepository(ctrl)
+		sources := NewSwitch(store, logrus.New())
+
+		store.EXPECT().
+			SetIngestSource(ctx, models.IngestSource{Name: SourceGRPC, Reason: "bad meter"}, false).
+			Return(models.IngestSource{Name: SourceGRPC, Reason: "bad meter", UpdatedAt: updated}, nil)
+		state, err := sources.Set(ctx, SourceGRPC, false, "bad meter", false)
+		require.NoError(t, err)
+		assert.Equal(t, updated, state.UpdatedAt)
+		assert.False(t, sources.Enabled(SourceGRPC))
+		assert.True(t, sources.Enabled(SourceUpstream))
+		assert.Equal(t, state, sources.States()[3])
+
+		_, err = sources.Set(ctx, "mqtt", false, "", false)
+		assert.ErrorContains(t, err, "unknown ingestion source")
+	})
+
+	t.Run("failed writes leave the state", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := mocks.NewMockTimeSeriesRepository(ctrl)
+		sources := NewSwitch(store, logrus.New())
+
+		store.EXPECT().SetIngestSource(ctx, gomock.Any(), true).Return(models.IngestSource{}, errors.New("connection refused"))
+		_, err := sources.Set(ctx, SourceUpstream, false, "", false)
+		assert.Error(t, err)
+		assert.True(t, sources.Enabled(SourceUpstream))
+	})
+
+	t.Run("collected sources resume from the time they are enabled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := mocks.NewMockTimeSeriesRepository(ctrl)
+		sources := NewSwitch(store, logrus.New())
+
+		store.EXPECT().SetIngestSource(ctx, models.IngestSource{Name: SourceUpstream, Enabled: true}, true).
+			Return(models.IngestSource{Name: SourceUpstream, Enabled: true}, nil)
+		_, err := sources.Set(ctx, SourceUpstream, true, "", false)
+		require.NoError(t, err)
+
+		store.EXPECT().SetIngestSource(ctx, models.IngestSource{Name: SourceSimulation, Enabled: true}, false).
+			Return(models.IngestSource{Name: SourceSimulation, Enabled: true}, nil)
+		_, err = sources.Set(ctx, SourceSimulation, true, "", true)
+		require.NoError(t, err)
+	})
+
+	t.Run("load does not replace a concurrent set", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := mocks.NewMockTimeSeriesRepository(ctrl)
+		sources := NewSwitch(store, logrus.New())
+
+		reading := make(chan struct{})
+		read := make(chan struct{})
+		store.EXPECT().IngestSources(ctx).DoAndReturn(func(context.Context) ([]models.IngestSource, error) {
+			close(reading)
+			<-read
+			return nil, nil
+		})
+		store.EXPECT().SetIngestSource(ctx, gomock.Any(), gomock.Any()).
+			Return(models.IngestSource{Name: SourceGRPC}, nil)
+
+		loaded := make(chan error)
+		go func() { loaded <- sources.Load(ctx) }()
+		<-reading
+		set := make(chan error)
+		go func() {
+			_, err := sources.Set(ctx, SourceGRPC, false, "", false)
+			set <- err
+		}()
+		close(read)
+		require.NoError(t, <-loaded)
+		require.NoError(t, <-set)
+		assert.False(t, sources.Enabled(SourceGRPC), "the state read before the set is not applied after it")
+	})
+
+	t.Run("load replaces the states", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := mocks.NewMockTimeSeriesRepository(ctrl)
+		sources := NewSwitch(store, logrus.New())
+
+		store.EXPECT().IngestSources(ctx).Return([]models.IngestSource{
+			{Name: SourceImport, Enabled: false},
+			{Name: "removed", Enabled: false},
+		}, nil)
+		require.NoError(t, sources.Load(ctx))
+		assert.False(t, sources.Enabled(SourceImport))
+		assert.Len(t, sources.States(), len(Sources))
+
+		store.EXPECT().IngestSources(ctx).Return([]models.IngestSource{{Name: SourceImport, Enabled: true}}, nil)
+		require.NoError(t, sources.Load(ctx))
+		assert.True(t, sources.Enabled(SourceImport))
+	})
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	store := mocks.NewMockTimeSeriesRepository(ctrl)
+	sources := NewSwitch(store, logrus.New())
+	repo := NewRepository(store, sources, SourceUpstream)
+	data := []models.TimeSeriesData{{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Value: 1}}
+
+	store.EXPECT().BatchInsertTimeSeriesData(ctx, data).Return(nil)
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, data))
+
+	store.EXPECT().SetIngestSource(ctx, gomock.Any(), gomock.Any()).Return(models.IngestSource{Name: SourceUpstream}, nil)
+	_, err := sources.Set(ctx, SourceUpstream, false, "", false)
+	require.NoError(t, err)
+
+	err = repo.BatchInsertTimeSeriesData(ctx, data)
+	assert.ErrorIs(t, err, ErrDisabled)
+	assert.ErrorContains(t, err, "upstream")
+	assert.ErrorIs(t, repo.InsertTimeSeriesDataContext(ctx, data[0].Time, 1), ErrDisabled)
+}
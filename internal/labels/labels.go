@@ -0,0 +1,306 @@
+// Package labels selects series by their labels, the key-value tags such
+// as site=plant1 or phase=A that series carry, with selectors in the
+// syntax of PromQL.
+//
+// A selector is an optional series name followed by matchers in braces,
+// each a label name, an operator and a quoted value:
+//   - =  selects labels equal to the value
+//   - != selects labels not equal to the value
+//   - =~ selects labels matching the regular expression
+//   - !~ selects labels not matching the regular expression
+//
+// Regular expressions use the RE2 syntax and must match the whole value.
+// A series lacking a label is treated as carrying it with an empty value,
+// and the series name is matched as the label __name__. As in PromQL, a
+// selector needs at least one matcher that does not match empty values,
+// so that it cannot select every series.
+//
+// Example Usage:
+//
+//	matchers, err := labels.ParseSelector(`net_load{site="plant1",phase=~"A|B"}`)
+//	if err != nil {
+//	    return err
+//	}
+//	if labels.MatchSeries(matchers, "net_load", map[string]string{"site": "plant1", "phase": "A"}) {
+//	    ...
+//	}
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NameLabel is the label the series name is matched as.
+const NameLabel = "__name__"
+
+// MatchType is the operator of a Matcher.
+type MatchType int
+
+// Operators of matchers
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	}
+	return fmt.Sprintf("MatchType(%d)", int(t))
+}
+
+// Matcher selects the series whose label Name compares to Value as Type
+// requires.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates a matcher, compiling the value of regular expression
+// matchers.
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	if !ValidName(name) && name != NameLabel {
+		return nil, fmt.Errorf("invalid label name %q", name)
+	}
+	m := &Matcher{Type: t, Name: name, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q of label %s: %v", value, name, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("invalid match type %d", int(t))
+	}
+	return m, nil
+}
+
+// Matches reports whether value, the value of the matcher's label or the
+// empty string when the label is missing, satisfies the matcher.
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+func (m *Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
+
+// ValidName reports whether name may be used as a label: a letter or
+// underscore followed by letters, digits and underscores, not starting
+// with two underscores, which are reserved for labels such as NameLabel.
+func ValidName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, c := range name {
+		if !isNameChar(c, i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns an error if the name of a label is invalid.
+func Validate(tags map[string]string) error {
+	for name := range tags {
+		if !ValidName(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// MatchSeries reports whether the series name carrying tags satisfies
+// every matcher.
+func MatchSeries(matchers []*Matcher, name string, tags map[string]string) bool {
+	for _, m := range matchers {
+		value := tags[m.Name]
+		if m.Name == NameLabel {
+			value = name
+		}
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses a selector such as `net_load{site="plant1"}`,
+// `{phase=~"A|B"}` or `net_load`, returning its matchers, those of the
+// braces preceded by one matching the series name, if given.
+func ParseSelector(selector string) ([]*Matcher, error) {
+	p := &parser{input: selector}
+	matchers, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+	}
+	for _, m := range matchers {
+		if !m.Matches("") {
+			return matchers, nil
+		}
+	}
+	return nil, fmt.Errorf("selector %q must contain a matcher that does not match empty values", selector)
+}
+
+// parser reads a selector
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) parse() ([]*Matcher, error) {
+	var matchers []*Matcher
+	p.skipSpace()
+	if name := p.name(); name != "" {
+		m, err := NewMatcher(MatchEqual, NameLabel, name)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+		p.skipSpace()
+	}
+	if p.pos == len(p.input) && len(matchers) > 0 {
+		return matchers, nil
+	}
+	if !p.consume("{") {
+		return nil, p.errorf("expected {")
+	}
+	for first := true; ; first = false {
+		p.skipSpace()
+		if p.consume("}") {
+			break
+		}
+		if !first {
+			if !p.consume(",") {
+				return nil, p.errorf("expected , or }")
+			}
+			p.skipSpace()
+			// A trailing comma is allowed
+			if p.consume("}") {
+				break
+			}
+		}
+		m, err := p.matcher()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	p.skipSpace()
+	if p.pos != len(p.input) {
+		return nil, p.errorf("unexpected %q after }", p.input[p.pos:])
+	}
+	return matchers, nil
+}
+
+// matcher reads a label name, an operator and a quoted value
+func (p *parser) matcher() (*Matcher, error) {
+	name := p.name()
+	if name == "" {
+		return nil, p.errorf("expected label name")
+	}
+	p.skipSpace()
+	var t MatchType
+	switch {
+	case p.consume("=~"):
+		t = MatchRegexp
+	case p.consume("!~"):
+		t = MatchNotRegexp
+	case p.consume("!="):
+		t = MatchNotEqual
+	case p.consume("="):
+		t = MatchEqual
+	default:
+		return nil, p.errorf("expected =, !=, =~ or !~ after %s", name)
+	}
+	p.skipSpace()
+	value, err := p.quoted()
+	if err != nil {
+		return nil, err
+	}
+	return NewMatcher(t, name, value)
+}
+
+// name reads a label or series name, returning "" if there is none
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.input) && isNameChar(rune(p.input[p.pos]), p.pos == start) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// quoted reads a string in double quotes, with Go escapes, or in back
+// quotes
+func (p *parser) quoted() (string, error) {
+	if p.pos == len(p.input) || (p.input[p.pos] != '"' && p.input[p.pos] != '`') {
+		return "", p.errorf("expected quoted value")
+	}
+	quote := p.input[p.pos]
+	end := p.pos + 1
+	for ; end < len(p.input) && p.input[end] != quote; end++ {
+		if p.input[end] == '\\' && quote != '`' {
+			end++
+		}
+	}
+	if end >= len(p.input) {
+		return "", p.errorf("unterminated value")
+	}
+	raw := p.input[p.pos : end+1]
+	p.pos = end + 1
+	value, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", p.errorf("invalid value %s", raw)
+	}
+	return value, nil
+}
+
+func (p *parser) consume(token string) bool {
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// isNameChar reports whether c may appear in a name, first being whether
+// it would be its first character
+func isNameChar(c rune, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
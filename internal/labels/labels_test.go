@@ -0,0 +1,84 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected []string
+	}{
+		{`net_load`, []string{`__name__="net_load"`}},
+		{`{site="plant1"}`, []string{`site="plant1"`}},
+		{`net_load{site="plant1", phase=~"A|B"}`, []string{`__name__="net_load"`, `site="plant1"`, `phase=~"A|B"`}},
+		{` { site != "" , env!~` + "`test.*`" + `, } `, []string{`site!=""`, `env!~"test.*"`}},
+		{`{__name__=~"net_.*"}`, []string{`__name__=~"net_.*"`}},
+		{`{site="plant \"1\""}`, []string{`site="plant \"1\""`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			matchers, err := ParseSelector(tt.selector)
+			require.NoError(t, err)
+			var actual []string
+			for _, m := range matchers {
+				actual = append(actual, m.String())
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+
+	for selector, message := range map[string]string{
+		``:                     "expected {",
+		`{}`:                   "must contain a matcher that does not match empty values",
+		`{site=""}`:            "must contain a matcher that does not match empty values",
+		`{site=~".*"}`:         "must contain a matcher that does not match empty values",
+		`{site="a" phase="b"}`: "expected , or }",
+		`{site="a"`:            "expected , or }",
+		`{site:"a"}`:           "expected =, !=, =~ or !~ after site",
+		`{site=a}`:             "expected quoted value",
+		`{site="a}`:            "unterminated value",
+		`{site=~"("}`:          "invalid regular expression",
+		`{__site="a"}`:         `invalid label name "__site"`,
+		`net_load{site="a"}x`:  `unexpected "x" after }`,
+	} {
+		_, err := ParseSelector(selector)
+		assert.ErrorContains(t, err, message, selector)
+	}
+}
+
+func TestMatchSeries(t *testing.T) {
+	tags := map[string]string{"site": "plant1", "phase": "A"}
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{`{site="plant1"}`, true},
+		{`{site="plant2"}`, false},
+		{`{site="plant1",phase!="B"}`, true},
+		{`{phase=~"A|B"}`, true},
+		{`{phase=~"B|C"}`, false},
+		{`{phase=~"A.+"}`, false},
+		{`{site="plant1",phase!~"A"}`, false},
+		{`{site="plant1",feeder=""}`, true},
+		{`{site="plant1",feeder!=""}`, false},
+		{`net_load{site="plant1"}`, true},
+		{`default{site="plant1"}`, false},
+		{`{__name__=~"net_.*"}`, true},
+	}
+	for _, tt := range tests {
+		matchers, err := ParseSelector(tt.selector)
+		require.NoError(t, err, tt.selector)
+		assert.Equal(t, tt.matches, MatchSeries(matchers, "net_load", tags), tt.selector)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(map[string]string{"site": "plant1", "_phase2": "A"}))
+	assert.ErrorContains(t, Validate(map[string]string{"2phase": "A"}), `invalid label name "2phase"`)
+	assert.ErrorContains(t, Validate(map[string]string{"__name__": "x"}), "invalid label name")
+	assert.ErrorContains(t, Validate(map[string]string{"site-id": "x"}), "invalid label name")
+}
@@ -0,0 +1,330 @@
+// Package lifecycle starts the components of the service in dependency
+// order, runs them until the service is asked to stop or one of them
+// fails, and then stops them in reverse order.
+//
+// A Group replaces hand-rolled goroutines and error channels:
+//   - Components start in the order they were added, after the components
+//     they depend on
+//   - Long-running work, such as serving a listener, runs in a goroutine
+//     owned by the group; a component failing stops the whole group
+//   - Components stop in reverse start order, so a server stops taking
+//     requests before the queue and database it writes to are closed
+//   - Stopping shares a single deadline, which a component may narrow with
+//     a timeout of its own; every component is stopped even if an earlier
+//     one fails or the deadline passes
+//   - Start, run and stop errors are returned joined
+//   - Components with a RestartPolicy are restarted after their work fails
+//     or panics, with backoff, until they fail too often
+//
+// Example Usage:
+//
+//	group := lifecycle.NewGroup(25*time.Second, logger)
+//	group.Add(lifecycle.Component{
+//	    Name: "repository",
+//	    Stop: func(context.Context) error { return repo.Close() },
+//	})
+//	group.Add(lifecycle.Component{
+//	    Name:      "grpc server",
+//	    DependsOn: []string{"repository"},
+//	    Run:       func(context.Context) error { return srv.Serve(lis) },
+//	    Stop:      func(ctx context.Context) error { srv.GracefulStop(); return nil },
+//	})
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+//	defer stop()
+//	if err := group.Run(ctx); err != nil {
+//	    logger.WithError(err).Fatal("Service stopped with errors")
+//	}
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout leaves a margin within Kubernetes' default 30 second
+// termination grace period.
+const DefaultTimeout = 25 * time.Second
+
+// Component is a part of the service with a lifecycle. Every function is
+// optional.
+type Component struct {
+	// Name identifies the component in logs, errors, DependsOn and
+	// SetStopTimeouts; it must be unique within a group
+	Name string
+	// DependsOn names components that must start before this one and
+	// stop after it
+	DependsOn []string
+
+	// Start prepares the component, and must return once it has started.
+	// A failing Start stops the components started before it.
+	Start func(ctx context.Context) error
+	// Run does the component's work until ctx is done, which happens when
+	// the component is stopped. Returning nil ends the component's work;
+	// returning an error or panicking stops the group, unless Restart is
+	// set.
+	Run func(ctx context.Context) error
+	// Stop releases the component, returning once done or once ctx is
+	// done. Run's context is cancelled first.
+	Stop func(ctx context.Context) error
+	// StopTimeout bounds Stop and the return of Run, within the group's
+	// deadline; only the group's deadline applies when zero
+	StopTimeout time.Duration
+	// Restart, when set, restarts Run after it fails or panics instead of
+	// stopping the group straight away. It must be valid.
+	Restart *RestartPolicy
+}
+
+// Group runs components from start to stop.
+type Group struct {
+	timeout    time.Duration
+	logger     *logrus.Logger
+	components []Component
+	metrics    *metrics
+}
+
+// NewGroup creates a group whose components must stop within timeout in
+// total.
+func NewGroup(timeout time.Duration, logger *logrus.Logger) *Group {
+	return &Group{
+		timeout: timeout,
+		logger:  logger,
+	}
+}
+
+// Add appends a component. Components without dependencies between them
+// start in the order they are added.
+func (g *Group) Add(c Component) {
+	g.components = append(g.components, c)
+}
+
+// SetStopTimeouts overrides the stop timeouts of the named components. It
+// must be called after the components are added.
+func (g *Group) SetStopTimeouts(timeouts map[string]time.Duration) error {
+	for name, timeout := range timeouts {
+		if timeout <= 0 {
+			return fmt.Errorf("stop timeout of %s must be positive", name)
+		}
+		i := g.index(name)
+		if i < 0 {
+			return fmt.Errorf("unknown component %q, expected one of %s", name, strings.Join(g.names(), ", "))
+		}
+		g.components[i].StopTimeout = timeout
+	}
+	return nil
+}
+
+// index returns the position of the named component, or -1
+func (g *Group) index(name string) int {
+	for i, c := range g.components {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// names returns the sorted names of the components
+func (g *Group) names() []string {
+	names := make([]string, len(g.components))
+	for i, c := range g.components {
+		names[i] = c.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// order returns the components in start order: each after its
+// dependencies, otherwise in the order they were added
+func (g *Group) order() ([]Component, error) {
+	for i, c := range g.components {
+		if c.Name == "" {
+			return nil, fmt.Errorf("component %d has no name", i)
+		}
+		if g.index(c.Name) != i {
+			return nil, fmt.Errorf("component %s is added twice", c.Name)
+		}
+		for _, dep := range c.DependsOn {
+			if g.index(dep) < 0 {
+				return nil, fmt.Errorf("component %s depends on unknown component %q", c.Name, dep)
+			}
+		}
+		if c.Restart != nil {
+			if err := c.Restart.Validate(); err != nil {
+				return nil, fmt.Errorf("component %s: %w", c.Name, err)
+			}
+		}
+	}
+
+	placed := make(map[string]bool, len(g.components))
+	ordered := make([]Component, 0, len(g.components))
+	for len(ordered) < len(g.components) {
+		progress := false
+		for _, c := range g.components {
+			if placed[c.Name] || !dependenciesPlaced(c, placed) {
+				continue
+			}
+			placed[c.Name] = true
+			ordered = append(ordered, c)
+			progress = true
+			break
+		}
+		if !progress {
+			var cyclic []string
+			for _, c := range g.components {
+				if !placed[c.Name] {
+					cyclic = append(cyclic, c.Name)
+				}
+			}
+			return nil, fmt.Errorf("components %s depend on each other", strings.Join(cyclic, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// dependenciesPlaced reports whether every dependency of c is placed
+func dependenciesPlaced(c Component, placed map[string]bool) bool {
+	for _, dep := range c.DependsOn {
+		if !placed[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// started is a component that has started
+type started struct {
+	Component
+	// cancel cancels the context of Run
+	cancel context.CancelFunc
+	// done is closed once Run has returned, with its error in err
+	done chan struct{}
+	err  error
+	// stopping is set once the component is being stopped, after which
+	// Run returning does not stop the group
+	stopping atomic.Bool
+}
+
+// Run starts the components, waits until ctx is done or a component fails,
+// and stops the started components in reverse order. It returns the
+// errors of failed components, joined, or nil after a clean stop. Nothing
+// is started when the components' dependencies cannot be ordered.
+func (g *Group) Run(ctx context.Context) error {
+	ordered, err := g.order()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	var running []*started
+	// failed receives the first component whose Run fails
+	failed := make(chan *started, 1)
+
+	for _, c := range ordered {
+		logger := g.logger.WithField("component", c.Name)
+		if c.Start != nil {
+			if err := c.Start(ctx); err != nil {
+				logger.WithError(err).Error("Failed to start")
+				errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+				break
+			}
+		}
+
+		s := &started{Component: c, done: make(chan struct{})}
+		running = append(running, s)
+		if c.Run == nil {
+			close(s.done)
+			continue
+		}
+		// Run outlives ctx, until the component is stopped
+		var runCtx context.Context
+		runCtx, s.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		go func() {
+			s.err = g.supervise(runCtx, c)
+			close(s.done)
+			if s.err != nil && !s.stopping.Load() {
+				select {
+				case failed <- s:
+				default:
+				}
+			}
+		}()
+	}
+
+	if len(errs) == 0 {
+		g.logger.WithField("components", len(running)).Info("All components started")
+		select {
+		case <-ctx.Done():
+			g.logger.Info("Stop requested, shutting down")
+		case s := <-failed:
+			g.logger.WithField("component", s.Name).WithError(s.err).Error("Component failed, shutting down")
+		}
+	}
+
+	return errors.Join(append(errs, g.stop(running)...)...)
+}
+
+// stop stops the components in reverse order within the group's timeout
+func (g *Group) stop(running []*started) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(running) - 1; i >= 0; i-- {
+		s := running[i]
+		if err := g.stopOne(ctx, s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+		}
+	}
+	return errs
+}
+
+// stopOne cancels the component's Run, calls its Stop and waits for Run
+// to return, and returns the first error of either
+func (g *Group) stopOne(ctx context.Context, s *started) error {
+	if s.StopTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.StopTimeout)
+		defer cancel()
+	}
+
+	begin := time.Now()
+	logger := g.logger.WithField("component", s.Name)
+	logger.Info("Stopping")
+
+	s.stopping.Store(true)
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	var err error
+	if s.Stop != nil {
+		err = s.Stop(ctx)
+	}
+	select {
+	case <-s.done:
+		// Cancelling Run is how components are stopped, so ending with
+		// the cancellation is not a failure
+		if err == nil && s.err != nil && !errors.Is(s.err, context.Canceled) {
+			err = s.err
+		}
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("did not stop: %w", ctx.Err())
+		}
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Failed to stop cleanly")
+		return err
+	}
+	logger.WithField("duration", time.Since(begin)).Info("Stopped")
+	return nil
+}
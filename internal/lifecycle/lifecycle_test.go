@@ -0,0 +1,259 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder records lifecycle events in order
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+// component records its start and stop, running until stopped
+func (r *recorder) component(name string, dependsOn ...string) Component {
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(context.Context) error {
+			r.record("start " + name)
+			return nil
+		},
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		Stop: func(context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+	}
+}
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	return logger
+}
+
+func TestGroup(t *testing.T) {
+	t.Run("dependency order", func(t *testing.T) {
+		r := &recorder{}
+		group := NewGroup(time.Second, newTestLogger())
+		group.Add(r.component("server", "queue"))
+		group.Add(r.component("repository"))
+		group.Add(r.component("queue", "repository"))
+		group.Add(r.component("tracing"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- group.Run(ctx) }()
+		require.Eventually(t, func() bool { return len(r.get()) == 4 }, time.Second, time.Millisecond)
+		cancel()
+
+		require.NoError(t, <-done, "stopping when asked is clean")
+		assert.Equal(t, []string{
+			"start repository", "start queue", "start server", "start tracing",
+			"stop tracing", "stop server", "stop queue", "stop repository",
+		}, r.get())
+	})
+
+	t.Run("a failing component stops the group", func(t *testing.T) {
+		r := &recorder{}
+		group := NewGroup(time.Second, newTestLogger())
+		group.Add(r.component("repository"))
+		failed := errors.New("address in use")
+		group.Add(Component{
+			Name: "server",
+			Run:  func(context.Context) error { return failed },
+		})
+		// Components whose work ends do not stop the group
+		group.Add(Component{
+			Name: "bootstrap",
+			Run:  func(context.Context) error { return nil },
+		})
+
+		err := group.Run(context.Background())
+		assert.ErrorIs(t, err, failed)
+		assert.ErrorContains(t, err, "server: address in use")
+		assert.Equal(t, []string{"start repository", "stop repository"}, r.get())
+	})
+
+	t.Run("a failing start stops the components started", func(t *testing.T) {
+		r := &recorder{}
+		group := NewGroup(time.Second, newTestLogger())
+		group.Add(r.component("repository"))
+		group.Add(Component{
+			Name:  "scheduler",
+			Start: func(context.Context) error { return errors.New("invalid schedule") },
+		})
+		group.Add(r.component("server"))
+
+		err := group.Run(context.Background())
+		assert.ErrorContains(t, err, "scheduler: invalid schedule")
+		assert.Equal(t, []string{"start repository", "stop repository"}, r.get())
+	})
+
+	t.Run("later components stop after failures and the deadline", func(t *testing.T) {
+		r := &recorder{}
+		group := NewGroup(50*time.Millisecond, newTestLogger())
+		group.Add(r.component("repository"))
+		group.Add(Component{
+			Name: "queue",
+			Stop: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			StopTimeout: 10 * time.Millisecond,
+		})
+		stuck := make(chan struct{})
+		defer close(stuck)
+		group.Add(Component{
+			Name: "server",
+			Run: func(context.Context) error {
+				<-stuck
+				return nil
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := group.Run(ctx)
+		assert.ErrorContains(t, err, "server: did not stop: context deadline exceeded")
+		assert.ErrorContains(t, err, "queue: context deadline exceeded")
+		assert.Equal(t, []string{"start repository", "stop repository"}, r.get())
+	})
+
+	t.Run("invalid dependencies start nothing", func(t *testing.T) {
+		for name, components := range map[string][]Component{
+			"depends on unknown component": {{Name: "server", DependsOn: []string{"repo"}}},
+			"depend on each other": {
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+			},
+			"added twice": {{Name: "a"}, {Name: "a"}},
+		} {
+			started := false
+			group := NewGroup(time.Second, newTestLogger())
+			for _, c := range components {
+				c.Start = func(context.Context) error {
+					started = true
+					return nil
+				}
+				group.Add(c)
+			}
+			assert.ErrorContains(t, group.Run(context.Background()), name)
+			assert.False(t, started)
+		}
+	})
+}
+
+func TestSetStopTimeouts(t *testing.T) {
+	group := NewGroup(time.Second, newTestLogger())
+	group.Add(Component{Name: "grpc server"})
+
+	require.NoError(t, group.SetStopTimeouts(map[string]time.Duration{"grpc server": 10 * time.Second}))
+	assert.Equal(t, 10*time.Second, group.components[0].StopTimeout)
+
+	assert.ErrorContains(t, group.SetStopTimeouts(map[string]time.Duration{"grpc": time.Second}), `unknown component "grpc", expected one of grpc server`)
+	assert.ErrorContains(t, group.SetStopTimeouts(map[string]time.Duration{"grpc server": 0}), "must be positive")
+}
+
+func TestRestart(t *testing.T) {
+	policy := &RestartPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+		MaxRestarts:    2,
+		Window:         time.Minute,
+	}
+
+	t.Run("failed work is restarted", func(t *testing.T) {
+		group := NewGroup(time.Second, newTestLogger())
+		reg := prometheus.NewRegistry()
+		require.NoError(t, group.SetMetrics(reg))
+
+		var runs atomic.Int32
+		group.Add(Component{
+			Name:    "scheduler",
+			Restart: policy,
+			Run: func(ctx context.Context) error {
+				switch runs.Add(1) {
+				case 1:
+					return errors.New("lost connection")
+				case 2:
+					panic("nil map")
+				}
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- group.Run(ctx) }()
+		require.Eventually(t, func() bool { return runs.Load() == 3 }, time.Second, time.Millisecond)
+		assert.Equal(t, 2.0, testutil.ToFloat64(group.metrics.restarts.WithLabelValues("scheduler")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(group.metrics.up.WithLabelValues("scheduler")))
+
+		cancel()
+		require.NoError(t, <-done)
+		assert.Equal(t, 0.0, testutil.ToFloat64(group.metrics.up.WithLabelValues("scheduler")))
+	})
+
+	t.Run("failing too often stops the group", func(t *testing.T) {
+		group := NewGroup(time.Second, newTestLogger())
+		var runs atomic.Int32
+		group.Add(Component{
+			Name:    "detector",
+			Restart: policy,
+			Run: func(context.Context) error {
+				runs.Add(1)
+				return errors.New("lost subscription")
+			},
+		})
+
+		err := group.Run(context.Background())
+		assert.ErrorContains(t, err, "detector: gave up after 2 restarts within 1m0s: lost subscription")
+		assert.Equal(t, int32(3), runs.Load())
+	})
+
+	t.Run("panics stop unsupervised components", func(t *testing.T) {
+		group := NewGroup(time.Second, newTestLogger())
+		group.Add(Component{
+			Name: "secrets",
+			Run:  func(context.Context) error { panic("nil map") },
+		})
+		assert.ErrorContains(t, group.Run(context.Background()), "secrets: panic: nil map")
+	})
+
+	t.Run("invalid policies start nothing", func(t *testing.T) {
+		group := NewGroup(time.Second, newTestLogger())
+		group.Add(Component{Name: "scheduler", Restart: &RestartPolicy{}})
+		assert.ErrorContains(t, group.Run(context.Background()), "component scheduler: restart backoff and window must be positive")
+	})
+
+	assert.NoError(t, DefaultRestartPolicy().Validate())
+}
@@ -0,0 +1,146 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// RestartPolicy controls how a component whose Run fails is restarted.
+// Once a component has been restarted MaxRestarts times within Window,
+// the circuit opens: it is not restarted again and its failure stops the
+// group, so the process exits instead of running without it.
+type RestartPolicy struct {
+	// InitialBackoff is the delay before the first restart
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay, which doubles after each restart until
+	// no restart is left within Window
+	MaxBackoff time.Duration
+	// MaxRestarts is the number of restarts allowed within Window
+	MaxRestarts int
+	// Window is the period restarts are counted over
+	Window time.Duration
+}
+
+// DefaultRestartPolicy returns a RestartPolicy allowing 5 restarts in 10
+// minutes, waiting from a second up to a minute in between.
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		MaxRestarts:    5,
+		Window:         10 * time.Minute,
+	}
+}
+
+// Validate checks that the policy is usable.
+func (p RestartPolicy) Validate() error {
+	if p.InitialBackoff <= 0 || p.Window <= 0 {
+		return fmt.Errorf("restart backoff and window must be positive")
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		return fmt.Errorf("restart max backoff must not be less than the initial backoff")
+	}
+	if p.MaxRestarts < 1 {
+		return fmt.Errorf("restart max restarts must be at least 1")
+	}
+	return nil
+}
+
+// metrics reports the state of supervised components
+type metrics struct {
+	restarts *prometheus.CounterVec
+	up       *prometheus.GaugeVec
+}
+
+// SetMetrics registers metrics of the components' restarts and whether
+// they are running with reg. It must be called before Run.
+func (g *Group) SetMetrics(reg prometheus.Registerer) error {
+	m := &metrics{
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "edgecom_component_restarts_total",
+			Help: "Restarts of components whose work failed",
+		}, []string{"component"}),
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "edgecom_component_up",
+			Help: "Whether a component's work is running (1) or not (0)",
+		}, []string{"component"}),
+	}
+	for _, c := range []prometheus.Collector{m.restarts, m.up} {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register component metric: %v", err)
+		}
+	}
+	g.metrics = m
+	return nil
+}
+
+// supervise calls Run until ctx is done or Run ends, restarting it after
+// failures as the component's policy allows. Panics are returned as
+// errors.
+func (g *Group) supervise(ctx context.Context, c Component) error {
+	logger := g.logger.WithField("component", c.Name)
+
+	var policy RestartPolicy
+	if c.Restart != nil {
+		policy = *c.Restart
+	}
+	backoff := policy.InitialBackoff
+	// restarts holds the times of the restarts within the window
+	var restarts []time.Time
+
+	for {
+		err := g.runOnce(ctx, c)
+		if err == nil || ctx.Err() != nil || c.Restart == nil {
+			return err
+		}
+
+		now := time.Now()
+		for len(restarts) > 0 && now.Sub(restarts[0]) > policy.Window {
+			restarts = restarts[1:]
+		}
+		if len(restarts) == 0 {
+			backoff = policy.InitialBackoff
+		}
+		if len(restarts) >= policy.MaxRestarts {
+			return fmt.Errorf("gave up after %d restarts within %s: %w", len(restarts), policy.Window, err)
+		}
+		restarts = append(restarts, now)
+		if g.metrics != nil {
+			g.metrics.restarts.WithLabelValues(c.Name).Inc()
+		}
+
+		logger.WithError(err).WithFields(logrus.Fields{
+			"backoff":  backoff,
+			"restarts": len(restarts),
+		}).Warn("Component failed, restarting")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, policy.MaxBackoff)
+	}
+}
+
+// runOnce calls the component's Run, returning a panic as an error
+func (g *Group) runOnce(ctx context.Context, c Component) (err error) {
+	if g.metrics != nil {
+		up := g.metrics.up.WithLabelValues(c.Name)
+		up.Set(1)
+		defer up.Set(0)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			g.logger.WithField("component", c.Name).WithField("stack", string(debug.Stack())).Error("Component panicked")
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.Run(ctx)
+}
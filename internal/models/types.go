@@ -19,12 +19,15 @@ import "time"
 //	  ]
 //	}
 type APIResponse struct {
-	Result []struct {
-		// Time is a Unix timestamp representing the measurement time
-		Time int64 `json:"time"`
-		// Value is the measurement value
-		Value float64 `json:"value"`
-	} `json:"result"`
+	Result []APIDataPoint `json:"result"`
+}
+
+// APIDataPoint is a single element of the API's result array.
+type APIDataPoint struct {
+	// Time is a Unix timestamp representing the measurement time
+	Time int64 `json:"time"`
+	// Value is the measurement value
+	Value float64 `json:"value"`
 }
 
 // TimeSeriesData represents a single time series data point in the internal format.
@@ -40,4 +43,184 @@ type TimeSeriesData struct {
 	Time time.Time `json:"time"`
 	// Value is the measurement value
 	Value float64 `json:"value"`
+	// Count is the number of samples aggregated into the point, or zero
+	// for a stored sample
+	Count int64 `json:"count,omitempty"`
+}
+
+// Gap is a time range that could not be ingested from the upstream API and
+// is waiting to be fetched again.
+type Gap struct {
+	// ID identifies the recorded gap
+	ID int64 `json:"id"`
+	// Start is the beginning of the missing range
+	Start time.Time `json:"start"`
+	// End is the end of the missing range
+	End time.Time `json:"end"`
+	// Reason describes why the range was not ingested
+	Reason string `json:"reason"`
+	// CreatedAt is when the gap was recorded
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SeriesDescription describes a series to the clients discovering it.
+type SeriesDescription struct {
+	// Unit is the unit of the series' values, for display
+	Unit string `json:"unit,omitempty"`
+	// Description explains the series to dashboard users
+	Description string `json:"description,omitempty"`
+	// Tags are labels, such as site or phase, that label selectors choose
+	// the series by
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// SeriesMetadata summarises the stored samples of a series. It is updated
+// in the same transaction as every insert, so it always agrees with the
+// stored data.
+type SeriesMetadata struct {
+	// Series names the series
+	Series string `json:"series"`
+	SeriesDescription
+	// FirstTime is the earliest stored timestamp, zero if there is no data
+	FirstTime time.Time `json:"first_time"`
+	// LastTime is the latest stored timestamp, zero if there is no data
+	LastTime time.Time `json:"last_time"`
+	// PointCount is the number of stored samples
+	PointCount int64 `json:"point_count"`
+	// UpdatedAt is when the metadata last changed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Statistics summarises the stored samples in a time range.
+type Statistics struct {
+	// Count is the number of samples in the range; the other fields are
+	// zero when it is
+	Count int64 `json:"count"`
+	// Min is the smallest sample value
+	Min float64 `json:"min"`
+	// Max is the largest sample value
+	Max float64 `json:"max"`
+	// Avg is the mean sample value
+	Avg float64 `json:"avg"`
+	// Sum is the total of the sample values
+	Sum float64 `json:"sum"`
+	// Stddev is the population standard deviation of the sample values
+	Stddev float64 `json:"stddev"`
+	// FirstTime is the earliest sample timestamp
+	FirstTime time.Time `json:"first_time"`
+	// LastTime is the latest sample timestamp
+	LastTime time.Time `json:"last_time"`
+}
+
+// Consumption summary periods
+const (
+	// PeriodDay summarises a UTC day
+	PeriodDay = "day"
+	// PeriodMonth summarises a UTC calendar month
+	PeriodMonth = "month"
+)
+
+// ConsumptionSummary summarises the consumption of a UTC day or month, in
+// the units of the stored values. Summaries are updated in the same
+// transaction as every insert, so they always agree with the stored data.
+type ConsumptionSummary struct {
+	// Period is PeriodDay or PeriodMonth
+	Period string `json:"period"`
+	// Start is the beginning of the period
+	Start time.Time `json:"start"`
+	// Total is the sum of the samples in the period
+	Total float64 `json:"total"`
+	// Peak is the highest sum of the samples within one clock hour of the
+	// period
+	Peak float64 `json:"peak"`
+	// PeakTime is the start of the hour of the peak
+	PeakTime time.Time `json:"peak_time"`
+	// Hours is the number of clock hours of the period with samples
+	Hours int `json:"hours"`
+	// SampleCount is the number of samples in the period
+	SampleCount int64 `json:"sample_count"`
+	// UpdatedAt is when the summary last changed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DemandResponseEvent is a window in which consumption was asked to drop
+// by TargetReduction, in the units of the stored values.
+type DemandResponseEvent struct {
+	// ID identifies the recorded event
+	ID int64 `json:"id"`
+	// Name describes the event, e.g. the programme or dispatch reference
+	Name string `json:"name"`
+	// Start is the beginning of the event window
+	Start time.Time `json:"start"`
+	// End is the end of the event window, exclusive
+	End time.Time `json:"end"`
+	// TargetReduction is the expected drop of the average value during the
+	// window
+	TargetReduction float64 `json:"target_reduction"`
+	// CreatedAt is when the event was recorded
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditRecord records a call to the service's API. Each record holds the
+// hash of the one before it, so records cannot be altered, removed or
+// reordered without breaking the chain.
+type AuditRecord struct {
+	// Seq numbers records consecutively from 1
+	Seq int64 `json:"seq"`
+	// Time is when the call completed
+	Time time.Time `json:"time"`
+	// Subject identifies the caller; empty when calls are not
+	// authenticated
+	Subject string `json:"subject"`
+	// Provider is the authentication provider that identified the caller
+	Provider string `json:"provider"`
+	// Source is the API called, grpc or http
+	Source string `json:"source"`
+	// Action is the full gRPC method, or the HTTP method and path
+	Action string `json:"action"`
+	// Request summarises the request's parameters, possibly truncated
+	Request string `json:"request"`
+	// Code is the outcome, a gRPC status code or HTTP status
+	Code string `json:"code"`
+	// Peer is the caller's network address
+	Peer string `json:"peer"`
+	// PrevHash is the hash of the previous record, empty for the first
+	PrevHash string `json:"prev_hash"`
+	// Hash is the hex-encoded SHA-256 of the record's other fields
+	Hash string `json:"hash"`
+}
+
+// VirtualSeries is a named expression over the stored series, saved at
+// runtime and computed when it is queried.
+type VirtualSeries struct {
+	// Name is what queries name the series by
+	Name string `json:"name"`
+	// Expression computes the series from the stored series and other
+	// derived series
+	Expression string `json:"expression"`
+	// Unit is the unit of the computed values, for display
+	Unit string `json:"unit,omitempty"`
+	// Description explains the series to dashboard users
+	Description string `json:"description,omitempty"`
+	// Tags are labels, such as site or phase, that label selectors choose
+	// the series by
+	Tags map[string]string `json:"tags,omitempty"`
+	// CreatedAt is when the series was first saved
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is when the series was last saved
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IngestSource is whether an ingestion source, such as the upstream API or
+// gRPC inserts, currently accepts data.
+type IngestSource struct {
+	// Name identifies the source, e.g. "upstream"
+	Name string `json:"name"`
+	// Enabled is false while data from the source is refused
+	Enabled bool `json:"enabled"`
+	// Reason explains why the source was last disabled or enabled
+	Reason string `json:"reason,omitempty"`
+	// UpdatedAt is when the source was last disabled or enabled, or the
+	// zero time if it never was
+	UpdatedAt time.Time `json:"updated_at"`
 }
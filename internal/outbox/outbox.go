@@ -0,0 +1,668 @@
+// Package outbox buffers writes that cross an unreliable network, so
+// that an edge site with a flaky WAN keeps collecting data and producing
+// files while offline, and catches up once connectivity returns.
+//
+// An Outbox is a directory of pending entries, which survive restarts:
+//
+//   - batches of points fetched from the upstream API whose insert failed
+//     because the database was unreachable, buffered by Repository
+//   - files, such as scheduled reports, whose upload to a destination
+//     failed, buffered by Destination
+//
+// Sync replays the entries, oldest first, and removes those that
+// succeed; Run syncs periodically. Buffering is explicit about
+// duplicates and conflicts:
+//
+//   - a batch identical to one already pending, as fetched again by a
+//     collection run retrying the same range, is buffered once
+//   - a file replaces a pending file of the same name bound for the same
+//     destination, so the latest version is uploaded once
+//   - points of a replayed batch whose timestamp is already stored, for
+//     example because the range was fetched again after connectivity
+//     returned, are skipped: stored data wins, so replays never duplicate
+//     samples
+//
+// Batches are replayed in order; while one fails, the later ones wait.
+// The outbox is bounded in bytes: once full, batches fail as they would
+// without it and files are uploaded without being buffered.
+//
+// Example Usage:
+//
+//	box, err := outbox.New("/var/lib/edgecom/outbox", outbox.DefaultMaxBytes, logger)
+//	if err != nil {
+//	    return err
+//	}
+//	fetcherRepo := box.WrapRepository(repo)
+//	archive := box.WrapDestination("archive", dest)
+//	go box.Run(ctx, outbox.DefaultSyncInterval)
+package outbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/destination"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Entry kinds
+const (
+	KindPoints = "points"
+	KindFile   = "file"
+)
+
+// Defaults
+const (
+	// DefaultMaxBytes bounds the size of the outbox
+	DefaultMaxBytes = 1 << 30
+	// DefaultSyncInterval is the time between syncs of Run
+	DefaultSyncInterval = 30 * time.Second
+)
+
+// ErrFull is returned when an entry does not fit in the outbox.
+var ErrFull = errors.New("outbox is full")
+
+// replayPageSize is the number of stored samples read at once when
+// looking for the points of a replayed batch that are already stored
+const replayPageSize = 10000
+
+// File name suffixes of an entry's header and of the content of a file
+// entry; files being written start with tempPrefix
+const (
+	headerSuffix = ".json"
+	dataSuffix   = ".data"
+	tempPrefix   = ".tmp-"
+)
+
+// header is the JSON stored for each entry
+type header struct {
+	Kind       string    `json:"kind"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// Points are the batch of a points entry
+	Points []point `json:"points,omitempty"`
+	// Destination and Name are where the content of a file entry goes
+	Destination string `json:"destination,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// point is a sample of a buffered batch
+type point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Outbox is a directory of writes waiting to be replayed.
+type Outbox struct {
+	dir      string
+	maxBytes int64
+	logger   *logrus.Logger
+
+	// syncMu serializes syncs
+	syncMu sync.Mutex
+
+	mu           sync.Mutex
+	size         int64
+	counts       map[string]int
+	inflight     map[string]bool
+	repo         database.TimeSeriesRepository
+	destinations map[string]destination.Destination
+
+	pending      *prometheus.GaugeVec
+	pendingBytes prometheus.Gauge
+	synced       *prometheus.CounterVec
+	conflicts    prometheus.Counter
+}
+
+// New returns the outbox in dir, which is created if it does not exist,
+// holding at most maxBytes. Entries left by an earlier process are kept
+// for the next sync, and files it left half written are removed.
+func New(dir string, maxBytes int64, logger *logrus.Logger) (*Outbox, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("an outbox directory is required")
+	}
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("outbox size must be positive, got %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	o := &Outbox{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		logger:       logger,
+		counts:       map[string]int{KindPoints: 0, KindFile: 0},
+		inflight:     make(map[string]bool),
+		destinations: make(map[string]destination.Destination),
+		pending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "edgecom_outbox_pending_entries",
+			Help: "Entries waiting in the outbox, by kind",
+		}, []string{"kind"}),
+		pendingBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "edgecom_outbox_pending_bytes",
+			Help: "Size of the entries waiting in the outbox",
+		}),
+		synced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "edgecom_outbox_synced_total",
+			Help: "Outbox entries replayed successfully, by kind",
+		}, []string{"kind"}),
+		conflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "edgecom_outbox_conflicting_points_total",
+			Help: "Buffered points skipped on replay because their timestamp was already stored",
+		}),
+	}
+
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// load removes half-written files and counts the pending entries
+func (o *Outbox) load() error {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, tempPrefix) {
+			os.Remove(filepath.Join(o.dir, name))
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read outbox directory: %w", err)
+		}
+		o.size += info.Size()
+		if strings.HasSuffix(name, headerSuffix) {
+			o.counts[entryKind(name)]++
+		}
+	}
+	o.updateGauges()
+	return nil
+}
+
+// SetMetrics registers the outbox's metrics with reg.
+func (o *Outbox) SetMetrics(reg prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{o.pending, o.pendingBytes, o.synced, o.conflicts} {
+		if err := reg.Register(collector); err != nil {
+			return fmt.Errorf("failed to register outbox metric: %v", err)
+		}
+	}
+	return nil
+}
+
+// WrapRepository returns repo buffering the batches whose insert fails
+// because the database is unreachable, and sets repo as the repository
+// buffered batches are replayed into. It must be called before Sync.
+func (o *Outbox) WrapRepository(repo database.TimeSeriesRepository) *Repository {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.repo = repo
+	return &Repository{TimeSeriesRepository: repo, outbox: o}
+}
+
+// WrapDestination returns dest buffering the files whose upload fails,
+// and sets dest as the destination named name buffered files are
+// uploaded to. It must be called before Sync.
+func (o *Outbox) WrapDestination(name string, dest destination.Destination) *Destination {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.destinations[name] = dest
+	return &Destination{name: name, dest: dest, outbox: o}
+}
+
+// Pending returns the number of entries waiting of each kind.
+func (o *Outbox) Pending() map[string]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending := make(map[string]int, len(o.counts))
+	for kind, n := range o.counts {
+		pending[kind] = n
+	}
+	return pending
+}
+
+// Run syncs the outbox every interval until ctx is done.
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.Sync(ctx); err != nil && ctx.Err() == nil {
+			o.logger.WithError(err).WithField("pending", o.Pending()).Warn("Failed to sync outbox, retrying later")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync replays the pending entries, oldest first, and removes those that
+// succeed. Once a batch of points fails, later batches are left for the
+// next sync, so batches are replayed in order; files are independent of
+// each other. It returns the first failure.
+func (o *Outbox) Sync(ctx context.Context) error {
+	o.syncMu.Lock()
+	defer o.syncMu.Unlock()
+
+	names, err := o.entries()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	pointsBlocked := false
+	synced := 0
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !o.claim(name) {
+			continue
+		}
+		h, err := o.readHeader(name)
+		if err != nil {
+			// An unreadable entry could never be replayed
+			o.logger.WithError(err).WithField("entry", name).Error("Removing unreadable outbox entry")
+			o.remove(name, entryKind(name))
+			o.release(name)
+			continue
+		}
+
+		if h.Kind == KindPoints && pointsBlocked {
+			o.release(name)
+			continue
+		}
+		if h.Kind == KindPoints {
+			err = o.replayPoints(ctx, h)
+			pointsBlocked = err != nil
+		} else {
+			err = o.upload(ctx, name, h)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("entry %s: %w", name, err)
+			}
+		} else {
+			o.remove(name, h.Kind)
+			o.synced.WithLabelValues(h.Kind).Inc()
+			synced++
+		}
+		o.release(name)
+	}
+
+	if synced > 0 {
+		o.logger.WithFields(logrus.Fields{
+			"synced":  synced,
+			"pending": o.Pending(),
+		}).Info("Synced outbox entries")
+	}
+	return firstErr
+}
+
+// replayPoints inserts the points of a buffered batch whose timestamps
+// are not stored yet
+func (o *Outbox) replayPoints(ctx context.Context, h header) error {
+	o.mu.Lock()
+	repo := o.repo
+	o.mu.Unlock()
+	if repo == nil {
+		return fmt.Errorf("no repository to replay points into")
+	}
+	if len(h.Points) == 0 {
+		return nil
+	}
+
+	first, last := h.Points[0].Time, h.Points[0].Time
+	for _, p := range h.Points[1:] {
+		if p.Time.Before(first) {
+			first = p.Time
+		}
+		if p.Time.After(last) {
+			last = p.Time
+		}
+	}
+	stored := make(map[int64]bool)
+	for skip := 0; ; skip += replayPageSize {
+		samples, err := repo.QueryRaw(ctx, first, last, skip, replayPageSize)
+		if err != nil {
+			return fmt.Errorf("failed to read stored points: %w", err)
+		}
+		for _, sample := range samples {
+			stored[sample.Time.UnixNano()] = true
+		}
+		if len(samples) < replayPageSize {
+			break
+		}
+	}
+
+	points := make([]models.TimeSeriesData, 0, len(h.Points))
+	for _, p := range h.Points {
+		if stored[p.Time.UnixNano()] {
+			continue
+		}
+		points = append(points, models.TimeSeriesData{Time: p.Time, Value: p.Value})
+	}
+	if skipped := len(h.Points) - len(points); skipped > 0 {
+		o.conflicts.Add(float64(skipped))
+		o.logger.WithFields(logrus.Fields{
+			"skipped": skipped,
+			"points":  len(h.Points),
+		}).Info("Skipped buffered points that were already stored")
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	return repo.BatchInsertTimeSeriesData(ctx, points)
+}
+
+// upload sends the content of a buffered file to its destination
+func (o *Outbox) upload(ctx context.Context, name string, h header) error {
+	o.mu.Lock()
+	dest, ok := o.destinations[h.Destination]
+	o.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown destination %q", h.Destination)
+	}
+
+	f, err := os.Open(filepath.Join(o.dir, strings.TrimSuffix(name, headerSuffix)+dataSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to open buffered file: %w", err)
+	}
+	defer f.Close()
+	return dest.Put(ctx, h.Name, f)
+}
+
+// full reports whether the outbox has no room left
+func (o *Outbox) full() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size >= o.maxBytes
+}
+
+// enqueuePoints buffers a batch, unless an identical batch is pending
+func (o *Outbox) enqueuePoints(data []models.TimeSeriesData) error {
+	h := header{Kind: KindPoints, EnqueuedAt: time.Now().UTC(), Points: make([]point, len(data))}
+	for i, p := range data {
+		h.Points[i] = point{Time: p.Time, Value: p.Value}
+	}
+	body, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	key := batchKey(data)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if matches, _ := filepath.Glob(filepath.Join(o.dir, "*-"+KindPoints+"-"+key+headerSuffix)); len(matches) > 0 {
+		return nil
+	}
+	if o.size+int64(len(body)) > o.maxBytes {
+		return ErrFull
+	}
+	if err := o.writeFile(entryName(KindPoints, key)+headerSuffix, body); err != nil {
+		return err
+	}
+	o.size += int64(len(body))
+	o.counts[KindPoints]++
+	o.updateGauges()
+	return nil
+}
+
+// bufferFile copies the content read from r to a temporary file in the
+// outbox's directory, which the caller removes, and returns its path and
+// size
+func (o *Outbox) bufferFile(r io.Reader) (string, int64, error) {
+	temp, err := os.CreateTemp(o.dir, tempPrefix+"*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to buffer file: %w", err)
+	}
+	size, err := io.Copy(temp, r)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", 0, fmt.Errorf("failed to buffer file: %w", err)
+	}
+	return temp.Name(), size, nil
+}
+
+// stageFile moves temp, a buffered file of size bytes, into the outbox as
+// the file name of the destination dest, replacing the pending versions
+// of the file, and returns the entry's header file name, claimed by the
+// caller. If the file does not fit once they are replaced, it fails with
+// ErrFull and leaves them pending.
+func (o *Outbox) stageFile(dest, name, temp string, size int64) (string, header, error) {
+	h := header{Kind: KindFile, EnqueuedAt: time.Now().UTC(), Destination: dest, Name: name}
+	body, err := json.Marshal(h)
+	if err != nil {
+		return "", header{}, err
+	}
+	key := fileKey(dest, name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	superseded := o.supersededLocked(key)
+	var freed int64
+	for _, previous := range superseded {
+		freed += o.entrySizeLocked(previous)
+	}
+	if o.size-freed+size+int64(len(body)) > o.maxBytes {
+		return "", header{}, ErrFull
+	}
+	for _, previous := range superseded {
+		o.removeLocked(previous, KindFile)
+	}
+	base := entryName(KindFile, key)
+	if err := os.Rename(temp, filepath.Join(o.dir, base+dataSuffix)); err != nil {
+		return "", header{}, fmt.Errorf("failed to buffer file: %w", err)
+	}
+	if err := o.writeFile(base+headerSuffix, body); err != nil {
+		os.Remove(filepath.Join(o.dir, base+dataSuffix))
+		return "", header{}, err
+	}
+	o.size += size + int64(len(body))
+	o.counts[KindFile]++
+	o.inflight[base+headerSuffix] = true
+	o.updateGauges()
+	return base + headerSuffix, h, nil
+}
+
+// removeSuperseded removes the pending versions of the file name of the
+// destination dest, once a newer one has been uploaded
+func (o *Outbox) removeSuperseded(dest, name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, previous := range o.supersededLocked(fileKey(dest, name)) {
+		o.removeLocked(previous, KindFile)
+	}
+}
+
+// supersededLocked returns the header file names of the pending versions
+// of the file with key, except those being uploaded. It must be called
+// with o.mu held.
+func (o *Outbox) supersededLocked(key string) []string {
+	paths, _ := filepath.Glob(filepath.Join(o.dir, "*-"+KindFile+"-"+key+headerSuffix))
+	var names []string
+	for _, path := range paths {
+		if previous := filepath.Base(path); !o.inflight[previous] {
+			names = append(names, previous)
+		}
+	}
+	return names
+}
+
+// entrySizeLocked returns the bytes taken by the file entry whose header
+// file is name. It must be called with o.mu held.
+func (o *Outbox) entrySizeLocked(name string) int64 {
+	var size int64
+	for _, path := range []string{name, strings.TrimSuffix(name, headerSuffix) + dataSuffix} {
+		if info, err := os.Stat(filepath.Join(o.dir, path)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// writeFile writes a file of the outbox atomically
+func (o *Outbox) writeFile(name string, body []byte) error {
+	temp, err := os.CreateTemp(o.dir, tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(body)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), filepath.Join(o.dir, name))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	return nil
+}
+
+// entries returns the header file names of the pending entries, oldest
+// first
+func (o *Outbox) entries() ([]string, error) {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+	var names []string
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, headerSuffix) && !strings.HasPrefix(name, tempPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readHeader reads the header of an entry
+func (o *Outbox) readHeader(name string) (header, error) {
+	var h header
+	body, err := os.ReadFile(filepath.Join(o.dir, name))
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(body, &h); err != nil {
+		return h, err
+	}
+	if h.Kind != KindPoints && h.Kind != KindFile {
+		return h, fmt.Errorf("unknown entry kind %q", h.Kind)
+	}
+	return h, nil
+}
+
+// claim marks an entry as being replayed, false if it already is
+func (o *Outbox) claim(name string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.inflight[name] {
+		return false
+	}
+	o.inflight[name] = true
+	return true
+}
+
+// release unmarks an entry claimed for replay
+func (o *Outbox) release(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.inflight, name)
+}
+
+// remove deletes an entry and its content
+func (o *Outbox) remove(name, kind string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.removeLocked(name, kind)
+}
+
+func (o *Outbox) removeLocked(name, kind string) {
+	paths := []string{filepath.Join(o.dir, name)}
+	if kind == KindFile {
+		paths = append(paths, filepath.Join(o.dir, strings.TrimSuffix(name, headerSuffix)+dataSuffix))
+	}
+	removed := false
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			if err := os.Remove(path); err == nil {
+				o.size -= info.Size()
+				removed = true
+			}
+		}
+	}
+	if removed {
+		o.counts[kind]--
+		o.updateGauges()
+	}
+}
+
+func (o *Outbox) updateGauges() {
+	for _, kind := range []string{KindPoints, KindFile} {
+		o.pending.WithLabelValues(kind).Set(float64(o.counts[kind]))
+	}
+	o.pendingBytes.Set(float64(o.size))
+}
+
+// entryName returns the name of a new entry without suffix. Names start
+// with the time they were created, so they sort oldest first.
+func entryName(kind, key string) string {
+	return fmt.Sprintf("%020d-%s-%s", time.Now().UnixNano(), kind, key)
+}
+
+// entryKind returns the kind of the entry with the given file name
+func entryKind(name string) string {
+	if strings.Contains(name, "-"+KindFile+"-") {
+		return KindFile
+	}
+	return KindPoints
+}
+
+// batchKey identifies the content of a batch
+func batchKey(data []models.TimeSeriesData) string {
+	h := sha256.New()
+	var buf [16]byte
+	for _, p := range data {
+		binary.BigEndian.PutUint64(buf[0:], uint64(p.Time.UnixNano()))
+		binary.BigEndian.PutUint64(buf[8:], math.Float64bits(p.Value))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// fileKey identifies a file of a destination
+func fileKey(dest, name string) string {
+	sum := sha256.Sum256([]byte(dest + "\x00" + name))
+	return hex.EncodeToString(sum[:])[:32]
+}
@@ -0,0 +1,202 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// errOffline is the error of a database that cannot be reached
+var errOffline = &pq.Error{Code: "08006"}
+
+// fakeDestination records uploads, failing while offline
+type fakeDestination struct {
+	mu      sync.Mutex
+	offline bool
+	files   map[string]string
+}
+
+func (d *fakeDestination) Put(_ context.Context, name string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.offline {
+		return errors.New("connection refused")
+	}
+	d.files[name] = string(content)
+	return nil
+}
+
+func testBatch(base time.Time) []models.TimeSeriesData {
+	return []models.TimeSeriesData{
+		{Time: base, Value: 1},
+		{Time: base.Add(time.Minute), Value: 2},
+		{Time: base.Add(2 * time.Minute), Value: 3},
+	}
+}
+
+func TestRepository(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTimeSeriesRepository(ctrl)
+	box, err := New(t.TempDir(), DefaultMaxBytes, logrus.New())
+	require.NoError(t, err)
+	buffered := box.WrapRepository(repo)
+
+	base := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	batch := testBatch(base)
+	ctx := context.Background()
+
+	t.Run("buffers batches while offline", func(t *testing.T) {
+		repo.EXPECT().BatchInsertTimeSeriesData(ctx, batch).Return(errOffline).Times(2)
+
+		require.NoError(t, buffered.BatchInsertTimeSeriesData(ctx, batch))
+		require.NoError(t, buffered.BatchInsertTimeSeriesData(ctx, batch), "a refetched batch is buffered once")
+		assert.Equal(t, 1, box.Pending()[KindPoints])
+		assert.Equal(t, 1.0, testutil.ToFloat64(box.pending.WithLabelValues(KindPoints)))
+	})
+
+	t.Run("returns other failures", func(t *testing.T) {
+		repo.EXPECT().BatchInsertTimeSeriesData(ctx, batch).Return(errors.New("invalid input"))
+
+		assert.ErrorContains(t, buffered.BatchInsertTimeSeriesData(ctx, batch), "invalid input")
+		assert.Equal(t, 1, box.Pending()[KindPoints])
+	})
+
+	t.Run("keeps batches while the database is unreachable", func(t *testing.T) {
+		repo.EXPECT().QueryRaw(gomock.Any(), base, base.Add(2*time.Minute), 0, replayPageSize).Return(nil, errOffline)
+
+		assert.Error(t, box.Sync(ctx))
+		assert.Equal(t, 1, box.Pending()[KindPoints])
+	})
+
+	t.Run("replays batches skipping stored points", func(t *testing.T) {
+		// The first point was fetched again and stored after reconnecting
+		repo.EXPECT().
+			QueryRaw(gomock.Any(), base, base.Add(2*time.Minute), 0, replayPageSize).
+			Return([]models.TimeSeriesData{{Time: base, Value: 1}}, nil)
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), batch[1:]).Return(nil)
+
+		require.NoError(t, box.Sync(ctx))
+		assert.Equal(t, 0, box.Pending()[KindPoints])
+		assert.Equal(t, 1.0, testutil.ToFloat64(box.conflicts))
+		assert.Equal(t, 1.0, testutil.ToFloat64(box.synced.WithLabelValues(KindPoints)))
+		assert.Equal(t, 0.0, testutil.ToFloat64(box.pendingBytes))
+	})
+}
+
+func TestReplayOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTimeSeriesRepository(ctrl)
+	dir := t.TempDir()
+	box, err := New(dir, DefaultMaxBytes, logrus.New())
+	require.NoError(t, err)
+	buffered := box.WrapRepository(repo)
+
+	base := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	first, second := testBatch(base), testBatch(base.Add(time.Hour))
+	repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).Return(errOffline).Times(2)
+	require.NoError(t, buffered.BatchInsertTimeSeriesData(context.Background(), first))
+	require.NoError(t, buffered.BatchInsertTimeSeriesData(context.Background(), second))
+
+	// Entries survive a restart
+	box, err = New(dir, DefaultMaxBytes, logrus.New())
+	require.NoError(t, err)
+	box.WrapRepository(repo)
+	assert.Equal(t, 2, box.Pending()[KindPoints])
+
+	// The second batch waits while the first fails
+	repo.EXPECT().QueryRaw(gomock.Any(), gomock.Any(), gomock.Any(), 0, replayPageSize).Return(nil, nil).Times(3)
+	gomock.InOrder(
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), first).Return(errOffline),
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), first).Return(nil),
+		repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), second).Return(nil),
+	)
+	assert.Error(t, box.Sync(context.Background()))
+	assert.Equal(t, 2, box.Pending()[KindPoints])
+	require.NoError(t, box.Sync(context.Background()))
+	assert.Equal(t, 0, box.Pending()[KindPoints])
+}
+
+func TestDestination(t *testing.T) {
+	box, err := New(t.TempDir(), DefaultMaxBytes, logrus.New())
+	require.NoError(t, err)
+	remote := &fakeDestination{offline: true, files: map[string]string{}}
+	dest := box.WrapDestination("archive", remote)
+	ctx := context.Background()
+
+	require.NoError(t, dest.Put(ctx, "report.pdf", strings.NewReader("draft")))
+	require.NoError(t, dest.Put(ctx, "report.pdf", strings.NewReader("final")))
+	require.NoError(t, dest.Put(ctx, "other.pdf", strings.NewReader("other")))
+	assert.Equal(t, 2, box.Pending()[KindFile], "a newer version replaces the pending one")
+
+	assert.Error(t, box.Sync(ctx))
+	assert.Equal(t, 2, box.Pending()[KindFile])
+
+	remote.offline = false
+	require.NoError(t, box.Sync(ctx))
+	assert.Equal(t, map[string]string{"report.pdf": "final", "other.pdf": "other"}, remote.files)
+	assert.Equal(t, 0, box.Pending()[KindFile])
+
+	// Online uploads are not kept
+	require.NoError(t, dest.Put(ctx, "next.pdf", strings.NewReader("next")))
+	assert.Equal(t, "next", remote.files["next.pdf"])
+	assert.Equal(t, 0, box.Pending()[KindFile])
+	assert.Equal(t, 0.0, testutil.ToFloat64(box.pendingBytes))
+}
+
+func TestFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTimeSeriesRepository(ctrl)
+	box, err := New(t.TempDir(), 64, logrus.New())
+	require.NoError(t, err)
+	buffered := box.WrapRepository(repo)
+
+	batch := testBatch(time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC))
+	repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), batch).Return(errOffline)
+	assert.ErrorIs(t, buffered.BatchInsertTimeSeriesData(context.Background(), batch), errOffline)
+	assert.Equal(t, 0, box.Pending()[KindPoints])
+
+	_, err = New(t.TempDir(), 0, logrus.New())
+	assert.ErrorContains(t, err, "must be positive")
+}
+
+func TestFullDestination(t *testing.T) {
+	box, err := New(t.TempDir(), 256, logrus.New())
+	require.NoError(t, err)
+	remote := &fakeDestination{offline: true, files: map[string]string{}}
+	dest := box.WrapDestination("archive", remote)
+	ctx := context.Background()
+
+	require.NoError(t, dest.Put(ctx, "report.pdf", strings.NewReader("draft")))
+	require.Equal(t, 1, box.Pending()[KindFile])
+
+	// A version too large to buffer does not drop the pending one
+	large := strings.Repeat("x", 512)
+	assert.Error(t, dest.Put(ctx, "report.pdf", strings.NewReader(large)))
+	assert.Equal(t, 1, box.Pending()[KindFile])
+
+	// and is uploaded directly once the destination is back, replacing it
+	remote.offline = false
+	require.NoError(t, dest.Put(ctx, "report.pdf", strings.NewReader(large)))
+	assert.Equal(t, large, remote.files["report.pdf"])
+	assert.Equal(t, 0, box.Pending()[KindFile])
+	require.NoError(t, box.Sync(ctx))
+	assert.Equal(t, large, remote.files["report.pdf"], "the stale version is not uploaded over it")
+}
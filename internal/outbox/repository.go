@@ -0,0 +1,97 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/destination"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Repository buffers the batches whose insert fails because the database
+// is unreachable. Other calls go to the wrapped repository.
+type Repository struct {
+	database.TimeSeriesRepository
+
+	outbox *Outbox
+}
+
+// BatchInsertTimeSeriesData inserts data, or buffers it in the outbox and
+// succeeds if the database could not be reached. Other failures, and
+// failures of batches that do not fit in the outbox, are returned.
+func (r *Repository) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
+	err := r.TimeSeriesRepository.BatchInsertTimeSeriesData(ctx, data)
+	if err == nil || ctx.Err() != nil || !database.IsTransient(err) {
+		return err
+	}
+
+	if bufferErr := r.outbox.enqueuePoints(data); bufferErr != nil {
+		r.outbox.logger.WithError(bufferErr).WithField("points", len(data)).Warn("Failed to buffer batch in the outbox")
+		return err
+	}
+	r.outbox.logger.WithError(err).WithField("points", len(data)).Warn("Database unreachable, buffered batch in the outbox")
+	return nil
+}
+
+// Destination buffers the files whose upload fails.
+type Destination struct {
+	name   string
+	dest   destination.Destination
+	outbox *Outbox
+}
+
+// Put stages the content read from r in the outbox and uploads it. If
+// the upload fails, the file stays in the outbox for the next sync and
+// Put succeeds; it fails if the file could not be staged. Files that do
+// not fit in the outbox are uploaded directly, without buffering.
+func (d *Destination) Put(ctx context.Context, name string, r io.Reader) error {
+	if d.outbox.full() {
+		return d.putDirect(ctx, name, r)
+	}
+	temp, size, err := d.outbox.bufferFile(r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	entry, h, err := d.outbox.stageFile(d.name, name, temp, size)
+	if errors.Is(err, ErrFull) {
+		f, err := os.Open(temp)
+		if err != nil {
+			return fmt.Errorf("failed to open buffered file: %w", err)
+		}
+		defer f.Close()
+		return d.putDirect(ctx, name, f)
+	}
+	if err != nil {
+		return err
+	}
+	defer d.outbox.release(entry)
+
+	if err := d.outbox.upload(ctx, entry, h); err != nil {
+		d.outbox.logger.WithError(err).WithFields(logrus.Fields{
+			"destination": d.name,
+			"file":        name,
+		}).Warn("Upload failed, buffered file in the outbox")
+		return nil
+	}
+	d.outbox.remove(entry, KindFile)
+	d.outbox.synced.WithLabelValues(KindFile).Inc()
+	return nil
+}
+
+// putDirect uploads a file without buffering it, and drops the pending
+// versions it replaces so that the next sync does not overwrite it
+func (d *Destination) putDirect(ctx context.Context, name string, r io.Reader) error {
+	if err := d.dest.Put(ctx, name, r); err != nil {
+		return err
+	}
+	d.outbox.removeSuperseded(d.name, name)
+	return nil
+}
@@ -0,0 +1,237 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page layout, in points on an A4 page
+const (
+	pageWidth   = 595
+	pageHeight  = 842
+	marginLeft  = 60
+	marginRight = 535
+
+	// digitWidth is the width of a Helvetica digit as a fraction of the
+	// font size, used to right-align axis labels
+	digitWidth = 0.556
+)
+
+// chartBox is the area of a chart's plot, excluding its labels
+type chartBox struct {
+	left, bottom, right, top float64
+}
+
+// WritePDF renders a summary as a single-page PDF document with the
+// summary statistics and two charts. Only the standard Helvetica fonts are
+// used, so the document needs no embedded fonts; characters outside ASCII
+// are replaced.
+func WritePDF(w io.Writer, s Summary) error {
+	var page pdfContent
+
+	page.text(marginLeft, 790, "F2", 20, s.Title)
+	page.text(marginLeft, 768, "F1", 11, fmt.Sprintf("%s to %s (%s)",
+		s.Start.Format("2 Jan 2006"), s.End.Add(-time.Nanosecond).Format("2 Jan 2006"), s.Start.Location()))
+
+	// Summary statistics
+	stats := [][2]string{
+		{"Total consumption", formatValue(s.Total)},
+		{"Daily average", formatValue(s.DailyAverage)},
+		{"Peak hour", fmt.Sprintf("%s at %s", formatValue(s.Peak), s.PeakTime.In(s.Start.Location()).Format("2 Jan 15:04"))},
+		{"Lowest hour", fmt.Sprintf("%s at %s", formatValue(s.Low), s.LowTime.In(s.Start.Location()).Format("2 Jan 15:04"))},
+		{"Data coverage", fmt.Sprintf("%.1f%% of hours", s.Coverage*100)},
+	}
+	if s.Empty() {
+		stats = [][2]string{{"No data", "No data was recorded in this period."}}
+	}
+	y := 730.0
+	for _, row := range stats {
+		page.text(marginLeft, y, "F2", 11, row[0])
+		page.text(200, y, "F1", 11, row[1])
+		y -= 18
+	}
+
+	totalsTitle := "Consumption per day"
+	if s.Period == PeriodDay {
+		totalsTitle = "Consumption per hour"
+	}
+	page.text(marginLeft, 580, "F2", 13, totalsTitle)
+	page.barChart(chartBox{marginLeft + 30, 380, marginRight, 560}, s.Totals)
+
+	page.text(marginLeft, 310, "F2", 13, "Average consumption by hour of day")
+	page.lineChart(chartBox{marginLeft + 30, 110, marginRight, 290}, s.Profile)
+
+	page.text(marginLeft, 40, "F1", 8, "Generated "+s.Generated.In(s.Start.Location()).Format("2 Jan 2006 15:04 MST"))
+
+	return writeDocument(w, page.buf.Bytes())
+}
+
+// pdfContent builds a page content stream
+type pdfContent struct {
+	buf bytes.Buffer
+}
+
+func (c *pdfContent) text(x, y float64, font string, size float64, s string) {
+	fmt.Fprintf(&c.buf, "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escapeText(s))
+}
+
+// rightText draws a numeric label ending at x
+func (c *pdfContent) rightText(x, y, size float64, s string) {
+	c.text(x-float64(len(s))*size*digitWidth, y, "F1", size, s)
+}
+
+func (c *pdfContent) line(x1, y1, x2, y2, width, gray float64) {
+	fmt.Fprintf(&c.buf, "%.3f G %g w %.2f %.2f m %.2f %.2f l S\n", gray, width, x1, y1, x2, y2)
+}
+
+// axes draws the value axis with gridlines up to max and returns the
+// scale from values to points
+func (c *pdfContent) axes(box chartBox, max float64) float64 {
+	const gridlines = 4
+	for i := 0; i <= gridlines; i++ {
+		value := max * float64(i) / gridlines
+		y := box.bottom + (box.top-box.bottom)*float64(i)/gridlines
+		gray, width := 0.85, 0.5
+		if i == 0 {
+			gray, width = 0, 1
+		}
+		c.line(box.left, y, box.right, y, width, gray)
+		c.rightText(box.left-4, y-3, 8, formatAxis(value))
+	}
+	return (box.top - box.bottom) / max
+}
+
+// barChart draws one bar per bucket, labelling at most 16 of them
+func (c *pdfContent) barChart(box chartBox, buckets []Bucket) {
+	scale := c.axes(box, niceCeil(maxValue(buckets)))
+	if len(buckets) == 0 {
+		return
+	}
+
+	slot := (box.right - box.left) / float64(len(buckets))
+	step := int(math.Ceil(float64(len(buckets)) / 16))
+	c.buf.WriteString("0.2 0.45 0.7 rg\n")
+	for i, bucket := range buckets {
+		x := box.left + slot*float64(i)
+		if height := math.Max(bucket.Value, 0) * scale; height > 0 {
+			fmt.Fprintf(&c.buf, "%.2f %.2f %.2f %.2f re f\n", x+slot*0.15, box.bottom, slot*0.7, height)
+		}
+		if i%step == 0 {
+			c.buf.WriteString("0 g\n")
+			c.text(x+slot/2-float64(len(bucket.Label))*8*digitWidth/2, box.bottom-12, "F1", 8, bucket.Label)
+			c.buf.WriteString("0.2 0.45 0.7 rg\n")
+		}
+	}
+	c.buf.WriteString("0 g\n")
+}
+
+// lineChart draws the buckets as a polyline, labelling every third one
+func (c *pdfContent) lineChart(box chartBox, buckets []Bucket) {
+	scale := c.axes(box, niceCeil(maxValue(buckets)))
+	if len(buckets) < 2 {
+		return
+	}
+
+	slot := (box.right - box.left) / float64(len(buckets)-1)
+	c.buf.WriteString("0.8 0.35 0.1 RG 1.5 w\n")
+	for i, bucket := range buckets {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(&c.buf, "%.2f %.2f %s\n", box.left+slot*float64(i), box.bottom+math.Max(bucket.Value, 0)*scale, op)
+	}
+	c.buf.WriteString("S\n")
+
+	for i := 0; i < len(buckets); i += 3 {
+		label := buckets[i].Label
+		c.text(box.left+slot*float64(i)-float64(len(label))*8*digitWidth/2, box.bottom-12, "F1", 8, label)
+	}
+}
+
+// writeDocument wraps a content stream in a single-page document
+func writeDocument(w io.Writer, content []byte) error {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pageWidth, pageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// escapeText makes s safe inside a PDF string literal
+func escapeText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// maxValue is the largest bucket value
+func maxValue(buckets []Bucket) float64 {
+	max := 0.0
+	for _, bucket := range buckets {
+		max = math.Max(max, bucket.Value)
+	}
+	return max
+}
+
+// niceCeil rounds max up to 1, 2 or 5 times a power of ten, so gridlines
+// fall on round numbers
+func niceCeil(max float64) float64 {
+	if !(max > 0) {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(max)))
+	for _, factor := range []float64{1, 2, 5, 10} {
+		if max <= factor*magnitude {
+			return factor * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatValue formats a statistic with two decimals
+func formatValue(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// formatAxis formats an axis label without trailing zeros
+func formatAxis(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,272 @@
+// Package report produces PDF summary reports of consumption over a
+// calendar period and delivers them to destinations.
+//
+// A report covers the last complete day, week (starting Monday) or month
+// before it is generated, in the reporter's time zone. It holds summary
+// statistics computed from hourly totals, a chart of the totals per hour
+// (daily reports) or per day (weekly and monthly reports), and a chart of
+// the average consumption by hour of day.
+//
+// Example Usage:
+//
+//	reporter, err := report.NewReporter(repo, report.Config{
+//	    Title:    "Monthly energy report",
+//	    Period:   report.PeriodMonth,
+//	    Location: time.UTC,
+//	}, []destination.Destination{destination.NewLocal("/var/lib/edgecom/reports")}, logger)
+//	if err != nil {
+//	    return err
+//	}
+//
+//	// On the 1st of each month, report the previous month
+//	err = reporter.Run(ctx, time.Now())
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/destination"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Report periods
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// ContentTypePDF is the media type of rendered reports
+const ContentTypePDF = "application/pdf"
+
+// Querier is the subset of the repository needed to build a report.
+type Querier interface {
+	Query(ctx context.Context, start, end time.Time, window, aggregation string) ([]models.TimeSeriesData, error)
+}
+
+// Config describes the reports to produce.
+type Config struct {
+	// Title heads every report
+	Title string
+	// Period is PeriodDay, PeriodWeek or PeriodMonth
+	Period string
+	// Location is the time zone periods and charts are laid out in
+	Location *time.Location
+}
+
+// Validate checks that the configuration is usable.
+func (c Config) Validate() error {
+	switch c.Period {
+	case PeriodDay, PeriodWeek, PeriodMonth:
+	default:
+		return fmt.Errorf("invalid report period %q, expected %q, %q or %q", c.Period, PeriodDay, PeriodWeek, PeriodMonth)
+	}
+	if c.Location == nil {
+		return fmt.Errorf("report time zone is required")
+	}
+	return nil
+}
+
+// Bucket is the total consumption of one chart bar or profile point.
+type Bucket struct {
+	// Label names the bucket on the chart axis
+	Label string
+	Value float64
+}
+
+// Summary is the content of a report.
+type Summary struct {
+	Title string
+	// Period is the kind of period reported on
+	Period string
+	// Start and End bound the reported period
+	Start, End time.Time
+	// Total is the consumption over the period
+	Total float64
+	// DailyAverage is Total divided by the number of days in the period
+	DailyAverage float64
+	// Peak and Low are the hours with the highest and lowest consumption,
+	// and PeakTime and LowTime when they started
+	Peak, Low         float64
+	PeakTime, LowTime time.Time
+	// Coverage is the fraction of hours in the period with data
+	Coverage float64
+	// Totals are the totals per hour of a daily report, or per day
+	Totals []Bucket
+	// Profile is the average consumption in each hour of the day
+	Profile []Bucket
+	// Generated is when the report was generated
+	Generated time.Time
+}
+
+// Empty reports whether the period has no data.
+func (s Summary) Empty() bool {
+	return s.Coverage == 0
+}
+
+// PeriodBefore returns the last complete period of the given kind before
+// now, in location.
+func PeriodBefore(period string, now time.Time, location *time.Location) (start, end time.Time) {
+	local := now.In(location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+
+	switch period {
+	case PeriodWeek:
+		// Weeks start on Monday
+		daysSinceMonday := (int(local.Weekday()) + 6) % 7
+		end = midnight.AddDate(0, 0, -daysSinceMonday)
+		return end.AddDate(0, 0, -7), end
+	case PeriodMonth:
+		end = time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, location)
+		return end.AddDate(0, -1, 0), end
+	default:
+		return midnight.AddDate(0, 0, -1), midnight
+	}
+}
+
+// Summarize computes the report for the period [start, end) from the
+// hourly totals in repo.
+func Summarize(ctx context.Context, repo Querier, cfg Config, start, end time.Time) (Summary, error) {
+	hours, err := repo.Query(ctx, start, end, "1h", "SUM")
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed to query hourly totals: %w", err)
+	}
+
+	summary := Summary{
+		Title:  cfg.Title,
+		Period: cfg.Period,
+		Start:  start,
+		End:    end,
+	}
+
+	// Lay out an empty bucket for every hour or day in the period, so gaps
+	// in the data show as missing bars
+	var totals []Bucket
+	index := make(map[int64]int)
+	bucketKey := func(t time.Time) int64 {
+		if cfg.Period == PeriodDay {
+			return t.Truncate(time.Hour).Unix()
+		}
+		local := t.In(cfg.Location)
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, cfg.Location).Unix()
+	}
+	for t := start; t.Before(end); {
+		index[t.Unix()] = len(totals)
+		if cfg.Period == PeriodDay {
+			totals = append(totals, Bucket{Label: t.In(cfg.Location).Format("15")})
+			t = t.Add(time.Hour)
+		} else {
+			totals = append(totals, Bucket{Label: t.In(cfg.Location).Format("2")})
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+
+	var profile [24]float64
+	var profileCount [24]int
+	summary.Peak, summary.Low = math.Inf(-1), math.Inf(1)
+	for _, hour := range hours {
+		if math.IsNaN(hour.Value) {
+			continue
+		}
+		summary.Total += hour.Value
+		if hour.Value > summary.Peak {
+			summary.Peak, summary.PeakTime = hour.Value, hour.Time
+		}
+		if hour.Value < summary.Low {
+			summary.Low, summary.LowTime = hour.Value, hour.Time
+		}
+		if i, ok := index[bucketKey(hour.Time)]; ok {
+			totals[i].Value += hour.Value
+		}
+		h := hour.Time.In(cfg.Location).Hour()
+		profile[h] += hour.Value
+		profileCount[h]++
+		summary.Coverage++
+	}
+	if summary.Coverage == 0 {
+		summary.Peak, summary.Low = 0, 0
+	}
+
+	summary.Coverage /= end.Sub(start).Hours()
+	days := float64(len(totals))
+	if cfg.Period == PeriodDay {
+		days = 1
+	}
+	summary.DailyAverage = summary.Total / days
+	summary.Totals = totals
+
+	summary.Profile = make([]Bucket, 24)
+	for h := range profile {
+		summary.Profile[h].Label = fmt.Sprintf("%02d", h)
+		if profileCount[h] > 0 {
+			summary.Profile[h].Value = profile[h] / float64(profileCount[h])
+		}
+	}
+
+	return summary, nil
+}
+
+// Reporter generates reports and delivers them.
+type Reporter struct {
+	repo         Querier
+	cfg          Config
+	destinations []destination.Destination
+	logger       *logrus.Logger
+}
+
+// NewReporter creates a reporter delivering to every destination.
+func NewReporter(repo Querier, cfg Config, destinations []destination.Destination, logger *logrus.Logger) (*Reporter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("at least one report destination is required")
+	}
+	return &Reporter{repo: repo, cfg: cfg, destinations: destinations, logger: logger}, nil
+}
+
+// Run reports on the last complete period before now and delivers the PDF
+// to every destination. A failed delivery does not stop delivery to the
+// others; the first error is returned.
+func (r *Reporter) Run(ctx context.Context, now time.Time) error {
+	start, end := PeriodBefore(r.cfg.Period, now, r.cfg.Location)
+	summary, err := Summarize(ctx, r.repo, r.cfg, start, end)
+	if err != nil {
+		return err
+	}
+	summary.Generated = now
+
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, summary); err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+
+	name := fmt.Sprintf("edgecom-%s-%s.pdf", r.cfg.Period, start.Format("2006-01-02"))
+	var firstErr error
+	for _, dest := range r.destinations {
+		if err := dest.Put(ctx, name, bytes.NewReader(buf.Bytes())); err != nil {
+			r.logger.WithError(err).WithField("report", name).Error("Failed to deliver report")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"report":   name,
+		"start":    start,
+		"end":      end,
+		"total":    summary.Total,
+		"coverage": summary.Coverage,
+	}).Info("Report delivered")
+	return nil
+}
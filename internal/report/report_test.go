@@ -0,0 +1,186 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/destination"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// fakeQuerier returns one point per hour in the queried range with the
+// value of its hour of day, skipping the hours in missing
+type fakeQuerier struct {
+	missing map[time.Time]bool
+}
+
+func (f *fakeQuerier) Query(_ context.Context, start, end time.Time, window, aggregation string) ([]models.TimeSeriesData, error) {
+	if window != "1h" || aggregation != "SUM" {
+		return nil, errors.New("unexpected query")
+	}
+	var points []models.TimeSeriesData
+	for t := start; t.Before(end); t = t.Add(time.Hour) {
+		if !f.missing[t] {
+			points = append(points, models.TimeSeriesData{Time: t.UTC(), Value: float64(t.UTC().Hour())})
+		}
+	}
+	return points, nil
+}
+
+// failingDestination fails every delivery
+type failingDestination struct{}
+
+func (failingDestination) Put(context.Context, string, io.Reader) error {
+	return errors.New("disk full")
+}
+
+func TestPeriodBefore(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// Sunday 1 December 2024, 06:00 in Berlin
+	now := time.Date(2024, 12, 1, 6, 0, 0, 0, berlin)
+
+	tests := []struct {
+		period    string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{PeriodDay, time.Date(2024, 11, 30, 0, 0, 0, 0, berlin), time.Date(2024, 12, 1, 0, 0, 0, 0, berlin)},
+		{PeriodWeek, time.Date(2024, 11, 18, 0, 0, 0, 0, berlin), time.Date(2024, 11, 25, 0, 0, 0, 0, berlin)},
+		{PeriodMonth, time.Date(2024, 11, 1, 0, 0, 0, 0, berlin), time.Date(2024, 12, 1, 0, 0, 0, 0, berlin)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			start, end := PeriodBefore(tt.period, now, berlin)
+			assert.True(t, tt.wantStart.Equal(start), "start %v", start)
+			assert.True(t, tt.wantEnd.Equal(end), "end %v", end)
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+	repo := &fakeQuerier{missing: map[time.Time]bool{start.Add(30 * time.Hour): true}}
+
+	summary, err := Summarize(context.Background(), repo, Config{Title: "Test", Period: PeriodWeek, Location: time.UTC}, start, end)
+	require.NoError(t, err)
+
+	// 0 + 1 + ... + 23 = 276 per day, with 06:00 missing on the second day
+	assert.Equal(t, 546.0, summary.Total)
+	assert.Equal(t, 273.0, summary.DailyAverage)
+	assert.Equal(t, []Bucket{{Label: "1", Value: 276}, {Label: "2", Value: 270}}, summary.Totals)
+	assert.Equal(t, 23.0, summary.Peak)
+	assert.Equal(t, start.Add(23*time.Hour), summary.PeakTime)
+	assert.Equal(t, 0.0, summary.Low)
+	assert.InDelta(t, 47.0/48, summary.Coverage, 1e-9)
+	require.Len(t, summary.Profile, 24)
+	assert.Equal(t, Bucket{Label: "06", Value: 6}, summary.Profile[6])
+	assert.False(t, summary.Empty())
+
+	t.Run("hourly buckets for a day", func(t *testing.T) {
+		summary, err := Summarize(context.Background(), &fakeQuerier{}, Config{Period: PeriodDay, Location: time.UTC}, start, start.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Len(t, summary.Totals, 24)
+		assert.Equal(t, Bucket{Label: "13", Value: 13}, summary.Totals[13])
+		assert.Equal(t, 276.0, summary.DailyAverage)
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		summary, err := Summarize(context.Background(), &fakeQuerier{missing: allHours(start, end)}, Config{Period: PeriodWeek, Location: time.UTC}, start, end)
+		require.NoError(t, err)
+		assert.True(t, summary.Empty())
+		assert.Zero(t, summary.Peak)
+	})
+}
+
+func allHours(start, end time.Time) map[time.Time]bool {
+	hours := make(map[time.Time]bool)
+	for t := start; t.Before(end); t = t.Add(time.Hour) {
+		hours[t] = true
+	}
+	return hours
+}
+
+func TestWritePDF(t *testing.T) {
+	start := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	summary, err := Summarize(context.Background(), &fakeQuerier{}, Config{Title: "Plant (north)", Period: PeriodMonth, Location: time.UTC}, start, start.AddDate(0, 1, 0))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePDF(&buf, summary))
+	doc := buf.Bytes()
+
+	assert.True(t, bytes.HasPrefix(doc, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(doc, []byte("%%EOF\n")))
+	assert.Contains(t, string(doc), `(Plant \(north\)) Tj`)
+	assert.Contains(t, string(doc), `(1 Nov 2024 to 30 Nov 2024 \(UTC\)) Tj`)
+	assert.Contains(t, string(doc), "(Consumption per day) Tj")
+
+	// Every cross-reference entry points at its object
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(doc)
+	require.NotNil(t, startxref)
+	xref, err := strconv.Atoi(string(startxref[1]))
+	require.NoError(t, err)
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(doc[xref:], -1)
+	require.Len(t, entries, 6)
+	for i, entry := range entries {
+		offset, err := strconv.Atoi(string(entry[1]))
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(doc[offset:], []byte(strconv.Itoa(i+1)+" 0 obj")), "object %d", i+1)
+	}
+}
+
+func TestReporterRun(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	dir := filepath.Join(t.TempDir(), "reports")
+	cfg := Config{Title: "Monthly report", Period: PeriodMonth, Location: time.UTC}
+	now := time.Date(2024, 12, 1, 6, 0, 0, 0, time.UTC)
+
+	t.Run("delivers to the directory", func(t *testing.T) {
+		reporter, err := NewReporter(&fakeQuerier{}, cfg, []destination.Destination{destination.NewLocal(dir)}, logger)
+		require.NoError(t, err)
+		require.NoError(t, reporter.Run(context.Background(), now))
+
+		content, err := os.ReadFile(filepath.Join(dir, "edgecom-month-2024-11-01.pdf"))
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(content, []byte("%PDF")))
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, files, 1, "no temporary files are left behind")
+	})
+
+	t.Run("a failed destination does not stop the others", func(t *testing.T) {
+		other := filepath.Join(t.TempDir(), "other")
+		reporter, err := NewReporter(&fakeQuerier{}, cfg, []destination.Destination{failingDestination{}, destination.NewLocal(other)}, logger)
+		require.NoError(t, err)
+
+		assert.ErrorContains(t, reporter.Run(context.Background(), now), "disk full")
+		assert.FileExists(t, filepath.Join(other, "edgecom-month-2024-11-01.pdf"))
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		_, err := NewReporter(&fakeQuerier{}, Config{Period: "quarter", Location: time.UTC}, []destination.Destination{destination.NewLocal(dir)}, logger)
+		assert.ErrorContains(t, err, "invalid report period")
+
+		_, err = NewReporter(&fakeQuerier{}, cfg, nil, logger)
+		assert.ErrorContains(t, err, "destination is required")
+	})
+}
@@ -2,11 +2,26 @@
 //
 // The scheduler provides:
 //   - Configurable periodic data fetching using cron expressions
+//   - Catching up from the ingest watermark after missed or failed runs
+//   - Hourly repair of ranges that could not be ingested
+//   - Delaying collection while database writes are backed up
+//   - Checking budgets after each successful collection
+//   - Sending webhook events for completed and failed collection runs,
+//     and once collection has failed a number of times in a row
+//   - Generating summary reports on their own schedule
+//   - Pausing and resuming all scheduled work at runtime
+//   - Skipping collection while its ingestion source is disabled
+//   - Prometheus metrics of the duration and outcome of each run
 //   - Context-aware execution with timeout handling
 //   - Graceful shutdown support
+//   - Recovering jobs that panic, so a supervisor can restart the
+//     scheduler through Run
 //   - Structured logging of fetch operations
 //   - Error handling and recovery
 //
+// Data is ingested through an api.DataFetcher, so sources other than the
+// HTTP API can be scheduled the same way.
+//
 // Example Usage:
 //
 //	logger := logrus.New()
@@ -17,17 +32,24 @@
 //	    log.Fatalf("Failed to start scheduler: %v", err)
 //	}
 //
-//	defer scheduler.Stop()
+//	defer scheduler.Shutdown(ctx)
 package scheduler
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 
 	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
 )
 
 // Scheduler manages periodic data fetching operations.
@@ -36,31 +58,256 @@ import (
 
 type Scheduler struct {
 	ctx     context.Context
-	fetcher *api.SeriesFetcher
+	fetcher api.DataFetcher
 	logger  *logrus.Logger
 	cron    *cron.Cron
+
+	// collectID identifies the periodic collection job
+	collectID cron.EntryID
+
+	// pressure, if set, reports whether database writes are backed up,
+	// and is checked every backpressurePoll while a run is delayed
+	pressure         Pressure
+	backpressurePoll time.Duration
+	// budgets, if set, is checked after each successful collection
+	budgets BudgetChecker
+	// notifier, if set, is sent an event after each collection run
+	notifier Notifier
+	// sourceEnabled, if set, reports whether the source collection and
+	// gap repair fetch from is enabled
+	sourceEnabled func() bool
+	// failureThreshold is the number of consecutive failed runs that
+	// sends an ingestion.failing event
+	failureThreshold int
+	// reports, if set, is run on reportSchedule
+	reports        ReportRunner
+	reportSchedule string
+	// clock tells the end of the range each run collects up to
+	clock clock.Clock
+
+	// runs and durations, if set, count the runs of each job by result
+	// and observe how long they took
+	runs      *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+
+	// scheduled is set once the jobs are added to cron, so that starting
+	// again after a crash does not add them twice
+	scheduled bool
+	// crashed receives the first job panic since Run started
+	crashed chan error
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Status reports the scheduler's recent activity.
+type Status struct {
+	// Paused is true while scheduled runs are skipped
+	Paused bool `json:"paused"`
+	// Running is true while a collection run is in progress
+	Running bool `json:"running"`
+	// LastRun is when the most recent collection run started
+	LastRun time.Time `json:"last_run"`
+	// LastSuccess is when the most recent successful run finished
+	LastSuccess time.Time `json:"last_success"`
+	// LastError is the error from the most recent run, if it failed
+	LastError string `json:"last_error,omitempty"`
+	// Runs is the number of collection runs since startup
+	Runs int `json:"runs"`
+	// Failures is the number of failed collection runs since startup
+	Failures int `json:"failures"`
+	// ConsecutiveFailures is the number of runs that have failed since
+	// the last successful one
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// NextRun is when the next collection run is scheduled
+	NextRun time.Time `json:"next_run"`
+}
+
+// Pressure reports whether database writes are backed up.
+type Pressure interface {
+	Saturated() bool
 }
 
+// BudgetChecker reports budget thresholds reached by newly collected data.
+type BudgetChecker interface {
+	Check(ctx context.Context, now time.Time) error
+}
+
+// Notifier sends events to webhooks.
+type Notifier interface {
+	Notify(ctx context.Context, event webhook.Event)
+}
+
+// ReportRunner generates and delivers the report due at now.
+type ReportRunner interface {
+	Run(ctx context.Context, now time.Time) error
+}
+
+// DefaultFailureThreshold is the number of consecutive failed collection
+// runs that sends an ingestion.failing event, unless set otherwise
+const DefaultFailureThreshold = 3
+
+// reportTimeout bounds a report run
+const reportTimeout = 5 * time.Minute
+
+// collectWindow is the interval between collection runs, and the range
+// fetched by a run when no watermark has been recorded yet
+const collectWindow = 5 * time.Minute
+
+// Jobs, as reported in metrics
+const (
+	jobCollection = "collection"
+	jobGapRepair  = "gap_repair"
+	jobReport     = "report"
+)
+
+// Run results, as reported in metrics
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+	// resultSkipped is a collection run skipped while the upstream
+	// circuit breaker is open
+	resultSkipped = "skipped"
+)
+
+// Backpressure delays
+const (
+	// defaultBackpressurePoll is how often a delayed run checks the write
+	// queue
+	defaultBackpressurePoll = time.Second
+	// maxBackpressureDelay bounds how long a run is delayed before it
+	// fetches anyway and waits on the write queue itself
+	maxBackpressureDelay = 5 * time.Minute
+)
+
 // NewScheduler creates a new scheduler instance with the provided
 // context, data fetcher, and logger. The context can be used to
 // control the scheduler's lifecycle.
-func NewScheduler(ctx context.Context, fetcher *api.SeriesFetcher, logger *logrus.Logger) *Scheduler {
+func NewScheduler(ctx context.Context, fetcher api.DataFetcher, logger *logrus.Logger) *Scheduler {
 	return &Scheduler{
-		ctx:     ctx,
-		fetcher: fetcher,
-		logger:  logger,
-		cron:    cron.New(),
+		ctx:              ctx,
+		fetcher:          fetcher,
+		logger:           logger,
+		cron:             cron.New(),
+		failureThreshold: DefaultFailureThreshold,
+		backpressurePoll: defaultBackpressurePoll,
+		clock:            clock.System,
+		crashed:          make(chan error, 1),
 	}
 }
 
+// SetClock replaces the wall clock that runs collect and report up to,
+// and that events are stamped with. Runs are scheduled on clk, so with an
+// accelerated clock they come as often as the clock's schedule sets, not
+// the wall clock's. It must be called before Start.
+func (s *Scheduler) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// SetBackpressure makes collection runs wait, up to maxBackpressureDelay,
+// while pressure reports saturation, so fetched data is not piled up in
+// memory in front of a slow database. It must be called before Start.
+func (s *Scheduler) SetBackpressure(pressure Pressure) {
+	s.pressure = pressure
+}
+
+// SetBudgets makes each successful collection run check budgets. It must
+// be called before Start.
+func (s *Scheduler) SetBudgets(budgets BudgetChecker) {
+	s.budgets = budgets
+}
+
+// SetNotifier sends an ingestion event to notifier after each collection
+// run. Runs skipped while the upstream circuit breaker is open are not
+// reported. It must be called before Start.
+func (s *Scheduler) SetNotifier(notifier Notifier) {
+	s.notifier = notifier
+}
+
+// SetSourceEnabled makes collection and gap repair runs be skipped while
+// enabled reports that their ingestion source is disabled. Once it is
+// enabled again, collection resumes from the ingest watermark, which
+// enabling the source advances unless asked to catch up. It must be
+// called before Start.
+func (s *Scheduler) SetSourceEnabled(enabled func() bool) {
+	s.sourceEnabled = enabled
+}
+
+// SetFailureThreshold sets the number of consecutive failed collection
+// runs, including runs skipped while the upstream circuit breaker is
+// open, after which the notifier is sent an ingestion.failing event. It
+// is sent once per run of failures. It must be called before Start.
+func (s *Scheduler) SetFailureThreshold(threshold int) error {
+	if threshold < 1 {
+		return fmt.Errorf("failure threshold must be positive, got %d", threshold)
+	}
+	s.failureThreshold = threshold
+	return nil
+}
+
+// SetMetrics registers metrics of the runs of each job, by result, and of
+// their duration with reg. Runs skipped while paused or while the source
+// is disabled are not counted. It
+// must be called before Start.
+func (s *Scheduler) SetMetrics(reg prometheus.Registerer) error {
+	runs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edgecom_scheduler_runs_total",
+		Help: "Scheduled runs, by job and result",
+	}, []string{"job", "result"})
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "edgecom_scheduler_run_duration_seconds",
+		Help:    "Duration of scheduled runs, by job",
+		Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"job"})
+	for _, c := range []prometheus.Collector{runs, durations} {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register scheduler metric: %v", err)
+		}
+	}
+	s.runs = runs
+	s.durations = durations
+	return nil
+}
+
+// observeRun records a run of job that started at started and ended with
+// err in the metrics, if set
+func (s *Scheduler) observeRun(job string, started time.Time, err error) {
+	if s.runs == nil {
+		return
+	}
+	result := resultSuccess
+	switch {
+	case errors.Is(err, api.ErrCircuitOpen):
+		result = resultSkipped
+	case err != nil:
+		result = resultFailure
+	}
+	s.runs.WithLabelValues(job, result).Inc()
+	s.durations.WithLabelValues(job).Observe(time.Since(started).Seconds())
+}
+
+// SetReports runs reports on schedule, a standard five-field cron
+// expression optionally prefixed with CRON_TZ=<zone>. It must be called
+// before Start.
+func (s *Scheduler) SetReports(schedule string, reports ReportRunner) error {
+	if _, err := cron.ParseStandard(schedule); err != nil {
+		return fmt.Errorf("invalid report schedule %q: %w", schedule, err)
+	}
+	s.reports = reports
+	s.reportSchedule = schedule
+	return nil
+}
+
 // Start begins the scheduling of periodic data fetches.
 // It continues running until the context is canceled or an unrecoverable error occurs.
 func (s *Scheduler) Start() error {
 	s.logger.Info("Initializing scheduler with 5-minute intervals")
 
-	_, err := s.cron.AddFunc("@every 5m", s.collectData)
-	if err != nil {
-		return err
+	if !s.scheduled {
+		if err := s.schedule(); err != nil {
+			return err
+		}
+		s.scheduled = true
 	}
 
 	s.cron.Start()
@@ -68,29 +315,352 @@ func (s *Scheduler) Start() error {
 	return nil
 }
 
-// collectData fetches data from the API and stores it in the database
+// Run starts the scheduler and returns nil once ctx is done, leaving it
+// to be stopped with Shutdown. If a job panics, the panic is recovered,
+// the scheduler stops once its running jobs finish, and the panic is
+// returned as an error; calling Run again restarts it.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-s.crashed:
+		<-s.cron.Stop().Done()
+		// No job is left running, including the one that panicked
+		s.mu.Lock()
+		s.status.Running = false
+		s.status.LastError = err.Error()
+		s.mu.Unlock()
+		return err
+	}
+}
+
+// schedule adds the jobs to cron
+func (s *Scheduler) schedule() error {
+	// A run catching up after an outage may outlast the interval; the next
+	// run starts from the watermark it leaves behind instead of fetching
+	// the same range concurrently
+	collect := cron.NewChain(s.recoverPanics("collection"), cron.SkipIfStillRunning(cron.DiscardLogger)).Then(cron.FuncJob(s.collectData))
+	id, err := s.addJob(fmt.Sprintf("@every %s", collectWindow), collect)
+	if err != nil {
+		return err
+	}
+	s.collectID = id
+
+	if _, err := s.addJob("@hourly", s.recoverPanics("gap repair")(cron.FuncJob(s.repairGaps))); err != nil {
+		return err
+	}
+
+	if s.reports != nil {
+		if _, err := s.addJob(s.reportSchedule, s.recoverPanics("report")(cron.FuncJob(s.runReports))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverPanics wraps jobs so that a panic is logged and reported to Run
+// instead of crashing the process
+func (s *Scheduler) recoverPanics(name string) cron.JobWrapper {
+	return func(job cron.Job) cron.Job {
+		return cron.FuncJob(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err := fmt.Errorf("%s job panicked: %v", name, r)
+					s.logger.WithError(err).WithField("stack", string(debug.Stack())).Error("Scheduled job panicked")
+					select {
+					case s.crashed <- err:
+					default:
+					}
+				}
+			}()
+			job.Run()
+		})
+	}
+}
+
+// addJob schedules job on spec, on the scheduler's clock
+func (s *Scheduler) addJob(spec string, job cron.Job) (cron.EntryID, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return 0, err
+	}
+	if s.clock != clock.System {
+		schedule = &clockSchedule{schedule: schedule, clock: s.clock}
+	}
+	return s.cron.Schedule(schedule, job), nil
+}
+
+// clockSchedule runs a schedule on a clock other than the wall clock the
+// cron runner waits on
+type clockSchedule struct {
+	schedule cron.Schedule
+	clock    clock.Clock
+	// last is the latest time on the clock a run was scheduled for, so
+	// a run starting a little early by the clock is not scheduled again
+	last time.Time
+}
+
+// Next returns the wall time at which the clock reaches the next time of
+// the schedule
+func (c *clockSchedule) Next(wall time.Time) time.Time {
+	now := c.clock.Now()
+	from := now
+	if c.last.After(from) {
+		from = c.last
+	}
+	c.last = c.schedule.Next(from)
+	return wall.Add(clock.Wall(c.clock, c.last.Sub(now)))
+}
+
+// Pause makes scheduled collection, gap repair and report runs be skipped
+// until Resume is called. A run already in progress is not interrupted.
+func (s *Scheduler) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.status.Paused {
+		s.status.Paused = true
+		s.logger.Info("Scheduler paused")
+	}
+}
+
+// Resume lets scheduled runs start again after Pause. Data missed while
+// paused is collected by the next run, from the ingest watermark.
+func (s *Scheduler) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Paused {
+		s.status.Paused = false
+		s.logger.Info("Scheduler resumed")
+	}
+}
+
+// paused reports whether scheduled runs are being skipped
+func (s *Scheduler) paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.Paused
+}
+
+// sourceDisabled reports whether the ingestion source is disabled
+func (s *Scheduler) sourceDisabled() bool {
+	return s.sourceEnabled != nil && !s.sourceEnabled()
+}
+
+// collectData fetches data from the API, from the ingest watermark up to
+// the start of the run, and stores it in the database
 func (s *Scheduler) collectData() {
+	if s.paused() {
+		s.logger.Debug("Skipping data collection while paused")
+		return
+	}
+	if s.sourceDisabled() {
+		s.logger.Debug("Skipping data collection while its source is disabled")
+		return
+	}
 	s.logger.Info("Starting scheduled data collection")
 
+	// Fix the end of the range before any delay
+	endTime := s.clock.Now()
+
+	if !s.waitForWriteCapacity() {
+		s.logger.Info("Abandoning delayed data collection on shutdown")
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Minute)
 	defer cancel()
 
-	endTime := time.Now()
-	startTime := endTime.Add(-5 * time.Minute)
+	s.logger.WithField("endTime", endTime).Info("Fetching data")
 
-	s.logger.WithFields(logrus.Fields{
-		"startTime": startTime,
-		"endTime":   endTime,
-	}).Info("Fetching data")
+	s.mu.Lock()
+	s.status.Running = true
+	s.status.LastRun = endTime
+	s.status.Runs++
+	s.mu.Unlock()
 
-	if err := s.fetcher.FetchData(ctx, startTime, endTime); err != nil {
-		s.logger.WithError(err).Error("Failed to fetch data")
+	started := time.Now()
+	err := s.fetcher.CatchUp(ctx, endTime, collectWindow)
+	s.observeRun(jobCollection, started, err)
+
+	s.mu.Lock()
+	s.status.Running = false
+	if err != nil {
+		s.status.LastError = err.Error()
+		s.status.Failures++
+		s.status.ConsecutiveFailures++
 	} else {
+		s.status.LastError = ""
+		s.status.LastSuccess = s.clock.Now()
+		s.status.ConsecutiveFailures = 0
+	}
+	status := s.status
+	s.mu.Unlock()
+
+	switch {
+	case errors.Is(err, api.ErrCircuitOpen):
+		s.logger.Info("Skipping data collection while the upstream API is unavailable")
+	case err != nil:
+		s.logger.WithError(err).Error("Failed to fetch data")
+		s.notify(ctx, webhook.Event{
+			Type:     webhook.EventIngestionFailed,
+			Time:     s.clock.Now(),
+			Severity: webhook.SeverityError,
+			Summary:  "Scheduled data collection failed",
+			Fields:   map[string]interface{}{"end_time": endTime, "error": err.Error()},
+		})
+	default:
 		s.logger.Info("Successfully completed scheduled data collection")
+		s.notify(ctx, webhook.Event{
+			Type:     webhook.EventIngestionCompleted,
+			Time:     s.clock.Now(),
+			Severity: webhook.SeverityInfo,
+			Summary:  "Scheduled data collection completed",
+			Fields:   map[string]interface{}{"end_time": endTime},
+		})
+		s.checkBudgets(ctx, endTime)
+	}
+
+	if status.ConsecutiveFailures == s.failureThreshold {
+		s.logger.WithField("failures", status.ConsecutiveFailures).Error("Data collection keeps failing")
+		fields := map[string]interface{}{
+			"failures": status.ConsecutiveFailures,
+			"error":    status.LastError,
+		}
+		if !status.LastSuccess.IsZero() {
+			fields["last_success"] = status.LastSuccess
+		}
+		s.notify(ctx, webhook.Event{
+			Type:     webhook.EventIngestionFailing,
+			Time:     s.clock.Now(),
+			Severity: webhook.SeverityCritical,
+			Summary:  fmt.Sprintf("Data collection has failed %d times in a row", status.ConsecutiveFailures),
+			Fields:   fields,
+		})
+	}
+}
+
+// notify sends event to the notifier, if set. A run that timed out still
+// reports its failure.
+func (s *Scheduler) notify(ctx context.Context, event webhook.Event) {
+	if s.notifier == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), webhook.DefaultTimeout)
+		defer cancel()
+	}
+	s.notifier.Notify(ctx, event)
+}
+
+// checkBudgets reports budget thresholds reached by the collected data
+func (s *Scheduler) checkBudgets(ctx context.Context, now time.Time) {
+	if s.budgets == nil {
+		return
+	}
+	if err := s.budgets.Check(ctx, now); err != nil {
+		s.logger.WithError(err).Error("Failed to check budgets")
+	}
+}
+
+// waitForWriteCapacity blocks while the write queue is saturated, for at
+// most maxBackpressureDelay. It returns false, at once, if the scheduler's
+// context is done while it waits, so that a delayed run does not hold up
+// shutdown.
+func (s *Scheduler) waitForWriteCapacity() bool {
+	if s.pressure == nil || !s.pressure.Saturated() {
+		return true
+	}
+
+	start := time.Now()
+	s.logger.Warn("Database writes are backed up, delaying data collection")
+
+	ticker := time.NewTicker(s.backpressurePoll)
+	defer ticker.Stop()
+	deadline := time.NewTimer(maxBackpressureDelay)
+	defer deadline.Stop()
+
+	for s.pressure.Saturated() {
+		select {
+		case <-s.ctx.Done():
+			return false
+		case <-deadline.C:
+			s.logger.Warn("Database writes are still backed up, collecting data anyway")
+			return true
+		case <-ticker.C:
+		}
+	}
+
+	s.logger.WithField("delay", time.Since(start)).Info("Resuming delayed data collection")
+	return true
+}
+
+// repairGaps fetches ranges that earlier runs or the bootstrap could not
+// ingest
+func (s *Scheduler) repairGaps() {
+	if s.paused() || s.sourceDisabled() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Minute)
+	defer cancel()
+
+	started := time.Now()
+	err := s.fetcher.RepairGaps(ctx)
+	s.observeRun(jobGapRepair, started, err)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to repair gaps")
+	}
+}
+
+// runReports generates and delivers the report that is due
+func (s *Scheduler) runReports() {
+	if s.paused() {
+		s.logger.Info("Skipping report while the scheduler is paused")
+		return
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, reportTimeout)
+	defer cancel()
+
+	started := time.Now()
+	err := s.reports.Run(ctx, s.clock.Now())
+	s.observeRun(jobReport, started, err)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to generate report")
+	}
+}
+
+// Status returns a snapshot of the scheduler's recent activity
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	status := s.status
+	s.mu.Unlock()
+
+	status.NextRun = s.cron.Entry(s.collectID).Next
+	if !status.NextRun.IsZero() && s.clock != clock.System {
+		// Report the run by the clock it collects up to
+		status.NextRun = s.clock.Now().Add(clock.FromWall(s.clock, time.Until(status.NextRun)))
 	}
+	return status
 }
 
 // Stop the scheduler
 func (s *Scheduler) Stop() {
 	s.cron.Stop()
 }
+
+// Shutdown stops scheduling new runs and waits for running jobs to finish,
+// or until ctx is done.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	stopped := s.cron.Stop()
+
+	select {
+	case <-stopped.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,340 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/api/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+// budgetCounter counts budget checks
+type budgetCounter struct {
+	checks int
+}
+
+func (b *budgetCounter) Check(context.Context, time.Time) error {
+	b.checks++
+	return nil
+}
+
+// eventRecorder records the types of events sent to webhooks
+type eventRecorder struct {
+	types []string
+}
+
+func (e *eventRecorder) Notify(_ context.Context, event webhook.Event) {
+	e.types = append(e.types, event.Type)
+}
+
+// pressureOnce is saturated on its first check only
+type pressureOnce struct {
+	checks int
+}
+
+func (p *pressureOnce) Saturated() bool {
+	p.checks++
+	return p.checks == 1
+}
+
+// saturated is always saturated
+type saturated struct{}
+
+func (saturated) Saturated() bool { return true }
+
+func newTestScheduler(t *testing.T) (*Scheduler, *mocks.MockDataFetcher) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	fetcher := mocks.NewMockDataFetcher(gomock.NewController(t))
+	return NewScheduler(context.Background(), fetcher, logger), fetcher
+}
+
+func TestCollectData(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		s, fetcher := newTestScheduler(t)
+		budgets := &budgetCounter{}
+		s.SetBudgets(budgets)
+		pressure := &pressureOnce{}
+		s.SetBackpressure(pressure)
+		s.backpressurePoll = time.Millisecond
+		events := &eventRecorder{}
+		s.SetNotifier(events)
+		now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+		s.SetClock(clock.NewFake(now))
+
+		fetcher.EXPECT().CatchUp(gomock.Any(), now, collectWindow).Return(nil)
+		s.collectData()
+
+		status := s.Status()
+		assert.Equal(t, 1, status.Runs)
+		assert.Zero(t, status.Failures)
+		assert.False(t, status.Running)
+		assert.Equal(t, now, status.LastRun)
+		assert.Equal(t, now, status.LastSuccess)
+		assert.Equal(t, 1, budgets.checks)
+		assert.Equal(t, 2, pressure.checks, "the run waited for write capacity")
+		assert.Equal(t, []string{webhook.EventIngestionCompleted}, events.types)
+	})
+
+	t.Run("shutdown while delayed", func(t *testing.T) {
+		logger := logrus.New()
+		logger.SetLevel(logrus.PanicLevel)
+		ctx, cancel := context.WithCancel(context.Background())
+		// The fetcher is never called
+		s := NewScheduler(ctx, mocks.NewMockDataFetcher(gomock.NewController(t)), logger)
+		s.SetBackpressure(saturated{})
+
+		time.AfterFunc(10*time.Millisecond, cancel)
+		started := time.Now()
+		s.collectData()
+		assert.Less(t, time.Since(started), defaultBackpressurePoll)
+		assert.Zero(t, s.Status().Runs)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		s, fetcher := newTestScheduler(t)
+		budgets := &budgetCounter{}
+		s.SetBudgets(budgets)
+		events := &eventRecorder{}
+		s.SetNotifier(events)
+
+		fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+		s.collectData()
+
+		status := s.Status()
+		assert.Equal(t, 1, status.Failures)
+		assert.Equal(t, "connection refused", status.LastError)
+		assert.True(t, status.LastSuccess.IsZero())
+		assert.Zero(t, budgets.checks, "budgets are only checked after new data")
+		assert.Equal(t, []string{webhook.EventIngestionFailed}, events.types)
+	})
+
+	t.Run("error cleared by the next success", func(t *testing.T) {
+		s, fetcher := newTestScheduler(t)
+		events := &eventRecorder{}
+		s.SetNotifier(events)
+
+		gomock.InOrder(
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("wrapped: %w", api.ErrCircuitOpen)),
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil),
+		)
+		s.collectData()
+		s.collectData()
+
+		status := s.Status()
+		assert.Equal(t, 2, status.Runs)
+		assert.Equal(t, 1, status.Failures)
+		assert.Empty(t, status.LastError)
+		assert.Equal(t, []string{webhook.EventIngestionCompleted}, events.types, "skipped runs are not reported")
+	})
+
+	t.Run("repeated failures", func(t *testing.T) {
+		s, fetcher := newTestScheduler(t)
+		events := &eventRecorder{}
+		s.SetNotifier(events)
+		assert.Error(t, s.SetFailureThreshold(0))
+		assert.NoError(t, s.SetFailureThreshold(2))
+
+		failure := errors.New("connection refused")
+		gomock.InOrder(
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("wrapped: %w", api.ErrCircuitOpen)),
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(failure),
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(failure),
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil),
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(failure),
+			fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(failure),
+		)
+		for i := 0; i < 3; i++ {
+			s.collectData()
+		}
+		assert.Equal(t, 3, s.Status().ConsecutiveFailures)
+		assert.Equal(t, []string{
+			webhook.EventIngestionFailed,
+			webhook.EventIngestionFailing, // circuit breaker skips count as failures
+			webhook.EventIngestionFailed,
+		}, events.types, "sent once per run of failures")
+
+		s.collectData()
+		assert.Zero(t, s.Status().ConsecutiveFailures)
+
+		events.types = nil
+		s.collectData()
+		s.collectData()
+		assert.Equal(t, []string{
+			webhook.EventIngestionFailed,
+			webhook.EventIngestionFailed,
+			webhook.EventIngestionFailing,
+		}, events.types, "sent again after a success")
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	s, fetcher := newTestScheduler(t)
+	reg := prometheus.NewRegistry()
+	assert.NoError(t, s.SetMetrics(reg))
+
+	gomock.InOrder(
+		fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil),
+		fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("connection refused")),
+		fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.ErrCircuitOpen),
+	)
+	fetcher.EXPECT().RepairGaps(gomock.Any()).Return(nil)
+	s.collectData()
+	s.collectData()
+	s.collectData()
+	s.repairGaps()
+	s.Pause()
+	s.collectData()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.runs.WithLabelValues(jobCollection, resultSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.runs.WithLabelValues(jobCollection, resultFailure)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.runs.WithLabelValues(jobCollection, resultSkipped)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.runs.WithLabelValues(jobGapRepair, resultSuccess)))
+	assert.Equal(t, 2, testutil.CollectAndCount(s.durations), "one series per job that ran")
+}
+
+func TestRepairGaps(t *testing.T) {
+	s, fetcher := newTestScheduler(t)
+
+	fetcher.EXPECT().RepairGaps(gomock.Any()).Return(errors.New("1 of 2 gaps could not be repaired"))
+	s.repairGaps()
+}
+
+// reportCounter counts report runs
+type reportCounter struct {
+	runs int
+}
+
+func (r *reportCounter) Run(context.Context, time.Time) error {
+	r.runs++
+	return nil
+}
+
+func TestSetReports(t *testing.T) {
+	s, _ := newTestScheduler(t)
+	reports := &reportCounter{}
+
+	assert.ErrorContains(t, s.SetReports("every month", reports), "invalid report schedule")
+	assert.NoError(t, s.SetReports("CRON_TZ=Europe/Berlin 0 6 1 * *", reports))
+
+	s.runReports()
+	assert.Equal(t, 1, reports.runs)
+
+	assert.NoError(t, s.Start())
+	assert.Len(t, s.cron.Entries(), 3)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestPause(t *testing.T) {
+	s, fetcher := newTestScheduler(t)
+	reports := &reportCounter{}
+	assert.NoError(t, s.SetReports("@daily", reports))
+
+	// Paused runs reach neither the fetcher nor the reports
+	s.Pause()
+	assert.True(t, s.Status().Paused)
+	s.collectData()
+	s.repairGaps()
+	s.runReports()
+	assert.Zero(t, s.Status().Runs)
+	assert.Zero(t, reports.runs)
+
+	s.Resume()
+	assert.False(t, s.Status().Paused)
+	fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), collectWindow)
+	s.collectData()
+	assert.Equal(t, 1, s.Status().Runs)
+}
+
+func TestSourceDisabled(t *testing.T) {
+	s, fetcher := newTestScheduler(t)
+	reports := &reportCounter{}
+	assert.NoError(t, s.SetReports("@daily", reports))
+	enabled := false
+	s.SetSourceEnabled(func() bool { return enabled })
+
+	// Only the runs fetching from the source are skipped
+	s.collectData()
+	s.repairGaps()
+	s.runReports()
+	assert.Zero(t, s.Status().Runs)
+	assert.Equal(t, 1, reports.runs)
+
+	enabled = true
+	fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), collectWindow)
+	s.collectData()
+	assert.Equal(t, 1, s.Status().Runs)
+}
+
+func TestStartAndShutdown(t *testing.T) {
+	s, _ := newTestScheduler(t)
+
+	assert.NoError(t, s.Start())
+	assert.WithinDuration(t, time.Now().Add(collectWindow), s.Status().NextRun, time.Minute)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestClockSchedule(t *testing.T) {
+	s, _ := newTestScheduler(t)
+	start := time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+	s.SetClock(clock.NewVirtual(start, 60))
+
+	assert.NoError(t, s.Start())
+	// A five-minute run comes every five wall seconds
+	assert.WithinDuration(t, time.Now().Add(5*time.Second), s.cron.Entry(s.collectID).Next, time.Second)
+	assert.WithinDuration(t, start.Add(collectWindow), s.Status().NextRun, time.Minute)
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	t.Run("runs starting early are not repeated", func(t *testing.T) {
+		clk := clock.NewFake(start.Add(-100 * time.Millisecond))
+		hourly, err := cron.ParseStandard("@hourly")
+		assert.NoError(t, err)
+		schedule := &clockSchedule{schedule: hourly, clock: clk}
+
+		wall := time.Now()
+		assert.Equal(t, wall.Add(100*time.Millisecond), schedule.Next(wall))
+		clk.Advance(50 * time.Millisecond)
+		assert.Equal(t, wall.Add(time.Hour+50*time.Millisecond), schedule.Next(wall))
+	})
+}
+
+func TestRunRecoversPanics(t *testing.T) {
+	s, fetcher := newTestScheduler(t)
+	fetcher.EXPECT().CatchUp(gomock.Any(), gomock.Any(), collectWindow).Do(func(context.Context, time.Time, time.Duration) {
+		panic("nil map")
+	})
+
+	// Run starts the scheduler if it is not started yet
+	assert.NoError(t, s.Start())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	s.cron.Entry(s.collectID).WrappedJob.Run()
+	assert.EqualError(t, <-done, "collection job panicked: nil map")
+	status := s.Status()
+	assert.False(t, status.Running)
+	assert.Equal(t, "collection job panicked: nil map", status.LastError)
+
+	// Running again keeps the jobs scheduled once
+	go func() { done <- s.Run(ctx) }()
+	assert.Eventually(t, func() bool { return s.cron.Entry(s.collectID).Next.After(time.Now()) }, time.Second, time.Millisecond)
+	assert.Len(t, s.cron.Entries(), 2)
+	cancel()
+	assert.NoError(t, <-done)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
@@ -0,0 +1,214 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/sigv4"
+)
+
+// Provider looks up secrets in a secret store by a reference whose form
+// depends on the store.
+type Provider interface {
+	Lookup(ctx context.Context, ref string) (string, error)
+}
+
+// FileProvider reads secrets from files, such as Kubernetes and Docker
+// secrets mounted in the container. The reference is the path of the
+// file, whose content has surrounding whitespace removed and must not be
+// empty.
+type FileProvider struct{}
+
+// Lookup reads the secret file at path.
+func (FileProvider) Lookup(_ context.Context, path string) (string, error) {
+	return read(path)
+}
+
+// Vault reads secrets from a HashiCorp Vault server over its HTTP API.
+// The reference is the path of a secret, as in the API, and the key of
+// the value within it: "secret/data/edgecom#password" for a KV version 2
+// engine mounted at secret/, or "kv/edgecom#password" for version 1.
+type Vault struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+// NewVault creates a provider for the Vault server at address, such as
+// "https://vault.example.com:8200", authenticating with token. namespace
+// is only needed for Vault Enterprise namespaces.
+func NewVault(address, token, namespace string) (*Vault, error) {
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid vault address %q", address)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("a vault token is required")
+	}
+	return &Vault{
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Lookup reads the value of a key of a secret.
+func (v *Vault) Lookup(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("invalid vault reference %q, expected path#key", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if len(body.Errors) > 0 {
+			return "", fmt.Errorf("failed to read vault secret %s: %s: %s", path, resp.Status, strings.Join(body.Errors, "; "))
+		}
+		return "", fmt.Errorf("failed to read vault secret %s: %s", path, resp.Status)
+	}
+	if decodeErr != nil {
+		return "", fmt.Errorf("failed to decode vault secret %s: %w", path, decodeErr)
+	}
+
+	data := body.Data
+	// KV version 2 nests the values with the secret's metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string key %q", path, key)
+	}
+	return value, nil
+}
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager. The reference
+// is the name or ARN of a secret, whose string value is returned, and
+// optionally the key of a value within it when it holds a JSON object:
+// "edgecom/database#password".
+type AWSSecretsManager struct {
+	endpoint    string
+	region      string
+	credentials sigv4.Credentials
+	client      *http.Client
+	now         func() time.Time
+}
+
+// NewAWSSecretsManager creates a provider for Secrets Manager in region,
+// signing requests with creds. Without an endpoint, the regional AWS
+// endpoint is used.
+func NewAWSSecretsManager(region, endpoint string, creds sigv4.Credentials) (*AWSSecretsManager, error) {
+	if region == "" {
+		return nil, fmt.Errorf("an aws region is required")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid secrets manager endpoint %q", endpoint)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("an aws access key ID and secret access key are required")
+	}
+	return &AWSSecretsManager{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/",
+		region:      region,
+		credentials: creds,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		now:         time.Now,
+	}, nil
+}
+
+// Lookup reads the value of a secret, or of a key within it.
+func (a *AWSSecretsManager) Lookup(ctx context.Context, ref string) (string, error) {
+	name, key, hasKey := strings.Cut(ref, "#")
+	if name == "" || (hasKey && key == "") {
+		return "", fmt.Errorf("invalid secrets manager reference %q, expected name or name#key", ref)
+	}
+
+	payload, err := json.Marshal(map[string]string{"SecretId": name})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sum := sha256.Sum256(payload)
+	sigv4.Sign(req, a.credentials, a.region, "secretsmanager", hex.EncodeToString(sum[:]), a.now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		SecretString *string `json:"SecretString"`
+		Type         string  `json:"__type"`
+		Message      string  `json:"message"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if body.Type != "" {
+			// Types may be qualified, as in "com.amazonaws...#ResourceNotFoundException"
+			errType := body.Type[strings.LastIndex(body.Type, "#")+1:]
+			return "", fmt.Errorf("failed to read secret %s: %s: %s: %s", name, resp.Status, errType, body.Message)
+		}
+		return "", fmt.Errorf("failed to read secret %s: %s", name, resp.Status)
+	}
+	if decodeErr != nil {
+		return "", fmt.Errorf("failed to decode secret %s: %w", name, decodeErr)
+	}
+	if body.SecretString == nil {
+		return "", fmt.Errorf("secret %s has no string value", name)
+	}
+	if !hasKey {
+		return *body.SecretString, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(*body.SecretString), &values); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %w", name, err)
+	}
+	value, ok := values[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string key %q", name, key)
+	}
+	return value, nil
+}
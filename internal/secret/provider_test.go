@@ -0,0 +1,124 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/sigv4"
+)
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db_password")
+	require.NoError(t, os.WriteFile(path, []byte("s3cret\n"), 0o600))
+
+	value, err := FileProvider{}.Lookup(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+
+	_, err = FileProvider{}.Lookup(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/edgecom":
+			w.Write([]byte(`{"data":{"data":{"password":"v2-secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv/edgecom":
+			w.Write([]byte(`{"data":{"password":"v1-secret","port":5432}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	vault, err := NewVault(server.URL, "root", "team")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	value, err := vault.Lookup(ctx, "secret/data/edgecom#password")
+	require.NoError(t, err)
+	assert.Equal(t, "v2-secret", value)
+
+	value, err = vault.Lookup(ctx, "/kv/edgecom#password")
+	require.NoError(t, err)
+	assert.Equal(t, "v1-secret", value)
+
+	_, err = vault.Lookup(ctx, "kv/edgecom#port")
+	assert.EqualError(t, err, `vault secret kv/edgecom has no string key "port"`)
+	_, err = vault.Lookup(ctx, "kv/missing#password")
+	assert.EqualError(t, err, "failed to read vault secret kv/missing: 404 Not Found")
+	_, err = vault.Lookup(ctx, "kv/edgecom")
+	assert.ErrorContains(t, err, "expected path#key")
+
+	denied, err := NewVault(server.URL, "other", "")
+	require.NoError(t, err)
+	_, err = denied.Lookup(ctx, "kv/edgecom#password")
+	assert.EqualError(t, err, "failed to read vault secret kv/edgecom: 403 Forbidden: permission denied")
+
+	_, err = NewVault("vault:8200", "root", "")
+	assert.Error(t, err)
+	_, err = NewVault(server.URL, "", "")
+	assert.Error(t, err)
+}
+
+func TestAWSSecretsManager(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		var body struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.SecretId {
+		case "edgecom/api-token":
+			w.Write([]byte(`{"Name":"edgecom/api-token","SecretString":"token"}`))
+		case "edgecom/database":
+			w.Write([]byte(`{"Name":"edgecom/database","SecretString":"{\"username\":\"edgecom\",\"password\":\"db-secret\"}"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`))
+		}
+	}))
+	defer server.Close()
+
+	sm, err := NewAWSSecretsManager("eu-central-1", server.URL, sigv4.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	value, err := sm.Lookup(ctx, "edgecom/api-token")
+	require.NoError(t, err)
+	assert.Equal(t, "token", value)
+	assert.Equal(t, http.MethodPost, got.Method)
+	assert.Equal(t, "secretsmanager.GetSecretValue", got.Header.Get("X-Amz-Target"))
+	assert.Contains(t, got.Header.Get("Authorization"), "Credential=AKIDEXAMPLE/")
+	assert.Contains(t, got.Header.Get("Authorization"), "/eu-central-1/secretsmanager/aws4_request")
+
+	value, err = sm.Lookup(ctx, "edgecom/database#password")
+	require.NoError(t, err)
+	assert.Equal(t, "db-secret", value)
+
+	_, err = sm.Lookup(ctx, "edgecom/database#host")
+	assert.EqualError(t, err, `secret edgecom/database has no string key "host"`)
+	_, err = sm.Lookup(ctx, "edgecom/api-token#password")
+	assert.ErrorContains(t, err, "not a JSON object")
+	_, err = sm.Lookup(ctx, "edgecom/missing")
+	assert.EqualError(t, err, "failed to read secret edgecom/missing: 400 Bad Request: ResourceNotFoundException: Secrets Manager can't find the specified secret.")
+
+	_, err = NewAWSSecretsManager("", "", sigv4.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"})
+	assert.Error(t, err)
+	_, err = NewAWSSecretsManager("eu-central-1", "", sigv4.Credentials{})
+	assert.Error(t, err)
+}
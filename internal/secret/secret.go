@@ -0,0 +1,178 @@
+// Package secret reads credentials from files and keeps them current as
+// the files are rotated, so outbound integrations pick up new credentials
+// without a restart.
+//
+// Orchestrators such as Kubernetes update mounted secrets in place, and
+// agents such as Vault Agent rewrite credential files before the old ones
+// expire. A Watcher polls its files and, when the content of one changes,
+// swaps in the new value and calls the file's change handlers, which can
+// drop connections established with the old credentials. A file that
+// cannot be read or is empty keeps its last value, so a rotation caught
+// half-written does not break running integrations.
+//
+// Providers look up secrets once, such as when the configuration is
+// loaded: FileProvider reads mounted secret files, Vault reads a HashiCorp
+// Vault server and AWSSecretsManager reads AWS Secrets Manager.
+//
+// Example Usage:
+//
+//	watcher := secret.NewWatcher(10*time.Second, logger)
+//	token, err := watcher.Add("/var/run/secrets/edgecom/api-token")
+//	if err != nil {
+//	    return err
+//	}
+//	token.OnChange(client.CloseIdleConnections)
+//	go watcher.Run(ctx)
+//
+//	req.Header.Set("Authorization", "Bearer "+token.Value())
+package secret
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInterval is how often files are checked for changes when the
+// watcher is not given an interval
+const DefaultInterval = 10 * time.Second
+
+// File is a secret read from a file. Its value has surrounding whitespace
+// removed, such as the trailing newline most tools write.
+type File struct {
+	path string
+
+	mu       sync.RWMutex
+	value    string
+	handlers []func()
+}
+
+// Path returns the path of the file.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Value returns the current content of the file.
+func (f *File) Value() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.value
+}
+
+// OnChange registers fn to be called after the value changes. It must be
+// called before the watcher runs.
+func (f *File) OnChange(fn func()) {
+	f.handlers = append(f.handlers, fn)
+}
+
+// reload re-reads the file and reports whether its value changed
+func (f *File) reload() (bool, error) {
+	value, err := read(f.path)
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if value == f.value {
+		return false, nil
+	}
+	f.value = value
+	return true, nil
+}
+
+// read returns the trimmed content of a secret file, which must not be
+// empty
+func read(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return value, nil
+}
+
+// Watcher keeps a set of secret files current.
+type Watcher struct {
+	interval time.Duration
+	logger   *logrus.Logger
+
+	mu    sync.Mutex
+	files []*File
+}
+
+// NewWatcher creates a watcher checking its files every interval, or
+// DefaultInterval when interval is zero.
+func NewWatcher(interval time.Duration, logger *logrus.Logger) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Watcher{interval: interval, logger: logger}
+}
+
+// Add reads the secret file at path and watches it for changes. Adding a
+// path twice returns the same File. It fails if the file cannot be read
+// or is empty.
+func (w *Watcher) Add(path string) (*File, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, f := range w.files {
+		if f.path == path {
+			return f, nil
+		}
+	}
+
+	value, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{path: path, value: value}
+	w.files = append(w.files, f)
+	return f, nil
+}
+
+// Run checks the files for changes until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check reloads every file, calling the handlers of those that changed
+func (w *Watcher) check() {
+	w.mu.Lock()
+	files := append([]*File(nil), w.files...)
+	w.mu.Unlock()
+
+	for _, f := range files {
+		changed, err := f.reload()
+		if err != nil {
+			w.logger.WithError(err).WithField("path", f.path).Warn("Failed to reload secret, keeping the previous value")
+			continue
+		}
+		if !changed {
+			continue
+		}
+		w.logger.WithField("path", f.path).Info("Secret rotated")
+		for _, fn := range f.handlers {
+			fn()
+		}
+	}
+}
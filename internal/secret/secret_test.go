@@ -0,0 +1,75 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	w := NewWatcher(0, logger)
+	f, err := w.Add(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first", f.Value())
+
+	again, err := w.Add(path)
+	require.NoError(t, err)
+	assert.Same(t, f, again)
+
+	changes := 0
+	f.OnChange(func() { changes++ })
+
+	t.Run("unchanged files do not notify", func(t *testing.T) {
+		w.check()
+		assert.Equal(t, 0, changes)
+	})
+
+	t.Run("rotation swaps the value", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("second\n"), 0o600))
+		w.check()
+		assert.Equal(t, "second", f.Value())
+		assert.Equal(t, 1, changes)
+	})
+
+	t.Run("unreadable files keep the previous value", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+		w.check()
+		assert.Equal(t, "second", f.Value())
+
+		require.NoError(t, os.Remove(path))
+		w.check()
+		assert.Equal(t, "second", f.Value())
+		assert.Equal(t, 1, changes)
+	})
+
+	t.Run("symlink swaps are followed", func(t *testing.T) {
+		// Kubernetes rotates mounted secrets by replacing a symlink
+		target := filepath.Join(dir, "token-v3")
+		require.NoError(t, os.WriteFile(target, []byte("third"), 0o600))
+		require.NoError(t, os.Symlink(target, path))
+		w.check()
+		assert.Equal(t, "third", f.Value())
+		assert.Equal(t, 2, changes)
+	})
+
+	t.Run("missing and empty files are rejected", func(t *testing.T) {
+		_, err := w.Add(filepath.Join(dir, "missing"))
+		assert.Error(t, err)
+
+		empty := filepath.Join(dir, "empty")
+		require.NoError(t, os.WriteFile(empty, []byte("\n"), 0o600))
+		_, err = w.Add(empty)
+		assert.ErrorContains(t, err, "is empty")
+	})
+}
@@ -0,0 +1,172 @@
+// Package sigv4 signs HTTP requests to AWS and compatible APIs, such as S3
+// and Secrets Manager, with AWS Signature Version 4.
+//
+// Example Usage:
+//
+//	creds, err := sigv4.ParseCredentials(content)
+//	if err != nil {
+//	    return err
+//	}
+//	sum := sha256.Sum256(body)
+//	sigv4.Sign(req, creds, "eu-central-1", "s3", hex.EncodeToString(sum[:]), time.Now())
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials are an access key with an optional session token.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// ParseCredentials reads the default profile of an AWS shared credentials
+// file. Keys before the first section are read too, for files holding a
+// single key.
+func ParseCredentials(content string) (Credentials, error) {
+	var creds Credentials
+	section := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != "" && section != "default" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("credentials file has no aws_access_key_id and aws_secret_access_key in its default profile")
+	}
+	return creds, nil
+}
+
+// Sign adds the X-Amz-Date, X-Amz-Content-Sha256, session token and
+// Authorization headers to req, signing it at now for service in region.
+// payloadHash is the hex-encoded SHA-256 of the body. The host,
+// Content-Type and X-Amz-* headers are signed; the path is signed as
+// URIEncode encodes it, so req.URL.RawPath should be set to match.
+func Sign(req *http.Request, creds Credentials, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		URIEncode(req.URL.Path),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signature := hex.EncodeToString(hmacSHA256(signingKey(creds.SecretAccessKey, date, region, service), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string of req with its parameters
+// sorted and encoded
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, encode(key, false)+"="+encode(value, false))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// signingKey derives the Signature Version 4 key for a day, region and
+// service
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// URIEncode percent-encodes every byte of a path except slashes and the
+// unreserved characters.
+func URIEncode(s string) string {
+	return encode(s, true)
+}
+
+// encode percent-encodes every byte of s except the unreserved characters
+// and, if keepSlash is set, slashes
+func encode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
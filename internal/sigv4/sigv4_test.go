@@ -0,0 +1,84 @@
+package sigv4
+
+import (
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sharedCredentials = `# written by vault agent
+[other]
+aws_access_key_id = OTHER
+
+[default]
+aws_access_key_id = AKIDROTATED
+aws_secret_access_key = rotated-secret
+aws_session_token = session
+`
+
+func TestParseCredentials(t *testing.T) {
+	creds, err := ParseCredentials(sharedCredentials)
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{AccessKeyID: "AKIDROTATED", SecretAccessKey: "rotated-secret", SessionToken: "session"}, creds)
+
+	creds, err = ParseCredentials("aws_access_key_id=AKID\naws_secret_access_key=secret")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, creds)
+
+	_, err = ParseCredentials("aws_access_key_id = AKID")
+	assert.Error(t, err)
+}
+
+func TestSigningKey(t *testing.T) {
+	// Example from the AWS Signature Version 4 documentation
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
+
+func TestSign(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://secretsmanager.eu-central-1.amazonaws.com/?b=2&a=x%2Fy", nil)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	req.Header.Set("User-Agent", "edgecom")
+
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	Sign(req, Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"},
+		"eu-central-1", "secretsmanager", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", now)
+
+	assert.Equal(t, "20241123T120000Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "session", req.Header.Get("X-Amz-Security-Token"))
+	assert.Regexp(t, `^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20241123/eu-central-1/secretsmanager/aws4_request, `+
+		`SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token;x-amz-target, Signature=[0-9a-f]{64}$`,
+		req.Header.Get("Authorization"))
+
+	assert.Equal(t, "a=x%2Fy&b=2", canonicalQuery(req))
+}
+
+func TestSignExtraHeaders(t *testing.T) {
+	// Headers set out of order must be signed in sorted order, or the
+	// store computes a different signature. The signature was computed
+	// independently of Sign.
+	req, err := http.NewRequest(http.MethodPut, "https://edgecom.s3.eu-central-1.amazonaws.com/exports/data.csv", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Amz-Storage-Class", "STANDARD_IA")
+	req.Header.Set("X-Amz-Meta-Site", "berlin")
+	req.Header.Set("Content-Type", "text/csv")
+
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	Sign(req, Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"},
+		"eu-central-1", "s3", "327a70357b1be136ba852447df60e110f11ab45606764cec2518b46dbfe38af4", now)
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20241123/eu-central-1/s3/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-meta-site;x-amz-storage-class, "+
+		"Signature=937df36dcc39b47acd15285767323706841dd640aae3ed16d1fbca083236260a",
+		req.Header.Get("Authorization"))
+}
+
+func TestURIEncode(t *testing.T) {
+	assert.Equal(t, "/edgecom/november%20report%2B1.pdf", URIEncode("/edgecom/november report+1.pdf"))
+}
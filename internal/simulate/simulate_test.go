@@ -0,0 +1,67 @@
+package simulate
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/clock"
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestFetcher(t *testing.T) {
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTimeSeriesRepository(ctrl)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	fetcher := NewFetcher(NewSynthetic(SyntheticConfig{}), repo, logger)
+	fetcher.SetClock(clock.NewFake(now))
+	require.NoError(t, fetcher.SetHistory(24*time.Hour))
+	assert.Error(t, fetcher.SetHistory(0))
+
+	// stored records the points inserted
+	var stored []models.TimeSeriesData
+	repo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, points []models.TimeSeriesData) error {
+			assert.LessOrEqual(t, len(points), insertChunkSize)
+			stored = append(stored, points...)
+			return nil
+		}).AnyTimes()
+
+	t.Run("bootstrap", func(t *testing.T) {
+		repo.EXPECT().AdvanceWatermark(gomock.Any(), now)
+		require.NoError(t, fetcher.BootstrapHistoricalData(context.Background(), api.DefaultBootstrapPolicy()))
+		require.Len(t, stored, 24*60)
+		assert.Equal(t, now.Add(-24*time.Hour), stored[0].Time)
+		assert.Equal(t, now.Add(-time.Minute), stored[len(stored)-1].Time)
+	})
+
+	t.Run("catch up from the watermark", func(t *testing.T) {
+		stored = nil
+		repo.EXPECT().Watermark(gomock.Any()).Return(now, nil)
+		repo.EXPECT().AdvanceWatermark(gomock.Any(), now.Add(5*time.Minute))
+		require.NoError(t, fetcher.CatchUp(context.Background(), now.Add(5*time.Minute), 5*time.Minute))
+		require.Len(t, stored, 5)
+		assert.Equal(t, now, stored[0].Time)
+	})
+
+	t.Run("catch up without a watermark", func(t *testing.T) {
+		stored = nil
+		repo.EXPECT().Watermark(gomock.Any()).Return(time.Time{}, nil)
+		repo.EXPECT().AdvanceWatermark(gomock.Any(), now)
+		require.NoError(t, fetcher.CatchUp(context.Background(), now, 10*time.Minute))
+		assert.Len(t, stored, 10)
+	})
+
+	assert.NoError(t, fetcher.RepairGaps(context.Background()))
+}
@@ -0,0 +1,192 @@
+package simulate
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Synthetic defaults
+const (
+	DefaultStep        = time.Minute
+	DefaultBase        = 40.0
+	DefaultAmplitude   = 30.0
+	DefaultNoise       = 2.0
+	DefaultSpikeFactor = 3.0
+)
+
+// weekendFactor scales the daily curve on Saturdays and Sundays
+const weekendFactor = 0.7
+
+// SyntheticConfig shapes a synthetic series. Zero fields take the
+// defaults noted on each.
+type SyntheticConfig struct {
+	// Step is the interval between points (default DefaultStep)
+	Step time.Duration
+	// Base is the overnight load (default DefaultBase)
+	Base float64
+	// Amplitude is how far the afternoon peak rises above Base (default
+	// DefaultAmplitude), less at weekends
+	Amplitude float64
+	// Noise is the largest random deviation of a point (default
+	// DefaultNoise)
+	Noise float64
+	// SpikeProbability is the probability of a point being a spike; there
+	// are no spikes when zero
+	SpikeProbability float64
+	// SpikeFactor multiplies the value of spikes (default
+	// DefaultSpikeFactor)
+	SpikeFactor float64
+	// Seed varies the noise and spikes between simulations
+	Seed int64
+}
+
+// Validate checks that the configuration's values are usable.
+func (c SyntheticConfig) Validate() error {
+	if c.Step < 0 {
+		return fmt.Errorf("step must not be negative")
+	}
+	if c.Base < 0 || c.Amplitude < 0 || c.Noise < 0 {
+		return fmt.Errorf("base, amplitude and noise must not be negative")
+	}
+	if c.SpikeProbability < 0 || c.SpikeProbability > 1 {
+		return fmt.Errorf("spike probability must be between 0 and 1, got %g", c.SpikeProbability)
+	}
+	if c.SpikeFactor < 0 {
+		return fmt.Errorf("spike factor must not be negative")
+	}
+	return nil
+}
+
+// withDefaults fills zero fields
+func (c SyntheticConfig) withDefaults() SyntheticConfig {
+	if c.Step == 0 {
+		c.Step = DefaultStep
+	}
+	if c.Base == 0 {
+		c.Base = DefaultBase
+	}
+	if c.Amplitude == 0 {
+		c.Amplitude = DefaultAmplitude
+	}
+	if c.Noise == 0 {
+		c.Noise = DefaultNoise
+	}
+	if c.SpikeFactor == 0 {
+		c.SpikeFactor = DefaultSpikeFactor
+	}
+	return c
+}
+
+// Synthetic is a Source generating a load curve that is lowest at 04:00
+// and peaks at 16:00 UTC, with noise and spikes derived from the time of
+// each point, so that points do not change when read again.
+type Synthetic struct {
+	cfg SyntheticConfig
+}
+
+// NewSynthetic creates a synthetic source. cfg must be valid.
+func NewSynthetic(cfg SyntheticConfig) *Synthetic {
+	return &Synthetic{cfg: cfg.withDefaults()}
+}
+
+// Points returns a point at every multiple of the step in [start, end).
+func (s *Synthetic) Points(start, end time.Time) []models.TimeSeriesData {
+	t := start.Truncate(s.cfg.Step)
+	if t.Before(start) {
+		t = t.Add(s.cfg.Step)
+	}
+
+	var points []models.TimeSeriesData
+	for ; t.Before(end); t = t.Add(s.cfg.Step) {
+		points = append(points, models.TimeSeriesData{Time: t, Value: s.value(t)})
+	}
+	return points
+}
+
+// value is the value of the point at t
+func (s *Synthetic) value(t time.Time) float64 {
+	t = t.UTC()
+	hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	curve := (1 - math.Cos(2*math.Pi*(hour-4)/24)) / 2
+	if day := t.Weekday(); day == time.Saturday || day == time.Sunday {
+		curve *= weekendFactor
+	}
+
+	value := s.cfg.Base + s.cfg.Amplitude*curve + s.cfg.Noise*(2*s.random(t, 0)-1)
+	if s.random(t, 1) < s.cfg.SpikeProbability {
+		value *= s.cfg.SpikeFactor
+	}
+	return math.Max(value, 0)
+}
+
+// random returns a number in [0, 1) determined by the seed, t and salt,
+// using the SplitMix64 finalizer
+func (s *Synthetic) random(t time.Time, salt uint64) float64 {
+	x := uint64(s.cfg.Seed) ^ uint64(t.UnixNano()) ^ salt*0x9e3779b97f4a7c15
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// Replay is a Source serving a recorded series. The recording repeats
+// every period of the whole days it covers, so that daily patterns stay
+// in place: times before and after it are served the recorded points of
+// the same time of day.
+type Replay struct {
+	points []models.TimeSeriesData
+	period time.Duration
+}
+
+// NewReplay creates a source replaying the points decoder reads from r.
+func NewReplay(r io.Reader, decoder api.Decoder) (*Replay, error) {
+	var points []models.TimeSeriesData
+	err := decoder.Decode(r, func(point models.TimeSeriesData) error {
+		points = append(points, point)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("recording has no points")
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	const day = 24 * time.Hour
+	span := points[len(points)-1].Time.Sub(points[0].Time)
+	return &Replay{points: points, period: (span/day + 1) * day}, nil
+}
+
+// Points returns the recorded points falling in [start, end), shifted by
+// whole periods.
+func (r *Replay) Points(start, end time.Time) []models.TimeSeriesData {
+	first := r.points[0].Time
+	since := start.Sub(first)
+	repeat := since / r.period
+	if since < 0 && since%r.period != 0 {
+		repeat--
+	}
+
+	var points []models.TimeSeriesData
+	for ; ; repeat++ {
+		shift := repeat * r.period
+		if !first.Add(shift).Before(end) {
+			return points
+		}
+		i := sort.Search(len(r.points), func(i int) bool {
+			return !r.points[i].Time.Add(shift).Before(start)
+		})
+		for ; i < len(r.points) && r.points[i].Time.Add(shift).Before(end); i++ {
+			points = append(points, models.TimeSeriesData{Time: r.points[i].Time.Add(shift), Value: r.points[i].Value})
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package simulate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+)
+
+func TestSynthetic(t *testing.T) {
+	// A Wednesday
+	day := time.Date(2024, 11, 20, 0, 0, 0, 0, time.UTC)
+	source := NewSynthetic(SyntheticConfig{Step: 5 * time.Minute, Seed: 1})
+
+	points := source.Points(day.Add(30*time.Second), day.Add(time.Hour))
+	require.Len(t, points, 11, "points at every step in [start, end)")
+	assert.Equal(t, day.Add(5*time.Minute), points[0].Time)
+	assert.Equal(t, points, source.Points(day.Add(30*time.Second), day.Add(time.Hour)), "reading again returns the same points")
+
+	value := func(t time.Time) float64 { return source.Points(t, t.Add(time.Second))[0].Value }
+	assert.InDelta(t, DefaultBase, value(day.Add(4*time.Hour)), DefaultNoise)
+	assert.InDelta(t, DefaultBase+DefaultAmplitude, value(day.Add(16*time.Hour)), DefaultNoise)
+	saturday := day.AddDate(0, 0, 3)
+	assert.InDelta(t, DefaultBase+DefaultAmplitude*weekendFactor, value(saturday.Add(16*time.Hour)), DefaultNoise)
+
+	t.Run("spikes", func(t *testing.T) {
+		spiky := NewSynthetic(SyntheticConfig{SpikeProbability: 1})
+		assert.Greater(t, spiky.Points(day, day.Add(time.Minute))[0].Value, DefaultBase*2)
+	})
+
+	t.Run("seeds", func(t *testing.T) {
+		other := NewSynthetic(SyntheticConfig{Step: 5 * time.Minute, Seed: 2})
+		assert.NotEqual(t, points, other.Points(day.Add(30*time.Second), day.Add(time.Hour)))
+	})
+
+	assert.Error(t, SyntheticConfig{SpikeProbability: 2}.Validate())
+	assert.Error(t, SyntheticConfig{Step: -time.Minute}.Validate())
+	assert.NoError(t, SyntheticConfig{}.Validate())
+}
+
+func TestReplay(t *testing.T) {
+	decoder, err := api.NewDecoder(api.DecoderConfig{Format: api.FormatCSV, TimeFormat: api.TimeFormatRFC3339})
+	require.NoError(t, err)
+	recording := "time,value\n" +
+		"2024-11-20T12:00:00Z,2\n" +
+		"2024-11-20T00:00:00Z,1\n" +
+		"2024-11-21T23:00:00Z,3\n"
+	replay, err := NewReplay(strings.NewReader(recording), decoder)
+	require.NoError(t, err)
+
+	day := time.Date(2024, 11, 20, 0, 0, 0, 0, time.UTC)
+	values := func(start, end time.Time) (times []time.Time, values []float64) {
+		for _, p := range replay.Points(start, end) {
+			times = append(times, p.Time)
+			values = append(values, p.Value)
+		}
+		return times, values
+	}
+
+	times, got := values(day, day.Add(12*time.Hour))
+	assert.Equal(t, []time.Time{day}, times, "the recording in time order, end excluded")
+	assert.Equal(t, []float64{1}, got)
+
+	// The two days of the recording repeat after and before it
+	times, got = values(day.AddDate(0, 0, 1), day.AddDate(0, 0, 3))
+	assert.Equal(t, []time.Time{day.Add(47 * time.Hour), day.AddDate(0, 0, 2), day.AddDate(0, 0, 2).Add(12 * time.Hour)}, times)
+	assert.Equal(t, []float64{3, 1, 2}, got)
+	times, got = values(day.AddDate(0, 0, -2), day.AddDate(0, 0, -1))
+	assert.Equal(t, []time.Time{day.AddDate(0, 0, -2), day.AddDate(0, 0, -2).Add(12 * time.Hour)}, times)
+	assert.Equal(t, []float64{1, 2}, got)
+
+	_, err = NewReplay(strings.NewReader("time,value\n"), decoder)
+	assert.ErrorContains(t, err, "no points")
+}
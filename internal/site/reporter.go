@@ -0,0 +1,166 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+// Defaults of InventoryConfig
+const (
+	DefaultInterval = 5 * time.Minute
+	DefaultTimeout  = 10 * time.Second
+)
+
+// InventoryConfig is where and how often heartbeats are sent.
+type InventoryConfig struct {
+	// URL is the inventory endpoint heartbeats are POSTed to
+	URL string
+	// Interval is the time between heartbeats, DefaultInterval when zero
+	Interval time.Duration
+	// Timeout bounds each request, DefaultTimeout when zero
+	Timeout time.Duration
+	// Headers are added to each request, such as an Authorization header
+	Headers map[string]string
+	// Secret, when set, signs each heartbeat in the X-Edgecom-Signature
+	// header, as webhook deliveries are
+	Secret string
+}
+
+// Heartbeat is the JSON body sent to the inventory endpoint.
+type Heartbeat struct {
+	Site Identity `json:"site"`
+	// Hostname is the host, or pod, the deployment runs on
+	Hostname string `json:"hostname,omitempty"`
+	// StartedAt is when the service started
+	StartedAt time.Time `json:"started_at"`
+	// SentAt is when the heartbeat was sent
+	SentAt time.Time `json:"sent_at"`
+	// UptimeSeconds is the time since StartedAt
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	// Status holds the details returned by the reporter's status function,
+	// such as the newest point ingested
+	Status map[string]interface{} `json:"status,omitempty"`
+}
+
+// Reporter sends heartbeats to the inventory endpoint.
+type Reporter struct {
+	identity Identity
+	config   InventoryConfig
+	client   *http.Client
+	logger   *logrus.Logger
+	hostname string
+	started  time.Time
+	status   func() map[string]interface{}
+}
+
+// NewReporter returns a reporter sending heartbeats describing identity
+// as configured.
+func NewReporter(identity Identity, config InventoryConfig, logger *logrus.Logger) (*Reporter, error) {
+	if err := identity.Validate(); err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(config.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid inventory url %q, expected an http or https URL", config.URL)
+	}
+	if config.Interval < 0 || config.Timeout < 0 {
+		return nil, fmt.Errorf("inventory interval and timeout must not be negative")
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	hostname, _ := os.Hostname()
+	return &Reporter{
+		identity: identity,
+		config:   config,
+		client:   &http.Client{},
+		logger:   logger,
+		hostname: hostname,
+		started:  time.Now(),
+	}, nil
+}
+
+// SetStatus sets the function whose result is sent as each heartbeat's
+// status. It must be called before Run.
+func (r *Reporter) SetStatus(status func() map[string]interface{}) {
+	r.status = status
+}
+
+// Run sends a heartbeat immediately, then every interval until ctx is
+// done. Failed heartbeats are logged and not retried: the next one
+// supersedes them.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Report(ctx); err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Warn("Failed to send inventory heartbeat")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Report sends one heartbeat, failing unless the endpoint responds with
+// a 2xx status.
+func (r *Reporter) Report(ctx context.Context) error {
+	now := time.Now()
+	heartbeat := Heartbeat{
+		Site:          r.identity,
+		Hostname:      r.hostname,
+		StartedAt:     r.started.UTC(),
+		SentAt:        now.UTC(),
+		UptimeSeconds: now.Sub(r.started).Seconds(),
+	}
+	if r.status != nil {
+		heartbeat.Status = r.status()
+	}
+	body, err := json.Marshal(heartbeat)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range r.config.Headers {
+		req.Header.Set(name, value)
+	}
+	if r.config.Secret != "" {
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(r.config.Secret, now, body))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("inventory responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
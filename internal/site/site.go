@@ -0,0 +1,136 @@
+// Package site identifies a deployment among a fleet of edge
+// deployments, so that their logs, metrics and health can be told apart
+// and tracked centrally.
+//
+// An Identity names the deployment with an ID, a location and free-form
+// labels. LogHook adds the ID and location to every log entry, and
+// WrapRegisterer adds the ID as a site label to every metric, along with
+// an edgecom_site_info metric carrying the location and labels. A
+// Reporter periodically POSTs a Heartbeat describing the deployment to a
+// central inventory endpoint, whose last heartbeat per site tells which
+// deployments are running, where, and since when.
+//
+// Example Usage:
+//
+//	identity := site.Identity{ID: "plant-7", Location: "Oslo", Labels: map[string]string{"region": "eu-north"}}
+//	logger.AddHook(site.NewLogHook(identity))
+//	prometheus.DefaultRegisterer, err = site.WrapRegisterer(identity, prometheus.DefaultRegisterer)
+//
+//	reporter, err := site.NewReporter(identity, site.InventoryConfig{
+//	    URL:      "https://inventory.example.com/heartbeats",
+//	    Interval: time.Minute,
+//	}, logger)
+//	go reporter.Run(ctx)
+package site
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Log entry fields added by LogHook
+const (
+	FieldSite     = "site"
+	FieldLocation = "site_location"
+)
+
+// labelName matches the names allowed for labels, which become metric
+// label names
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Identity names a deployment.
+type Identity struct {
+	// ID uniquely identifies the deployment in the fleet
+	ID string `json:"id"`
+	// Location is where the deployment runs, such as a plant or city
+	Location string `json:"location,omitempty"`
+	// Labels are free-form attributes, such as a region or customer
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Empty reports whether no identity was configured.
+func (i Identity) Empty() bool {
+	return i.ID == "" && i.Location == "" && len(i.Labels) == 0
+}
+
+// Validate checks that the identity has an ID and that its label names
+// can be used as metric label names.
+func (i Identity) Validate() error {
+	if i.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	for name := range i.Labels {
+		if !labelName.MatchString(name) {
+			return fmt.Errorf("invalid label name %q, expected letters, digits and underscores", name)
+		}
+	}
+	return nil
+}
+
+// LogHook adds the site's ID and location to every log entry, without
+// overriding fields the entry already has.
+type LogHook struct {
+	fields logrus.Fields
+}
+
+// NewLogHook returns a hook adding identity to log entries.
+func NewLogHook(identity Identity) *LogHook {
+	fields := logrus.Fields{FieldSite: identity.ID}
+	if identity.Location != "" {
+		fields[FieldLocation] = identity.Location
+	}
+	return &LogHook{fields: fields}
+}
+
+// Levels returns all levels: every entry is labelled.
+func (h *LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds the site fields to entry.
+func (h *LogHook) Fire(entry *logrus.Entry) error {
+	for key, value := range h.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+// WrapRegisterer returns a registerer adding a site label, holding the
+// identity's ID, to every metric registered with reg through it, and
+// registers edgecom_site_info through it, a gauge of 1 labelled with the
+// location and with each label prefixed with "label_". Metrics must be
+// registered through the returned registerer to be labelled, so it
+// should replace prometheus.DefaultRegisterer before any registration.
+func WrapRegisterer(identity Identity, reg prometheus.Registerer) (prometheus.Registerer, error) {
+	if err := identity.Validate(); err != nil {
+		return nil, err
+	}
+	wrapped := prometheus.WrapRegistererWith(prometheus.Labels{"site": identity.ID}, reg)
+
+	names := make([]string, 0, len(identity.Labels))
+	for name := range identity.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := prometheus.Labels{"location": identity.Location}
+	for _, name := range names {
+		labels["label_"+name] = identity.Labels[name]
+	}
+
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "edgecom_site_info",
+		Help:        "Identity of the deployment, always 1",
+		ConstLabels: labels,
+	})
+	info.Set(1)
+	if err := wrapped.Register(info); err != nil {
+		return nil, fmt.Errorf("failed to register site info metric: %v", err)
+	}
+	return wrapped, nil
+}
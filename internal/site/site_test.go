@@ -0,0 +1,157 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/webhook"
+)
+
+var testIdentity = Identity{
+	ID:       "plant-7",
+	Location: "Oslo",
+	Labels:   map[string]string{"region": "eu-north"},
+}
+
+func TestIdentityValidate(t *testing.T) {
+	assert.NoError(t, testIdentity.Validate())
+	assert.ErrorContains(t, Identity{Location: "Oslo"}.Validate(), "id is required")
+	assert.ErrorContains(t, Identity{ID: "a", Labels: map[string]string{"bad-name": "x"}}.Validate(), "invalid label name")
+	assert.True(t, Identity{}.Empty())
+	assert.False(t, Identity{Location: "Oslo"}.Empty())
+}
+
+func TestLogHook(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(NewLogHook(testIdentity))
+
+	logger.Info("first")
+	logger.WithField(FieldSite, "override").Info("second")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var first, second map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "plant-7", first[FieldSite])
+	assert.Equal(t, "Oslo", first[FieldLocation])
+	assert.Equal(t, "override", second[FieldSite], "existing fields are kept")
+}
+
+func TestWrapRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	wrapped, err := WrapRegisterer(testIdentity, reg)
+	require.NoError(t, err)
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "edgecom_test_total", Help: "Test counter"})
+	wrapped.MustRegister(counter)
+	counter.Inc()
+
+	expected := `
+		# HELP edgecom_site_info Identity of the deployment, always 1
+		# TYPE edgecom_site_info gauge
+		edgecom_site_info{label_region="eu-north",location="Oslo",site="plant-7"} 1
+		# HELP edgecom_test_total Test counter
+		# TYPE edgecom_test_total counter
+		edgecom_test_total{site="plant-7"} 1
+	`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+
+	_, err = WrapRegisterer(Identity{}, prometheus.NewRegistry())
+	assert.ErrorContains(t, err, "id is required")
+}
+
+func TestReporter(t *testing.T) {
+	t.Run("sends heartbeat", func(t *testing.T) {
+		var body []byte
+		var header http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = io.ReadAll(r.Body)
+			header = r.Header
+		}))
+		defer server.Close()
+
+		reporter, err := NewReporter(testIdentity, InventoryConfig{
+			URL:     server.URL,
+			Headers: map[string]string{"Authorization": "Bearer token"},
+			Secret:  "s3cret",
+		}, logrus.New())
+		require.NoError(t, err)
+		reporter.SetStatus(func() map[string]interface{} {
+			return map[string]interface{}{"subscribers": 2}
+		})
+		require.NoError(t, reporter.Report(context.Background()))
+
+		var heartbeat Heartbeat
+		require.NoError(t, json.Unmarshal(body, &heartbeat))
+		assert.Equal(t, testIdentity, heartbeat.Site)
+		assert.False(t, heartbeat.StartedAt.IsZero())
+		assert.False(t, heartbeat.SentAt.Before(heartbeat.StartedAt))
+		assert.Equal(t, 2.0, heartbeat.Status["subscribers"])
+		assert.Equal(t, "application/json", header.Get("Content-Type"))
+		assert.Equal(t, "Bearer token", header.Get("Authorization"))
+		assert.NoError(t, webhook.VerifySignature("s3cret", header.Get(webhook.HeaderSignature), body, time.Now(), time.Minute))
+	})
+
+	t.Run("failed status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		reporter, err := NewReporter(testIdentity, InventoryConfig{URL: server.URL}, logrus.New())
+		require.NoError(t, err)
+		assert.ErrorContains(t, reporter.Report(context.Background()), "status 503")
+	})
+
+	t.Run("runs until cancelled", func(t *testing.T) {
+		beats := make(chan struct{}, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			beats <- struct{}{}
+		}))
+		defer server.Close()
+
+		reporter, err := NewReporter(testIdentity, InventoryConfig{URL: server.URL, Interval: 10 * time.Millisecond}, logrus.New())
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			reporter.Run(ctx)
+			close(done)
+		}()
+		for i := 0; i < 2; i++ {
+			select {
+			case <-beats:
+			case <-time.After(time.Second):
+				t.Fatal("heartbeat not sent")
+			}
+		}
+		cancel()
+		<-done
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewReporter(testIdentity, InventoryConfig{URL: "ftp://inventory"}, logrus.New())
+		assert.ErrorContains(t, err, "invalid inventory url")
+		_, err = NewReporter(testIdentity, InventoryConfig{URL: "https://inventory", Interval: -time.Second}, logrus.New())
+		assert.ErrorContains(t, err, "must not be negative")
+		_, err = NewReporter(Identity{}, InventoryConfig{URL: "https://inventory"}, logrus.New())
+		assert.ErrorContains(t, err, "id is required")
+	})
+}
@@ -0,0 +1,278 @@
+// Package spill stages large intermediate results, such as exports
+// buffered before an upload, in temporary files on disk.
+//
+// Each file is checksummed as it is written and verified as it is read
+// back, so a file damaged on disk fails the upload instead of producing a
+// corrupt download. File names carry the host name and ID of the process
+// that created them, and Clean removes the files of processes on this host
+// that are no longer running, so a crash part way through a multi-GB
+// export does not leak disk space. Files of other hosts, which share the
+// directory on a network volume, are left alone, since whether their
+// process is running cannot be told from here.
+//
+// Example Usage:
+//
+//	dir := spill.NewDir("/var/lib/edgecom/spill")
+//	if removed, size, err := dir.Clean(); err == nil && removed > 0 {
+//	    log.Printf("removed %d orphaned spill files (%d bytes)", removed, size)
+//	}
+//
+//	f, err := dir.Create()
+//	if err != nil {
+//	    return err
+//	}
+//	defer f.Close()
+//	if _, err := io.Copy(f, export); err != nil {
+//	    return err
+//	}
+//	if err := f.Seal(); err != nil {
+//	    return err
+//	}
+//	// Reads fail with ErrCorrupt if the file changed on disk
+//	_, err = io.Copy(upload, f)
+package spill
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// filePrefix starts the name of every spill file, followed by the ID of
+// the process that created it and its host name, as <pid>@<host>-<random>
+const filePrefix = "edgecom-spill-"
+
+// ErrCorrupt is returned when a spill file read back does not match what
+// was written to it.
+var ErrCorrupt = errors.New("spill file is corrupt")
+
+// Dir is a directory holding spill files.
+type Dir struct {
+	path string
+	host string
+}
+
+// NewDir returns the spill directory at path, which is created on the
+// first spill if it does not exist. An empty path is the system temporary
+// directory.
+func NewDir(path string) *Dir {
+	if path == "" {
+		path = os.TempDir()
+	}
+	return &Dir{path: path, host: hostName()}
+}
+
+// hostName returns the host name spill files are marked with. Characters
+// that could not be told apart from the rest of a file name are replaced.
+func hostName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, host)
+}
+
+// Path returns the directory's path.
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// Create creates a spill file open for writing.
+func (d *Dir) Create() (*File, error) {
+	if err := os.MkdirAll(d.path, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	f, err := os.CreateTemp(d.path, filePrefix+strconv.Itoa(os.Getpid())+"@"+d.host+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return &File{file: f, hash: sha256.New()}, nil
+}
+
+// Clean removes spill files left behind by processes of this host that
+// are no longer running, and reports how many files and bytes were
+// removed. It is meant to run at startup, before this process spills
+// anything: files carrying this process's ID are then left over from an
+// earlier process that had the same ID, as happens when a container
+// restarts.
+func (d *Dir) Clean() (removed int, size int64, err error) {
+	entries, err := os.ReadDir(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list spill directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		pid, host, ok := owner(entry.Name())
+		if !ok || entry.IsDir() || host != d.host || (pid != os.Getpid() && running(pid)) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.path, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, size, fmt.Errorf("failed to remove orphaned spill file: %w", err)
+		}
+		removed++
+		size += info.Size()
+	}
+	return removed, size, nil
+}
+
+// owner returns the process ID and host name in a spill file name
+func owner(name string) (int, string, bool) {
+	rest, ok := strings.CutPrefix(name, filePrefix)
+	if !ok {
+		return 0, "", false
+	}
+	// The random suffix has no dashes, but host names may
+	i := strings.LastIndex(rest, "-")
+	if i < 0 {
+		return 0, "", false
+	}
+	id, host, ok := strings.Cut(rest[:i], "@")
+	if !ok || host == "" {
+		return 0, "", false
+	}
+	pid, err := strconv.Atoi(id)
+	return pid, host, err == nil && pid > 0
+}
+
+// running reports whether a process with the ID exists
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Signal 0 checks for the process without signalling it; a process
+	// of another user cannot be signalled but exists
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// File is a spill file. It is written, sealed, then read back any number
+// of times, and removed when closed.
+type File struct {
+	file *os.File
+	hash hash.Hash
+	size int64
+	sum  []byte
+
+	// Verification of the current read pass
+	read     int64
+	readHash hash.Hash
+}
+
+// Name returns the path of the file.
+func (f *File) Name() string {
+	return f.file.Name()
+}
+
+// Write appends p to the file. Files cannot be written once sealed.
+func (f *File) Write(p []byte) (int, error) {
+	if f.sum != nil {
+		return 0, fmt.Errorf("spill file is sealed")
+	}
+	n, err := f.file.Write(p)
+	f.hash.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+// Seal flushes the file to disk, records its checksum and rewinds it for
+// reading.
+func (f *File) Seal() error {
+	if f.sum != nil {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	f.sum = f.hash.Sum(nil)
+	return f.Rewind()
+}
+
+// Size returns the number of bytes written.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// Sum returns the SHA-256 checksum of the content, nil until the file is
+// sealed.
+func (f *File) Sum() []byte {
+	return f.sum
+}
+
+// Rewind starts a new read pass from the beginning of a sealed file.
+func (f *File) Rewind() error {
+	if f.sum == nil {
+		return fmt.Errorf("spill file is not sealed")
+	}
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read spill file: %w", err)
+	}
+	f.read = 0
+	f.readHash = sha256.New()
+	return nil
+}
+
+// Read reads the sealed file. The end of the file is reported only after
+// the content read has been checked against the checksum recorded when
+// it was sealed; a mismatch is reported as ErrCorrupt.
+func (f *File) Read(p []byte) (int, error) {
+	if f.sum == nil {
+		return 0, fmt.Errorf("spill file is not sealed")
+	}
+	n, err := f.file.Read(p)
+	f.readHash.Write(p[:n])
+	f.read += int64(n)
+	if f.read > f.size {
+		return n, fmt.Errorf("%w: %s has grown to more than %d bytes", ErrCorrupt, f.Name(), f.size)
+	}
+	if err == io.EOF {
+		if f.read != f.size {
+			return n, fmt.Errorf("%w: %s has %d of %d bytes", ErrCorrupt, f.Name(), f.read, f.size)
+		}
+		if !bytes.Equal(f.readHash.Sum(nil), f.sum) {
+			return n, fmt.Errorf("%w: %s does not match its checksum", ErrCorrupt, f.Name())
+		}
+	}
+	return n, err
+}
+
+// Verify reads the whole sealed file to check it against its checksum,
+// for files handed to other programs by name, and rewinds it.
+func (f *File) Verify() error {
+	if err := f.Rewind(); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		return err
+	}
+	return f.Rewind()
+}
+
+// Close closes and removes the file.
+func (f *File) Close() error {
+	err := f.file.Close()
+	if removeErr := os.Remove(f.file.Name()); err == nil && !errors.Is(removeErr, os.ErrNotExist) {
+		err = removeErr
+	}
+	return err
+}
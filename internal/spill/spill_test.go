@@ -0,0 +1,115 @@
+package spill
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func spillFile(t *testing.T, content string) *File {
+	t.Helper()
+	f, err := NewDir(t.TempDir()).Create()
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	_, err = io.Copy(f, strings.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, f.Seal())
+	return f
+}
+
+func TestFile(t *testing.T) {
+	f := spillFile(t, "time,value\n")
+	assert.Equal(t, int64(11), f.Size())
+	assert.Len(t, f.Sum(), 32)
+
+	_, err := f.Write([]byte("more"))
+	assert.Error(t, err, "sealed files cannot be written")
+
+	for pass := 0; pass < 2; pass++ {
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "time,value\n", string(content))
+		require.NoError(t, f.Rewind())
+	}
+
+	name := f.Name()
+	require.NoError(t, f.Close())
+	_, err = os.Stat(name)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "closing removes the file")
+}
+
+func TestFileCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace string
+	}{
+		{"changed", "time,VALUE\n"},
+		{"truncated", "time"},
+		{"grown", "time,value\n1,2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := spillFile(t, "time,value\n")
+			require.NoError(t, os.WriteFile(f.Name(), []byte(tt.replace), 0o600))
+
+			_, err := io.ReadAll(f)
+			assert.True(t, errors.Is(err, ErrCorrupt), "got %v", err)
+			assert.True(t, errors.Is(f.Verify(), ErrCorrupt))
+		})
+	}
+}
+
+func TestClean(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("partial export"), 0o600))
+	}
+
+	spill := NewDir(dir)
+	spill.host = "edge-1.example"
+
+	// The parent of the test process is running; an earlier process with
+	// this process's ID is not. Files of another host sharing the
+	// directory are kept whatever their process ID.
+	live := filePrefix + strconv.Itoa(os.Getppid()) + "@edge-1.example-1"
+	otherHost := filePrefix + "999999999@edge-2.example-4"
+	legacy := filePrefix + "999999999-5"
+	write(live)
+	write(filePrefix + strconv.Itoa(os.Getpid()) + "@edge-1.example-2")
+	write(filePrefix + "999999999@edge-1.example-3")
+	write(otherHost)
+	write(legacy)
+	write("unrelated.csv")
+
+	removed, size, err := spill.Clean()
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, int64(28), size)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{live, otherHost, legacy, "unrelated.csv"}, names)
+
+	f, err := spill.Create()
+	require.NoError(t, err)
+	defer f.Close()
+	pid, host, ok := owner(filepath.Base(f.Name()))
+	assert.True(t, ok)
+	assert.Equal(t, os.Getpid(), pid)
+	assert.Equal(t, "edge-1.example", host)
+
+	removed, _, err = NewDir(filepath.Join(dir, "missing")).Clean()
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
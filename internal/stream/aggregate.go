@@ -0,0 +1,90 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Querier is the subset of the repository needed to compute bucket updates.
+type Querier interface {
+	Query(ctx context.Context, start, end time.Time, window string, aggregation string) ([]models.TimeSeriesData, error)
+}
+
+// WindowDuration converts a window string such as "5m" or "1d" to a
+// time.Duration. Day suffixes are supported in addition to the units
+// accepted by time.ParseDuration.
+func WindowDuration(window string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(window, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window: %s", window)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window: %s", window)
+	}
+	return d, nil
+}
+
+// BucketRange returns the range of whole buckets of the given width that
+// cover every point in the batch.
+func BucketRange(points []models.TimeSeriesData, width time.Duration) (start, end time.Time) {
+	for i, p := range points {
+		if i == 0 || p.Time.Before(start) {
+			start = p.Time
+		}
+		if i == 0 || p.Time.After(end) {
+			end = p.Time
+		}
+	}
+	return start.Truncate(width), end.Truncate(width).Add(width)
+}
+
+// BucketUpdates re-aggregates every bucket touched by a newly ingested
+// batch. Buckets are recomputed from storage rather than from the batch
+// alone, so the values are exact even when a bucket spans several batches.
+func BucketUpdates(
+	ctx context.Context,
+	querier Querier,
+	points []models.TimeSeriesData,
+	window string,
+	aggregation string,
+) ([]models.TimeSeriesData, error) {
+	if len(points) == 0 {
+		return nil, nil
+	}
+
+	width, err := WindowDuration(window)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := BucketRange(points, width)
+
+	// The repository range is inclusive, stop just short of the next bucket
+	return querier.Query(ctx, start, end.Add(-time.Microsecond), window, aggregation)
+}
+
+// InRange returns the points whose time falls within [start, end]. A zero
+// start or end leaves that side of the range open.
+func InRange(points []models.TimeSeriesData, start, end time.Time) []models.TimeSeriesData {
+	var result []models.TimeSeriesData
+	for _, p := range points {
+		if !start.IsZero() && p.Time.Before(start) {
+			continue
+		}
+		if !end.IsZero() && p.Time.After(end) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
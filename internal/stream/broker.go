@@ -0,0 +1,519 @@
+// Package stream distributes newly ingested time series data to live
+// subscribers such as WebSocket clients.
+//
+// The package provides:
+//   - An in-process Broker that fans out ingested batches to subscribers,
+//     each with its own buffer and policy for when it falls behind
+//   - A repository decorator that publishes every successful insert
+//   - Helpers for turning raw batches into aggregated bucket updates
+//
+// Example Usage:
+//
+//	broker := stream.NewBroker()
+//	repo = stream.NewPublishingRepository(repo, broker)
+//
+//	sub := broker.Subscribe()
+//	defer sub.Close()
+//
+//	for batch := range sub.C {
+//	    log.Printf("received %d new points", len(batch))
+//	}
+//	if errors.Is(sub.Err(), stream.ErrSlowConsumer) {
+//	    log.Printf("fell behind after %d dropped batches", sub.Dropped())
+//	}
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// defaultBufferSize is the number of batches buffered per subscriber.
+const defaultBufferSize = 16
+
+// MaxBufferSize is the largest buffer a subscriber may ask for, in batches.
+const MaxBufferSize = 1024
+
+// DefaultMaxPause is how long a paused subscriber may take to catch up
+// before it is disconnected.
+const DefaultMaxPause = 30 * time.Second
+
+const (
+	// resumeInterval is how often a paused subscriber is checked for
+	// having drained its buffer
+	resumeInterval = 20 * time.Millisecond
+	// backfillPageSize is the number of samples read from the repository
+	// at a time when a paused subscriber resumes
+	backfillPageSize = 10000
+	// maxBackfill is the most samples a paused subscriber is resynced
+	// with; one that missed more is disconnected instead
+	maxBackfill = 100000
+)
+
+// OverflowPolicy decides what happens to a batch published to a
+// subscriber whose buffer is full.
+type OverflowPolicy string
+
+// Overflow policies
+const (
+	// DropOldest discards the oldest buffered batch to make room, so the
+	// subscriber stays current at the cost of a gap. It is the default.
+	DropOldest OverflowPolicy = "drop_oldest"
+	// Pause stops delivering batches to the subscriber, remembering the
+	// range of the points it missed. Once it has drained its buffer, the
+	// missed points are read back from the repository and delivered as
+	// one batch, and live delivery resumes, so the subscriber catches up
+	// without a gap. A subscriber still paused after MaxPause is ended
+	// with ErrSlowConsumer.
+	Pause OverflowPolicy = "pause"
+	// Disconnect ends the subscription with ErrSlowConsumer.
+	Disconnect OverflowPolicy = "disconnect"
+)
+
+// ErrSlowConsumer is the error of subscriptions ended because they did
+// not keep up with the published batches.
+var ErrSlowConsumer = errors.New("slow consumer: subscription buffer full")
+
+// SubscribeOptions configures a subscription. Zero fields take the
+// broker's defaults.
+type SubscribeOptions struct {
+	// BufferSize is the number of batches buffered for the subscriber,
+	// at most MaxBufferSize
+	BufferSize int
+	// Overflow is the policy applied when the buffer is full
+	Overflow OverflowPolicy
+	// MaxPause is how long a subscriber may stay paused under the Pause
+	// policy, DefaultMaxPause by default
+	MaxPause time.Duration
+}
+
+// validate checks the options, allowing zero fields
+func (o SubscribeOptions) validate() error {
+	if o.BufferSize < 0 || o.BufferSize > MaxBufferSize {
+		return fmt.Errorf("buffer size must be between 1 and %d, got %d", MaxBufferSize, o.BufferSize)
+	}
+	if o.MaxPause < 0 {
+		return fmt.Errorf("max pause must not be negative, got %s", o.MaxPause)
+	}
+	switch o.Overflow {
+	case "", DropOldest, Pause, Disconnect:
+		return nil
+	}
+	return fmt.Errorf("unknown overflow policy %q, expected %q, %q or %q", o.Overflow, DropOldest, Pause, Disconnect)
+}
+
+// Broker fans out newly ingested data points to all active subscribers.
+//
+// Publishing never blocks: a subscriber whose buffer is full has the
+// batch handled by its overflow policy rather than stalling the ingestion
+// path.
+type Broker struct {
+	mu       sync.RWMutex
+	subs     map[*Subscription]struct{}
+	defaults SubscribeOptions
+	stats    Stats
+	closed   bool
+
+	// repository is read back from when paused subscribers resume
+	repository database.TimeSeriesRepository
+
+	dropped      *prometheus.CounterVec
+	disconnected prometheus.Counter
+}
+
+// Stats summarizes the most recent ingestion activity seen by the broker.
+type Stats struct {
+	// LastBatchAt is when the most recent batch was published
+	LastBatchAt time.Time `json:"last_batch_at"`
+	// LastBatchSize is the number of points in the most recent batch
+	LastBatchSize int `json:"last_batch_size"`
+	// NewestPoint is the latest data point timestamp published so far
+	NewestPoint time.Time `json:"newest_point"`
+	// TotalPoints is the number of points published since startup
+	TotalPoints uint64 `json:"total_points"`
+}
+
+// Subscription receives batches published after it was created.
+type Subscription struct {
+	// C delivers published batches. It is closed when the subscription
+	// is closed.
+	C <-chan []models.TimeSeriesData
+
+	ch       chan []models.TimeSeriesData
+	overflow OverflowPolicy
+	maxPause time.Duration
+	broker   *Broker
+	dropped  atomic.Uint64
+	// closed is set, and ch closed, under the broker's lock, so that
+	// Publish never sends on a closed channel
+	closed bool
+	// err is set under the broker's lock before ch is closed for slow
+	// consumers
+	err error
+
+	// The pause state, under the broker's lock: when the subscriber was
+	// paused, and the range of the points it has missed since, which is
+	// its watermark to resync from. stop ends the resuming goroutine.
+	pausedAt             time.Time
+	missedFrom, missedTo time.Time
+	missed               bool
+	stop                 context.CancelFunc
+}
+
+// NewBroker creates a new broker with no subscribers.
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[*Subscription]struct{}),
+		defaults: SubscribeOptions{
+			BufferSize: defaultBufferSize,
+			Overflow:   DropOldest,
+			MaxPause:   DefaultMaxPause,
+		},
+	}
+}
+
+// SetDefaults sets the buffer size, overflow policy and pause limit of
+// subscriptions that do not choose their own; zero fields keep the
+// current defaults. It must be called before the broker has subscribers.
+func (b *Broker) SetDefaults(opts SubscribeOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if opts.BufferSize != 0 {
+		b.defaults.BufferSize = opts.BufferSize
+	}
+	if opts.Overflow != "" {
+		b.defaults.Overflow = opts.Overflow
+	}
+	if opts.MaxPause != 0 {
+		b.defaults.MaxPause = opts.MaxPause
+	}
+	return nil
+}
+
+// SetRepository sets the repository paused subscribers are resynced
+// from, which holds the published points. Subscriptions with the Pause
+// policy are refused without one. It must be called before the broker
+// has subscribers.
+func (b *Broker) SetRepository(repo database.TimeSeriesRepository) {
+	b.repository = repo
+}
+
+// SetMetrics registers counters of the batches dropped for slow
+// subscribers, by overflow policy, and of the subscribers disconnected,
+// along with a gauge of the active subscriptions, with reg. It must be
+// called before the broker has subscribers.
+func (b *Broker) SetMetrics(reg prometheus.Registerer) error {
+	dropped := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edgecom_live_dropped_batches_total",
+		Help: "Batches not delivered to live subscribers whose buffer was full",
+	}, []string{"policy"})
+	disconnected := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "edgecom_live_slow_consumer_disconnects_total",
+		Help: "Live subscribers disconnected for not keeping up",
+	})
+	subscriptions := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "edgecom_live_subscribers",
+		Help: "Active live subscriptions",
+	}, func() float64 { return float64(b.Subscribers()) })
+
+	if err := reg.Register(dropped); err != nil {
+		return fmt.Errorf("failed to register dropped batches metric: %v", err)
+	}
+	if err := reg.Register(disconnected); err != nil {
+		return fmt.Errorf("failed to register slow consumer metric: %v", err)
+	}
+	if err := reg.Register(subscriptions); err != nil {
+		return fmt.Errorf("failed to register live subscribers metric: %v", err)
+	}
+	b.dropped = dropped
+	b.disconnected = disconnected
+	return nil
+}
+
+// Subscribe registers a new subscriber with the default options. Callers
+// must Close the subscription when they are done with it.
+func (b *Broker) Subscribe() *Subscription {
+	sub, _ := b.SubscribeWith(SubscribeOptions{})
+	return sub
+}
+
+// SubscribeWith registers a new subscriber with its own buffer size and
+// overflow policy, failing for invalid options. Callers must Close the
+// subscription when they are done with it.
+func (b *Broker) SubscribeWith(opts SubscribeOptions) (*Subscription, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.BufferSize == 0 {
+		opts.BufferSize = b.defaults.BufferSize
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = b.defaults.Overflow
+	}
+	if opts.MaxPause == 0 {
+		opts.MaxPause = b.defaults.MaxPause
+	}
+	if opts.Overflow == Pause && b.repository == nil {
+		return nil, fmt.Errorf("overflow policy %q is not available without a repository to resync from", Pause)
+	}
+
+	ch := make(chan []models.TimeSeriesData, opts.BufferSize)
+	sub := &Subscription{
+		C:        ch,
+		ch:       ch,
+		overflow: opts.Overflow,
+		maxPause: opts.MaxPause,
+		broker:   b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		sub.close(nil)
+		return sub, nil
+	}
+	b.subs[sub] = struct{}{}
+
+	return sub, nil
+}
+
+// Close ends every subscription, closing its channel so that consumers
+// such as streaming HTTP handlers return, and closes later subscriptions
+// immediately. It is used on shutdown; publishing afterwards is a no-op.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	b.closed = true
+	subs := make([]*Subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// Publish delivers points to every subscriber without blocking.
+func (b *Broker) Publish(points []models.TimeSeriesData) {
+	if len(points) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stats.LastBatchAt = time.Now()
+	b.stats.LastBatchSize = len(points)
+	b.stats.TotalPoints += uint64(len(points))
+	for _, p := range points {
+		if p.Time.After(b.stats.NewestPoint) {
+			b.stats.NewestPoint = p.Time
+		}
+	}
+
+	for sub := range b.subs {
+		b.deliver(sub, points)
+	}
+}
+
+// deliver sends points to sub without blocking, applying its overflow
+// policy if its buffer is full. It must be called with b.mu held.
+func (b *Broker) deliver(sub *Subscription, points []models.TimeSeriesData) {
+	if !sub.pausedAt.IsZero() {
+		b.pause(sub, points)
+		return
+	}
+	select {
+	case sub.ch <- points:
+		return
+	default:
+	}
+
+	switch sub.overflow {
+	case DropOldest:
+		// The subscriber may drain the buffer meanwhile, in which case
+		// nothing needs dropping; only Publish sends, so there is room
+		// afterwards
+		select {
+		case <-sub.ch:
+			b.drop(sub)
+		default:
+		}
+		sub.ch <- points
+	case Pause:
+		ctx, stop := context.WithCancel(context.Background())
+		sub.pausedAt = time.Now()
+		sub.stop = stop
+		b.pause(sub, points)
+		go b.resume(ctx, sub)
+	case Disconnect:
+		b.drop(sub)
+		b.disconnect(sub, ErrSlowConsumer)
+	}
+}
+
+// disconnect ends sub with err. It must be called with b.mu held.
+func (b *Broker) disconnect(sub *Subscription, err error) {
+	if b.disconnected != nil {
+		b.disconnected.Inc()
+	}
+	delete(b.subs, sub)
+	sub.close(err)
+}
+
+// pause records points as missed by the paused sub, extending the range
+// it is resynced with, or disconnects it once it has been paused for
+// longer than its limit. It must be called with b.mu held.
+func (b *Broker) pause(sub *Subscription, points []models.TimeSeriesData) {
+	if time.Since(sub.pausedAt) > sub.maxPause {
+		b.disconnect(sub, ErrSlowConsumer)
+		return
+	}
+	for _, p := range points {
+		if !sub.missed || p.Time.Before(sub.missedFrom) {
+			sub.missedFrom = p.Time
+		}
+		if !sub.missed || p.Time.After(sub.missedTo) {
+			sub.missedTo = p.Time
+		}
+		sub.missed = true
+	}
+}
+
+// resume waits for the paused sub to drain its buffer, then delivers the
+// points it missed, read back from the repository, until it has caught
+// up and is delivered to live again. Points published meanwhile are
+// added to its missed range, so none is lost or delivered out of order.
+func (b *Broker) resume(ctx context.Context, sub *Subscription) {
+	ticker := time.NewTicker(resumeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		b.mu.Lock()
+		if time.Since(sub.pausedAt) > sub.maxPause {
+			b.disconnect(sub, ErrSlowConsumer)
+		}
+		if sub.closed || len(sub.ch) > 0 {
+			b.mu.Unlock()
+			continue
+		}
+		if !sub.missed {
+			sub.pausedAt = time.Time{}
+			sub.stop()
+			b.mu.Unlock()
+			return
+		}
+		from, to := sub.missedFrom, sub.missedTo
+		sub.missed = false
+		b.mu.Unlock()
+
+		points, err := b.backfill(ctx, from, to)
+
+		b.mu.Lock()
+		switch {
+		case sub.closed:
+		case err != nil:
+			b.disconnect(sub, fmt.Errorf("%w: %v", ErrSlowConsumer, err))
+		case len(points) > 0:
+			// Nothing else sends while sub is paused, and its buffer
+			// was empty
+			sub.ch <- points
+		}
+		b.mu.Unlock()
+	}
+}
+
+// backfill reads the stored points within [from, to] back from the
+// repository, failing if there are more than maxBackfill
+func (b *Broker) backfill(ctx context.Context, from, to time.Time) ([]models.TimeSeriesData, error) {
+	var points []models.TimeSeriesData
+	for {
+		page, err := b.repository.QueryRaw(ctx, from, to, len(points), backfillPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read missed points: %w", err)
+		}
+		points = append(points, page...)
+		if len(points) > maxBackfill {
+			return nil, fmt.Errorf("missed more than %d points", maxBackfill)
+		}
+		if len(page) < backfillPageSize {
+			return points, nil
+		}
+	}
+}
+
+// drop counts a batch sub did not receive
+func (b *Broker) drop(sub *Subscription) {
+	sub.dropped.Add(1)
+	if b.dropped != nil {
+		b.dropped.WithLabelValues(string(sub.overflow)).Inc()
+	}
+}
+
+// Subscribers returns the number of active subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+// Stats returns a snapshot of recent ingestion activity.
+func (b *Broker) Stats() Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.stats
+}
+
+// Dropped returns the number of batches the subscription missed because
+// its buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Err returns ErrSlowConsumer once C is closed because the subscription
+// fell behind under the Disconnect policy, and nil otherwise.
+func (s *Subscription) Err() error {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return s.err
+}
+
+// Close unregisters the subscription and closes its channel.
+// It is safe to call Close more than once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	delete(s.broker.subs, s)
+	s.close(nil)
+}
+
+// close closes ch with err unless it is already closed. It must be
+// called with the broker's lock held.
+func (s *Subscription) close(err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	if s.stop != nil {
+		s.stop()
+	}
+	close(s.ch)
+}
@@ -0,0 +1,317 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestBroker(t *testing.T) {
+	t.Run("fan out to subscribers", func(t *testing.T) {
+		broker := NewBroker()
+		sub1 := broker.Subscribe()
+		defer sub1.Close()
+		sub2 := broker.Subscribe()
+		defer sub2.Close()
+
+		batch := []models.TimeSeriesData{{Time: time.Now(), Value: 1.0}}
+		broker.Publish(batch)
+
+		assert.Equal(t, batch, <-sub1.C)
+		assert.Equal(t, batch, <-sub2.C)
+
+		stats := broker.Stats()
+		assert.Equal(t, 1, stats.LastBatchSize)
+		assert.Equal(t, uint64(1), stats.TotalPoints)
+		assert.True(t, stats.NewestPoint.Equal(batch[0].Time))
+	})
+
+	t.Run("close unsubscribes", func(t *testing.T) {
+		broker := NewBroker()
+		sub := broker.Subscribe()
+		assert.Equal(t, 1, broker.Subscribers())
+
+		sub.Close()
+		sub.Close()
+		assert.Equal(t, 0, broker.Subscribers())
+
+		_, ok := <-sub.C
+		assert.False(t, ok, "channel should be closed")
+	})
+
+	t.Run("slow subscriber does not block publish", func(t *testing.T) {
+		broker := NewBroker()
+		sub := broker.Subscribe()
+		defer sub.Close()
+
+		batch := []models.TimeSeriesData{{Time: time.Now(), Value: 1.0}}
+		for i := 0; i < defaultBufferSize*2; i++ {
+			broker.Publish(batch)
+		}
+		assert.Len(t, sub.C, defaultBufferSize)
+	})
+
+	t.Run("drop oldest keeps the newest batches", func(t *testing.T) {
+		broker := NewBroker()
+		sub, err := broker.SubscribeWith(SubscribeOptions{BufferSize: 2, Overflow: DropOldest})
+		require.NoError(t, err)
+		defer sub.Close()
+
+		for i := 0; i < 5; i++ {
+			broker.Publish([]models.TimeSeriesData{{Value: float64(i)}})
+		}
+		assert.Equal(t, 3.0, (<-sub.C)[0].Value)
+		assert.Equal(t, 4.0, (<-sub.C)[0].Value)
+		assert.Equal(t, uint64(3), sub.Dropped())
+	})
+
+	t.Run("disconnect ends slow subscriptions", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		broker := NewBroker()
+		require.NoError(t, broker.SetMetrics(reg))
+		require.NoError(t, broker.SetDefaults(SubscribeOptions{BufferSize: 1, Overflow: Disconnect}))
+		slow := broker.Subscribe()
+		defer slow.Close()
+		other, err := broker.SubscribeWith(SubscribeOptions{BufferSize: 4})
+		require.NoError(t, err)
+		defer other.Close()
+
+		batch := []models.TimeSeriesData{{Time: time.Now(), Value: 1.0}}
+		broker.Publish(batch)
+		broker.Publish(batch)
+		assert.Equal(t, 1, broker.Subscribers())
+
+		assert.Equal(t, batch, <-slow.C)
+		_, ok := <-slow.C
+		assert.False(t, ok)
+		assert.ErrorIs(t, slow.Err(), ErrSlowConsumer)
+		assert.NoError(t, other.Err())
+		assert.Len(t, other.C, 2)
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(broker.disconnected))
+		assert.Equal(t, 1.0, testutil.ToFloat64(broker.dropped.WithLabelValues("disconnect")))
+	})
+
+	t.Run("closing while disconnected", func(t *testing.T) {
+		broker := NewBroker()
+		batch := []models.TimeSeriesData{{Time: time.Now(), Value: 1.0}}
+		for i := 0; i < 100; i++ {
+			sub, err := broker.SubscribeWith(SubscribeOptions{BufferSize: 1, Overflow: Disconnect})
+			require.NoError(t, err)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				sub.Close()
+			}()
+			broker.Publish(batch)
+			broker.Publish(batch)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Close deadlocked with Publish")
+			}
+		}
+		assert.Zero(t, broker.Subscribers())
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		broker := NewBroker()
+		for _, opts := range []SubscribeOptions{
+			{BufferSize: -1},
+			{BufferSize: MaxBufferSize + 1},
+			{Overflow: "block"},
+			{MaxPause: -time.Second},
+		} {
+			_, err := broker.SubscribeWith(opts)
+			assert.Error(t, err, "%+v", opts)
+			assert.Error(t, broker.SetDefaults(opts), "%+v", opts)
+		}
+		assert.Zero(t, broker.Subscribers())
+	})
+
+	t.Run("pause resyncs from the repository", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+		base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		missed := []models.TimeSeriesData{
+			{Time: base.Add(time.Hour), Value: 1.0},
+			{Time: base.Add(2 * time.Hour), Value: 2.0},
+		}
+		mockRepo.EXPECT().
+			QueryRaw(gomock.Any(), missed[0].Time, missed[1].Time, 0, backfillPageSize).
+			Return(missed, nil)
+
+		broker := NewBroker()
+		broker.SetRepository(mockRepo)
+		sub, err := broker.SubscribeWith(SubscribeOptions{BufferSize: 1, Overflow: Pause, MaxPause: time.Minute})
+		require.NoError(t, err)
+		defer sub.Close()
+
+		first := []models.TimeSeriesData{{Time: base, Value: 0.0}}
+		broker.Publish(first)
+		broker.Publish(missed[:1])
+		broker.Publish(missed[1:])
+		assert.Len(t, sub.C, 1)
+
+		assert.Equal(t, first, <-sub.C)
+		select {
+		case batch := <-sub.C:
+			assert.Equal(t, missed, batch)
+		case <-time.After(5 * time.Second):
+			t.Fatal("paused subscriber was not resynced")
+		}
+		assert.Eventually(t, func() bool {
+			broker.mu.RLock()
+			defer broker.mu.RUnlock()
+			return sub.pausedAt.IsZero()
+		}, 5*time.Second, resumeInterval)
+
+		live := []models.TimeSeriesData{{Time: base.Add(3 * time.Hour), Value: 3.0}}
+		broker.Publish(live)
+		assert.Equal(t, live, <-sub.C)
+		assert.NoError(t, sub.Err())
+		assert.Zero(t, sub.Dropped())
+	})
+
+	t.Run("pause limit disconnects", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		broker := NewBroker()
+		broker.SetRepository(mocks.NewMockTimeSeriesRepository(ctrl))
+		sub, err := broker.SubscribeWith(SubscribeOptions{BufferSize: 1, Overflow: Pause, MaxPause: 50 * time.Millisecond})
+		require.NoError(t, err)
+		defer sub.Close()
+
+		batch := []models.TimeSeriesData{{Time: time.Now(), Value: 1.0}}
+		broker.Publish(batch)
+		broker.Publish(batch)
+
+		assert.Eventually(t, func() bool {
+			return broker.Subscribers() == 0
+		}, 5*time.Second, resumeInterval)
+		assert.Equal(t, batch, <-sub.C)
+		_, ok := <-sub.C
+		assert.False(t, ok)
+		assert.ErrorIs(t, sub.Err(), ErrSlowConsumer)
+	})
+
+	t.Run("pause needs a repository", func(t *testing.T) {
+		broker := NewBroker()
+		_, err := broker.SubscribeWith(SubscribeOptions{Overflow: Pause})
+		assert.Error(t, err)
+		require.NoError(t, broker.SetDefaults(SubscribeOptions{Overflow: Pause}))
+		assert.Zero(t, broker.Subscribers())
+	})
+
+	t.Run("close ends subscriptions", func(t *testing.T) {
+		broker := NewBroker()
+		sub := broker.Subscribe()
+
+		broker.Close()
+		_, ok := <-sub.C
+		assert.False(t, ok)
+		assert.Equal(t, 0, broker.Subscribers())
+
+		late := broker.Subscribe()
+		_, ok = <-late.C
+		assert.False(t, ok)
+		late.Close()
+		assert.Equal(t, 0, broker.Subscribers())
+	})
+}
+
+func TestPublishingRepository(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+	broker := NewBroker()
+	repo := NewPublishingRepository(mockRepo, broker)
+
+	sub := broker.Subscribe()
+	defer sub.Close()
+
+	batch := []models.TimeSeriesData{{Time: time.Now(), Value: 1.0}}
+
+	// Failed inserts are not published
+	mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), batch).Return(assert.AnError)
+	require.Error(t, repo.BatchInsertTimeSeriesData(context.Background(), batch))
+	assert.Len(t, sub.C, 0)
+
+	mockRepo.EXPECT().BatchInsertTimeSeriesData(gomock.Any(), batch).Return(nil)
+	require.NoError(t, repo.BatchInsertTimeSeriesData(context.Background(), batch))
+	assert.Equal(t, batch, <-sub.C)
+
+	// Single inserts go through the context-aware method, including the
+	// deprecated context-free one
+	point := batch[0]
+	mockRepo.EXPECT().InsertTimeSeriesDataContext(gomock.Any(), point.Time, point.Value).Return(nil).Times(2)
+	require.NoError(t, repo.InsertTimeSeriesDataContext(context.Background(), point.Time, point.Value))
+	assert.Equal(t, batch, <-sub.C)
+	require.NoError(t, repo.InsertTimeSeriesData(point.Time, point.Value))
+	assert.Equal(t, batch, <-sub.C)
+}
+
+func TestBucketUpdates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+	base := time.Date(2024, 11, 23, 10, 0, 0, 0, time.UTC)
+	points := []models.TimeSeriesData{
+		{Time: base.Add(65 * time.Minute), Value: 1.0},
+		{Time: base.Add(10 * time.Minute), Value: 2.0},
+	}
+
+	mockRepo.EXPECT().
+		Query(gomock.Any(), base, base.Add(2*time.Hour-time.Microsecond), "1h", "AVG").
+		Return([]models.TimeSeriesData{{Time: base, Value: 2.0}}, nil)
+
+	updates, err := BucketUpdates(context.Background(), mockRepo, points, "1h", "AVG")
+	require.NoError(t, err)
+	assert.Len(t, updates, 1)
+
+	_, err = BucketUpdates(context.Background(), mockRepo, points, "bogus", "AVG")
+	assert.Error(t, err)
+}
+
+func TestWindowDuration(t *testing.T) {
+	tests := []struct {
+		window  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"1m", time.Minute, false},
+		{"5m", 5 * time.Minute, false},
+		{"1h", time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"0d", 0, true},
+		{"", 0, true},
+		{"abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			got, err := WindowDuration(tt.window)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package stream
+
+import (
+	"context"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Publisher receives batches of stored points. Broker implements it, as
+// does the internal event bus.
+type Publisher interface {
+	Publish(points []models.TimeSeriesData)
+}
+
+// PublishingRepository decorates a TimeSeriesRepository so that every
+// successful insert is published to a Publisher.
+//
+// Wrapping the repository, rather than the fetcher, means every ingestion
+// path reaches live subscribers without having to know about them.
+type PublishingRepository struct {
+	database.TimeSeriesRepository
+	publisher Publisher
+}
+
+// NewPublishingRepository wraps repo so inserts are published to
+// publisher.
+func NewPublishingRepository(repo database.TimeSeriesRepository, publisher Publisher) *PublishingRepository {
+	return &PublishingRepository{
+		TimeSeriesRepository: repo,
+		publisher:            publisher,
+	}
+}
+
+// InsertTimeSeriesData inserts a single point and publishes it on success.
+//
+// Deprecated: Use InsertTimeSeriesDataContext.
+func (r *PublishingRepository) InsertTimeSeriesData(timestamp time.Time, value float64) error {
+	return r.InsertTimeSeriesDataContext(context.Background(), timestamp, value)
+}
+
+// InsertTimeSeriesDataContext inserts a single point and publishes it on
+// success.
+func (r *PublishingRepository) InsertTimeSeriesDataContext(ctx context.Context, timestamp time.Time, value float64) error {
+	if err := r.TimeSeriesRepository.InsertTimeSeriesDataContext(ctx, timestamp, value); err != nil {
+		return err
+	}
+	r.publisher.Publish([]models.TimeSeriesData{{Time: timestamp, Value: value}})
+	return nil
+}
+
+// BatchInsertTimeSeriesData inserts a batch and publishes it once the
+// transaction has committed.
+func (r *PublishingRepository) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
+	if err := r.TimeSeriesRepository.BatchInsertTimeSeriesData(ctx, data); err != nil {
+		return err
+	}
+	r.publisher.Publish(data)
+	return nil
+}
+
+// Compile-time interface implementation check
+var _ database.TimeSeriesRepository = (*PublishingRepository)(nil)
@@ -0,0 +1,117 @@
+// Package tracing configures OpenTelemetry tracing for the service.
+//
+// Spans are created by the gRPC tracing interceptor, the database
+// repository and the series fetcher through the global tracer provider.
+// Until Setup installs an exporting provider those spans are no-ops, so
+// packages can create spans unconditionally.
+//
+// Example Usage:
+//
+//	shutdown, err := tracing.Setup(ctx, tracing.Config{
+//	    Enabled:     true,
+//	    Endpoint:    "otel-collector:4317",
+//	    Insecure:    true,
+//	    ServiceName: "edgecom",
+//	    SampleRatio: 0.1,
+//	})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer shutdown(context.Background())
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// DefaultServiceName is reported when Config.ServiceName is empty.
+const DefaultServiceName = "edgecom"
+
+// Config controls the OTLP trace exporter.
+type Config struct {
+	// Enabled turns on exporting. When false, spans are created but
+	// discarded.
+	Enabled bool
+
+	// Endpoint is the host:port of an OTLP/gRPC collector. The exporter's
+	// environment defaults (OTEL_EXPORTER_OTLP_ENDPOINT) apply when empty.
+	Endpoint string
+
+	// Insecure disables TLS towards the collector.
+	Insecure bool
+
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+
+	// SampleRatio is the fraction of new traces that are sampled, between
+	// 0 and 1. Requests that arrive with a sampled parent are always
+	// traced.
+	SampleRatio float64
+}
+
+// Validate reports whether the configuration is usable.
+func (c Config) Validate() error {
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1, got %v", c.SampleRatio)
+	}
+	return nil
+}
+
+// Setup installs the W3C trace context propagator and, if cfg.Enabled, a
+// tracer provider exporting to an OTLP collector. The returned function
+// flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts := []otlptracegrpc.Option{}
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "zero ratio", config: Config{}},
+		{name: "full sampling", config: Config{SampleRatio: 1}},
+		{name: "negative ratio", config: Config{SampleRatio: -0.1}, wantErr: true},
+		{name: "ratio above one", config: Config{SampleRatio: 1.5}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "sample ratio")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		// An invalid ratio is ignored while export is disabled
+		shutdown, err := Setup(context.Background(), Config{SampleRatio: 2})
+		require.NoError(t, err)
+		assert.NoError(t, shutdown(context.Background()))
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := Setup(context.Background(), Config{Enabled: true, SampleRatio: 2})
+		assert.Error(t, err)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		// The exporter connects lazily, so no collector is needed here
+		shutdown, err := Setup(context.Background(), Config{
+			Enabled:     true,
+			Endpoint:    "localhost:4317",
+			Insecure:    true,
+			SampleRatio: 1,
+		})
+		require.NoError(t, err)
+		assert.NoError(t, shutdown(context.Background()))
+	})
+}
@@ -0,0 +1,171 @@
+// Package transform corrects the values of points as they are ingested.
+//
+// Raw meters frequently report in the wrong unit or polarity, or with a
+// constant offset. A Pipeline applies a list of steps to the value of
+// every incoming point, and Repository applies a pipeline to the points
+// written through it, so each ingestion source can be corrected on its
+// own before anything else sees its data:
+//
+//   - scale: multiplies values by a factor, such as 0.001 for Wh to kWh
+//   - offset: adds a constant
+//   - negate: flips the sign
+//   - clamp: bounds values to a range, cutting off outliers
+//
+// Example Usage:
+//
+//	pipeline, err := transform.NewPipeline([]transform.Step{
+//	    {Type: transform.Scale, Factor: 0.001},
+//	    {Type: transform.Clamp, Min: &zero},
+//	})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	repo = transform.NewRepository(repo, pipeline)
+package transform
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// Step types
+const (
+	// Scale multiplies values by Factor
+	Scale = "scale"
+	// Offset adds Offset to values
+	Offset = "offset"
+	// Negate flips the sign of values
+	Negate = "negate"
+	// Clamp bounds values to [Min, Max]
+	Clamp = "clamp"
+)
+
+// Step is a single correction of a pipeline.
+type Step struct {
+	Type string
+	// Factor is the multiplier of a scale step
+	Factor float64
+	// Offset is the constant an offset step adds
+	Offset float64
+	// Min and Max bound the values of a clamp step; nil leaves the side
+	// unbounded
+	Min, Max *float64
+}
+
+// Pipeline is a list of steps applied to values in order. The zero value
+// leaves values unchanged.
+type Pipeline struct {
+	steps []Step
+}
+
+// NewPipeline validates steps and returns the pipeline applying them.
+func NewPipeline(steps []Step) (Pipeline, error) {
+	for i, step := range steps {
+		switch step.Type {
+		case Scale:
+			if step.Factor == 0 || math.IsNaN(step.Factor) || math.IsInf(step.Factor, 0) {
+				return Pipeline{}, fmt.Errorf("step %d: scale needs a finite, non-zero factor", i+1)
+			}
+		case Offset:
+			if math.IsNaN(step.Offset) || math.IsInf(step.Offset, 0) {
+				return Pipeline{}, fmt.Errorf("step %d: offset must be finite", i+1)
+			}
+		case Negate:
+		case Clamp:
+			if step.Min == nil && step.Max == nil {
+				return Pipeline{}, fmt.Errorf("step %d: clamp needs a min or a max", i+1)
+			}
+			if step.Min != nil && step.Max != nil && *step.Min > *step.Max {
+				return Pipeline{}, fmt.Errorf("step %d: clamp min %g is above max %g", i+1, *step.Min, *step.Max)
+			}
+		default:
+			return Pipeline{}, fmt.Errorf("step %d: invalid type %q, expected %q, %q, %q or %q",
+				i+1, step.Type, Scale, Offset, Negate, Clamp)
+		}
+	}
+	return Pipeline{steps: steps}, nil
+}
+
+// Empty reports whether the pipeline leaves values unchanged.
+func (p Pipeline) Empty() bool {
+	return len(p.steps) == 0
+}
+
+// Value applies the steps to v.
+func (p Pipeline) Value(v float64) float64 {
+	for _, step := range p.steps {
+		switch step.Type {
+		case Scale:
+			v *= step.Factor
+		case Offset:
+			v += step.Offset
+		case Negate:
+			v = -v
+		case Clamp:
+			if step.Min != nil && v < *step.Min {
+				v = *step.Min
+			}
+			if step.Max != nil && v > *step.Max {
+				v = *step.Max
+			}
+		}
+	}
+	return v
+}
+
+// Apply returns the points of data with their values transformed. data
+// itself is not modified.
+func (p Pipeline) Apply(data []models.TimeSeriesData) []models.TimeSeriesData {
+	if p.Empty() {
+		return data
+	}
+	transformed := make([]models.TimeSeriesData, len(data))
+	for i, point := range data {
+		point.Value = p.Value(point.Value)
+		transformed[i] = point
+	}
+	return transformed
+}
+
+// Repository decorates a TimeSeriesRepository so that points are written
+// with their values transformed. It wraps the repository of a single
+// ingestion source, outside every other decorator, so that duplicates are
+// collapsed and subscribers are notified with the corrected values.
+type Repository struct {
+	database.TimeSeriesRepository
+	pipeline Pipeline
+}
+
+// NewRepository wraps repo to transform the points written through it
+// with pipeline.
+func NewRepository(repo database.TimeSeriesRepository, pipeline Pipeline) *Repository {
+	return &Repository{
+		TimeSeriesRepository: repo,
+		pipeline:             pipeline,
+	}
+}
+
+// InsertTimeSeriesData transforms a single point and inserts it.
+//
+// Deprecated: Use InsertTimeSeriesDataContext.
+func (r *Repository) InsertTimeSeriesData(timestamp time.Time, value float64) error {
+	return r.InsertTimeSeriesDataContext(context.Background(), timestamp, value)
+}
+
+// InsertTimeSeriesDataContext transforms a single point and inserts it.
+func (r *Repository) InsertTimeSeriesDataContext(ctx context.Context, timestamp time.Time, value float64) error {
+	return r.TimeSeriesRepository.InsertTimeSeriesDataContext(ctx, timestamp, r.pipeline.Value(value))
+}
+
+// BatchInsertTimeSeriesData transforms a batch and inserts the result.
+func (r *Repository) BatchInsertTimeSeriesData(ctx context.Context, data []models.TimeSeriesData) error {
+	return r.TimeSeriesRepository.BatchInsertTimeSeriesData(ctx, r.pipeline.Apply(data))
+}
+
+// Compile-time interface implementation check
+var _ database.TimeSeriesRepository = (*Repository)(nil)
@@ -0,0 +1,95 @@
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/database/mocks"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+func TestPipeline(t *testing.T) {
+	zero, hundred := 0.0, 100.0
+
+	tests := []struct {
+		name  string
+		steps []Step
+		in    float64
+		want  float64
+	}{
+		{name: "empty", in: 42, want: 42},
+		{name: "scale", steps: []Step{{Type: Scale, Factor: 0.001}}, in: 42000, want: 42},
+		{name: "offset", steps: []Step{{Type: Offset, Offset: -1.5}}, in: 42, want: 40.5},
+		{name: "negate", steps: []Step{{Type: Negate}}, in: -42, want: 42},
+		{name: "clamp below", steps: []Step{{Type: Clamp, Min: &zero, Max: &hundred}}, in: -3, want: 0},
+		{name: "clamp above", steps: []Step{{Type: Clamp, Max: &hundred}}, in: 1e9, want: 100},
+		{name: "clamp within", steps: []Step{{Type: Clamp, Min: &zero}}, in: 42, want: 42},
+		{
+			name: "steps apply in order",
+			steps: []Step{
+				{Type: Negate},
+				{Type: Scale, Factor: 0.001},
+				{Type: Offset, Offset: 2},
+				{Type: Clamp, Min: &zero},
+			},
+			in:   -40000,
+			want: 42,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewPipeline(tt.steps)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, pipeline.Value(tt.in), 1e-9)
+		})
+	}
+
+	t.Run("invalid steps", func(t *testing.T) {
+		for _, steps := range [][]Step{
+			{{Type: "round"}},
+			{{Type: Scale}},
+			{{Type: Clamp}},
+			{{Type: Clamp, Min: &hundred, Max: &zero}},
+		} {
+			_, err := NewPipeline(steps)
+			assert.Error(t, err, "%+v", steps)
+		}
+	})
+
+	t.Run("apply leaves the batch unchanged", func(t *testing.T) {
+		pipeline, err := NewPipeline([]Step{{Type: Negate}})
+		require.NoError(t, err)
+		t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		data := []models.TimeSeriesData{{Time: t0, Value: 1}, {Time: t0.Add(time.Minute), Value: -2}}
+
+		got := pipeline.Apply(data)
+		assert.Equal(t, []models.TimeSeriesData{{Time: t0, Value: -1}, {Time: t0.Add(time.Minute), Value: 2}}, got)
+		assert.Equal(t, 1.0, data[0].Value)
+	})
+}
+
+func TestRepository(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := mocks.NewMockTimeSeriesRepository(ctrl)
+
+	pipeline, err := NewPipeline([]Step{{Type: Scale, Factor: 0.001}})
+	require.NoError(t, err)
+	repo := NewRepository(mockRepo, pipeline)
+	ctx := context.Background()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockRepo.EXPECT().
+		BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{{Time: t0, Value: 1.5}}).
+		Return(nil)
+	require.NoError(t, repo.BatchInsertTimeSeriesData(ctx, []models.TimeSeriesData{{Time: t0, Value: 1500}}))
+
+	mockRepo.EXPECT().InsertTimeSeriesDataContext(ctx, t0, 2.0).Return(nil)
+	require.NoError(t, repo.InsertTimeSeriesDataContext(ctx, t0, 2000))
+}
@@ -0,0 +1,92 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// feedLookback is how far before the requested range a feed is queried,
+// so that the reading in effect at the start of the range is included
+const feedLookback = 24 * time.Hour
+
+// feedTimeout bounds a single feed request
+const feedTimeout = 30 * time.Second
+
+// FeedSource reads outdoor temperatures from an external HTTP feed. The
+// feed is queried with start and end parameters in the same format as the
+// upstream series API, and each point of the response is a temperature in
+// degrees Celsius.
+type FeedSource struct {
+	url     string
+	decoder api.Decoder
+	client  *http.Client
+}
+
+// NewFeedSource creates a source for the feed at rawURL, whose responses
+// are read with decoder. rawURL may carry query parameters of its own.
+func NewFeedSource(rawURL string, decoder api.Decoder) (*FeedSource, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, fmt.Errorf("invalid temperature feed URL: %w", err)
+	}
+	return &FeedSource{
+		url:     rawURL,
+		decoder: decoder,
+		client:  http.DefaultClient,
+	}, nil
+}
+
+// Temperatures fetches the feed's readings from a day before start until
+// end.
+func (f *FeedSource) Temperatures(ctx context.Context, start, end time.Time) ([]models.TimeSeriesData, error) {
+	ctx, cancel := context.WithTimeout(ctx, feedTimeout)
+	defer cancel()
+
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid temperature feed URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("start", start.Add(-feedLookback).Format("2006-01-02T15:04:05"))
+	query.Set("end", end.Format("2006-01-02T15:04:05"))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temperature request: %w", err)
+	}
+	req.Header.Set("User-Agent", "EdgeCom-Client/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("temperature feed request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("temperature feed returned %d", resp.StatusCode)
+	}
+
+	var readings []models.TimeSeriesData
+	err = f.decoder.Decode(resp.Body, func(point models.TimeSeriesData) error {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			return fmt.Errorf("invalid temperature: %v", point.Value)
+		}
+		readings = append(readings, point)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode temperature feed: %w", err)
+	}
+	sort.Slice(readings, func(i, j int) bool { return readings[i].Time.Before(readings[j].Time) })
+	return readings, nil
+}
@@ -0,0 +1,223 @@
+// Package weather normalizes consumption for the weather, so that periods
+// can be compared without differences in heating and cooling dominating
+// the comparison.
+//
+// Outdoor temperatures come from a Source and are turned into heating and
+// cooling degrees relative to a balance point, the temperature at which
+// neither is needed. A Model is fitted by least squares to consumption
+// buckets and their degrees, as a base load plus a heating and a cooling
+// slope, and Normalize restates a bucket at reference degrees, such as the
+// normal degrees of its time of year, averaged over previous years.
+//
+// Example Usage:
+//
+//	heating, cooling, ok := weather.Degrees(temperatures, day, 24*time.Hour, weather.DefaultBalancePoint)
+//	...
+//	model, err := weather.Fit(observations, weather.DefaultBalancePoint)
+//	if err != nil {
+//	    return err
+//	}
+//	normalized := model.Normalize(observation, referenceHeating, referenceCooling)
+package weather
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+// DefaultBalancePoint is the outdoor temperature, in degrees Celsius,
+// below which buildings are assumed to heat and above which they cool
+const DefaultBalancePoint = 18.0
+
+// DefaultNormalYears is the number of previous years the normal weather
+// of a bucket is averaged over
+const DefaultNormalYears = 10
+
+// minObservations is the fewest buckets a model is fitted to
+const minObservations = 3
+
+// Source provides outdoor temperatures for a time range.
+type Source interface {
+	// Temperatures returns readings covering [start, end], ordered by
+	// time, including the reading in effect at start. Each reading
+	// applies until the next one.
+	Temperatures(ctx context.Context, start, end time.Time) ([]models.TimeSeriesData, error)
+}
+
+// Degrees returns the mean heating and cooling degrees relative to balance
+// of the readings in [start, start+window), or of the reading in effect at
+// start if none falls in the bucket. It returns false if no reading is in
+// effect at start. readings must be ordered by time.
+func Degrees(readings []models.TimeSeriesData, start time.Time, window time.Duration, balance float64) (float64, float64, bool) {
+	end := start.Add(window)
+	// Index of the first reading after start
+	i := sort.Search(len(readings), func(i int) bool { return readings[i].Time.After(start) })
+
+	var heating, cooling float64
+	n := 0
+	add := func(reading models.TimeSeriesData) {
+		heating += math.Max(balance-reading.Value, 0)
+		cooling += math.Max(reading.Value-balance, 0)
+		n++
+	}
+	first := i
+	if i > 0 && readings[i-1].Time.Equal(start) {
+		first--
+	}
+	for j := first; j < len(readings) && readings[j].Time.Before(end); j++ {
+		add(readings[j])
+	}
+	if n == 0 {
+		if i == 0 {
+			return 0, 0, false
+		}
+		add(readings[i-1])
+	}
+	return heating / float64(n), cooling / float64(n), true
+}
+
+// Observation is a consumption bucket and its mean heating and cooling
+// degrees.
+type Observation struct {
+	Value   float64
+	Heating float64
+	Cooling float64
+}
+
+// Model is consumption as a base load plus a slope per heating and per
+// cooling degree, fitted by least squares.
+type Model struct {
+	// BalancePoint is the temperature the degrees are relative to
+	BalancePoint float64
+	BaseLoad     float64
+	// HeatingSlope and CoolingSlope are the consumption per degree. A
+	// slope is zero if the fitted buckets never needed heating or cooling.
+	HeatingSlope float64
+	CoolingSlope float64
+	// RSquared is the fraction of the variance of consumption the model
+	// explains
+	RSquared float64
+	// Observations is the number of buckets fitted
+	Observations int
+}
+
+// Fit fits a model to observations, whose degrees are relative to balance.
+// Heating or cooling degrees that are the same in every bucket, such as
+// cooling degrees over a winter, cannot be told apart from the base load
+// and are left out of the model.
+func Fit(observations []Observation, balance float64) (Model, error) {
+	if len(observations) < minObservations {
+		return Model{}, fmt.Errorf("at least %d buckets with temperatures are needed to fit a weather model, got %d", minObservations, len(observations))
+	}
+
+	// The terms of the model, the base load first
+	terms := []func(Observation) float64{func(Observation) float64 { return 1 }}
+	heating, cooling := -1, -1
+	if varies(observations, func(o Observation) float64 { return o.Heating }) {
+		heating = len(terms)
+		terms = append(terms, func(o Observation) float64 { return o.Heating })
+	}
+	if varies(observations, func(o Observation) float64 { return o.Cooling }) {
+		cooling = len(terms)
+		terms = append(terms, func(o Observation) float64 { return o.Cooling })
+	}
+
+	// Normal equations: (XᵀX) β = Xᵀy
+	k := len(terms)
+	a := make([][]float64, k)
+	for i := range a {
+		a[i] = make([]float64, k+1)
+	}
+	for _, o := range observations {
+		for i, ti := range terms {
+			xi := ti(o)
+			for j, tj := range terms {
+				a[i][j] += xi * tj(o)
+			}
+			a[i][k] += xi * o.Value
+		}
+	}
+	beta, err := solve(a)
+	if err != nil {
+		return Model{}, err
+	}
+
+	m := Model{BalancePoint: balance, BaseLoad: beta[0], Observations: len(observations)}
+	if heating >= 0 {
+		m.HeatingSlope = beta[heating]
+	}
+	if cooling >= 0 {
+		m.CoolingSlope = beta[cooling]
+	}
+
+	var mean, residual, total float64
+	for _, o := range observations {
+		mean += o.Value
+	}
+	mean /= float64(len(observations))
+	for _, o := range observations {
+		fitted := m.BaseLoad + m.HeatingSlope*o.Heating + m.CoolingSlope*o.Cooling
+		residual += (o.Value - fitted) * (o.Value - fitted)
+		total += (o.Value - mean) * (o.Value - mean)
+	}
+	m.RSquared = 1
+	if total > 0 {
+		m.RSquared = 1 - residual/total
+	}
+	return m, nil
+}
+
+// Normalize restates the consumption of o at the given heating and cooling
+// degrees, removing the consumption the model attributes to the difference
+// between them and those of o.
+func (m Model) Normalize(o Observation, heating, cooling float64) float64 {
+	return o.Value - m.HeatingSlope*(o.Heating-heating) - m.CoolingSlope*(o.Cooling-cooling)
+}
+
+// varies reports whether value differs between observations
+func varies(observations []Observation, value func(Observation) float64) bool {
+	for _, o := range observations[1:] {
+		if math.Abs(value(o)-value(observations[0])) > 1e-9 {
+			return true
+		}
+	}
+	return false
+}
+
+// solve solves the linear system of the augmented matrix a by Gaussian
+// elimination with partial pivoting
+func solve(a [][]float64) ([]float64, error) {
+	k := len(a)
+	for col := 0; col < k; col++ {
+		pivot := col
+		for row := col + 1; row < k; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, fmt.Errorf("the weather model cannot be fitted: heating and cooling degrees are not independent")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := col + 1; row < k; row++ {
+			f := a[row][col] / a[col][col]
+			for j := col; j <= k; j++ {
+				a[row][j] -= f * a[col][j]
+			}
+		}
+	}
+	x := make([]float64, k)
+	for row := k - 1; row >= 0; row-- {
+		sum := a[row][k]
+		for j := row + 1; j < k; j++ {
+			sum -= a[row][j] * x[j]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, nil
+}
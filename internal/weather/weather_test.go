@@ -0,0 +1,130 @@
+package weather
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tejusbharadwaj/edgecom/internal/api"
+	"github.com/tejusbharadwaj/edgecom/internal/models"
+)
+
+var base = time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC)
+
+func TestDegrees(t *testing.T) {
+	readings := []models.TimeSeriesData{
+		{Time: base, Value: 10},
+		{Time: base.Add(time.Hour), Value: 14},
+		{Time: base.Add(2 * time.Hour), Value: 24},
+	}
+
+	tests := []struct {
+		name             string
+		start            time.Time
+		window           time.Duration
+		heating, cooling float64
+		found            bool
+	}{
+		{name: "before the first reading", start: base.Add(-time.Hour), window: time.Hour},
+		{name: "readings in the bucket are averaged", start: base, window: 2 * time.Hour, heating: 6, found: true},
+		{name: "heating and cooling", start: base.Add(time.Hour), window: 2 * time.Hour, heating: 2, cooling: 3, found: true},
+		{name: "reading in effect", start: base.Add(90 * time.Minute), window: 5 * time.Minute, heating: 4, found: true},
+		{name: "after the last reading", start: base.AddDate(0, 0, 1), window: time.Hour, cooling: 6, found: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heating, cooling, found := Degrees(readings, tt.start, tt.window, DefaultBalancePoint)
+			assert.Equal(t, tt.found, found)
+			assert.InDelta(t, tt.heating, heating, 1e-9)
+			assert.InDelta(t, tt.cooling, cooling, 1e-9)
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	t.Run("heating and cooling", func(t *testing.T) {
+		var observations []Observation
+		for _, d := range [][2]float64{{0, 0}, {5, 0}, {10, 0}, {0, 4}, {0, 8}, {2, 1}} {
+			observations = append(observations, Observation{Value: 100 + 3*d[0] + 5*d[1], Heating: d[0], Cooling: d[1]})
+		}
+		model, err := Fit(observations, DefaultBalancePoint)
+		require.NoError(t, err)
+		assert.InDelta(t, 100, model.BaseLoad, 1e-6)
+		assert.InDelta(t, 3, model.HeatingSlope, 1e-6)
+		assert.InDelta(t, 5, model.CoolingSlope, 1e-6)
+		assert.InDelta(t, 1, model.RSquared, 1e-9)
+		assert.Equal(t, 6, model.Observations)
+
+		// A cold bucket restated at milder weather
+		assert.InDelta(t, 106, model.Normalize(observations[2], 2, 0), 1e-6)
+	})
+
+	t.Run("winter only", func(t *testing.T) {
+		observations := []Observation{
+			{Value: 110, Heating: 5},
+			{Value: 118, Heating: 10},
+			{Value: 106, Heating: 2},
+			{Value: 116, Heating: 8},
+		}
+		model, err := Fit(observations, DefaultBalancePoint)
+		require.NoError(t, err)
+		assert.Zero(t, model.CoolingSlope)
+		assert.Greater(t, model.HeatingSlope, 1.0)
+		assert.Less(t, model.RSquared, 1.0)
+	})
+
+	t.Run("too few buckets", func(t *testing.T) {
+		_, err := Fit([]Observation{{Value: 1}, {Value: 2}}, DefaultBalancePoint)
+		assert.ErrorContains(t, err, "at least 3 buckets")
+	})
+
+	t.Run("dependent degrees", func(t *testing.T) {
+		// Cooling degrees always twice the heating degrees
+		observations := []Observation{
+			{Value: 1, Heating: 1, Cooling: 2},
+			{Value: 2, Heating: 2, Cooling: 4},
+			{Value: 3, Heating: 3, Cooling: 6},
+		}
+		_, err := Fit(observations, DefaultBalancePoint)
+		assert.ErrorContains(t, err, "not independent")
+	})
+}
+
+func TestFeedSource(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"result": [{"time": 1732323600, "value": 7.5}, {"time": 1732320000, "value": 6}]}`))
+	}))
+	defer srv.Close()
+
+	decoder, err := api.NewDecoder(api.DecoderConfig{})
+	require.NoError(t, err)
+
+	source, err := NewFeedSource(srv.URL+"?station=EGLL", decoder)
+	require.NoError(t, err)
+	readings, err := source.Temperatures(context.Background(), base, base.Add(2*time.Hour))
+	require.NoError(t, err)
+
+	assert.Equal(t, "end=2024-11-23T02%3A00%3A00&start=2024-11-22T00%3A00%3A00&station=EGLL", query)
+	require.Len(t, readings, 2)
+	assert.True(t, readings[0].Time.Equal(base), "readings are ordered by time")
+	assert.Equal(t, 7.5, readings[1].Value)
+
+	t.Run("error status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+
+		source, err := NewFeedSource(srv.URL, decoder)
+		require.NoError(t, err)
+		_, err = source.Temperatures(context.Background(), base, base.Add(time.Hour))
+		assert.ErrorContains(t, err, "temperature feed returned 502")
+	})
+}
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned by VerifySignature for requests that
+// were not signed with the secret, or too long ago.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the X-Edgecom-Signature header value of body, sent at t
+// and signed with secret.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// VerifySignature checks the X-Edgecom-Signature header value of a
+// request with the given body against secret, rejecting signatures made
+// more than tolerance before or after now, which could be replays.
+func VerifySignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	expected := signature(secret, timestamp, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// signature returns the hex-encoded HMAC-SHA256 of the timestamp, a
+// period and the body
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
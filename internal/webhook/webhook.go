@@ -0,0 +1,516 @@
+// Package webhook notifies external systems of alerts and ingestion runs
+// over HTTP.
+//
+// Each webhook receives the events it subscribes to as a POST request.
+// The body is the event as JSON, or the output of a Go text/template,
+// so receivers with fixed schemas such as PagerDuty, Microsoft Teams or
+// in-house systems can be targeted from configuration alone. Templates
+// are executed with the Event as data and have these functions:
+//
+//   - json: the value encoded as JSON, for embedding strings and maps
+//   - rfc3339: a time formatted as RFC 3339 in UTC
+//   - upper, lower: the string in upper or lower case
+//
+// Every request carries the event type in an X-Edgecom-Event header and
+// an ID, unique to the event and the webhook, in X-Edgecom-Delivery, so
+// receivers can discard retried deliveries they have already processed.
+// Webhooks with a secret are signed: X-Edgecom-Signature holds
+// "t=<unix time>,v1=<signature>", the signature being the hex-encoded
+// HMAC-SHA256 of the time, a period and the body, keyed with the secret.
+// VerifySignature checks it.
+//
+// Notify only queues an event; Run delivers queued events, in order for
+// each webhook and in parallel across webhooks, so a slow webhook neither
+// holds up the caller nor other webhooks. Deliveries failing with a
+// network error, 408, 429 or a 5xx status are retried with exponential
+// backoff, honouring Retry-After, up to the webhook's MaxAttempts. Events
+// for a webhook whose queue is full are dropped and logged.
+//
+// Example Usage:
+//
+//	notifier, err := webhook.NewNotifier([]webhook.Webhook{{
+//	    Name:   "pagerduty",
+//	    URL:    "https://events.pagerduty.com/v2/enqueue",
+//	    Events: []string{webhook.EventBudgetThreshold, webhook.EventIngestionFailing},
+//	    Template: `{"routing_key": "R0UT1NGKEY", "event_action": "trigger",
+//	        "payload": {"summary": {{json .Summary}}, "severity": {{json .Severity}},
+//	        "source": "edgecom", "custom_details": {{json .Fields}}}}`,
+//	}}, logger)
+//	if err != nil {
+//	    return err
+//	}
+//	go notifier.Run(ctx)
+//
+//	notifier.Notify(ctx, webhook.Event{
+//	    Type:     webhook.EventIngestionFailed,
+//	    Time:     time.Now(),
+//	    Severity: webhook.SeverityError,
+//	    Summary:  "Data collection failed",
+//	})
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event types
+const (
+	// EventBudgetThreshold is sent when a budget's month-to-date actual
+	// first reaches one of its thresholds in a month
+	EventBudgetThreshold = "budget.threshold"
+	// EventBudgetProjected is sent when a budget is first projected to be
+	// exceeded in a month
+	EventBudgetProjected = "budget.projected"
+	// EventIngestionCompleted is sent after each successful collection run
+	EventIngestionCompleted = "ingestion.completed"
+	// EventIngestionFailed is sent after each failed collection run
+	EventIngestionFailed = "ingestion.failed"
+	// EventIngestionFailing is sent when collection has failed a number
+	// of consecutive times, once per run of failures
+	EventIngestionFailing = "ingestion.failing"
+	// EventBootstrapCompleted is sent when the historical bootstrap has
+	// loaded the initial data
+	EventBootstrapCompleted = "bootstrap.completed"
+	// EventAnomalyDetected is sent when an anomaly rule finds an ingested
+	// sample abnormal
+	EventAnomalyDetected = "anomaly.detected"
+)
+
+// EventTypes lists every event type
+var EventTypes = []string{
+	EventBudgetThreshold, EventBudgetProjected, EventIngestionCompleted, EventIngestionFailed,
+	EventIngestionFailing, EventBootstrapCompleted, EventAnomalyDetected,
+}
+
+// Event severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityError    = "error"
+	SeverityCritical = "critical"
+)
+
+// DefaultTimeout bounds a delivery when a webhook does not set its own
+const DefaultTimeout = 10 * time.Second
+
+// DefaultMaxAttempts is the number of times an event is sent to a webhook
+// before giving up, when the webhook does not set its own
+const DefaultMaxAttempts = 3
+
+// DefaultRetryBackoff is the delay before the first retry, doubling with
+// each further retry, when a webhook does not set its own
+const DefaultRetryBackoff = time.Second
+
+// maxRetryDelay caps the delay between attempts, including delays asked
+// for with Retry-After
+const maxRetryDelay = time.Minute
+
+// queueSize is the number of events waiting to be delivered to a webhook
+// before further events for it are dropped
+const queueSize = 100
+
+// drainTimeout bounds delivering the queued events once Run is stopped
+const drainTimeout = 10 * time.Second
+
+// Request headers
+const (
+	// HeaderEvent carries the event type
+	HeaderEvent = "X-Edgecom-Event"
+	// HeaderDelivery carries an ID that is the same for every attempt to
+	// deliver an event to a webhook
+	HeaderDelivery = "X-Edgecom-Delivery"
+	// HeaderSignature carries the signature of signed deliveries
+	HeaderSignature = "X-Edgecom-Signature"
+)
+
+// Secret is a signing secret that may change while the service runs, such
+// as a *secret.File kept current by a secret.Watcher
+type Secret interface {
+	Value() string
+}
+
+// Event is a notification sent to webhooks.
+type Event struct {
+	Type     string                 `json:"type"`
+	Time     time.Time              `json:"time"`
+	Severity string                 `json:"severity"`
+	Summary  string                 `json:"summary"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Webhook is an HTTP endpoint receiving events.
+type Webhook struct {
+	Name string
+	URL  string
+	// Events are the event types sent to the webhook, all when empty
+	Events []string
+	// Headers are added to every request, for example for authentication
+	Headers map[string]string
+	// Template renders the request body; the event as JSON when empty
+	Template string
+	// ContentType of the body, application/json by default
+	ContentType string
+	// Timeout bounds each attempt of a delivery, DefaultTimeout when zero
+	Timeout time.Duration
+	// Secret signs the requests when set, as does the current value of
+	// SecretFile
+	Secret     string
+	SecretFile Secret
+	// MaxAttempts is the number of times an event is sent before giving
+	// up, DefaultMaxAttempts when zero; 1 disables retries
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry, doubling with each
+	// further retry, DefaultRetryBackoff when zero
+	RetryBackoff time.Duration
+}
+
+// Validate checks that the webhook is usable and its template parses.
+func (w Webhook) Validate() error {
+	_, err := w.parse()
+	return err
+}
+
+// parse validates the webhook and parses its template
+func (w Webhook) parse() (*template.Template, error) {
+	if w.Name == "" {
+		return nil, fmt.Errorf("webhook name is required")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("webhook %s: invalid URL %q", w.Name, w.URL)
+	}
+	for _, event := range w.Events {
+		if !knownEvent(event) {
+			return nil, fmt.Errorf("webhook %s: unknown event %q, expected one of %s", w.Name, event, strings.Join(EventTypes, ", "))
+		}
+	}
+	if w.Timeout < 0 {
+		return nil, fmt.Errorf("webhook %s: timeout must not be negative", w.Name)
+	}
+	if w.Secret != "" && w.SecretFile != nil {
+		return nil, fmt.Errorf("webhook %s: secret and secret file are mutually exclusive", w.Name)
+	}
+	if w.MaxAttempts < 0 {
+		return nil, fmt.Errorf("webhook %s: max attempts must not be negative", w.Name)
+	}
+	if w.RetryBackoff < 0 {
+		return nil, fmt.Errorf("webhook %s: retry backoff must not be negative", w.Name)
+	}
+	if w.Template == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(w.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(w.Template)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: invalid template: %w", w.Name, err)
+	}
+	return tmpl, nil
+}
+
+func knownEvent(event string) bool {
+	for _, known := range EventTypes {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFuncs are available to payload templates
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// endpoint is a webhook with its parsed template
+type endpoint struct {
+	Webhook
+	template *template.Template
+	events   map[string]bool
+	queue    chan Event
+}
+
+// Notifier delivers events to webhooks.
+type Notifier struct {
+	endpoints []endpoint
+	disabled  map[string]bool
+	client    *http.Client
+	logger    *logrus.Logger
+}
+
+// NewNotifier creates a notifier for webhooks, validating each and
+// parsing its template.
+func NewNotifier(webhooks []Webhook, logger *logrus.Logger) (*Notifier, error) {
+	names := make(map[string]bool, len(webhooks))
+	endpoints := make([]endpoint, 0, len(webhooks))
+	for _, w := range webhooks {
+		tmpl, err := w.parse()
+		if err != nil {
+			return nil, err
+		}
+		if names[w.Name] {
+			return nil, fmt.Errorf("duplicate webhook: %s", w.Name)
+		}
+		names[w.Name] = true
+
+		e := endpoint{Webhook: w, template: tmpl, queue: make(chan Event, queueSize)}
+		if len(w.Events) > 0 {
+			e.events = make(map[string]bool, len(w.Events))
+			for _, event := range w.Events {
+				e.events[event] = true
+			}
+		}
+		if e.ContentType == "" {
+			e.ContentType = "application/json"
+		}
+		if e.Timeout == 0 {
+			e.Timeout = DefaultTimeout
+		}
+		if e.MaxAttempts == 0 {
+			e.MaxAttempts = DefaultMaxAttempts
+		}
+		if e.RetryBackoff == 0 {
+			e.RetryBackoff = DefaultRetryBackoff
+		}
+		endpoints = append(endpoints, e)
+	}
+	return &Notifier{endpoints: endpoints, client: &http.Client{}, logger: logger}, nil
+}
+
+// Disable stops events of the given types from being sent to any webhook,
+// whatever they subscribe to. It must be called before the notifier is
+// first used.
+func (n *Notifier) Disable(eventTypes ...string) error {
+	for _, eventType := range eventTypes {
+		if !knownEvent(eventType) {
+			return fmt.Errorf("unknown event %q, expected one of %s", eventType, strings.Join(EventTypes, ", "))
+		}
+		if n.disabled == nil {
+			n.disabled = make(map[string]bool)
+		}
+		n.disabled[eventType] = true
+	}
+	return nil
+}
+
+// Notify queues event for every webhook subscribed to its type and
+// returns without waiting for the deliveries, which Run makes. ctx is not
+// used, as deliveries outlive the call.
+func (n *Notifier) Notify(_ context.Context, event Event) {
+	if n.disabled[event.Type] {
+		return
+	}
+	for i := range n.endpoints {
+		e := &n.endpoints[i]
+		if e.events != nil && !e.events[event.Type] {
+			continue
+		}
+		select {
+		case e.queue <- event:
+		default:
+			n.logger.WithFields(logrus.Fields{
+				"webhook": e.Name,
+				"event":   event.Type,
+			}).Error("Webhook queue is full, dropping event")
+		}
+	}
+}
+
+// Run delivers queued events until ctx is done, then delivers those still
+// queued within drainTimeout. Deliveries that still fail after their
+// retries are logged.
+func (n *Notifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range n.endpoints {
+		e := &n.endpoints[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.run(ctx, e)
+		}()
+	}
+	wg.Wait()
+}
+
+// run delivers the events queued for e, one at a time
+func (n *Notifier) run(ctx context.Context, e *endpoint) {
+	// Checked first, as select picks among ready cases at random
+	for ctx.Err() == nil {
+		select {
+		case event := <-e.queue:
+			n.deliverLogged(ctx, e, event)
+		case <-ctx.Done():
+		}
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+	defer cancel()
+	for {
+		select {
+		case event := <-e.queue:
+			n.deliverLogged(drainCtx, e, event)
+		default:
+			return
+		}
+	}
+}
+
+// deliverLogged delivers event to e, logging a failure
+func (n *Notifier) deliverLogged(ctx context.Context, e *endpoint, event Event) {
+	if err := n.deliver(ctx, e, event); err != nil {
+		n.logger.WithError(err).WithFields(logrus.Fields{
+			"webhook": e.Name,
+			"event":   event.Type,
+		}).Error("Failed to deliver webhook")
+	}
+}
+
+// render returns the body sent to e for event
+func render(e *endpoint, event Event) ([]byte, error) {
+	if e.template == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := e.template.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// deliver sends event to e, retrying failed attempts that may succeed
+// when repeated
+func (n *Notifier) deliver(ctx context.Context, e *endpoint, event Event) error {
+	body, err := render(e, event)
+	if err != nil {
+		return err
+	}
+	id, err := deliveryID()
+	if err != nil {
+		return err
+	}
+
+	backoff := e.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := n.send(ctx, e, event.Type, id, body)
+		if err == nil || retryAfter < 0 || attempt >= e.MaxAttempts {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return err
+		}
+
+		delay := backoff
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		n.logger.WithError(err).WithFields(logrus.Fields{
+			"webhook": e.Name,
+			"event":   event.Type,
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("Webhook delivery failed, retrying")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (gave up after %d attempts: %v)", err, attempt, ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// send makes one attempt to deliver body. A failed attempt that should
+// not be retried returns a negative delay; others return the delay the
+// webhook asked for with Retry-After, or zero.
+func (n *Notifier) send(ctx context.Context, e *endpoint, eventType, id string, body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", e.ContentType)
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, id)
+	if secret := e.secret(); secret != "" {
+		req.Header.Set(HeaderSignature, Sign(secret, time.Now(), body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 == 2 {
+		return 0, nil
+	}
+
+	err = fmt.Errorf("unexpected status: %s", resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout:
+		return 0, err
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return parseRetryAfter(resp.Header.Get("Retry-After")), err
+	default:
+		return -1, err
+	}
+}
+
+// secret returns the current signing secret of e, empty when requests are
+// not signed
+func (e *endpoint) secret() string {
+	if e.SecretFile != nil {
+		return e.SecretFile.Value()
+	}
+	return e.Secret
+}
+
+// parseRetryAfter returns the delay of a Retry-After header in seconds,
+// or zero when it is absent or not a number of seconds
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// deliveryID returns a random delivery ID
+func deliveryID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
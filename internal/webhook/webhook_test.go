@@ -0,0 +1,315 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the requests made to a test server by path
+type receiver struct {
+	mu       sync.Mutex
+	bodies   map[string]string
+	requests map[string]*http.Request
+}
+
+func newReceiver(t *testing.T) (*receiver, *httptest.Server) {
+	r := &receiver{bodies: map[string]string{}, requests: map[string]*http.Request{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.bodies[req.URL.Path] = string(body)
+		r.requests[req.URL.Path] = req
+		r.mu.Unlock()
+		if req.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+// deliverQueued delivers the events queued so far
+func deliverQueued(notifier *Notifier) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	notifier.Run(ctx)
+}
+
+var testEvent = Event{
+	Type:     EventBudgetThreshold,
+	Time:     time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC),
+	Severity: SeverityWarning,
+	Summary:  `Budget "energy" reached 80% of its monthly limit`,
+	Fields:   map[string]interface{}{"budget": "energy", "threshold": 0.8},
+}
+
+func TestNotify(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	r, server := newReceiver(t)
+
+	notifier, err := NewNotifier([]Webhook{
+		{Name: "default", URL: server.URL + "/default"},
+		{
+			Name:    "pagerduty",
+			URL:     server.URL + "/pagerduty",
+			Events:  []string{EventBudgetThreshold},
+			Headers: map[string]string{"Authorization": "Token token=secret"},
+			Template: `{"event_action": "trigger", "payload": {"summary": {{json .Summary}}, ` +
+				`"severity": {{json .Severity}}, "timestamp": {{json (rfc3339 .Time)}}, "custom_details": {{json .Fields}}}}`,
+		},
+		{
+			Name:        "text",
+			URL:         server.URL + "/text",
+			Template:    `{{upper .Severity}}: {{.Summary}} ({{.Fields.budget}})`,
+			ContentType: "text/plain",
+		},
+		{Name: "ingestion only", URL: server.URL + "/ingestion", Events: []string{EventIngestionFailed}},
+		{Name: "broken", URL: server.URL + "/broken", MaxAttempts: 1},
+	}, logger)
+	require.NoError(t, err)
+
+	notifier.Notify(context.Background(), testEvent)
+	deliverQueued(notifier)
+
+	var event Event
+	require.NoError(t, json.Unmarshal([]byte(r.bodies["/default"]), &event))
+	assert.Equal(t, testEvent.Summary, event.Summary)
+	assert.Equal(t, "application/json", r.requests["/default"].Header.Get("Content-Type"))
+	assert.Equal(t, EventBudgetThreshold, r.requests["/default"].Header.Get(HeaderEvent))
+	assert.Len(t, r.requests["/default"].Header.Get(HeaderDelivery), 32)
+	assert.Empty(t, r.requests["/default"].Header.Get(HeaderSignature), "webhooks without a secret are not signed")
+
+	assert.JSONEq(t, `{"event_action": "trigger", "payload": {"summary": "Budget \"energy\" reached 80% of its monthly limit",
+		"severity": "warning", "timestamp": "2024-11-23T12:00:00Z", "custom_details": {"budget": "energy", "threshold": 0.8}}}`,
+		r.bodies["/pagerduty"])
+	assert.Equal(t, "Token token=secret", r.requests["/pagerduty"].Header.Get("Authorization"))
+
+	assert.Equal(t, `WARNING: Budget "energy" reached 80% of its monthly limit (energy)`, r.bodies["/text"])
+	assert.Equal(t, "text/plain", r.requests["/text"].Header.Get("Content-Type"))
+
+	assert.NotContains(t, r.bodies, "/ingestion", "unsubscribed events are not sent")
+	assert.Contains(t, r.bodies, "/broken", "failures do not stop other deliveries")
+}
+
+func TestNotifyAsync(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		mu.Lock()
+		received = append(received, req.URL.Path)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	notifier, err := NewNotifier([]Webhook{
+		{Name: "slow", URL: server.URL + "/slow"},
+		{Name: "fast", URL: server.URL + "/fast"},
+	}, logger)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Run(ctx)
+
+	for i := 0; i < 3; i++ {
+		notifier.Notify(context.Background(), testEvent)
+	}
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 3
+	}, 5*time.Second, 10*time.Millisecond, "a slow webhook does not hold up the caller or other webhooks")
+	for _, path := range received {
+		assert.Equal(t, "/fast", path)
+	}
+
+	t.Run("queue full", func(t *testing.T) {
+		notifier, err := NewNotifier([]Webhook{{Name: "unread", URL: server.URL + "/fast"}}, logger)
+		require.NoError(t, err)
+		for i := 0; i < queueSize+2; i++ {
+			notifier.Notify(context.Background(), testEvent)
+		}
+		assert.Len(t, notifier.endpoints[0].queue, queueSize, "events for a full queue are dropped")
+	})
+}
+
+func TestDeliverErrors(t *testing.T) {
+	_, server := newReceiver(t)
+	notifier, err := NewNotifier([]Webhook{
+		{Name: "broken", URL: server.URL + "/broken", MaxAttempts: 1},
+		{Name: "missing field", URL: server.URL + "/ok", Template: `{{.Fields.site}}`},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	err = notifier.deliver(context.Background(), &notifier.endpoints[0], testEvent)
+	assert.EqualError(t, err, "unexpected status: 502 Bad Gateway")
+
+	err = notifier.deliver(context.Background(), &notifier.endpoints[1], testEvent)
+	assert.ErrorContains(t, err, "failed to render template")
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		wantErr string
+	}{
+		{"valid", Webhook{Name: "teams", URL: "https://example.com/hook", Template: `{"text": {{json .Summary}}}`}, ""},
+		{"missing name", Webhook{URL: "https://example.com/hook"}, "webhook name is required"},
+		{"invalid URL", Webhook{Name: "teams", URL: "example.com/hook"}, "invalid URL"},
+		{"unknown event", Webhook{Name: "teams", URL: "https://example.com/hook", Events: []string{"budget.exceeded"}}, "unknown event"},
+		{"invalid template", Webhook{Name: "teams", URL: "https://example.com/hook", Template: `{{.Summary`}, "invalid template"},
+		{"unknown function", Webhook{Name: "teams", URL: "https://example.com/hook", Template: `{{yaml .Fields}}`}, "invalid template"},
+		{"secret and secret file", Webhook{Name: "teams", URL: "https://example.com/hook", Secret: "s", SecretFile: staticSecret("f")}, "mutually exclusive"},
+		{"negative max attempts", Webhook{Name: "teams", URL: "https://example.com/hook", MaxAttempts: -1}, "max attempts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.webhook.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err := NewNotifier([]Webhook{
+		{Name: "teams", URL: "https://example.com/a"},
+		{Name: "teams", URL: "https://example.com/b"},
+	}, logrus.New())
+	assert.EqualError(t, err, "duplicate webhook: teams")
+}
+
+// staticSecret is a Secret with a fixed value
+type staticSecret string
+
+func (s staticSecret) Value() string { return string(s) }
+
+func TestRetries(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	// serve answers each attempt with the next status code
+	serve := func(statuses ...int) (*httptest.Server, *[]string) {
+		var mu sync.Mutex
+		var deliveries []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			deliveries = append(deliveries, req.Header.Get(HeaderDelivery))
+			w.WriteHeader(statuses[len(deliveries)-1])
+		}))
+		t.Cleanup(server.Close)
+		return server, &deliveries
+	}
+
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		wantErr  string
+	}{
+		{"server error then success", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, 3, ""},
+		{"rate limited then success", []int{http.StatusTooManyRequests, http.StatusNoContent}, 2, ""},
+		{"gives up", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 3, "502 Bad Gateway (after 3 attempts)"},
+		{"client errors are not retried", []int{http.StatusBadRequest}, 1, "400 Bad Request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, deliveries := serve(tt.statuses...)
+			notifier, err := NewNotifier([]Webhook{
+				{Name: "flaky", URL: server.URL, RetryBackoff: time.Millisecond},
+			}, logger)
+			require.NoError(t, err)
+
+			err = notifier.deliver(context.Background(), &notifier.endpoints[0], testEvent)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+			require.Len(t, *deliveries, tt.attempts)
+			for _, id := range *deliveries {
+				assert.Equal(t, (*deliveries)[0], id, "attempts share the delivery ID")
+			}
+		})
+	}
+
+	t.Run("stops when cancelled", func(t *testing.T) {
+		server, deliveries := serve(http.StatusBadGateway, http.StatusBadGateway)
+		notifier, err := NewNotifier([]Webhook{{Name: "flaky", URL: server.URL, RetryBackoff: time.Hour}}, logger)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = notifier.deliver(ctx, &notifier.endpoints[0], testEvent)
+		assert.ErrorContains(t, err, "gave up after 1 attempts")
+		assert.Len(t, *deliveries, 1)
+	})
+
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Wed, 21 Oct 2015 07:28:00 GMT"))
+}
+
+func TestSignature(t *testing.T) {
+	r, server := newReceiver(t)
+	secret := staticSecret("whsec-1")
+	notifier, err := NewNotifier([]Webhook{
+		{Name: "signed", URL: server.URL + "/signed", Secret: "whsec-0"},
+		{Name: "rotated", URL: server.URL + "/rotated", SecretFile: secret},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	notifier.Notify(context.Background(), testEvent)
+	deliverQueued(notifier)
+
+	now := time.Now()
+	body := []byte(r.bodies["/signed"])
+	header := r.requests["/signed"].Header.Get(HeaderSignature)
+	assert.NoError(t, VerifySignature("whsec-0", header, body, now, 5*time.Minute))
+	assert.ErrorIs(t, VerifySignature("other", header, body, now, 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifySignature("whsec-0", header, append(body, ' '), now, 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifySignature("whsec-0", header, body, now.Add(time.Hour), 5*time.Minute), ErrInvalidSignature, "old signatures may be replays")
+	assert.ErrorIs(t, VerifySignature("whsec-0", "v1=abc", body, now, 5*time.Minute), ErrInvalidSignature)
+
+	header = r.requests["/rotated"].Header.Get(HeaderSignature)
+	assert.NoError(t, VerifySignature("whsec-1", header, []byte(r.bodies["/rotated"]), now, 5*time.Minute))
+
+	signed := Sign("whsec-0", time.Unix(1700000000, 0), []byte(`{"type":"budget.threshold"}`))
+	assert.Regexp(t, `^t=1700000000,v1=[0-9a-f]{64}$`, signed)
+}
+
+func TestDisable(t *testing.T) {
+	r, server := newReceiver(t)
+	notifier, err := NewNotifier([]Webhook{{Name: "all", URL: server.URL + "/all"}}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, notifier.Disable(EventBudgetThreshold))
+
+	notifier.Notify(context.Background(), testEvent)
+	deliverQueued(notifier)
+	assert.Empty(t, r.bodies, "disabled events are not sent")
+
+	notifier.Notify(context.Background(), Event{Type: EventBudgetProjected})
+	deliverQueued(notifier)
+	assert.Contains(t, r.bodies, "/all")
+
+	assert.ErrorContains(t, notifier.Disable("budget.exceeded"), "unknown event")
+}
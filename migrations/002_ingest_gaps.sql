@@ -0,0 +1,12 @@
+-- Ranges that could not be ingested and still need to be fetched
+CREATE TABLE IF NOT EXISTS ingest_gaps (
+    id BIGSERIAL PRIMARY KEY,
+    start_time TIMESTAMPTZ NOT NULL,
+    end_time TIMESTAMPTZ NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    resolved_at TIMESTAMPTZ
+);
+
+-- Index for looking up unresolved gaps
+CREATE INDEX IF NOT EXISTS idx_ingest_gaps_pending ON ingest_gaps (start_time) WHERE resolved_at IS NULL;
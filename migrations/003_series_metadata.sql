@@ -0,0 +1,14 @@
+-- Per-series summary maintained in the same transaction as every insert
+CREATE TABLE IF NOT EXISTS series_metadata (
+    series TEXT PRIMARY KEY,
+    first_time TIMESTAMPTZ,
+    last_time TIMESTAMPTZ,
+    point_count BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Seed from data stored before the table existed
+INSERT INTO series_metadata (series, first_time, last_time, point_count)
+SELECT 'default', MIN(time), MAX(time), COUNT(*)
+FROM time_series_data
+ON CONFLICT (series) DO NOTHING;
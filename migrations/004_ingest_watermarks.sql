@@ -0,0 +1,6 @@
+-- End of the range up to which each series has been ingested without gaps
+CREATE TABLE IF NOT EXISTS ingest_watermarks (
+    series TEXT PRIMARY KEY,
+    watermark TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
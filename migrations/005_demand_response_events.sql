@@ -0,0 +1,12 @@
+-- Demand response events: windows in which consumption was asked to drop
+CREATE TABLE IF NOT EXISTS demand_response_events (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    start_time TIMESTAMPTZ NOT NULL,
+    end_time TIMESTAMPTZ NOT NULL,
+    target_reduction DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Index for listing events by time
+CREATE INDEX IF NOT EXISTS idx_demand_response_events_start ON demand_response_events (start_time);
@@ -0,0 +1,11 @@
+-- Schema version: one row per applied migration, so that edgecom doctor can
+-- tell whether a database is up to date. Later migrations insert their own
+-- number.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version)
+SELECT generate_series(1, 6)
+ON CONFLICT (version) DO NOTHING;
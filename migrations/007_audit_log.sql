@@ -0,0 +1,21 @@
+-- Audit log: hash-chained records of API calls. Records are only ever
+-- appended; each holds the hash of its predecessor, so edgecom audit verify
+-- detects rows that were altered or deleted.
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq BIGINT PRIMARY KEY,
+    time TIMESTAMPTZ NOT NULL,
+    subject TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    source TEXT NOT NULL,
+    action TEXT NOT NULL,
+    request TEXT NOT NULL,
+    code TEXT NOT NULL,
+    peer TEXT NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+-- Index for exporting records by time
+CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log (time);
+
+INSERT INTO schema_migrations (version) VALUES (7) ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,38 @@
+-- Daily and monthly consumption summaries, maintained in the same
+-- transaction as every insert, so report pages read a few rows instead of
+-- aggregating the raw series. Periods are UTC days and months. Each holds
+-- its total consumption, its highest hourly consumption and the start of
+-- that hour, and the number of hours with samples, all in stored units.
+CREATE TABLE IF NOT EXISTS consumption_summaries (
+    period TEXT NOT NULL CHECK (period IN ('day', 'month')),
+    start_time TIMESTAMPTZ NOT NULL,
+    total DOUBLE PRECISION NOT NULL,
+    peak DOUBLE PRECISION NOT NULL,
+    peak_time TIMESTAMPTZ NOT NULL,
+    hours INTEGER NOT NULL,
+    sample_count BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (period, start_time)
+);
+
+-- Seed from data stored before the table existed
+INSERT INTO consumption_summaries (period, start_time, total, peak, peak_time, hours, sample_count)
+SELECT 'day', day, SUM(total), MAX(total), (array_agg(hour ORDER BY total DESC, hour))[1], COUNT(*), SUM(samples)
+FROM (
+    SELECT time_bucket('1 day', time) AS day, time_bucket('1 hour', time) AS hour,
+        SUM(value) AS total, COUNT(*) AS samples
+    FROM time_series_data
+    GROUP BY day, hour
+) hours
+GROUP BY day
+ON CONFLICT (period, start_time) DO NOTHING;
+
+INSERT INTO consumption_summaries (period, start_time, total, peak, peak_time, hours, sample_count)
+SELECT 'month', date_trunc('month', start_time AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS month,
+    SUM(total), MAX(peak), (array_agg(peak_time ORDER BY peak DESC, peak_time))[1], SUM(hours), SUM(sample_count)
+FROM consumption_summaries
+WHERE period = 'day'
+GROUP BY month
+ON CONFLICT (period, start_time) DO NOTHING;
+
+INSERT INTO schema_migrations (version) VALUES (8) ON CONFLICT (version) DO NOTHING;
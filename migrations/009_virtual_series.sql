@@ -0,0 +1,13 @@
+-- Virtual series: named expressions over the stored series, saved through
+-- the AdminService and computed when they are queried, like the derived
+-- series of config.yaml
+CREATE TABLE IF NOT EXISTS virtual_series (
+    name TEXT PRIMARY KEY,
+    expression TEXT NOT NULL,
+    unit TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version) VALUES (9) ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,10 @@
+-- Ingestion sources disabled or re-enabled through the AdminService. Sources
+-- without a row are enabled.
+CREATE TABLE IF NOT EXISTS ingest_sources (
+    name TEXT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version) VALUES (10) ON CONFLICT (version) DO NOTHING;
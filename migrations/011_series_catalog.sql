@@ -0,0 +1,7 @@
+-- Descriptions of the series in series_metadata, listed by the ListSeries
+-- and GetSeries RPCs alongside their stored range and point count
+ALTER TABLE series_metadata ADD COLUMN IF NOT EXISTS unit TEXT NOT NULL DEFAULT '';
+ALTER TABLE series_metadata ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE series_metadata ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '{}';
+
+INSERT INTO schema_migrations (version) VALUES (11) ON CONFLICT (version) DO NOTHING;
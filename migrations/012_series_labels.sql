@@ -0,0 +1,7 @@
+-- Labels of virtual series, like those of the stored series, and a GIN
+-- index on the labels of the stored series, which label selectors narrow
+-- by containment and key existence
+ALTER TABLE virtual_series ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS series_metadata_tags_idx ON series_metadata USING GIN (tags);
+
+INSERT INTO schema_migrations (version) VALUES (12) ON CONFLICT (version) DO NOTHING;
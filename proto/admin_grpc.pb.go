@@ -0,0 +1,583 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: proto/admin.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	AdminService_Backfill_FullMethodName            = "/edgecom.AdminService/Backfill"
+	AdminService_ClearCache_FullMethodName          = "/edgecom.AdminService/ClearCache"
+	AdminService_PauseScheduler_FullMethodName      = "/edgecom.AdminService/PauseScheduler"
+	AdminService_ResumeScheduler_FullMethodName     = "/edgecom.AdminService/ResumeScheduler"
+	AdminService_ReloadConfig_FullMethodName        = "/edgecom.AdminService/ReloadConfig"
+	AdminService_GetWatermarks_FullMethodName       = "/edgecom.AdminService/GetWatermarks"
+	AdminService_SaveVirtualSeries_FullMethodName   = "/edgecom.AdminService/SaveVirtualSeries"
+	AdminService_ListVirtualSeries_FullMethodName   = "/edgecom.AdminService/ListVirtualSeries"
+	AdminService_DeleteVirtualSeries_FullMethodName = "/edgecom.AdminService/DeleteVirtualSeries"
+	AdminService_ListIngestSources_FullMethodName   = "/edgecom.AdminService/ListIngestSources"
+	AdminService_SetIngestSource_FullMethodName     = "/edgecom.AdminService/SetIngestSource"
+	AdminService_GetLimits_FullMethodName           = "/edgecom.AdminService/GetLimits"
+	AdminService_SetLimits_FullMethodName           = "/edgecom.AdminService/SetLimits"
+)
+
+// AdminServiceClient is the client API for AdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// AdminService performs routine operational actions on a running service,
+// so operators need neither database access nor restarts for them.
+type AdminServiceClient interface {
+	Backfill(ctx context.Context, in *BackfillRequest, opts ...grpc.CallOption) (*BackfillResponse, error)
+	ClearCache(ctx context.Context, in *ClearCacheRequest, opts ...grpc.CallOption) (*ClearCacheResponse, error)
+	PauseScheduler(ctx context.Context, in *PauseSchedulerRequest, opts ...grpc.CallOption) (*SchedulerState, error)
+	ResumeScheduler(ctx context.Context, in *ResumeSchedulerRequest, opts ...grpc.CallOption) (*SchedulerState, error)
+	ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error)
+	GetWatermarks(ctx context.Context, in *WatermarksRequest, opts ...grpc.CallOption) (*WatermarksResponse, error)
+	SaveVirtualSeries(ctx context.Context, in *SaveVirtualSeriesRequest, opts ...grpc.CallOption) (*VirtualSeries, error)
+	ListVirtualSeries(ctx context.Context, in *ListVirtualSeriesRequest, opts ...grpc.CallOption) (*ListVirtualSeriesResponse, error)
+	DeleteVirtualSeries(ctx context.Context, in *DeleteVirtualSeriesRequest, opts ...grpc.CallOption) (*DeleteVirtualSeriesResponse, error)
+	ListIngestSources(ctx context.Context, in *ListIngestSourcesRequest, opts ...grpc.CallOption) (*ListIngestSourcesResponse, error)
+	SetIngestSource(ctx context.Context, in *SetIngestSourceRequest, opts ...grpc.CallOption) (*IngestSource, error)
+	GetLimits(ctx context.Context, in *GetLimitsRequest, opts ...grpc.CallOption) (*Limits, error)
+	SetLimits(ctx context.Context, in *SetLimitsRequest, opts ...grpc.CallOption) (*Limits, error)
+}
+
+type adminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminServiceClient(cc grpc.ClientConnInterface) AdminServiceClient {
+	return &adminServiceClient{cc}
+}
+
+func (c *adminServiceClient) Backfill(ctx context.Context, in *BackfillRequest, opts ...grpc.CallOption) (*BackfillResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BackfillResponse)
+	err := c.cc.Invoke(ctx, AdminService_Backfill_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ClearCache(ctx context.Context, in *ClearCacheRequest, opts ...grpc.CallOption) (*ClearCacheResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClearCacheResponse)
+	err := c.cc.Invoke(ctx, AdminService_ClearCache_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) PauseScheduler(ctx context.Context, in *PauseSchedulerRequest, opts ...grpc.CallOption) (*SchedulerState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SchedulerState)
+	err := c.cc.Invoke(ctx, AdminService_PauseScheduler_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ResumeScheduler(ctx context.Context, in *ResumeSchedulerRequest, opts ...grpc.CallOption) (*SchedulerState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SchedulerState)
+	err := c.cc.Invoke(ctx, AdminService_ResumeScheduler_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, AdminService_ReloadConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) GetWatermarks(ctx context.Context, in *WatermarksRequest, opts ...grpc.CallOption) (*WatermarksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WatermarksResponse)
+	err := c.cc.Invoke(ctx, AdminService_GetWatermarks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) SaveVirtualSeries(ctx context.Context, in *SaveVirtualSeriesRequest, opts ...grpc.CallOption) (*VirtualSeries, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VirtualSeries)
+	err := c.cc.Invoke(ctx, AdminService_SaveVirtualSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ListVirtualSeries(ctx context.Context, in *ListVirtualSeriesRequest, opts ...grpc.CallOption) (*ListVirtualSeriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListVirtualSeriesResponse)
+	err := c.cc.Invoke(ctx, AdminService_ListVirtualSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) DeleteVirtualSeries(ctx context.Context, in *DeleteVirtualSeriesRequest, opts ...grpc.CallOption) (*DeleteVirtualSeriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteVirtualSeriesResponse)
+	err := c.cc.Invoke(ctx, AdminService_DeleteVirtualSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ListIngestSources(ctx context.Context, in *ListIngestSourcesRequest, opts ...grpc.CallOption) (*ListIngestSourcesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListIngestSourcesResponse)
+	err := c.cc.Invoke(ctx, AdminService_ListIngestSources_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) SetIngestSource(ctx context.Context, in *SetIngestSourceRequest, opts ...grpc.CallOption) (*IngestSource, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IngestSource)
+	err := c.cc.Invoke(ctx, AdminService_SetIngestSource_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) GetLimits(ctx context.Context, in *GetLimitsRequest, opts ...grpc.CallOption) (*Limits, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Limits)
+	err := c.cc.Invoke(ctx, AdminService_GetLimits_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) SetLimits(ctx context.Context, in *SetLimitsRequest, opts ...grpc.CallOption) (*Limits, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Limits)
+	err := c.cc.Invoke(ctx, AdminService_SetLimits_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServiceServer is the server API for AdminService service.
+// All implementations must embed UnimplementedAdminServiceServer
+// for forward compatibility.
+//
+// AdminService performs routine operational actions on a running service,
+// so operators need neither database access nor restarts for them.
+type AdminServiceServer interface {
+	Backfill(context.Context, *BackfillRequest) (*BackfillResponse, error)
+	ClearCache(context.Context, *ClearCacheRequest) (*ClearCacheResponse, error)
+	PauseScheduler(context.Context, *PauseSchedulerRequest) (*SchedulerState, error)
+	ResumeScheduler(context.Context, *ResumeSchedulerRequest) (*SchedulerState, error)
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error)
+	GetWatermarks(context.Context, *WatermarksRequest) (*WatermarksResponse, error)
+	SaveVirtualSeries(context.Context, *SaveVirtualSeriesRequest) (*VirtualSeries, error)
+	ListVirtualSeries(context.Context, *ListVirtualSeriesRequest) (*ListVirtualSeriesResponse, error)
+	DeleteVirtualSeries(context.Context, *DeleteVirtualSeriesRequest) (*DeleteVirtualSeriesResponse, error)
+	ListIngestSources(context.Context, *ListIngestSourcesRequest) (*ListIngestSourcesResponse, error)
+	SetIngestSource(context.Context, *SetIngestSourceRequest) (*IngestSource, error)
+	GetLimits(context.Context, *GetLimitsRequest) (*Limits, error)
+	SetLimits(context.Context, *SetLimitsRequest) (*Limits, error)
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+// UnimplementedAdminServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServiceServer struct{}
+
+func (UnimplementedAdminServiceServer) Backfill(context.Context, *BackfillRequest) (*BackfillResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Backfill not implemented")
+}
+func (UnimplementedAdminServiceServer) ClearCache(context.Context, *ClearCacheRequest) (*ClearCacheResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearCache not implemented")
+}
+func (UnimplementedAdminServiceServer) PauseScheduler(context.Context, *PauseSchedulerRequest) (*SchedulerState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseScheduler not implemented")
+}
+func (UnimplementedAdminServiceServer) ResumeScheduler(context.Context, *ResumeSchedulerRequest) (*SchedulerState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeScheduler not implemented")
+}
+func (UnimplementedAdminServiceServer) ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedAdminServiceServer) GetWatermarks(context.Context, *WatermarksRequest) (*WatermarksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWatermarks not implemented")
+}
+func (UnimplementedAdminServiceServer) SaveVirtualSeries(context.Context, *SaveVirtualSeriesRequest) (*VirtualSeries, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SaveVirtualSeries not implemented")
+}
+func (UnimplementedAdminServiceServer) ListVirtualSeries(context.Context, *ListVirtualSeriesRequest) (*ListVirtualSeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVirtualSeries not implemented")
+}
+func (UnimplementedAdminServiceServer) DeleteVirtualSeries(context.Context, *DeleteVirtualSeriesRequest) (*DeleteVirtualSeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteVirtualSeries not implemented")
+}
+func (UnimplementedAdminServiceServer) ListIngestSources(context.Context, *ListIngestSourcesRequest) (*ListIngestSourcesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListIngestSources not implemented")
+}
+func (UnimplementedAdminServiceServer) SetIngestSource(context.Context, *SetIngestSourceRequest) (*IngestSource, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetIngestSource not implemented")
+}
+func (UnimplementedAdminServiceServer) GetLimits(context.Context, *GetLimitsRequest) (*Limits, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLimits not implemented")
+}
+func (UnimplementedAdminServiceServer) SetLimits(context.Context, *SetLimitsRequest) (*Limits, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLimits not implemented")
+}
+func (UnimplementedAdminServiceServer) mustEmbedUnimplementedAdminServiceServer() {}
+func (UnimplementedAdminServiceServer) testEmbeddedByValue()                      {}
+
+// UnsafeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServiceServer will
+// result in compilation errors.
+type UnsafeAdminServiceServer interface {
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+func RegisterAdminServiceServer(s grpc.ServiceRegistrar, srv AdminServiceServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&AdminService_ServiceDesc, srv)
+}
+
+func _AdminService_Backfill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackfillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).Backfill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_Backfill_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).Backfill(ctx, req.(*BackfillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ClearCache_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearCacheRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ClearCache(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_ClearCache_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ClearCache(ctx, req.(*ClearCacheRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_PauseScheduler_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseSchedulerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).PauseScheduler(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_PauseScheduler_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).PauseScheduler(ctx, req.(*PauseSchedulerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ResumeScheduler_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeSchedulerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ResumeScheduler(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_ResumeScheduler_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ResumeScheduler(ctx, req.(*ResumeSchedulerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_ReloadConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ReloadConfig(ctx, req.(*ReloadConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_GetWatermarks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WatermarksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).GetWatermarks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_GetWatermarks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).GetWatermarks(ctx, req.(*WatermarksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_SaveVirtualSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SaveVirtualSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SaveVirtualSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_SaveVirtualSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SaveVirtualSeries(ctx, req.(*SaveVirtualSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ListVirtualSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVirtualSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ListVirtualSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_ListVirtualSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ListVirtualSeries(ctx, req.(*ListVirtualSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_DeleteVirtualSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteVirtualSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).DeleteVirtualSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_DeleteVirtualSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).DeleteVirtualSeries(ctx, req.(*DeleteVirtualSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ListIngestSources_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListIngestSourcesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ListIngestSources(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_ListIngestSources_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ListIngestSources(ctx, req.(*ListIngestSourcesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_SetIngestSource_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetIngestSourceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SetIngestSource(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_SetIngestSource_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SetIngestSource(ctx, req.(*SetIngestSourceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_GetLimits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLimitsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).GetLimits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_GetLimits_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).GetLimits(ctx, req.(*GetLimitsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_SetLimits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetLimitsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SetLimits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_SetLimits_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SetLimits(ctx, req.(*SetLimitsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "edgecom.AdminService",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Backfill",
+			Handler:    _AdminService_Backfill_Handler,
+		},
+		{
+			MethodName: "ClearCache",
+			Handler:    _AdminService_ClearCache_Handler,
+		},
+		{
+			MethodName: "PauseScheduler",
+			Handler:    _AdminService_PauseScheduler_Handler,
+		},
+		{
+			MethodName: "ResumeScheduler",
+			Handler:    _AdminService_ResumeScheduler_Handler,
+		},
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _AdminService_ReloadConfig_Handler,
+		},
+		{
+			MethodName: "GetWatermarks",
+			Handler:    _AdminService_GetWatermarks_Handler,
+		},
+		{
+			MethodName: "SaveVirtualSeries",
+			Handler:    _AdminService_SaveVirtualSeries_Handler,
+		},
+		{
+			MethodName: "ListVirtualSeries",
+			Handler:    _AdminService_ListVirtualSeries_Handler,
+		},
+		{
+			MethodName: "DeleteVirtualSeries",
+			Handler:    _AdminService_DeleteVirtualSeries_Handler,
+		},
+		{
+			MethodName: "ListIngestSources",
+			Handler:    _AdminService_ListIngestSources_Handler,
+		},
+		{
+			MethodName: "SetIngestSource",
+			Handler:    _AdminService_SetIngestSource_Handler,
+		},
+		{
+			MethodName: "GetLimits",
+			Handler:    _AdminService_GetLimits_Handler,
+		},
+		{
+			MethodName: "SetLimits",
+			Handler:    _AdminService_SetLimits_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/admin.proto",
+}
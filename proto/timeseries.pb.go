@@ -9,6 +9,7 @@ package proto
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
@@ -26,10 +27,20 @@ type TimeSeriesRequest struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Start       *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
-	End         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
-	Window      string                 `protobuf:"bytes,3,opt,name=window,proto3" json:"window,omitempty"`           // e.g., '1m', '5m', '1h', '1d'
-	Aggregation string                 `protobuf:"bytes,4,opt,name=aggregation,proto3" json:"aggregation,omitempty"` // 'MIN', 'MAX', 'AVG', 'SUM'
+	Start             *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End               *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	Window            string                 `protobuf:"bytes,3,opt,name=window,proto3" json:"window,omitempty"`                                                 // e.g., '1m', '5m', '1h', '1d'
+	Aggregation       string                 `protobuf:"bytes,4,opt,name=aggregation,proto3" json:"aggregation,omitempty"`                                       // 'MIN', 'MAX', 'AVG', 'SUM', 'LOAD_FACTOR', 'UTILIZATION'
+	Calendar          string                 `protobuf:"bytes,5,opt,name=calendar,proto3" json:"calendar,omitempty"`                                             // Optional business calendar; aggregates only its working hours
+	WeatherNormalized bool                   `protobuf:"varint,6,opt,name=weather_normalized,json=weatherNormalized,proto3" json:"weather_normalized,omitempty"` // Restates SUM or AVG buckets at the normal weather of their time of year
+	PageSize          int32                  `protobuf:"varint,7,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`                            // Pages the buckets when set; capped at 10000
+	PageToken         string                 `protobuf:"bytes,8,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`                          // next_page_token from a previous response
+	MaxPoints         int32                  `protobuf:"varint,9,opt,name=max_points,json=maxPoints,proto3" json:"max_points,omitempty"`                         // Caps the number of points; chooses the window when it is empty
+	IncludeChecksum   bool                   `protobuf:"varint,10,opt,name=include_checksum,json=includeChecksum,proto3" json:"include_checksum,omitempty"`      // Adds a checksum of the points and the ingest watermark to the metadata
+	Series            string                 `protobuf:"bytes,11,opt,name=series,proto3" json:"series,omitempty"`                                                // Optional derived series computed from the buckets; the stored series when empty
+	Transform         string                 `protobuf:"bytes,12,opt,name=transform,proto3" json:"transform,omitempty"`                                          // Optional: 'DELTA', 'RATE' (per second) or 'CUMSUM' over the buckets
+	Selector          string                 `protobuf:"bytes,13,opt,name=selector,proto3" json:"selector,omitempty"`                                            // Optional label selector choosing the series instead, e.g. '{site="plant1",phase="A"}'; must select one
+	GroupBy           []string               `protobuf:"bytes,14,rep,name=group_by,json=groupBy,proto3" json:"group_by,omitempty"`                               // Optional labels, e.g. 'site', grouping the series the selector selects into groups
 }
 
 func (x *TimeSeriesRequest) Reset() {
@@ -90,6 +101,76 @@ func (x *TimeSeriesRequest) GetAggregation() string {
 	return ""
 }
 
+func (x *TimeSeriesRequest) GetCalendar() string {
+	if x != nil {
+		return x.Calendar
+	}
+	return ""
+}
+
+func (x *TimeSeriesRequest) GetWeatherNormalized() bool {
+	if x != nil {
+		return x.WeatherNormalized
+	}
+	return false
+}
+
+func (x *TimeSeriesRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *TimeSeriesRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *TimeSeriesRequest) GetMaxPoints() int32 {
+	if x != nil {
+		return x.MaxPoints
+	}
+	return 0
+}
+
+func (x *TimeSeriesRequest) GetIncludeChecksum() bool {
+	if x != nil {
+		return x.IncludeChecksum
+	}
+	return false
+}
+
+func (x *TimeSeriesRequest) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *TimeSeriesRequest) GetTransform() string {
+	if x != nil {
+		return x.Transform
+	}
+	return ""
+}
+
+func (x *TimeSeriesRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+func (x *TimeSeriesRequest) GetGroupBy() []string {
+	if x != nil {
+		return x.GroupBy
+	}
+	return nil
+}
+
 type TimeSeriesDataPoint struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -148,7 +229,12 @@ type TimeSeriesResponse struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Data []*TimeSeriesDataPoint `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"`
+	Data          []*TimeSeriesDataPoint `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"`
+	WeatherModel  *WeatherModel          `protobuf:"bytes,2,opt,name=weather_model,json=weatherModel,proto3" json:"weather_model,omitempty"`      // With weather_normalized only
+	NextPageToken string                 `protobuf:"bytes,3,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"` // Set when paging and more buckets remain
+	Window        string                 `protobuf:"bytes,4,opt,name=window,proto3" json:"window,omitempty"`                                      // The window chosen for a request with max_points and no window
+	Metadata      *QueryMetadata         `protobuf:"bytes,5,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	Groups        []*SeriesGroup         `protobuf:"bytes,6,rep,name=groups,proto3" json:"groups,omitempty"` // With group_by, one series per group instead of data
 }
 
 func (x *TimeSeriesResponse) Reset() {
@@ -188,44 +274,3206 @@ func (x *TimeSeriesResponse) GetData() []*TimeSeriesDataPoint {
 	return nil
 }
 
-var File_proto_timeseries_proto protoreflect.FileDescriptor
+func (x *TimeSeriesResponse) GetWeatherModel() *WeatherModel {
+	if x != nil {
+		return x.WeatherModel
+	}
+	return nil
+}
 
-var file_proto_timeseries_proto_rawDesc = []byte{
-	0x0a, 0x16, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x65, 0x72, 0x69,
-	0x65, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
-	0x6d, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
-	0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x22, 0xad, 0x01, 0x0a, 0x11, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65,
-	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72,
-	0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
-	0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e,
-	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
-	0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x64,
-	0x6f, 0x77, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77,
-	0x12, 0x20, 0x0a, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18,
-	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69,
-	0x6f, 0x6e, 0x22, 0x5b, 0x0a, 0x13, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73,
-	0x44, 0x61, 0x74, 0x61, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x2e, 0x0a, 0x04, 0x74, 0x69, 0x6d,
-	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
-	0x61, 0x6d, 0x70, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
-	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22,
-	0x46, 0x0a, 0x12, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73,
-	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x30, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20,
+func (x *TimeSeriesResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+func (x *TimeSeriesResponse) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+func (x *TimeSeriesResponse) GetMetadata() *QueryMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *TimeSeriesResponse) GetGroups() []*SeriesGroup {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+// WeatherModel is the regression of the buckets of a range on their mean
+// heating and cooling degrees that weather-normalized queries restate the
+// buckets with.
+type WeatherModel struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BalancePoint float64 `protobuf:"fixed64,1,opt,name=balance_point,json=balancePoint,proto3" json:"balance_point,omitempty"` // Degrees Celsius the degrees are relative to
+	BaseLoad     float64 `protobuf:"fixed64,2,opt,name=base_load,json=baseLoad,proto3" json:"base_load,omitempty"`
+	HeatingSlope float64 `protobuf:"fixed64,3,opt,name=heating_slope,json=heatingSlope,proto3" json:"heating_slope,omitempty"` // Per heating degree; 0 if no bucket needed heating
+	CoolingSlope float64 `protobuf:"fixed64,4,opt,name=cooling_slope,json=coolingSlope,proto3" json:"cooling_slope,omitempty"` // Per cooling degree; 0 if no bucket needed cooling
+	RSquared     float64 `protobuf:"fixed64,5,opt,name=r_squared,json=rSquared,proto3" json:"r_squared,omitempty"`             // Fraction of the variance of the buckets the model explains
+	Observations int32   `protobuf:"varint,6,opt,name=observations,proto3" json:"observations,omitempty"`                      // Buckets fitted
+	NormalYears  int32   `protobuf:"varint,7,opt,name=normal_years,json=normalYears,proto3" json:"normal_years,omitempty"`     // Previous years the normal weather was averaged over
+}
+
+func (x *WeatherModel) Reset() {
+	*x = WeatherModel{}
+	mi := &file_proto_timeseries_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WeatherModel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WeatherModel) ProtoMessage() {}
+
+func (x *WeatherModel) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WeatherModel.ProtoReflect.Descriptor instead.
+func (*WeatherModel) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WeatherModel) GetBalancePoint() float64 {
+	if x != nil {
+		return x.BalancePoint
+	}
+	return 0
+}
+
+func (x *WeatherModel) GetBaseLoad() float64 {
+	if x != nil {
+		return x.BaseLoad
+	}
+	return 0
+}
+
+func (x *WeatherModel) GetHeatingSlope() float64 {
+	if x != nil {
+		return x.HeatingSlope
+	}
+	return 0
+}
+
+func (x *WeatherModel) GetCoolingSlope() float64 {
+	if x != nil {
+		return x.CoolingSlope
+	}
+	return 0
+}
+
+func (x *WeatherModel) GetRSquared() float64 {
+	if x != nil {
+		return x.RSquared
+	}
+	return 0
+}
+
+func (x *WeatherModel) GetObservations() int32 {
+	if x != nil {
+		return x.Observations
+	}
+	return 0
+}
+
+func (x *WeatherModel) GetNormalYears() int32 {
+	if x != nil {
+		return x.NormalYears
+	}
+	return 0
+}
+
+// SeriesGroup aggregates, bucket by bucket, the series sharing the values
+// of the group_by labels of a TimeSeriesRequest.
+type SeriesGroup struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Labels map[string]string      `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"` // The group_by labels; empty for series lacking one
+	Series []string               `protobuf:"bytes,2,rep,name=series,proto3" json:"series,omitempty"`                                                                                         // The series aggregated, by name
+	Data   []*TimeSeriesDataPoint `protobuf:"bytes,3,rep,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *SeriesGroup) Reset() {
+	*x = SeriesGroup{}
+	mi := &file_proto_timeseries_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SeriesGroup) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SeriesGroup) ProtoMessage() {}
+
+func (x *SeriesGroup) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SeriesGroup.ProtoReflect.Descriptor instead.
+func (*SeriesGroup) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SeriesGroup) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *SeriesGroup) GetSeries() []string {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+func (x *SeriesGroup) GetData() []*TimeSeriesDataPoint {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// QueryMetadata describes how the points of a TimeSeriesResponse were
+// produced. Buckets without samples are omitted rather than filled.
+type QueryMetadata struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Window        string                 `protobuf:"bytes,1,opt,name=window,proto3" json:"window,omitempty"`                                         // The window the buckets were aggregated over
+	Aggregation   string                 `protobuf:"bytes,2,opt,name=aggregation,proto3" json:"aggregation,omitempty"`                               // 'MIN', 'MAX', 'AVG', 'SUM', 'LOAD_FACTOR' or 'UTILIZATION'
+	SampleCounts  []int64                `protobuf:"varint,3,rep,packed,name=sample_counts,json=sampleCounts,proto3" json:"sample_counts,omitempty"` // Raw samples behind each point, in the order of data
+	TotalSamples  int64                  `protobuf:"varint,4,opt,name=total_samples,json=totalSamples,proto3" json:"total_samples,omitempty"`        // Sum of sample_counts
+	QueryDuration *durationpb.Duration   `protobuf:"bytes,5,opt,name=query_duration,json=queryDuration,proto3" json:"query_duration,omitempty"`      // Time the database query took; cached responses repeat it
+	GapsFilled    bool                   `protobuf:"varint,6,opt,name=gaps_filled,json=gapsFilled,proto3" json:"gaps_filled,omitempty"`              // Whether empty buckets were filled; always false
+	Downsampled   bool                   `protobuf:"varint,7,opt,name=downsampled,proto3" json:"downsampled,omitempty"`                              // Whether max_points reduced the buckets with LTTB
+	Calendar      string                 `protobuf:"bytes,8,opt,name=calendar,proto3" json:"calendar,omitempty"`                                     // The business calendar the buckets were restricted to
+	Checksum      string                 `protobuf:"bytes,9,opt,name=checksum,proto3" json:"checksum,omitempty"`                                     // SHA-256 of the points and sample counts, with include_checksum
+	Watermark     *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=watermark,proto3" json:"watermark,omitempty"`                                  // Ingest watermark at query time, with include_checksum
+	Series        string                 `protobuf:"bytes,11,opt,name=series,proto3" json:"series,omitempty"`                                        // The derived series the points were computed for, if any
+	Transform     string                 `protobuf:"bytes,12,opt,name=transform,proto3" json:"transform,omitempty"`                                  // The transform applied to the buckets, if any
+	Stale         bool                   `protobuf:"varint,13,opt,name=stale,proto3" json:"stale,omitempty"`                                         // Whether the response was served from the cache while the database was down
+	StaleAge      *durationpb.Duration   `protobuf:"bytes,14,opt,name=stale_age,json=staleAge,proto3" json:"stale_age,omitempty"`                    // With stale, how long ago the response was invalidated by newer data
+}
+
+func (x *QueryMetadata) Reset() {
+	*x = QueryMetadata{}
+	mi := &file_proto_timeseries_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryMetadata) ProtoMessage() {}
+
+func (x *QueryMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryMetadata.ProtoReflect.Descriptor instead.
+func (*QueryMetadata) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *QueryMetadata) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+func (x *QueryMetadata) GetAggregation() string {
+	if x != nil {
+		return x.Aggregation
+	}
+	return ""
+}
+
+func (x *QueryMetadata) GetSampleCounts() []int64 {
+	if x != nil {
+		return x.SampleCounts
+	}
+	return nil
+}
+
+func (x *QueryMetadata) GetTotalSamples() int64 {
+	if x != nil {
+		return x.TotalSamples
+	}
+	return 0
+}
+
+func (x *QueryMetadata) GetQueryDuration() *durationpb.Duration {
+	if x != nil {
+		return x.QueryDuration
+	}
+	return nil
+}
+
+func (x *QueryMetadata) GetGapsFilled() bool {
+	if x != nil {
+		return x.GapsFilled
+	}
+	return false
+}
+
+func (x *QueryMetadata) GetDownsampled() bool {
+	if x != nil {
+		return x.Downsampled
+	}
+	return false
+}
+
+func (x *QueryMetadata) GetCalendar() string {
+	if x != nil {
+		return x.Calendar
+	}
+	return ""
+}
+
+func (x *QueryMetadata) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *QueryMetadata) GetWatermark() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Watermark
+	}
+	return nil
+}
+
+func (x *QueryMetadata) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *QueryMetadata) GetTransform() string {
+	if x != nil {
+		return x.Transform
+	}
+	return ""
+}
+
+func (x *QueryMetadata) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+func (x *QueryMetadata) GetStaleAge() *durationpb.Duration {
+	if x != nil {
+		return x.StaleAge
+	}
+	return nil
+}
+
+type RawQueryRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start     *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End       *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	PageSize  int32                  `protobuf:"varint,3,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`   // Defaults to 1000, capped at 10000
+	PageToken string                 `protobuf:"bytes,4,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"` // next_page_token from a previous response
+}
+
+func (x *RawQueryRequest) Reset() {
+	*x = RawQueryRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RawQueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RawQueryRequest) ProtoMessage() {}
+
+func (x *RawQueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RawQueryRequest.ProtoReflect.Descriptor instead.
+func (*RawQueryRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RawQueryRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *RawQueryRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *RawQueryRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *RawQueryRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type RawQueryResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data          []*TimeSeriesDataPoint `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"`                                          // Stored samples ordered by time
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"` // Empty when there are no more results
+}
+
+func (x *RawQueryResponse) Reset() {
+	*x = RawQueryResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RawQueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RawQueryResponse) ProtoMessage() {}
+
+func (x *RawQueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RawQueryResponse.ProtoReflect.Descriptor instead.
+func (*RawQueryResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RawQueryResponse) GetData() []*TimeSeriesDataPoint {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *RawQueryResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type LatestRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Count int32 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"` // Number of most recent points; defaults to 1, capped at 1000
+}
+
+func (x *LatestRequest) Reset() {
+	*x = LatestRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LatestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LatestRequest) ProtoMessage() {}
+
+func (x *LatestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LatestRequest.ProtoReflect.Descriptor instead.
+func (*LatestRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *LatestRequest) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type LatestResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data []*TimeSeriesDataPoint `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"` // Most recent samples, newest first
+}
+
+func (x *LatestResponse) Reset() {
+	*x = LatestResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LatestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LatestResponse) ProtoMessage() {}
+
+func (x *LatestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LatestResponse.ProtoReflect.Descriptor instead.
+func (*LatestResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *LatestResponse) GetData() []*TimeSeriesDataPoint {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type StatisticsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End   *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (x *StatisticsRequest) Reset() {
+	*x = StatisticsRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatisticsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatisticsRequest) ProtoMessage() {}
+
+func (x *StatisticsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatisticsRequest.ProtoReflect.Descriptor instead.
+func (*StatisticsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *StatisticsRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *StatisticsRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+type StatisticsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Count     int64                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"` // Samples in the range; the other fields are unset when 0
+	Min       float64                `protobuf:"fixed64,2,opt,name=min,proto3" json:"min,omitempty"`
+	Max       float64                `protobuf:"fixed64,3,opt,name=max,proto3" json:"max,omitempty"`
+	Avg       float64                `protobuf:"fixed64,4,opt,name=avg,proto3" json:"avg,omitempty"`
+	Sum       float64                `protobuf:"fixed64,5,opt,name=sum,proto3" json:"sum,omitempty"`
+	Stddev    float64                `protobuf:"fixed64,6,opt,name=stddev,proto3" json:"stddev,omitempty"`                      // Population standard deviation
+	FirstTime *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=first_time,json=firstTime,proto3" json:"first_time,omitempty"` // Earliest sample in the range
+	LastTime  *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=last_time,json=lastTime,proto3" json:"last_time,omitempty"`    // Latest sample in the range
+}
+
+func (x *StatisticsResponse) Reset() {
+	*x = StatisticsResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatisticsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatisticsResponse) ProtoMessage() {}
+
+func (x *StatisticsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatisticsResponse.ProtoReflect.Descriptor instead.
+func (*StatisticsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *StatisticsResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *StatisticsResponse) GetMin() float64 {
+	if x != nil {
+		return x.Min
+	}
+	return 0
+}
+
+func (x *StatisticsResponse) GetMax() float64 {
+	if x != nil {
+		return x.Max
+	}
+	return 0
+}
+
+func (x *StatisticsResponse) GetAvg() float64 {
+	if x != nil {
+		return x.Avg
+	}
+	return 0
+}
+
+func (x *StatisticsResponse) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+func (x *StatisticsResponse) GetStddev() float64 {
+	if x != nil {
+		return x.Stddev
+	}
+	return 0
+}
+
+func (x *StatisticsResponse) GetFirstTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FirstTime
+	}
+	return nil
+}
+
+func (x *StatisticsResponse) GetLastTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastTime
+	}
+	return nil
+}
+
+type InsertRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data []*TimeSeriesDataPoint `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"` // At most 10000 points per message
+}
+
+func (x *InsertRequest) Reset() {
+	*x = InsertRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InsertRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InsertRequest) ProtoMessage() {}
+
+func (x *InsertRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InsertRequest.ProtoReflect.Descriptor instead.
+func (*InsertRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *InsertRequest) GetData() []*TimeSeriesDataPoint {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type InsertResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Inserted int64 `protobuf:"varint,1,opt,name=inserted,proto3" json:"inserted,omitempty"` // Number of points stored
+}
+
+func (x *InsertResponse) Reset() {
+	*x = InsertResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InsertResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InsertResponse) ProtoMessage() {}
+
+func (x *InsertResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InsertResponse.ProtoReflect.Descriptor instead.
+func (*InsertResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *InsertResponse) GetInserted() int64 {
+	if x != nil {
+		return x.Inserted
+	}
+	return 0
+}
+
+type EmissionsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start  *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	Window string                 `protobuf:"bytes,3,opt,name=window,proto3" json:"window,omitempty"` // e.g., '1h', '1d'
+}
+
+func (x *EmissionsRequest) Reset() {
+	*x = EmissionsRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EmissionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EmissionsRequest) ProtoMessage() {}
+
+func (x *EmissionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EmissionsRequest.ProtoReflect.Descriptor instead.
+func (*EmissionsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *EmissionsRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *EmissionsRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *EmissionsRequest) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+type EmissionsBucket struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time        *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Consumption float64                `protobuf:"fixed64,2,opt,name=consumption,proto3" json:"consumption,omitempty"`                    // Summed consumption in stored units
+	Intensity   float64                `protobuf:"fixed64,3,opt,name=intensity,proto3" json:"intensity,omitempty"`                        // Consumption-weighted grams of CO2e per kWh
+	EmissionsKg float64                `protobuf:"fixed64,4,opt,name=emissions_kg,json=emissionsKg,proto3" json:"emissions_kg,omitempty"` // Kilograms of CO2e
+}
+
+func (x *EmissionsBucket) Reset() {
+	*x = EmissionsBucket{}
+	mi := &file_proto_timeseries_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EmissionsBucket) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EmissionsBucket) ProtoMessage() {}
+
+func (x *EmissionsBucket) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EmissionsBucket.ProtoReflect.Descriptor instead.
+func (*EmissionsBucket) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *EmissionsBucket) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *EmissionsBucket) GetConsumption() float64 {
+	if x != nil {
+		return x.Consumption
+	}
+	return 0
+}
+
+func (x *EmissionsBucket) GetIntensity() float64 {
+	if x != nil {
+		return x.Intensity
+	}
+	return 0
+}
+
+func (x *EmissionsBucket) GetEmissionsKg() float64 {
+	if x != nil {
+		return x.EmissionsKg
+	}
+	return 0
+}
+
+type EmissionsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data             []*EmissionsBucket `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"`
+	TotalEmissionsKg float64            `protobuf:"fixed64,2,opt,name=total_emissions_kg,json=totalEmissionsKg,proto3" json:"total_emissions_kg,omitempty"` // Sum over all buckets
+}
+
+func (x *EmissionsResponse) Reset() {
+	*x = EmissionsResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EmissionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EmissionsResponse) ProtoMessage() {}
+
+func (x *EmissionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EmissionsResponse.ProtoReflect.Descriptor instead.
+func (*EmissionsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *EmissionsResponse) GetData() []*EmissionsBucket {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *EmissionsResponse) GetTotalEmissionsKg() float64 {
+	if x != nil {
+		return x.TotalEmissionsKg
+	}
+	return 0
+}
+
+type DemandResponseEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id              int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"` // Assigned when the event is recorded
+	Name            string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Start           *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=start,proto3" json:"start,omitempty"`
+	End             *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=end,proto3" json:"end,omitempty"`                                                  // At most 24 hours after start
+	TargetReduction float64                `protobuf:"fixed64,5,opt,name=target_reduction,json=targetReduction,proto3" json:"target_reduction,omitempty"` // Expected drop of the average value, in stored units
+}
+
+func (x *DemandResponseEvent) Reset() {
+	*x = DemandResponseEvent{}
+	mi := &file_proto_timeseries_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DemandResponseEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DemandResponseEvent) ProtoMessage() {}
+
+func (x *DemandResponseEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DemandResponseEvent.ProtoReflect.Descriptor instead.
+func (*DemandResponseEvent) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *DemandResponseEvent) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *DemandResponseEvent) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DemandResponseEvent) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *DemandResponseEvent) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *DemandResponseEvent) GetTargetReduction() float64 {
+	if x != nil {
+		return x.TargetReduction
+	}
+	return 0
+}
+
+type ListDemandResponseEventsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End   *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"` // Events overlapping [start, end) are returned
+}
+
+func (x *ListDemandResponseEventsRequest) Reset() {
+	*x = ListDemandResponseEventsRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDemandResponseEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDemandResponseEventsRequest) ProtoMessage() {}
+
+func (x *ListDemandResponseEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDemandResponseEventsRequest.ProtoReflect.Descriptor instead.
+func (*ListDemandResponseEventsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ListDemandResponseEventsRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ListDemandResponseEventsRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+type DemandResponsePerformance struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Event             *DemandResponseEvent `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	Evaluated         bool                 `protobuf:"varint,2,opt,name=evaluated,proto3" json:"evaluated,omitempty"`                                           // False when the event or its baseline days lack data
+	Complete          bool                 `protobuf:"varint,3,opt,name=complete,proto3" json:"complete,omitempty"`                                             // False while the event is in progress
+	Baseline          float64              `protobuf:"fixed64,4,opt,name=baseline,proto3" json:"baseline,omitempty"`                                            // Average value in the same window on previous days
+	Actual            float64              `protobuf:"fixed64,5,opt,name=actual,proto3" json:"actual,omitempty"`                                                // Average value during the event
+	AchievedReduction float64              `protobuf:"fixed64,6,opt,name=achieved_reduction,json=achievedReduction,proto3" json:"achieved_reduction,omitempty"` // baseline - actual
+	TargetAchieved    float64              `protobuf:"fixed64,7,opt,name=target_achieved,json=targetAchieved,proto3" json:"target_achieved,omitempty"`          // achieved_reduction / target_reduction
+	BaselineDays      int32                `protobuf:"varint,8,opt,name=baseline_days,json=baselineDays,proto3" json:"baseline_days,omitempty"`                 // Previous days with data, out of 10
+}
+
+func (x *DemandResponsePerformance) Reset() {
+	*x = DemandResponsePerformance{}
+	mi := &file_proto_timeseries_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DemandResponsePerformance) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DemandResponsePerformance) ProtoMessage() {}
+
+func (x *DemandResponsePerformance) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DemandResponsePerformance.ProtoReflect.Descriptor instead.
+func (*DemandResponsePerformance) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *DemandResponsePerformance) GetEvent() *DemandResponseEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *DemandResponsePerformance) GetEvaluated() bool {
+	if x != nil {
+		return x.Evaluated
+	}
+	return false
+}
+
+func (x *DemandResponsePerformance) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+func (x *DemandResponsePerformance) GetBaseline() float64 {
+	if x != nil {
+		return x.Baseline
+	}
+	return 0
+}
+
+func (x *DemandResponsePerformance) GetActual() float64 {
+	if x != nil {
+		return x.Actual
+	}
+	return 0
+}
+
+func (x *DemandResponsePerformance) GetAchievedReduction() float64 {
+	if x != nil {
+		return x.AchievedReduction
+	}
+	return 0
+}
+
+func (x *DemandResponsePerformance) GetTargetAchieved() float64 {
+	if x != nil {
+		return x.TargetAchieved
+	}
+	return 0
+}
+
+func (x *DemandResponsePerformance) GetBaselineDays() int32 {
+	if x != nil {
+		return x.BaselineDays
+	}
+	return 0
+}
+
+type ListDemandResponseEventsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Events []*DemandResponsePerformance `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"` // Ordered by start
+}
+
+func (x *ListDemandResponseEventsResponse) Reset() {
+	*x = ListDemandResponseEventsResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDemandResponseEventsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDemandResponseEventsResponse) ProtoMessage() {}
+
+func (x *ListDemandResponseEventsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDemandResponseEventsResponse.ProtoReflect.Descriptor instead.
+func (*ListDemandResponseEventsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ListDemandResponseEventsResponse) GetEvents() []*DemandResponsePerformance {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type BudgetStatusRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *BudgetStatusRequest) Reset() {
+	*x = BudgetStatusRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BudgetStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BudgetStatusRequest) ProtoMessage() {}
+
+func (x *BudgetStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BudgetStatusRequest.ProtoReflect.Descriptor instead.
+func (*BudgetStatusRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{21}
+}
+
+type BudgetStatus struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name              string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Kind              string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`     // 'consumption' or 'cost'
+	Limit             float64                `protobuf:"fixed64,3,opt,name=limit,proto3" json:"limit,omitempty"` // Monthly budget in stored units or currency
+	MonthStart        *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=month_start,json=monthStart,proto3" json:"month_start,omitempty"`
+	MonthEnd          *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=month_end,json=monthEnd,proto3" json:"month_end,omitempty"`
+	Actual            float64                `protobuf:"fixed64,6,opt,name=actual,proto3" json:"actual,omitempty"`                                                        // Month to date
+	Projected         float64                `protobuf:"fixed64,7,opt,name=projected,proto3" json:"projected,omitempty"`                                                  // Month-to-date run rate extrapolated to month end
+	UsedFraction      float64                `protobuf:"fixed64,8,opt,name=used_fraction,json=usedFraction,proto3" json:"used_fraction,omitempty"`                        // actual / limit
+	ProjectedFraction float64                `protobuf:"fixed64,9,opt,name=projected_fraction,json=projectedFraction,proto3" json:"projected_fraction,omitempty"`         // projected / limit
+	CrossedThresholds []float64              `protobuf:"fixed64,10,rep,packed,name=crossed_thresholds,json=crossedThresholds,proto3" json:"crossed_thresholds,omitempty"` // Fractions of the limit reached
+}
+
+func (x *BudgetStatus) Reset() {
+	*x = BudgetStatus{}
+	mi := &file_proto_timeseries_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BudgetStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BudgetStatus) ProtoMessage() {}
+
+func (x *BudgetStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BudgetStatus.ProtoReflect.Descriptor instead.
+func (*BudgetStatus) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *BudgetStatus) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *BudgetStatus) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *BudgetStatus) GetLimit() float64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *BudgetStatus) GetMonthStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.MonthStart
+	}
+	return nil
+}
+
+func (x *BudgetStatus) GetMonthEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.MonthEnd
+	}
+	return nil
+}
+
+func (x *BudgetStatus) GetActual() float64 {
+	if x != nil {
+		return x.Actual
+	}
+	return 0
+}
+
+func (x *BudgetStatus) GetProjected() float64 {
+	if x != nil {
+		return x.Projected
+	}
+	return 0
+}
+
+func (x *BudgetStatus) GetUsedFraction() float64 {
+	if x != nil {
+		return x.UsedFraction
+	}
+	return 0
+}
+
+func (x *BudgetStatus) GetProjectedFraction() float64 {
+	if x != nil {
+		return x.ProjectedFraction
+	}
+	return 0
+}
+
+func (x *BudgetStatus) GetCrossedThresholds() []float64 {
+	if x != nil {
+		return x.CrossedThresholds
+	}
+	return nil
+}
+
+type BudgetStatusResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Budgets []*BudgetStatus `protobuf:"bytes,1,rep,name=budgets,proto3" json:"budgets,omitempty"` // In configuration order
+}
+
+func (x *BudgetStatusResponse) Reset() {
+	*x = BudgetStatusResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BudgetStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BudgetStatusResponse) ProtoMessage() {}
+
+func (x *BudgetStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BudgetStatusResponse.ProtoReflect.Descriptor instead.
+func (*BudgetStatusResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *BudgetStatusResponse) GetBudgets() []*BudgetStatus {
+	if x != nil {
+		return x.Budgets
+	}
+	return nil
+}
+
+type SummariesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start  *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`   // Aligned down to the start of its period
+	End    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`       // Periods starting before end are returned
+	Period string                 `protobuf:"bytes,3,opt,name=period,proto3" json:"period,omitempty"` // 'day' or 'month', in UTC
+}
+
+func (x *SummariesRequest) Reset() {
+	*x = SummariesRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SummariesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SummariesRequest) ProtoMessage() {}
+
+func (x *SummariesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SummariesRequest.ProtoReflect.Descriptor instead.
+func (*SummariesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *SummariesRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *SummariesRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *SummariesRequest) GetPeriod() string {
+	if x != nil {
+		return x.Period
+	}
+	return ""
+}
+
+type ConsumptionSummary struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start       *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	TotalKwh    float64                `protobuf:"fixed64,3,opt,name=total_kwh,json=totalKwh,proto3" json:"total_kwh,omitempty"`       // Consumption over the period
+	PeakKw      float64                `protobuf:"fixed64,4,opt,name=peak_kw,json=peakKw,proto3" json:"peak_kw,omitempty"`             // Highest hourly average demand
+	PeakTime    *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=peak_time,json=peakTime,proto3" json:"peak_time,omitempty"`         // Start of the peak hour
+	LoadFactor  float64                `protobuf:"fixed64,6,opt,name=load_factor,json=loadFactor,proto3" json:"load_factor,omitempty"` // Average over peak demand of the hours with samples
+	SampleCount int64                  `protobuf:"varint,7,opt,name=sample_count,json=sampleCount,proto3" json:"sample_count,omitempty"`
+}
+
+func (x *ConsumptionSummary) Reset() {
+	*x = ConsumptionSummary{}
+	mi := &file_proto_timeseries_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumptionSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumptionSummary) ProtoMessage() {}
+
+func (x *ConsumptionSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumptionSummary.ProtoReflect.Descriptor instead.
+func (*ConsumptionSummary) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *ConsumptionSummary) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ConsumptionSummary) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *ConsumptionSummary) GetTotalKwh() float64 {
+	if x != nil {
+		return x.TotalKwh
+	}
+	return 0
+}
+
+func (x *ConsumptionSummary) GetPeakKw() float64 {
+	if x != nil {
+		return x.PeakKw
+	}
+	return 0
+}
+
+func (x *ConsumptionSummary) GetPeakTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.PeakTime
+	}
+	return nil
+}
+
+func (x *ConsumptionSummary) GetLoadFactor() float64 {
+	if x != nil {
+		return x.LoadFactor
+	}
+	return 0
+}
+
+func (x *ConsumptionSummary) GetSampleCount() int64 {
+	if x != nil {
+		return x.SampleCount
+	}
+	return 0
+}
+
+type SummariesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Summaries []*ConsumptionSummary `protobuf:"bytes,1,rep,name=summaries,proto3" json:"summaries,omitempty"` // Periods with samples, earliest first
+}
+
+func (x *SummariesResponse) Reset() {
+	*x = SummariesResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SummariesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SummariesResponse) ProtoMessage() {}
+
+func (x *SummariesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SummariesResponse.ProtoReflect.Descriptor instead.
+func (*SummariesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *SummariesResponse) GetSummaries() []*ConsumptionSummary {
+	if x != nil {
+		return x.Summaries
+	}
+	return nil
+}
+
+type ExportRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start       *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	Window      string                 `protobuf:"bytes,3,opt,name=window,proto3" json:"window,omitempty"`           // Optional; raw samples are exported when empty
+	Aggregation string                 `protobuf:"bytes,4,opt,name=aggregation,proto3" json:"aggregation,omitempty"` // Required with window: 'MIN', 'MAX', 'AVG', 'SUM', 'LOAD_FACTOR', 'UTILIZATION'
+	Format      string                 `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`           // 'csv' (default), 'ndjson', 'parquet' or 'tsz'
+	Gzip        bool                   `protobuf:"varint,6,opt,name=gzip,proto3" json:"gzip,omitempty"`              // Gzip CSV and NDJSON; GZIP pages for Parquet; the configured codec for TSZ
+}
+
+func (x *ExportRequest) Reset() {
+	*x = ExportRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportRequest) ProtoMessage() {}
+
+func (x *ExportRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportRequest.ProtoReflect.Descriptor instead.
+func (*ExportRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *ExportRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ExportRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *ExportRequest) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+func (x *ExportRequest) GetAggregation() string {
+	if x != nil {
+		return x.Aggregation
+	}
+	return ""
+}
+
+func (x *ExportRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ExportRequest) GetGzip() bool {
+	if x != nil {
+		return x.Gzip
+	}
+	return false
+}
+
+type ExportChunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data        []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`                                  // The next bytes of the file
+	ContentType string `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"` // Set on the first chunk only
+	Filename    string `protobuf:"bytes,3,opt,name=filename,proto3" json:"filename,omitempty"`                          // Suggested file name, set on the first chunk only
+}
+
+func (x *ExportChunk) Reset() {
+	*x = ExportChunk{}
+	mi := &file_proto_timeseries_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportChunk) ProtoMessage() {}
+
+func (x *ExportChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportChunk.ProtoReflect.Descriptor instead.
+func (*ExportChunk) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *ExportChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ExportChunk) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *ExportChunk) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+// SubscribeRequest selects the live updates a SubscribeTimeSeries stream
+// pushes. Without a window, each message carries the newly written raw
+// points; with one, the recomputed buckets they touched.
+type SubscribeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Window      string                 `protobuf:"bytes,1,opt,name=window,proto3" json:"window,omitempty"`                            // Optional, e.g. '1m', '5m', '1h', '1d'
+	Aggregation string                 `protobuf:"bytes,2,opt,name=aggregation,proto3" json:"aggregation,omitempty"`                  // Required with window: 'MIN', 'MAX', 'AVG', 'SUM', 'LOAD_FACTOR', 'UTILIZATION'
+	Start       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=start,proto3" json:"start,omitempty"`                              // Optional; ignores points before it
+	End         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=end,proto3" json:"end,omitempty"`                                  // Optional; ignores points after it
+	Overflow    string                 `protobuf:"bytes,5,opt,name=overflow,proto3" json:"overflow,omitempty"`                        // Optional when the client falls behind: 'drop_oldest', 'pause' or 'disconnect'
+	BufferSize  int32                  `protobuf:"varint,6,opt,name=buffer_size,json=bufferSize,proto3" json:"buffer_size,omitempty"` // Optional updates buffered for the client, at most 1024
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *SubscribeRequest) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetAggregation() string {
+	if x != nil {
+		return x.Aggregation
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetOverflow() string {
+	if x != nil {
+		return x.Overflow
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetBufferSize() int32 {
+	if x != nil {
+		return x.BufferSize
+	}
+	return 0
+}
+
+// CompareRequest queries a range and the same range shifted back by each
+// offset, such as this week and the week before.
+type CompareRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start             *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End               *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	Window            string                 `protobuf:"bytes,3,opt,name=window,proto3" json:"window,omitempty"`                                                 // e.g., '1m', '5m', '1h', '1d'
+	Aggregation       string                 `protobuf:"bytes,4,opt,name=aggregation,proto3" json:"aggregation,omitempty"`                                       // 'MIN', 'MAX', 'AVG', 'SUM', 'LOAD_FACTOR', 'UTILIZATION'
+	Calendar          string                 `protobuf:"bytes,5,opt,name=calendar,proto3" json:"calendar,omitempty"`                                             // Optional business calendar, as in TimeSeriesRequest
+	Series            string                 `protobuf:"bytes,6,opt,name=series,proto3" json:"series,omitempty"`                                                 // Optional derived series, as in TimeSeriesRequest
+	Offsets           []string               `protobuf:"bytes,7,rep,name=offsets,proto3" json:"offsets,omitempty"`                                               // e.g. '-7d', '-52w'; negative whole hours, days or weeks, multiples of the window
+	WeatherNormalized bool                   `protobuf:"varint,8,opt,name=weather_normalized,json=weatherNormalized,proto3" json:"weather_normalized,omitempty"` // Restates each range at the normal weather of its time of year, as in TimeSeriesRequest
+	Transform         string                 `protobuf:"bytes,9,opt,name=transform,proto3" json:"transform,omitempty"`                                           // Optional transform, as in TimeSeriesRequest
+	Selector          string                 `protobuf:"bytes,10,opt,name=selector,proto3" json:"selector,omitempty"`                                            // Optional label selector, as in TimeSeriesRequest
+}
+
+func (x *CompareRequest) Reset() {
+	*x = CompareRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompareRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareRequest) ProtoMessage() {}
+
+func (x *CompareRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareRequest.ProtoReflect.Descriptor instead.
+func (*CompareRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *CompareRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *CompareRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *CompareRequest) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+func (x *CompareRequest) GetAggregation() string {
+	if x != nil {
+		return x.Aggregation
+	}
+	return ""
+}
+
+func (x *CompareRequest) GetCalendar() string {
+	if x != nil {
+		return x.Calendar
+	}
+	return ""
+}
+
+func (x *CompareRequest) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *CompareRequest) GetOffsets() []string {
+	if x != nil {
+		return x.Offsets
+	}
+	return nil
+}
+
+func (x *CompareRequest) GetWeatherNormalized() bool {
+	if x != nil {
+		return x.WeatherNormalized
+	}
+	return false
+}
+
+func (x *CompareRequest) GetTransform() string {
+	if x != nil {
+		return x.Transform
+	}
+	return ""
+}
+
+func (x *CompareRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+// ComparedSeries is the buckets of one of the compared ranges.
+type ComparedSeries struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Label        string                 `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"` // 'current' for the requested range, the offset for the others
+	Start        *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"` // The range that was queried
+	End          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	Data         []*TimeSeriesDataPoint `protobuf:"bytes,4,rep,name=data,proto3" json:"data,omitempty"` // Bucket times shifted onto the requested range, so series align
+	Metadata     *QueryMetadata         `protobuf:"bytes,5,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	WeatherModel *WeatherModel          `protobuf:"bytes,6,opt,name=weather_model,json=weatherModel,proto3" json:"weather_model,omitempty"` // With weather_normalized only; fitted to this range alone
+}
+
+func (x *ComparedSeries) Reset() {
+	*x = ComparedSeries{}
+	mi := &file_proto_timeseries_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ComparedSeries) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ComparedSeries) ProtoMessage() {}
+
+func (x *ComparedSeries) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ComparedSeries.ProtoReflect.Descriptor instead.
+func (*ComparedSeries) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *ComparedSeries) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *ComparedSeries) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ComparedSeries) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *ComparedSeries) GetData() []*TimeSeriesDataPoint {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ComparedSeries) GetMetadata() *QueryMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *ComparedSeries) GetWeatherModel() *WeatherModel {
+	if x != nil {
+		return x.WeatherModel
+	}
+	return nil
+}
+
+type CompareResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Series []*ComparedSeries `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"` // The requested range, then one per offset in request order
+}
+
+func (x *CompareResponse) Reset() {
+	*x = CompareResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompareResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareResponse) ProtoMessage() {}
+
+func (x *CompareResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareResponse.ProtoReflect.Descriptor instead.
+func (*CompareResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *CompareResponse) GetSeries() []*ComparedSeries {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+// Series describes a series queries may name. Stored series carry the
+// range and count of their samples; derived and virtual series are
+// computed when read and carry their expression instead.
+type Series struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name        string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Kind        string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"` // 'stored', 'derived' or 'virtual'
+	Unit        string                 `protobuf:"bytes,3,opt,name=unit,proto3" json:"unit,omitempty"`
+	Description string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	Tags        map[string]string      `protobuf:"bytes,5,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	FirstTime   *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=first_time,json=firstTime,proto3" json:"first_time,omitempty"`     // Stored series with samples only
+	LastTime    *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=last_time,json=lastTime,proto3" json:"last_time,omitempty"`        // Stored series with samples only
+	PointCount  int64                  `protobuf:"varint,8,opt,name=point_count,json=pointCount,proto3" json:"point_count,omitempty"` // Stored series only
+	Expression  string                 `protobuf:"bytes,9,opt,name=expression,proto3" json:"expression,omitempty"`                    // Derived and virtual series only
+}
+
+func (x *Series) Reset() {
+	*x = Series{}
+	mi := &file_proto_timeseries_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Series) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Series) ProtoMessage() {}
+
+func (x *Series) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Series.ProtoReflect.Descriptor instead.
+func (*Series) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *Series) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Series) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Series) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *Series) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Series) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Series) GetFirstTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FirstTime
+	}
+	return nil
+}
+
+func (x *Series) GetLastTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastTime
+	}
+	return nil
+}
+
+func (x *Series) GetPointCount() int64 {
+	if x != nil {
+		return x.PointCount
+	}
+	return 0
+}
+
+func (x *Series) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+type ListSeriesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Kind     string            `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`                                                                                         // Optional; lists every kind when empty
+	Tags     map[string]string `protobuf:"bytes,2,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"` // Optional; lists the series carrying all of them
+	Selector string            `protobuf:"bytes,3,opt,name=selector,proto3" json:"selector,omitempty"`                                                                                 // Optional label selector, e.g. '{site="plant1",phase=~"A|B"}'
+}
+
+func (x *ListSeriesRequest) Reset() {
+	*x = ListSeriesRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSeriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSeriesRequest) ProtoMessage() {}
+
+func (x *ListSeriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSeriesRequest.ProtoReflect.Descriptor instead.
+func (*ListSeriesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *ListSeriesRequest) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *ListSeriesRequest) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *ListSeriesRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+type ListSeriesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Series []*Series `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"` // By name
+}
+
+func (x *ListSeriesResponse) Reset() {
+	*x = ListSeriesResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSeriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSeriesResponse) ProtoMessage() {}
+
+func (x *ListSeriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSeriesResponse.ProtoReflect.Descriptor instead.
+func (*ListSeriesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *ListSeriesResponse) GetSeries() []*Series {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+type GetSeriesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (x *GetSeriesRequest) Reset() {
+	*x = GetSeriesRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSeriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSeriesRequest) ProtoMessage() {}
+
+func (x *GetSeriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSeriesRequest.ProtoReflect.Descriptor instead.
+func (*GetSeriesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *GetSeriesRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type TopKRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start       *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	Window      string                 `protobuf:"bytes,3,opt,name=window,proto3" json:"window,omitempty"`           // The buckets the series are computed from, e.g. '1h'
+	Aggregation string                 `protobuf:"bytes,4,opt,name=aggregation,proto3" json:"aggregation,omitempty"` // 'MIN', 'MAX', 'AVG' or 'SUM', of each bucket and over the range
+	Selector    string                 `protobuf:"bytes,5,opt,name=selector,proto3" json:"selector,omitempty"`       // Label selector choosing the series ranked, e.g. '{kind="feeder"}'
+	K           int32                  `protobuf:"varint,6,opt,name=k,proto3" json:"k,omitempty"`                    // Number of series returned; 1 to 1000
+	Bottom      bool                   `protobuf:"varint,7,opt,name=bottom,proto3" json:"bottom,omitempty"`          // Ranks the lowest aggregates first instead of the highest
+	Calendar    string                 `protobuf:"bytes,8,opt,name=calendar,proto3" json:"calendar,omitempty"`       // Optional business calendar, as in TimeSeriesRequest
+}
+
+func (x *TopKRequest) Reset() {
+	*x = TopKRequest{}
+	mi := &file_proto_timeseries_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TopKRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TopKRequest) ProtoMessage() {}
+
+func (x *TopKRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TopKRequest.ProtoReflect.Descriptor instead.
+func (*TopKRequest) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *TopKRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *TopKRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *TopKRequest) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+func (x *TopKRequest) GetAggregation() string {
+	if x != nil {
+		return x.Aggregation
+	}
+	return ""
+}
+
+func (x *TopKRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+func (x *TopKRequest) GetK() int32 {
+	if x != nil {
+		return x.K
+	}
+	return 0
+}
+
+func (x *TopKRequest) GetBottom() bool {
+	if x != nil {
+		return x.Bottom
+	}
+	return false
+}
+
+func (x *TopKRequest) GetCalendar() string {
+	if x != nil {
+		return x.Calendar
+	}
+	return ""
+}
+
+// RankedSeries is a series with its aggregate over the range of a
+// TopKRequest.
+type RankedSeries struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Series string                 `protobuf:"bytes,1,opt,name=series,proto3" json:"series,omitempty"`
+	Tags   map[string]string      `protobuf:"bytes,2,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Value  float64                `protobuf:"fixed64,3,opt,name=value,proto3" json:"value,omitempty"` // The aggregate over the range
+	Time   *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=time,proto3" json:"time,omitempty"`     // The bucket the value was reached in, for MIN and MAX
+}
+
+func (x *RankedSeries) Reset() {
+	*x = RankedSeries{}
+	mi := &file_proto_timeseries_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RankedSeries) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RankedSeries) ProtoMessage() {}
+
+func (x *RankedSeries) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RankedSeries.ProtoReflect.Descriptor instead.
+func (*RankedSeries) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *RankedSeries) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *RankedSeries) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *RankedSeries) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *RankedSeries) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+type TopKResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Series      []*RankedSeries `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"`                               // Highest aggregates first, or lowest with bottom; ties by name
+	TotalSeries int32           `protobuf:"varint,2,opt,name=total_series,json=totalSeries,proto3" json:"total_series,omitempty"` // Series the selector selects, including those without samples in the range
+	Metadata    *QueryMetadata  `protobuf:"bytes,3,opt,name=metadata,proto3" json:"metadata,omitempty"`
+}
+
+func (x *TopKResponse) Reset() {
+	*x = TopKResponse{}
+	mi := &file_proto_timeseries_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TopKResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TopKResponse) ProtoMessage() {}
+
+func (x *TopKResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_timeseries_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TopKResponse.ProtoReflect.Descriptor instead.
+func (*TopKResponse) Descriptor() ([]byte, []int) {
+	return file_proto_timeseries_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *TopKResponse) GetSeries() []*RankedSeries {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+func (x *TopKResponse) GetTotalSeries() int32 {
+	if x != nil {
+		return x.TotalSeries
+	}
+	return 0
+}
+
+func (x *TopKResponse) GetMetadata() *QueryMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+var File_proto_timeseries_proto protoreflect.FileDescriptor
+
+var file_proto_timeseries_proto_rawDesc = []byte{
+	0x0a, 0x16, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x65, 0x72, 0x69,
+	0x65, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x22, 0xeb, 0x03, 0x0a, 0x11, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72,
+	0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e,
+	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x64,
+	0x6f, 0x77, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77,
+	0x12, 0x20, 0x0a, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x61, 0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x61, 0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x12, 0x2d,
+	0x0a, 0x12, 0x77, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72, 0x5f, 0x6e, 0x6f, 0x72, 0x6d, 0x61, 0x6c,
+	0x69, 0x7a, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x77, 0x65, 0x61, 0x74,
+	0x68, 0x65, 0x72, 0x4e, 0x6f, 0x72, 0x6d, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x64, 0x12, 0x1b, 0x0a,
+	0x09, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x08, 0x70, 0x61, 0x67, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x61,
+	0x67, 0x65, 0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x70, 0x61, 0x67, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x1d, 0x0a, 0x0a, 0x6d, 0x61, 0x78,
+	0x5f, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x6d,
+	0x61, 0x78, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x69, 0x6e, 0x63, 0x6c,
+	0x75, 0x64, 0x65, 0x5f, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x0f, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x43, 0x68, 0x65, 0x63, 0x6b,
+	0x73, 0x75, 0x6d, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x0b, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x74,
+	0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x6c,
+	0x65, 0x63, 0x74, 0x6f, 0x72, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6c,
+	0x65, 0x63, 0x74, 0x6f, 0x72, 0x12, 0x19, 0x0a, 0x08, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x5f, 0x62,
+	0x79, 0x18, 0x0e, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x42, 0x79,
+	0x22, 0x5b, 0x0a, 0x13, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x44, 0x61,
+	0x74, 0x61, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x2e, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0xa4, 0x02,
+	0x0a, 0x12, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x30, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x44, 0x61, 0x74, 0x61, 0x50, 0x6f, 0x69, 0x6e, 0x74,
+	0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x3a, 0x0a, 0x0d, 0x77, 0x65, 0x61, 0x74, 0x68, 0x65,
+	0x72, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x15, 0x2e,
+	0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x57, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72, 0x4d,
+	0x6f, 0x64, 0x65, 0x6c, 0x52, 0x0c, 0x77, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72, 0x4d, 0x6f, 0x64,
+	0x65, 0x6c, 0x12, 0x26, 0x0a, 0x0f, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x70, 0x61, 0x67, 0x65, 0x5f,
+	0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x6e, 0x65, 0x78,
+	0x74, 0x50, 0x61, 0x67, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69,
+	0x6e, 0x64, 0x6f, 0x77, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64,
+	0x6f, 0x77, 0x12, 0x32, 0x0a, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x51,
+	0x75, 0x65, 0x72, 0x79, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x08, 0x6d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x12, 0x2c, 0x0a, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73,
+	0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d,
+	0x2e, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x52, 0x06, 0x67, 0x72,
+	0x6f, 0x75, 0x70, 0x73, 0x22, 0xfe, 0x01, 0x0a, 0x0c, 0x57, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72,
+	0x4d, 0x6f, 0x64, 0x65, 0x6c, 0x12, 0x23, 0x0a, 0x0d, 0x62, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x65,
+	0x5f, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0c, 0x62, 0x61,
+	0x6c, 0x61, 0x6e, 0x63, 0x65, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x61,
+	0x73, 0x65, 0x5f, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x62,
+	0x61, 0x73, 0x65, 0x4c, 0x6f, 0x61, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x68, 0x65, 0x61, 0x74, 0x69,
+	0x6e, 0x67, 0x5f, 0x73, 0x6c, 0x6f, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0c,
+	0x68, 0x65, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x53, 0x6c, 0x6f, 0x70, 0x65, 0x12, 0x23, 0x0a, 0x0d,
+	0x63, 0x6f, 0x6f, 0x6c, 0x69, 0x6e, 0x67, 0x5f, 0x73, 0x6c, 0x6f, 0x70, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x0c, 0x63, 0x6f, 0x6f, 0x6c, 0x69, 0x6e, 0x67, 0x53, 0x6c, 0x6f, 0x70,
+	0x65, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x5f, 0x73, 0x71, 0x75, 0x61, 0x72, 0x65, 0x64, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x72, 0x53, 0x71, 0x75, 0x61, 0x72, 0x65, 0x64, 0x12, 0x22,
+	0x0a, 0x0c, 0x6f, 0x62, 0x73, 0x65, 0x72, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x0c, 0x6f, 0x62, 0x73, 0x65, 0x72, 0x76, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6e, 0x6f, 0x72, 0x6d, 0x61, 0x6c, 0x5f, 0x79, 0x65, 0x61,
+	0x72, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x6e, 0x6f, 0x72, 0x6d, 0x61, 0x6c,
+	0x59, 0x65, 0x61, 0x72, 0x73, 0x22, 0xcc, 0x01, 0x0a, 0x0b, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73,
+	0x47, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x38, 0x0a, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x20, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e,
+	0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x2e, 0x4c, 0x61, 0x62, 0x65,
+	0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x12,
+	0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x30, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18,
+	0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e,
+	0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x44, 0x61, 0x74, 0x61, 0x50, 0x6f,
+	0x69, 0x6e, 0x74, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x1a, 0x39, 0x0a, 0x0b, 0x4c, 0x61, 0x62,
+	0x65, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x3a, 0x02, 0x38, 0x01, 0x22, 0x8e, 0x04, 0x0a, 0x0d, 0x51, 0x75, 0x65, 0x72, 0x79, 0x4d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x20,
+	0x0a, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x23, 0x0a, 0x0d, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74,
+	0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x03, 0x52, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x43,
+	0x6f, 0x75, 0x6e, 0x74, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x73,
+	0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x74, 0x6f,
+	0x74, 0x61, 0x6c, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x12, 0x40, 0x0a, 0x0e, 0x71, 0x75,
+	0x65, 0x72, 0x79, 0x5f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0d, 0x71,
+	0x75, 0x65, 0x72, 0x79, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1f, 0x0a, 0x0b,
+	0x67, 0x61, 0x70, 0x73, 0x5f, 0x66, 0x69, 0x6c, 0x6c, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0a, 0x67, 0x61, 0x70, 0x73, 0x46, 0x69, 0x6c, 0x6c, 0x65, 0x64, 0x12, 0x20, 0x0a,
+	0x0b, 0x64, 0x6f, 0x77, 0x6e, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x0b, 0x64, 0x6f, 0x77, 0x6e, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x64, 0x12,
+	0x1a, 0x0a, 0x08, 0x63, 0x61, 0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x63, 0x61, 0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x63,
+	0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63,
+	0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x12, 0x38, 0x0a, 0x09, 0x77, 0x61, 0x74, 0x65, 0x72,
+	0x6d, 0x61, 0x72, 0x6b, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x77, 0x61, 0x74, 0x65, 0x72, 0x6d, 0x61, 0x72,
+	0x6b, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x72, 0x61,
+	0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x74, 0x72,
+	0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x6c, 0x65,
+	0x18, 0x0d, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x12, 0x36, 0x0a,
+	0x09, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x5f, 0x61, 0x67, 0x65, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x73, 0x74, 0x61,
+	0x6c, 0x65, 0x41, 0x67, 0x65, 0x22, 0xad, 0x01, 0x0a, 0x0f, 0x52, 0x61, 0x77, 0x51, 0x75, 0x65,
+	0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65,
+	0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x61, 0x67,
+	0x65, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x70, 0x61,
+	0x67, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x74,
+	0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x70, 0x61, 0x67, 0x65,
+	0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0x6c, 0x0a, 0x10, 0x52, 0x61, 0x77, 0x51, 0x75, 0x65, 0x72,
+	0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x30, 0x0a, 0x04, 0x64, 0x61, 0x74,
+	0x61, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x44, 0x61, 0x74, 0x61,
+	0x50, 0x6f, 0x69, 0x6e, 0x74, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x26, 0x0a, 0x0f, 0x6e,
+	0x65, 0x78, 0x74, 0x5f, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x6e, 0x65, 0x78, 0x74, 0x50, 0x61, 0x67, 0x65, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x22, 0x25, 0x0a, 0x0d, 0x4c, 0x61, 0x74, 0x65, 0x73, 0x74, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0x42, 0x0a, 0x0e, 0x4c, 0x61,
+	0x74, 0x65, 0x73, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x30, 0x0a, 0x04,
+	0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x65, 0x64, 0x67,
+	0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x44,
+	0x61, 0x74, 0x61, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x73,
+	0x0a, 0x11, 0x53, 0x74, 0x61, 0x74, 0x69, 0x73, 0x74, 0x69, 0x63, 0x73, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05,
+	0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x03,
+	0x65, 0x6e, 0x64, 0x22, 0xfe, 0x01, 0x0a, 0x12, 0x53, 0x74, 0x61, 0x74, 0x69, 0x73, 0x74, 0x69,
+	0x63, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f,
+	0x75, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74,
+	0x12, 0x10, 0x0a, 0x03, 0x6d, 0x69, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6d,
+	0x69, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x03, 0x6d, 0x61, 0x78, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x76, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x03, 0x61, 0x76, 0x67, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x75, 0x6d, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x03, 0x73, 0x75, 0x6d, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x64, 0x64,
+	0x65, 0x76, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x06, 0x73, 0x74, 0x64, 0x64, 0x65, 0x76,
+	0x12, 0x39, 0x0a, 0x0a, 0x66, 0x69, 0x72, 0x73, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x07,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x09, 0x66, 0x69, 0x72, 0x73, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x37, 0x0a, 0x09, 0x6c,
+	0x61, 0x73, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x08, 0x6c, 0x61, 0x73, 0x74,
+	0x54, 0x69, 0x6d, 0x65, 0x22, 0x41, 0x0a, 0x0d, 0x49, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20,
 	0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x54, 0x69,
 	0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x44, 0x61, 0x74, 0x61, 0x50, 0x6f, 0x69, 0x6e,
-	0x74, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x32, 0x61, 0x0a, 0x11, 0x54, 0x69, 0x6d, 0x65, 0x53,
-	0x65, 0x72, 0x69, 0x65, 0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x4c, 0x0a, 0x0f,
-	0x51, 0x75, 0x65, 0x72, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12,
-	0x1a, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65,
-	0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x65, 0x64,
+	0x74, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x2c, 0x0a, 0x0e, 0x49, 0x6e, 0x73, 0x65, 0x72,
+	0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x69, 0x6e, 0x73,
+	0x65, 0x72, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x69, 0x6e, 0x73,
+	0x65, 0x72, 0x74, 0x65, 0x64, 0x22, 0x8a, 0x01, 0x0a, 0x10, 0x45, 0x6d, 0x69, 0x73, 0x73, 0x69,
+	0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74,
+	0x61, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03,
+	0x65, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69,
+	0x6e, 0x64, 0x6f, 0x77, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64,
+	0x6f, 0x77, 0x22, 0xa4, 0x01, 0x0a, 0x0f, 0x45, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73,
+	0x42, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x12, 0x2e, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x20, 0x0a, 0x0b, 0x63, 0x6f, 0x6e, 0x73, 0x75, 0x6d,
+	0x70, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x63, 0x6f, 0x6e,
+	0x73, 0x75, 0x6d, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1c, 0x0a, 0x09, 0x69, 0x6e, 0x74, 0x65,
+	0x6e, 0x73, 0x69, 0x74, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x69, 0x6e, 0x74,
+	0x65, 0x6e, 0x73, 0x69, 0x74, 0x79, 0x12, 0x21, 0x0a, 0x0c, 0x65, 0x6d, 0x69, 0x73, 0x73, 0x69,
+	0x6f, 0x6e, 0x73, 0x5f, 0x6b, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x65, 0x6d,
+	0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x4b, 0x67, 0x22, 0x6f, 0x0a, 0x11, 0x45, 0x6d, 0x69,
+	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2c,
+	0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x65,
+	0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x45, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73,
+	0x42, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x2c, 0x0a, 0x12,
+	0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x65, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x5f,
+	0x6b, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x10, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x45,
+	0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x4b, 0x67, 0x22, 0xc4, 0x01, 0x0a, 0x13, 0x44,
+	0x65, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65,
+	0x6e, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x29, 0x0a, 0x10, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74,
+	0x5f, 0x72, 0x65, 0x64, 0x75, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x0f, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x52, 0x65, 0x64, 0x75, 0x63, 0x74, 0x69, 0x6f,
+	0x6e, 0x22, 0x81, 0x01, 0x0a, 0x1f, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x03, 0x65, 0x6e, 0x64, 0x22, 0xba, 0x02, 0x0a, 0x19, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x50, 0x65, 0x72, 0x66, 0x6f, 0x72, 0x6d, 0x61,
+	0x6e, 0x63, 0x65, 0x12, 0x32, 0x0a, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x44, 0x65, 0x6d,
+	0x61, 0x6e, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x52, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x65, 0x76, 0x61, 0x6c, 0x75,
+	0x61, 0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x65, 0x76, 0x61, 0x6c,
+	0x75, 0x61, 0x74, 0x65, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74,
+	0x65, 0x12, 0x1a, 0x0a, 0x08, 0x62, 0x61, 0x73, 0x65, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x08, 0x62, 0x61, 0x73, 0x65, 0x6c, 0x69, 0x6e, 0x65, 0x12, 0x16, 0x0a,
+	0x06, 0x61, 0x63, 0x74, 0x75, 0x61, 0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01, 0x52, 0x06, 0x61,
+	0x63, 0x74, 0x75, 0x61, 0x6c, 0x12, 0x2d, 0x0a, 0x12, 0x61, 0x63, 0x68, 0x69, 0x65, 0x76, 0x65,
+	0x64, 0x5f, 0x72, 0x65, 0x64, 0x75, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x11, 0x61, 0x63, 0x68, 0x69, 0x65, 0x76, 0x65, 0x64, 0x52, 0x65, 0x64, 0x75, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x5f, 0x61,
+	0x63, 0x68, 0x69, 0x65, 0x76, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0e, 0x74,
+	0x61, 0x72, 0x67, 0x65, 0x74, 0x41, 0x63, 0x68, 0x69, 0x65, 0x76, 0x65, 0x64, 0x12, 0x23, 0x0a,
+	0x0d, 0x62, 0x61, 0x73, 0x65, 0x6c, 0x69, 0x6e, 0x65, 0x5f, 0x64, 0x61, 0x79, 0x73, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x0c, 0x62, 0x61, 0x73, 0x65, 0x6c, 0x69, 0x6e, 0x65, 0x44, 0x61,
+	0x79, 0x73, 0x22, 0x5e, 0x0a, 0x20, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3a, 0x0a, 0x06, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73,
+	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d,
+	0x2e, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x50,
+	0x65, 0x72, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x06, 0x65, 0x76, 0x65, 0x6e,
+	0x74, 0x73, 0x22, 0x15, 0x0a, 0x13, 0x42, 0x75, 0x64, 0x67, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0xfb, 0x02, 0x0a, 0x0c, 0x42, 0x75,
+	0x64, 0x67, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
+	0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12,
+	0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6b, 0x69,
+	0x6e, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x3b, 0x0a, 0x0b, 0x6d, 0x6f, 0x6e, 0x74,
+	0x68, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0a, 0x6d, 0x6f, 0x6e, 0x74, 0x68,
+	0x53, 0x74, 0x61, 0x72, 0x74, 0x12, 0x37, 0x0a, 0x09, 0x6d, 0x6f, 0x6e, 0x74, 0x68, 0x5f, 0x65,
+	0x6e, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x08, 0x6d, 0x6f, 0x6e, 0x74, 0x68, 0x45, 0x6e, 0x64, 0x12, 0x16,
+	0x0a, 0x06, 0x61, 0x63, 0x74, 0x75, 0x61, 0x6c, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x06,
+	0x61, 0x63, 0x74, 0x75, 0x61, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x70, 0x72, 0x6f, 0x6a, 0x65, 0x63,
+	0x74, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x70, 0x72, 0x6f, 0x6a, 0x65,
+	0x63, 0x74, 0x65, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x75, 0x73, 0x65, 0x64, 0x5f, 0x66, 0x72, 0x61,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0c, 0x75, 0x73, 0x65,
+	0x64, 0x46, 0x72, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2d, 0x0a, 0x12, 0x70, 0x72, 0x6f,
+	0x6a, 0x65, 0x63, 0x74, 0x65, 0x64, 0x5f, 0x66, 0x72, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x09, 0x20, 0x01, 0x28, 0x01, 0x52, 0x11, 0x70, 0x72, 0x6f, 0x6a, 0x65, 0x63, 0x74, 0x65, 0x64,
+	0x46, 0x72, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2d, 0x0a, 0x12, 0x63, 0x72, 0x6f, 0x73,
+	0x73, 0x65, 0x64, 0x5f, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x73, 0x18, 0x0a,
+	0x20, 0x03, 0x28, 0x01, 0x52, 0x11, 0x63, 0x72, 0x6f, 0x73, 0x73, 0x65, 0x64, 0x54, 0x68, 0x72,
+	0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x73, 0x22, 0x47, 0x0a, 0x14, 0x42, 0x75, 0x64, 0x67, 0x65,
+	0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x2f, 0x0a, 0x07, 0x62, 0x75, 0x64, 0x67, 0x65, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x15, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x42, 0x75, 0x64, 0x67, 0x65,
+	0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x07, 0x62, 0x75, 0x64, 0x67, 0x65, 0x74, 0x73,
+	0x22, 0x8a, 0x01, 0x0a, 0x10, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x22, 0xa7, 0x02,
+	0x0a, 0x12, 0x43, 0x6f, 0x6e, 0x73, 0x75, 0x6d, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x6d,
+	0x6d, 0x61, 0x72, 0x79, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52,
+	0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52,
+	0x03, 0x65, 0x6e, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x6b, 0x77,
+	0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x4b, 0x77,
+	0x68, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x65, 0x61, 0x6b, 0x5f, 0x6b, 0x77, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x06, 0x70, 0x65, 0x61, 0x6b, 0x4b, 0x77, 0x12, 0x37, 0x0a, 0x09, 0x70, 0x65,
+	0x61, 0x6b, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x08, 0x70, 0x65, 0x61, 0x6b, 0x54,
+	0x69, 0x6d, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x66, 0x61, 0x63, 0x74,
+	0x6f, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x6c, 0x6f, 0x61, 0x64, 0x46, 0x61,
+	0x63, 0x74, 0x6f, 0x72, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x5f, 0x63,
+	0x6f, 0x75, 0x6e, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x73, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0x4e, 0x0a, 0x11, 0x53, 0x75, 0x6d, 0x6d, 0x61,
+	0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x39, 0x0a, 0x09,
+	0x73, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x1b, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x43, 0x6f, 0x6e, 0x73, 0x75, 0x6d,
+	0x70, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79, 0x52, 0x09, 0x73, 0x75,
+	0x6d, 0x6d, 0x61, 0x72, 0x69, 0x65, 0x73, 0x22, 0xd5, 0x01, 0x0a, 0x0d, 0x45, 0x78, 0x70, 0x6f,
+	0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65,
+	0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e,
+	0x64, 0x6f, 0x77, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f,
+	0x77, 0x12, 0x20, 0x0a, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x67,
+	0x7a, 0x69, 0x70, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x67, 0x7a, 0x69, 0x70, 0x22,
+	0x60, 0x0a, 0x0b, 0x45, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x12,
+	0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e,
+	0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x6e, 0x61, 0x6d,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x6e, 0x61, 0x6d,
+	0x65, 0x22, 0xe9, 0x01, 0x0a, 0x10, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x20,
+	0x0a, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64,
+	0x12, 0x1a, 0x0a, 0x08, 0x6f, 0x76, 0x65, 0x72, 0x66, 0x6c, 0x6f, 0x77, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x6f, 0x76, 0x65, 0x72, 0x66, 0x6c, 0x6f, 0x77, 0x12, 0x1f, 0x0a, 0x0b,
+	0x62, 0x75, 0x66, 0x66, 0x65, 0x72, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0a, 0x62, 0x75, 0x66, 0x66, 0x65, 0x72, 0x53, 0x69, 0x7a, 0x65, 0x22, 0xe1, 0x02,
+	0x0a, 0x0e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64,
+	0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x20, 0x0a, 0x0b, 0x61, 0x67, 0x67, 0x72,
+	0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61,
+	0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x61,
+	0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x61,
+	0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x18,
+	0x0a, 0x07, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x07, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x73, 0x12, 0x2d, 0x0a, 0x12, 0x77, 0x65, 0x61, 0x74,
+	0x68, 0x65, 0x72, 0x5f, 0x6e, 0x6f, 0x72, 0x6d, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x64, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x77, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72, 0x4e, 0x6f, 0x72,
+	0x6d, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x72, 0x61, 0x6e, 0x73,
+	0x66, 0x6f, 0x72, 0x6d, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x74, 0x72, 0x61, 0x6e,
+	0x73, 0x66, 0x6f, 0x72, 0x6d, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f,
+	0x72, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f,
+	0x72, 0x22, 0xa8, 0x02, 0x0a, 0x0e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x64, 0x53, 0x65,
+	0x72, 0x69, 0x65, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x05, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74,
+	0x61, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03,
+	0x65, 0x6e, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x30, 0x0a, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63,
+	0x6f, 0x6d, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x44, 0x61, 0x74,
+	0x61, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x32, 0x0a, 0x08,
+	0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16,
+	0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x51, 0x75, 0x65, 0x72, 0x79, 0x4d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61,
+	0x12, 0x3a, 0x0a, 0x0d, 0x77, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72, 0x5f, 0x6d, 0x6f, 0x64, 0x65,
+	0x6c, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x2e, 0x57, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72, 0x4d, 0x6f, 0x64, 0x65, 0x6c, 0x52, 0x0c,
+	0x77, 0x65, 0x61, 0x74, 0x68, 0x65, 0x72, 0x4d, 0x6f, 0x64, 0x65, 0x6c, 0x22, 0x42, 0x0a, 0x0f,
+	0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x2f, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x17, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72,
+	0x65, 0x64, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73,
+	0x22, 0x83, 0x03, 0x0a, 0x06, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x6e,
+	0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6b,
+	0x69, 0x6e, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x6e, 0x69, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x75, 0x6e, 0x69, 0x74, 0x12, 0x20, 0x0a, 0x0b, 0x64, 0x65, 0x73, 0x63, 0x72,
+	0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x65,
+	0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2d, 0x0a, 0x04, 0x74, 0x61, 0x67,
+	0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x2e, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x2e, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x12, 0x39, 0x0a, 0x0a, 0x66, 0x69, 0x72, 0x73,
+	0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x66, 0x69, 0x72, 0x73, 0x74, 0x54,
+	0x69, 0x6d, 0x65, 0x12, 0x37, 0x0a, 0x09, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x52, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x1f, 0x0a, 0x0b,
+	0x70, 0x6f, 0x69, 0x6e, 0x74, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0a, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x1e, 0x0a,
+	0x0a, 0x65, 0x78, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x09, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x65, 0x78, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x1a, 0x37, 0x0a,
+	0x09, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65,
+	0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0xb6, 0x01, 0x0a, 0x11, 0x4c, 0x69, 0x73, 0x74, 0x53,
+	0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04,
+	0x6b, 0x69, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6b, 0x69, 0x6e, 0x64,
+	0x12, 0x38, 0x0a, 0x04, 0x74, 0x61, 0x67, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x24,
+	0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72,
+	0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x54, 0x61, 0x67, 0x73, 0x45,
+	0x6e, 0x74, 0x72, 0x79, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65,
+	0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65,
+	0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x1a, 0x37, 0x0a, 0x09, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22,
+	0x3d, 0x0a, 0x12, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x27, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e,
+	0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x22, 0x26,
+	0x0a, 0x10, 0x47, 0x65, 0x74, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x85, 0x02, 0x0a, 0x0b, 0x54, 0x6f, 0x70, 0x4b, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x20,
+	0x0a, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x12, 0x0c, 0x0a, 0x01,
+	0x6b, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x01, 0x6b, 0x12, 0x16, 0x0a, 0x06, 0x62, 0x6f,
+	0x74, 0x74, 0x6f, 0x6d, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x62, 0x6f, 0x74, 0x74,
+	0x6f, 0x6d, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x61, 0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x61, 0x6c, 0x65, 0x6e, 0x64, 0x61, 0x72, 0x22, 0xda,
+	0x01, 0x0a, 0x0c, 0x52, 0x61, 0x6e, 0x6b, 0x65, 0x64, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12,
+	0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x33, 0x0a, 0x04, 0x74, 0x61, 0x67, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1f, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e,
+	0x52, 0x61, 0x6e, 0x6b, 0x65, 0x64, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x2e, 0x54, 0x61, 0x67,
+	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x12, 0x14, 0x0a, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x12, 0x2e, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x04, 0x74, 0x69,
+	0x6d, 0x65, 0x1a, 0x37, 0x0a, 0x09, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12,
+	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
+	0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x94, 0x01, 0x0a, 0x0c,
+	0x54, 0x6f, 0x70, 0x4b, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2d, 0x0a, 0x06,
+	0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x65,
+	0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x52, 0x61, 0x6e, 0x6b, 0x65, 0x64, 0x53, 0x65, 0x72,
+	0x69, 0x65, 0x73, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x74,
+	0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0b, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x32,
+	0x0a, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x16, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x51, 0x75, 0x65, 0x72, 0x79,
+	0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61,
+	0x74, 0x61, 0x32, 0x92, 0x0a, 0x0a, 0x11, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65,
+	0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x4c, 0x0a, 0x0f, 0x51, 0x75, 0x65, 0x72,
+	0x79, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x1a, 0x2e, 0x65, 0x64,
 	0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73,
-	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x29, 0x5a, 0x27, 0x67, 0x69,
-	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x65, 0x6a, 0x75, 0x73, 0x62, 0x68,
-	0x61, 0x72, 0x61, 0x64, 0x77, 0x61, 0x6a, 0x2f, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2f,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x41, 0x0a, 0x08, 0x51, 0x75, 0x65, 0x72, 0x79, 0x52,
+	0x61, 0x77, 0x12, 0x18, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x52, 0x61, 0x77,
+	0x51, 0x75, 0x65, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e, 0x65,
+	0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x52, 0x61, 0x77, 0x51, 0x75, 0x65, 0x72, 0x79, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x3e, 0x0a, 0x09, 0x47, 0x65, 0x74,
+	0x4c, 0x61, 0x74, 0x65, 0x73, 0x74, 0x12, 0x16, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d,
+	0x2e, 0x4c, 0x61, 0x74, 0x65, 0x73, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17,
+	0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x4c, 0x61, 0x74, 0x65, 0x73, 0x74, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x4a, 0x0a, 0x0d, 0x47, 0x65, 0x74,
+	0x53, 0x74, 0x61, 0x74, 0x69, 0x73, 0x74, 0x69, 0x63, 0x73, 0x12, 0x1a, 0x2e, 0x65, 0x64, 0x67,
+	0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x69, 0x73, 0x74, 0x69, 0x63, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d,
+	0x2e, 0x53, 0x74, 0x61, 0x74, 0x69, 0x73, 0x74, 0x69, 0x63, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x45, 0x0a, 0x10, 0x49, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x54,
+	0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x16, 0x2e, 0x65, 0x64, 0x67, 0x65,
+	0x63, 0x6f, 0x6d, 0x2e, 0x49, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x17, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x49, 0x6e, 0x73, 0x65,
+	0x72, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x47, 0x0a, 0x10,
+	0x49, 0x6e, 0x67, 0x65, 0x73, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73,
+	0x12, 0x16, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x49, 0x6e, 0x73, 0x65, 0x72,
+	0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63,
+	0x6f, 0x6d, 0x2e, 0x49, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x22, 0x00, 0x28, 0x01, 0x12, 0x49, 0x0a, 0x0e, 0x51, 0x75, 0x65, 0x72, 0x79, 0x45, 0x6d,
+	0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x19, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x2e, 0x45, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x45, 0x6d, 0x69,
+	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00,
+	0x12, 0x59, 0x0a, 0x19, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x1c, 0x2e,
+	0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x1a, 0x1c, 0x2e, 0x65, 0x64,
+	0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x22, 0x00, 0x12, 0x71, 0x0a, 0x18, 0x4c,
+	0x69, 0x73, 0x74, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x28, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x29, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x4c, 0x69, 0x73, 0x74,
+	0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x45, 0x76,
+	0x65, 0x6e, 0x74, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x50,
+	0x0a, 0x0f, 0x47, 0x65, 0x74, 0x42, 0x75, 0x64, 0x67, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x1c, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x42, 0x75, 0x64, 0x67,
+	0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1d, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x42, 0x75, 0x64, 0x67, 0x65, 0x74,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00,
+	0x12, 0x47, 0x0a, 0x0c, 0x47, 0x65, 0x74, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x69, 0x65, 0x73,
+	0x12, 0x19, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x53, 0x75, 0x6d, 0x6d, 0x61,
+	0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x65, 0x64,
+	0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x69, 0x65, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x44, 0x0a, 0x10, 0x45, 0x78, 0x70,
+	0x6f, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x16, 0x2e,
+	0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x45, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e,
+	0x45, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x22, 0x00, 0x30, 0x01, 0x12,
+	0x51, 0x0a, 0x13, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x54, 0x69, 0x6d, 0x65,
+	0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x19, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d,
+	0x2e, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x1b, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00,
+	0x30, 0x01, 0x12, 0x48, 0x0a, 0x11, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x54, 0x69, 0x6d,
+	0x65, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x17, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f,
+	0x6d, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x18, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x61,
+	0x72, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x47, 0x0a, 0x0a,
+	0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x1a, 0x2e, 0x65, 0x64, 0x67,
+	0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d,
+	0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x39, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x53, 0x65, 0x72, 0x69,
+	0x65, 0x73, 0x12, 0x19, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x47, 0x65, 0x74,
+	0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e,
+	0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73, 0x22, 0x00,
+	0x12, 0x35, 0x0a, 0x04, 0x54, 0x6f, 0x70, 0x4b, 0x12, 0x14, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x63,
+	0x6f, 0x6d, 0x2e, 0x54, 0x6f, 0x70, 0x4b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15,
+	0x2e, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2e, 0x54, 0x6f, 0x70, 0x4b, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x29, 0x5a, 0x27, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x65, 0x6a, 0x75, 0x73, 0x62, 0x68, 0x61, 0x72, 0x61,
+	0x64, 0x77, 0x61, 0x6a, 0x2f, 0x65, 0x64, 0x67, 0x65, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -240,25 +3488,158 @@ func file_proto_timeseries_proto_rawDescGZIP() []byte {
 	return file_proto_timeseries_proto_rawDescData
 }
 
-var file_proto_timeseries_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_proto_timeseries_proto_msgTypes = make([]protoimpl.MessageInfo, 44)
 var file_proto_timeseries_proto_goTypes = []any{
-	(*TimeSeriesRequest)(nil),     // 0: edgecom.TimeSeriesRequest
-	(*TimeSeriesDataPoint)(nil),   // 1: edgecom.TimeSeriesDataPoint
-	(*TimeSeriesResponse)(nil),    // 2: edgecom.TimeSeriesResponse
-	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
+	(*TimeSeriesRequest)(nil),                // 0: edgecom.TimeSeriesRequest
+	(*TimeSeriesDataPoint)(nil),              // 1: edgecom.TimeSeriesDataPoint
+	(*TimeSeriesResponse)(nil),               // 2: edgecom.TimeSeriesResponse
+	(*WeatherModel)(nil),                     // 3: edgecom.WeatherModel
+	(*SeriesGroup)(nil),                      // 4: edgecom.SeriesGroup
+	(*QueryMetadata)(nil),                    // 5: edgecom.QueryMetadata
+	(*RawQueryRequest)(nil),                  // 6: edgecom.RawQueryRequest
+	(*RawQueryResponse)(nil),                 // 7: edgecom.RawQueryResponse
+	(*LatestRequest)(nil),                    // 8: edgecom.LatestRequest
+	(*LatestResponse)(nil),                   // 9: edgecom.LatestResponse
+	(*StatisticsRequest)(nil),                // 10: edgecom.StatisticsRequest
+	(*StatisticsResponse)(nil),               // 11: edgecom.StatisticsResponse
+	(*InsertRequest)(nil),                    // 12: edgecom.InsertRequest
+	(*InsertResponse)(nil),                   // 13: edgecom.InsertResponse
+	(*EmissionsRequest)(nil),                 // 14: edgecom.EmissionsRequest
+	(*EmissionsBucket)(nil),                  // 15: edgecom.EmissionsBucket
+	(*EmissionsResponse)(nil),                // 16: edgecom.EmissionsResponse
+	(*DemandResponseEvent)(nil),              // 17: edgecom.DemandResponseEvent
+	(*ListDemandResponseEventsRequest)(nil),  // 18: edgecom.ListDemandResponseEventsRequest
+	(*DemandResponsePerformance)(nil),        // 19: edgecom.DemandResponsePerformance
+	(*ListDemandResponseEventsResponse)(nil), // 20: edgecom.ListDemandResponseEventsResponse
+	(*BudgetStatusRequest)(nil),              // 21: edgecom.BudgetStatusRequest
+	(*BudgetStatus)(nil),                     // 22: edgecom.BudgetStatus
+	(*BudgetStatusResponse)(nil),             // 23: edgecom.BudgetStatusResponse
+	(*SummariesRequest)(nil),                 // 24: edgecom.SummariesRequest
+	(*ConsumptionSummary)(nil),               // 25: edgecom.ConsumptionSummary
+	(*SummariesResponse)(nil),                // 26: edgecom.SummariesResponse
+	(*ExportRequest)(nil),                    // 27: edgecom.ExportRequest
+	(*ExportChunk)(nil),                      // 28: edgecom.ExportChunk
+	(*SubscribeRequest)(nil),                 // 29: edgecom.SubscribeRequest
+	(*CompareRequest)(nil),                   // 30: edgecom.CompareRequest
+	(*ComparedSeries)(nil),                   // 31: edgecom.ComparedSeries
+	(*CompareResponse)(nil),                  // 32: edgecom.CompareResponse
+	(*Series)(nil),                           // 33: edgecom.Series
+	(*ListSeriesRequest)(nil),                // 34: edgecom.ListSeriesRequest
+	(*ListSeriesResponse)(nil),               // 35: edgecom.ListSeriesResponse
+	(*GetSeriesRequest)(nil),                 // 36: edgecom.GetSeriesRequest
+	(*TopKRequest)(nil),                      // 37: edgecom.TopKRequest
+	(*RankedSeries)(nil),                     // 38: edgecom.RankedSeries
+	(*TopKResponse)(nil),                     // 39: edgecom.TopKResponse
+	nil,                                      // 40: edgecom.SeriesGroup.LabelsEntry
+	nil,                                      // 41: edgecom.Series.TagsEntry
+	nil,                                      // 42: edgecom.ListSeriesRequest.TagsEntry
+	nil,                                      // 43: edgecom.RankedSeries.TagsEntry
+	(*timestamppb.Timestamp)(nil),            // 44: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),              // 45: google.protobuf.Duration
 }
 var file_proto_timeseries_proto_depIdxs = []int32{
-	3, // 0: edgecom.TimeSeriesRequest.start:type_name -> google.protobuf.Timestamp
-	3, // 1: edgecom.TimeSeriesRequest.end:type_name -> google.protobuf.Timestamp
-	3, // 2: edgecom.TimeSeriesDataPoint.time:type_name -> google.protobuf.Timestamp
-	1, // 3: edgecom.TimeSeriesResponse.data:type_name -> edgecom.TimeSeriesDataPoint
-	0, // 4: edgecom.TimeSeriesService.QueryTimeSeries:input_type -> edgecom.TimeSeriesRequest
-	2, // 5: edgecom.TimeSeriesService.QueryTimeSeries:output_type -> edgecom.TimeSeriesResponse
-	5, // [5:6] is the sub-list for method output_type
-	4, // [4:5] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	44, // 0: edgecom.TimeSeriesRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 1: edgecom.TimeSeriesRequest.end:type_name -> google.protobuf.Timestamp
+	44, // 2: edgecom.TimeSeriesDataPoint.time:type_name -> google.protobuf.Timestamp
+	1,  // 3: edgecom.TimeSeriesResponse.data:type_name -> edgecom.TimeSeriesDataPoint
+	3,  // 4: edgecom.TimeSeriesResponse.weather_model:type_name -> edgecom.WeatherModel
+	5,  // 5: edgecom.TimeSeriesResponse.metadata:type_name -> edgecom.QueryMetadata
+	4,  // 6: edgecom.TimeSeriesResponse.groups:type_name -> edgecom.SeriesGroup
+	40, // 7: edgecom.SeriesGroup.labels:type_name -> edgecom.SeriesGroup.LabelsEntry
+	1,  // 8: edgecom.SeriesGroup.data:type_name -> edgecom.TimeSeriesDataPoint
+	45, // 9: edgecom.QueryMetadata.query_duration:type_name -> google.protobuf.Duration
+	44, // 10: edgecom.QueryMetadata.watermark:type_name -> google.protobuf.Timestamp
+	45, // 11: edgecom.QueryMetadata.stale_age:type_name -> google.protobuf.Duration
+	44, // 12: edgecom.RawQueryRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 13: edgecom.RawQueryRequest.end:type_name -> google.protobuf.Timestamp
+	1,  // 14: edgecom.RawQueryResponse.data:type_name -> edgecom.TimeSeriesDataPoint
+	1,  // 15: edgecom.LatestResponse.data:type_name -> edgecom.TimeSeriesDataPoint
+	44, // 16: edgecom.StatisticsRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 17: edgecom.StatisticsRequest.end:type_name -> google.protobuf.Timestamp
+	44, // 18: edgecom.StatisticsResponse.first_time:type_name -> google.protobuf.Timestamp
+	44, // 19: edgecom.StatisticsResponse.last_time:type_name -> google.protobuf.Timestamp
+	1,  // 20: edgecom.InsertRequest.data:type_name -> edgecom.TimeSeriesDataPoint
+	44, // 21: edgecom.EmissionsRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 22: edgecom.EmissionsRequest.end:type_name -> google.protobuf.Timestamp
+	44, // 23: edgecom.EmissionsBucket.time:type_name -> google.protobuf.Timestamp
+	15, // 24: edgecom.EmissionsResponse.data:type_name -> edgecom.EmissionsBucket
+	44, // 25: edgecom.DemandResponseEvent.start:type_name -> google.protobuf.Timestamp
+	44, // 26: edgecom.DemandResponseEvent.end:type_name -> google.protobuf.Timestamp
+	44, // 27: edgecom.ListDemandResponseEventsRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 28: edgecom.ListDemandResponseEventsRequest.end:type_name -> google.protobuf.Timestamp
+	17, // 29: edgecom.DemandResponsePerformance.event:type_name -> edgecom.DemandResponseEvent
+	19, // 30: edgecom.ListDemandResponseEventsResponse.events:type_name -> edgecom.DemandResponsePerformance
+	44, // 31: edgecom.BudgetStatus.month_start:type_name -> google.protobuf.Timestamp
+	44, // 32: edgecom.BudgetStatus.month_end:type_name -> google.protobuf.Timestamp
+	22, // 33: edgecom.BudgetStatusResponse.budgets:type_name -> edgecom.BudgetStatus
+	44, // 34: edgecom.SummariesRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 35: edgecom.SummariesRequest.end:type_name -> google.protobuf.Timestamp
+	44, // 36: edgecom.ConsumptionSummary.start:type_name -> google.protobuf.Timestamp
+	44, // 37: edgecom.ConsumptionSummary.end:type_name -> google.protobuf.Timestamp
+	44, // 38: edgecom.ConsumptionSummary.peak_time:type_name -> google.protobuf.Timestamp
+	25, // 39: edgecom.SummariesResponse.summaries:type_name -> edgecom.ConsumptionSummary
+	44, // 40: edgecom.ExportRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 41: edgecom.ExportRequest.end:type_name -> google.protobuf.Timestamp
+	44, // 42: edgecom.SubscribeRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 43: edgecom.SubscribeRequest.end:type_name -> google.protobuf.Timestamp
+	44, // 44: edgecom.CompareRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 45: edgecom.CompareRequest.end:type_name -> google.protobuf.Timestamp
+	44, // 46: edgecom.ComparedSeries.start:type_name -> google.protobuf.Timestamp
+	44, // 47: edgecom.ComparedSeries.end:type_name -> google.protobuf.Timestamp
+	1,  // 48: edgecom.ComparedSeries.data:type_name -> edgecom.TimeSeriesDataPoint
+	5,  // 49: edgecom.ComparedSeries.metadata:type_name -> edgecom.QueryMetadata
+	3,  // 50: edgecom.ComparedSeries.weather_model:type_name -> edgecom.WeatherModel
+	31, // 51: edgecom.CompareResponse.series:type_name -> edgecom.ComparedSeries
+	41, // 52: edgecom.Series.tags:type_name -> edgecom.Series.TagsEntry
+	44, // 53: edgecom.Series.first_time:type_name -> google.protobuf.Timestamp
+	44, // 54: edgecom.Series.last_time:type_name -> google.protobuf.Timestamp
+	42, // 55: edgecom.ListSeriesRequest.tags:type_name -> edgecom.ListSeriesRequest.TagsEntry
+	33, // 56: edgecom.ListSeriesResponse.series:type_name -> edgecom.Series
+	44, // 57: edgecom.TopKRequest.start:type_name -> google.protobuf.Timestamp
+	44, // 58: edgecom.TopKRequest.end:type_name -> google.protobuf.Timestamp
+	43, // 59: edgecom.RankedSeries.tags:type_name -> edgecom.RankedSeries.TagsEntry
+	44, // 60: edgecom.RankedSeries.time:type_name -> google.protobuf.Timestamp
+	38, // 61: edgecom.TopKResponse.series:type_name -> edgecom.RankedSeries
+	5,  // 62: edgecom.TopKResponse.metadata:type_name -> edgecom.QueryMetadata
+	0,  // 63: edgecom.TimeSeriesService.QueryTimeSeries:input_type -> edgecom.TimeSeriesRequest
+	6,  // 64: edgecom.TimeSeriesService.QueryRaw:input_type -> edgecom.RawQueryRequest
+	8,  // 65: edgecom.TimeSeriesService.GetLatest:input_type -> edgecom.LatestRequest
+	10, // 66: edgecom.TimeSeriesService.GetStatistics:input_type -> edgecom.StatisticsRequest
+	12, // 67: edgecom.TimeSeriesService.InsertTimeSeries:input_type -> edgecom.InsertRequest
+	12, // 68: edgecom.TimeSeriesService.IngestTimeSeries:input_type -> edgecom.InsertRequest
+	14, // 69: edgecom.TimeSeriesService.QueryEmissions:input_type -> edgecom.EmissionsRequest
+	17, // 70: edgecom.TimeSeriesService.RecordDemandResponseEvent:input_type -> edgecom.DemandResponseEvent
+	18, // 71: edgecom.TimeSeriesService.ListDemandResponseEvents:input_type -> edgecom.ListDemandResponseEventsRequest
+	21, // 72: edgecom.TimeSeriesService.GetBudgetStatus:input_type -> edgecom.BudgetStatusRequest
+	24, // 73: edgecom.TimeSeriesService.GetSummaries:input_type -> edgecom.SummariesRequest
+	27, // 74: edgecom.TimeSeriesService.ExportTimeSeries:input_type -> edgecom.ExportRequest
+	29, // 75: edgecom.TimeSeriesService.SubscribeTimeSeries:input_type -> edgecom.SubscribeRequest
+	30, // 76: edgecom.TimeSeriesService.CompareTimeSeries:input_type -> edgecom.CompareRequest
+	34, // 77: edgecom.TimeSeriesService.ListSeries:input_type -> edgecom.ListSeriesRequest
+	36, // 78: edgecom.TimeSeriesService.GetSeries:input_type -> edgecom.GetSeriesRequest
+	37, // 79: edgecom.TimeSeriesService.TopK:input_type -> edgecom.TopKRequest
+	2,  // 80: edgecom.TimeSeriesService.QueryTimeSeries:output_type -> edgecom.TimeSeriesResponse
+	7,  // 81: edgecom.TimeSeriesService.QueryRaw:output_type -> edgecom.RawQueryResponse
+	9,  // 82: edgecom.TimeSeriesService.GetLatest:output_type -> edgecom.LatestResponse
+	11, // 83: edgecom.TimeSeriesService.GetStatistics:output_type -> edgecom.StatisticsResponse
+	13, // 84: edgecom.TimeSeriesService.InsertTimeSeries:output_type -> edgecom.InsertResponse
+	13, // 85: edgecom.TimeSeriesService.IngestTimeSeries:output_type -> edgecom.InsertResponse
+	16, // 86: edgecom.TimeSeriesService.QueryEmissions:output_type -> edgecom.EmissionsResponse
+	17, // 87: edgecom.TimeSeriesService.RecordDemandResponseEvent:output_type -> edgecom.DemandResponseEvent
+	20, // 88: edgecom.TimeSeriesService.ListDemandResponseEvents:output_type -> edgecom.ListDemandResponseEventsResponse
+	23, // 89: edgecom.TimeSeriesService.GetBudgetStatus:output_type -> edgecom.BudgetStatusResponse
+	26, // 90: edgecom.TimeSeriesService.GetSummaries:output_type -> edgecom.SummariesResponse
+	28, // 91: edgecom.TimeSeriesService.ExportTimeSeries:output_type -> edgecom.ExportChunk
+	2,  // 92: edgecom.TimeSeriesService.SubscribeTimeSeries:output_type -> edgecom.TimeSeriesResponse
+	32, // 93: edgecom.TimeSeriesService.CompareTimeSeries:output_type -> edgecom.CompareResponse
+	35, // 94: edgecom.TimeSeriesService.ListSeries:output_type -> edgecom.ListSeriesResponse
+	33, // 95: edgecom.TimeSeriesService.GetSeries:output_type -> edgecom.Series
+	39, // 96: edgecom.TimeSeriesService.TopK:output_type -> edgecom.TopKResponse
+	80, // [80:97] is the sub-list for method output_type
+	63, // [63:80] is the sub-list for method input_type
+	63, // [63:63] is the sub-list for extension type_name
+	63, // [63:63] is the sub-list for extension extendee
+	0,  // [0:63] is the sub-list for field type_name
 }
 
 func init() { file_proto_timeseries_proto_init() }
@@ -272,7 +3653,7 @@ func file_proto_timeseries_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_timeseries_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   44,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -15,11 +15,27 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.64.0 or later.
-const _ = grpc.SupportPackageIsVersion9
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
 
 const (
-	TimeSeriesService_QueryTimeSeries_FullMethodName = "/edgecom.TimeSeriesService/QueryTimeSeries"
+	TimeSeriesService_QueryTimeSeries_FullMethodName           = "/edgecom.TimeSeriesService/QueryTimeSeries"
+	TimeSeriesService_QueryRaw_FullMethodName                  = "/edgecom.TimeSeriesService/QueryRaw"
+	TimeSeriesService_GetLatest_FullMethodName                 = "/edgecom.TimeSeriesService/GetLatest"
+	TimeSeriesService_GetStatistics_FullMethodName             = "/edgecom.TimeSeriesService/GetStatistics"
+	TimeSeriesService_InsertTimeSeries_FullMethodName          = "/edgecom.TimeSeriesService/InsertTimeSeries"
+	TimeSeriesService_IngestTimeSeries_FullMethodName          = "/edgecom.TimeSeriesService/IngestTimeSeries"
+	TimeSeriesService_QueryEmissions_FullMethodName            = "/edgecom.TimeSeriesService/QueryEmissions"
+	TimeSeriesService_RecordDemandResponseEvent_FullMethodName = "/edgecom.TimeSeriesService/RecordDemandResponseEvent"
+	TimeSeriesService_ListDemandResponseEvents_FullMethodName  = "/edgecom.TimeSeriesService/ListDemandResponseEvents"
+	TimeSeriesService_GetBudgetStatus_FullMethodName           = "/edgecom.TimeSeriesService/GetBudgetStatus"
+	TimeSeriesService_GetSummaries_FullMethodName              = "/edgecom.TimeSeriesService/GetSummaries"
+	TimeSeriesService_ExportTimeSeries_FullMethodName          = "/edgecom.TimeSeriesService/ExportTimeSeries"
+	TimeSeriesService_SubscribeTimeSeries_FullMethodName       = "/edgecom.TimeSeriesService/SubscribeTimeSeries"
+	TimeSeriesService_CompareTimeSeries_FullMethodName         = "/edgecom.TimeSeriesService/CompareTimeSeries"
+	TimeSeriesService_ListSeries_FullMethodName                = "/edgecom.TimeSeriesService/ListSeries"
+	TimeSeriesService_GetSeries_FullMethodName                 = "/edgecom.TimeSeriesService/GetSeries"
+	TimeSeriesService_TopK_FullMethodName                      = "/edgecom.TimeSeriesService/TopK"
 )
 
 // TimeSeriesServiceClient is the client API for TimeSeriesService service.
@@ -27,6 +43,22 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type TimeSeriesServiceClient interface {
 	QueryTimeSeries(ctx context.Context, in *TimeSeriesRequest, opts ...grpc.CallOption) (*TimeSeriesResponse, error)
+	QueryRaw(ctx context.Context, in *RawQueryRequest, opts ...grpc.CallOption) (*RawQueryResponse, error)
+	GetLatest(ctx context.Context, in *LatestRequest, opts ...grpc.CallOption) (*LatestResponse, error)
+	GetStatistics(ctx context.Context, in *StatisticsRequest, opts ...grpc.CallOption) (*StatisticsResponse, error)
+	InsertTimeSeries(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertResponse, error)
+	IngestTimeSeries(ctx context.Context, opts ...grpc.CallOption) (TimeSeriesService_IngestTimeSeriesClient, error)
+	QueryEmissions(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*EmissionsResponse, error)
+	RecordDemandResponseEvent(ctx context.Context, in *DemandResponseEvent, opts ...grpc.CallOption) (*DemandResponseEvent, error)
+	ListDemandResponseEvents(ctx context.Context, in *ListDemandResponseEventsRequest, opts ...grpc.CallOption) (*ListDemandResponseEventsResponse, error)
+	GetBudgetStatus(ctx context.Context, in *BudgetStatusRequest, opts ...grpc.CallOption) (*BudgetStatusResponse, error)
+	GetSummaries(ctx context.Context, in *SummariesRequest, opts ...grpc.CallOption) (*SummariesResponse, error)
+	ExportTimeSeries(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TimeSeriesService_ExportTimeSeriesClient, error)
+	SubscribeTimeSeries(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (TimeSeriesService_SubscribeTimeSeriesClient, error)
+	CompareTimeSeries(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error)
+	ListSeries(ctx context.Context, in *ListSeriesRequest, opts ...grpc.CallOption) (*ListSeriesResponse, error)
+	GetSeries(ctx context.Context, in *GetSeriesRequest, opts ...grpc.CallOption) (*Series, error)
+	TopK(ctx context.Context, in *TopKRequest, opts ...grpc.CallOption) (*TopKResponse, error)
 }
 
 type timeSeriesServiceClient struct {
@@ -47,11 +79,258 @@ func (c *timeSeriesServiceClient) QueryTimeSeries(ctx context.Context, in *TimeS
 	return out, nil
 }
 
+func (c *timeSeriesServiceClient) QueryRaw(ctx context.Context, in *RawQueryRequest, opts ...grpc.CallOption) (*RawQueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RawQueryResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_QueryRaw_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) GetLatest(ctx context.Context, in *LatestRequest, opts ...grpc.CallOption) (*LatestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LatestResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_GetLatest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) GetStatistics(ctx context.Context, in *StatisticsRequest, opts ...grpc.CallOption) (*StatisticsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatisticsResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_GetStatistics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) InsertTimeSeries(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InsertResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_InsertTimeSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) IngestTimeSeries(ctx context.Context, opts ...grpc.CallOption) (TimeSeriesService_IngestTimeSeriesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TimeSeriesService_ServiceDesc.Streams[0], TimeSeriesService_IngestTimeSeries_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &timeSeriesServiceIngestTimeSeriesClient{ClientStream: stream}
+	return x, nil
+}
+
+type TimeSeriesService_IngestTimeSeriesClient interface {
+	Send(*InsertRequest) error
+	CloseAndRecv() (*InsertResponse, error)
+	grpc.ClientStream
+}
+
+type timeSeriesServiceIngestTimeSeriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *timeSeriesServiceIngestTimeSeriesClient) Send(m *InsertRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *timeSeriesServiceIngestTimeSeriesClient) CloseAndRecv() (*InsertResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(InsertResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *timeSeriesServiceClient) QueryEmissions(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*EmissionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EmissionsResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_QueryEmissions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) RecordDemandResponseEvent(ctx context.Context, in *DemandResponseEvent, opts ...grpc.CallOption) (*DemandResponseEvent, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DemandResponseEvent)
+	err := c.cc.Invoke(ctx, TimeSeriesService_RecordDemandResponseEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) ListDemandResponseEvents(ctx context.Context, in *ListDemandResponseEventsRequest, opts ...grpc.CallOption) (*ListDemandResponseEventsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDemandResponseEventsResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_ListDemandResponseEvents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) GetBudgetStatus(ctx context.Context, in *BudgetStatusRequest, opts ...grpc.CallOption) (*BudgetStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BudgetStatusResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_GetBudgetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) GetSummaries(ctx context.Context, in *SummariesRequest, opts ...grpc.CallOption) (*SummariesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SummariesResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_GetSummaries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) ExportTimeSeries(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TimeSeriesService_ExportTimeSeriesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TimeSeriesService_ServiceDesc.Streams[1], TimeSeriesService_ExportTimeSeries_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &timeSeriesServiceExportTimeSeriesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TimeSeriesService_ExportTimeSeriesClient interface {
+	Recv() (*ExportChunk, error)
+	grpc.ClientStream
+}
+
+type timeSeriesServiceExportTimeSeriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *timeSeriesServiceExportTimeSeriesClient) Recv() (*ExportChunk, error) {
+	m := new(ExportChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *timeSeriesServiceClient) SubscribeTimeSeries(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (TimeSeriesService_SubscribeTimeSeriesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TimeSeriesService_ServiceDesc.Streams[2], TimeSeriesService_SubscribeTimeSeries_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &timeSeriesServiceSubscribeTimeSeriesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TimeSeriesService_SubscribeTimeSeriesClient interface {
+	Recv() (*TimeSeriesResponse, error)
+	grpc.ClientStream
+}
+
+type timeSeriesServiceSubscribeTimeSeriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *timeSeriesServiceSubscribeTimeSeriesClient) Recv() (*TimeSeriesResponse, error) {
+	m := new(TimeSeriesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *timeSeriesServiceClient) CompareTimeSeries(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompareResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_CompareTimeSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) ListSeries(ctx context.Context, in *ListSeriesRequest, opts ...grpc.CallOption) (*ListSeriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSeriesResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_ListSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) GetSeries(ctx context.Context, in *GetSeriesRequest, opts ...grpc.CallOption) (*Series, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Series)
+	err := c.cc.Invoke(ctx, TimeSeriesService_GetSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeSeriesServiceClient) TopK(ctx context.Context, in *TopKRequest, opts ...grpc.CallOption) (*TopKResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TopKResponse)
+	err := c.cc.Invoke(ctx, TimeSeriesService_TopK_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TimeSeriesServiceServer is the server API for TimeSeriesService service.
 // All implementations must embed UnimplementedTimeSeriesServiceServer
 // for forward compatibility.
 type TimeSeriesServiceServer interface {
 	QueryTimeSeries(context.Context, *TimeSeriesRequest) (*TimeSeriesResponse, error)
+	QueryRaw(context.Context, *RawQueryRequest) (*RawQueryResponse, error)
+	GetLatest(context.Context, *LatestRequest) (*LatestResponse, error)
+	GetStatistics(context.Context, *StatisticsRequest) (*StatisticsResponse, error)
+	InsertTimeSeries(context.Context, *InsertRequest) (*InsertResponse, error)
+	IngestTimeSeries(TimeSeriesService_IngestTimeSeriesServer) error
+	QueryEmissions(context.Context, *EmissionsRequest) (*EmissionsResponse, error)
+	RecordDemandResponseEvent(context.Context, *DemandResponseEvent) (*DemandResponseEvent, error)
+	ListDemandResponseEvents(context.Context, *ListDemandResponseEventsRequest) (*ListDemandResponseEventsResponse, error)
+	GetBudgetStatus(context.Context, *BudgetStatusRequest) (*BudgetStatusResponse, error)
+	GetSummaries(context.Context, *SummariesRequest) (*SummariesResponse, error)
+	ExportTimeSeries(*ExportRequest, TimeSeriesService_ExportTimeSeriesServer) error
+	SubscribeTimeSeries(*SubscribeRequest, TimeSeriesService_SubscribeTimeSeriesServer) error
+	CompareTimeSeries(context.Context, *CompareRequest) (*CompareResponse, error)
+	ListSeries(context.Context, *ListSeriesRequest) (*ListSeriesResponse, error)
+	GetSeries(context.Context, *GetSeriesRequest) (*Series, error)
+	TopK(context.Context, *TopKRequest) (*TopKResponse, error)
 	mustEmbedUnimplementedTimeSeriesServiceServer()
 }
 
@@ -65,6 +344,54 @@ type UnimplementedTimeSeriesServiceServer struct{}
 func (UnimplementedTimeSeriesServiceServer) QueryTimeSeries(context.Context, *TimeSeriesRequest) (*TimeSeriesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method QueryTimeSeries not implemented")
 }
+func (UnimplementedTimeSeriesServiceServer) QueryRaw(context.Context, *RawQueryRequest) (*RawQueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryRaw not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) GetLatest(context.Context, *LatestRequest) (*LatestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLatest not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) GetStatistics(context.Context, *StatisticsRequest) (*StatisticsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatistics not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) InsertTimeSeries(context.Context, *InsertRequest) (*InsertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InsertTimeSeries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) IngestTimeSeries(TimeSeriesService_IngestTimeSeriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method IngestTimeSeries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) QueryEmissions(context.Context, *EmissionsRequest) (*EmissionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryEmissions not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) RecordDemandResponseEvent(context.Context, *DemandResponseEvent) (*DemandResponseEvent, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordDemandResponseEvent not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) ListDemandResponseEvents(context.Context, *ListDemandResponseEventsRequest) (*ListDemandResponseEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDemandResponseEvents not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) GetBudgetStatus(context.Context, *BudgetStatusRequest) (*BudgetStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBudgetStatus not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) GetSummaries(context.Context, *SummariesRequest) (*SummariesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSummaries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) ExportTimeSeries(*ExportRequest, TimeSeriesService_ExportTimeSeriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportTimeSeries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) SubscribeTimeSeries(*SubscribeRequest, TimeSeriesService_SubscribeTimeSeriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeTimeSeries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) CompareTimeSeries(context.Context, *CompareRequest) (*CompareResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompareTimeSeries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) ListSeries(context.Context, *ListSeriesRequest) (*ListSeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSeries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) GetSeries(context.Context, *GetSeriesRequest) (*Series, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSeries not implemented")
+}
+func (UnimplementedTimeSeriesServiceServer) TopK(context.Context, *TopKRequest) (*TopKResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TopK not implemented")
+}
 func (UnimplementedTimeSeriesServiceServer) mustEmbedUnimplementedTimeSeriesServiceServer() {}
 func (UnimplementedTimeSeriesServiceServer) testEmbeddedByValue()                           {}
 
@@ -104,6 +431,308 @@ func _TimeSeriesService_QueryTimeSeries_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TimeSeriesService_QueryRaw_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).QueryRaw(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_QueryRaw_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).QueryRaw(ctx, req.(*RawQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_GetLatest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LatestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).GetLatest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_GetLatest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).GetLatest(ctx, req.(*LatestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_GetStatistics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatisticsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).GetStatistics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_GetStatistics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).GetStatistics(ctx, req.(*StatisticsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_InsertTimeSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).InsertTimeSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_InsertTimeSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).InsertTimeSeries(ctx, req.(*InsertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_IngestTimeSeries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TimeSeriesServiceServer).IngestTimeSeries(&timeSeriesServiceIngestTimeSeriesServer{ServerStream: stream})
+}
+
+type TimeSeriesService_IngestTimeSeriesServer interface {
+	SendAndClose(*InsertResponse) error
+	Recv() (*InsertRequest, error)
+	grpc.ServerStream
+}
+
+type timeSeriesServiceIngestTimeSeriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *timeSeriesServiceIngestTimeSeriesServer) SendAndClose(m *InsertResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *timeSeriesServiceIngestTimeSeriesServer) Recv() (*InsertRequest, error) {
+	m := new(InsertRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _TimeSeriesService_QueryEmissions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmissionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).QueryEmissions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_QueryEmissions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).QueryEmissions(ctx, req.(*EmissionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_RecordDemandResponseEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DemandResponseEvent)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).RecordDemandResponseEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_RecordDemandResponseEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).RecordDemandResponseEvent(ctx, req.(*DemandResponseEvent))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_ListDemandResponseEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDemandResponseEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).ListDemandResponseEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_ListDemandResponseEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).ListDemandResponseEvents(ctx, req.(*ListDemandResponseEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_GetBudgetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BudgetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).GetBudgetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_GetBudgetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).GetBudgetStatus(ctx, req.(*BudgetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_GetSummaries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SummariesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).GetSummaries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_GetSummaries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).GetSummaries(ctx, req.(*SummariesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_ExportTimeSeries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TimeSeriesServiceServer).ExportTimeSeries(m, &timeSeriesServiceExportTimeSeriesServer{ServerStream: stream})
+}
+
+type TimeSeriesService_ExportTimeSeriesServer interface {
+	Send(*ExportChunk) error
+	grpc.ServerStream
+}
+
+type timeSeriesServiceExportTimeSeriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *timeSeriesServiceExportTimeSeriesServer) Send(m *ExportChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TimeSeriesService_SubscribeTimeSeries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TimeSeriesServiceServer).SubscribeTimeSeries(m, &timeSeriesServiceSubscribeTimeSeriesServer{ServerStream: stream})
+}
+
+type TimeSeriesService_SubscribeTimeSeriesServer interface {
+	Send(*TimeSeriesResponse) error
+	grpc.ServerStream
+}
+
+type timeSeriesServiceSubscribeTimeSeriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *timeSeriesServiceSubscribeTimeSeriesServer) Send(m *TimeSeriesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TimeSeriesService_CompareTimeSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).CompareTimeSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_CompareTimeSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).CompareTimeSeries(ctx, req.(*CompareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_ListSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).ListSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_ListSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).ListSeries(ctx, req.(*ListSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_GetSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).GetSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_GetSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).GetSeries(ctx, req.(*GetSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeSeriesService_TopK_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TopKRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeSeriesServiceServer).TopK(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeSeriesService_TopK_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeSeriesServiceServer).TopK(ctx, req.(*TopKRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TimeSeriesService_ServiceDesc is the grpc.ServiceDesc for TimeSeriesService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,7 +744,75 @@ var TimeSeriesService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "QueryTimeSeries",
 			Handler:    _TimeSeriesService_QueryTimeSeries_Handler,
 		},
+		{
+			MethodName: "QueryRaw",
+			Handler:    _TimeSeriesService_QueryRaw_Handler,
+		},
+		{
+			MethodName: "GetLatest",
+			Handler:    _TimeSeriesService_GetLatest_Handler,
+		},
+		{
+			MethodName: "GetStatistics",
+			Handler:    _TimeSeriesService_GetStatistics_Handler,
+		},
+		{
+			MethodName: "InsertTimeSeries",
+			Handler:    _TimeSeriesService_InsertTimeSeries_Handler,
+		},
+		{
+			MethodName: "QueryEmissions",
+			Handler:    _TimeSeriesService_QueryEmissions_Handler,
+		},
+		{
+			MethodName: "RecordDemandResponseEvent",
+			Handler:    _TimeSeriesService_RecordDemandResponseEvent_Handler,
+		},
+		{
+			MethodName: "ListDemandResponseEvents",
+			Handler:    _TimeSeriesService_ListDemandResponseEvents_Handler,
+		},
+		{
+			MethodName: "GetBudgetStatus",
+			Handler:    _TimeSeriesService_GetBudgetStatus_Handler,
+		},
+		{
+			MethodName: "GetSummaries",
+			Handler:    _TimeSeriesService_GetSummaries_Handler,
+		},
+		{
+			MethodName: "CompareTimeSeries",
+			Handler:    _TimeSeriesService_CompareTimeSeries_Handler,
+		},
+		{
+			MethodName: "ListSeries",
+			Handler:    _TimeSeriesService_ListSeries_Handler,
+		},
+		{
+			MethodName: "GetSeries",
+			Handler:    _TimeSeriesService_GetSeries_Handler,
+		},
+		{
+			MethodName: "TopK",
+			Handler:    _TimeSeriesService_TopK_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "IngestTimeSeries",
+			Handler:       _TimeSeriesService_IngestTimeSeries_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ExportTimeSeries",
+			Handler:       _TimeSeriesService_ExportTimeSeries_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeTimeSeries",
+			Handler:       _TimeSeriesService_SubscribeTimeSeries_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/timeseries.proto",
 }